@@ -0,0 +1,203 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package credentials reads the user, password and token of a sink from its options. Each of
+// them can be given inline, e.g. `password=secret`, read from a file, e.g.
+// `passwordFile=/etc/secrets/password`, or read from an environment variable, e.g.
+// `passwordEnv=SINK_PASSWORD`. Files are read again when they change, so that secrets
+// mounted from Kubernetes are picked up when they are rotated, without restarting Heapster.
+package credentials
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	fileSuffix = "File"
+	envSuffix  = "Env"
+)
+
+// Values are the credentials at a point in time.
+type Values struct {
+	User     string
+	Password string
+	Token    string
+}
+
+// Credentials returns the latest credentials of a sink. The zero value has no credentials.
+type Credentials struct {
+	user     *credential
+	password *credential
+	token    *credential
+}
+
+// credential is a single secret, given inline or read from a file or an environment variable.
+type credential struct {
+	option string
+	value  string
+	file   string
+	env    string
+
+	lock    sync.Mutex
+	modTime time.Time
+}
+
+// New reads the credentials from the given options, named userOption, passwordOption and
+// "token" with the File and Env suffixes for files and environment variables. It fails if a
+// credential is given more than once or if its file cannot be read.
+func New(opts url.Values, userOption, passwordOption string) (*Credentials, error) {
+	this := &Credentials{}
+	var err error
+	if this.user, err = newCredential(opts, userOption); err != nil {
+		return nil, err
+	}
+	if this.password, err = newCredential(opts, passwordOption); err != nil {
+		return nil, err
+	}
+	if this.token, err = newCredential(opts, "token"); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+func newCredential(opts url.Values, option string) (*credential, error) {
+	this := &credential{option: option}
+	sources := 0
+	if len(opts[option]) > 0 {
+		this.value = opts[option][0]
+		sources++
+	}
+	if len(opts[option+fileSuffix]) > 0 {
+		this.file = opts[option+fileSuffix][0]
+		sources++
+	}
+	if len(opts[option+envSuffix]) > 0 {
+		this.env = opts[option+envSuffix][0]
+		sources++
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of the %s, %s%s and %s%s options can be set", option, option, fileSuffix, option, envSuffix)
+	}
+	if this.file != "" {
+		if err := this.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return this, nil
+}
+
+// Get returns the current credentials, reading again the files which changed. If a file
+// cannot be read anymore, its last value is used.
+func (this *Credentials) Get() Values {
+	return Values{
+		User:     this.user.get(),
+		Password: this.password.get(),
+		Token:    this.token.get(),
+	}
+}
+
+// IsSet returns true if any credential is configured.
+func (this *Credentials) IsSet() bool {
+	for _, c := range []*credential{this.user, this.password, this.token} {
+		if c != nil && (c.value != "" || c.file != "" || c.env != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize sets the Authorization header of the request: basic authentication if a user is
+// configured, a bearer token otherwise, and nothing if neither is.
+func (this *Credentials) Authorize(req *http.Request) error {
+	values := this.Get()
+	if values.User != "" {
+		req.SetBasicAuth(values.User, values.Password)
+	} else if values.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+values.Token)
+	}
+	return nil
+}
+
+// File is a single secret read from a file, e.g. a CA bundle, and read again when the file changes.
+type File struct {
+	credential *credential
+}
+
+// NewFile reads the secret from the file. The name of the secret is used in the logs.
+func NewFile(name, file string) (*File, error) {
+	this := &credential{option: name, file: file}
+	if err := this.reload(); err != nil {
+		return nil, err
+	}
+	return &File{credential: this}, nil
+}
+
+// Get returns the contents of the file, read again if it changed. If the file cannot be read
+// anymore, its last contents are used.
+func (this *File) Get() string {
+	return this.credential.get()
+}
+
+func (this *credential) get() string {
+	if this == nil {
+		return ""
+	}
+	if this.env != "" {
+		return os.Getenv(this.env)
+	}
+	if this.file == "" {
+		return this.value
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.reloadLocked(); err != nil {
+		glog.Warningf("Failed to read %s from %s, using its previous value: %v", this.option, this.file, err)
+	}
+	return this.value
+}
+
+func (this *credential) reload() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.reloadLocked()
+}
+
+func (this *credential) reloadLocked() error {
+	info, err := os.Stat(this.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(this.modTime) {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(this.file)
+	if err != nil {
+		return err
+	}
+	if !this.modTime.IsZero() {
+		glog.Infof("Reloaded %s from %s", this.option, this.file)
+	}
+	this.value = strings.TrimSpace(string(contents))
+	this.modTime = info.ModTime()
+	return nil
+}
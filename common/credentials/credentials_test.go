@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600))
+	os.Setenv("TEST_CREDENTIALS_TOKEN", "token1")
+	defer os.Unsetenv("TEST_CREDENTIALS_TOKEN")
+
+	creds, err := New(url.Values{
+		"user":         {"heapster"},
+		"passFile":     {passwordFile},
+		"tokenEnv":     {"TEST_CREDENTIALS_TOKEN"},
+		"unrelatedEnv": {"HOME"},
+	}, "user", "pass")
+	require.NoError(t, err)
+	assert.True(t, creds.IsSet())
+	assert.Equal(t, Values{User: "heapster", Password: "secret", Token: "token1"}, creds.Get())
+
+	// Rotated secrets are picked up.
+	require.NoError(t, ioutil.WriteFile(passwordFile, []byte("rotated"), 0600))
+	require.NoError(t, os.Chtimes(passwordFile, time.Now(), time.Now().Add(time.Minute)))
+	os.Setenv("TEST_CREDENTIALS_TOKEN", "token2")
+	assert.Equal(t, Values{User: "heapster", Password: "rotated", Token: "token2"}, creds.Get())
+
+	// The previous value is kept if the file disappears.
+	require.NoError(t, os.Remove(passwordFile))
+	assert.Equal(t, "rotated", creds.Get().Password)
+}
+
+func TestCredentialsValidation(t *testing.T) {
+	_, err := New(url.Values{"password": {"a"}, "passwordEnv": {"B"}}, "user", "password")
+	assert.Error(t, err)
+
+	_, err = New(url.Values{"tokenFile": {"/nonexistent/token"}}, "user", "password")
+	assert.Error(t, err)
+
+	creds, err := New(url.Values{}, "user", "password")
+	require.NoError(t, err)
+	assert.False(t, creds.IsSet())
+	assert.False(t, (&Credentials{}).IsSet())
+	assert.Equal(t, Values{}, (&Credentials{}).Get())
+}
+
+func TestCredentialsAuthorize(t *testing.T) {
+	creds, err := New(url.Values{"user": {"heapster"}, "password": {"secret"}, "token": {"ignored"}}, "user", "password")
+	require.NoError(t, err)
+	req, err := http.NewRequest("GET", "http://localhost", nil)
+	require.NoError(t, err)
+	require.NoError(t, creds.Authorize(req))
+	user, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "heapster", user)
+	assert.Equal(t, "secret", password)
+
+	creds, err = New(url.Values{"token": {"abc"}}, "user", "password")
+	require.NoError(t, err)
+	require.NoError(t, creds.Authorize(req))
+	assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+}
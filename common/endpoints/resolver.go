@@ -0,0 +1,220 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package endpoints resolves the host names of sink endpoints again periodically and when an
+// address fails, so that sinks follow DNS changes, e.g. of relays behind a round-robin record,
+// instead of using the first resolved address for the life of the process.
+package endpoints
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// Options of the sinks supporting re-resolution.
+	RefreshIntervalOption = "dns_refresh_interval"
+	FailureCooldownOption = "dns_failure_cooldown"
+
+	DefaultFailureCooldown = 30 * time.Second
+)
+
+// Resolver rotates over the addresses of a host, skipping those which failed within the
+// cooldown. The host is resolved again every interval and whenever an address fails.
+type Resolver struct {
+	host     string
+	port     string
+	interval time.Duration
+	cooldown time.Duration
+	lookup   func(host string) ([]string, error)
+	now      func() time.Time
+
+	lock      sync.Mutex
+	addresses []string
+	// Time of the last resolution, zero to resolve at the next call.
+	resolvedAt time.Time
+	// Index of the next address to return.
+	next        int
+	failedUntil map[string]time.Time
+}
+
+// NewResolver returns a resolver of the host of hostport, e.g. influxdb-relay:8086, resolved
+// every interval. Failed addresses are skipped for cooldown.
+func NewResolver(hostport string, interval, cooldown time.Duration) (*Resolver, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 || cooldown <= 0 {
+		return nil, fmt.Errorf("the refresh interval and the failure cooldown must be positive")
+	}
+	return &Resolver{
+		host:        host,
+		port:        port,
+		interval:    interval,
+		cooldown:    cooldown,
+		lookup:      net.LookupHost,
+		now:         time.Now,
+		failedUntil: map[string]time.Time{},
+	}, nil
+}
+
+// NewResolverFromOptions returns a resolver configured by the dns_refresh_interval and
+// dns_failure_cooldown options, nil if dns_refresh_interval is not set.
+func NewResolverFromOptions(hostport string, opts url.Values) (*Resolver, error) {
+	if opts.Get(RefreshIntervalOption) == "" {
+		return nil, nil
+	}
+	interval, err := time.ParseDuration(opts.Get(RefreshIntervalOption))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", RefreshIntervalOption, err)
+	}
+	cooldown := DefaultFailureCooldown
+	if value := opts.Get(FailureCooldownOption); value != "" {
+		if cooldown, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", FailureCooldownOption, err)
+		}
+	}
+	return NewResolver(hostport, interval, cooldown)
+}
+
+// Next returns the next healthy address, as host:port. If all addresses failed within the
+// cooldown, it returns the one whose cooldown ends first.
+func (this *Resolver) Next() (string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.refreshLocked(); err != nil {
+		return "", err
+	}
+	now := this.now()
+	var earliest string
+	for i := 0; i < len(this.addresses); i++ {
+		index := (this.next + i) % len(this.addresses)
+		address := this.addresses[index]
+		until, failed := this.failedUntil[address]
+		if !failed || !now.Before(until) {
+			delete(this.failedUntil, address)
+			this.next = index + 1
+			return address, nil
+		}
+		if earliest == "" || until.Before(this.failedUntil[earliest]) {
+			earliest = address
+		}
+	}
+	return earliest, nil
+}
+
+// Has returns whether the address is still one of the addresses of the host and healthy, so
+// that connections to addresses which were removed are dropped.
+func (this *Resolver) Has(address string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.refreshLocked(); err != nil {
+		// Keep using the connection rather than failing on a transient DNS error.
+		return true
+	}
+	if until, failed := this.failedUntil[address]; failed && this.now().Before(until) {
+		return false
+	}
+	return contains(this.addresses, address)
+}
+
+// MarkFailed skips the address for the cooldown and resolves the host again at the next call.
+func (this *Resolver) MarkFailed(address string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	glog.V(2).Infof("Endpoint %s of %s failed, skipping it for %s", address, this.host, this.cooldown)
+	this.failedUntil[address] = this.now().Add(this.cooldown)
+	this.resolvedAt = time.Time{}
+}
+
+// Dial connects to the next healthy address, ignoring the address it is given, so that it can
+// be used as the Dial function of an http.Transport. Addresses which cannot be reached are
+// marked as failed.
+func (this *Resolver) Dial(network, _ string) (net.Conn, error) {
+	address, err := this.Next()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		this.MarkFailed(address)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (this *Resolver) refreshLocked() error {
+	now := this.now()
+	if !this.resolvedAt.IsZero() && now.Sub(this.resolvedAt) < this.interval {
+		return nil
+	}
+	ips, err := this.lookup(this.host)
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("no address found")
+	}
+	if err != nil {
+		if len(this.addresses) > 0 {
+			// Keep the last known addresses until the host can be resolved again.
+			glog.Warningf("Failed to resolve %s, using the last known addresses: %v", this.host, err)
+			this.resolvedAt = now
+			return nil
+		}
+		return fmt.Errorf("failed to resolve %s: %v", this.host, err)
+	}
+	addresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, net.JoinHostPort(ip, this.port))
+	}
+	// Sorted so that the rotation does not depend on the order of the records.
+	sort.Strings(addresses)
+	if !equal(addresses, this.addresses) {
+		glog.Infof("Resolved %s to %v", this.host, addresses)
+		for address := range this.failedUntil {
+			if !contains(addresses, address) {
+				delete(this.failedUntil, address)
+			}
+		}
+	}
+	this.addresses = addresses
+	this.resolvedAt = now
+	return nil
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(addresses []string, address string) bool {
+	for _, known := range addresses {
+		if known == address {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package endpoints
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDNS struct {
+	ips     []string
+	err     error
+	lookups int
+}
+
+func (this *fakeDNS) lookup(host string) ([]string, error) {
+	this.lookups++
+	return this.ips, this.err
+}
+
+func newTestResolver(t *testing.T, dns *fakeDNS, now *time.Time) *Resolver {
+	resolver, err := NewResolver("relay:8086", time.Minute, 30*time.Second)
+	require.NoError(t, err)
+	resolver.lookup = dns.lookup
+	resolver.now = func() time.Time { return *now }
+	return resolver
+}
+
+func next(t *testing.T, resolver *Resolver) string {
+	address, err := resolver.Next()
+	require.NoError(t, err)
+	return address
+}
+
+func TestResolverRotation(t *testing.T) {
+	now := time.Now()
+	dns := &fakeDNS{ips: []string{"10.0.0.2", "10.0.0.1"}}
+	resolver := newTestResolver(t, dns, &now)
+
+	assert.Equal(t, "10.0.0.1:8086", next(t, resolver))
+	assert.Equal(t, "10.0.0.2:8086", next(t, resolver))
+	assert.Equal(t, "10.0.0.1:8086", next(t, resolver))
+	assert.Equal(t, 1, dns.lookups)
+
+	// A failed address is skipped for the cooldown, and the host is resolved again.
+	resolver.MarkFailed("10.0.0.2:8086")
+	assert.False(t, resolver.Has("10.0.0.2:8086"))
+	assert.Equal(t, "10.0.0.1:8086", next(t, resolver))
+	assert.Equal(t, "10.0.0.1:8086", next(t, resolver))
+	assert.Equal(t, 2, dns.lookups)
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, "10.0.0.2:8086", next(t, resolver))
+
+	// When all addresses failed, the one whose cooldown ends first is used.
+	resolver.MarkFailed("10.0.0.1:8086")
+	now = now.Add(time.Second)
+	resolver.MarkFailed("10.0.0.2:8086")
+	assert.Equal(t, "10.0.0.1:8086", next(t, resolver))
+}
+
+func TestResolverRefresh(t *testing.T) {
+	now := time.Now()
+	dns := &fakeDNS{ips: []string{"10.0.0.1"}}
+	resolver := newTestResolver(t, dns, &now)
+	assert.Equal(t, "10.0.0.1:8086", next(t, resolver))
+
+	// The new addresses are used once the interval elapsed.
+	dns.ips = []string{"10.0.0.3"}
+	now = now.Add(30 * time.Second)
+	assert.True(t, resolver.Has("10.0.0.1:8086"))
+	now = now.Add(30 * time.Second)
+	assert.False(t, resolver.Has("10.0.0.1:8086"))
+	assert.Equal(t, "10.0.0.3:8086", next(t, resolver))
+
+	// The last known addresses are kept while the host cannot be resolved.
+	dns.err = fmt.Errorf("SERVFAIL")
+	now = now.Add(time.Minute)
+	assert.Equal(t, "10.0.0.3:8086", next(t, resolver))
+}
+
+func TestResolverFailures(t *testing.T) {
+	now := time.Now()
+	resolver := newTestResolver(t, &fakeDNS{err: fmt.Errorf("NXDOMAIN")}, &now)
+	_, err := resolver.Next()
+	assert.Error(t, err)
+
+	resolver = newTestResolver(t, &fakeDNS{}, &now)
+	_, err = resolver.Next()
+	assert.Error(t, err)
+}
+
+func TestResolverDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	resolver, err := NewResolver(net.JoinHostPort("localhost", port), time.Minute, time.Minute)
+	require.NoError(t, err)
+	resolver.lookup = (&fakeDNS{ips: []string{"127.0.0.1"}}).lookup
+	conn, err := resolver.Dial("tcp", "ignored:80")
+	require.NoError(t, err)
+	conn.Close()
+
+	listener.Close()
+	_, err = resolver.Dial("tcp", "ignored:80")
+	assert.Error(t, err)
+	assert.False(t, resolver.Has(net.JoinHostPort("127.0.0.1", port)))
+}
+
+func TestNewResolverFromOptions(t *testing.T) {
+	resolver, err := NewResolverFromOptions("relay:8086", url.Values{})
+	assert.NoError(t, err)
+	assert.Nil(t, resolver)
+
+	resolver, err = NewResolverFromOptions("relay:8086", url.Values{RefreshIntervalOption: {"1m"}})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultFailureCooldown, resolver.cooldown)
+
+	for _, opts := range []url.Values{
+		{RefreshIntervalOption: {"often"}},
+		{RefreshIntervalOption: {"0s"}},
+		{RefreshIntervalOption: {"1m"}, FailureCooldownOption: {"-1s"}},
+	} {
+		_, err := NewResolverFromOptions("relay:8086", opts)
+		assert.Error(t, err, "%v", opts)
+	}
+	_, err = NewResolverFromOptions("relay", url.Values{RefreshIntervalOption: {"1m"}})
+	assert.Error(t, err)
+}
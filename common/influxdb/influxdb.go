@@ -15,12 +15,17 @@
 package influxdb
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
+	"k8s.io/heapster/common/endpoints"
 	"k8s.io/heapster/version"
 
 	influxdb "github.com/influxdata/influxdb/client"
@@ -32,6 +37,21 @@ type InfluxdbClient interface {
 	Ping() (time.Duration, string, error)
 }
 
+// HeaderWriter is implemented by the clients able to send extra headers with the points they
+// write, e.g. the ID of the batch of Heapster, which the InfluxDB client does not support.
+type HeaderWriter interface {
+	WriteWithHeaders(influxdb.BatchPoints, http.Header) (*influxdb.Response, error)
+}
+
+// WriteWithHeaders writes the points with the headers if the client supports them, and without
+// them otherwise.
+func WriteWithHeaders(client InfluxdbClient, bp influxdb.BatchPoints, header http.Header) (*influxdb.Response, error) {
+	if writer, ok := client.(HeaderWriter); ok {
+		return writer.WriteWithHeaders(bp, header)
+	}
+	return client.Write(bp)
+}
+
 type InfluxdbConfig struct {
 	User                  string
 	Password              string
@@ -44,12 +64,28 @@ type InfluxdbConfig struct {
 	ClusterName           string
 	DisableCounterMetrics bool
 	Concurrency           int
+	// Rotates over the addresses of the host, nil to connect to the host name.
+	Resolver *endpoints.Resolver
 }
 
 func NewClient(c InfluxdbConfig) (InfluxdbClient, error) {
+	var client InfluxdbClient
+	var err error
+	if c.Resolver != nil {
+		client = &resolvingClient{config: c, clients: map[string]InfluxdbClient{}}
+	} else if client, err = newClient(c, c.Host); err != nil {
+		return nil, err
+	}
+	if _, _, err := client.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping InfluxDB server at %q - %v", c.Host, err)
+	}
+	return client, nil
+}
+
+func newClient(c InfluxdbConfig, host string) (InfluxdbClient, error) {
 	url := &url.URL{
 		Scheme: "http",
-		Host:   c.Host,
+		Host:   host,
 	}
 	if c.Secure {
 		url.Scheme = "https"
@@ -61,16 +97,145 @@ func NewClient(c InfluxdbConfig) (InfluxdbClient, error) {
 		Password:  c.Password,
 		UserAgent: fmt.Sprintf("%v/%v", "heapster", version.HeapsterVersion),
 		UnsafeSsl: c.InsecureSsl,
+		// Shared with the clients writing points with headers.
+		Transport: &http.Transport{TLSClientConfig: newTLSConfig(c, host)},
 	}
 	client, err := influxdb.NewClient(*iConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &headerClient{Client: client, config: *iConfig}, nil
+}
 
+// newTLSConfig returns the TLS configuration of the connections to host, either the host of the
+// configuration or one of its addresses, whose certificate is checked against the name of the host.
+func newTLSConfig(c InfluxdbConfig, host string) *tls.Config {
+	config := &tls.Config{InsecureSkipVerify: c.InsecureSsl}
+	if host != c.Host {
+		config.ServerName = c.Host
+		if name, _, err := net.SplitHostPort(c.Host); err == nil {
+			config.ServerName = name
+		}
+	}
+	return config
+}
+
+// headerClient is an InfluxDB client also writing points with extra headers.
+type headerClient struct {
+	*influxdb.Client
+	config influxdb.Config
+}
+
+// WriteWithHeaders writes the points with the Write method of a client adding the headers to
+// its requests, which shares the transport, and so the connections, of this client.
+func (this *headerClient) WriteWithHeaders(bp influxdb.BatchPoints, header http.Header) (*influxdb.Response, error) {
+	config := this.config
+	config.Transport = &headerTransport{header: header, base: this.config.Transport}
+	client, err := influxdb.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
-	if _, _, err := client.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping InfluxDB server at %q - %v", c.Host, err)
+	return client.Write(bp)
+}
+
+// headerTransport adds headers to the requests sent with the base transport.
+type headerTransport struct {
+	header http.Header
+	base   http.RoundTripper
+}
+
+func (this *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests must not be modified by round trippers.
+	copied := *req
+	copied.Header = make(http.Header, len(req.Header)+len(this.header))
+	for key, values := range req.Header {
+		copied.Header[key] = values
 	}
-	return client, nil
+	for key, values := range this.header {
+		copied.Header[key] = values
+	}
+	return this.base.RoundTrip(&copied)
+}
+
+// resolvingClient sends each request to the next healthy address of the host, with a client
+// per address so that connections are reused.
+type resolvingClient struct {
+	config  InfluxdbConfig
+	lock    sync.Mutex
+	clients map[string]InfluxdbClient
+}
+
+// client returns the client of the next address. Clients of the addresses which are no longer
+// returned by the resolver are dropped.
+func (this *resolvingClient) client() (InfluxdbClient, string, error) {
+	address, err := this.config.Resolver.Next()
+	if err != nil {
+		return nil, "", err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for known := range this.clients {
+		if known != address && !this.config.Resolver.Has(known) {
+			delete(this.clients, known)
+		}
+	}
+	client, found := this.clients[address]
+	if !found {
+		if client, err = newClient(this.config, address); err != nil {
+			return nil, "", err
+		}
+		this.clients[address] = client
+	}
+	return client, address, nil
+}
+
+func (this *resolvingClient) observe(address string, err error) {
+	if err != nil {
+		this.config.Resolver.MarkFailed(address)
+	}
+}
+
+func (this *resolvingClient) Write(bp influxdb.BatchPoints) (*influxdb.Response, error) {
+	client, address, err := this.client()
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Write(bp)
+	this.observe(address, err)
+	return response, err
+}
+
+func (this *resolvingClient) WriteWithHeaders(bp influxdb.BatchPoints, header http.Header) (*influxdb.Response, error) {
+	client, address, err := this.client()
+	if err != nil {
+		return nil, err
+	}
+	response, err := WriteWithHeaders(client, bp, header)
+	this.observe(address, err)
+	return response, err
+}
+
+func (this *resolvingClient) Query(q influxdb.Query) (*influxdb.Response, error) {
+	client, address, err := this.client()
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Query(q)
+	// Errors of the query itself do not tell anything about the health of the server.
+	if response == nil {
+		this.observe(address, err)
+	}
+	return response, err
+}
+
+func (this *resolvingClient) Ping() (time.Duration, string, error) {
+	client, address, err := this.client()
+	if err != nil {
+		return 0, "", err
+	}
+	latency, version, err := client.Ping()
+	this.observe(address, err)
+	return latency, version, err
 }
 
 func BuildConfig(uri *url.URL) (*InfluxdbConfig, error) {
@@ -153,5 +318,11 @@ func BuildConfig(uri *url.URL) (*InfluxdbConfig, error) {
 		config.Concurrency = concurrency
 	}
 
+	resolver, err := endpoints.NewResolverFromOptions(config.Host, opts)
+	if err != nil {
+		return nil, err
+	}
+	config.Resolver = resolver
+
 	return &config, nil
 }
@@ -0,0 +1,43 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvedAddressesVerifiedAgainstHost(t *testing.T) {
+	uri, err := url.Parse("influxdb:?secure=true&dns_refresh_interval=1m")
+	require.NoError(t, err)
+	uri.Host = "influxdb-relay:8086"
+	config, err := BuildConfig(uri)
+	require.NoError(t, err)
+
+	client, err := newClient(*config, "10.0.0.1:8086")
+	require.NoError(t, err)
+	tlsConfig := client.(*headerClient).config.Transport.(*http.Transport).TLSClientConfig
+	assert.Equal(t, "influxdb-relay", tlsConfig.ServerName)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+
+	client, err = newClient(*config, config.Host)
+	require.NoError(t, err)
+	tlsConfig = client.(*headerClient).config.Transport.(*http.Transport).TLSClientConfig
+	assert.Empty(t, tlsConfig.ServerName)
+}
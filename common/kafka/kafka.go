@@ -22,10 +22,12 @@ import (
 	"io/ioutil"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	kafka "github.com/Shopify/sarama"
 	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
 )
 
 const (
@@ -48,25 +50,46 @@ type KafkaClient interface {
 	Name() string
 	Stop()
 	ProduceKafkaMessage(msgData interface{}) error
+	// ProduceKeyedKafkaMessage produces a message with the given key, so consumers and log
+	// compaction can drop duplicates.
+	ProduceKeyedKafkaMessage(key string, msgData interface{}) error
 }
 
 type kafkaSink struct {
+	sync.Mutex
 	producer  kafka.SyncProducer
 	dataTopic string
+
+	brokers     []string
+	config      *kafka.Config
+	credentials *credentials.Credentials
+	// The SASL credentials the producer was created with.
+	saslValues credentials.Values
 }
 
 func (sink *kafkaSink) ProduceKafkaMessage(msgData interface{}) error {
+	return sink.ProduceKeyedKafkaMessage("", msgData)
+}
+
+func (sink *kafkaSink) ProduceKeyedKafkaMessage(key string, msgData interface{}) error {
 	start := time.Now()
 	msgJson, err := json.Marshal(msgData)
 	if err != nil {
 		return fmt.Errorf("failed to transform the items to json : %s", err)
 	}
 
-	_, _, err = sink.producer.SendMessage(&kafka.ProducerMessage{
+	message := &kafka.ProducerMessage{
 		Topic: sink.dataTopic,
-		Key:   nil,
 		Value: kafka.ByteEncoder(msgJson),
-	})
+	}
+	if key != "" {
+		message.Key = kafka.StringEncoder(key)
+	}
+	producer, err := sink.getProducer()
+	if err != nil {
+		return err
+	}
+	_, _, err = producer.SendMessage(message)
 	if err != nil {
 		return fmt.Errorf("failed to produce message to %s: %s", sink.dataTopic, err)
 	}
@@ -80,7 +103,35 @@ func (sink *kafkaSink) Name() string {
 }
 
 func (sink *kafkaSink) Stop() {
-	sink.producer.Close()
+	sink.Lock()
+	defer sink.Unlock()
+	if sink.producer != nil {
+		sink.producer.Close()
+	}
+}
+
+// getProducer returns the producer, creating it again if the SASL credentials changed.
+func (sink *kafkaSink) getProducer() (kafka.SyncProducer, error) {
+	sink.Lock()
+	defer sink.Unlock()
+	if values := sink.credentials.Get(); values != sink.saslValues {
+		glog.Infof("The SASL credentials of the kafka sink changed, reconnecting")
+		if sink.producer != nil {
+			sink.producer.Close()
+			sink.producer = nil
+		}
+		sink.saslValues = values
+	}
+	if sink.producer == nil {
+		sink.config.Net.SASL.User = sink.saslValues.User
+		sink.config.Net.SASL.Password = sink.saslValues.Password
+		producer, err := kafka.NewSyncProducer(sink.brokers, sink.config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup Producer: - %v", err)
+		}
+		sink.producer = producer
+	}
+	return sink.producer, nil
 }
 
 func getTopic(opts map[string][]string, topicType string) (string, error) {
@@ -158,16 +209,15 @@ func getTlsConfiguration(opts url.Values) (*tls.Config, bool, error) {
 	return t, true, nil
 }
 
-func getSASLConfiguration(opts url.Values) (string, string, bool, error) {
-	if len(opts["user"]) == 0 {
-		return "", "", false, nil
-	}
-	user := opts["user"][0]
-	if len(opts["password"]) == 0 {
-		return "", "", false, nil
+// getSASLConfiguration returns the SASL PLAIN credentials, if both the user and the password
+// are configured.
+func getSASLConfiguration(opts url.Values) (*credentials.Credentials, bool, error) {
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, false, err
 	}
-	password := opts["password"][0]
-	return user, password, true, nil
+	values := creds.Get()
+	return creds, values.User != "" && values.Password != "", nil
 }
 
 func getOptionsWithoutSecrets(values url.Values) string {
@@ -226,21 +276,28 @@ func NewKafkaClient(uri *url.URL, topicType string) (KafkaClient, error) {
 		return nil, err
 	}
 
-	config.Net.SASL.User, config.Net.SASL.Password, config.Net.SASL.Enable, err = getSASLConfiguration(opts)
+	creds, saslEnabled, err := getSASLConfiguration(opts)
 	if err != nil {
 		return nil, err
 	}
+	config.Net.SASL.Enable = saslEnabled
+	if !saslEnabled {
+		// Do not reconnect when the credentials change, they are not used.
+		creds = &credentials.Credentials{}
+	}
 
 	// set up producer of kafka server.
 	glog.V(3).Infof("attempting to setup kafka sink")
-	sinkProducer, err := kafka.NewSyncProducer(kafkaBrokers, config)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to setup Producer: - %v", err)
+	sink := &kafkaSink{
+		dataTopic:   topic,
+		brokers:     kafkaBrokers,
+		config:      config,
+		credentials: creds,
+	}
+	if _, err := sink.getProducer(); err != nil {
+		return nil, err
 	}
 
 	glog.V(3).Infof("kafka sink setup successfully")
-	return &kafkaSink{
-		producer:  sinkProducer,
-		dataTopic: topic,
-	}, nil
+	return sink, nil
 }
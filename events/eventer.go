@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strconv"
@@ -28,10 +29,16 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/util/logs"
+	"k8s.io/client-go/informers"
+	kube_client "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/heapster/common/flags"
+	kube_config "k8s.io/heapster/common/kubernetes"
 	"k8s.io/heapster/events/api"
 	"k8s.io/heapster/events/manager"
+	"k8s.io/heapster/events/policy"
 	"k8s.io/heapster/events/sinks"
 	"k8s.io/heapster/events/sources"
 	"k8s.io/heapster/version"
@@ -45,6 +52,9 @@ var (
 	argVersion     bool
 	argHealthzIP   = flag.String("healthz-ip", "0.0.0.0", "ip eventer health check service uses")
 	argHealthzPort = flag.Uint("healthz-port", 8084, "port eventer health check listens on")
+
+	argNamespacePolicies = flag.Bool("namespace_policies", false, "Export the events of each namespace as set by its "+
+		"heapster.kubernetes.io/events-* annotations, see docs/sink-configuration.md")
 )
 
 func main() {
@@ -86,7 +96,14 @@ func main() {
 
 	// sinks
 	sinksFactory := sinks.NewSinkFactory()
-	sinkList := sinksFactory.BuildAll(argSinks)
+	var policies *policy.NamespacePolicies
+	if *argNamespacePolicies {
+		policies, err = createNamespacePolicies(&argSources[0].Val)
+		if err != nil {
+			glog.Fatalf("Failed to watch the namespace policies: %v", err)
+		}
+	}
+	sinkList := sinksFactory.BuildAllFiltered(argSinks, policies)
 	if len([]flags.Uri(argSinks)) != 0 && len(sinkList) == 0 {
 		glog.Fatal("No available sink to use")
 	}
@@ -113,6 +130,27 @@ func main() {
 	<-quitChannel
 }
 
+// createNamespacePolicies watches the namespaces of the Kubernetes API server the events are read
+// from.
+func createNamespacePolicies(uri *url.URL) (*policy.NamespacePolicies, error) {
+	kubeConfig, err := kube_config.GetKubeClientConfig(uri)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kube_client.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	factory := informers.NewSharedInformerFactory(kubeClient, time.Hour)
+	namespaces := factory.Core().V1().Namespaces()
+	namespaceLister := namespaces.Lister()
+	factory.Start(wait.NeverStop)
+	// Otherwise the events are exported with the default policy until the namespaces are listed.
+	glog.Infof("Waiting for the namespaces to be listed")
+	cache.WaitForCacheSync(wait.NeverStop, namespaces.Informer().HasSynced)
+	return policy.NewNamespacePolicies(namespaceLister), nil
+}
+
 func startHTTPServer() {
 	glog.Info("Starting eventer http service")
 
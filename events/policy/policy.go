@@ -0,0 +1,198 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy lets namespaces declare with annotations whether their events are exported, to
+// which sinks, and from which event type on, so that tenants control the noise of their own
+// namespaces.
+package policy
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/events/core"
+)
+
+// Annotations of the namespaces setting their policy.
+const (
+	// Whether the events of the namespace are exported, true (default) or false.
+	ExportAnnotation = "heapster.kubernetes.io/events-export"
+	// Comma separated types of the sinks the events are exported to, e.g. "elasticsearch,gcl".
+	// Defaults to all sinks.
+	SinksAnnotation = "heapster.kubernetes.io/events-sinks"
+	// Lowest type of the exported events, Normal (default) or Warning.
+	MinSeverityAnnotation = "heapster.kubernetes.io/events-min-severity"
+)
+
+// Severity of the event types, events of unknown types are exported like warnings.
+var severities = map[string]int{
+	kube_api.EventTypeNormal:  0,
+	kube_api.EventTypeWarning: 1,
+}
+
+var filteredEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "eventer",
+		Subsystem: "policy",
+		Name:      "filtered_events_total",
+		Help:      "Number of events not exported to a sink because of the policy of their namespace.",
+	},
+	[]string{"exporter"},
+)
+
+func init() {
+	prometheus.MustRegister(filteredEvents)
+}
+
+// Policy is the export policy of a namespace.
+type Policy struct {
+	Export bool
+	// Types of the sinks the events are exported to, nil for all sinks.
+	Sinks map[string]bool
+	// Lowest severity of the exported events.
+	MinSeverity int
+}
+
+// DefaultPolicy exports all events to all sinks.
+var DefaultPolicy = Policy{Export: true}
+
+// Allows returns whether the event is exported to the sink of the given type.
+func (this Policy) Allows(sinkType string, event *kube_api.Event) bool {
+	if !this.Export {
+		return false
+	}
+	if this.Sinks != nil && !this.Sinks[sinkType] {
+		return false
+	}
+	return severity(event.Type) >= this.MinSeverity
+}
+
+func severity(eventType string) int {
+	if severity, found := severities[eventType]; found {
+		return severity
+	}
+	return severities[kube_api.EventTypeWarning]
+}
+
+// ParsePolicy returns the policy set by the annotations of the namespace. Invalid annotations are
+// logged and ignored, so that a typo does not silence a namespace.
+func ParsePolicy(namespace *kube_api.Namespace) Policy {
+	policy := DefaultPolicy
+	if value, found := namespace.Annotations[ExportAnnotation]; found {
+		export, err := strconv.ParseBool(value)
+		if err != nil {
+			glog.Warningf("Ignoring invalid %s annotation %q of namespace %s: %v", ExportAnnotation, value, namespace.Name, err)
+		} else {
+			policy.Export = export
+		}
+	}
+	if value, found := namespace.Annotations[SinksAnnotation]; found {
+		policy.Sinks = map[string]bool{}
+		for _, sink := range strings.Split(value, ",") {
+			if sink = strings.TrimSpace(sink); sink != "" {
+				policy.Sinks[sink] = true
+			}
+		}
+	}
+	if value, found := namespace.Annotations[MinSeverityAnnotation]; found {
+		minSeverity, found := severities[strings.Title(strings.ToLower(strings.TrimSpace(value)))]
+		if !found {
+			glog.Warningf("Ignoring invalid %s annotation %q of namespace %s, expected Normal or Warning", MinSeverityAnnotation, value, namespace.Name)
+		} else {
+			policy.MinSeverity = minSeverity
+		}
+	}
+	return policy
+}
+
+// NamespacePolicies evaluates the policies of the namespaces of the events. Events of namespaces
+// which are unknown or not annotated, and events of cluster scoped objects, use DefaultPolicy.
+type NamespacePolicies struct {
+	namespaceLister v1listers.NamespaceLister
+}
+
+func NewNamespacePolicies(namespaceLister v1listers.NamespaceLister) *NamespacePolicies {
+	return &NamespacePolicies{namespaceLister: namespaceLister}
+}
+
+// Filter returns the events of the batch allowed to be exported to the sink of the given type.
+func (this *NamespacePolicies) Filter(sinkType string, batch *core.EventBatch) *core.EventBatch {
+	policies := map[string]Policy{}
+	result := &core.EventBatch{
+		Timestamp: batch.Timestamp,
+		Events:    make([]*kube_api.Event, 0, len(batch.Events)),
+	}
+	for _, event := range batch.Events {
+		// Empty for cluster scoped objects, e.g. nodes, although their events are stored in the
+		// default namespace.
+		namespace := event.InvolvedObject.Namespace
+		policy, found := policies[namespace]
+		if !found {
+			policy = this.getPolicy(namespace)
+			policies[namespace] = policy
+		}
+		if policy.Allows(sinkType, event) {
+			result.Events = append(result.Events, event)
+		}
+	}
+	return result
+}
+
+func (this *NamespacePolicies) getPolicy(namespace string) Policy {
+	if namespace == "" {
+		return DefaultPolicy
+	}
+	ns, err := this.namespaceLister.Get(namespace)
+	if err != nil {
+		return DefaultPolicy
+	}
+	return ParsePolicy(ns)
+}
+
+// filteringSink exports the events allowed by the policies of their namespaces to a sink.
+type filteringSink struct {
+	sinkType string
+	sink     core.EventSink
+	policies *NamespacePolicies
+}
+
+// NewFilteringSink returns a sink exporting to sink the events allowed for sinkType, the key of
+// the sink in its --sink flag, e.g. elasticsearch.
+func NewFilteringSink(sinkType string, sink core.EventSink, policies *NamespacePolicies) core.EventSink {
+	return &filteringSink{
+		sinkType: sinkType,
+		sink:     sink,
+		policies: policies,
+	}
+}
+
+func (this *filteringSink) Name() string {
+	return this.sink.Name()
+}
+
+func (this *filteringSink) ExportEvents(batch *core.EventBatch) {
+	filtered := this.policies.Filter(this.sinkType, batch)
+	if dropped := len(batch.Events) - len(filtered.Events); dropped > 0 {
+		filteredEvents.WithLabelValues(this.sink.Name()).Add(float64(dropped))
+	}
+	this.sink.ExportEvents(filtered)
+}
+
+func (this *filteringSink) Stop() {
+	this.sink.Stop()
+}
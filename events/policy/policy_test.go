@@ -0,0 +1,105 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/events/core"
+)
+
+func newNamespace(name string, annotations map[string]string) *kube_api.Namespace {
+	return &kube_api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func newEvent(namespace, eventType string) *kube_api.Event {
+	return &kube_api.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: namespace, Name: namespace + "-" + eventType},
+		InvolvedObject: kube_api.ObjectReference{Namespace: namespace},
+		Type:           eventType,
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy := ParsePolicy(newNamespace("ns", nil))
+	assert.Equal(t, DefaultPolicy, policy)
+
+	policy = ParsePolicy(newNamespace("ns", map[string]string{
+		ExportAnnotation:      "true",
+		SinksAnnotation:       "elasticsearch, gcl,",
+		MinSeverityAnnotation: "warning",
+	}))
+	assert.Equal(t, Policy{Export: true, Sinks: map[string]bool{"elasticsearch": true, "gcl": true}, MinSeverity: 1}, policy)
+
+	// Invalid annotations are ignored.
+	policy = ParsePolicy(newNamespace("ns", map[string]string{
+		ExportAnnotation:      "maybe",
+		MinSeverityAnnotation: "Critical",
+	}))
+	assert.Equal(t, DefaultPolicy, policy)
+}
+
+type fakeSink struct {
+	events []*kube_api.Event
+}
+
+func (this *fakeSink) Name() string { return "fake" }
+
+func (this *fakeSink) ExportEvents(batch *core.EventBatch) {
+	this.events = append(this.events, batch.Events...)
+}
+
+func (this *fakeSink) Stop() {}
+
+func TestFilteringSink(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(newNamespace("silent", map[string]string{ExportAnnotation: "false"}))
+	indexer.Add(newNamespace("es-only", map[string]string{SinksAnnotation: "elasticsearch"}))
+	indexer.Add(newNamespace("warnings", map[string]string{MinSeverityAnnotation: "Warning"}))
+	indexer.Add(newNamespace("plain", nil))
+	policies := NewNamespacePolicies(v1listers.NewNamespaceLister(indexer))
+
+	var events []*kube_api.Event
+	for _, namespace := range []string{"silent", "es-only", "warnings", "plain", "unknown", ""} {
+		events = append(events, newEvent(namespace, kube_api.EventTypeNormal), newEvent(namespace, kube_api.EventTypeWarning))
+	}
+	batch := &core.EventBatch{Timestamp: time.Now(), Events: events}
+	names := func(events []*kube_api.Event) []string {
+		result := []string{}
+		for _, event := range events {
+			result = append(result, event.Name)
+		}
+		return result
+	}
+
+	gcl := &fakeSink{}
+	NewFilteringSink("gcl", gcl, policies).ExportEvents(batch)
+	assert.Equal(t, []string{"warnings-Warning", "plain-Normal", "plain-Warning", "unknown-Normal", "unknown-Warning",
+		"-Normal", "-Warning"}, names(gcl.events))
+
+	elasticsearch := &fakeSink{}
+	NewFilteringSink("elasticsearch", elasticsearch, policies).ExportEvents(batch)
+	assert.Equal(t, []string{"es-only-Normal", "es-only-Warning", "warnings-Warning", "plain-Normal", "plain-Warning",
+		"unknown-Normal", "unknown-Warning", "-Normal", "-Warning"}, names(elasticsearch.events))
+
+	// The batch given to the other sinks is not modified.
+	assert.Equal(t, 12, len(batch.Events))
+}
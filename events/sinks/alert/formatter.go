@@ -0,0 +1,238 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package alert formats events into the title and message of the notifications posted by the
+// notification sinks, e.g. Teams or Opsgenie, from Go templates which can be overridden without
+// rebuilding Heapster.
+package alert
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	kube_api "k8s.io/api/core/v1"
+)
+
+const (
+	// Names of the templates, and of the files holding them in the templates directory.
+	TitleTemplate   = "title"
+	MessageTemplate = "message"
+)
+
+var templateNames = []string{TitleTemplate, MessageTemplate}
+
+// Alert is the data the templates are executed with.
+type Alert struct {
+	// Name of the cluster, set with the cluster option of the sink.
+	Cluster string
+	Type    string
+	Reason  string
+	Message string
+	Kind    string
+	// Namespace and Name of the involved object.
+	Namespace string
+	Name      string
+	// Namespace/Name of the involved object, or Name for objects which are not namespaced.
+	Object         string
+	FieldPath      string
+	Component      string
+	Host           string
+	Count          int32
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+	Event          *kube_api.Event
+}
+
+var funcs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// Cuts a string to the given number of bytes, for the fields notification services limit.
+	"truncate": func(length int, s string) string {
+		if len(s) > length {
+			return s[:length]
+		}
+		return s
+	},
+	"rfc3339": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	},
+}
+
+// Templates maps the names of the templates to their text.
+type Templates map[string]string
+
+// Formatter renders the title and message of the notifications of an event. The templates are
+// read from the directory given with the templates option of the sink, typically a ConfigMap
+// mounted in the Heapster pod, and reloaded when they change. Templates missing from the
+// directory, or failing to render, fall back to the defaults of the sink.
+type Formatter struct {
+	cluster   string
+	directory string
+	defaults  map[string]*template.Template
+
+	lock      sync.Mutex
+	templates map[string]*template.Template
+	modTimes  map[string]time.Time
+}
+
+// NewFormatter creates a formatter from the cluster and templates options of a sink.
+func NewFormatter(opts url.Values, defaults Templates) (*Formatter, error) {
+	this := &Formatter{
+		cluster:   opts.Get("cluster"),
+		directory: opts.Get("templates"),
+		defaults:  map[string]*template.Template{},
+		templates: map[string]*template.Template{},
+		modTimes:  map[string]time.Time{},
+	}
+	for _, name := range templateNames {
+		tmpl, err := parse(name, defaults[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid default %s template: %v", name, err)
+		}
+		this.defaults[name] = tmpl
+	}
+	if this.directory != "" {
+		info, err := os.Stat(this.directory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid templates directory: %v", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("templates should be a directory, e.g. a mounted ConfigMap, got %s", this.directory)
+		}
+		// Invalid templates are reported at startup, later they only fall back to the defaults.
+		for _, name := range templateNames {
+			if err := this.reload(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return this, nil
+}
+
+// Title renders the title of the notification of the event.
+func (this *Formatter) Title(event *kube_api.Event) string {
+	return this.render(TitleTemplate, event)
+}
+
+// Message renders the message of the notification of the event.
+func (this *Formatter) Message(event *kube_api.Event) string {
+	return this.render(MessageTemplate, event)
+}
+
+func (this *Formatter) render(name string, event *kube_api.Event) string {
+	alert := this.newAlert(event)
+	if tmpl := this.get(name); tmpl != nil {
+		var buffer bytes.Buffer
+		err := tmpl.Execute(&buffer, alert)
+		if err == nil {
+			return strings.TrimSpace(buffer.String())
+		}
+		glog.Warningf("Failed to render the %s template, using the default one: %v", name, err)
+	}
+	var buffer bytes.Buffer
+	if err := this.defaults[name].Execute(&buffer, alert); err != nil {
+		glog.Errorf("Failed to render the default %s template: %v", name, err)
+		return event.Message
+	}
+	return strings.TrimSpace(buffer.String())
+}
+
+// get returns the template of the directory with the given name, nil if there is none.
+func (this *Formatter) get(name string) *template.Template {
+	if this.directory == "" {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.reloadLocked(name); err != nil {
+		glog.Warningf("Failed to reload the %s template, using its previous version: %v", name, err)
+	}
+	return this.templates[name]
+}
+
+func (this *Formatter) reload(name string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.reloadLocked(name)
+}
+
+func (this *Formatter) reloadLocked(name string) error {
+	file := filepath.Join(this.directory, name)
+	// Kubernetes updates mounted ConfigMaps by swapping a symlink, Stat follows it.
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		delete(this.templates, name)
+		delete(this.modTimes, name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(this.modTimes[name]) {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	tmpl, err := parse(name, string(contents))
+	if err != nil {
+		return fmt.Errorf("invalid %s template in %s: %v", name, file, err)
+	}
+	if _, found := this.modTimes[name]; found {
+		glog.Infof("Reloaded the %s template from %s", name, file)
+	}
+	this.templates[name] = tmpl
+	this.modTimes[name] = info.ModTime()
+	return nil
+}
+
+func (this *Formatter) newAlert(event *kube_api.Event) *Alert {
+	object := event.InvolvedObject.Name
+	if event.InvolvedObject.Namespace != "" {
+		object = event.InvolvedObject.Namespace + "/" + object
+	}
+	return &Alert{
+		Cluster:        this.cluster,
+		Type:           event.Type,
+		Reason:         event.Reason,
+		Message:        event.Message,
+		Kind:           event.InvolvedObject.Kind,
+		Namespace:      event.InvolvedObject.Namespace,
+		Name:           event.InvolvedObject.Name,
+		Object:         object,
+		FieldPath:      event.InvolvedObject.FieldPath,
+		Component:      event.Source.Component,
+		Host:           event.Source.Host,
+		Count:          event.Count,
+		FirstTimestamp: event.FirstTimestamp.Time,
+		LastTimestamp:  event.LastTimestamp.Time,
+		Event:          event,
+	}
+}
+
+func parse(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Parse(text)
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alert
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var defaults = Templates{
+	TitleTemplate:   "{{.Type}}: {{.Reason}}",
+	MessageTemplate: "{{.Message}}",
+}
+
+var event = &kube_api.Event{
+	InvolvedObject: kube_api.ObjectReference{Kind: "Pod", Namespace: "ns1", Name: "web"},
+	Type:           kube_api.EventTypeWarning,
+	Reason:         "BackOff",
+	Message:        "Back-off restarting failed container",
+	Count:          3,
+	LastTimestamp:  metav1.NewTime(time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)),
+}
+
+func TestDefaultTemplates(t *testing.T) {
+	formatter, err := NewFormatter(url.Values{}, defaults)
+	require.NoError(t, err)
+	assert.Equal(t, "Warning: BackOff", formatter.Title(event))
+	assert.Equal(t, "Back-off restarting failed container", formatter.Message(event))
+}
+
+func TestTemplatesDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	title := filepath.Join(dir, TitleTemplate)
+	require.NoError(t, ioutil.WriteFile(title,
+		[]byte("[{{.Cluster}}] {{.Reason | upper}} on {{.Object}} x{{.Count}} at {{rfc3339 .LastTimestamp}}\n"), 0644))
+
+	formatter, err := NewFormatter(url.Values{"cluster": {"prod"}, "templates": {dir}}, defaults)
+	require.NoError(t, err)
+	assert.Equal(t, "[prod] BACKOFF on ns1/web x3 at 2017-09-01T12:00:00Z", formatter.Title(event))
+	// There is no message template in the directory.
+	assert.Equal(t, "Back-off restarting failed container", formatter.Message(event))
+
+	// The templates are reloaded when the ConfigMap changes.
+	require.NoError(t, ioutil.WriteFile(title, []byte("{{truncate 4 .Message}}"), 0644))
+	require.NoError(t, os.Chtimes(title, time.Now(), time.Now().Add(time.Minute)))
+	assert.Equal(t, "Back", formatter.Title(event))
+
+	// Invalid templates keep the previous version.
+	require.NoError(t, ioutil.WriteFile(title, []byte("{{.Reason"), 0644))
+	require.NoError(t, os.Chtimes(title, time.Now(), time.Now().Add(2*time.Minute)))
+	assert.Equal(t, "Back", formatter.Title(event))
+
+	// Templates failing to render fall back to the defaults.
+	require.NoError(t, ioutil.WriteFile(title, []byte("{{.Unknown}}"), 0644))
+	require.NoError(t, os.Chtimes(title, time.Now(), time.Now().Add(3*time.Minute)))
+	assert.Equal(t, "Warning: BackOff", formatter.Title(event))
+
+	// Removed templates fall back to the defaults too.
+	require.NoError(t, os.Remove(title))
+	assert.Equal(t, "Warning: BackOff", formatter.Title(event))
+}
+
+func TestInvalidTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, MessageTemplate), []byte("{{if}}"), 0644))
+
+	_, err = NewFormatter(url.Values{"templates": {dir}}, defaults)
+	assert.Error(t, err)
+	_, err = NewFormatter(url.Values{"templates": {filepath.Join(dir, "missing")}}, defaults)
+	assert.Error(t, err)
+	_, err = NewFormatter(url.Values{"templates": {filepath.Join(dir, MessageTemplate)}}, defaults)
+	assert.Error(t, err)
+	_, err = NewFormatter(url.Values{}, Templates{TitleTemplate: "{{"})
+	assert.Error(t, err)
+}
@@ -19,13 +19,16 @@ import (
 
 	"k8s.io/heapster/common/flags"
 	"k8s.io/heapster/events/core"
+	"k8s.io/heapster/events/policy"
 	"k8s.io/heapster/events/sinks/elasticsearch"
 	"k8s.io/heapster/events/sinks/gcl"
 	"k8s.io/heapster/events/sinks/honeycomb"
 	"k8s.io/heapster/events/sinks/influxdb"
 	"k8s.io/heapster/events/sinks/kafka"
 	"k8s.io/heapster/events/sinks/log"
+	"k8s.io/heapster/events/sinks/opsgenie"
 	"k8s.io/heapster/events/sinks/riemann"
+	"k8s.io/heapster/events/sinks/teams"
 
 	"github.com/golang/glog"
 )
@@ -49,12 +52,32 @@ func (this *SinkFactory) Build(uri flags.Uri) (core.EventSink, error) {
 		return riemann.CreateRiemannSink(&uri.Val)
 	case "honeycomb":
 		return honeycomb.NewHoneycombSink(&uri.Val)
+	case "opsgenie":
+		return opsgenie.NewOpsgenieSink(&uri.Val)
+	case "teams":
+		return teams.NewTeamsSink(&uri.Val)
 	default:
 		return nil, fmt.Errorf("Sink not recognized: %s", uri.Key)
 	}
 }
 
+// Supports returns true if the factory can build an event sink of the given type.
+func (this *SinkFactory) Supports(key string) bool {
+	switch key {
+	case "gcl", "log", "influxdb", "elasticsearch", "kafka", "riemann", "honeycomb", "opsgenie", "teams":
+		return true
+	default:
+		return false
+	}
+}
+
 func (this *SinkFactory) BuildAll(uris flags.Uris) []core.EventSink {
+	return this.BuildAllFiltered(uris, nil)
+}
+
+// BuildAllFiltered is like BuildAll, but each sink only exports the events allowed for its type
+// by the policies of their namespaces. Nil policies export all events.
+func (this *SinkFactory) BuildAllFiltered(uris flags.Uris, policies *policy.NamespacePolicies) []core.EventSink {
 	result := make([]core.EventSink, 0, len(uris))
 	for _, uri := range uris {
 		sink, err := this.Build(uri)
@@ -62,6 +85,9 @@ func (this *SinkFactory) BuildAll(uris flags.Uris) []core.EventSink {
 			glog.Errorf("Failed to create %v sink: %v", uri, err)
 			continue
 		}
+		if policies != nil {
+			sink = policy.NewFilteringSink(uri.Key, sink, policies)
+		}
 		result = append(result, sink)
 	}
 	return result
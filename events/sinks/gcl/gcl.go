@@ -18,11 +18,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
+	kube_api "k8s.io/api/core/v1"
 	gce_util "k8s.io/heapster/common/gce"
 	"k8s.io/heapster/events/core"
 
+	gce "cloud.google.com/go/compute/metadata"
 	"github.com/golang/glog"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -30,14 +33,29 @@ import (
 )
 
 const (
-	monitoredResourceType = "global"
-	logName               = "kubernetes.io/events"
-	loggingSeverity       = "NOTICE"
+	globalResourceType = "global"
+	defaultLogName     = "kubernetes.io/events"
+	// Severity of events of unknown types.
+	defaultSeverity = "NOTICE"
 )
 
+// Severities of log entries by event type, unless overridden by the severities option.
+var defaultSeverities = map[string]string{
+	kube_api.EventTypeNormal:  "INFO",
+	kube_api.EventTypeWarning: "WARNING",
+}
+
 type gclSink struct {
 	project    string
 	gclService *gcl.Service
+	// Cluster name and location of the k8s_* monitored resources. If the cluster name is not
+	// known, all entries are written for the global resource.
+	clusterName     string
+	clusterLocation string
+	logName         string
+	// Log names of events in particular namespaces, by namespace name.
+	namespaceLogNames map[string]string
+	severities        map[string]string
 }
 
 func (sink *gclSink) ExportEvents(eventBatch *core.EventBatch) {
@@ -46,21 +64,17 @@ func (sink *gclSink) ExportEvents(eventBatch *core.EventBatch) {
 		return
 	}
 	glog.V(4).Info("Exporting events")
-	entries := make([]*gcl.LogEntry, len(eventBatch.Events))
-	for i, event := range eventBatch.Events {
-		evtJson, err := json.Marshal(event)
+	entries := make([]*gcl.LogEntry, 0, len(eventBatch.Events))
+	for _, event := range eventBatch.Events {
+		entry, err := sink.logEntry(event)
 		if err != nil {
 			glog.Errorf("Skipping exporting event due to error while marshaling event %v as JSON: %v", event, err)
 			continue
 		}
-		entries[i] = &gcl.LogEntry{
-			LogName:     fmt.Sprintf("projects/%s/logs/%s", sink.project, url.QueryEscape(logName)),
-			Timestamp:   event.LastTimestamp.Time.UTC().Format(time.RFC3339),
-			Severity:    loggingSeverity,
-			Resource:    &gcl.MonitoredResource{Type: monitoredResourceType},
-			InsertId:    string(event.UID),
-			JsonPayload: evtJson,
-		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return
 	}
 	req := &gcl.WriteLogEntriesRequest{Entries: entries}
 	if _, err := sink.gclService.Entries.Write(req).Do(); err != nil {
@@ -70,6 +84,52 @@ func (sink *gclSink) ExportEvents(eventBatch *core.EventBatch) {
 	}
 }
 
+func (sink *gclSink) logEntry(event *kube_api.Event) (*gcl.LogEntry, error) {
+	evtJson, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	logName := sink.logName
+	if name, found := sink.namespaceLogNames[event.InvolvedObject.Namespace]; found {
+		logName = name
+	}
+	severity, found := sink.severities[event.Type]
+	if !found {
+		severity = defaultSeverity
+	}
+	return &gcl.LogEntry{
+		LogName:     fmt.Sprintf("projects/%s/logs/%s", sink.project, url.QueryEscape(logName)),
+		Timestamp:   event.LastTimestamp.Time.UTC().Format(time.RFC3339),
+		Severity:    severity,
+		Resource:    sink.monitoredResource(&event.InvolvedObject),
+		InsertId:    string(event.UID),
+		JsonPayload: evtJson,
+	}, nil
+}
+
+// monitoredResource returns the k8s_pod or k8s_node resource of events about pods or nodes,
+// and the k8s_cluster resource of other events.
+func (sink *gclSink) monitoredResource(object *kube_api.ObjectReference) *gcl.MonitoredResource {
+	if sink.clusterName == "" {
+		return &gcl.MonitoredResource{Type: globalResourceType}
+	}
+	labels := map[string]string{
+		"project_id":   sink.project,
+		"location":     sink.clusterLocation,
+		"cluster_name": sink.clusterName,
+	}
+	switch object.Kind {
+	case "Pod":
+		labels["namespace_name"] = object.Namespace
+		labels["pod_name"] = object.Name
+		return &gcl.MonitoredResource{Type: "k8s_pod", Labels: labels}
+	case "Node":
+		labels["node_name"] = object.Name
+		return &gcl.MonitoredResource{Type: "k8s_node", Labels: labels}
+	}
+	return &gcl.MonitoredResource{Type: "k8s_cluster", Labels: labels}
+}
+
 func (sink *gclSink) Name() string {
 	return "GCL Sink"
 }
@@ -79,6 +139,48 @@ func (sink *gclSink) Stop() {
 }
 
 func CreateGCLSink(uri *url.URL) (core.EventSink, error) {
+	opts := uri.Query()
+	sink := &gclSink{
+		clusterName:       opts.Get("cluster_name"),
+		clusterLocation:   opts.Get("cluster_location"),
+		logName:           defaultLogName,
+		namespaceLogNames: map[string]string{},
+		severities:        map[string]string{},
+	}
+	if name := opts.Get("log_name"); name != "" {
+		sink.logName = name
+	}
+	var err error
+	if sink.namespaceLogNames, err = parsePairs(opts.Get("namespace_log_names")); err != nil {
+		return nil, fmt.Errorf("invalid namespace_log_names: %v", err)
+	}
+	for eventType, severity := range defaultSeverities {
+		sink.severities[eventType] = severity
+	}
+	severities, err := parsePairs(opts.Get("severities"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid severities: %v", err)
+	}
+	for eventType, severity := range severities {
+		sink.severities[eventType] = strings.ToUpper(severity)
+	}
+
+	if gce.OnGCE() {
+		if sink.clusterName == "" {
+			if sink.clusterName, err = gce.InstanceAttributeValue("cluster-name"); err != nil {
+				glog.Warningf("Cluster name could not be discovered using the GCE Metadata Server: %v", err)
+			}
+		}
+		if sink.clusterLocation == "" {
+			if sink.clusterLocation, err = gce.InstanceAttributeValue("cluster-location"); err != nil {
+				glog.Warningf("Cluster location could not be discovered using the GCE Metadata Server: %v", err)
+			}
+		}
+	}
+	if sink.clusterName == "" {
+		glog.Warning("Cluster name unknown, writing events for the global monitored resource")
+	}
+
 	client, err := google.DefaultClient(oauth2.NoContext, gcl.LoggingWriteScope)
 	if err != nil {
 		return nil, fmt.Errorf("error creating oauth2 client: %v", err)
@@ -96,7 +198,24 @@ func CreateGCLSink(uri *url.URL) (core.EventSink, error) {
 		return nil, fmt.Errorf("error getting GCP project ID: %v", err)
 	}
 
-	sink := &gclSink{project: projectId, gclService: gclService}
+	sink.project = projectId
+	sink.gclService = gclService
 	glog.Info("created GCL sink")
 	return sink, nil
 }
+
+// parsePairs parses a comma-separated list of key:value pairs.
+func parsePairs(value string) (map[string]string, error) {
+	result := map[string]string{}
+	if value == "" {
+		return result, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected key:value, found %q", pair)
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kube_api "k8s.io/api/core/v1"
+)
+
+func newEvent(eventType, kind, namespace, name string) *kube_api.Event {
+	return &kube_api.Event{
+		Type: eventType,
+		InvolvedObject: kube_api.ObjectReference{
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+}
+
+func TestLogEntry(t *testing.T) {
+	sink := &gclSink{
+		project:           "project",
+		clusterName:       "cluster",
+		clusterLocation:   "europe-west1-c",
+		logName:           defaultLogName,
+		namespaceLogNames: map[string]string{"team-a": "team-a/events"},
+		severities:        map[string]string{"Normal": "INFO", "Warning": "ERROR"},
+	}
+
+	entry, err := sink.logEntry(newEvent("Warning", "Pod", "default", "web"))
+	require.NoError(t, err)
+	assert.Equal(t, "ERROR", entry.Severity)
+	assert.Equal(t, "projects/project/logs/kubernetes.io%2Fevents", entry.LogName)
+	assert.Equal(t, "k8s_pod", entry.Resource.Type)
+	assert.Equal(t, map[string]string{
+		"project_id":     "project",
+		"location":       "europe-west1-c",
+		"cluster_name":   "cluster",
+		"namespace_name": "default",
+		"pod_name":       "web",
+	}, entry.Resource.Labels)
+
+	entry, err = sink.logEntry(newEvent("Normal", "Node", "", "node-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "INFO", entry.Severity)
+	assert.Equal(t, "k8s_node", entry.Resource.Type)
+	assert.Equal(t, "node-1", entry.Resource.Labels["node_name"])
+
+	entry, err = sink.logEntry(newEvent("Custom", "Deployment", "team-a", "web"))
+	require.NoError(t, err)
+	assert.Equal(t, defaultSeverity, entry.Severity)
+	assert.Equal(t, "projects/project/logs/team-a%2Fevents", entry.LogName)
+	assert.Equal(t, "k8s_cluster", entry.Resource.Type)
+
+	// Without the cluster name the k8s_* resources cannot be identified.
+	sink.clusterName = ""
+	entry, err = sink.logEntry(newEvent("Normal", "Pod", "default", "web"))
+	require.NoError(t, err)
+	assert.Equal(t, globalResourceType, entry.Resource.Type)
+}
+
+func TestParsePairs(t *testing.T) {
+	pairs, err := parsePairs("Normal:DEBUG,Warning:ALERT")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Normal": "DEBUG", "Warning": "ALERT"}, pairs)
+
+	pairs, err = parsePairs("")
+	require.NoError(t, err)
+	assert.Empty(t, pairs)
+
+	_, err = parsePairs("Normal")
+	assert.Error(t, err)
+}
@@ -45,6 +45,10 @@ func (client *fakeKafkaClient) ProduceKafkaMessage(msgData interface{}) error {
 	return nil
 }
 
+func (client *fakeKafkaClient) ProduceKeyedKafkaMessage(key string, msgData interface{}) error {
+	return client.ProduceKafkaMessage(msgData)
+}
+
 func (client *fakeKafkaClient) Name() string {
 	return "Apache Kafka Sink"
 }
@@ -29,6 +29,28 @@ const (
 )
 
 var (
+	// Last time Eventer exported events since unix epoch in seconds.
+	lastExportTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "eventer",
+			Subsystem: "exporter",
+			Name:      "last_time_seconds",
+			Help:      "Last time Eventer exported events since unix epoch in seconds.",
+		},
+		[]string{"exporter"},
+	)
+
+	// Number of event batches that could not be pushed to a sink in time.
+	droppedBatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "exporter",
+			Name:      "dropped_batches_total",
+			Help:      "Number of event batches dropped because the sink did not accept them in time.",
+		},
+		[]string{"exporter"},
+	)
+
 	// Time spent exporting events to sink in milliseconds.
 	exporterDuration = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
@@ -42,6 +64,8 @@ var (
 )
 
 func init() {
+	prometheus.MustRegister(lastExportTimestamp)
+	prometheus.MustRegister(droppedBatches)
 	prometheus.MustRegister(exporterDuration)
 }
 
@@ -49,28 +73,31 @@ type sinkHolder struct {
 	sink              core.EventSink
 	eventBatchChannel chan *core.EventBatch
 	stopChannel       chan bool
+	// Number of batches dropped in a row.
+	consecutiveFailures int
 }
 
 // Sink Manager - a special sink that distributes data to other sinks. It pushes data
 // only to these sinks that completed their previous exports. Data that could not be
-// pushed in the defined time is dropped and not retried.
+// pushed in the defined time is dropped and not retried. Pushes to different sinks are
+// concurrent, so a slow sink delays the export to the others by exportEventsTimeout at most.
 type sinkManager struct {
-	sinkHolders         []sinkHolder
+	sinkHolders         []*sinkHolder
 	exportEventsTimeout time.Duration
 	// Should be larger than exportEventsTimeout, although it is not a hard requirement.
 	stopTimeout time.Duration
 }
 
 func NewEventSinkManager(sinks []core.EventSink, exportEventsTimeout, stopTimeout time.Duration) (core.EventSink, error) {
-	sinkHolders := []sinkHolder{}
+	sinkHolders := []*sinkHolder{}
 	for _, sink := range sinks {
-		sh := sinkHolder{
+		sh := &sinkHolder{
 			sink:              sink,
 			eventBatchChannel: make(chan *core.EventBatch),
 			stopChannel:       make(chan bool),
 		}
 		sinkHolders = append(sinkHolders, sh)
-		go func(sh sinkHolder) {
+		go func(sh *sinkHolder) {
 			for {
 				select {
 				case data := <-sh.eventBatchChannel:
@@ -97,15 +124,17 @@ func (this *sinkManager) ExportEvents(data *core.EventBatch) {
 	var wg sync.WaitGroup
 	for _, sh := range this.sinkHolders {
 		wg.Add(1)
-		go func(sh sinkHolder, wg *sync.WaitGroup) {
+		go func(sh *sinkHolder, wg *sync.WaitGroup) {
 			defer wg.Done()
 			glog.V(2).Infof("Pushing events to: %s", sh.sink.Name())
 			select {
 			case sh.eventBatchChannel <- data:
 				glog.V(2).Infof("Data events completed: %s", sh.sink.Name())
-				// everything ok
+				sh.consecutiveFailures = 0
 			case <-time.After(this.exportEventsTimeout):
-				glog.Warningf("Failed to events data to sink: %s", sh.sink.Name())
+				sh.consecutiveFailures++
+				droppedBatches.WithLabelValues(sh.sink.Name()).Inc()
+				glog.Warningf("Failed to push events to sink: %s (%d consecutive failures)", sh.sink.Name(), sh.consecutiveFailures)
 			}
 		}(sh, &wg)
 	}
@@ -121,7 +150,7 @@ func (this *sinkManager) Stop() {
 	for _, sh := range this.sinkHolders {
 		glog.V(2).Infof("Running stop for: %s", sh.sink.Name())
 
-		go func(sh sinkHolder) {
+		go func(sh *sinkHolder) {
 			select {
 			case sh.stopChannel <- true:
 				// everything ok
@@ -138,6 +167,9 @@ func (this *sinkManager) Stop() {
 func export(s core.EventSink, data *core.EventBatch) {
 	startTime := time.Now()
 	defer func() {
+		lastExportTimestamp.
+			WithLabelValues(s.Name()).
+			Set(float64(time.Now().Unix()))
 		exporterDuration.
 			WithLabelValues(s.Name()).
 			Observe(float64(time.Since(startTime)) / float64(time.Millisecond))
@@ -112,3 +112,20 @@ func TestStop(t *testing.T) {
 	assert.Equal(t, true, sink1.IsStopped())
 	assert.Equal(t, true, sink2.IsStopped())
 }
+
+func TestSlowSinkFailuresCounted(t *testing.T) {
+	timeout := 100 * time.Millisecond
+
+	slow := util.NewDummySink("slow", 30*time.Second)
+	fast := util.NewDummySink("fast", 0)
+	manager, _ := NewEventSinkManager([]core.EventSink{slow, fast}, timeout, timeout)
+
+	doThreeBatches(manager)
+	time.Sleep(100 * time.Millisecond)
+
+	// The slow sink accepts the first batch only, the fast one gets all of them.
+	assert.Equal(t, 3, fast.GetExportCount())
+	holders := manager.(*sinkManager).sinkHolders
+	assert.Equal(t, 2, holders[0].consecutiveFailures)
+	assert.Equal(t, 0, holders[1].consecutiveFailures)
+}
@@ -0,0 +1,251 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opsgenie
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/events/core"
+	"k8s.io/heapster/events/sinks/alert"
+)
+
+const (
+	defaultApiUrl   = "https://api.opsgenie.com"
+	defaultPriority = "P3"
+	defaultSource   = "heapster-eventer"
+	requestTimeout  = 10 * time.Second
+	// Opsgenie rejects longer aliases and messages.
+	maxAliasLength   = 512
+	maxMessageLength = 130
+)
+
+// The title of the templates is the message of the alerts, the message is their description.
+var defaultTemplates = alert.Templates{
+	alert.TitleTemplate:   "{{.Reason}}: {{.Object}}",
+	alert.MessageTemplate: "{{.Message}}",
+}
+
+// defaultRecoveries maps the reasons of events reporting a problem to the reasons of the events
+// reporting its recovery. Most of them are Normal events, so they are alerted on regardless of
+// their type.
+var defaultRecoveries = map[string]string{
+	"NodeNotReady":              "NodeReady",
+	"NodeNotSchedulable":        "NodeSchedulable",
+	"NodeHasDiskPressure":       "NodeHasNoDiskPressure",
+	"NodeHasInsufficientMemory": "NodeHasSufficientMemory",
+	"NodeHasInsufficientPID":    "NodeHasSufficientPID",
+	"NodeOutOfDisk":             "NodeHasSufficientDisk",
+}
+
+type opsgenieSink struct {
+	client      *http.Client
+	apiUrl      string
+	credentials *credentials.Credentials
+	formatter   *alert.Formatter
+	priority    string
+	tags        []string
+	// Reasons of the problems closed by each recovery reason.
+	problems map[string][]string
+	// Reasons alerted on regardless of the type of their events.
+	alerted map[string]bool
+}
+
+// NewOpsgenieSink creates a sink opening an Opsgenie alert for each Warning event, and for the
+// events reporting a problem which recovers, e.g. NodeNotReady. Alerts are deduplicated by
+// their alias, made of the involved object and the reason of the event, and closed when the
+// matching recovery event, e.g. NodeReady, arrives.
+func NewOpsgenieSink(uri *url.URL) (core.EventSink, error) {
+	opts := uri.Query()
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+	if creds.Get().Token == "" {
+		return nil, fmt.Errorf("the API key should be set with the token, tokenFile or tokenEnv option")
+	}
+	formatter, err := alert.NewFormatter(opts, defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &opsgenieSink{
+		client:      &http.Client{Timeout: requestTimeout},
+		apiUrl:      defaultApiUrl,
+		credentials: creds,
+		formatter:   formatter,
+		priority:    defaultPriority,
+		problems:    map[string][]string{},
+		alerted:     map[string]bool{},
+	}
+	if len(opts["url"]) > 0 {
+		if _, err := url.ParseRequestURI(opts["url"][0]); err != nil {
+			return nil, fmt.Errorf("invalid url %q: %v", opts["url"][0], err)
+		}
+		sink.apiUrl = strings.TrimSuffix(opts["url"][0], "/")
+	}
+	if len(opts["priority"]) > 0 {
+		sink.priority = opts["priority"][0]
+		switch sink.priority {
+		case "P1", "P2", "P3", "P4", "P5":
+		default:
+			return nil, fmt.Errorf("invalid priority %q, should be one of P1 to P5", sink.priority)
+		}
+	}
+	if len(opts["tags"]) > 0 {
+		sink.tags = strings.Split(opts["tags"][0], ",")
+	}
+
+	recoveries := map[string]string{}
+	for problem, recovery := range defaultRecoveries {
+		recoveries[problem] = recovery
+	}
+	for _, option := range opts["recovery"] {
+		parts := strings.SplitN(option, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid recovery %q, should be <problem reason>:<recovery reason>", option)
+		}
+		recoveries[parts[0]] = parts[1]
+	}
+	for problem, recovery := range recoveries {
+		sink.problems[recovery] = append(sink.problems[recovery], problem)
+		sink.alerted[problem] = true
+	}
+	return sink, nil
+}
+
+func (sink *opsgenieSink) Name() string {
+	return "Opsgenie Sink"
+}
+
+func (sink *opsgenieSink) Stop() {}
+
+func (sink *opsgenieSink) ExportEvents(eventBatch *core.EventBatch) {
+	for _, event := range eventBatch.Events {
+		for _, problem := range sink.problems[event.Reason] {
+			if err := sink.close(alias(event, problem), event); err != nil {
+				glog.Warningf("Failed to close the Opsgenie alert for %s of %s: %v", problem, objectName(event), err)
+			}
+		}
+		if event.Type == kube_api.EventTypeWarning || sink.alerted[event.Reason] {
+			if err := sink.open(event); err != nil {
+				glog.Warningf("Failed to create an Opsgenie alert for %s of %s: %v", event.Reason, objectName(event), err)
+			}
+		}
+	}
+}
+
+type createAlertRequest struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+	Entity      string            `json:"entity,omitempty"`
+	Source      string            `json:"source"`
+	Priority    string            `json:"priority"`
+}
+
+type closeAlertRequest struct {
+	Source string `json:"source"`
+	Note   string `json:"note,omitempty"`
+}
+
+func (sink *opsgenieSink) open(event *kube_api.Event) error {
+	message := sink.formatter.Title(event)
+	if len(message) > maxMessageLength {
+		message = message[:maxMessageLength]
+	}
+	return sink.post("/v2/alerts", &createAlertRequest{
+		Message:     message,
+		Alias:       alias(event, event.Reason),
+		Description: sink.formatter.Message(event),
+		Tags:        sink.tags,
+		Details: map[string]string{
+			"kind":      event.InvolvedObject.Kind,
+			"namespace": event.InvolvedObject.Namespace,
+			"name":      event.InvolvedObject.Name,
+			"reason":    event.Reason,
+			"type":      event.Type,
+			"count":     strconv.Itoa(int(event.Count)),
+			"component": event.Source.Component,
+			"host":      event.Source.Host,
+		},
+		Entity:   objectName(event),
+		Source:   defaultSource,
+		Priority: sink.priority,
+	})
+}
+
+func (sink *opsgenieSink) close(alias string, event *kube_api.Event) error {
+	path := "/v2/alerts/" + url.PathEscape(alias) + "/close?identifierType=alias"
+	return sink.post(path, &closeAlertRequest{
+		Source: defaultSource,
+		Note:   fmt.Sprintf("%s: %s", event.Reason, event.Message),
+	})
+}
+
+func (sink *opsgenieSink) post(path string, body interface{}) error {
+	contents, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", sink.apiUrl+path, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+sink.credentials.Get().Token)
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Requests are processed asynchronously, Opsgenie accepts them with 202.
+	if resp.StatusCode/100 != 2 {
+		response, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", path, resp.Status, strings.TrimSpace(string(response)))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// alias identifies the alerts for the problem of the involved object of the event, so that
+// Opsgenie counts repeated events as a single alert.
+func alias(event *kube_api.Event, reason string) string {
+	alias := fmt.Sprintf("%s/%s:%s", strings.ToLower(event.InvolvedObject.Kind), objectName(event), reason)
+	if len(alias) > maxAliasLength {
+		alias = alias[:maxAliasLength]
+	}
+	return alias
+}
+
+func objectName(event *kube_api.Event) string {
+	if event.InvolvedObject.Namespace == "" {
+		return event.InvolvedObject.Name
+	}
+	return event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name
+}
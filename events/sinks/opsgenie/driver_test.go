@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opsgenie
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/heapster/events/core"
+)
+
+type request struct {
+	uri           string
+	authorization string
+	body          map[string]interface{}
+}
+
+func newEvent(eventType, kind, namespace, name, reason string) *kube_api.Event {
+	return &kube_api.Event{
+		InvolvedObject: kube_api.ObjectReference{Kind: kind, Namespace: namespace, Name: name},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        reason + " happened",
+		Count:          1,
+	}
+}
+
+func TestOpsgenieSink(t *testing.T) {
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		body := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(contents, &body))
+		requests = append(requests, request{uri: r.URL.RequestURI(), authorization: r.Header.Get("Authorization"), body: body})
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse("opsgenie:?" + url.Values{
+		"url":      {server.URL},
+		"token":    {"key"},
+		"priority": {"P2"},
+		"tags":     {"kubernetes,prod"},
+		"recovery": {"BackOff:Started"},
+	}.Encode())
+	require.NoError(t, err)
+	sink, err := NewOpsgenieSink(uri)
+	require.NoError(t, err)
+
+	sink.ExportEvents(&core.EventBatch{Events: []*kube_api.Event{
+		newEvent(kube_api.EventTypeNormal, "Node", "", "node-1", "NodeNotReady"),
+		newEvent(kube_api.EventTypeWarning, "Pod", "ns1", "web", "BackOff"),
+		// Normal events without recovery are not alerted on.
+		newEvent(kube_api.EventTypeNormal, "Pod", "ns1", "web", "Pulled"),
+		newEvent(kube_api.EventTypeNormal, "Node", "", "node-1", "NodeReady"),
+		newEvent(kube_api.EventTypeNormal, "Pod", "ns1", "web", "Started"),
+	}})
+
+	require.Len(t, requests, 4)
+	assert.Equal(t, "/v2/alerts", requests[0].uri)
+	assert.Equal(t, "GenieKey key", requests[0].authorization)
+	assert.Equal(t, "node/node-1:NodeNotReady", requests[0].body["alias"])
+	assert.Equal(t, "NodeNotReady: node-1", requests[0].body["message"])
+	assert.Equal(t, "P2", requests[0].body["priority"])
+	assert.Equal(t, []interface{}{"kubernetes", "prod"}, requests[0].body["tags"])
+
+	assert.Equal(t, "/v2/alerts", requests[1].uri)
+	assert.Equal(t, "pod/ns1/web:BackOff", requests[1].body["alias"])
+
+	assert.Equal(t, "/v2/alerts/node%2Fnode-1:NodeNotReady/close?identifierType=alias", requests[2].uri)
+	assert.Equal(t, "NodeReady: NodeReady happened", requests[2].body["note"])
+	assert.Equal(t, "/v2/alerts/pod%2Fns1%2Fweb:BackOff/close?identifierType=alias", requests[3].uri)
+}
+
+func TestOpsgenieSinkOptions(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"token=key&priority=P9",
+		"token=key&recovery=BackOff",
+		"token=key&url=not-a-url",
+	} {
+		uri, err := url.Parse("opsgenie:?" + query)
+		require.NoError(t, err)
+		_, err = NewOpsgenieSink(uri)
+		assert.Error(t, err, query)
+	}
+}
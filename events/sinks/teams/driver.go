@@ -0,0 +1,269 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package teams
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/heapster/events/core"
+	"k8s.io/heapster/events/sinks/alert"
+)
+
+const (
+	// Teams throttles incoming webhooks posting more than a few messages per second.
+	defaultMessagesPerMinute = 30
+	defaultBurst             = 10
+	requestTimeout           = 10 * time.Second
+	adaptiveCardContentType  = "application/vnd.microsoft.card.adaptive"
+)
+
+var defaultTemplates = alert.Templates{
+	alert.TitleTemplate:   "{{if .Cluster}}[{{.Cluster}}] {{end}}{{.Type}}: {{.Reason}}",
+	alert.MessageTemplate: "{{.Message}}",
+}
+
+var (
+	// Number of events not posted to Teams because the rate limit of their channel was reached.
+	droppedEvents = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "eventer",
+			Subsystem: "teams",
+			Name:      "rate_limited_events_total",
+			Help:      "Number of events not posted to Teams because the rate limit of their channel was reached.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(droppedEvents)
+}
+
+// channel is a Teams incoming webhook, with its own rate limit.
+type channel struct {
+	webhook string
+	limiter flowcontrol.RateLimiter
+}
+
+type teamsSink struct {
+	client    *http.Client
+	formatter *alert.Formatter
+	// Only events of this type are posted, all events if empty.
+	eventType      string
+	defaultChannel *channel
+	// Channels of the events with the given reasons.
+	routes map[string]*channel
+}
+
+// NewTeamsSink creates a sink posting events as adaptive cards to Microsoft Teams incoming
+// webhooks, e.g. `teams:?webhook=https://outlook.office.com/webhook/...`. Events can be routed
+// to other channels by reason with `route=BackOff,Failed=<webhook>`. The title and text of the
+// cards can be customized with templates, see the alert package.
+func NewTeamsSink(uri *url.URL) (core.EventSink, error) {
+	opts := uri.Query()
+	if len(opts["webhook"]) == 0 {
+		return nil, fmt.Errorf("the webhook option is required")
+	}
+
+	perMinute := defaultMessagesPerMinute
+	if len(opts["rate"]) > 0 {
+		rate, err := strconv.Atoi(opts["rate"][0])
+		if err != nil || rate < 1 {
+			return nil, fmt.Errorf("invalid rate %q, should be a positive number of messages per minute", opts["rate"][0])
+		}
+		perMinute = rate
+	}
+	burst := defaultBurst
+	if burst > perMinute {
+		burst = perMinute
+	}
+	newChannel := func(webhook string) (*channel, error) {
+		if _, err := url.ParseRequestURI(webhook); err != nil {
+			return nil, fmt.Errorf("invalid webhook %q: %v", webhook, err)
+		}
+		return &channel{
+			webhook: webhook,
+			limiter: flowcontrol.NewTokenBucketRateLimiter(float32(perMinute)/60, burst),
+		}, nil
+	}
+
+	formatter, err := alert.NewFormatter(opts, defaultTemplates)
+	if err != nil {
+		return nil, err
+	}
+	sink := &teamsSink{
+		client:    &http.Client{Timeout: requestTimeout},
+		formatter: formatter,
+		routes:    map[string]*channel{},
+	}
+	if sink.defaultChannel, err = newChannel(opts["webhook"][0]); err != nil {
+		return nil, err
+	}
+	if len(opts["eventType"]) > 0 {
+		sink.eventType = opts["eventType"][0]
+		if sink.eventType != kube_api.EventTypeNormal && sink.eventType != kube_api.EventTypeWarning {
+			return nil, fmt.Errorf("invalid event type %q, should be %s or %s", sink.eventType, kube_api.EventTypeNormal, kube_api.EventTypeWarning)
+		}
+	}
+	// Channels routed several reasons share their rate limit.
+	channels := map[string]*channel{sink.defaultChannel.webhook: sink.defaultChannel}
+	for _, route := range opts["route"] {
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid route %q, should be <reason>[,<reason>]=<webhook>", route)
+		}
+		ch, found := channels[parts[1]]
+		if !found {
+			if ch, err = newChannel(parts[1]); err != nil {
+				return nil, err
+			}
+			channels[parts[1]] = ch
+		}
+		for _, reason := range strings.Split(parts[0], ",") {
+			sink.routes[reason] = ch
+		}
+	}
+	return sink, nil
+}
+
+func (sink *teamsSink) Name() string {
+	return "Teams Sink"
+}
+
+func (sink *teamsSink) Stop() {}
+
+func (sink *teamsSink) ExportEvents(eventBatch *core.EventBatch) {
+	for _, event := range eventBatch.Events {
+		if sink.eventType != "" && event.Type != sink.eventType {
+			continue
+		}
+		ch := sink.defaultChannel
+		if routed, found := sink.routes[event.Reason]; found {
+			ch = routed
+		}
+		if !ch.limiter.TryAccept() {
+			droppedEvents.Inc()
+			glog.V(2).Infof("Rate limit of Teams channel reached, dropping event %s/%s", event.Namespace, event.Name)
+			continue
+		}
+		if err := sink.post(ch.webhook, sink.card(event)); err != nil {
+			glog.Warningf("Failed to post event %s/%s to Teams: %v", event.Namespace, event.Name, err)
+		}
+	}
+}
+
+func (sink *teamsSink) post(webhook string, message *message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	resp, err := sink.client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		contents, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// message is an incoming webhook message holding an adaptive card.
+type message struct {
+	Type        string       `json:"type"`
+	Attachments []attachment `json:"attachments"`
+}
+
+type attachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string            `json:"$schema"`
+	Type    string            `json:"type"`
+	Version string            `json:"version"`
+	Body    []cardElement     `json:"body"`
+	MSTeams map[string]string `json:"msteams,omitempty"`
+}
+
+type cardElement struct {
+	Type   string `json:"type"`
+	Text   string `json:"text,omitempty"`
+	Size   string `json:"size,omitempty"`
+	Weight string `json:"weight,omitempty"`
+	Color  string `json:"color,omitempty"`
+	Wrap   bool   `json:"wrap,omitempty"`
+	Facts  []fact `json:"facts,omitempty"`
+}
+
+type fact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+func (sink *teamsSink) card(event *kube_api.Event) *message {
+	color := "good"
+	if event.Type == kube_api.EventTypeWarning {
+		color = "attention"
+	}
+	object := event.InvolvedObject.Kind + "/" + event.InvolvedObject.Name
+	if event.InvolvedObject.FieldPath != "" {
+		object += " (" + event.InvolvedObject.FieldPath + ")"
+	}
+	facts := []fact{
+		{Title: "Namespace", Value: event.InvolvedObject.Namespace},
+		{Title: "Object", Value: object},
+		{Title: "Source", Value: strings.TrimSpace(event.Source.Component + " " + event.Source.Host)},
+		{Title: "Count", Value: strconv.Itoa(int(event.Count))},
+	}
+	if !event.FirstTimestamp.IsZero() {
+		facts = append(facts, fact{Title: "First seen", Value: event.FirstTimestamp.UTC().Format(time.RFC3339)})
+	}
+	if !event.LastTimestamp.IsZero() {
+		facts = append(facts, fact{Title: "Last seen", Value: event.LastTimestamp.UTC().Format(time.RFC3339)})
+	}
+	return &message{
+		Type: "message",
+		Attachments: []attachment{{
+			ContentType: adaptiveCardContentType,
+			Content: adaptiveCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.2",
+				Body: []cardElement{
+					{Type: "TextBlock", Text: sink.formatter.Title(event), Size: "Medium", Weight: "Bolder", Color: color, Wrap: true},
+					{Type: "FactSet", Facts: facts},
+					{Type: "TextBlock", Text: sink.formatter.Message(event), Wrap: true},
+				},
+				MSTeams: map[string]string{"width": "Full"},
+			},
+		}},
+	}
+}
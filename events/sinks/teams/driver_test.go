@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package teams
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/heapster/events/core"
+)
+
+type fakeWebhooks struct {
+	sync.Mutex
+	messages map[string][]message
+}
+
+func (this *fakeWebhooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	this.Lock()
+	defer this.Unlock()
+	this.messages[r.URL.Path] = append(this.messages[r.URL.Path], msg)
+	w.Write([]byte("1"))
+}
+
+func newEvent(eventType, reason string) *kube_api.Event {
+	return &kube_api.Event{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1." + reason},
+		InvolvedObject: kube_api.ObjectReference{
+			Kind:      "Pod",
+			Namespace: "default",
+			Name:      "web-1",
+		},
+		Type:          eventType,
+		Reason:        reason,
+		Message:       "Back-off restarting failed container",
+		Count:         3,
+		Source:        kube_api.EventSource{Component: "kubelet", Host: "node-1"},
+		LastTimestamp: metav1.NewTime(time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)),
+	}
+}
+
+func TestTeamsSink(t *testing.T) {
+	webhooks := &fakeWebhooks{messages: map[string][]message{}}
+	server := httptest.NewServer(webhooks)
+	defer server.Close()
+
+	uri, err := url.Parse("teams:?" + url.Values{
+		"webhook": {server.URL + "/default"},
+		"route":   {"BackOff,Failed=" + server.URL + "/crashes"},
+		"rate":    {"2"},
+		"cluster": {"prod"},
+	}.Encode())
+	require.NoError(t, err)
+	sink, err := NewTeamsSink(uri)
+	require.NoError(t, err)
+
+	sink.ExportEvents(&core.EventBatch{Events: []*kube_api.Event{
+		newEvent(kube_api.EventTypeWarning, "BackOff"),
+		newEvent(kube_api.EventTypeWarning, "Failed"),
+		// Over the rate limit of the crashes channel.
+		newEvent(kube_api.EventTypeWarning, "BackOff"),
+		newEvent(kube_api.EventTypeNormal, "Scheduled"),
+	}})
+
+	webhooks.Lock()
+	defer webhooks.Unlock()
+	assert.Len(t, webhooks.messages["/crashes"], 2)
+	require.Len(t, webhooks.messages["/default"], 1)
+
+	card := webhooks.messages["/crashes"][0].Attachments[0]
+	assert.Equal(t, adaptiveCardContentType, card.ContentType)
+	body := card.Content.Body
+	require.Len(t, body, 3)
+	assert.Equal(t, "[prod] Warning: BackOff", body[0].Text)
+	assert.Equal(t, "attention", body[0].Color)
+	assert.Contains(t, body[1].Facts, fact{Title: "Object", Value: "Pod/web-1"})
+	assert.Contains(t, body[1].Facts, fact{Title: "Last seen", Value: "2017-09-01T12:00:00Z"})
+	assert.Equal(t, "Back-off restarting failed container", body[2].Text)
+	assert.Equal(t, "good", webhooks.messages["/default"][0].Attachments[0].Content.Body[0].Color)
+}
+
+func TestTeamsSinkEventType(t *testing.T) {
+	webhooks := &fakeWebhooks{messages: map[string][]message{}}
+	server := httptest.NewServer(webhooks)
+	defer server.Close()
+
+	uri, err := url.Parse("teams:?" + url.Values{"webhook": {server.URL}, "eventType": {"Warning"}}.Encode())
+	require.NoError(t, err)
+	sink, err := NewTeamsSink(uri)
+	require.NoError(t, err)
+	sink.ExportEvents(&core.EventBatch{Events: []*kube_api.Event{
+		newEvent(kube_api.EventTypeNormal, "Scheduled"),
+		newEvent(kube_api.EventTypeWarning, "BackOff"),
+	}})
+
+	webhooks.Lock()
+	defer webhooks.Unlock()
+	require.Len(t, webhooks.messages["/"], 1)
+	assert.Equal(t, "Warning: BackOff", webhooks.messages["/"][0].Attachments[0].Content.Body[0].Text)
+}
+
+func TestTeamsSinkOptions(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"webhook=not-a-url",
+		"webhook=https://example.com/hook&rate=0",
+		"webhook=https://example.com/hook&eventType=Error",
+		"webhook=https://example.com/hook&route=BackOff",
+	} {
+		uri, err := url.Parse("teams:?" + query)
+		require.NoError(t, err)
+		_, err = NewTeamsSink(uri)
+		assert.Error(t, err, query)
+	}
+}
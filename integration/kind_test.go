@@ -0,0 +1,68 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/e2e"
+)
+
+var (
+	kindCluster = flag.String("kind_cluster", "heapster-e2e", "Name of the kind cluster created by TestKindPipeline")
+	kindImage   = flag.String("kind_image", "", "Node image of the kind cluster, the default image of kind if empty")
+	e2eSinks    flags.Uris
+)
+
+func init() {
+	flag.Var(&e2eSinks, "e2e_sink", "Sink(s) exported to by TestKindPipeline, in addition to its recording sink")
+}
+
+// TestKindPipeline runs the pipeline against a new kind cluster and checks the exported batches,
+// as `heapster e2e` does.
+func TestKindPipeline(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping kind integration test.")
+	}
+	if !e2e.KindAvailable() {
+		t.Skip("skipping kind integration test: the kind command is not installed.")
+	}
+	cluster, err := e2e.CreateKindCluster(*kindCluster, *kindImage, 2*time.Minute)
+	require.NoError(t, err)
+	defer cluster.Delete()
+
+	source, err := e2e.KubeletSource(cluster.Kubeconfig)
+	require.NoError(t, err)
+	harness, kubeClient, err := e2e.NewHarness(e2e.Options{
+		Sources:          flags.Uris{source},
+		Sinks:            e2eSinks,
+		Cycles:           e2e.DefaultCycles,
+		Resolution:       e2e.DefaultResolution,
+		MaxScrapeLatency: e2e.DefaultMaxScrapeLatency,
+		MaxExportLatency: e2e.DefaultMaxExportLatency,
+	})
+	require.NoError(t, err)
+	expected, err := e2e.ExpectationsFromCluster(kubeClient)
+	require.NoError(t, err)
+	require.NotEmpty(t, expected.Nodes)
+
+	report := harness.Run(expected)
+	t.Log(report)
+	require.True(t, report.Passed(), "%v", report.Failures)
+}
@@ -15,6 +15,8 @@
 package v1
 
 import (
+	"encoding/json"
+	"net/http"
 	"time"
 
 	restful "github.com/emicklei/go-restful"
@@ -22,7 +24,13 @@ import (
 
 	"k8s.io/heapster/metrics/api/v1/types"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/forecast"
+	"k8s.io/heapster/metrics/idle"
+	"k8s.io/heapster/metrics/manager"
+	"k8s.io/heapster/metrics/processors"
+	"k8s.io/heapster/metrics/recommender"
 	metricsink "k8s.io/heapster/metrics/sinks/metric"
+	"k8s.io/heapster/metrics/sources"
 )
 
 type Api struct {
@@ -32,6 +40,13 @@ type Api struct {
 	gkeMetrics          map[string]core.MetricDescriptor
 	gkeLabels           map[string]core.LabelDescriptor
 	disabled            bool
+	responseCache       *responseCache
+	recommender         *recommender.Recommender
+	idleDetector        *idle.Detector
+	forecaster          *forecast.Forecaster
+	validator           *processors.Validator
+	sourceManager       sources.SourceManager
+	boosts              *manager.ResolutionBoosts
 }
 
 var (
@@ -71,6 +86,16 @@ func NewApi(runningInKubernetes bool, metricSink *metricsink.MetricSink, histori
 	}
 }
 
+// EnableResponseCache makes the model API serve repeated queries from a cache of rendered
+// responses. Cached responses are kept for at most ttl and never outlive the data batch
+// they were rendered from.
+func (a *Api) EnableResponseCache(ttl time.Duration) {
+	if a.metricSink == nil || ttl <= 0 {
+		return
+	}
+	a.responseCache = newResponseCache(a.metricSink, ttl)
+}
+
 // Register the mainApi on the specified endpoint.
 func (a *Api) Register(container *restful.Container) {
 	ws := new(restful.WebService)
@@ -93,14 +118,41 @@ func (a *Api) Register(container *restful.Container) {
 		Operation("exportmetricsSchema").
 		Writes(types.TimeseriesSchema{}))
 	container.Add(ws)
+	a.RegisterMetadata(container)
+	a.RegisterDashboards(container)
 
 	if a.metricSink != nil {
 		a.RegisterModel(container)
 	}
+	a.RegisterOpenAPI(container)
 
 	if a.historicalSource != nil {
 		a.RegisterHistorical(container)
 	}
+
+	if a.recommender != nil {
+		a.RegisterRecommendations(container)
+	}
+
+	if a.idleDetector != nil {
+		a.RegisterIdleWorkloads(container)
+	}
+
+	if a.forecaster != nil {
+		a.RegisterForecasts(container)
+	}
+
+	if a.validator != nil {
+		a.RegisterQuarantine(container)
+	}
+
+	if a.sourceManager != nil {
+		a.RegisterSources(container)
+	}
+
+	if a.boosts != nil {
+		a.RegisterResolutionBoosts(container)
+	}
 }
 
 func convertLabelDescriptor(ld core.LabelDescriptor) types.LabelDescriptor {
@@ -189,10 +241,17 @@ func (a *Api) exportMetricsSchema(_ *restful.Request, response *restful.Response
 	response.WriteEntity(result)
 }
 
+// exportMetrics writes the latest batch with a Digest header (RFC 3230) holding the SHA-256
+// checksum of the body, so that clients can detect responses truncated or altered by proxies.
 func (a *Api) exportMetrics(_ *restful.Request, response *restful.Response) {
-	response.PrettyPrint(false)
-	err := response.WriteEntity(a.getMetricsResponse())
+	body, err := json.Marshal(a.getMetricsResponse())
 	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	response.AddHeader("Content-Type", restful.MIME_JSON)
+	response.AddHeader(types.DigestHeader, types.Digest(body))
+	if _, err := response.Write(body); err != nil {
 		glog.V(4).Infof("Error writing response: %v", err)
 	}
 }
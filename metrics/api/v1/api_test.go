@@ -15,16 +15,23 @@
 package v1
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	restful "github.com/emicklei/go-restful"
 	fuzz "github.com/google/gofuzz"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"k8s.io/heapster/metrics/api/v1/types"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/manager"
 	metricsink "k8s.io/heapster/metrics/sinks/metric"
+	"k8s.io/heapster/metrics/sources"
 )
 
 func TestApiFactory(t *testing.T) {
@@ -204,3 +211,226 @@ func TestRealInput(t *testing.T) {
 
 	}
 }
+
+func TestPodMetricsIncludePrevious(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	podBatch := func(timestamp time.Time, uid string, value int64) *core.DataBatch {
+		return &core.DataBatch{
+			Timestamp: timestamp,
+			MetricSets: map[string]*core.MetricSet{
+				core.PodKey("ns1", "pod1"): {
+					Labels: map[string]string{
+						core.LabelMetricSetType.Key: core.MetricSetTypePod,
+						core.LabelNamespaceName.Key: "ns1",
+						core.LabelPodName.Key:       "pod1",
+						core.LabelPodId.Key:         uid,
+					},
+					MetricValues: map[string]core.MetricValue{
+						core.MetricMemoryUsage.Name: {
+							IntValue:   value,
+							MetricType: core.MetricGauge,
+							ValueType:  core.ValueInt64,
+						},
+					},
+				},
+			},
+		}
+	}
+	metricSink := metricsink.NewMetricSink(time.Hour, time.Hour, []string{})
+	metricSink.ExportData(podBatch(now.Add(-2*time.Minute), "uid1", 10))
+	metricSink.ExportData(podBatch(now.Add(-time.Minute), "uid2", 20))
+	metricSink.ExportData(podBatch(now, "uid2", 30))
+
+	container := restful.NewContainer()
+	NewApi(true, metricSink, nil, false).RegisterModel(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	get := func(query string) types.MetricResult {
+		resp, err := http.Get(server.URL + "/api/v1/model/namespaces/ns1/pods/pod1/metrics/memory/usage" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var result types.MetricResult
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	result := get("")
+	assert.Equal(t, "uid2", result.UID)
+	assert.Len(t, result.Metrics, 2)
+	assert.Empty(t, result.Previous)
+
+	result = get("?includePrevious=true")
+	assert.Equal(t, "uid2", result.UID)
+	assert.Len(t, result.Metrics, 2)
+	require.Len(t, result.Previous, 1)
+	assert.Equal(t, "uid1", result.Previous[0].UID)
+	require.Len(t, result.Previous[0].Metrics, 1)
+	assert.Equal(t, uint64(10), result.Previous[0].Metrics[0].Value)
+
+	resp, err := http.Get(server.URL + "/api/v1/model/namespaces/ns1/pods/pod1/metrics/memory/usage?includePrevious=maybe")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestExportMetricsDigest(t *testing.T) {
+	metricSink := metricsink.NewMetricSink(time.Hour, time.Hour, []string{})
+	metricSink.ExportData(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					core.LabelNodename.Key:      "node1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricMemoryUsage.Name: {IntValue: 10, MetricType: core.MetricGauge, ValueType: core.ValueInt64},
+				},
+			},
+		},
+	})
+
+	container := restful.NewContainer()
+	NewApi(true, metricSink, nil, false).Register(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/metric-export")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, types.Digest(body), resp.Header.Get(types.DigestHeader))
+	var timeseries []*types.Timeseries
+	require.NoError(t, json.Unmarshal(body, &timeseries))
+	assert.Len(t, timeseries, 1)
+}
+
+func TestMetricMetadata(t *testing.T) {
+	container := restful.NewContainer()
+	NewApi(true, nil, nil, false).Register(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/metric-metadata/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var descriptors []types.MetricDescriptor
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&descriptors))
+	assert.Len(t, descriptors, len(core.MetricDescriptors()))
+	for i := 1; i < len(descriptors); i++ {
+		assert.True(t, descriptors[i-1].Name < descriptors[i].Name)
+	}
+
+	resp, err = http.Get(server.URL + "/api/v1/metric-metadata/memory/usage")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var descriptor types.MetricDescriptor
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&descriptor))
+	assert.Equal(t, types.MetricDescriptor{
+		Name:        "memory/usage",
+		Description: core.MetricMemoryUsage.Description,
+		Type:        "gauge",
+		ValueType:   "int64",
+		Units:       "bytes",
+	}, descriptor)
+
+	resp, err = http.Get(server.URL + "/api/v1/metric-metadata/custom/unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestResolutionBoosts(t *testing.T) {
+	container := restful.NewContainer()
+	api := NewApi(true, nil, nil, false)
+	api.EnableResolutionBoosts(manager.NewResolutionBoosts(time.Minute))
+	api.Register(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/v1/resolution/?namespace=ns1&interval=10s&duration=10m", "", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var boost types.ResolutionBoost
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&boost))
+	assert.Equal(t, "ns1", boost.Namespace)
+	assert.Equal(t, "10s", boost.Interval)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), boost.Expires, time.Minute)
+
+	for _, query := range []string{
+		"namespace=ns1&interval=10s",
+		"namespace=ns1&interval=7s&duration=10m",
+		"interval=10s&duration=10m",
+	} {
+		resp, err := http.Post(server.URL+"/api/v1/resolution/?"+query, "", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	resp, err = http.Get(server.URL + "/api/v1/resolution/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var boosts types.ResolutionBoostList
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&boosts))
+	assert.Equal(t, []types.ResolutionBoost{boost}, boosts.Items)
+}
+
+type fakeSourceManager struct {
+	statuses []sources.SourceStatus
+}
+
+func (this *fakeSourceManager) Name() string { return "fake" }
+
+func (this *fakeSourceManager) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	return nil, nil
+}
+
+func (this *fakeSourceManager) GetSourceStatuses() []sources.SourceStatus {
+	return this.statuses
+}
+
+func TestSourceStatuses(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	container := restful.NewContainer()
+	api := NewApi(true, nil, nil, false)
+	api.EnableSources(&fakeSourceManager{statuses: []sources.SourceStatus{
+		{Source: "kubelet:node1", LastScrapeTime: now, LastSuccessTime: now},
+		{Source: "kubelet:node2", LastScrapeTime: now, Error: "connection refused", LastError: "connection refused",
+			LastErrorTime: now, ConsecutiveFailures: 3},
+	}})
+	api.Register(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/sources/?failing=true")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var statuses types.SourceStatusList
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&statuses))
+	require.Len(t, statuses.Items, 1)
+	status := statuses.Items[0]
+	assert.Equal(t, "kubelet:node2", status.Source)
+	assert.Equal(t, 3, status.ConsecutiveFailures)
+	assert.Nil(t, status.LastSuccessTime)
+	require.NotNil(t, status.LastErrorTime)
+	assert.True(t, now.Equal(*status.LastErrorTime))
+
+	resp, err = http.Get(server.URL + "/api/v1/sources/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&statuses))
+	assert.Len(t, statuses.Items, 2)
+
+	resp, err = http.Get(server.URL + "/api/v1/sources/?failing=maybe")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,379 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is a typed client of the model and metric export APIs of Heapster.
+//
+//	c, err := client.New(client.Config{Host: "http://heapster.kube-system", MaxRetries: 3})
+//	...
+//	result, err := c.Metric(ctx, client.Pod("default", "web-1"), "cpu/usage_rate", nil)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+)
+
+const (
+	modelPath        = "/api/v1/model"
+	metricExportPath = "/api/v1/metric-export"
+
+	defaultRetryBackoff    = 500 * time.Millisecond
+	maxRetryBackoff        = 30 * time.Second
+	defaultPodListPageSize = 50
+)
+
+// Config holds the settings of a Client.
+type Config struct {
+	// URL of Heapster, e.g. http://heapster.kube-system. It may include a path prefix, e.g. to
+	// reach Heapster through the apiserver proxy.
+	Host string
+	// Client used to send requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Number of times a request failing with a network error or a 5xx or 429 response is
+	// retried. Zero disables retries.
+	MaxRetries int
+	// Delay before the first retry, doubled for each further one. Defaults to 500ms.
+	RetryBackoff time.Duration
+	// Maximum number of pods requested at once by PodListMetric. Defaults to 50.
+	PodListPageSize int
+}
+
+// Client reads metrics from the model and metric export APIs of Heapster. It is safe for
+// concurrent use.
+type Client struct {
+	// Base URL without a trailing slash.
+	host            string
+	httpClient      *http.Client
+	maxRetries      int
+	retryBackoff    time.Duration
+	podListPageSize int
+}
+
+// StatusError is returned for requests answered with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+	// Body of the response, usually the error message.
+	Message string
+}
+
+func (this *StatusError) Error() string {
+	return fmt.Sprintf("heapster returned %d %s: %s", this.StatusCode, http.StatusText(this.StatusCode), this.Message)
+}
+
+// IsNotFound returns true if err is a 404 returned by Heapster, e.g. for an unknown entity.
+func IsNotFound(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+func New(config Config) (*Client, error) {
+	host, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host %q: %v", config.Host, err)
+	}
+	if host.Scheme == "" || host.Host == "" {
+		return nil, fmt.Errorf("invalid host %q: scheme and host must be set", config.Host)
+	}
+	if config.MaxRetries < 0 {
+		return nil, fmt.Errorf("MaxRetries must not be negative")
+	}
+
+	c := &Client{
+		host:            strings.TrimSuffix(config.Host, "/"),
+		httpClient:      config.HTTPClient,
+		maxRetries:      config.MaxRetries,
+		retryBackoff:    config.RetryBackoff,
+		podListPageSize: config.PodListPageSize,
+	}
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	if c.retryBackoff <= 0 {
+		c.retryBackoff = defaultRetryBackoff
+	}
+	if c.podListPageSize <= 0 {
+		c.podListPageSize = defaultPodListPageSize
+	}
+	return c, nil
+}
+
+// Entity identifies an object of the model, e.g. a node or a pod.
+type Entity struct {
+	// Path of the entity below the model root.
+	path string
+}
+
+func (e Entity) String() string {
+	if e.path == "" {
+		return "cluster"
+	}
+	return strings.TrimPrefix(e.path, "/")
+}
+
+// Cluster is the whole cluster.
+func Cluster() Entity {
+	return Entity{}
+}
+
+func Node(node string) Entity {
+	return Entity{path: "/nodes/" + escape(node)}
+}
+
+func Namespace(namespace string) Entity {
+	return Entity{path: "/namespaces/" + escape(namespace)}
+}
+
+func Pod(namespace, pod string) Entity {
+	return Entity{path: Namespace(namespace).path + "/pods/" + escape(pod)}
+}
+
+func PodContainer(namespace, pod, container string) Entity {
+	return Entity{path: Pod(namespace, pod).path + "/containers/" + escape(container)}
+}
+
+// FreeContainer is a system container running on a node outside of any pod.
+func FreeContainer(node, container string) Entity {
+	return Entity{path: Node(node).path + "/freecontainers/" + escape(container)}
+}
+
+// MetricOptions narrow down the points returned for a metric.
+type MetricOptions struct {
+	// Time range of the points. Zero values leave the range open.
+	Start time.Time
+	End   time.Time
+	// Labels of the requested labeled metric, e.g. the resource_id of a filesystem.
+	Labels map[string]string
+	// Also return the metrics of earlier pods with the same name. Only supported for pods and
+	// pod containers.
+	IncludePrevious bool
+}
+
+func (o *MetricOptions) query() url.Values {
+	query := url.Values{}
+	if o == nil {
+		return query
+	}
+	if !o.Start.IsZero() {
+		query.Set("start", o.Start.UTC().Format(time.RFC3339))
+	}
+	if !o.End.IsZero() {
+		query.Set("end", o.End.UTC().Format(time.RFC3339))
+	}
+	if len(o.Labels) > 0 {
+		pairs := make([]string, 0, len(o.Labels))
+		for key, value := range o.Labels {
+			pairs = append(pairs, key+":"+value)
+		}
+		sort.Strings(pairs)
+		query.Set("labels", strings.Join(pairs, ","))
+	}
+	if o.IncludePrevious {
+		query.Set("includePrevious", "true")
+	}
+	return query
+}
+
+// Nodes returns the names of all nodes with some metrics.
+func (this *Client) Nodes(ctx context.Context) ([]string, error) {
+	return this.getNames(ctx, modelPath+"/nodes/")
+}
+
+// Namespaces returns the names of all namespaces with some metrics.
+func (this *Client) Namespaces(ctx context.Context) ([]string, error) {
+	return this.getNames(ctx, modelPath+"/namespaces/")
+}
+
+// Pods returns the names of all pods of the namespace with some metrics.
+func (this *Client) Pods(ctx context.Context, namespace string) ([]string, error) {
+	return this.getNames(ctx, modelPath+Namespace(namespace).path+"/pods/")
+}
+
+// PodContainers returns the names of all containers of the pod with some metrics.
+func (this *Client) PodContainers(ctx context.Context, namespace, pod string) ([]string, error) {
+	return this.getNames(ctx, modelPath+Pod(namespace, pod).path+"/containers")
+}
+
+// FreeContainers returns the names of all system containers of the node with some metrics.
+func (this *Client) FreeContainers(ctx context.Context, node string) ([]string, error) {
+	return this.getNames(ctx, modelPath+Node(node).path+"/freecontainers/")
+}
+
+// MetricNames returns the names of all metrics available for the entity.
+func (this *Client) MetricNames(ctx context.Context, entity Entity) ([]string, error) {
+	return this.getNames(ctx, modelPath+entity.path+"/metrics/")
+}
+
+// Metric returns the points of a metric of the entity. Options may be nil.
+func (this *Client) Metric(ctx context.Context, entity Entity, metric string, options *MetricOptions) (*types.MetricResult, error) {
+	result := &types.MetricResult{}
+	err := this.get(ctx, modelPath+entity.path+"/metrics/"+metric, options.query(), result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// PodListMetric returns the points of a metric of each of the pods, in the order of the pods.
+// Long lists are requested in pages, so the request URLs stay short.
+func (this *Client) PodListMetric(ctx context.Context, namespace string, pods []string, metric string, options *MetricOptions) ([]types.MetricResult, error) {
+	result := make([]types.MetricResult, 0, len(pods))
+	for start := 0; start < len(pods); start += this.podListPageSize {
+		end := start + this.podListPageSize
+		if end > len(pods) {
+			end = len(pods)
+		}
+		page := make([]string, 0, end-start)
+		for _, pod := range pods[start:end] {
+			page = append(page, escape(pod))
+		}
+		list := types.MetricResultList{}
+		path := modelPath + Namespace(namespace).path + "/pod-list/" + strings.Join(page, ",") + "/metrics/" + metric
+		if err := this.get(ctx, path, options.query(), &list); err != nil {
+			return nil, err
+		}
+		result = append(result, list.Items...)
+	}
+	return result, nil
+}
+
+// MetricPages calls f with the points of a metric of the entity in consecutive windows of the
+// given size from start to end, oldest first, so long time ranges are read in bounded
+// responses. Points at the boundary of two windows are only passed to the earlier one. It
+// stops at the first error returned by a request or by f.
+func (this *Client) MetricPages(ctx context.Context, entity Entity, metric string, start, end time.Time, window time.Duration,
+	options *MetricOptions, f func(*types.MetricResult) error) error {
+	if window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	pageOptions := MetricOptions{}
+	if options != nil {
+		pageOptions = *options
+	}
+	for pageStart := start; pageStart.Before(end); pageStart = pageStart.Add(window) {
+		pageOptions.Start = pageStart
+		pageOptions.End = pageStart.Add(window)
+		if pageOptions.End.After(end) {
+			pageOptions.End = end
+		}
+		result, err := this.Metric(ctx, entity, metric, &pageOptions)
+		if err != nil {
+			return err
+		}
+		if pageStart != start {
+			// The start of the range is inclusive, so drop the points of the previous window.
+			points := result.Metrics[:0]
+			for _, point := range result.Metrics {
+				if point.Timestamp.After(pageStart) {
+					points = append(points, point)
+				}
+			}
+			result.Metrics = points
+		}
+		if err := f(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportMetrics returns the latest point of all metrics of all nodes, pods and containers.
+func (this *Client) ExportMetrics(ctx context.Context) ([]*types.Timeseries, error) {
+	result := []*types.Timeseries{}
+	if err := this.get(ctx, metricExportPath, nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExportMetricsSchema returns the descriptors of the metrics and labels returned by
+// ExportMetrics.
+func (this *Client) ExportMetricsSchema(ctx context.Context) (*types.TimeseriesSchema, error) {
+	result := &types.TimeseriesSchema{}
+	if err := this.get(ctx, metricExportPath+"-schema", nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (this *Client) getNames(ctx context.Context, path string) ([]string, error) {
+	result := []string{}
+	if err := this.get(ctx, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// get decodes the JSON response to a GET of path into result, retrying failed requests.
+func (this *Client) get(ctx context.Context, path string, query url.Values, result interface{}) error {
+	// The path is escaped already.
+	u := this.host + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	backoff := this.retryBackoff
+	for attempt := 0; ; attempt++ {
+		retriable, err := this.do(ctx, u, result)
+		if err == nil || !retriable || attempt == this.maxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// do sends a single request. It returns whether a failed request may be retried.
+func (this *Client) do(ctx context.Context, u string, result interface{}) (bool, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := this.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		// Network errors are retried, unless the request was cancelled.
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		retriable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retriable, &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return false, fmt.Errorf("failed to decode response of %s: %v", u, err)
+	}
+	return false, nil
+}
+
+// escape escapes a name for use as a single path segment.
+func escape(name string) string {
+	return strings.Replace(url.QueryEscape(name), "+", "%20", -1)
+}
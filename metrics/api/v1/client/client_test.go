@@ -0,0 +1,173 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v1 "k8s.io/heapster/metrics/api/v1"
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/core"
+	metricsink "k8s.io/heapster/metrics/sinks/metric"
+)
+
+func newTestServer(t *testing.T, now time.Time) *httptest.Server {
+	metricSet := func(labels map[string]string, memory int64) *core.MetricSet {
+		return &core.MetricSet{
+			Labels: labels,
+			MetricValues: map[string]core.MetricValue{
+				core.MetricMemoryUsage.Name: {IntValue: memory, MetricType: core.MetricGauge, ValueType: core.ValueInt64},
+			},
+		}
+	}
+	podLabels := func(pod string) map[string]string {
+		return map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypePod,
+			core.LabelNamespaceName.Key: "default",
+			core.LabelPodName.Key:       pod,
+		}
+	}
+	metricSink := metricsink.NewMetricSink(time.Hour, time.Hour, []string{core.MetricMemoryUsage.Name})
+	for i := int64(0); i < 3; i++ {
+		metricSink.ExportData(&core.DataBatch{
+			Timestamp: now.Add(time.Duration(i-2) * time.Minute),
+			MetricSets: map[string]*core.MetricSet{
+				core.ClusterKey(): metricSet(map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeCluster}, 100+i),
+				core.NodeKey("node-1"): metricSet(map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					core.LabelNodename.Key:      "node-1",
+					core.LabelHostname.Key:      "node-1",
+				}, 50+i),
+				core.PodKey("default", "web 1"): metricSet(podLabels("web 1"), 10+i),
+				core.PodKey("default", "web-2"): metricSet(podLabels("web-2"), 20+i),
+			},
+		})
+	}
+
+	container := restful.NewContainer()
+	v1.NewApi(true, metricSink, nil, false).Register(container)
+	return httptest.NewServer(container)
+}
+
+func TestClient(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	server := newTestServer(t, now)
+	defer server.Close()
+	c, err := New(Config{Host: server.URL + "/", PodListPageSize: 1})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	nodes, err := c.Nodes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1"}, nodes)
+
+	pods, err := c.Pods(ctx, "default")
+	require.NoError(t, err)
+	sort.Strings(pods)
+	assert.Equal(t, []string{"web 1", "web-2"}, pods)
+
+	names, err := c.MetricNames(ctx, Pod("default", "web 1"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{core.MetricMemoryUsage.Name}, names)
+
+	result, err := c.Metric(ctx, Cluster(), core.MetricMemoryUsage.Name, nil)
+	require.NoError(t, err)
+	require.Len(t, result.Metrics, 3)
+	assert.Equal(t, uint64(102), result.Metrics[2].Value)
+	assert.True(t, now.Equal(result.LatestTimestamp))
+
+	result, err = c.Metric(ctx, Node("node-1"), core.MetricMemoryUsage.Name, &MetricOptions{Start: now.Add(-time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, result.Metrics, 2)
+	assert.Equal(t, uint64(51), result.Metrics[0].Value)
+
+	// Pages of a single pod are merged in the order of the pods.
+	results, err := c.PodListMetric(ctx, "default", []string{"web-2", "web 1"}, core.MetricMemoryUsage.Name, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, uint64(22), results[0].Metrics[2].Value)
+	assert.Equal(t, uint64(12), results[1].Metrics[2].Value)
+
+	var pages []types.MetricResult
+	err = c.MetricPages(ctx, Pod("default", "web-2"), core.MetricMemoryUsage.Name, now.Add(-2*time.Minute), now, time.Minute, nil,
+		func(page *types.MetricResult) error {
+			pages = append(pages, *page)
+			return nil
+		})
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+	assert.Len(t, pages[0].Metrics, 2)
+	// The point at the boundary is only returned by the first page.
+	require.Len(t, pages[1].Metrics, 1)
+	assert.Equal(t, uint64(22), pages[1].Metrics[0].Value)
+
+	schema, err := c.ExportMetricsSchema(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, schema.Metrics)
+}
+
+func TestClientRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`["node-1"]`))
+	}))
+	defer server.Close()
+
+	c, err := New(Config{Host: server.URL, MaxRetries: 1, RetryBackoff: time.Millisecond})
+	require.NoError(t, err)
+	_, err = c.Nodes(context.Background())
+	require.Error(t, err)
+	statusErr, ok := err.(*StatusError)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	assert.Equal(t, "overloaded", statusErr.Message)
+	assert.False(t, IsNotFound(err))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	nodes, err := c.Nodes(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1"}, nodes)
+
+	// Cancelled requests are not retried.
+	atomic.StoreInt32(&requests, 0)
+	c, err = New(Config{Host: server.URL, MaxRetries: 5, RetryBackoff: time.Hour})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Nodes(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestNewValidatesHost(t *testing.T) {
+	_, err := New(Config{Host: "heapster:8082"})
+	assert.Error(t, err)
+	_, err = New(Config{Host: "http://heapster", MaxRetries: -1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/util/dashboards"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+// RegisterDashboards registers the endpoints serving Grafana dashboards of the exported metrics.
+func (a *Api) RegisterDashboards(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/dashboards").
+		Doc("Grafana dashboards of the metrics exported to the sinks").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint lists the names of the dashboards.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("dashboardList", a.dashboardList)).
+		Doc("Get the names of the available dashboards").
+		Operation("dashboardList").
+		Writes([]string{}))
+
+	// The /{dashboard} endpoint returns a dashboard, ready to be posted to Grafana.
+	ws.Route(ws.GET("/{dashboard}").
+		To(metrics.InstrumentRouteFunc("dashboard", a.dashboard)).
+		Doc("Get a Grafana dashboard, as posted to the /api/dashboards/db endpoint of Grafana").
+		Operation("dashboard").
+		Param(ws.PathParameter("dashboard", "The name of the dashboard, e.g. cluster or pods").DataType("string")).
+		Param(ws.QueryParameter("cluster", "The name of the cluster, as in the cluster_name label. Empty for all clusters").DataType("string")).
+		Param(ws.QueryParameter("sink", "The sink queried by the dashboard, influxdb (default) or prometheus").DataType("string")).
+		Param(ws.QueryParameter("with_fields", "If true, query the measurements written by the InfluxDB sink with withfields=true").DataType("boolean")).
+		Param(ws.QueryParameter("datasource", "The name of the Grafana data source. Empty for the default data source").DataType("string")).
+		Writes(dashboards.Request{}))
+	container.Add(ws)
+}
+
+func (a *Api) dashboardList(request *restful.Request, response *restful.Response) {
+	response.WriteEntity(dashboards.Names())
+}
+
+func (a *Api) dashboard(request *restful.Request, response *restful.Response) {
+	options := dashboards.Options{
+		Cluster:    request.QueryParameter("cluster"),
+		Sink:       request.QueryParameter("sink"),
+		Datasource: request.QueryParameter("datasource"),
+	}
+	if options.Sink == "" {
+		options.Sink = dashboards.SinkInfluxdb
+	}
+	if param := request.QueryParameter("with_fields"); param != "" {
+		var err error
+		if options.WithFields, err = strconv.ParseBool(param); err != nil {
+			response.WriteError(http.StatusBadRequest, fmt.Errorf("invalid with_fields parameter %q: %v", param, err))
+			return
+		}
+	}
+	name := request.PathParameter("dashboard")
+	found := false
+	for _, known := range dashboards.Names() {
+		found = found || known == name
+	}
+	if !found {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("unknown dashboard %q", name))
+		return
+	}
+	dashboard, err := dashboards.Generate(name, options)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	response.WriteEntity(dashboard)
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+const ancestorsDoc = "Entities nested in others are looked up by the names of their ancestors, " +
+	"passed in query parameters named after the name labels of the ancestors' types"
+
+// addEntityRoutes adds the model routes of non-Kubernetes entities registered with
+// core.RegisterEntityType.
+func addEntityRoutes(a *Api, ws *restful.WebService) {
+	// The /entities/{entity-type}/ endpoint returns the names of all entities of a type.
+	ws.Route(ws.GET("/entities/{entity-type}/").
+		To(metrics.InstrumentRouteFunc("entityList", a.entityList)).
+		Doc("Get a list of all entities of a registered non-Kubernetes type with some metrics. " + ancestorsDoc).
+		Operation("entityList").
+		Param(ws.PathParameter("entity-type", "The type of the entities to lookup").DataType("string")).
+		Writes([]string{}))
+
+	// The /entities/{entity-type}/{entity-name}/metrics endpoint returns a list of all available
+	// metrics of an entity.
+	ws.Route(ws.GET("/entities/{entity-type}/{entity-name}/metrics").
+		To(metrics.InstrumentRouteFunc("availableEntityMetrics", a.availableEntityMetrics)).
+		Doc("Get a list of all available metrics of an entity. " + ancestorsDoc).
+		Operation("availableEntityMetrics").
+		Param(ws.PathParameter("entity-type", "The type of the entity to lookup").DataType("string")).
+		Param(ws.PathParameter("entity-name", "The name of the entity to lookup").DataType("string")).
+		Writes([]string{}))
+
+	// The /entities/{entity-type}/{entity-name}/metrics/{metric-name} endpoint exposes a metric
+	// of an entity.
+	ws.Route(ws.GET("/entities/{entity-type}/{entity-name}/metrics/{metric-name:*}").
+		To(metrics.InstrumentRouteFunc("entityMetrics", a.entityMetrics)).
+		Doc("Export a metric of an entity. " + ancestorsDoc).
+		Operation("entityMetrics").
+		Param(ws.PathParameter("entity-type", "The type of the entity to lookup").DataType("string")).
+		Param(ws.PathParameter("entity-name", "The name of the entity to lookup").DataType("string")).
+		Param(ws.PathParameter("metric-name", "The name of the requested metric").DataType("string")).
+		Param(ws.QueryParameter("start", "Start time for requested metrics").DataType("string")).
+		Param(ws.QueryParameter("end", "End time for requested metric").DataType("string")).
+		Param(ws.QueryParameter("labels", "A comma-separated list of key:values pairs to use to search for a labeled metric").DataType("string")).
+		Writes(types.MetricResult{}))
+}
+
+// entityAncestors returns the name labels of the ancestors of the requested entity type, as given
+// in the query parameters.
+func entityAncestors(request *restful.Request) (core.EntityType, map[string]string, error) {
+	metricSetType := request.PathParameter("entity-type")
+	entityType, found := core.GetEntityType(metricSetType)
+	if !found {
+		return core.EntityType{}, nil, fmt.Errorf("unknown entity type %q", metricSetType)
+	}
+	ancestors := map[string]string{}
+	for t := entityType.ParentType; t != ""; {
+		parent, _ := core.GetEntityType(t)
+		if name := request.QueryParameter(parent.NameLabel.Key); name != "" {
+			ancestors[parent.NameLabel.Key] = name
+		}
+		t = parent.ParentType
+	}
+	return entityType, ancestors, nil
+}
+
+// entityKey returns the key of the requested entity.
+func entityKey(request *restful.Request) (string, error) {
+	entityType, labels, err := entityAncestors(request)
+	if err != nil {
+		return "", err
+	}
+	labels[entityType.NameLabel.Key] = request.PathParameter("entity-name")
+	return core.EntityKey(entityType.MetricSetType, labels)
+}
+
+func (a *Api) entityList(request *restful.Request, response *restful.Response) {
+	entityType, ancestors, err := entityAncestors(request)
+	if err != nil {
+		response.WriteError(http.StatusNotFound, err)
+		return
+	}
+	response.WriteEntity(a.metricSink.GetEntities(entityType.MetricSetType, ancestors))
+}
+
+func (a *Api) availableEntityMetrics(request *restful.Request, response *restful.Response) {
+	key, err := entityKey(request)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	a.processMetricNamesRequest(key, response)
+}
+
+func (a *Api) entityMetrics(request *restful.Request, response *restful.Response) {
+	key, err := entityKey(request)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	a.processMetricRequest(key, request, response)
+}
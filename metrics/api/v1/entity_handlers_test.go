@@ -0,0 +1,96 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/core"
+	metricsink "k8s.io/heapster/metrics/sinks/metric"
+)
+
+func TestEntityMetrics(t *testing.T) {
+	require.NoError(t, core.RegisterEntityType(core.EntityType{
+		MetricSetType: "docker_host",
+		NameLabel:     core.LabelDescriptor{Key: "docker_host"},
+	}))
+	require.NoError(t, core.RegisterEntityType(core.EntityType{
+		MetricSetType: "docker_container",
+		NameLabel:     core.LabelDescriptor{Key: "docker_container"},
+		ParentType:    "docker_host",
+	}))
+
+	containerSet := func(host, container string, memory int64) *core.MetricSet {
+		return &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: "docker_container",
+				"docker_host":               host,
+				"docker_container":          container,
+			},
+			MetricValues: map[string]core.MetricValue{
+				core.MetricMemoryUsage.Name: {IntValue: memory, MetricType: core.MetricGauge, ValueType: core.ValueInt64},
+			},
+		}
+	}
+	metricSink := metricsink.NewMetricSink(time.Hour, time.Hour, []string{})
+	metricSink.ExportData(&core.DataBatch{
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+		MetricSets: map[string]*core.MetricSet{
+			"docker_host:h1/docker_container:web": containerSet("h1", "web", 10),
+			"docker_host:h1/docker_container:db":  containerSet("h1", "db", 20),
+			"docker_host:h2/docker_container:web": containerSet("h2", "web", 30),
+		},
+	})
+
+	container := restful.NewContainer()
+	NewApi(false, metricSink, nil, false).RegisterModel(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	get := func(path string, result interface{}) int {
+		resp, err := http.Get(server.URL + "/api/v1/model/entities/" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(result))
+		}
+		return resp.StatusCode
+	}
+
+	var names []string
+	assert.Equal(t, http.StatusOK, get("docker_container/?docker_host=h1", &names))
+	sort.Strings(names)
+	assert.Equal(t, []string{"db", "web"}, names)
+	assert.Equal(t, http.StatusOK, get("docker_container/", &names))
+	assert.Len(t, names, 3)
+
+	var result types.MetricResult
+	assert.Equal(t, http.StatusOK, get("docker_container/web/metrics/memory/usage?docker_host=h2", &result))
+	require.Len(t, result.Metrics, 1)
+	assert.Equal(t, uint64(30), result.Metrics[0].Value)
+
+	assert.Equal(t, http.StatusBadRequest, get("docker_container/web/metrics/memory/usage", &result))
+	assert.Equal(t, http.StatusNotFound, get("unknown/", &names))
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/forecast"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+const defaultForecastDays = 7
+
+// EnableForecasts makes the Api serve the usage forecasts of forecaster.
+func (a *Api) EnableForecasts(forecaster *forecast.Forecaster) {
+	a.forecaster = forecaster
+}
+
+// RegisterForecasts registers the endpoint serving usage forecasts.
+func (a *Api) RegisterForecasts(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/forecast").
+		Doc("CPU and memory usage forecasts for capacity planning").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint returns the forecasts of the cluster and all node pools.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("forecast", a.forecast)).
+		Doc("Get the projected cpu and memory usage of the cluster and its node pools").
+		Operation("forecast").
+		Param(ws.QueryParameter("days", fmt.Sprintf("Number of days to project the usage ahead (default: %d)", defaultForecastDays)).DataType("integer")).
+		Writes(types.ForecastList{}))
+	container.Add(ws)
+}
+
+func (a *Api) forecast(request *restful.Request, response *restful.Response) {
+	days := defaultForecastDays
+	if param := request.QueryParameter("days"); param != "" {
+		var err error
+		days, err = strconv.Atoi(param)
+		if err != nil || days < 0 {
+			response.WriteError(http.StatusBadRequest, fmt.Errorf("days must be a non-negative integer: %q", param))
+			return
+		}
+	}
+
+	forecasts, err := a.forecaster.GetForecasts(time.Duration(days) * 24 * time.Hour)
+	if err != nil {
+		response.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	result := types.ForecastList{
+		Days:  days,
+		Items: make([]types.Forecast, 0, len(forecasts)),
+	}
+	for _, f := range forecasts {
+		result.Items = append(result.Items, types.Forecast{
+			NodePool: f.NodePool,
+			Samples:  f.Samples,
+			Cpu:      types.ResourceForecast(f.Cpu),
+			Memory:   types.ResourceForecast(f.Memory),
+		})
+	}
+	response.WriteEntity(result)
+}
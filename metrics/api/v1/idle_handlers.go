@@ -0,0 +1,65 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/idle"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+// EnableIdleWorkloads makes the Api serve the idle workloads found by detector.
+func (a *Api) EnableIdleWorkloads(detector *idle.Detector) {
+	a.idleDetector = detector
+}
+
+// RegisterIdleWorkloads registers the endpoint listing idle workloads.
+func (a *Api) RegisterIdleWorkloads(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/idle-workloads").
+		Doc("Workloads whose resource usage stayed below the idle thresholds").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint returns all idle workloads.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("idleWorkloads", a.idleWorkloads)).
+		Doc("Get the workloads whose cpu and network usage stayed below the idle thresholds").
+		Operation("idleWorkloads").
+		Param(ws.QueryParameter("namespace", "Only return workloads in this namespace").DataType("string")).
+		Writes(types.IdleWorkloadList{}))
+	container.Add(ws)
+}
+
+func (a *Api) idleWorkloads(request *restful.Request, response *restful.Response) {
+	workloads := a.idleDetector.GetIdleWorkloads(request.QueryParameter("namespace"))
+	result := types.IdleWorkloadList{
+		Items: make([]types.IdleWorkload, 0, len(workloads)),
+	}
+	for _, w := range workloads {
+		result.Items = append(result.Items, types.IdleWorkload{
+			Namespace:           w.Namespace,
+			Kind:                w.Kind,
+			Name:                w.Name,
+			Pods:                w.Pods,
+			ObservedSince:       w.ObservedSince,
+			MaxCpuUsageRate:     w.MaxCpuUsageRate,
+			MaxNetworkUsageRate: w.MaxNetworkUsageRate,
+		})
+	}
+	response.WriteEntity(result)
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+// RegisterMetadata registers the endpoints describing the metrics, so that dashboards can
+// document the metrics they display.
+func (a *Api) RegisterMetadata(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/metric-metadata").
+		Doc("Description, type and units of the metrics exported by Heapster").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint returns the metadata of all metrics.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("allMetricMetadata", a.allMetricMetadata)).
+		Doc("Get the metadata of all metrics, sorted by name").
+		Operation("allMetricMetadata").
+		Writes([]types.MetricDescriptor{}))
+
+	// The /{metric-name} endpoint returns the metadata of a single metric.
+	ws.Route(ws.GET("/{metric-name:*}").
+		To(metrics.InstrumentRouteFunc("metricMetadata", a.metricMetadata)).
+		Doc("Get the metadata of the given metric").
+		Operation("metricMetadata").
+		Param(ws.PathParameter("metric-name", "The name of the requested metric, e.g. cpu/usage").DataType("string")).
+		Writes(types.MetricDescriptor{}))
+	container.Add(ws)
+}
+
+func (a *Api) allMetricMetadata(request *restful.Request, response *restful.Response) {
+	descriptors := core.MetricDescriptors()
+	result := make([]types.MetricDescriptor, 0, len(descriptors))
+	for _, descriptor := range descriptors {
+		result = append(result, convertMetricDescriptor(descriptor))
+	}
+	response.WriteEntity(result)
+}
+
+func (a *Api) metricMetadata(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("metric-name")
+	descriptor, found := core.MetricDescriptorForName(name)
+	if !found {
+		response.WriteError(http.StatusNotFound, fmt.Errorf("unknown metric %q", name))
+		return
+	}
+	response.WriteEntity(convertMetricDescriptor(descriptor))
+}
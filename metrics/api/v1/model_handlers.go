@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 
 	"k8s.io/heapster/metrics/api/v1/types"
 	"k8s.io/heapster/metrics/core"
+	metricsink "k8s.io/heapster/metrics/sinks/metric"
 	"k8s.io/heapster/metrics/util/metrics"
 )
 
@@ -81,7 +83,8 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 	ws.Route(ws.GET("/metrics/").
 		To(metrics.InstrumentRouteFunc("availableClusterMetrics", a.availableClusterMetrics)).
 		Doc("Get a list of all available metrics for the Cluster entity").
-		Operation("availableClusterMetrics"))
+		Operation("availableClusterMetrics").
+		Writes([]string{}))
 
 	// The /metrics/{metric-name} endpoint exposes an aggregated metric for the Cluster entity of the model.
 	ws.Route(ws.GET("/metrics/{metric-name:*}").
@@ -98,14 +101,16 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 	ws.Route(ws.GET("/nodes/").
 		To(metrics.InstrumentRouteFunc("nodeList", a.nodeList)).
 		Doc("Get a list of all nodes that have some current metrics").
-		Operation("nodeList"))
+		Operation("nodeList").
+		Writes([]string{}))
 
 	// The /nodes/{node-name}/metrics endpoint returns a list of all available metrics for a Node entity.
 	ws.Route(ws.GET("/nodes/{node-name}/metrics/").
 		To(metrics.InstrumentRouteFunc("availableNodeMetrics", a.availableNodeMetrics)).
 		Doc("Get a list of all available metrics for a Node entity").
 		Operation("availableNodeMetrics").
-		Param(ws.PathParameter("node-name", "The name of the node to lookup").DataType("string")))
+		Param(ws.PathParameter("node-name", "The name of the node to lookup").DataType("string")).
+		Writes([]string{}))
 
 	// The /nodes/{node-name}/metrics/{metric-name} endpoint exposes a metric for a Node entity of the model.
 	// The {node-name} parameter is the hostname of a specific node.
@@ -125,14 +130,16 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 		ws.Route(ws.GET("/namespaces/").
 			To(metrics.InstrumentRouteFunc("namespaceList", a.namespaceList)).
 			Doc("Get a list of all namespaces that have some current metrics").
-			Operation("namespaceList"))
+			Operation("namespaceList").
+			Writes([]string{}))
 
 		// The /namespaces/{namespace-name}/metrics endpoint returns a list of all available metrics for a Namespace entity.
 		ws.Route(ws.GET("/namespaces/{namespace-name}/metrics").
 			To(metrics.InstrumentRouteFunc("availableNamespaceMetrics", a.availableNamespaceMetrics)).
 			Doc("Get a list of all available metrics for a Namespace entity").
 			Operation("availableNamespaceMetrics").
-			Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")))
+			Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
+			Writes([]string{}))
 
 		// The /namespaces/{namespace-name}/metrics/{metric-name} endpoint exposes an aggregated metrics
 		// for a Namespace entity of the model.
@@ -151,7 +158,8 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 			To(metrics.InstrumentRouteFunc("namespacePodList", a.namespacePodList)).
 			Doc("Get a list of pods from the given namespace that have some metrics").
 			Operation("namespacePodList").
-			Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")))
+			Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
+			Writes([]string{}))
 
 		// The /namespaces/{namespace-name}/pods/{pod-name}/metrics endpoint returns a list of all available metrics for a Pod entity.
 		ws.Route(ws.GET("/namespaces/{namespace-name}/pods/{pod-name}/metrics").
@@ -159,7 +167,8 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 			Doc("Get a list of all available metrics for a Pod entity").
 			Operation("availablePodMetrics").
 			Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
-			Param(ws.PathParameter("pod-name", "The name of the pod to lookup").DataType("string")))
+			Param(ws.PathParameter("pod-name", "The name of the pod to lookup").DataType("string")).
+			Writes([]string{}))
 
 		// The /namespaces/{namespace-name}/pods/{pod-name}/metrics/{metric-name} endpoint exposes
 		// an aggregated metric for a Pod entity of the model.
@@ -173,6 +182,7 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 			Param(ws.QueryParameter("start", "Start time for requested metrics").DataType("string")).
 			Param(ws.QueryParameter("end", "End time for requested metric").DataType("string")).
 			Param(ws.QueryParameter("labels", "A comma-separated list of key:values pairs to use to search for a labeled metric").DataType("string")).
+			Param(ws.QueryParameter("includePrevious", "Also return metrics of earlier pods with the same name").DataType("boolean")).
 			Writes(types.MetricResult{}))
 
 		// The /namespaces/{namespace-name}/pods/{pod-name}/containers endpoint
@@ -182,7 +192,8 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 			Doc("Get a list of containers for a Pod entity ").
 			Operation("podContainerList").
 			Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
-			Param(ws.PathParameter("pod-name", "The name of the pod to lookup").DataType("string")))
+			Param(ws.PathParameter("pod-name", "The name of the pod to lookup").DataType("string")).
+			Writes([]string{}))
 
 		// The /namespaces/{namespace-name}/pods/{pod-name}/containers/metrics/{container-name}/metrics endpoint
 		// returns a list of all available metrics for a Pod Container entity.
@@ -192,7 +203,8 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 			Operation("availableContainerMetrics").
 			Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
 			Param(ws.PathParameter("pod-name", "The name of the pod to lookup").DataType("string")).
-			Param(ws.PathParameter("container-name", "The name of the namespace to use").DataType("string")))
+			Param(ws.PathParameter("container-name", "The name of the namespace to use").DataType("string")).
+			Writes([]string{}))
 
 		// The /namespaces/{namespace-name}/pods/{pod-name}/containers/{container-name}/metrics/{metric-name} endpoint exposes
 		// a metric for a Container entity of the model.
@@ -207,6 +219,7 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 			Param(ws.QueryParameter("start", "Start time for requested metrics").DataType("string")).
 			Param(ws.QueryParameter("end", "End time for requested metric").DataType("string")).
 			Param(ws.QueryParameter("labels", "A comma-separated list of key:values pairs to use to search for a labeled metric").DataType("string")).
+			Param(ws.QueryParameter("includePrevious", "Also return metrics of earlier pods with the same name").DataType("boolean")).
 			Writes(types.MetricResult{}))
 	}
 
@@ -214,7 +227,8 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 		To(metrics.InstrumentRouteFunc("systemContainerList", a.nodeSystemContainerList)).
 		Doc("Get a list of all non-pod containers with some metrics").
 		Operation("systemContainerList").
-		Param(ws.PathParameter("node-name", "The name of the namespace to lookup").DataType("string")))
+		Param(ws.PathParameter("node-name", "The name of the namespace to lookup").DataType("string")).
+		Writes([]string{}))
 
 	// The /nodes/{node-name}/freecontainers/{container-name}/metrics endpoint
 	// returns a list of all available metrics for a Free Container entity.
@@ -223,7 +237,8 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 		Doc("Get a list of all available metrics for a free Container entity").
 		Operation("availableMetrics").
 		Param(ws.PathParameter("node-name", "The name of the namespace to lookup").DataType("string")).
-		Param(ws.PathParameter("container-name", "The name of the namespace to use").DataType("string")))
+		Param(ws.PathParameter("container-name", "The name of the namespace to use").DataType("string")).
+		Writes([]string{}))
 
 	// The /nodes/{node-name}/freecontainers/{container-name}/metrics/{metric-name} endpoint exposes
 	// a metric for a free Container entity of the model.
@@ -256,6 +271,19 @@ func addClusterMetricsRoutes(a clusterMetricsFetcher, ws *restful.WebService) {
 	}
 }
 
+// podKey returns the key under which the metrics of the given pod are stored. It depends on the
+// configured core.PodKeyFunc, so it has to be looked up in the metric sink.
+func (a *Api) podKey(namespace, podName string) string {
+	if a.metricSink == nil {
+		return core.PodKey(namespace, podName)
+	}
+	return a.metricSink.GetPodKey(namespace, podName)
+}
+
+func (a *Api) podContainerKey(namespace, podName, containerName string) string {
+	return core.ContainerKeyForPod(a.podKey(namespace, podName), containerName)
+}
+
 func (a *Api) isRunningInKubernetes() bool {
 	return a.runningInKubernetes
 }
@@ -269,13 +297,18 @@ func (a *Api) RegisterModel(container *restful.Container) {
 		Doc("Root endpoint of the stats model").
 		Consumes("*/*").
 		Produces(restful.MIME_JSON)
+	if a.responseCache != nil {
+		ws.Filter(a.responseCache.filter)
+	}
 
 	addClusterMetricsRoutes(a, ws)
+	addEntityRoutes(a, ws)
 
 	ws.Route(ws.GET("/debug/allkeys").
 		To(metrics.InstrumentRouteFunc("debugAllKeys", a.allKeys)).
 		Doc("Get keys of all metric sets available").
-		Operation("debugAllKeys"))
+		Operation("debugAllKeys").
+		Writes([]string{}))
 	container.Add(ws)
 }
 
@@ -297,14 +330,14 @@ func (a *Api) availableNamespaceMetrics(request *restful.Request, response *rest
 // availableMetrics returns a list of available pod metric names.
 func (a *Api) availablePodMetrics(request *restful.Request, response *restful.Response) {
 	a.processMetricNamesRequest(
-		core.PodKey(request.PathParameter("namespace-name"),
+		a.podKey(request.PathParameter("namespace-name"),
 			request.PathParameter("pod-name")), response)
 }
 
 // availableMetrics returns a list of available pod metric names.
 func (a *Api) availablePodContainerMetrics(request *restful.Request, response *restful.Response) {
 	a.processMetricNamesRequest(
-		core.PodContainerKey(request.PathParameter("namespace-name"),
+		a.podContainerKey(request.PathParameter("namespace-name"),
 			request.PathParameter("pod-name"),
 			request.PathParameter("container-name"),
 		), response)
@@ -361,10 +394,8 @@ func (a *Api) namespaceMetrics(request *restful.Request, response *restful.Respo
 
 // podMetrics returns a metric timeseries for a metric of the Pod entity.
 func (a *Api) podMetrics(request *restful.Request, response *restful.Response) {
-	a.processMetricRequest(
-		core.PodKey(request.PathParameter("namespace-name"),
-			request.PathParameter("pod-name")),
-		request, response)
+	a.processPodMetricRequest(request.PathParameter("namespace-name"),
+		request.PathParameter("pod-name"), "", request, response)
 }
 
 func (a *Api) podListMetrics(request *restful.Request, response *restful.Response) {
@@ -378,7 +409,7 @@ func (a *Api) podListMetrics(request *restful.Request, response *restful.Respons
 	metricName := request.PathParameter("metric-name")
 	convertedMetricName := convertMetricName(metricName)
 	for _, podName := range strings.Split(request.PathParameter("pod-list"), ",") {
-		keys = append(keys, core.PodKey(ns, podName))
+		keys = append(keys, a.podKey(ns, podName))
 	}
 
 	labels, err := getLabels(request)
@@ -407,11 +438,9 @@ func (a *Api) podListMetrics(request *restful.Request, response *restful.Respons
 // podContainerMetrics returns a metric timeseries for a metric of a Pod Container entity.
 // podContainerMetrics uses the namespace-name/pod-name/container-name path.
 func (a *Api) podContainerMetrics(request *restful.Request, response *restful.Response) {
-	a.processMetricRequest(
-		core.PodContainerKey(request.PathParameter("namespace-name"),
-			request.PathParameter("pod-name"),
-			request.PathParameter("container-name"),
-		),
+	a.processPodMetricRequest(request.PathParameter("namespace-name"),
+		request.PathParameter("pod-name"),
+		request.PathParameter("container-name"),
 		request, response)
 }
 
@@ -451,14 +480,81 @@ func (a *Api) processMetricRequest(key string, request *restful.Request, respons
 		return
 	}
 
+	converted := exportTimestampedMetricValue(a.getMetricValues(key, convertedMetricName, labels, start, end))
+	response.WriteEntity(converted)
+}
+
+// processPodMetricRequest returns the metrics of the latest incarnation of a pod, or of one of its
+// containers if containerName is not empty. A pod that was recreated with the same name is treated
+// as a new series; the metrics of the earlier incarnations are returned only with includePrevious=true.
+func (a *Api) processPodMetricRequest(namespace, podName, containerName string, request *restful.Request, response *restful.Response) {
+	start, end, err := getStartEndTime(request)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	metricName := request.PathParameter("metric-name")
+	convertedMetricName := convertMetricName(metricName)
+	labels, err := getLabels(request)
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	includePrevious := false
+	if param := request.QueryParameter("includePrevious"); param != "" {
+		includePrevious, err = strconv.ParseBool(param)
+		if err != nil {
+			response.WriteError(http.StatusBadRequest, fmt.Errorf("includePrevious argument cannot be parsed: %s", err))
+			return
+		}
+	}
+
+	incarnations := a.metricSink.GetPodIncarnations(namespace, podName)
+	if len(incarnations) == 0 {
+		key := core.PodKey(namespace, podName)
+		if containerName != "" {
+			key = core.PodContainerKey(namespace, podName, containerName)
+		}
+		response.WriteEntity(exportTimestampedMetricValue(a.getMetricValues(key, convertedMetricName, labels, start, end)))
+		return
+	}
+
+	incarnationResult := func(incarnation metricsink.PodIncarnation) types.MetricResult {
+		key := incarnation.Key
+		if containerName != "" {
+			key = core.ContainerKeyForPod(key, containerName)
+		}
+		// With name based keys all incarnations share the same key, so the values have
+		// to be split by the time during which each incarnation existed.
+		incarnationStart, incarnationEnd := start, end
+		if incarnationStart.Before(incarnation.FirstSeen) {
+			incarnationStart = incarnation.FirstSeen
+		}
+		if incarnationEnd.After(incarnation.LastSeen) {
+			incarnationEnd = incarnation.LastSeen
+		}
+		result := exportTimestampedMetricValue(a.getMetricValues(key, convertedMetricName, labels, incarnationStart, incarnationEnd))
+		result.UID = incarnation.UID
+		return result
+	}
+
+	result := incarnationResult(incarnations[len(incarnations)-1])
+	if includePrevious {
+		for _, incarnation := range incarnations[:len(incarnations)-1] {
+			result.Previous = append(result.Previous, incarnationResult(incarnation))
+		}
+	}
+	response.WriteEntity(result)
+}
+
+func (a *Api) getMetricValues(key, metricName string, labels map[string]string, start, end time.Time) []core.TimestampedMetricValue {
 	var metrics map[string][]core.TimestampedMetricValue
 	if labels != nil {
-		metrics = a.metricSink.GetLabeledMetric(convertedMetricName, labels, []string{key}, start, end)
+		metrics = a.metricSink.GetLabeledMetric(metricName, labels, []string{key}, start, end)
 	} else {
-		metrics = a.metricSink.GetMetric(convertedMetricName, []string{key}, start, end)
+		metrics = a.metricSink.GetMetric(metricName, []string{key}, start, end)
 	}
-	converted := exportTimestampedMetricValue(metrics[key])
-	response.WriteEntity(converted)
+	return metrics[key]
 }
 
 func (a *Api) processMetricNamesRequest(key string, response *restful.Response) {
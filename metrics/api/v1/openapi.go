@@ -0,0 +1,234 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/go-openapi/spec"
+
+	"k8s.io/heapster/metrics/util/metrics"
+	"k8s.io/heapster/version"
+)
+
+const openAPIPath = "/openapi/v2"
+
+// Root paths of the web services described by the OpenAPI document.
+var openAPIRootPaths = []string{"/api/v1/model", "/api/v1/metric-export", "/api/v1/metric-metadata"}
+
+// Matches the regular expressions of path parameters, e.g. ":*" in "{metric-name:*}".
+var pathParamExpression = regexp.MustCompile(`\{([^}:]+):[^}]*\}`)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// openAPIHandler serves an OpenAPI v2 document describing the model and metric export APIs.
+// The document is built from the routes registered in the container on the first request,
+// when all web services are in place.
+type openAPIHandler struct {
+	container *restful.Container
+	once      sync.Once
+	swagger   *spec.Swagger
+}
+
+// RegisterOpenAPI registers the endpoint serving the OpenAPI document of the model API.
+func (a *Api) RegisterOpenAPI(container *restful.Container) {
+	handler := &openAPIHandler{container: container}
+	ws := new(restful.WebService)
+	ws.Path(openAPIPath).
+		Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("").
+		To(metrics.InstrumentRouteFunc("openAPI", handler.serve)).
+		Doc("Get the OpenAPI v2 document describing the model and metric export APIs").
+		Operation("openAPI"))
+	container.Add(ws)
+}
+
+func (this *openAPIHandler) serve(request *restful.Request, response *restful.Response) {
+	this.once.Do(func() {
+		this.swagger = buildOpenAPI(this.container.RegisteredWebServices())
+	})
+	response.WriteHeaderAndJson(http.StatusOK, this.swagger, restful.MIME_JSON)
+}
+
+// buildOpenAPI describes all routes of the given web services below openAPIRootPaths. Types
+// written by the routes are described by schemas derived from their JSON encoding.
+func buildOpenAPI(webServices []*restful.WebService) *spec.Swagger {
+	definitions := spec.Definitions{}
+	paths := &spec.Paths{Paths: map[string]spec.PathItem{}}
+	for _, ws := range webServices {
+		if !describedByOpenAPI(ws.RootPath()) {
+			continue
+		}
+		for _, route := range ws.Routes() {
+			path := pathParamExpression.ReplaceAllString(route.Path, "{$1}")
+			item := paths.Paths[path]
+			operation := buildOperation(route, definitions)
+			switch route.Method {
+			case "GET":
+				item.Get = operation
+			case "POST":
+				item.Post = operation
+			case "PUT":
+				item.Put = operation
+			case "DELETE":
+				item.Delete = operation
+			default:
+				continue
+			}
+			paths.Paths[path] = item
+		}
+	}
+
+	return &spec.Swagger{
+		SwaggerProps: spec.SwaggerProps{
+			Swagger:  "2.0",
+			Consumes: []string{restful.MIME_JSON},
+			Produces: []string{restful.MIME_JSON},
+			Info: &spec.Info{
+				InfoProps: spec.InfoProps{
+					Title:       "Heapster",
+					Description: "Model and metric export APIs of Heapster",
+					Version:     version.HeapsterVersion,
+				},
+			},
+			Paths:       paths,
+			Definitions: definitions,
+		},
+	}
+}
+
+func describedByOpenAPI(rootPath string) bool {
+	for _, path := range openAPIRootPaths {
+		if rootPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+func buildOperation(route restful.Route, definitions spec.Definitions) *spec.Operation {
+	operation := spec.NewOperation(route.Operation)
+	operation.Description = route.Doc
+	operation.Produces = route.Produces
+	for _, param := range route.ParameterDocs {
+		data := param.Data()
+		var p *spec.Parameter
+		switch data.Kind {
+		case restful.PathParameterKind:
+			p = spec.PathParam(data.Name)
+		case restful.QueryParameterKind:
+			p = spec.QueryParam(data.Name)
+		default:
+			continue
+		}
+		p.Description = data.Description
+		p.Type = data.DataType
+		if p.Type == "" {
+			p.Type = "string"
+		}
+		operation.AddParam(p)
+	}
+
+	response := spec.NewResponse().WithDescription("OK")
+	if route.WriteSample != nil {
+		response.Schema = schemaOf(reflect.TypeOf(route.WriteSample), definitions)
+	}
+	operation.RespondsWith(http.StatusOK, response)
+	return operation
+}
+
+// schemaOf returns the schema of the JSON encoding of values of type t. Structs are added to
+// definitions and referenced by the name of their package and type, e.g. types.MetricPoint.
+func schemaOf(t reflect.Type, definitions spec.Definitions) *spec.Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return spec.DateTimeProperty()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return spec.BoolProperty()
+	case reflect.String:
+		return spec.StringProperty()
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return spec.Int32Property()
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return spec.Int64Property()
+	case reflect.Float32:
+		return spec.Float32Property()
+	case reflect.Float64:
+		return spec.Float64Property()
+	case reflect.Slice, reflect.Array:
+		return spec.ArrayProperty(schemaOf(t.Elem(), definitions))
+	case reflect.Map:
+		return spec.MapProperty(schemaOf(t.Elem(), definitions))
+	case reflect.Struct:
+		name := definitionName(t)
+		if _, found := definitions[name]; !found {
+			// Added before the fields are described, so recursive types terminate.
+			definitions[name] = spec.Schema{}
+			definitions[name] = structSchema(t, definitions)
+		}
+		return spec.RefSchema("#/definitions/" + name)
+	}
+	// Interfaces may hold any value.
+	return &spec.Schema{}
+}
+
+func structSchema(t reflect.Type, definitions spec.Definitions) spec.Schema {
+	schema := spec.Schema{}
+	schema.Typed("object", "")
+	schema.Properties = map[string]spec.Schema{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		name := field.Name
+		optional := false
+		if tag := field.Tag.Get("json"); tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				if option == "omitempty" {
+					optional = true
+				}
+			}
+		}
+		schema.Properties[name] = *schemaOf(field.Type, definitions)
+		if !optional {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+func definitionName(t reflect.Type) string {
+	path := strings.Split(t.PkgPath(), "/")
+	return path[len(path)-1] + "." + t.Name()
+}
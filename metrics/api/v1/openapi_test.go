@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/go-openapi/spec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	metricsink "k8s.io/heapster/metrics/sinks/metric"
+)
+
+func TestOpenAPI(t *testing.T) {
+	metricSink := metricsink.NewMetricSink(time.Hour, time.Hour, []string{})
+	container := restful.NewContainer()
+	NewApi(true, metricSink, nil, false).Register(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + openAPIPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var swagger spec.Swagger
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&swagger))
+	assert.Equal(t, "2.0", swagger.Swagger)
+
+	// Path parameter expressions are stripped.
+	item, found := swagger.Paths.Paths["/api/v1/model/namespaces/{namespace-name}/pods/{pod-name}/metrics/{metric-name}"]
+	require.True(t, found)
+	require.NotNil(t, item.Get)
+	assert.Equal(t, "podMetrics", item.Get.ID)
+	params := map[string]string{}
+	for _, p := range item.Get.Parameters {
+		params[p.Name] = p.In
+	}
+	assert.Equal(t, "path", params["pod-name"])
+	assert.Equal(t, "path", params["metric-name"])
+	assert.Equal(t, "query", params["start"])
+	assert.Equal(t, "#/definitions/types.MetricResult", item.Get.Responses.StatusCodeResponses[http.StatusOK].Schema.Ref.String())
+
+	item, found = swagger.Paths.Paths["/api/v1/model/nodes/"]
+	require.True(t, found)
+	assert.Equal(t, "array", item.Get.Responses.StatusCodeResponses[http.StatusOK].Schema.Type[0])
+
+	_, found = swagger.Paths.Paths["/api/v1/metric-export/"]
+	assert.True(t, found)
+	// Other APIs are not described.
+	_, found = swagger.Paths.Paths["/api/v1/metric-export-schema/"]
+	assert.False(t, found)
+
+	point, found := swagger.Definitions["types.MetricPoint"]
+	require.True(t, found)
+	assert.Equal(t, "date-time", point.Properties["timestamp"].Format)
+	assert.Equal(t, "int64", point.Properties["value"].Format)
+	assert.Equal(t, "double", point.Properties["floatValue"].Format)
+	assert.Equal(t, []string{"timestamp", "value"}, point.Required)
+
+	result := swagger.Definitions["types.MetricResult"]
+	assert.Equal(t, "#/definitions/types.MetricPoint", result.Properties["metrics"].Items.Schema.Ref.String())
+	// Recursive types are referenced.
+	assert.Equal(t, "#/definitions/types.MetricResult", result.Properties["previous"].Items.Schema.Ref.String())
+	_, found = swagger.Definitions["types.Point"]
+	assert.True(t, found)
+}
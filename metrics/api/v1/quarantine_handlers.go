@@ -0,0 +1,57 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/processors"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+// EnableQuarantine makes the Api serve the values dropped by validator.
+func (a *Api) EnableQuarantine(validator *processors.Validator) {
+	a.validator = validator
+}
+
+// RegisterQuarantine registers the debug endpoint listing the latest values dropped by the
+// validator.
+func (a *Api) RegisterQuarantine(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/debug/quarantine").
+		Doc("Metric values dropped because they failed validation").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint returns the latest quarantined values, oldest first.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("quarantine", a.quarantine)).
+		Doc("Get the latest metric values dropped because they failed validation").
+		Operation("quarantine").
+		Writes(types.QuarantinedValueList{}))
+	container.Add(ws)
+}
+
+func (a *Api) quarantine(request *restful.Request, response *restful.Response) {
+	values := a.validator.GetQuarantine()
+	result := types.QuarantinedValueList{
+		Items: make([]types.QuarantinedValue, 0, len(values)),
+	}
+	for _, v := range values {
+		result.Items = append(result.Items, types.QuarantinedValue(v))
+	}
+	response.WriteEntity(result)
+}
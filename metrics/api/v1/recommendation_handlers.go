@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/recommender"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+// EnableRecommendations makes the Api serve the container resource recommendations of r.
+func (a *Api) EnableRecommendations(r *recommender.Recommender) {
+	a.recommender = r
+}
+
+// RegisterRecommendations registers the endpoints serving container resource recommendations.
+func (a *Api) RegisterRecommendations(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/recommendations").
+		Doc("Resource request and limit recommendations for the containers of workloads").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint returns the recommendations for the containers of all workloads.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("allRecommendations", a.allRecommendations)).
+		Doc("Get resource recommendations for all containers").
+		Operation("allRecommendations").
+		Writes(types.ContainerRecommendationList{}))
+
+	// The /namespaces/{namespace-name}/ endpoint returns the recommendations for all containers in a namespace.
+	ws.Route(ws.GET("/namespaces/{namespace-name}/").
+		To(metrics.InstrumentRouteFunc("namespaceRecommendations", a.namespaceRecommendations)).
+		Doc("Get resource recommendations for all containers in a namespace").
+		Operation("namespaceRecommendations").
+		Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
+		Writes(types.ContainerRecommendationList{}))
+
+	// The /namespaces/{namespace-name}/workloads/{kind}/{workload-name}/ endpoint returns the recommendations for the
+	// containers of a workload.
+	ws.Route(ws.GET("/namespaces/{namespace-name}/workloads/{kind}/{workload-name}/").
+		To(metrics.InstrumentRouteFunc("workloadRecommendations", a.workloadRecommendations)).
+		Doc("Get resource recommendations for the containers of a workload").
+		Operation("workloadRecommendations").
+		Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
+		Param(ws.PathParameter("kind", "The kind of the workload, e.g. Deployment, or Pod for pods without a controller").DataType("string")).
+		Param(ws.PathParameter("workload-name", "The name of the workload to lookup").DataType("string")).
+		Writes(types.ContainerRecommendationList{}))
+
+	// The /namespaces/{namespace-name}/pods/{pod-name}/ endpoint returns the recommendations for the containers of the
+	// workload of a pod.
+	ws.Route(ws.GET("/namespaces/{namespace-name}/pods/{pod-name}/").
+		To(metrics.InstrumentRouteFunc("podRecommendations", a.podRecommendations)).
+		Doc("Get resource recommendations for the containers of the workload of a pod").
+		Operation("podRecommendations").
+		Param(ws.PathParameter("namespace-name", "The name of the namespace to lookup").DataType("string")).
+		Param(ws.PathParameter("pod-name", "The name of the pod to lookup").DataType("string")).
+		Writes(types.ContainerRecommendationList{}))
+	container.Add(ws)
+}
+
+func (a *Api) allRecommendations(request *restful.Request, response *restful.Response) {
+	writeRecommendations(a.recommender.GetRecommendations("", "", ""), response)
+}
+
+func (a *Api) namespaceRecommendations(request *restful.Request, response *restful.Response) {
+	writeRecommendations(a.recommender.GetRecommendations(request.PathParameter("namespace-name"), "", ""), response)
+}
+
+func (a *Api) workloadRecommendations(request *restful.Request, response *restful.Response) {
+	writeRecommendations(a.recommender.GetRecommendations(request.PathParameter("namespace-name"),
+		request.PathParameter("kind"), request.PathParameter("workload-name")), response)
+}
+
+func (a *Api) podRecommendations(request *restful.Request, response *restful.Response) {
+	writeRecommendations(a.recommender.GetPodRecommendations(request.PathParameter("namespace-name"),
+		request.PathParameter("pod-name")), response)
+}
+
+func writeRecommendations(recommendations []recommender.Recommendation, response *restful.Response) {
+	result := types.ContainerRecommendationList{
+		Items: make([]types.ContainerRecommendation, 0, len(recommendations)),
+	}
+	for _, r := range recommendations {
+		result.Items = append(result.Items, types.ContainerRecommendation{
+			Namespace: r.Namespace,
+			Kind:      r.Kind,
+			Workload:  r.Workload,
+			Container: r.Container,
+			Samples:   r.Samples,
+			LastSeen:  r.LastSeen,
+			Requests: types.ResourceRecommendation{
+				Cpu:    r.CpuRequest,
+				Memory: r.MemoryRequest,
+			},
+			Limits: types.ResourceRecommendation{
+				Cpu:    r.CpuLimit,
+				Memory: r.MemoryLimit,
+			},
+		})
+	}
+	response.WriteEntity(result)
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/recommender"
+)
+
+func TestRecommendations(t *testing.T) {
+	controller := true
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range []string{"db-0", "db-1"} {
+		require.NoError(t, store.Add(&kube_api.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "ns1",
+				Name:            pod,
+				OwnerReferences: []metav1.OwnerReference{{Kind: "StatefulSet", Name: "db", Controller: &controller}},
+			},
+		}))
+	}
+
+	now := time.Now()
+	r := recommender.NewRecommender(v1listers.NewPodLister(store), 24*time.Hour)
+	batch := &core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}}
+	for _, pod := range []string{"db-0", "db-1", "standalone"} {
+		batch.MetricSets[core.PodContainerKey("ns1", pod, "c1")] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+				core.LabelNamespaceName.Key: "ns1",
+				core.LabelPodName.Key:       pod,
+				core.LabelContainerName.Key: "c1",
+			},
+			MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsageRate.Name:     {IntValue: 100, ValueType: core.ValueInt64},
+				core.MetricMemoryWorkingSet.Name: {IntValue: 100 << 20, ValueType: core.ValueInt64},
+			},
+		}
+	}
+	r.ExportData(batch)
+
+	api := NewApi(false, nil, nil, false)
+	api.EnableRecommendations(r)
+	container := restful.NewContainer()
+	container.Router(restful.CurlyRouter{})
+	api.Register(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	get := func(path string) types.ContainerRecommendationList {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var result types.ContainerRecommendationList
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	assert.Len(t, get("/api/v1/recommendations/").Items, 2)
+	assert.Len(t, get("/api/v1/recommendations/namespaces/ns1/").Items, 2)
+	assert.Len(t, get("/api/v1/recommendations/namespaces/ns2/").Items, 0)
+
+	items := get("/api/v1/recommendations/namespaces/ns1/workloads/StatefulSet/db/").Items
+	require.Len(t, items, 1)
+	assert.Equal(t, "StatefulSet", items[0].Kind)
+	assert.Equal(t, "db", items[0].Workload)
+	assert.Equal(t, "c1", items[0].Container)
+	// The usage of both pods of the stateful set.
+	assert.Equal(t, uint32(2), items[0].Samples)
+	assert.Equal(t, items, get("/api/v1/recommendations/namespaces/ns1/pods/db-1/").Items)
+
+	items = get("/api/v1/recommendations/namespaces/ns1/pods/standalone/").Items
+	require.Len(t, items, 1)
+	assert.Equal(t, "Pod", items[0].Kind)
+	assert.Equal(t, "standalone", items[0].Workload)
+	assert.Equal(t, uint32(1), items[0].Samples)
+	assert.Equal(t, int64(115), items[0].Requests.Cpu)
+	assert.InDelta(t, 115<<20, items[0].Limits.Memory, 1)
+}
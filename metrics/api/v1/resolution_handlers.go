@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/manager"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+// EnableResolutionBoosts makes the Api serve the endpoints boosting the resolution of namespaces
+// and nodes.
+func (a *Api) EnableResolutionBoosts(boosts *manager.ResolutionBoosts) {
+	a.boosts = boosts
+}
+
+// RegisterResolutionBoosts registers the endpoints listing and adding resolution boosts.
+func (a *Api) RegisterResolutionBoosts(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/resolution").
+		Doc("Temporary increases of the resolution of the metrics of a namespace or a node").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint lists the active boosts.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("resolutionBoosts", a.resolutionBoosts)).
+		Doc("Get the active resolution boosts, latest first").
+		Operation("resolutionBoosts").
+		Writes(types.ResolutionBoostList{}))
+
+	// POST / boosts the resolution of a namespace or a node.
+	ws.Route(ws.POST("/").
+		To(metrics.InstrumentRouteFunc("boostResolution", a.boostResolution)).
+		Doc("Scrape the metrics of a namespace or a node at the given interval for the given duration").
+		Operation("boostResolution").
+		Param(ws.QueryParameter("namespace", "Namespace whose metrics are boosted").DataType("string")).
+		Param(ws.QueryParameter("node", "Node whose metrics are boosted").DataType("string")).
+		Param(ws.QueryParameter("interval", "Interval between two scrapes, e.g. 10s, dividing the metric resolution").DataType("string")).
+		Param(ws.QueryParameter("duration", "Duration of the boost, e.g. 10m").DataType("string")).
+		Writes(types.ResolutionBoost{}))
+	container.Add(ws)
+}
+
+func (a *Api) resolutionBoosts(request *restful.Request, response *restful.Response) {
+	boosts := a.boosts.List()
+	result := types.ResolutionBoostList{
+		Items: make([]types.ResolutionBoost, 0, len(boosts)),
+	}
+	for _, boost := range boosts {
+		result.Items = append(result.Items, convertBoost(boost))
+	}
+	response.WriteEntity(result)
+}
+
+func (a *Api) boostResolution(request *restful.Request, response *restful.Response) {
+	var durations []time.Duration
+	for _, param := range []string{"interval", "duration"} {
+		duration, err := time.ParseDuration(request.QueryParameter(param))
+		if err != nil {
+			response.WriteError(http.StatusBadRequest, fmt.Errorf("invalid %s: %v", param, err))
+			return
+		}
+		durations = append(durations, duration)
+	}
+	boost, err := a.boosts.Add(request.QueryParameter("namespace"), request.QueryParameter("node"), durations[0], durations[1])
+	if err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, convertBoost(boost))
+}
+
+func convertBoost(boost manager.Boost) types.ResolutionBoost {
+	return types.ResolutionBoost{
+		Namespace: boost.Namespace,
+		Node:      boost.Node,
+		Interval:  boost.Interval.String(),
+		Expires:   boost.Expires,
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/heapster/metrics/core"
+	metricsink "k8s.io/heapster/metrics/sinks/metric"
+)
+
+var (
+	// Number of model API requests served from or missing the response cache.
+	responseCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "model_api",
+			Name:      "response_cache_requests_total",
+			Help:      "Number of model API requests served from (hit) or missing (miss) the response cache.",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(responseCacheRequests)
+}
+
+type cachedResponse struct {
+	body        []byte
+	contentType string
+	// Timestamp of the latest data batch when the response was rendered.
+	batchTimestamp time.Time
+	// The latest data batch itself, which is replaced when data is purged from the metric sink.
+	batch   *core.DataBatch
+	expires time.Time
+}
+
+// responseCache keeps rendered model API responses keyed by the request path, query and
+// accepted content type. A response is served from the cache until the ttl passes or
+// a newer data batch arrives in the metric sink, whichever comes first.
+type responseCache struct {
+	metricSink *metricsink.MetricSink
+	ttl        time.Duration
+
+	lock           sync.Mutex
+	batchTimestamp time.Time
+	entries        map[string]cachedResponse
+}
+
+func newResponseCache(metricSink *metricsink.MetricSink, ttl time.Duration) *responseCache {
+	return &responseCache{
+		metricSink: metricSink,
+		ttl:        ttl,
+		entries:    map[string]cachedResponse{},
+	}
+}
+
+// filter is a go-restful filter serving cached responses and caching successful ones.
+func (c *responseCache) filter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	batch := c.metricSink.GetLatestDataBatch()
+	if batch == nil || request.Request.Method != "GET" {
+		chain.ProcessFilter(request, response)
+		return
+	}
+
+	key := request.Request.URL.RequestURI() + "|" + request.HeaderParameter("Accept")
+	if cached, found := c.get(key, batch, nowFunc()); found {
+		responseCacheRequests.WithLabelValues("hit").Inc()
+		if cached.contentType != "" {
+			response.Header().Set("Content-Type", cached.contentType)
+		}
+		response.WriteHeader(http.StatusOK)
+		response.Write(cached.body)
+		return
+	}
+	responseCacheRequests.WithLabelValues("miss").Inc()
+
+	recorder := &responseRecorder{ResponseWriter: response.ResponseWriter, status: http.StatusOK}
+	response.ResponseWriter = recorder
+	chain.ProcessFilter(request, response)
+	response.ResponseWriter = recorder.ResponseWriter
+
+	if recorder.status != http.StatusOK {
+		return
+	}
+	c.put(key, cachedResponse{
+		body:           recorder.body.Bytes(),
+		contentType:    recorder.Header().Get("Content-Type"),
+		batchTimestamp: batch.Timestamp,
+		batch:          batch,
+		expires:        nowFunc().Add(c.ttl),
+	})
+}
+
+func (c *responseCache) get(key string, batch *core.DataBatch, now time.Time) (cachedResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.invalidateOlderThan(batch.Timestamp)
+	cached, found := c.entries[key]
+	if !found || cached.batch != batch || !now.Before(cached.expires) {
+		return cachedResponse{}, false
+	}
+	return cached, true
+}
+
+func (c *responseCache) put(key string, cached cachedResponse) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.invalidateOlderThan(cached.batchTimestamp)
+	if !cached.batchTimestamp.Equal(c.batchTimestamp) {
+		// The response was rendered from a batch that is no longer the latest.
+		return
+	}
+	c.entries[key] = cached
+}
+
+// invalidateOlderThan drops all entries once a newer batch is seen. Must be called with the lock held.
+func (c *responseCache) invalidateOlderThan(batchTimestamp time.Time) {
+	if batchTimestamp.After(c.batchTimestamp) {
+		c.batchTimestamp = batchTimestamp
+		c.entries = map[string]cachedResponse{}
+	}
+}
+
+// responseRecorder passes the response through to the client while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
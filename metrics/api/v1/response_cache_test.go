@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+	metricsink "k8s.io/heapster/metrics/sinks/metric"
+)
+
+func TestResponseCache(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	metricSink := metricsink.NewMetricSink(time.Hour, time.Hour, []string{})
+	metricSink.ExportData(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{}})
+
+	calls := 0
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/model").Produces(restful.MIME_JSON)
+	ws.Filter(newResponseCache(metricSink, time.Minute).filter)
+	ws.Route(ws.GET("/nodes/").To(func(request *restful.Request, response *restful.Response) {
+		calls++
+		response.WriteEntity([]string{"node1"})
+	}))
+	container := restful.NewContainer()
+	container.Add(ws)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	get := func(path string) string {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, restful.MIME_JSON, resp.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	first := get("/api/v1/model/nodes/")
+	assert.Equal(t, first, get("/api/v1/model/nodes/"))
+	assert.Equal(t, 1, calls)
+
+	// Different query parameters are cached separately.
+	get("/api/v1/model/nodes/?start=2017-01-01T00:00:00Z")
+	assert.Equal(t, 2, calls)
+
+	// Responses expire after the ttl.
+	now = now.Add(2 * time.Minute)
+	get("/api/v1/model/nodes/")
+	assert.Equal(t, 3, calls)
+
+	// Responses are invalidated by a new data batch.
+	metricSink.ExportData(&core.DataBatch{Timestamp: now, MetricSets: map[string]*core.MetricSet{
+		core.NamespaceKey("ns1"): {Labels: map[string]string{}},
+	}})
+	get("/api/v1/model/nodes/")
+	assert.Equal(t, 4, calls)
+	get("/api/v1/model/nodes/")
+	assert.Equal(t, 4, calls)
+
+	// Responses are invalidated when data is purged from the metric sink.
+	metricSink.DeleteNamespace("ns1")
+	get("/api/v1/model/nodes/")
+	assert.Equal(t, 5, calls)
+}
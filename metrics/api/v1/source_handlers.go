@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/sources"
+	"k8s.io/heapster/metrics/util/metrics"
+)
+
+// EnableSources makes the Api serve the status of the sources scraped by sourceManager.
+func (a *Api) EnableSources(sourceManager sources.SourceManager) {
+	a.sourceManager = sourceManager
+}
+
+// RegisterSources registers the endpoint listing the status of all sources.
+func (a *Api) RegisterSources(container *restful.Container) {
+	ws := new(restful.WebService)
+	ws.Path("/api/v1/sources").
+		Doc("Outcome of the latest scrape of each source").
+		Consumes("*/*").
+		Produces(restful.MIME_JSON)
+
+	// The / endpoint returns the status of all sources.
+	ws.Route(ws.GET("/").
+		To(metrics.InstrumentRouteFunc("sources", a.sources)).
+		Doc("Get the time, duration and error of the latest scrape of each source, e.g. of each kubelet, and the outcome of their earlier scrapes").
+		Operation("sources").
+		Param(ws.QueryParameter("failing", "If true, only list the sources whose latest scrape failed").DataType("boolean")).
+		Writes(types.SourceStatusList{}))
+	container.Add(ws)
+}
+
+func (a *Api) sources(request *restful.Request, response *restful.Response) {
+	failing := false
+	if param := request.QueryParameter("failing"); param != "" {
+		var err error
+		if failing, err = strconv.ParseBool(param); err != nil {
+			response.WriteError(http.StatusBadRequest, fmt.Errorf("invalid failing parameter %q: %v", param, err))
+			return
+		}
+	}
+	statuses := a.sourceManager.GetSourceStatuses()
+	result := types.SourceStatusList{
+		Items: make([]types.SourceStatus, 0, len(statuses)),
+	}
+	for _, s := range statuses {
+		if failing && s.Error == "" {
+			continue
+		}
+		status := types.SourceStatus{
+			Source:                     s.Source,
+			NodeName:                   s.NodeName,
+			KubeletVersion:             s.KubeletVersion,
+			LastScrapeTime:             s.LastScrapeTime,
+			ScrapeDurationMilliseconds: int64(s.ScrapeDuration / time.Millisecond),
+			MetricSets:                 s.MetricSets,
+			Error:                      s.Error,
+			LastError:                  s.LastError,
+			ConsecutiveFailures:        s.ConsecutiveFailures,
+			Errors:                     s.Errors,
+		}
+		if !s.LastSuccessTime.IsZero() {
+			lastSuccessTime := s.LastSuccessTime
+			status.LastSuccessTime = &lastSuccessTime
+		}
+		if !s.LastErrorTime.IsZero() {
+			lastErrorTime := s.LastErrorTime
+			status.LastErrorTime = &lastErrorTime
+		}
+		result.Items = append(result.Items, status)
+	}
+	response.WriteEntity(result)
+}
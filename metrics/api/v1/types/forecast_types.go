@@ -0,0 +1,43 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+type ResourceForecast struct {
+	// Average usage in the latest hour.
+	Current float64 `json:"current"`
+	// Usage projected to the end of the forecast period.
+	Projected float64 `json:"projected"`
+	// Change of the usage per day.
+	SlopePerDay float64 `json:"slopePerDay"`
+	// Current allocatable capacity, zero if unknown.
+	Allocatable int64 `json:"allocatable"`
+}
+
+type Forecast struct {
+	// The node pool, empty for the whole cluster.
+	NodePool string `json:"nodePool,omitempty"`
+	// Number of hourly averages the forecast is based on.
+	Samples int `json:"samples"`
+	// CPU in millicores.
+	Cpu ResourceForecast `json:"cpu"`
+	// Memory in bytes.
+	Memory ResourceForecast `json:"memory"`
+}
+
+type ForecastList struct {
+	// Number of days the usage is projected ahead.
+	Days  int        `json:"days"`
+	Items []Forecast `json:"items"`
+}
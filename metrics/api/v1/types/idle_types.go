@@ -0,0 +1,36 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"time"
+)
+
+type IdleWorkload struct {
+	Namespace string `json:"namespace"`
+	// Kind of the controller, e.g. Deployment, or Pod for pods without a controller.
+	Kind          string    `json:"kind"`
+	Name          string    `json:"name"`
+	Pods          []string  `json:"pods"`
+	ObservedSince time.Time `json:"observedSince"`
+	// Peak CPU usage in millicores.
+	MaxCpuUsageRate int64 `json:"maxCpuUsageRate"`
+	// Peak received and transmitted bytes per second.
+	MaxNetworkUsageRate float64 `json:"maxNetworkUsageRate"`
+}
+
+type IdleWorkloadList struct {
+	Items []IdleWorkload `json:"items"`
+}
@@ -30,6 +30,11 @@ type MetricPoint struct {
 type MetricResult struct {
 	Metrics         []MetricPoint `json:"metrics"`
 	LatestTimestamp time.Time     `json:"latestTimestamp"`
+	// UID of the pod the metrics belong to. Only set for pod and pod container metrics.
+	UID string `json:"uid,omitempty"`
+	// Metrics of earlier pods with the same name, oldest first. Only set if requested
+	// with includePrevious=true.
+	Previous []MetricResult `json:"previous,omitempty"`
 }
 
 type MetricResultList struct {
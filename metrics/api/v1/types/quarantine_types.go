@@ -0,0 +1,34 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"time"
+)
+
+type QuarantinedValue struct {
+	// Key of the metric set, e.g. node:node1.
+	Key string `json:"key"`
+	// Name of the metric, empty if the whole metric set was dropped.
+	Metric string `json:"metric,omitempty"`
+	Value  string `json:"value"`
+	// Why the value was dropped, e.g. negative or future_timestamp.
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type QuarantinedValueList struct {
+	Items []QuarantinedValue `json:"items"`
+}
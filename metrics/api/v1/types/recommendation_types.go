@@ -0,0 +1,43 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"time"
+)
+
+type ResourceRecommendation struct {
+	// CPU in millicores.
+	Cpu int64 `json:"cpu"`
+	// Memory in bytes.
+	Memory int64 `json:"memory"`
+}
+
+type ContainerRecommendation struct {
+	Namespace string `json:"namespace"`
+	// Kind of the controller of the pods, e.g. Deployment, or Pod for pods without a controller.
+	Kind      string `json:"kind"`
+	Workload  string `json:"workload"`
+	Container string `json:"container"`
+	// Number of usage samples the recommendation is based on.
+	Samples  uint32                 `json:"samples"`
+	LastSeen time.Time              `json:"lastSeen"`
+	Requests ResourceRecommendation `json:"requests"`
+	Limits   ResourceRecommendation `json:"limits"`
+}
+
+type ContainerRecommendationList struct {
+	Items []ContainerRecommendation `json:"items"`
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"time"
+)
+
+type SourceStatus struct {
+	Source string `json:"source"`
+	// Node and kubelet version of sources scraping a single kubelet.
+	NodeName       string    `json:"nodeName,omitempty"`
+	KubeletVersion string    `json:"kubeletVersion,omitempty"`
+	LastScrapeTime time.Time `json:"lastScrapeTime"`
+	// Duration of the latest scrape in milliseconds.
+	ScrapeDurationMilliseconds int64 `json:"scrapeDurationMilliseconds"`
+	// Number of metric sets returned by the latest scrape.
+	MetricSets int `json:"metricSets"`
+	// Error of the latest scrape, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+	// Start of the latest successful scrape, unset if none succeeded yet.
+	LastSuccessTime *time.Time `json:"lastSuccessTime,omitempty"`
+	// Error and start of the latest failed scrape, even if later scrapes succeeded.
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+	// Number of scrapes which failed since the latest successful one.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// Number of failed scrapes since Heapster started, by reason: timeout, decode or request.
+	Errors map[string]int64 `json:"errors,omitempty"`
+}
+
+type SourceStatusList struct {
+	Items []SourceStatus `json:"items"`
+}
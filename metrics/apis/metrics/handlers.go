@@ -259,7 +259,7 @@ func (a *Api) getPodMetrics(pod *kube_v1.Pod) *metrics.PodMetrics {
 	}
 
 	for _, c := range pod.Spec.Containers {
-		ms, found := batch.MetricSets[core.PodContainerKey(pod.Namespace, pod.Name, c.Name)]
+		ms, found := batch.MetricSets[core.PodContainerKeyWithUID(pod.Namespace, pod.Name, string(pod.UID), c.Name)]
 		if !found {
 			glog.V(2).Infof("No metrics for container %s in pod %s/%s", c.Name, pod.Namespace, pod.Name)
 			return nil
@@ -15,18 +15,40 @@
 package main
 
 import (
+	"crypto/subtle"
 	"crypto/x509"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
 	"strings"
 
 	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/authenticatorfactory"
+	"k8s.io/apiserver/pkg/authentication/request/bearertoken"
+	"k8s.io/apiserver/pkg/authentication/request/union"
 	x509request "k8s.io/apiserver/pkg/authentication/request/x509"
 	"k8s.io/apiserver/pkg/authentication/user"
+	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1beta1"
 	"k8s.io/heapster/metrics/options"
 )
 
+const (
+	// Name of the user authenticated by the static /metrics bearer token.
+	metricsTokenUser = "heapster:metrics-token"
+	// Prefix of the names of the users authenticated by the scoped API tokens.
+	apiTokenUserPrefix = "heapster:api-token:"
+	// Key of the namespaces readable with a scoped API token in the extra info of its user.
+	apiTokenNamespacesKey = "namespaces"
+	// Prefix of the paths of the Heapster-specific APIs, which accept the scoped API tokens.
+	apiPathPrefix = "/api/v1/"
+	// Prefix of the model API paths of a namespace.
+	namespaceModelPathPrefix = "/api/v1/model/namespaces/"
+)
+
 func newAuthHandler(opt *options.HeapsterRunOptions, handler http.Handler) (http.Handler, error) {
 	// Authn/Authz setup
 	authn, err := newAuthenticatorFromClientCAFile(opt.TLSClientCAFile)
@@ -39,6 +61,92 @@ func newAuthHandler(opt *options.HeapsterRunOptions, handler http.Handler) (http
 		return nil, err
 	}
 
+	return withAuth(authn, authz, handler), nil
+}
+
+// metricsAuthEnabled returns true if the /metrics endpoint has its own authentication configured.
+func metricsAuthEnabled(opt *options.HeapsterRunOptions) bool {
+	return len(opt.MetricsTokenFile) > 0 || opt.MetricsTokenReview
+}
+
+// newMetricsAuthHandler protects the /metrics endpoint with bearer tokens, which are either compared
+// with the static token or reviewed by the API server using tokenReviews. Client certificates are
+// accepted as well if a client CA is configured.
+func newMetricsAuthHandler(opt *options.HeapsterRunOptions, tokenReviews authenticationclient.TokenReviewInterface,
+	handler http.Handler) (http.Handler, error) {
+
+	authenticators := []authenticator.Request{}
+	allowedUsers := strings.Split(opt.AllowedUsers, ",")
+	if len(opt.TLSClientCAFile) > 0 {
+		authn, err := newAuthenticatorFromClientCAFile(opt.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authn)
+	}
+	if len(opt.MetricsTokenFile) > 0 {
+		authn, err := newAuthenticatorFromTokenFile(opt.MetricsTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authn)
+		if len(opt.AllowedUsers) > 0 {
+			allowedUsers = append(allowedUsers, metricsTokenUser)
+		}
+	}
+	if tokenReviews != nil {
+		config := authenticatorfactory.DelegatingAuthenticatorConfig{
+			TokenAccessReviewClient: tokenReviews,
+			CacheTTL:                opt.Authentication.CacheTTL,
+		}
+		authn, _, err := config.New()
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authn)
+	}
+
+	authz, err := newAuthorizerFromUserList(allowedUsers...)
+	if err != nil {
+		return nil, err
+	}
+
+	return withAuth(union.New(authenticators...), authz, handler), nil
+}
+
+// apiAuthEnabled returns true if the Heapster-specific APIs accept scoped API tokens.
+func apiAuthEnabled(opt *options.HeapsterRunOptions) bool {
+	return len(opt.APITokenFile) > 0
+}
+
+// newAPIAuthHandler protects the Heapster-specific APIs under /api/v1 with the scoped tokens of the API
+// token file, which only grant read access to the model API of their namespaces. Client certificates
+// are accepted as well if a client CA is configured, and grant access to all the APIs. The other
+// paths, e.g. the metrics API used by the HPA and kubectl top, are not served by this handler.
+func newAPIAuthHandler(opt *options.HeapsterRunOptions, handler http.Handler) (http.Handler, error) {
+	authenticators := []authenticator.Request{}
+	if len(opt.TLSClientCAFile) > 0 {
+		authn, err := newAuthenticatorFromClientCAFile(opt.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authn)
+	}
+	authn, err := newAuthenticatorFromAPITokenFile(opt.APITokenFile)
+	if err != nil {
+		return nil, err
+	}
+	authenticators = append(authenticators, authn)
+
+	authz, err := newAuthorizerFromUserList(strings.Split(opt.AllowedUsers, ",")...)
+	if err != nil {
+		return nil, err
+	}
+
+	return withAuth(union.New(authenticators...), &namespaceScopeAuthorizer{authz}, handler), nil
+}
+
+func withAuth(authn authenticator.Request, authz Authorizer, handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		// Check authn
 		user, ok, err := authn.AuthenticateRequest(req)
@@ -63,7 +171,7 @@ func newAuthHandler(opt *options.HeapsterRunOptions, handler http.Handler) (http
 		}
 
 		handler.ServeHTTP(w, req)
-	}), nil
+	})
 }
 
 // newAuthenticatorFromClientCAFile returns an authenticator.Request or an error
@@ -86,6 +194,85 @@ func newAuthenticatorFromClientCAFile(clientCAFile string) (authenticator.Reques
 	return x509request.New(opts, x509request.CommonNameUserConversion), nil
 }
 
+// newAuthenticatorFromTokenFile returns an authenticator.Request that accepts the bearer token
+// stored in the given file.
+func newAuthenticatorFromTokenFile(tokenFile string) (authenticator.Request, error) {
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	expected := strings.TrimSpace(string(data))
+	if len(expected) == 0 {
+		return nil, fmt.Errorf("no token found in %s", tokenFile)
+	}
+	return newStaticTokenAuthenticator(func(token string) (user.Info, bool, error) {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return nil, false, nil
+		}
+		return &user.DefaultInfo{Name: metricsTokenUser}, true, nil
+	}), nil
+}
+
+// newStaticTokenAuthenticator returns an authenticator.Request that accepts the bearer tokens known
+// to auth. Unknown tokens are not authenticated rather than an error, so that they are rejected as
+// unauthorized.
+func newStaticTokenAuthenticator(auth authenticator.TokenFunc) authenticator.Request {
+	authn := bearertoken.New(auth)
+	return authenticator.RequestFunc(func(req *http.Request) (user.Info, bool, error) {
+		user, ok, err := authn.AuthenticateRequest(req)
+		if !ok {
+			return nil, false, nil
+		}
+		return user, ok, err
+	})
+}
+
+// newAuthenticatorFromAPITokenFile returns an authenticator.Request that accepts the bearer tokens
+// of the given CSV file, whose lines are token,name,namespace[,namespace...].
+func newAuthenticatorFromAPITokenFile(tokenFile string) (authenticator.Request, error) {
+	file, err := os.Open(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := map[string]user.Info{}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	for entry := 1; ; entry++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", tokenFile, err)
+		}
+		if len(record) < 3 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("invalid entry %d of %s: expected token,name,namespace[,namespace...]", entry, tokenFile)
+		}
+		if _, found := tokens[record[0]]; found {
+			return nil, fmt.Errorf("invalid entry %d of %s: duplicate token", entry, tokenFile)
+		}
+		tokens[record[0]] = &user.DefaultInfo{
+			Name:  apiTokenUserPrefix + record[1],
+			Extra: map[string][]string{apiTokenNamespacesKey: record[2:]},
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no token found in %s", tokenFile)
+	}
+	return newStaticTokenAuthenticator(func(token string) (user.Info, bool, error) {
+		for expected, info := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+				return info, true, nil
+			}
+		}
+		return nil, false, nil
+	}), nil
+}
+
 type Authorizer interface {
 	AuthorizeRequest(req *http.Request, user user.Info) (bool, error)
 }
@@ -114,3 +301,29 @@ type userAuthorizer struct {
 func (a *userAuthorizer) AuthorizeRequest(req *http.Request, user user.Info) (bool, error) {
 	return a.allowedUsers[user.GetName()], nil
 }
+
+// namespaceScopeAuthorizer only allows the users of scoped API tokens to read the model API of their
+// namespaces, and delegates the authorization of the other users.
+type namespaceScopeAuthorizer struct {
+	delegate Authorizer
+}
+
+func (a *namespaceScopeAuthorizer) AuthorizeRequest(req *http.Request, user user.Info) (bool, error) {
+	if !strings.HasPrefix(user.GetName(), apiTokenUserPrefix) {
+		return a.delegate.AuthorizeRequest(req, user)
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false, nil
+	}
+	requestPath := path.Clean(req.URL.Path)
+	if !strings.HasPrefix(requestPath, namespaceModelPathPrefix) {
+		return false, nil
+	}
+	namespace := strings.SplitN(strings.TrimPrefix(requestPath, namespaceModelPathPrefix), "/", 2)[0]
+	for _, allowed := range user.GetExtra()[apiTokenNamespacesKey] {
+		if namespace == allowed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/options"
+)
+
+func TestMetricsTokenAuth(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "metrics-token")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("secret\n")
+	require.NoError(t, err)
+	require.NoError(t, tokenFile.Close())
+
+	opt := options.NewHeapsterRunOptions()
+	opt.MetricsTokenFile = tokenFile.Name()
+	opt.AllowedUsers = "someone"
+	handler, err := newMetricsAuthHandler(opt, nil, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	require.NoError(t, err)
+
+	for token, expectedCode := range map[string]int{
+		"":       http.StatusUnauthorized,
+		"wrong":  http.StatusUnauthorized,
+		"secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, expectedCode, recorder.Code, "token %q", token)
+	}
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	tokenFile, err := ioutil.TempFile("", "api-tokens")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name())
+	_, err = tokenFile.WriteString("# token,name,namespaces\nsecret-a,team-a,ns-a\nsecret-b,team-b,ns-b,ns-c\n")
+	require.NoError(t, err)
+	require.NoError(t, tokenFile.Close())
+
+	opt := options.NewHeapsterRunOptions()
+	opt.APITokenFile = tokenFile.Name()
+	handler, err := newAPIAuthHandler(opt, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		method, path, token string
+		expectedCode        int
+	}{
+		{"GET", "/api/v1/model/namespaces/ns-a/metrics/", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/model/namespaces/ns-a/metrics/", "wrong", http.StatusUnauthorized},
+		{"GET", "/api/v1/model/namespaces/ns-a/metrics/", "secret-a", http.StatusOK},
+		{"GET", "/api/v1/model/namespaces/ns-a/pods/pod1/metrics/cpu/usage_rate", "secret-a", http.StatusOK},
+		{"GET", "/api/v1/model/namespaces/ns-b/metrics/", "secret-a", http.StatusForbidden},
+		{"GET", "/api/v1/model/namespaces/ns-a/../ns-b/metrics/", "secret-a", http.StatusForbidden},
+		{"GET", "/api/v1/model/namespaces/ns-c/metrics/", "secret-b", http.StatusOK},
+		{"GET", "/api/v1/model/namespaces/", "secret-a", http.StatusForbidden},
+		{"GET", "/api/v1/model/nodes/", "secret-a", http.StatusForbidden},
+		{"POST", "/api/v1/model/namespaces/ns-a/metrics/", "secret-a", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, test.expectedCode, recorder.Code, "%+v", test)
+	}
+}
+
+func TestInvalidAPITokenFile(t *testing.T) {
+	for _, content := range []string{"", "secret,team-a\n", "secret,team-a,ns-a\nsecret,team-b,ns-b\n"} {
+		tokenFile, err := ioutil.TempFile("", "api-tokens")
+		require.NoError(t, err)
+		defer os.Remove(tokenFile.Name())
+		_, err = tokenFile.WriteString(content)
+		require.NoError(t, err)
+		require.NoError(t, tokenFile.Close())
+
+		_, err = newAuthenticatorFromAPITokenFile(tokenFile.Name())
+		assert.Error(t, err, "%q", content)
+	}
+}
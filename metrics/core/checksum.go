@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PointKey identifies a metric value within a batch, e.g. "node:n1/cpu/usage" or
+// "node:n1/filesystem/usage{resource_id=/}".
+func PointKey(metricSetKey, metricName string, labels map[string]string) string {
+	key := fmt.Sprintf("%s/%s", metricSetKey, metricName)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels))
+		for k, v := range labels {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		key += "{" + strings.Join(pairs, ",") + "}"
+	}
+	return key
+}
+
+// FormatPointValue formats a metric value as in the checksums of batches: integers in decimal,
+// floats in the shortest decimal representation.
+func FormatPointValue(value MetricValue) string {
+	if value.ValueType == ValueFloat {
+		return strconv.FormatFloat(value.FloatValue, 'g', -1, 64)
+	}
+	return strconv.FormatInt(value.IntValue, 10)
+}
+
+// BatchChecksum returns the SHA-256 checksum of the metric values of the batch, in hex, and the
+// number of values. The checksum is computed over the lines "<PointKey> <FormatPointValue>",
+// sorted and terminated by a newline, so that consumers receiving the values of a batch in
+// separate messages can verify none was lost or altered.
+func BatchChecksum(batch *DataBatch) (string, int) {
+	var lines []string
+	for key, metricSet := range batch.MetricSets {
+		for name, value := range metricSet.MetricValues {
+			lines = append(lines, PointKey(key, name, nil)+" "+FormatPointValue(value))
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			lines = append(lines, PointKey(key, metric.Name, metric.Labels)+" "+FormatPointValue(metric.MetricValue))
+		}
+	}
+	sort.Strings(lines)
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), len(lines)
+}
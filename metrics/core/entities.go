@@ -0,0 +1,160 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// EntityType describes metric sets of objects other than the Kubernetes ones, e.g. standalone
+// Docker hosts and their containers, or VMs reporting through an agent. Sources reporting such
+// objects register their entity types before they are scraped. Their metric sets carry the type
+// in the LabelMetricSetType label and the names of the entity and all of its ancestors in the
+// name labels of the respective types.
+type EntityType struct {
+	// Value of the LabelMetricSetType label of metric sets of this type.
+	MetricSetType string
+	// Label holding the name of the entity.
+	NameLabel LabelDescriptor
+	// Type of the parent entity, empty for top-level entities. It must be registered first.
+	ParentType string
+	// Metrics summed up from the entities of this type into their parent.
+	AggregatedMetrics []string
+}
+
+var (
+	entityTypesLock sync.RWMutex
+	// Registered entity types in the order of registration, so parents precede their children.
+	entityTypes []EntityType
+)
+
+var kubernetesMetricSetTypes = map[string]bool{
+	MetricSetTypeSystemContainer: true,
+	MetricSetTypePodContainer:    true,
+	MetricSetTypePod:             true,
+	MetricSetTypeNamespace:       true,
+	MetricSetTypeNode:            true,
+	MetricSetTypeCluster:         true,
+}
+
+// RegisterEntityType makes metric sets of the given type known to the processors, the model
+// and the sinks. Registering the same type twice is an error.
+func RegisterEntityType(entityType EntityType) error {
+	if entityType.MetricSetType == "" || entityType.NameLabel.Key == "" {
+		return fmt.Errorf("entity type and name label must be set")
+	}
+	if kubernetesMetricSetTypes[entityType.MetricSetType] || strings.ContainsAny(entityType.MetricSetType, ":/") {
+		return fmt.Errorf("invalid entity type %q", entityType.MetricSetType)
+	}
+
+	entityTypesLock.Lock()
+	defer entityTypesLock.Unlock()
+	parentFound := entityType.ParentType == ""
+	for _, t := range entityTypes {
+		if t.MetricSetType == entityType.MetricSetType {
+			return fmt.Errorf("entity type %q is already registered", entityType.MetricSetType)
+		}
+		if t.MetricSetType == entityType.ParentType {
+			parentFound = true
+		}
+	}
+	if !parentFound {
+		return fmt.Errorf("parent %q of entity type %q is not registered", entityType.ParentType, entityType.MetricSetType)
+	}
+	entityTypes = append(entityTypes, entityType)
+	return nil
+}
+
+// GetEntityType returns the registered entity type of metric sets with the given type label.
+func GetEntityType(metricSetType string) (EntityType, bool) {
+	entityTypesLock.RLock()
+	defer entityTypesLock.RUnlock()
+	for _, t := range entityTypes {
+		if t.MetricSetType == metricSetType {
+			return t, true
+		}
+	}
+	return EntityType{}, false
+}
+
+// EntityTypes returns all registered entity types. Parents precede their children.
+func EntityTypes() []EntityType {
+	entityTypesLock.RLock()
+	defer entityTypesLock.RUnlock()
+	result := make([]EntityType, len(entityTypes))
+	copy(result, entityTypes)
+	return result
+}
+
+// EntityLabels returns the type label and the name labels identifying the entity of the given
+// type within labels, or an error if a name label is missing.
+func EntityLabels(metricSetType string, labels map[string]string) (map[string]string, error) {
+	types, names, err := entityPath(metricSetType, labels)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]string{LabelMetricSetType.Key: metricSetType}
+	for i, t := range types {
+		result[t.NameLabel.Key] = names[i]
+	}
+	return result, nil
+}
+
+// EntityKey returns the key of the metric set of the entity of the given type identified by the
+// name labels within labels.
+func EntityKey(metricSetType string, labels map[string]string) (string, error) {
+	types, names, err := entityPath(metricSetType, labels)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = fmt.Sprintf("%s:%s", t.MetricSetType, names[i])
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// entityPath returns the types and names of the entity and all of its ancestors, top-level first.
+func entityPath(metricSetType string, labels map[string]string) ([]EntityType, []string, error) {
+	var types []EntityType
+	var names []string
+	for t := metricSetType; t != ""; {
+		entityType, found := GetEntityType(t)
+		if !found {
+			return nil, nil, fmt.Errorf("unknown entity type %q", t)
+		}
+		name := labels[entityType.NameLabel.Key]
+		if name == "" {
+			return nil, nil, fmt.Errorf("missing label %s of entity type %s", entityType.NameLabel.Key, t)
+		}
+		types = append([]EntityType{entityType}, types...)
+		names = append([]string{name}, names...)
+		t = entityType.ParentType
+	}
+	return types, names, nil
+}
+
+// EntityLabelDescriptors returns the name labels of all registered entity types.
+func EntityLabelDescriptors() []LabelDescriptor {
+	entityTypesLock.RLock()
+	defer entityTypesLock.RUnlock()
+	result := make([]LabelDescriptor, 0, len(entityTypes))
+	for _, t := range entityTypes {
+		result = append(result, t.NameLabel)
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntityTypes(t *testing.T) {
+	vmLabel := LabelDescriptor{Key: "vm_name"}
+	processLabel := LabelDescriptor{Key: "process_name"}
+
+	assert.Error(t, RegisterEntityType(EntityType{MetricSetType: MetricSetTypeNode, NameLabel: vmLabel}))
+	assert.Error(t, RegisterEntityType(EntityType{MetricSetType: "vm"}))
+	assert.Error(t, RegisterEntityType(EntityType{MetricSetType: "vm_process", NameLabel: processLabel, ParentType: "vm"}))
+	assert.NoError(t, RegisterEntityType(EntityType{MetricSetType: "vm", NameLabel: vmLabel}))
+	assert.Error(t, RegisterEntityType(EntityType{MetricSetType: "vm", NameLabel: vmLabel}))
+	assert.NoError(t, RegisterEntityType(EntityType{MetricSetType: "vm_process", NameLabel: processLabel, ParentType: "vm"}))
+
+	labels := map[string]string{
+		LabelMetricSetType.Key: "vm_process",
+		"vm_name":              "vm1",
+		"process_name":         "nginx",
+		"other":                "value",
+	}
+	key, err := EntityKey("vm_process", labels)
+	assert.NoError(t, err)
+	assert.Equal(t, "vm:vm1/vm_process:nginx", key)
+	key, err = EntityKey("vm", labels)
+	assert.NoError(t, err)
+	assert.Equal(t, "vm:vm1", key)
+
+	parentLabels, err := EntityLabels("vm", labels)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{LabelMetricSetType.Key: "vm", "vm_name": "vm1"}, parentLabels)
+
+	_, err = EntityKey("vm_process", map[string]string{"process_name": "nginx"})
+	assert.Error(t, err)
+	_, err = EntityKey("unknown", labels)
+	assert.Error(t, err)
+
+	assert.Contains(t, EntityLabelDescriptors(), vmLabel)
+	assert.Contains(t, SupportedLabels(), processLabel)
+}
@@ -64,6 +64,14 @@ var (
 		Key:         "resource_id",
 		Description: "Identifier(s) specific to a metric",
 	}
+	LabelEventReason = LabelDescriptor{
+		Key:         "event_reason",
+		Description: "Reason of a Kubernetes event",
+	}
+	LabelEventType = LabelDescriptor{
+		Key:         "event_type",
+		Description: "Type of a Kubernetes event (Normal, Warning)",
+	}
 	LabelHostID = LabelDescriptor{
 		Key:         "host_id",
 		Description: "Identifier specific to a host. Set by cloud provider or user",
@@ -105,6 +113,66 @@ var (
 		Key:         "accelerator_id",
 		Description: "ID of the accelerator",
 	}
+	LabelContainerTerminated = LabelDescriptor{
+		Key:         "terminated",
+		Description: "Set to true on the final sample of a terminated container",
+	}
+	LabelNodeCondition = LabelDescriptor{
+		Key:         "condition",
+		Description: "Type of the node condition, e.g. Ready or MemoryPressure",
+	}
+	LabelScrapeErrorReason = LabelDescriptor{
+		Key:         "reason",
+		Description: "Reason of the failed scrapes of heapster/scrape_errors metrics: timeout, decode or request",
+	}
+	LabelPodPhase = LabelDescriptor{
+		Key:         "phase",
+		Description: "Phase of the pod of pod/phase metrics, e.g. Pending or Running",
+	}
+	LabelDeploymentName = LabelDescriptor{
+		Key:         "deployment_name",
+		Description: "Name of the deployment of deployment metrics",
+	}
+	LabelSidecar = LabelDescriptor{
+		Key:         "sidecar",
+		Description: "Set to true on the sidecar containers, e.g. service mesh proxies, and on the pod metrics of their usage",
+	}
+	LabelContainerRuntime = LabelDescriptor{
+		Key:         "container_runtime",
+		Description: "Runtime running the container (docker, containerd, cri-o etc.)",
+	}
+	LabelNodeDeleted = LabelDescriptor{
+		Key:         "node_deleted",
+		Description: "Set to true on the last known metrics of a deleted node, exported during the deleted node grace period",
+	}
+	LabelClusterName = LabelDescriptor{
+		Key:         "cluster_name",
+		Description: "Member cluster of the metrics scraped from a federated Heapster",
+	}
+	LabelImageName = LabelDescriptor{
+		Key:         "image_name",
+		Description: "Name of the image run in the container, without tag and digest",
+	}
+	LabelImageTag = LabelDescriptor{
+		Key:         "image_tag",
+		Description: "Tag of the image run in the container",
+	}
+	LabelImageDigest = LabelDescriptor{
+		Key:         "image_digest",
+		Description: "Digest of the image run in the container, e.g. sha256:...",
+	}
+	LabelPersistentVolumeClaimName = LabelDescriptor{
+		Key:         "pvc_name",
+		Description: "Name of the persistent volume claim backing the pod volume",
+	}
+	LabelPersistentVolumeName = LabelDescriptor{
+		Key:         "pv_name",
+		Description: "Name of the persistent volume bound to the claim",
+	}
+	LabelStorageClass = LabelDescriptor{
+		Key:         "storage_class",
+		Description: "Storage class of the persistent volume claim",
+	}
 )
 
 type LabelDescriptor struct {
@@ -119,11 +187,19 @@ var commonLabels = []LabelDescriptor{
 	LabelNodename,
 	LabelHostname,
 	LabelHostID,
+	LabelClusterName,
+	LabelNodeDeleted,
 }
 
 var containerLabels = []LabelDescriptor{
 	LabelContainerName,
 	LabelContainerBaseImage,
+	LabelContainerTerminated,
+	LabelContainerRuntime,
+	LabelSidecar,
+	LabelImageName,
+	LabelImageTag,
+	LabelImageDigest,
 }
 
 var podLabels = []LabelDescriptor{
@@ -135,8 +211,19 @@ var podLabels = []LabelDescriptor{
 
 var metricLabels = []LabelDescriptor{
 	LabelResourceID,
+	LabelEventReason,
+	LabelEventType,
 }
 
+var volumeLabels = []LabelDescriptor{
+	LabelPersistentVolumeClaimName,
+	LabelPersistentVolumeName,
+	LabelStorageClass,
+}
+
+// Labels of the filesystem metrics, which identify the persistent volume of pod volumes.
+var filesystemLabels = append(append([]LabelDescriptor{}, metricLabels...), volumeLabels...)
+
 var customMetricLabels = []LabelDescriptor{
 	LabelCustomMetricName,
 }
@@ -191,9 +278,17 @@ func MetricLabels() []LabelDescriptor {
 	return result
 }
 
+func VolumeLabels() []LabelDescriptor {
+	result := make([]LabelDescriptor, len(volumeLabels))
+	copy(result, volumeLabels)
+	return result
+}
+
 func SupportedLabels() []LabelDescriptor {
 	result := CommonLabels()
 	result = append(result, PodLabels()...)
+	result = append(result, EntityLabelDescriptors()...)
+	result = append(result, VolumeLabels()...)
 	return append(result, MetricLabels()...)
 }
 
@@ -16,6 +16,7 @@ package core
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	cadvisor "github.com/google/cadvisor/info/v1"
@@ -36,6 +37,7 @@ var StandardMetrics = []Metric{
 	MetricMemoryRSS,
 	MetricMemoryCache,
 	MetricMemoryWorkingSet,
+	MetricMemoryCommitted,
 	MetricMemoryPageFaults,
 	MetricMemoryMajorPageFaults,
 	MetricNetworkRx,
@@ -88,6 +90,10 @@ var LabeledMetrics = []Metric{
 	MetricAcceleratorMemoryTotal,
 	MetricAcceleratorMemoryUsed,
 	MetricAcceleratorDutyCycle,
+	MetricEventCount,
+	MetricNodeCondition,
+	MetricNodeConditionLastTransitionTime,
+	MetricScrapeErrors,
 }
 
 var NodeAutoscalingMetrics = []Metric{
@@ -103,6 +109,31 @@ var NodeAutoscalingMetrics = []Metric{
 	MetricNodeCpuReservation,
 	MetricNodeMemoryReservation,
 	MetricNodeEphemeralStorageReservation,
+	MetricNodeEphemeralStorageAvailable,
+}
+
+// Totals over the accelerators attached to a container, aggregated like the other usage metrics.
+var AcceleratorMetrics = []Metric{
+	MetricGpuCount,
+	MetricGpuUsage,
+	MetricGpuMemoryUsed,
+	MetricGpuMemoryTotal,
+}
+
+// Counts of objects present on a node, provided by Kubelet.
+var NodeCountMetrics = []Metric{
+	MetricNodePodCount,
+	MetricNodeContainerCount,
+	MetricNodeImageCount,
+}
+
+// State of the Kubernetes objects, provided by the kubernetes.state source.
+var ObjectStateMetrics = []Metric{
+	MetricPodPhase,
+	MetricContainerReady,
+	MetricDeploymentDesiredReplicas,
+	MetricDeploymentAvailableReplicas,
+	MetricDeploymentUpdatedReplicas,
 }
 
 var CpuMetrics = []Metric{
@@ -134,6 +165,7 @@ var MemoryMetrics = []Metric{
 	MetricMemoryRSS,
 	MetricMemoryCache,
 	MetricMemoryWorkingSet,
+	MetricMemoryCommitted,
 	MetricNodeMemoryAllocatable,
 	MetricNodeMemoryCapacity,
 	MetricNodeMemoryUtilization,
@@ -187,8 +219,34 @@ func MetricFamilyForName(metricName string) MetricFamily {
 	return MetricFamilyGeneral
 }
 
-var AllMetrics = append(append(append(append(StandardMetrics, AdditionalMetrics...), RateMetrics...), LabeledMetrics...),
-	NodeAutoscalingMetrics...)
+var AllMetrics = append(append(append(append(append(append(append(StandardMetrics, AdditionalMetrics...), RateMetrics...), LabeledMetrics...),
+	NodeAutoscalingMetrics...), NodeCountMetrics...), AcceleratorMetrics...), ObjectStateMetrics...)
+
+var metricDescriptorsByName = map[string]MetricDescriptor{}
+
+func init() {
+	for _, metric := range AllMetrics {
+		metricDescriptorsByName[metric.Name] = metric.MetricDescriptor
+	}
+	metricDescriptorsByName[MetricRestartCount.Name] = MetricRestartCount.MetricDescriptor
+}
+
+// MetricDescriptorForName returns the descriptor of the metric with the given name, which
+// holds its description, type and units, and false for unknown metrics, e.g. custom metrics.
+func MetricDescriptorForName(name string) (MetricDescriptor, bool) {
+	descriptor, found := metricDescriptorsByName[name]
+	return descriptor, found
+}
+
+// MetricDescriptors returns the descriptors of all known metrics, sorted by name.
+func MetricDescriptors() []MetricDescriptor {
+	result := make([]MetricDescriptor, 0, len(metricDescriptorsByName))
+	for _, descriptor := range metricDescriptorsByName {
+		result = append(result, descriptor)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
 
 // Definition of Standard Metrics.
 var MetricUptime = Metric{
@@ -343,6 +401,18 @@ var MetricMemoryWorkingSet = Metric{
 	},
 }
 
+// Only reported by Windows nodes, which account memory by commit charge rather than RSS. cAdvisor
+// does not run on Windows, so the metric has no value in the kubelet source.
+var MetricMemoryCommitted = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "memory/committed",
+		Description: "Committed memory, i.e. the memory reserved by the processes in RAM or in the page file. Windows only",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsBytes,
+	},
+}
+
 var MetricMemoryPageFaults = Metric{
 	MetricDescriptor: MetricDescriptor{
 		Name:        "memory/page_faults",
@@ -729,8 +799,186 @@ var MetricNodeEphemeralStorageReservation = Metric{
 	},
 }
 
+var MetricNodeEphemeralStorageAvailable = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "ephemeral_storage/node_available",
+		Description: "Ephemeral storage available on a node",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsBytes,
+	},
+}
+
+var MetricNodePodCount = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "node/pod_count",
+		Description: "Number of pods running on a node",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+	},
+}
+
+var MetricNodeContainerCount = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "node/container_count",
+		Description: "Number of pod containers running on a node",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+	},
+}
+
+var MetricNodeImageCount = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "node/image_count",
+		Description: "Number of container images present on a node",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+	},
+}
+
+var MetricGpuCount = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "gpu/count",
+		Description: "Number of accelerators attached",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+	},
+}
+
+var MetricGpuUsage = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "gpu/usage",
+		Description: "Sum of the duty cycles of the attached accelerators in percent, i.e. 100 per fully busy accelerator",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+	},
+}
+
+var MetricGpuMemoryUsed = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "gpu/memory_used",
+		Description: "Memory allocated on the attached accelerators (in bytes)",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsBytes,
+	},
+}
+
+var MetricGpuMemoryTotal = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "gpu/memory_total",
+		Description: "Total memory of the attached accelerators (in bytes)",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsBytes,
+	},
+}
+
 // Labeled metrics
 
+var MetricNodeCondition = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "node/condition",
+		Description: "Whether a condition of a node, e.g. Ready or DiskPressure, is true (1) or not (0)",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+		Labels:      []LabelDescriptor{LabelNodeCondition},
+	},
+}
+
+var MetricNodeConditionLastTransitionTime = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "node/condition_last_transition_time",
+		Description: "Time of the last change of a condition of a node in milliseconds since the epoch",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsMilliseconds,
+		Labels:      []LabelDescriptor{LabelNodeCondition},
+	},
+}
+
+var MetricScrapeErrors = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "heapster/scrape_errors",
+		Description: "Cumulative number of failed scrapes of the kubelet of a node since Heapster started, by reason",
+		Type:        MetricCumulative,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+		Labels:      []LabelDescriptor{LabelScrapeErrorReason},
+	},
+}
+
+var MetricPodPhase = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "pod/phase",
+		Description: "Whether a pod is in a phase, e.g. Pending or Running (1), or not (0)",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+		Labels:      []LabelDescriptor{LabelPodPhase},
+	},
+}
+
+var MetricContainerReady = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "container/ready",
+		Description: "Whether a container passes its readiness probe (1) or not (0)",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+	},
+}
+
+var MetricDeploymentDesiredReplicas = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "deployment/desired_replicas",
+		Description: "Number of replicas requested by the spec of a deployment",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+		Labels:      []LabelDescriptor{LabelDeploymentName},
+	},
+}
+
+var MetricDeploymentAvailableReplicas = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "deployment/available_replicas",
+		Description: "Number of available replicas of a deployment, i.e. ready for at least its minReadySeconds",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+		Labels:      []LabelDescriptor{LabelDeploymentName},
+	},
+}
+
+var MetricDeploymentUpdatedReplicas = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "deployment/updated_replicas",
+		Description: "Number of replicas of a deployment running its latest template",
+		Type:        MetricGauge,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+		Labels:      []LabelDescriptor{LabelDeploymentName},
+	},
+}
+
+var MetricEventCount = Metric{
+	MetricDescriptor: MetricDescriptor{
+		Name:        "event/count",
+		Description: "Cumulative number of Kubernetes events in a namespace, by reason and type",
+		Type:        MetricCumulative,
+		ValueType:   ValueInt64,
+		Units:       UnitsCount,
+		Labels:      []LabelDescriptor{LabelEventReason, LabelEventType},
+	},
+}
+
 var MetricFilesystemUsage = Metric{
 	MetricDescriptor: MetricDescriptor{
 		Name:        "filesystem/usage",
@@ -738,7 +986,7 @@ var MetricFilesystemUsage = Metric{
 		Type:        MetricGauge,
 		ValueType:   ValueInt64,
 		Units:       UnitsBytes,
-		Labels:      metricLabels,
+		Labels:      filesystemLabels,
 	},
 	HasLabeledMetric: func(spec *cadvisor.ContainerSpec, stat *cadvisor.ContainerStats) bool {
 		return spec.HasFilesystem
@@ -769,7 +1017,7 @@ var MetricFilesystemLimit = Metric{
 		Type:        MetricGauge,
 		ValueType:   ValueInt64,
 		Units:       UnitsBytes,
-		Labels:      metricLabels,
+		Labels:      filesystemLabels,
 	},
 	HasLabeledMetric: func(spec *cadvisor.ContainerSpec, stat *cadvisor.ContainerStats) bool {
 		return spec.HasFilesystem
@@ -800,7 +1048,7 @@ var MetricFilesystemAvailable = Metric{
 		Type:        MetricGauge,
 		ValueType:   ValueInt64,
 		Units:       UnitsBytes,
-		Labels:      metricLabels,
+		Labels:      filesystemLabels,
 	},
 	HasLabeledMetric: func(spec *cadvisor.ContainerSpec, stat *cadvisor.ContainerStats) bool {
 		return spec.HasFilesystem
@@ -831,7 +1079,7 @@ var MetricFilesystemInodes = Metric{
 		Type:        MetricGauge,
 		ValueType:   ValueInt64,
 		Units:       UnitsBytes,
-		Labels:      metricLabels,
+		Labels:      filesystemLabels,
 	},
 	HasLabeledMetric: func(spec *cadvisor.ContainerSpec, stat *cadvisor.ContainerStats) bool {
 		return spec.HasFilesystem
@@ -864,7 +1112,7 @@ var MetricFilesystemInodesFree = Metric{
 		Type:        MetricGauge,
 		ValueType:   ValueInt64,
 		Units:       UnitsBytes,
-		Labels:      metricLabels,
+		Labels:      filesystemLabels,
 	},
 	HasLabeledMetric: func(spec *cadvisor.ContainerSpec, stat *cadvisor.ContainerStats) bool {
 		return spec.HasFilesystem
@@ -16,6 +16,8 @@ package core
 
 import (
 	"fmt"
+	"strings"
+	"time"
 )
 
 // MetricsSet keys are inside of DataBatch. The structure of the returned string is
@@ -31,10 +33,66 @@ func PodKey(namespace, podName string) string {
 	return fmt.Sprintf("namespace:%s/pod:%s", namespace, podName)
 }
 
+// PodKeyFunc constructs the key of a pod MetricSet. The pod UID may be empty if it is not known.
+type PodKeyFunc func(namespace, podName, podUID string) string
+
+// Pod key functions that can be selected with SetPodKeyFunc.
+var PodKeyFuncs = map[string]PodKeyFunc{
+	// Pods are identified by namespace and name only, so a recreated pod continues the
+	// history of the previous pod with the same name.
+	"name": func(namespace, podName, podUID string) string {
+		return PodKey(namespace, podName)
+	},
+	// Pods are identified by their UID in addition to namespace and name.
+	"uid": func(namespace, podName, podUID string) string {
+		if podUID == "" {
+			return PodKey(namespace, podName)
+		}
+		return fmt.Sprintf("namespace:%s/pod:%s/uid:%s", namespace, podName, podUID)
+	},
+}
+
+var podKeyFunc = PodKeyFuncs["name"]
+
+// SetPodKeyFunc overrides how the keys of pod and pod container MetricSets are constructed.
+// It must be called before any metrics are scraped.
+func SetPodKeyFunc(f PodKeyFunc) {
+	podKeyFunc = f
+}
+
+// PodKeyWithUID returns the key of a pod MetricSet using the configured PodKeyFunc.
+// All components which know the pod UID should use it instead of PodKey.
+func PodKeyWithUID(namespace, podName, podUID string) string {
+	return podKeyFunc(namespace, podName, podUID)
+}
+
+// PodContainerKeyWithUID returns the key of a pod container MetricSet using the configured PodKeyFunc.
+func PodContainerKeyWithUID(namespace, podName, podUID, containerName string) string {
+	return ContainerKeyForPod(podKeyFunc(namespace, podName, podUID), containerName)
+}
+
+// ContainerKeyForPod returns the key of a container MetricSet within the pod with the given key.
+func ContainerKeyForPod(podKey, containerName string) string {
+	return fmt.Sprintf("%s/container:%s", podKey, containerName)
+}
+
+// TerminatedContainerKey returns the key of the MetricSet of a terminated instance of the
+// container with the given key, started at startTime.
+func TerminatedContainerKey(containerKey string, startTime time.Time) string {
+	return fmt.Sprintf("%s/started:%d", containerKey, startTime.Unix())
+}
+
 func NamespaceKey(namespace string) string {
 	return fmt.Sprintf("namespace:%s", namespace)
 }
 
+// InNamespace reports whether key is the key of the given namespace or of a pod or pod container
+// within it.
+func InNamespace(key, namespace string) bool {
+	namespaceKey := NamespaceKey(namespace)
+	return key == namespaceKey || strings.HasPrefix(key, namespaceKey+"/")
+}
+
 func NodeKey(node string) string {
 	return fmt.Sprintf("node:%s", node)
 }
@@ -46,3 +104,16 @@ func NodeContainerKey(node, container string) string {
 func ClusterKey() string {
 	return "cluster"
 }
+
+// FederatedKey returns the key of a metric set scraped from the Heapster of a member cluster,
+// given its key in that cluster.
+func FederatedKey(cluster, key string) string {
+	return fmt.Sprintf("cluster:%s/%s", cluster, key)
+}
+
+// IsFederated reports whether the metric set was scraped from the Heapster of a member cluster,
+// which enriched and aggregated it already.
+func IsFederated(metricSet *MetricSet) bool {
+	_, found := metricSet.Labels[LabelClusterName.Key]
+	return found
+}
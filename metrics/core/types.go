@@ -15,6 +15,8 @@
 package core
 
 import (
+	"fmt"
+	"math/rand"
 	"time"
 )
 
@@ -133,8 +135,48 @@ type MetricSet struct {
 
 type DataBatch struct {
 	Timestamp time.Time
+	// ID of the scrape cycle that produced the batch. It is included in the log lines
+	// related to the batch, so that data missing in a sink can be traced back to the scrape.
+	ID string
 	// Should use key functions from ms_keys.go
 	MetricSets map[string]*MetricSet
+	// Set for batches holding the metric sets of only some objects, e.g. the batches scraped
+	// between two regular ones for the objects whose resolution is boosted.
+	Partial bool
+	// Set if the replicas of Heapster scraping the cluster are identified, so that consumers of
+	// the sinks receiving the batches of several replicas can keep a single copy.
+	DedupKey *DedupKey
+	// Set on the parts of a batch exported to a sink in several calls, e.g. by decreasing
+	// priority, so that sinks replacing their state with each batch can reassemble it. The parts
+	// have the ID of the batch.
+	Part *BatchPart
+}
+
+// BatchPart locates a part of a batch exported in several calls.
+type BatchPart struct {
+	// Position of the part, from 0.
+	Index int
+	// Set on the last part. The parts after one which was not exported in time are dropped, so a
+	// batch may have no last part.
+	Last bool
+}
+
+// NewBatchID returns a new identifier for the scrape cycle ending at the given time.
+func NewBatchID(end time.Time) string {
+	return fmt.Sprintf("%s-%08x", end.UTC().Format("20060102T150405Z"), rand.Uint32())
+}
+
+// DedupKey identifies a batch across the redundant replicas of Heapster scraping the same
+// cluster: the batches of the replicas with the same cluster and timestamp hold the same metrics.
+type DedupKey struct {
+	Cluster   string
+	Replica   string
+	Timestamp time.Time
+}
+
+// String returns the key as cluster/replica/timestamp, e.g. "prod/heapster-1/20171017T120000Z".
+func (this *DedupKey) String() string {
+	return fmt.Sprintf("%s/%s/%s", this.Cluster, this.Replica, this.Timestamp.UTC().Format("20060102T150405Z"))
 }
 
 // A place from where the metrics should be scraped.
@@ -143,6 +185,22 @@ type MetricsSource interface {
 	ScrapeMetrics(start, end time.Time) (*DataBatch, error)
 }
 
+// NodeScraper is implemented by sources able to scrape the metrics of some nodes only, e.g. of
+// the nodes whose resolution is boosted.
+type NodeScraper interface {
+	ScrapeNodeMetrics(start, end time.Time, nodes map[string]bool) (*DataBatch, error)
+}
+
+// DecodeError is returned by sources which received a response they could not decode, e.g. a
+// truncated kubelet response, as opposed to a request which failed.
+type DecodeError struct {
+	Err error
+}
+
+func (this *DecodeError) Error() string {
+	return this.Err.Error()
+}
+
 // Provider of list of sources to be scaped.
 type MetricsSourceProvider interface {
 	GetMetricsSources() []MetricsSource
@@ -158,6 +216,24 @@ type DataSink interface {
 	Stop()
 }
 
+// PartialBatchSink is implemented by sinks able to export the batches holding the metric sets
+// of only some objects, see DataBatch.Partial. The other sinks only get whole batches.
+type PartialBatchSink interface {
+	DataSink
+	// AcceptsPartialBatches returns whether the sink should get partial batches.
+	AcceptsPartialBatches() bool
+}
+
+// DeduplicatingDataSink is implemented by sinks which store a batch exported more than once
+// only once, e.g. because its points are keyed by their timestamp. Exports to such sinks can
+// be journaled and retried until they are acknowledged.
+type DeduplicatingDataSink interface {
+	DataSink
+	// ExportDataWithAck exports the batch like ExportData and returns an error if it was not
+	// stored completely.
+	ExportDataWithAck(*DataBatch) error
+}
+
 type DataProcessor interface {
 	Name() string
 	Process(*DataBatch) (*DataBatch, error)
@@ -0,0 +1,312 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package e2e runs the scrape, process and export pipeline of Heapster against a live cluster,
+// typically a local kind cluster, and checks that the batches are complete and exported in time.
+// The batches stored by the sinks implementing ReadBackSink are read back and checked too, which
+// makes `heapster e2e` a conformance check of those sinks. It backs both the integration tests and
+// `heapster e2e`.
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	DefaultCycles           = 3
+	DefaultResolution       = 15 * time.Second
+	DefaultMaxScrapeLatency = 10 * time.Second
+	DefaultMaxExportLatency = 10 * time.Second
+)
+
+// Expectations are the metric sets every complete batch holds.
+type Expectations struct {
+	// Names of the nodes.
+	Nodes []string
+	// Running pods, as namespace/name.
+	Pods []string
+}
+
+// ExpectationsFromCluster returns the ready nodes and the running pods of the cluster.
+func ExpectationsFromCluster(client kube_client.Interface) (*Expectations, error) {
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	pods, err := client.CoreV1().Pods(kube_api.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	expected := &Expectations{}
+	for _, node := range nodes.Items {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == kube_api.NodeReady && condition.Status == kube_api.ConditionTrue {
+				expected.Nodes = append(expected.Nodes, node.Name)
+			}
+		}
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == kube_api.PodRunning {
+			expected.Pods = append(expected.Pods, pod.Namespace+"/"+pod.Name)
+		}
+	}
+	sort.Strings(expected.Nodes)
+	sort.Strings(expected.Pods)
+	return expected, nil
+}
+
+// ReadBackSink is a sink whose stored batches can be read back, so that the checks run on what it
+// stored rather than on what it was given.
+type ReadBackSink interface {
+	core.DataSink
+	// ReadBack returns the batch of the timestamp as stored by the sink.
+	ReadBack(timestamp time.Time) (*core.DataBatch, error)
+}
+
+// Harness runs a number of cycles of the pipeline, as Heapster does every resolution.
+type Harness struct {
+	Source     core.MetricsSource
+	Processors []core.DataProcessor
+	// Sinks under test. The batches are also exported to a recording sink, which the checks
+	// are run on, and read back from the sinks implementing ReadBackSink to be checked as well.
+	Sinks            []core.DataSink
+	Cycles           int
+	Resolution       time.Duration
+	MaxScrapeLatency time.Duration
+	MaxExportLatency time.Duration
+}
+
+// CycleReport holds the measures of a cycle.
+type CycleReport struct {
+	Timestamp     time.Time
+	MetricSets    int
+	ScrapeLatency time.Duration
+	// Latency of the export by sink name.
+	ExportLatency map[string]time.Duration
+}
+
+// Report is the result of a run. The run passed if there is no failure.
+type Report struct {
+	Cycles   []CycleReport
+	Failures []string
+	// Names of the sinks which cannot be read back, of which only the export was checked.
+	NotReadBack []string
+}
+
+func (this *Report) Passed() bool {
+	return len(this.Failures) == 0
+}
+
+func (this *Report) fail(format string, args ...interface{}) {
+	failure := fmt.Sprintf(format, args...)
+	glog.Warningf("e2e check failed: %s", failure)
+	this.Failures = append(this.Failures, failure)
+}
+
+func (this *Report) String() string {
+	var buffer bytes.Buffer
+	for i, cycle := range this.Cycles {
+		fmt.Fprintf(&buffer, "cycle %d: %d metric sets, scraped in %s", i+1, cycle.MetricSets, cycle.ScrapeLatency)
+		names := make([]string, 0, len(cycle.ExportLatency))
+		for name := range cycle.ExportLatency {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&buffer, ", exported to %s in %s", name, cycle.ExportLatency[name])
+		}
+		buffer.WriteString("\n")
+	}
+	for _, name := range this.NotReadBack {
+		fmt.Fprintf(&buffer, "%s cannot be read back, its stored batches were not checked\n", name)
+	}
+	if this.Passed() {
+		buffer.WriteString("PASS\n")
+		return buffer.String()
+	}
+	for _, failure := range this.Failures {
+		fmt.Fprintf(&buffer, "FAIL: %s\n", failure)
+	}
+	return buffer.String()
+}
+
+// recordingSink keeps the last batch it received.
+type recordingSink struct {
+	sync.Mutex
+	batch *core.DataBatch
+}
+
+func (this *recordingSink) Name() string {
+	return "e2e recording sink"
+}
+
+func (this *recordingSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+	this.batch = batch
+}
+
+func (this *recordingSink) Stop() {}
+
+func (this *recordingSink) lastBatch() *core.DataBatch {
+	this.Lock()
+	defer this.Unlock()
+	return this.batch
+}
+
+// Run runs the cycles and checks the batches against the expectations: every cycle exports the
+// nodes and the cluster, and the last one, which has rates, every pod. The same checks run on the
+// batches read back from the sinks implementing ReadBackSink. The sinks are stopped at the end.
+func (this *Harness) Run(expected *Expectations) *Report {
+	report := &Report{}
+	recorder := &recordingSink{}
+	sinks := append([]core.DataSink{recorder}, this.Sinks...)
+	for _, sink := range this.Sinks {
+		if _, ok := sink.(ReadBackSink); !ok {
+			report.NotReadBack = append(report.NotReadBack, sink.Name())
+		}
+	}
+
+	end := time.Now().Truncate(this.Resolution)
+	for cycle := 1; cycle <= this.Cycles; cycle++ {
+		if cycle > 1 {
+			end = end.Add(this.Resolution)
+			time.Sleep(end.Sub(time.Now()))
+		}
+		cycleReport := CycleReport{Timestamp: end, ExportLatency: map[string]time.Duration{}}
+
+		start := time.Now()
+		batch, err := this.Source.ScrapeMetrics(end.Add(-this.Resolution), end)
+		cycleReport.ScrapeLatency = time.Since(start)
+		if err != nil {
+			report.fail("cycle %d: scrape failed: %v", cycle, err)
+			report.Cycles = append(report.Cycles, cycleReport)
+			continue
+		}
+		if cycleReport.ScrapeLatency > this.MaxScrapeLatency {
+			report.fail("cycle %d: scrape took %s, more than %s", cycle, cycleReport.ScrapeLatency, this.MaxScrapeLatency)
+		}
+		for _, processor := range this.Processors {
+			if batch, err = processor.Process(batch); err != nil {
+				report.fail("cycle %d: processor %s failed: %v", cycle, processor.Name(), err)
+				break
+			}
+		}
+		if err != nil {
+			report.Cycles = append(report.Cycles, cycleReport)
+			continue
+		}
+		cycleReport.MetricSets = len(batch.MetricSets)
+
+		for _, sink := range sinks {
+			latency, err := export(sink, batch)
+			if err != nil {
+				report.fail("cycle %d: sink %s failed: %v", cycle, sink.Name(), err)
+			} else if latency > this.MaxExportLatency {
+				report.fail("cycle %d: export to %s took %s, more than %s", cycle, sink.Name(), latency, this.MaxExportLatency)
+			}
+			if sink != recorder {
+				cycleReport.ExportLatency[sink.Name()] = latency
+			}
+		}
+		final := cycle == this.Cycles
+		checkBatch(report, fmt.Sprintf("cycle %d", cycle), recorder.lastBatch(), expected, final)
+		for _, sink := range this.Sinks {
+			readBack, ok := sink.(ReadBackSink)
+			if !ok {
+				continue
+			}
+			context := fmt.Sprintf("cycle %d: sink %s", cycle, sink.Name())
+			stored, err := readBack.ReadBack(batch.Timestamp)
+			if err != nil {
+				report.fail("%s: read back failed: %v", context, err)
+				continue
+			}
+			checkBatch(report, context, stored, expected, final)
+		}
+		report.Cycles = append(report.Cycles, cycleReport)
+	}
+
+	for _, sink := range this.Sinks {
+		sink.Stop()
+	}
+	return report
+}
+
+// export exports the batch to the sink, turning a panic of the sink into an error.
+func export(sink core.DataSink, batch *core.DataBatch) (latency time.Duration, err error) {
+	start := time.Now()
+	defer func() {
+		latency = time.Since(start)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	sink.ExportData(batch)
+	return
+}
+
+// checkBatch records the metric sets missing from the batch, prefixing the failures with the
+// context. Rates and pods are only checked when final is set, as the first cycles have no rate yet
+// and may miss pods which just started.
+func checkBatch(report *Report, context string, batch *core.DataBatch, expected *Expectations, final bool) {
+	if batch == nil {
+		report.fail("%s: no batch was exported", context)
+		return
+	}
+	nodeMetrics := []string{core.MetricCpuUsage.Name, core.MetricMemoryUsage.Name}
+	if final {
+		nodeMetrics = append(nodeMetrics, core.MetricCpuUsageRate.Name)
+	}
+	for _, node := range expected.Nodes {
+		checkMetricSet(report, context, "node "+node, batch.MetricSets[core.NodeKey(node)], nodeMetrics)
+	}
+	checkMetricSet(report, context, "cluster", batch.MetricSets[core.ClusterKey()], []string{core.MetricMemoryUsage.Name})
+	if !final {
+		return
+	}
+
+	pods := map[string]*core.MetricSet{}
+	for _, metricSet := range batch.MetricSets {
+		if metricSet.Labels[core.LabelMetricSetType.Key] == core.MetricSetTypePod {
+			pods[metricSet.Labels[core.LabelNamespaceName.Key]+"/"+metricSet.Labels[core.LabelPodName.Key]] = metricSet
+		}
+	}
+	for _, pod := range expected.Pods {
+		checkMetricSet(report, context, "pod "+pod, pods[pod], []string{core.MetricMemoryUsage.Name})
+	}
+}
+
+func checkMetricSet(report *Report, context string, name string, metricSet *core.MetricSet, metrics []string) {
+	if metricSet == nil {
+		report.fail("%s: no metric set for %s", context, name)
+		return
+	}
+	for _, metric := range metrics {
+		if _, found := metricSet.MetricValues[metric]; !found {
+			report.fail("%s: no %s for %s", context, metric, name)
+		}
+	}
+}
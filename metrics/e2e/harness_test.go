@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/processors"
+)
+
+// fakeSource reports a node and a pod, with a growing CPU usage.
+type fakeSource struct {
+	scrapes   int
+	startTime time.Time
+}
+
+func (this *fakeSource) Name() string { return "fake" }
+
+func (this *fakeSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	this.scrapes++
+	usage := func(cpu int64) map[string]core.MetricValue {
+		return map[string]core.MetricValue{
+			core.MetricCpuUsage.Name:    {MetricType: core.MetricCumulative, ValueType: core.ValueInt64, IntValue: cpu},
+			core.MetricMemoryUsage.Name: {MetricType: core.MetricGauge, ValueType: core.ValueInt64, IntValue: 1000},
+		}
+	}
+	startTime := this.startTime
+	return &core.DataBatch{
+		Timestamp: end,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node-1"): {
+				CollectionStartTime: startTime,
+				ScrapeTime:          end,
+				MetricValues:        usage(int64(this.scrapes) * 1000000000),
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					core.LabelNodename.Key:      "node-1",
+				},
+			},
+			core.PodKey("default", "web"): {
+				CollectionStartTime: startTime,
+				ScrapeTime:          end,
+				MetricValues:        usage(int64(this.scrapes) * 1000),
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelNamespaceName.Key: "default",
+					core.LabelPodName.Key:       "web",
+					core.LabelNodename.Key:      "node-1",
+				},
+			},
+		},
+	}, nil
+}
+
+type panickingSink struct{}
+
+func (this *panickingSink) Name() string                     { return "panicking" }
+func (this *panickingSink) ExportData(batch *core.DataBatch) { panic("broken sink") }
+func (this *panickingSink) Stop()                            {}
+
+// lossySink stores the batches without their pod metric sets.
+type lossySink struct {
+	batches map[time.Time]*core.DataBatch
+}
+
+func (this *lossySink) Name() string { return "lossy" }
+func (this *lossySink) Stop()        {}
+
+func (this *lossySink) ExportData(batch *core.DataBatch) {
+	stored := &core.DataBatch{Timestamp: batch.Timestamp, MetricSets: map[string]*core.MetricSet{}}
+	for key, metricSet := range batch.MetricSets {
+		if metricSet.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePod {
+			stored.MetricSets[key] = metricSet
+		}
+	}
+	this.batches[batch.Timestamp] = stored
+}
+
+func (this *lossySink) ReadBack(timestamp time.Time) (*core.DataBatch, error) {
+	if batch, found := this.batches[timestamp]; found {
+		return batch, nil
+	}
+	return nil, fmt.Errorf("no batch of %s", timestamp)
+}
+
+func newHarness(sinks ...core.DataSink) *Harness {
+	return &Harness{
+		Source: &fakeSource{startTime: time.Now().Add(-time.Hour)},
+		Processors: []core.DataProcessor{
+			processors.NewRateCalculator(core.RateMetricsMapping),
+			&processors.NamespaceAggregator{MetricsToAggregate: []string{core.MetricMemoryUsage.Name}},
+			&processors.ClusterAggregator{MetricsToAggregate: []string{core.MetricMemoryUsage.Name}},
+		},
+		Sinks:            sinks,
+		Cycles:           2,
+		Resolution:       10 * time.Millisecond,
+		MaxScrapeLatency: time.Second,
+		MaxExportLatency: time.Second,
+	}
+}
+
+func TestHarnessPasses(t *testing.T) {
+	report := newHarness().Run(&Expectations{Nodes: []string{"node-1"}, Pods: []string{"default/web"}})
+	assert.True(t, report.Passed(), "%v", report.Failures)
+	assert.Equal(t, 2, len(report.Cycles))
+	assert.Equal(t, 4, report.Cycles[1].MetricSets)
+	assert.Contains(t, report.String(), "PASS")
+}
+
+func TestHarnessChecksReadBackBatches(t *testing.T) {
+	report := newHarness(&lossySink{batches: map[time.Time]*core.DataBatch{}}).Run(&Expectations{
+		Nodes: []string{"node-1"},
+		Pods:  []string{"default/web"},
+	})
+	assert.False(t, report.Passed())
+	assert.Equal(t, []string{"cycle 2: sink lossy: no metric set for pod default/web"}, report.Failures)
+	assert.Empty(t, report.NotReadBack)
+}
+
+func TestHarnessFailures(t *testing.T) {
+	report := newHarness(&panickingSink{}).Run(&Expectations{
+		Nodes: []string{"node-1", "node-2"},
+		Pods:  []string{"default/web", "default/db"},
+	})
+	assert.False(t, report.Passed())
+	assert.Equal(t, []string{
+		"cycle 1: sink panicking failed: panic: broken sink",
+		"cycle 1: no metric set for node node-2",
+		"cycle 2: sink panicking failed: panic: broken sink",
+		"cycle 2: no metric set for node node-2",
+		"cycle 2: no metric set for pod default/db",
+	}, report.Failures)
+	assert.Equal(t, []string{"panicking"}, report.NotReadBack)
+	assert.Contains(t, report.String(), "panicking cannot be read back")
+}
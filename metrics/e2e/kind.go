@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The kind configuration of the clusters: the read-only port of the kubelets is enabled, so
+// that the harness scrapes them without the credentials of a service account.
+const kindConfig = `kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+kubeadmConfigPatches:
+- |
+  kind: KubeletConfiguration
+  readOnlyPort: 10255
+`
+
+// KindCluster is a local cluster run by kind, https://kind.sigs.k8s.io, in Docker containers.
+type KindCluster struct {
+	Name       string
+	Kubeconfig string
+	dir        string
+}
+
+// KindAvailable returns whether the kind command is installed.
+func KindAvailable() bool {
+	_, err := exec.LookPath("kind")
+	return err == nil
+}
+
+// CreateKindCluster creates a cluster with the node image, the default image of kind if empty,
+// and waits up to wait for its nodes to be ready.
+func CreateKindCluster(name, nodeImage string, wait time.Duration) (*KindCluster, error) {
+	dir, err := ioutil.TempDir("", "heapster-e2e")
+	if err != nil {
+		return nil, err
+	}
+	cluster := &KindCluster{Name: name, Kubeconfig: filepath.Join(dir, "kubeconfig"), dir: dir}
+	config := filepath.Join(dir, "kind.yaml")
+	if err := ioutil.WriteFile(config, []byte(kindConfig), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	args := []string{"create", "cluster", "--name", name, "--config", config,
+		"--kubeconfig", cluster.Kubeconfig, "--wait", wait.String()}
+	if nodeImage != "" {
+		args = append(args, "--image", nodeImage)
+	}
+	glog.Infof("Creating kind cluster %s", name)
+	if out, err := exec.Command("kind", args...).CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create kind cluster %s: %v, output: %s", name, err, out)
+	}
+	return cluster, nil
+}
+
+// Delete deletes the cluster and its kubeconfig.
+func (this *KindCluster) Delete() error {
+	defer os.RemoveAll(this.dir)
+	glog.Infof("Deleting kind cluster %s", this.Name)
+	if out, err := exec.Command("kind", "delete", "cluster", "--name", this.Name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete kind cluster %s: %v, output: %s", this.Name, err, out)
+	}
+	return nil
+}
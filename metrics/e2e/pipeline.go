@@ -0,0 +1,143 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	kube_client "k8s.io/client-go/kubernetes"
+
+	"k8s.io/heapster/common/flags"
+	kube_config "k8s.io/heapster/common/kubernetes"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/processors"
+	"k8s.io/heapster/metrics/sinks"
+	"k8s.io/heapster/metrics/sources"
+	"k8s.io/heapster/metrics/util"
+)
+
+// Processors of the default pipeline of the harness: the rates and aggregations of Heapster,
+// without the processors configured by flags.
+var defaultProcessors = []string{
+	"rate", "pod_enricher", "namespace_enricher",
+	"pod_aggregator", "namespace_aggregator", "node_aggregator", "cluster_aggregator",
+}
+
+// KubeletSource returns the summary source of the cluster of the kubeconfig, scraping the
+// read-only port of the kubelets as enabled by CreateKindCluster.
+func KubeletSource(kubeconfig string) (flags.Uri, error) {
+	uri := flags.Uri{}
+	err := uri.Set("kubernetes.summary_api:?inClusterConfig=false&auth=" + url.QueryEscape(kubeconfig))
+	return uri, err
+}
+
+// Options configure the pipeline of NewHarness, as the flags of the same name configure Heapster.
+type Options struct {
+	Sources flags.Uris
+	// Processors, the default pipeline of the harness if empty.
+	Processors       flags.Uris
+	Sinks            flags.Uris
+	Cycles           int
+	Resolution       time.Duration
+	MaxScrapeLatency time.Duration
+	MaxExportLatency time.Duration
+}
+
+// NewHarness builds the pipeline of the options. The first source is also used to reach the API
+// server, which the expectations are listed from.
+func NewHarness(opts Options) (*Harness, kube_client.Interface, error) {
+	if len(opts.Sources) == 0 {
+		return nil, nil, fmt.Errorf("at least one source is required")
+	}
+	if opts.Cycles < 2 {
+		return nil, nil, fmt.Errorf("at least 2 cycles are required to compute rates, got %d", opts.Cycles)
+	}
+	if opts.Resolution <= 0 {
+		return nil, nil, fmt.Errorf("resolution must be positive, got %s", opts.Resolution)
+	}
+	kubernetesUrl := &opts.Sources[0].Val
+	kubeConfig, err := kube_config.GetKubeClientConfig(kubernetesUrl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure the kubernetes client: %v", err)
+	}
+	kubeClient, err := kube_client.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the kubernetes client: %v", err)
+	}
+
+	providers, err := sources.NewSourceFactory().BuildAll(opts.Sources)
+	if err != nil {
+		return nil, nil, err
+	}
+	managers := make([]sources.SourceManager, 0, len(providers))
+	for i, provider := range providers {
+		timeout, err := sources.GetScrapeTimeout(opts.Sources[i], opts.MaxScrapeLatency)
+		if err != nil {
+			return nil, nil, err
+		}
+		manager, err := sources.NewSourceManager(provider, timeout, 0, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		managers = append(managers, manager)
+	}
+	var source core.MetricsSource = managers[0]
+	if len(managers) > 1 {
+		source = sources.NewMergingSourceManager(managers)
+	}
+
+	podLister, err := util.GetSharedPodLister(kubernetesUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	labelCopier, err := util.NewLabelCopier(",", nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var factory *processors.ProcessorFactory
+	factory = processors.NewProcessorFactory(kubernetesUrl, podLister, labelCopier, func() ([]core.DataProcessor, error) {
+		uris := flags.Uris{}
+		for _, key := range defaultProcessors {
+			uris = append(uris, flags.Uri{Key: key})
+		}
+		return factory.BuildAll(uris)
+	})
+	dataProcessors, err := factory.BuildAll(opts.Processors)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sinkFactory := sinks.NewSinkFactory(kubernetesUrl)
+	sinkList := make([]core.DataSink, 0, len(opts.Sinks))
+	for _, uri := range opts.Sinks {
+		sink, err := sinkFactory.Build(uri)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create %v sink: %v", uri, err)
+		}
+		sinkList = append(sinkList, sink)
+	}
+
+	return &Harness{
+		Source:           source,
+		Processors:       dataProcessors,
+		Sinks:            sinkList,
+		Cycles:           opts.Cycles,
+		Resolution:       opts.Resolution,
+		MaxScrapeLatency: opts.MaxScrapeLatency,
+		MaxExportLatency: opts.MaxExportLatency,
+	}, kubeClient, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	goflag "flag"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/pflag"
+
+	"k8s.io/apiserver/pkg/util/logs"
+	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/e2e"
+)
+
+const e2eCommand = "e2e"
+
+// runE2e implements `heapster e2e`, which runs the pipeline against a cluster, a new kind cluster
+// unless --source is given, and checks the batches exported to the sinks. It returns the exit
+// code of the process: 0 if all checks passed, 1 otherwise.
+func runE2e(args []string) int {
+	fs := pflag.NewFlagSet(e2eCommand, pflag.ExitOnError)
+	fs.AddGoFlagSet(goflag.CommandLine)
+	opts := e2e.Options{}
+	fs.Var(&opts.Sources, "source", "Source(s) to scrape, configured as for the heapster command. Defaults to the kubelets of a new kind cluster")
+	fs.Var(&opts.Processors, "processor", "Processor(s) the batches go through, configured as for the heapster command")
+	fs.Var(&opts.Sinks, "sink", "Sink(s) under test, configured as for the heapster command")
+	fs.IntVar(&opts.Cycles, "cycles", e2e.DefaultCycles, "Number of scrape cycles, at least 2")
+	fs.DurationVar(&opts.Resolution, "resolution", e2e.DefaultResolution, "Time between two cycles")
+	fs.DurationVar(&opts.MaxScrapeLatency, "max_scrape_latency", e2e.DefaultMaxScrapeLatency, "Longest accepted scrape of all sources")
+	fs.DurationVar(&opts.MaxExportLatency, "max_export_latency", e2e.DefaultMaxExportLatency, "Longest accepted export to a sink")
+	kindCluster := fs.String("kind_cluster", "heapster-e2e", "Name of the kind cluster created when no --source is given")
+	kindImage := fs.String("kind_image", "", "Node image of the kind cluster, the default image of kind if empty")
+	keepCluster := fs.Bool("keep_cluster", false, "Do not delete the kind cluster at the end")
+	fs.Parse(args)
+	logs.InitLogs()
+	defer logs.FlushLogs()
+
+	if len(opts.Sources) == 0 {
+		if !e2e.KindAvailable() {
+			glog.Errorf("Either --source or the kind command is required")
+			return 2
+		}
+		cluster, err := e2e.CreateKindCluster(*kindCluster, *kindImage, 2*time.Minute)
+		if err != nil {
+			glog.Errorf("%v", err)
+			return 1
+		}
+		if !*keepCluster {
+			defer cluster.Delete()
+		}
+		source, err := e2e.KubeletSource(cluster.Kubeconfig)
+		if err != nil {
+			glog.Errorf("%v", err)
+			return 1
+		}
+		opts.Sources = flags.Uris{source}
+	}
+
+	harness, kubeClient, err := e2e.NewHarness(opts)
+	if err != nil {
+		glog.Errorf("Failed to create the pipeline: %v", err)
+		return 2
+	}
+	expected, err := e2e.ExpectationsFromCluster(kubeClient)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return 1
+	}
+	report := harness.Run(expected)
+	fmt.Print(report)
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
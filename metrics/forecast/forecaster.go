@@ -0,0 +1,348 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forecast projects the cpu and memory usage of the cluster and its node pools into
+// the future with a linear regression over their hourly history.
+package forecast
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+)
+
+// Resolution of the usage history.
+const bucketSize = time.Hour
+
+// ResourceForecast is the forecast of a single resource.
+type ResourceForecast struct {
+	// Average usage in the latest hour.
+	Current float64
+	// Usage projected to the end of the forecast period.
+	Projected float64
+	// Change of the usage per day.
+	SlopePerDay float64
+	// Current allocatable capacity. Zero if unknown.
+	Allocatable int64
+}
+
+// Forecast is the forecast of the whole cluster, or of a node pool if NodePool is set.
+type Forecast struct {
+	NodePool string
+	// Number of hourly averages the forecast is based on.
+	Samples int
+	// CPU in millicores.
+	Cpu ResourceForecast
+	// Memory in bytes.
+	Memory ResourceForecast
+}
+
+type point struct {
+	timestamp time.Time
+	value     float64
+}
+
+type hourlySum struct {
+	cpu     float64
+	memory  float64
+	batches int
+}
+
+type groupUsage struct {
+	// Sums of the usage of all nodes of the group, by the start of the hour.
+	hours             map[time.Time]*hourlySum
+	cpuAllocatable    int64
+	memoryAllocatable int64
+}
+
+// Forecaster is a sink recording the hourly average of the summed cpu usage rate and memory
+// usage of all nodes, for the cluster and for each node pool. Nodes belong to the node pool
+// named by the value of their node pool label. If a historical source is set, the history is
+// read from it instead, so forecasts survive restarts and can be based on a longer history.
+type Forecaster struct {
+	lock          sync.Mutex
+	nodeLister    v1listers.NodeLister
+	nodePoolLabel string
+	history       time.Duration
+	historical    core.HistoricalSource
+	// Usage by node pool. The empty node pool is the whole cluster.
+	groups  map[string]*groupUsage
+	nowFunc func() time.Time
+}
+
+func NewForecaster(nodeLister v1listers.NodeLister, nodePoolLabel string, history time.Duration) *Forecaster {
+	return &Forecaster{
+		nodeLister:    nodeLister,
+		nodePoolLabel: nodePoolLabel,
+		history:       history,
+		groups:        map[string]*groupUsage{},
+		nowFunc:       time.Now,
+	}
+}
+
+// SetHistoricalSource makes forecasts use the history stored in the given source.
+func (this *Forecaster) SetHistoricalSource(historical core.HistoricalSource) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.historical = historical
+}
+
+func (this *Forecaster) Name() string {
+	return "Forecaster"
+}
+
+func (this *Forecaster) Stop() {
+	// Do nothing.
+}
+
+func (this *Forecaster) ExportData(batch *core.DataBatch) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	// Usage and allocatable capacity summed up over the nodes of each group.
+	type total struct {
+		cpu, memory                       float64
+		cpuAllocatable, memoryAllocatable int64
+	}
+	totals := map[string]*total{}
+	for _, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypeNode {
+			continue
+		}
+		groups := []string{""}
+		if pool := this.nodePool(ms.Labels[core.LabelNodename.Key]); pool != "" {
+			groups = append(groups, pool)
+		}
+		for _, group := range groups {
+			t, found := totals[group]
+			if !found {
+				t = &total{}
+				totals[group] = t
+			}
+			t.cpu += float64(ms.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+			t.memory += float64(ms.MetricValues[core.MetricMemoryUsage.Name].IntValue)
+			t.cpuAllocatable += int64(ms.MetricValues[core.MetricNodeCpuAllocatable.Name].FloatValue)
+			t.memoryAllocatable += int64(ms.MetricValues[core.MetricNodeMemoryAllocatable.Name].FloatValue)
+		}
+	}
+
+	hour := batch.Timestamp.Truncate(bucketSize)
+	for group, t := range totals {
+		usage, found := this.groups[group]
+		if !found {
+			usage = &groupUsage{hours: map[time.Time]*hourlySum{}}
+			this.groups[group] = usage
+		}
+		sum, found := usage.hours[hour]
+		if !found {
+			sum = &hourlySum{}
+			usage.hours[hour] = sum
+		}
+		sum.cpu += t.cpu
+		sum.memory += t.memory
+		sum.batches++
+		usage.cpuAllocatable = t.cpuAllocatable
+		usage.memoryAllocatable = t.memoryAllocatable
+	}
+
+	cutoff := batch.Timestamp.Add(-this.history)
+	for group, usage := range this.groups {
+		for hour := range usage.hours {
+			if hour.Before(cutoff) {
+				delete(usage.hours, hour)
+			}
+		}
+		if len(usage.hours) == 0 {
+			delete(this.groups, group)
+		}
+	}
+}
+
+func (this *Forecaster) nodePool(nodeName string) string {
+	if this.nodePoolLabel == "" || nodeName == "" {
+		return ""
+	}
+	node, err := this.nodeLister.Get(nodeName)
+	if err != nil {
+		return ""
+	}
+	return node.Labels[this.nodePoolLabel]
+}
+
+// GetForecasts projects the usage of the cluster and of all node pools the given duration
+// ahead. The cluster comes first, followed by the node pools ordered by name.
+func (this *Forecaster) GetForecasts(ahead time.Duration) ([]Forecast, error) {
+	this.lock.Lock()
+	historical := this.historical
+	cpuHistory, memoryHistory := this.recordedHistory()
+	allocatable := map[string][2]int64{}
+	for group, usage := range this.groups {
+		allocatable[group] = [2]int64{usage.cpuAllocatable, usage.memoryAllocatable}
+	}
+	this.lock.Unlock()
+
+	if historical != nil {
+		var err error
+		if cpuHistory, memoryHistory, err = this.storedHistory(historical); err != nil {
+			return nil, err
+		}
+	}
+
+	groups := make([]string, 0, len(cpuHistory))
+	for group := range cpuHistory {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	target := this.nowFunc().Add(ahead)
+	result := make([]Forecast, 0, len(groups))
+	for _, group := range groups {
+		forecast := Forecast{
+			NodePool: group,
+			Samples:  len(cpuHistory[group]),
+			Cpu:      project(cpuHistory[group], target),
+			Memory:   project(memoryHistory[group], target),
+		}
+		forecast.Cpu.Allocatable = allocatable[group][0]
+		forecast.Memory.Allocatable = allocatable[group][1]
+		result = append(result, forecast)
+	}
+	return result, nil
+}
+
+// recordedHistory returns the hourly averages recorded by the sink, ordered by time. Must be
+// called with the lock held.
+func (this *Forecaster) recordedHistory() (map[string][]point, map[string][]point) {
+	cpu := map[string][]point{}
+	memory := map[string][]point{}
+	for group, usage := range this.groups {
+		for hour, sum := range usage.hours {
+			cpu[group] = append(cpu[group], point{hour, sum.cpu / float64(sum.batches)})
+			memory[group] = append(memory[group], point{hour, sum.memory / float64(sum.batches)})
+		}
+		sort.Sort(byTimestamp(cpu[group]))
+		sort.Sort(byTimestamp(memory[group]))
+	}
+	return cpu, memory
+}
+
+// storedHistory reads the hourly averages of all nodes from the historical source and sums
+// them up by node pool.
+func (this *Forecaster) storedHistory(historical core.HistoricalSource) (map[string][]point, map[string][]point, error) {
+	nodes, err := historical.GetNodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]core.HistoricalKey, 0, len(nodes))
+	nodePools := map[string]string{}
+	for _, node := range nodes {
+		keys = append(keys, core.HistoricalKey{ObjectType: core.MetricSetTypeNode, NodeName: node})
+		nodePools[node] = this.nodePool(node)
+	}
+
+	end := this.nowFunc()
+	start := end.Add(-this.history)
+	result := []map[string][]point{}
+	for _, metric := range []string{core.MetricCpuUsageRate.Name, core.MetricMemoryUsage.Name} {
+		aggregations, err := historical.GetAggregation(metric, []core.AggregationType{core.AggregationTypeAverage},
+			keys, start, end, bucketSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		sums := map[string]map[time.Time]float64{}
+		for key, values := range aggregations {
+			groups := []string{""}
+			if pool := nodePools[key.NodeName]; pool != "" {
+				groups = append(groups, pool)
+			}
+			for _, value := range values {
+				average, found := value.Aggregations[core.AggregationTypeAverage]
+				if !found {
+					continue
+				}
+				for _, group := range groups {
+					if sums[group] == nil {
+						sums[group] = map[time.Time]float64{}
+					}
+					sums[group][value.Timestamp] += toFloat(average)
+				}
+			}
+		}
+		history := map[string][]point{}
+		for group, buckets := range sums {
+			for timestamp, sum := range buckets {
+				history[group] = append(history[group], point{timestamp, sum})
+			}
+			sort.Sort(byTimestamp(history[group]))
+		}
+		result = append(result, history)
+	}
+	glog.V(4).Infof("Read the history of %d nodes for forecasting", len(nodes))
+	return result[0], result[1], nil
+}
+
+func toFloat(value core.MetricValue) float64 {
+	if value.ValueType == core.ValueFloat {
+		return value.FloatValue
+	}
+	return float64(value.IntValue)
+}
+
+// project fits a line through the points and evaluates it at target. With less than two
+// points the latest value is carried forward.
+func project(points []point, target time.Time) ResourceForecast {
+	if len(points) == 0 {
+		return ResourceForecast{}
+	}
+	current := points[len(points)-1].value
+	forecast := ResourceForecast{Current: current, Projected: current}
+	if len(points) < 2 {
+		return forecast
+	}
+
+	origin := points[0].timestamp
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		x := p.timestamp.Sub(origin).Hours()
+		sumX += x
+		sumY += p.value
+		sumXY += x * p.value
+		sumXX += x * x
+	}
+	n := float64(len(points))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return forecast
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+
+	forecast.SlopePerDay = slope * 24
+	forecast.Projected = intercept + slope*target.Sub(origin).Hours()
+	if forecast.Projected < 0 {
+		forecast.Projected = 0
+	}
+	return forecast
+}
+
+type byTimestamp []point
+
+func (p byTimestamp) Len() int           { return len(p) }
+func (p byTimestamp) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byTimestamp) Less(i, j int) bool { return p[i].timestamp.Before(p[j].timestamp) }
@@ -0,0 +1,150 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forecast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/core"
+)
+
+const poolLabel = "pool"
+
+func newNodeLister(t *testing.T) v1listers.NodeLister {
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, store.Add(&kube_api.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{poolLabel: "default"}}}))
+	require.NoError(t, store.Add(&kube_api.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{poolLabel: "highmem"}}}))
+	return v1listers.NewNodeLister(store)
+}
+
+func nodeBatch(timestamp time.Time, cpu, memory int64) *core.DataBatch {
+	batch := &core.DataBatch{Timestamp: timestamp, MetricSets: map[string]*core.MetricSet{}}
+	for _, node := range []string{"node1", "node2"} {
+		batch.MetricSets[core.NodeKey(node)] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+				core.LabelNodename.Key:      node,
+			},
+			MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsageRate.Name:          {IntValue: cpu, ValueType: core.ValueInt64},
+				core.MetricMemoryUsage.Name:           {IntValue: memory, ValueType: core.ValueInt64},
+				core.MetricNodeCpuAllocatable.Name:    {FloatValue: 4000, ValueType: core.ValueFloat},
+				core.MetricNodeMemoryAllocatable.Name: {FloatValue: 1 << 30, ValueType: core.ValueFloat},
+			},
+		}
+	}
+	return batch
+}
+
+func TestProject(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	assert.Equal(t, ResourceForecast{}, project(nil, start))
+	assert.Equal(t, ResourceForecast{Current: 5, Projected: 5}, project([]point{{start, 5}}, start.Add(time.Hour)))
+
+	forecast := project([]point{{start, 10}, {start.Add(time.Hour), 12}, {start.Add(2 * time.Hour), 14}}, start.Add(24*time.Hour))
+	assert.InDelta(t, 14, forecast.Current, 1e-9)
+	assert.InDelta(t, 58, forecast.Projected, 1e-9)
+	assert.InDelta(t, 48, forecast.SlopePerDay, 1e-9)
+
+	// Usage never drops below zero.
+	forecast = project([]point{{start, 10}, {start.Add(time.Hour), 0}}, start.Add(24*time.Hour))
+	assert.Equal(t, float64(0), forecast.Projected)
+}
+
+func TestForecastsFromRecordedHistory(t *testing.T) {
+	start := time.Unix(1500000000, 0).Truncate(time.Hour)
+	forecaster := NewForecaster(newNodeLister(t), poolLabel, 24*time.Hour)
+	forecaster.nowFunc = func() time.Time { return start.Add(47 * time.Hour) }
+
+	for hour := 0; hour < 48; hour++ {
+		for minute := 0; minute < 60; minute += 30 {
+			timestamp := start.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+			forecaster.ExportData(nodeBatch(timestamp, int64(100+hour), 1<<20))
+		}
+	}
+
+	forecasts, err := forecaster.GetForecasts(24 * time.Hour)
+	require.NoError(t, err)
+	require.Len(t, forecasts, 3)
+	assert.Equal(t, []string{"", "default", "highmem"}, []string{forecasts[0].NodePool, forecasts[1].NodePool, forecasts[2].NodePool})
+
+	cluster := forecasts[0]
+	// Only the last day is kept.
+	assert.Equal(t, 24, cluster.Samples)
+	assert.InDelta(t, 2*147, cluster.Cpu.Current, 1e-6)
+	assert.InDelta(t, 2*(147+24), cluster.Cpu.Projected, 1e-6)
+	assert.InDelta(t, 2*24, cluster.Cpu.SlopePerDay, 1e-6)
+	assert.Equal(t, int64(8000), cluster.Cpu.Allocatable)
+	assert.InDelta(t, 2<<20, cluster.Memory.Projected, 1e-6)
+	assert.Equal(t, int64(2<<30), cluster.Memory.Allocatable)
+
+	pool := forecasts[1]
+	assert.InDelta(t, 147+24, pool.Cpu.Projected, 1e-6)
+	assert.Equal(t, int64(4000), pool.Cpu.Allocatable)
+}
+
+type fakeHistoricalSource struct {
+	core.HistoricalSource
+	start time.Time
+}
+
+func (f *fakeHistoricalSource) GetNodes() ([]string, error) {
+	return []string{"node1", "node2"}, nil
+}
+
+func (f *fakeHistoricalSource) GetAggregation(metricName string, aggregations []core.AggregationType, metricKeys []core.HistoricalKey,
+	start, end time.Time, bucketSize time.Duration) (map[core.HistoricalKey][]core.TimestampedAggregationValue, error) {
+	result := map[core.HistoricalKey][]core.TimestampedAggregationValue{}
+	for _, key := range metricKeys {
+		for day := 0; day < 10; day++ {
+			value := core.MetricValue{IntValue: int64(100 + 10*day), ValueType: core.ValueInt64}
+			if metricName == core.MetricMemoryUsage.Name {
+				value = core.MetricValue{FloatValue: 1 << 20, ValueType: core.ValueFloat}
+			}
+			result[key] = append(result[key], core.TimestampedAggregationValue{
+				Timestamp:  f.start.Add(time.Duration(day) * 24 * time.Hour),
+				BucketSize: bucketSize,
+				AggregationValue: core.AggregationValue{
+					Aggregations: map[core.AggregationType]core.MetricValue{core.AggregationTypeAverage: value},
+				},
+			})
+		}
+	}
+	return result, nil
+}
+
+func TestForecastsFromHistoricalSource(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	forecaster := NewForecaster(newNodeLister(t), poolLabel, 30*24*time.Hour)
+	forecaster.nowFunc = func() time.Time { return start.Add(9 * 24 * time.Hour) }
+	forecaster.SetHistoricalSource(&fakeHistoricalSource{start: start})
+
+	forecasts, err := forecaster.GetForecasts(24 * time.Hour)
+	require.NoError(t, err)
+	require.Len(t, forecasts, 3)
+	assert.Equal(t, 10, forecasts[0].Samples)
+	assert.InDelta(t, 2*190, forecasts[0].Cpu.Current, 1e-6)
+	assert.InDelta(t, 2*200, forecasts[0].Cpu.Projected, 1e-6)
+	assert.InDelta(t, 2<<20, forecasts[0].Memory.Projected, 1e-6)
+	assert.InDelta(t, 200, forecasts[2].Cpu.Projected, 1e-6)
+}
@@ -15,23 +15,38 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/pprof"
 	"strings"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"k8s.io/heapster/metrics/api/v1"
 	metricsApi "k8s.io/heapster/metrics/apis/metrics"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/forecast"
+	"k8s.io/heapster/metrics/idle"
+	"k8s.io/heapster/metrics/manager"
+	"k8s.io/heapster/metrics/processors"
+	"k8s.io/heapster/metrics/recommender"
 	metricsink "k8s.io/heapster/metrics/sinks/metric"
+	"k8s.io/heapster/metrics/sources"
 	"k8s.io/heapster/metrics/util/metrics"
+	"k8s.io/heapster/metrics/util/profiling"
 
 	v1listers "k8s.io/client-go/listers/core/v1"
 )
 
-const pprofBasePath = "/debug/pprof/"
+const (
+	pprofBasePath    = "/debug/pprof/"
+	profilesBasePath = "/debug/profiles/"
+)
 
-func setupHandlers(metricSink *metricsink.MetricSink, podLister v1listers.PodLister, nodeLister v1listers.NodeLister, historicalSource core.HistoricalSource, disableMetricExport bool) http.Handler {
+func setupHandlers(metricSink *metricsink.MetricSink, podLister v1listers.PodLister, nodeLister v1listers.NodeLister, historicalSource core.HistoricalSource, disableMetricExport bool, responseCacheTTL time.Duration,
+	recommender *recommender.Recommender, idleDetector *idle.Detector, forecaster *forecast.Forecaster,
+	validator *processors.Validator, sourceManager sources.SourceManager, boosts *manager.ResolutionBoosts, profiles *profiling.Recorder) http.Handler {
 
 	runningInKubernetes := true
 
@@ -40,6 +55,23 @@ func setupHandlers(metricSink *metricsink.MetricSink, podLister v1listers.PodLis
 	wsContainer.EnableContentEncoding(true)
 	wsContainer.Router(restful.CurlyRouter{})
 	a := v1.NewApi(runningInKubernetes, metricSink, historicalSource, disableMetricExport)
+	a.EnableResponseCache(responseCacheTTL)
+	if recommender != nil {
+		a.EnableRecommendations(recommender)
+	}
+	if idleDetector != nil {
+		a.EnableIdleWorkloads(idleDetector)
+	}
+	if forecaster != nil {
+		a.EnableForecasts(forecaster)
+	}
+	if validator != nil {
+		a.EnableQuarantine(validator)
+	}
+	a.EnableSources(sourceManager)
+	if boosts != nil {
+		a.EnableResolutionBoosts(boosts)
+	}
 	a.Register(wsContainer)
 	// Metrics API
 	m := metricsApi.NewApi(metricSink, podLister, nodeLister)
@@ -64,5 +96,30 @@ func setupHandlers(metricSink *metricsink.MetricSink, podLister v1listers.PodLis
 	ws.Route(ws.GET("/{subpath:*}").To(metrics.InstrumentRouteFunc("pprof", handlePprofEndpoint))).Doc("pprof endpoint")
 	wsContainer.Add(ws)
 
+	// Setup the handlers of the captured profiles.
+	if profiles != nil {
+		listProfiles := func(req *restful.Request, resp *restful.Response) {
+			resp.Header().Set("Content-Type", restful.MIME_JSON)
+			if err := json.NewEncoder(resp).Encode(profiles.List()); err != nil {
+				resp.WriteError(http.StatusInternalServerError, err)
+			}
+		}
+		getProfile := func(req *restful.Request, resp *restful.Response) {
+			name := req.PathParameter("name")
+			data, found := profiles.Get(name)
+			if !found {
+				resp.WriteErrorString(http.StatusNotFound, fmt.Sprintf("profile %s not found", name))
+				return
+			}
+			resp.Header().Set("Content-Type", "application/octet-stream")
+			resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			resp.Write(data)
+		}
+		ws := new(restful.WebService).Path(profilesBasePath)
+		ws.Route(ws.GET("/").To(metrics.InstrumentRouteFunc("profiles", listProfiles)).Doc("list the captured profiles"))
+		ws.Route(ws.GET("/{name}").To(metrics.InstrumentRouteFunc("profile", getProfile)).Doc("download a captured profile"))
+		wsContainer.Add(ws)
+	}
+
 	return wsContainer
 }
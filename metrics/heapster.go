@@ -17,13 +17,16 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
@@ -33,30 +36,48 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
 
-	kube_api "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/apiserver/pkg/util/flag"
 	"k8s.io/apiserver/pkg/util/logs"
 	kube_client "k8s.io/client-go/kubernetes"
+	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1beta1"
 	v1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/heapster/common/flags"
 	kube_config "k8s.io/heapster/common/kubernetes"
+	events_core "k8s.io/heapster/events/core"
+	events_manager "k8s.io/heapster/events/manager"
+	events_policy "k8s.io/heapster/events/policy"
+	events_sinks "k8s.io/heapster/events/sinks"
+	kube_events "k8s.io/heapster/events/sources/kubernetes"
 	"k8s.io/heapster/metrics/cmd/heapster-apiserver/app"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/forecast"
+	"k8s.io/heapster/metrics/idle"
 	"k8s.io/heapster/metrics/manager"
 	"k8s.io/heapster/metrics/options"
 	"k8s.io/heapster/metrics/processors"
+	"k8s.io/heapster/metrics/recommender"
 	"k8s.io/heapster/metrics/sinks"
 	metricsink "k8s.io/heapster/metrics/sinks/metric"
 	"k8s.io/heapster/metrics/sources"
 	"k8s.io/heapster/metrics/util"
+	"k8s.io/heapster/metrics/util/capture"
+	"k8s.io/heapster/metrics/util/openmetrics"
+	"k8s.io/heapster/metrics/util/profiling"
 	"k8s.io/heapster/version"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == replayCommand {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == e2eCommand {
+		os.Exit(runE2e(os.Args[2:]))
+	}
+
 	opt := options.NewHeapsterRunOptions()
 	opt.AddFlags(pflag.CommandLine)
 
@@ -72,33 +93,123 @@ func main() {
 
 	labelCopier, err := util.NewLabelCopier(opt.LabelSeparator, opt.StoredLabels, opt.IgnoredLabels)
 	if err != nil {
-		glog.Fatalf("Failed to initialize label copier: %v", err)
+		fail(failureFlags, "failed to initialize label copier: %v", err)
 	}
 
 	setMaxProcs(opt)
 	glog.Infof(strings.Join(os.Args, " "))
 	glog.Infof("Heapster version %v", version.HeapsterVersion)
 	if err := validateFlags(opt); err != nil {
-		glog.Fatal(err)
+		fail(failureFlags, "%v", err)
 	}
 
+	core.SetPodKeyFunc(core.PodKeyFuncs[opt.PodKeyScheme])
+
 	kubernetesUrl, err := getKubernetesAddress(opt.Sources)
 	if err != nil {
-		glog.Fatalf("Failed to get kubernetes address: %v", err)
+		fail(failureSource, "failed to get kubernetes address: %v", err)
+	}
+	sourceManager := createSourceManagerOrDie(opt.Sources, opt.SourceScrapeTimeout, opt.SourceConcurrency,
+		time.Duration(opt.ScrapeJitter*float64(opt.MetricResolution)), opt.StartupGrace)
+	podLister, nodeLister := getListersOrDie(kubernetesUrl, opt.StartupGrace)
+
+	var extraSinks []core.DataSink
+	var usageRecommender *recommender.Recommender
+	if opt.Recommendations {
+		usageRecommender = recommender.NewRecommender(podLister, opt.RecommendationHistory)
+		extraSinks = append(extraSinks, usageRecommender)
+	}
+	var idleDetector *idle.Detector
+	if opt.IdleWorkloads {
+		idleDetector = idle.NewDetector(podLister, opt.IdleDays, opt.IdleCpuThreshold, opt.IdleNetworkThreshold)
+		extraSinks = append(extraSinks, idleDetector)
+	}
+	var forecaster *forecast.Forecaster
+	if opt.Forecast {
+		forecaster = forecast.NewForecaster(nodeLister, opt.ForecastNodePoolLabel, opt.ForecastHistory)
+		extraSinks = append(extraSinks, forecaster)
+	}
+	if len(opt.CaptureDir) > 0 {
+		capturer, err := capture.Enable(opt.CaptureDir, opt.CaptureBatches, opt.CaptureScrubbedLabels)
+		if err != nil {
+			fail(failureFlags, "failed to start capturing: %v", err)
+		}
+		extraSinks = append(extraSinks, capturer)
+	}
+	sinkManager, metricSink, historicalSource := createAndInitSinksOrDie(kubernetesUrl, opt, extraSinks)
+	if forecaster != nil && historicalSource != nil {
+		forecaster.SetHistoricalSource(historicalSource)
 	}
-	sourceManager := createSourceManagerOrDie(opt.Sources)
-	sinkManager, metricSink, historicalSource := createAndInitSinksOrDie(opt.Sinks, opt.HistoricalSource, opt.SinkExportDataTimeout, opt.DisableMetricSink)
 
-	podLister, nodeLister := getListersOrDie(kubernetesUrl)
-	dataProcessors := createDataProcessorsOrDie(kubernetesUrl, podLister, labelCopier)
+	if metricSink != nil {
+		purgeDeletedNamespacesOrDie(kubernetesUrl, metricSink)
+	}
+	var eventCounter *processors.EventCounter
+	if opt.EventCounts {
+		eventCounter = processors.NewEventCounter(opt.EventCountRetention)
+		deleteNamespaceEventCountsOrDie(kubernetesUrl, eventCounter)
+	}
+	if opt.EventCounts || opt.Eventer {
+		startEventsPipelineOrDie(opt, kubernetesUrl, eventCounter)
+	}
+	var validator *processors.Validator
+	if opt.ValidateMetrics {
+		validator = processors.NewValidator(opt.ValidationMaxClockSkew, opt.ClockSkewPolicy)
+	}
+	var volumeEnricher *processors.VolumeEnricher
+	if opt.PersistentVolumeLabels {
+		if volumeEnricher, err = processors.NewVolumeEnricher(kubernetesUrl, podLister); err != nil {
+			fail(failureKubeConfig, "failed to create VolumeEnricher: %v", err)
+		}
+	}
+	var sidecarClassifier *processors.SidecarClassifier
+	if len(opt.SidecarContainers) > 0 || opt.SidecarAnnotation != "" {
+		if sidecarClassifier, err = processors.NewSidecarClassifier(opt.SidecarContainers, opt.SidecarAnnotation, podLister); err != nil {
+			fail(failureFlags, "failed to create SidecarClassifier: %v", err)
+		}
+	}
+	// The processors of the default pipeline fail on the access to the API server, the others on
+	// their flags.
+	processorFailure := failureFlags
+	processorFactory := processors.NewProcessorFactory(kubernetesUrl, podLister, labelCopier, func() ([]core.DataProcessor, error) {
+		dataProcessors, err := createDataProcessors(kubernetesUrl, podLister, labelCopier, eventCounter, validator,
+			volumeEnricher, sidecarClassifier, opt.SeparateSidecarUsage)
+		if err != nil {
+			processorFailure = failureKubeConfig
+		}
+		return dataProcessors, err
+	})
+	dataProcessors, err := processorFactory.BuildAll(opt.Processors)
+	if err != nil {
+		fail(processorFailure, "failed to create processors: %v", err)
+	}
+	if opt.DeletedNodeGrace > 0 {
+		// Last, so that the retained metric sets are not aggregated.
+		dataProcessors = append(dataProcessors, processors.NewDeletedNodeRetainer(nodeLister, opt.DeletedNodeGrace))
+	}
 
 	man, err := manager.NewManager(sourceManager, dataProcessors, sinkManager,
-		opt.MetricResolution, manager.DefaultScrapeOffset, manager.DefaultMaxParallelism)
+		opt.MetricResolution, manager.DefaultScrapeOffset, opt.MinParallelism)
 	if err != nil {
-		glog.Fatalf("Failed to create main manager: %v", err)
+		fail(failureInternal, "failed to create main manager: %v", err)
+	}
+	if opt.MaxParallelism > opt.MinParallelism {
+		go wait.Forever(func() { scaleParallelism(man, nodeLister, opt) }, opt.MetricResolution)
+	}
+	if len(opt.DedupCluster) > 0 {
+		man.SetDedupIdentity(opt.DedupCluster, replicaName(opt))
+	}
+	var boosts *manager.ResolutionBoosts
+	if opt.ResolutionBoosts {
+		boosts = manager.NewResolutionBoosts(opt.MetricResolution)
+		man.SetResolutionBoosts(boosts)
 	}
 	man.Start()
 
+	if len(opt.DumpOpenMetrics) > 0 {
+		dumpOpenMetricsAndExit(opt.DumpOpenMetrics, metricSink, opt.MetricResolution)
+	}
+
 	if opt.EnableAPIServer {
 		// Run API server in a separate goroutine
 		createAndRunAPIServer(opt, metricSink, nodeLister, podLister)
@@ -106,7 +217,36 @@ func main() {
 
 	mux := http.NewServeMux()
 	promHandler := prometheus.Handler()
-	handler := setupHandlers(metricSink, podLister, nodeLister, historicalSource, opt.DisableMetricExport)
+	if metricsAuthEnabled(opt) {
+		var tokenReviews authenticationclient.TokenReviewInterface
+		if opt.MetricsTokenReview {
+			tokenReviews = createKubeClientOrDie(kubernetesUrl).AuthenticationV1beta1().TokenReviews()
+		}
+		promHandler, err = newMetricsAuthHandler(opt, tokenReviews, promHandler)
+		if err != nil {
+			fail(failureFlags, "failed to create authenticated prometheus handler: %v", err)
+		}
+	}
+	var responseCacheTTL time.Duration
+	if opt.ModelResponseCache {
+		responseCacheTTL = opt.MetricResolution
+	}
+	var profiles *profiling.Recorder
+	if opt.ProfileInterval > 0 {
+		profiles, err = profiling.NewRecorder(opt.ProfileInterval, opt.ProfileCPUDuration, opt.ProfileRetention, opt.ProfileMinHeapBytes)
+		if err != nil {
+			fail(failureFlags, "failed to start capturing profiles: %v", err)
+		}
+		profiles.Start()
+	}
+	handler := setupHandlers(metricSink, podLister, nodeLister, historicalSource, opt.DisableMetricExport, responseCacheTTL, usageRecommender, idleDetector, forecaster, validator, sourceManager, boosts, profiles)
+	if apiAuthEnabled(opt) {
+		apiHandler, err := newAPIAuthHandler(opt, handler)
+		if err != nil {
+			fail(failureFlags, "failed to create authenticated API handler: %v", err)
+		}
+		mux.Handle(apiPathPrefix, apiHandler)
+	}
 	healthz.InstallHandler(mux, healthzChecker(metricSink))
 
 	addr := net.JoinHostPort(opt.Ip, strconv.Itoa(opt.Port))
@@ -115,10 +255,16 @@ func main() {
 	if len(opt.TLSCertFile) > 0 && len(opt.TLSKeyFile) > 0 {
 		startSecureServing(opt, handler, promHandler, mux, addr)
 	} else {
+		if metricsAuthEnabled(opt) {
+			glog.Warningf("Serving /metrics without TLS, bearer tokens will be sent in clear text")
+		}
+		if apiAuthEnabled(opt) {
+			glog.Warningf("Serving the API without TLS, bearer tokens will be sent in clear text")
+		}
 		mux.Handle("/", handler)
 		mux.Handle("/metrics", promHandler)
 
-		glog.Fatal(http.ListenAndServe(addr, mux))
+		fail(failureServing, "failed to serve on %s: %v", addr, http.ListenAndServe(addr, mux))
 	}
 }
 func createAndRunAPIServer(opt *options.HeapsterRunOptions, metricSink *metricsink.MetricSink,
@@ -134,8 +280,7 @@ func createAndRunAPIServer(opt *options.HeapsterRunOptions, metricSink *metricsi
 
 	runApiServer := func(s *app.HeapsterAPIServer) {
 		if err := s.RunServer(); err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+			fail(failureServing, "failed to run the API server: %v", err)
 		}
 	}
 	glog.Infof("Starting Heapster API server...")
@@ -148,15 +293,18 @@ func startSecureServing(opt *options.HeapsterRunOptions, handler http.Handler, p
 	if len(opt.TLSClientCAFile) > 0 {
 		authPprofHandler, err := newAuthHandler(opt, handler)
 		if err != nil {
-			glog.Fatalf("Failed to create authorized pprof handler: %v", err)
+			fail(failureFlags, "failed to create authorized pprof handler: %v", err)
 		}
 		handler = authPprofHandler
 
-		authPromHandler, err := newAuthHandler(opt, promHandler)
-		if err != nil {
-			glog.Fatalf("Failed to create authorized prometheus handler: %v", err)
+		// Otherwise client certificates were already accepted by the metrics auth handler.
+		if !metricsAuthEnabled(opt) {
+			authPromHandler, err := newAuthHandler(opt, promHandler)
+			if err != nil {
+				fail(failureFlags, "failed to create authorized prometheus handler: %v", err)
+			}
+			promHandler = authPromHandler
 		}
-		promHandler = authPromHandler
 	}
 	mux.Handle("/", handler)
 	mux.Handle("/metrics", promHandler)
@@ -168,126 +316,299 @@ func startSecureServing(opt *options.HeapsterRunOptions, handler http.Handler, p
 			Handler:   mux,
 			TLSConfig: &tls.Config{ClientAuth: tls.RequestClientCert},
 		}
-		glog.Fatal(server.ListenAndServeTLS(opt.TLSCertFile, opt.TLSKeyFile))
+		fail(failureServing, "failed to serve on %s: %v", address, server.ListenAndServeTLS(opt.TLSCertFile, opt.TLSKeyFile))
 	} else {
-		glog.Fatal(http.ListenAndServeTLS(address, opt.TLSCertFile, opt.TLSKeyFile, mux))
+		fail(failureServing, "failed to serve on %s: %v", address, http.ListenAndServeTLS(address, opt.TLSCertFile, opt.TLSKeyFile, mux))
 	}
 }
 
-func createSourceManagerOrDie(src flags.Uris) core.MetricsSource {
-	if len(src) != 1 {
-		glog.Fatal("Wrong number of sources specified")
-	}
+func createSourceManagerOrDie(src flags.Uris, scrapeTimeout time.Duration, concurrency int, jitterWindow time.Duration,
+	grace time.Duration) sources.SourceManager {
 	sourceFactory := sources.NewSourceFactory()
-	sourceProvider, err := sourceFactory.BuildAll(src)
+	var sourceProviders []core.MetricsSourceProvider
+	err := retryStartup(grace, "create the sources", func() (err error) {
+		sourceProviders, err = sourceFactory.BuildAll(src)
+		return err
+	})
 	if err != nil {
-		glog.Fatalf("Failed to create source provide: %v", err)
+		fail(failureSource, "failed to create source provider: %v", err)
 	}
-	sourceManager, err := sources.NewSourceManager(sourceProvider, sources.DefaultMetricsScrapeTimeout)
-	if err != nil {
-		glog.Fatalf("Failed to create source manager: %v", err)
+	managers := make([]sources.SourceManager, 0, len(sourceProviders))
+	for i, sourceProvider := range sourceProviders {
+		// Validated with the flags.
+		timeout, _ := sources.GetScrapeTimeout(src[i], scrapeTimeout)
+		sourceManager, err := sources.NewSourceManager(sourceProvider, timeout, concurrency, jitterWindow)
+		if err != nil {
+			fail(failureInternal, "failed to create source manager: %v", err)
+		}
+		managers = append(managers, sourceManager)
+	}
+	if len(managers) == 1 {
+		return managers[0]
 	}
-	return sourceManager
+	// Sources given first take precedence for metrics reported by several of them.
+	return sources.NewMergingSourceManager(managers)
 }
 
-func createAndInitSinksOrDie(sinkAddresses flags.Uris, historicalSource string, sinkExportDataTimeout time.Duration, disableMetricSink bool) (core.DataSink, *metricsink.MetricSink, core.HistoricalSource) {
-	sinksFactory := sinks.NewSinkFactory()
-	metricSink, sinkList, histSource := sinksFactory.BuildAll(sinkAddresses, historicalSource, disableMetricSink)
-	if metricSink == nil && !disableMetricSink {
-		glog.Fatal("Failed to create metric sink")
+func createAndInitSinksOrDie(kubernetesUrl *url.URL, opt *options.HeapsterRunOptions,
+	extraSinks []core.DataSink) (core.DataSink, *metricsink.MetricSink, core.HistoricalSource) {
+	sinksFactory := sinks.NewSinkFactory(kubernetesUrl)
+	if len(opt.SinkJournalDir) > 0 {
+		sinksFactory.EnableJournal(opt.SinkJournalDir, opt.SinkJournalMaxBatches)
+	}
+	if len(opt.ExportPriorities) > 0 {
+		sinksFactory.EnablePriorities(opt.ExportPriorities, opt.SinkExportDataTimeout)
+	}
+	if len(opt.SensitiveLabels) > 0 {
+		var key []byte
+		if len(opt.SensitiveLabelKeyFile) > 0 {
+			contents, err := ioutil.ReadFile(opt.SensitiveLabelKeyFile)
+			if err != nil {
+				fail(failureFlags, "failed to read the sensitive label key: %v", err)
+			}
+			key = bytes.TrimSpace(contents)
+		}
+		scrubber, err := processors.NewLabelScrubber(opt.SensitiveLabels, key)
+		if err != nil {
+			fail(failureFlags, "failed to create the label scrubber: %v", err)
+		}
+		sinksFactory.EnableLabelScrubbing(scrubber, opt.SensitiveLabelSinks)
 	}
-	if histSource == nil && len(historicalSource) > 0 {
-		glog.Fatal("Failed to use a sink as a historical metrics source")
+	if len(opt.ExportLease) > 0 {
+		parts := strings.Split(opt.ExportLease, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			fail(failureFlags, "invalid export lease %q, expected namespace/name", opt.ExportLease)
+		}
+		configMaps := createKubeClientOrDie(kubernetesUrl).CoreV1().ConfigMaps(parts[0])
+		sinksFactory.EnableExportLease(sinks.NewConfigMapLease(configMaps, parts[1], replicaName(opt)))
+	}
+	var metricSink *metricsink.MetricSink
+	var sinkList []core.DataSink
+	var histSource core.HistoricalSource
+	err := retryStartup(opt.StartupGrace, "create the sinks", func() (err error) {
+		metricSink, sinkList, histSource, err = sinksFactory.BuildAll(opt.Sinks, opt.HistoricalSource, opt.DisableMetricSink)
+		return err
+	})
+	if err != nil {
+		fail(failureSink, "%v", err)
+	}
+	sinkList = append(sinkList, extraSinks...)
+	if metricSink == nil && !opt.DisableMetricSink {
+		fail(failureInternal, "failed to create metric sink")
+	}
+	if histSource == nil && len(opt.HistoricalSource) > 0 {
+		fail(failureSink, "failed to use a sink as a historical metrics source")
 	}
 	for _, sink := range sinkList {
 		glog.Infof("Starting with %s", sink.Name())
 	}
-	sinkManager, err := sinks.NewDataSinkManager(sinkList, sinkExportDataTimeout, sinks.DefaultSinkStopTimeout)
+	sinkManager, err := sinks.NewDataSinkManager(sinkList, opt.SinkExportDataTimeout, sinks.DefaultSinkStopTimeout)
 	if err != nil {
-		glog.Fatalf("Failed to create sink manager: %v", err)
+		fail(failureInternal, "failed to create sink manager: %v", err)
 	}
 	return sinkManager, metricSink, histSource
 }
 
-func getListersOrDie(kubernetesUrl *url.URL) (v1listers.PodLister, v1listers.NodeLister) {
-	kubeClient := createKubeClientOrDie(kubernetesUrl)
-
-	podLister, err := getPodLister(kubeClient)
+// replicaName returns the name identifying this replica among the redundant ones.
+func replicaName(opt *options.HeapsterRunOptions) string {
+	if len(opt.ReplicaName) > 0 {
+		return opt.ReplicaName
+	}
+	hostname, err := os.Hostname()
 	if err != nil {
-		glog.Fatalf("Failed to create podLister: %v", err)
+		fail(failureFlags, "failed to get the hostname, set --replica_name: %v", err)
 	}
-	nodeLister, _, err := util.GetNodeLister(kubeClient)
+	return hostname
+}
+
+func getListersOrDie(kubernetesUrl *url.URL, grace time.Duration) (v1listers.PodLister, v1listers.NodeLister) {
+	var podLister v1listers.PodLister
+	var nodeLister v1listers.NodeLister
+	err := retryStartup(grace, "create the listers", func() (err error) {
+		if podLister, err = util.GetSharedPodLister(kubernetesUrl); err != nil {
+			return fmt.Errorf("failed to create podLister: %v", err)
+		}
+		if nodeLister, err = util.GetSharedNodeLister(kubernetesUrl); err != nil {
+			return fmt.Errorf("failed to create nodeLister: %v", err)
+		}
+		return nil
+	})
 	if err != nil {
-		glog.Fatalf("Failed to create nodeLister: %v", err)
+		fail(failureKubeConfig, "%v", err)
 	}
 	return podLister, nodeLister
 }
 
+// purgeDeletedNamespacesOrDie removes the data of deleted namespaces from the metric sink right
+// away, so it is not served by the model API until it expires.
+func purgeDeletedNamespacesOrDie(kubernetesUrl *url.URL, metricSink *metricsink.MetricSink) {
+	err := util.AddNamespaceDeletionHandler(kubernetesUrl, func(namespace string) {
+		glog.V(2).Infof("Namespace %s deleted, removing its metrics", namespace)
+		metricSink.DeleteNamespace(namespace)
+	})
+	if err != nil {
+		fail(failureKubeConfig, "failed to watch namespaces: %v", err)
+	}
+}
+
+// deleteNamespaceEventCountsOrDie drops the event counts of deleted namespaces right away, so
+// they are not exported until they expire.
+func deleteNamespaceEventCountsOrDie(kubernetesUrl *url.URL, eventCounter *processors.EventCounter) {
+	err := util.AddNamespaceDeletionHandler(kubernetesUrl, func(namespace string) {
+		glog.V(2).Infof("Namespace %s deleted, removing its event counts", namespace)
+		eventCounter.DeleteNamespace(namespace)
+	})
+	if err != nil {
+		fail(failureKubeConfig, "failed to watch namespaces: %v", err)
+	}
+}
+
 func createKubeClientOrDie(kubernetesUrl *url.URL) *kube_client.Clientset {
 	kubeConfig, err := kube_config.GetKubeClientConfig(kubernetesUrl)
 	if err != nil {
-		glog.Fatalf("Failed to get client config: %v", err)
+		fail(failureKubeConfig, "failed to get client config: %v", err)
 	}
 	return kube_client.NewForConfigOrDie(kubeConfig)
 }
 
-func createDataProcessorsOrDie(kubernetesUrl *url.URL, podLister v1listers.PodLister, labelCopier *util.LabelCopier) []core.DataProcessor {
-	dataProcessors := []core.DataProcessor{
-		// Convert cumulative to rate
-		processors.NewRateCalculator(core.RateMetricsMapping),
+func createDataProcessors(kubernetesUrl *url.URL, podLister v1listers.PodLister, labelCopier *util.LabelCopier,
+	eventCounter *processors.EventCounter, validator *processors.Validator, volumeEnricher *processors.VolumeEnricher,
+	sidecarClassifier *processors.SidecarClassifier, separateSidecars bool) ([]core.DataProcessor, error) {
+	dataProcessors := []core.DataProcessor{}
+	if validator != nil {
+		// Drop corrupt values before anything is computed from them.
+		dataProcessors = append(dataProcessors, validator)
 	}
+	// Convert cumulative to rate
+	dataProcessors = append(dataProcessors, processors.NewRateCalculator(core.RateMetricsMapping))
 
 	podBasedEnricher, err := processors.NewPodBasedEnricher(podLister, labelCopier)
 	if err != nil {
-		glog.Fatalf("Failed to create PodBasedEnricher: %v", err)
+		return nil, fmt.Errorf("failed to create PodBasedEnricher: %v", err)
 	}
 	dataProcessors = append(dataProcessors, podBasedEnricher)
 
 	namespaceBasedEnricher, err := processors.NewNamespaceBasedEnricher(kubernetesUrl)
 	if err != nil {
-		glog.Fatalf("Failed to create NamespaceBasedEnricher: %v", err)
+		return nil, fmt.Errorf("failed to create NamespaceBasedEnricher: %v", err)
 	}
 	dataProcessors = append(dataProcessors, namespaceBasedEnricher)
 
-	// aggregators
-	metricsToAggregate := []string{
-		core.MetricCpuUsageRate.Name,
-		core.MetricMemoryUsage.Name,
-		core.MetricCpuRequest.Name,
-		core.MetricCpuLimit.Name,
-		core.MetricMemoryRequest.Name,
-		core.MetricMemoryLimit.Name,
+	if volumeEnricher != nil {
+		dataProcessors = append(dataProcessors, volumeEnricher)
 	}
 
-	metricsToAggregateForNode := []string{
-		core.MetricCpuRequest.Name,
-		core.MetricCpuLimit.Name,
-		core.MetricMemoryRequest.Name,
-		core.MetricMemoryLimit.Name,
-		core.MetricEphemeralStorageRequest.Name,
-		core.MetricEphemeralStorageLimit.Name,
+	if sidecarClassifier != nil {
+		dataProcessors = append(dataProcessors, sidecarClassifier)
 	}
 
+	// aggregators
+	metricsToAggregate := processors.DefaultMetricsToAggregate()
+	metricsToAggregateForNode := processors.DefaultNodeMetricsToAggregate()
+
+	podAggregator := processors.NewPodAggregator()
+	podAggregator.SeparateSidecars = separateSidecars
 	dataProcessors = append(dataProcessors,
-		processors.NewPodAggregator(),
+		podAggregator,
 		&processors.NamespaceAggregator{
 			MetricsToAggregate: metricsToAggregate,
-		},
+		})
+
+	if eventCounter != nil {
+		dataProcessors = append(dataProcessors, eventCounter)
+	}
+
+	dataProcessors = append(dataProcessors,
 		&processors.NodeAggregator{
 			MetricsToAggregate: metricsToAggregateForNode,
 		},
 		&processors.ClusterAggregator{
 			MetricsToAggregate: metricsToAggregate,
-		})
+		},
+		// Non-Kubernetes entities registered by sources
+		&processors.EntityAggregator{})
 
 	nodeAutoscalingEnricher, err := processors.NewNodeAutoscalingEnricher(kubernetesUrl, labelCopier)
 	if err != nil {
-		glog.Fatalf("Failed to create NodeAutoscalingEnricher: %v", err)
+		return nil, fmt.Errorf("failed to create NodeAutoscalingEnricher: %v", err)
 	}
 	dataProcessors = append(dataProcessors, nodeAutoscalingEnricher)
-	return dataProcessors
+
+	nodeConditionEnricher, err := processors.NewNodeConditionEnricher(kubernetesUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create NodeConditionEnricher: %v", err)
+	}
+	dataProcessors = append(dataProcessors, nodeConditionEnricher)
+	return dataProcessors, nil
+}
+
+// startEventsPipelineOrDie watches Kubernetes events and exports them to the event counter and,
+// with --eventer, to the event sinks. All consumers share a single watch.
+func startEventsPipelineOrDie(opt *options.HeapsterRunOptions, kubernetesUrl *url.URL, eventCounter *processors.EventCounter) {
+	var eventSource events_core.EventSource
+	err := retryStartup(opt.StartupGrace, "create the event source", func() (err error) {
+		eventSource, err = kube_events.NewKubernetesSource(kubernetesUrl)
+		return err
+	})
+	if err != nil {
+		fail(failureKubeConfig, "failed to create event source: %v", err)
+	}
+
+	sinkList := []events_core.EventSink{}
+	if eventCounter != nil {
+		sinkList = append(sinkList, eventCounter)
+	}
+	if opt.Eventer {
+		sinkFactory := events_sinks.NewSinkFactory()
+		sinkUris := opt.EventSinks
+		if len(sinkUris) == 0 {
+			for _, uri := range opt.Sinks {
+				if sinkFactory.Supports(uri.Key) {
+					sinkUris = append(sinkUris, uri)
+				}
+			}
+		}
+		var policies *events_policy.NamespacePolicies
+		if opt.EventNamespacePolicies {
+			factory, err := util.GetSharedInformerFactory(kubernetesUrl)
+			if err != nil {
+				fail(failureKubeConfig, "failed to watch the namespace policies: %v", err)
+			}
+			namespaces := factory.Core().V1().Namespaces()
+			policies = events_policy.NewNamespacePolicies(namespaces.Lister())
+			factory.Start(wait.NeverStop)
+			// Otherwise the events are exported with the default policy until the namespaces are listed.
+			glog.Infof("Waiting for the namespaces to be listed")
+			cache.WaitForCacheSync(wait.NeverStop, namespaces.Informer().HasSynced)
+		}
+		eventSinks := sinkFactory.BuildAllFiltered(sinkUris, policies)
+		if len(sinkUris) != 0 && len(eventSinks) == 0 {
+			fail(failureSink, "no available event sink to use")
+		}
+		for _, sink := range eventSinks {
+			glog.Infof("Starting with %s event sink", sink.Name())
+		}
+		sinkList = append(sinkList, eventSinks...)
+	}
+
+	sinkManager, err := events_sinks.NewEventSinkManager(sinkList, events_sinks.DefaultSinkExportEventsTimeout,
+		events_sinks.DefaultSinkStopTimeout)
+	if err != nil {
+		fail(failureInternal, "failed to create event sink manager: %v", err)
+	}
+	eventManager, err := events_manager.NewManager(eventSource, sinkManager, opt.EventFrequency)
+	if err != nil {
+		fail(failureInternal, "failed to create event manager: %v", err)
+	}
+	eventManager.Start()
 }
 
+// Bounds of --event_frequency, the same as those of the standalone eventer.
+const (
+	minEventFrequency = 5 * time.Second
+	maxEventFrequency = 3 * time.Minute
+)
+
 const (
 	minMetricsCount = 1
 	maxMetricsDelay = 3 * time.Minute
@@ -324,15 +645,6 @@ func getKubernetesAddress(args flags.Uris) (*url.URL, error) {
 	return nil, fmt.Errorf("No kubernetes source found.")
 }
 
-func getPodLister(kubeClient *kube_client.Clientset) (v1listers.PodLister, error) {
-	lw := cache.NewListWatchFromClient(kubeClient.CoreV1().RESTClient(), "pods", kube_api.NamespaceAll, fields.Everything())
-	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
-	podLister := v1listers.NewPodLister(store)
-	reflector := cache.NewReflector(lw, &kube_api.Pod{}, store, time.Hour)
-	go reflector.Run(wait.NeverStop)
-	return podLister, nil
-}
-
 func validateFlags(opt *options.HeapsterRunOptions) error {
 	if opt.MetricResolution < 5*time.Second {
 		return fmt.Errorf("metric resolution should not be less than 5 seconds - %d", opt.MetricResolution)
@@ -343,9 +655,122 @@ func validateFlags(opt *options.HeapsterRunOptions) error {
 	if len(opt.TLSClientCAFile) > 0 && len(opt.TLSCertFile) == 0 {
 		return fmt.Errorf("client cert authentication requires TLS certificate & key")
 	}
+	if _, found := core.PodKeyFuncs[opt.PodKeyScheme]; !found {
+		return fmt.Errorf("unknown pod key scheme %q", opt.PodKeyScheme)
+	}
+	for _, pattern := range opt.ExportPriorities {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid export priority namespace pattern %q: %v", pattern, err)
+		}
+	}
+	if opt.SourceScrapeTimeout <= 0 {
+		return fmt.Errorf("source scrape timeout must be positive, got %s", opt.SourceScrapeTimeout)
+	}
+	for _, uri := range opt.Sources {
+		if _, err := sources.GetScrapeTimeout(uri, opt.SourceScrapeTimeout); err != nil {
+			return err
+		}
+	}
+	if opt.SourceConcurrency < 0 {
+		return fmt.Errorf("source concurrency must not be negative")
+	}
+	if opt.ScrapeJitter < 0 || opt.ScrapeJitter >= 1 {
+		return fmt.Errorf("scrape jitter must be at least 0 and less than 1, got %v", opt.ScrapeJitter)
+	}
+	if opt.MinParallelism < 1 || opt.MaxParallelism < opt.MinParallelism {
+		return fmt.Errorf("parallelism bounds must satisfy 1 <= min_parallelism <= max_parallelism")
+	}
+	if (opt.Eventer || opt.EventCounts) && (opt.EventFrequency < minEventFrequency || opt.EventFrequency > maxEventFrequency) {
+		return fmt.Errorf("event frequency needs to be between %s and %s", minEventFrequency, maxEventFrequency)
+	}
+	if len(opt.DumpOpenMetrics) > 0 && opt.DisableMetricSink {
+		return fmt.Errorf("dumping metrics requires the metric sink to be enabled")
+	}
+	if opt.EventCounts && opt.EventCountRetention <= 0 {
+		return fmt.Errorf("event count retention must be positive")
+	}
+	if opt.Recommendations && opt.RecommendationHistory <= 0 {
+		return fmt.Errorf("recommendation history must be positive")
+	}
+	if opt.IdleWorkloads && opt.IdleDays < 1 {
+		return fmt.Errorf("idle days must be at least 1")
+	}
+	if opt.Forecast && opt.ForecastHistory < 2*time.Hour {
+		return fmt.Errorf("forecast history must be at least 2 hours")
+	}
+	if opt.ValidateMetrics && opt.ValidationMaxClockSkew < 0 {
+		return fmt.Errorf("validation max clock skew must not be negative")
+	}
+	switch opt.ClockSkewPolicy {
+	case processors.ClockSkewReject, processors.ClockSkewCorrect, processors.ClockSkewAccept:
+	default:
+		return fmt.Errorf("unknown clock skew policy %q, expected reject, correct or accept", opt.ClockSkewPolicy)
+	}
+	if len(opt.SinkJournalDir) > 0 && opt.SinkJournalMaxBatches < 1 {
+		return fmt.Errorf("sink journal max batches must be at least 1")
+	}
+	if len(opt.CaptureDir) > 0 && opt.CaptureBatches < 1 {
+		return fmt.Errorf("capture batches must be at least 1")
+	}
+	if opt.SeparateSidecarUsage && len(opt.SidecarContainers) == 0 && opt.SidecarAnnotation == "" {
+		return fmt.Errorf("separating the sidecar usage requires --sidecar_containers or --sidecar_annotation")
+	}
+	if opt.DeletedNodeGrace < 0 {
+		return fmt.Errorf("deleted node grace must not be negative")
+	}
+	if opt.StartupGrace < 0 {
+		return fmt.Errorf("startup grace must not be negative")
+	}
+	if opt.ProfileInterval > 0 {
+		if opt.ProfileRetention < 1 {
+			return fmt.Errorf("profile capture retention must be at least 1")
+		}
+		if opt.ProfileCPUDuration <= 0 || opt.ProfileCPUDuration >= opt.ProfileInterval {
+			return fmt.Errorf("profile capture CPU duration must be positive and shorter than the profile capture interval")
+		}
+	}
 	return nil
 }
 
+// scaleParallelism adjusts the housekeeping parallelism of the manager to the current number of nodes.
+func scaleParallelism(man manager.Manager, nodeLister v1listers.NodeLister, opt *options.HeapsterRunOptions) {
+	nodes, err := nodeLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Failed to list nodes: %v", err)
+		return
+	}
+	man.SetMaxParallelism(manager.ParallelismForNodes(len(nodes), opt.MinParallelism, opt.MaxParallelism))
+}
+
+// dumpOpenMetricsAndExit waits for the first data batch to reach the metric sink, writes it
+// in OpenMetrics text format to the given file (or stdout for "-") and exits.
+func dumpOpenMetricsAndExit(path string, metricSink *metricsink.MetricSink, resolution time.Duration) {
+	var batch *core.DataBatch
+	err := wait.PollImmediate(time.Second, 3*resolution, func() (bool, error) {
+		batch = metricSink.GetLatestDataBatch()
+		return batch != nil, nil
+	})
+	if err != nil {
+		fail(failureInternal, "no data batch available to dump: %v", err)
+	}
+
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			fail(failureFlags, "failed to create %s: %v", path, err)
+		}
+	}
+	if err := openmetrics.Write(out, batch); err != nil {
+		fail(failureInternal, "failed to dump metrics: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		fail(failureInternal, "failed to dump metrics: %v", err)
+	}
+	glog.Infof("Dumped %d metric sets from batch %s", len(batch.MetricSets), batch.Timestamp)
+	logs.FlushLogs()
+	os.Exit(0)
+}
+
 func setMaxProcs(opt *options.HeapsterRunOptions) {
 	// Allow as many threads as we have cores unless the user specified a value.
 	var numProcs int
@@ -0,0 +1,235 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package idle finds workloads whose cpu and network usage stayed below thresholds for days.
+package idle
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+const dayDuration = 24 * time.Hour
+
+// Workload is an idle pod or the controller of idle pods.
+type Workload struct {
+	Namespace string
+	// Kind of the controller, e.g. Deployment or StatefulSet, or Pod for pods without one.
+	Kind string
+	Name string
+	// Pods of the workload seen during the idle period.
+	Pods []string
+	// Time since which the workload has been observed. It may have been idle for longer.
+	ObservedSince time.Time
+	// Peak usage during the idle period.
+	MaxCpuUsageRate     int64   // millicores
+	MaxNetworkUsageRate float64 // bytes per second, received and transmitted
+}
+
+type dailyPeak struct {
+	cpu     int64
+	network float64
+}
+
+type workloadUsage struct {
+	namespace string
+	kind      string
+	name      string
+	firstSeen time.Time
+	lastSeen  time.Time
+	// Last time each pod was seen, by name.
+	pods map[string]time.Time
+	// Peak usage by the number of the day since the epoch.
+	days map[int64]*dailyPeak
+}
+
+// Detector is a sink recording the daily peak cpu and network usage of all workloads. A
+// workload is idle if it has been observed for the configured number of days and its peak
+// usage stayed below both thresholds on each of them.
+type Detector struct {
+	lock             sync.Mutex
+	podLister        v1listers.PodLister
+	days             int64
+	cpuThreshold     int64
+	networkThreshold float64
+	workloads        map[string]*workloadUsage
+	// Timestamp of the latest batch.
+	latest time.Time
+}
+
+func NewDetector(podLister v1listers.PodLister, days int, cpuThreshold int64, networkThreshold float64) *Detector {
+	return &Detector{
+		podLister:        podLister,
+		days:             int64(days),
+		cpuThreshold:     cpuThreshold,
+		networkThreshold: networkThreshold,
+		workloads:        map[string]*workloadUsage{},
+	}
+}
+
+func (this *Detector) Name() string {
+	return "Idle Workload Detector"
+}
+
+func (this *Detector) Stop() {
+	// Do nothing.
+}
+
+func (this *Detector) ExportData(batch *core.DataBatch) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if batch.Timestamp.After(this.latest) {
+		this.latest = batch.Timestamp
+	}
+	day := dayOf(batch.Timestamp)
+	for _, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePod {
+			continue
+		}
+		namespace := ms.Labels[core.LabelNamespaceName.Key]
+		pod := ms.Labels[core.LabelPodName.Key]
+		kind, name := util.WorkloadOf(this.podLister, namespace, pod)
+		key := namespace + "/" + kind + "/" + name
+
+		usage, found := this.workloads[key]
+		if !found {
+			usage = &workloadUsage{
+				namespace: namespace,
+				kind:      kind,
+				name:      name,
+				firstSeen: batch.Timestamp,
+				pods:      map[string]time.Time{},
+				days:      map[int64]*dailyPeak{},
+			}
+			this.workloads[key] = usage
+		}
+		if batch.Timestamp.After(usage.lastSeen) {
+			usage.lastSeen = batch.Timestamp
+		}
+		usage.pods[pod] = batch.Timestamp
+
+		peak, found := usage.days[day]
+		if !found {
+			peak = &dailyPeak{}
+			usage.days[day] = peak
+		}
+		if cpu, found := ms.MetricValues[core.MetricCpuUsageRate.Name]; found && cpu.IntValue > peak.cpu {
+			peak.cpu = cpu.IntValue
+		}
+		network := ms.MetricValues[core.MetricNetworkRxRate.Name].FloatValue +
+			ms.MetricValues[core.MetricNetworkTxRate.Name].FloatValue
+		if network > peak.network {
+			peak.network = network
+		}
+	}
+	this.expire(batch.Timestamp)
+}
+
+// expire forgets usage from before the idle period and workloads which were not seen during
+// it. Must be called with the lock held.
+func (this *Detector) expire(now time.Time) {
+	today := dayOf(now)
+	cutoff := now.Add(-time.Duration(this.days) * dayDuration)
+	for key, usage := range this.workloads {
+		for day := range usage.days {
+			if day <= today-this.days {
+				delete(usage.days, day)
+			}
+		}
+		for pod, lastSeen := range usage.pods {
+			if lastSeen.Before(cutoff) {
+				delete(usage.pods, pod)
+			}
+		}
+		if len(usage.pods) == 0 {
+			delete(this.workloads, key)
+		}
+	}
+}
+
+// GetIdleWorkloads returns the idle workloads, optionally limited to a namespace, ordered by
+// namespace, kind and name.
+func (this *Detector) GetIdleWorkloads(namespace string) []Workload {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	result := []Workload{}
+	for _, usage := range this.workloads {
+		if namespace != "" && usage.namespace != namespace {
+			continue
+		}
+		if usage.lastSeen.Before(this.latest) {
+			// The workload is gone.
+			continue
+		}
+		if usage.lastSeen.Sub(usage.firstSeen) < time.Duration(this.days)*dayDuration {
+			// Not observed for long enough.
+			continue
+		}
+		workload := Workload{
+			Namespace:     usage.namespace,
+			Kind:          usage.kind,
+			Name:          usage.name,
+			Pods:          make([]string, 0, len(usage.pods)),
+			ObservedSince: usage.firstSeen,
+		}
+		idle := true
+		for _, peak := range usage.days {
+			if peak.cpu >= this.cpuThreshold || peak.network >= this.networkThreshold {
+				idle = false
+				break
+			}
+			if peak.cpu > workload.MaxCpuUsageRate {
+				workload.MaxCpuUsageRate = peak.cpu
+			}
+			if peak.network > workload.MaxNetworkUsageRate {
+				workload.MaxNetworkUsageRate = peak.network
+			}
+		}
+		if !idle {
+			continue
+		}
+		for pod := range usage.pods {
+			workload.Pods = append(workload.Pods, pod)
+		}
+		sort.Strings(workload.Pods)
+		result = append(result, workload)
+	}
+	sort.Sort(byWorkload(result))
+	return result
+}
+
+func dayOf(timestamp time.Time) int64 {
+	return timestamp.Unix() / int64(dayDuration/time.Second)
+}
+
+type byWorkload []Workload
+
+func (w byWorkload) Len() int      { return len(w) }
+func (w byWorkload) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+func (w byWorkload) Less(i, j int) bool {
+	if w[i].Namespace != w[j].Namespace {
+		return w[i].Namespace < w[j].Namespace
+	}
+	if w[i].Kind != w[j].Kind {
+		return w[i].Kind < w[j].Kind
+	}
+	return w[i].Name < w[j].Name
+}
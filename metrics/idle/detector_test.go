@@ -0,0 +1,122 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package idle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+type podUsage struct {
+	name    string
+	cpu     int64
+	network float64
+}
+
+func podBatch(timestamp time.Time, pods ...podUsage) *core.DataBatch {
+	batch := &core.DataBatch{Timestamp: timestamp, MetricSets: map[string]*core.MetricSet{}}
+	for _, pod := range pods {
+		batch.MetricSets[core.PodKey("ns1", pod.name)] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePod,
+				core.LabelNamespaceName.Key: "ns1",
+				core.LabelPodName.Key:       pod.name,
+			},
+			MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsageRate.Name:  {IntValue: pod.cpu, ValueType: core.ValueInt64},
+				core.MetricNetworkRxRate.Name: {FloatValue: pod.network, ValueType: core.ValueFloat},
+			},
+		}
+	}
+	return batch
+}
+
+func newPod(name string, labels map[string]string, ownerKind, ownerName string) *kube_api.Pod {
+	controller := true
+	pod := &kube_api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      name,
+			Labels:    labels,
+		},
+	}
+	if ownerKind != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: ownerName, Controller: &controller}}
+	}
+	return pod
+}
+
+func TestGetIdleWorkloads(t *testing.T) {
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range []*kube_api.Pod{
+		newPod("web-5d8f-a", map[string]string{util.PodTemplateHashLabel: "5d8f"}, "ReplicaSet", "web-5d8f"),
+		newPod("web-5d8f-b", map[string]string{util.PodTemplateHashLabel: "5d8f"}, "ReplicaSet", "web-5d8f"),
+		newPod("busy-0", nil, "StatefulSet", "busy"),
+		newPod("chatty-0", nil, "StatefulSet", "chatty"),
+	} {
+		require.NoError(t, store.Add(pod))
+	}
+	detector := NewDetector(v1listers.NewPodLister(store), 2, 10, 1000)
+
+	start := time.Unix(0, 0).Add(100 * dayDuration)
+	for hour := 0; hour <= 48; hour++ {
+		busyCpu := int64(5)
+		if hour == 30 {
+			busyCpu = 500
+		}
+		detector.ExportData(podBatch(start.Add(time.Duration(hour)*time.Hour),
+			podUsage{name: "web-5d8f-a", cpu: 2, network: 10},
+			podUsage{name: "web-5d8f-b", cpu: 3, network: 20},
+			podUsage{name: "busy-0", cpu: busyCpu},
+			podUsage{name: "chatty-0", cpu: 1, network: 5000},
+			podUsage{name: "orphan", cpu: 1}))
+		if hour == 47 {
+			// Not observed for two days yet.
+			assert.Empty(t, detector.GetIdleWorkloads(""))
+		}
+	}
+
+	workloads := detector.GetIdleWorkloads("")
+	require.Len(t, workloads, 2)
+	assert.Equal(t, Workload{
+		Namespace:           "ns1",
+		Kind:                util.KindDeployment,
+		Name:                "web",
+		Pods:                []string{"web-5d8f-a", "web-5d8f-b"},
+		ObservedSince:       start,
+		MaxCpuUsageRate:     3,
+		MaxNetworkUsageRate: 20,
+	}, workloads[0])
+	assert.Equal(t, util.KindPod, workloads[1].Kind)
+	assert.Equal(t, "orphan", workloads[1].Name)
+
+	assert.Empty(t, detector.GetIdleWorkloads("ns2"))
+
+	// Workloads which are gone are not reported.
+	detector.ExportData(podBatch(start.Add(49*time.Hour), podUsage{name: "orphan", cpu: 1}))
+	workloads = detector.GetIdleWorkloads("ns1")
+	require.Len(t, workloads, 1)
+	assert.Equal(t, "orphan", workloads[0].Name)
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	MinBoostInterval = 5 * time.Second
+	MaxBoostDuration = time.Hour
+)
+
+// Boost increases the resolution of the metrics of a namespace or a node until it expires.
+type Boost struct {
+	// Exactly one of Namespace and Node is set.
+	Namespace string
+	Node      string
+	Interval  time.Duration
+	Expires   time.Time
+}
+
+func (this *Boost) matches(metricSet *core.MetricSet) bool {
+	if this.Namespace != "" {
+		return metricSet.Labels[core.LabelNamespaceName.Key] == this.Namespace
+	}
+	return metricSet.Labels[core.LabelNodename.Key] == this.Node
+}
+
+// ResolutionBoosts holds the boosts requested during incident investigations. While boosts are
+// active, the manager scrapes the boosted nodes, and the nodes running pods of the boosted
+// namespaces, at the shortest of their intervals, and exports the batches scraped between two
+// regular ones with the metric sets of the boosted objects only.
+type ResolutionBoosts struct {
+	resolution time.Duration
+	now        func() time.Time
+
+	lock   sync.Mutex
+	boosts []Boost
+	// Nodes running pods of each namespace, as of the latest regular batch.
+	namespaceNodes map[string]map[string]bool
+}
+
+func NewResolutionBoosts(resolution time.Duration) *ResolutionBoosts {
+	return &ResolutionBoosts{
+		resolution: resolution,
+		now:        time.Now,
+	}
+}
+
+// Add boosts the resolution of the namespace or the node to interval for duration. A boost of
+// an object boosted already replaces the previous one.
+func (this *ResolutionBoosts) Add(namespace, node string, interval, duration time.Duration) (Boost, error) {
+	if (namespace == "") == (node == "") {
+		return Boost{}, fmt.Errorf("exactly one of namespace and node should be set")
+	}
+	if interval < MinBoostInterval || interval >= this.resolution || this.resolution%interval != 0 {
+		return Boost{}, fmt.Errorf("interval should be at least %s and divide the metric resolution %s, got %s",
+			MinBoostInterval, this.resolution, interval)
+	}
+	if duration <= 0 || duration > MaxBoostDuration {
+		return Boost{}, fmt.Errorf("duration should be positive and at most %s, got %s", MaxBoostDuration, duration)
+	}
+	boost := Boost{
+		Namespace: namespace,
+		Node:      node,
+		Interval:  interval,
+		Expires:   this.now().Add(duration),
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	boosts := []Boost{boost}
+	for _, other := range this.activeLocked() {
+		if other.Namespace != namespace || other.Node != node {
+			boosts = append(boosts, other)
+		}
+	}
+	this.boosts = boosts
+	glog.Infof("Boosted the resolution of namespace %q node %q to %s until %s", namespace, node, interval, boost.Expires)
+	return boost, nil
+}
+
+// List returns the active boosts, latest first.
+func (this *ResolutionBoosts) List() []Boost {
+	if this == nil {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]Boost{}, this.activeLocked()...)
+}
+
+// activeLocked drops the expired boosts and returns the others. Must be called with the lock held.
+func (this *ResolutionBoosts) activeLocked() []Boost {
+	now := this.now()
+	active := this.boosts[:0]
+	for _, boost := range this.boosts {
+		if now.Before(boost.Expires) {
+			active = append(active, boost)
+		} else {
+			glog.Infof("Boost of the resolution of namespace %q node %q expired", boost.Namespace, boost.Node)
+		}
+	}
+	this.boosts = active
+	return active
+}
+
+// interval returns the interval at which the manager should scrape, the resolution if no boost
+// is active.
+func (this *ResolutionBoosts) interval(resolution time.Duration) time.Duration {
+	interval := resolution
+	for _, boost := range this.List() {
+		if boost.Interval < interval {
+			interval = boost.Interval
+		}
+	}
+	return interval
+}
+
+// observe records the nodes running the pods of each namespace in a regular batch.
+func (this *ResolutionBoosts) observe(batch *core.DataBatch) {
+	namespaceNodes := map[string]map[string]bool{}
+	for _, metricSet := range batch.MetricSets {
+		namespace, node := metricSet.Labels[core.LabelNamespaceName.Key], metricSet.Labels[core.LabelNodename.Key]
+		if namespace == "" || node == "" {
+			continue
+		}
+		if namespaceNodes[namespace] == nil {
+			namespaceNodes[namespace] = map[string]bool{}
+		}
+		namespaceNodes[namespace][node] = true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.namespaceNodes = namespaceNodes
+}
+
+// nodes returns the nodes to scrape between two regular batches: the boosted nodes, and the
+// nodes running pods of the boosted namespaces.
+func (this *ResolutionBoosts) nodes() map[string]bool {
+	boosts := this.List()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	nodes := map[string]bool{}
+	for _, boost := range boosts {
+		if boost.Node != "" {
+			nodes[boost.Node] = true
+		}
+		for node := range this.namespaceNodes[boost.Namespace] {
+			nodes[node] = true
+		}
+	}
+	return nodes
+}
+
+// filter returns a batch with the metric sets of the boosted objects of the batch, nil if there
+// are none.
+func (this *ResolutionBoosts) filter(batch *core.DataBatch) *core.DataBatch {
+	boosts := this.List()
+	result := &core.DataBatch{
+		Timestamp:  batch.Timestamp,
+		ID:         batch.ID,
+		MetricSets: map[string]*core.MetricSet{},
+		Partial:    true,
+	}
+	for key, metricSet := range batch.MetricSets {
+		for i := range boosts {
+			if boosts[i].matches(metricSet) {
+				result.MetricSets[key] = metricSet
+				break
+			}
+		}
+	}
+	if len(result.MetricSets) == 0 {
+		return nil
+	}
+	return result
+}
@@ -0,0 +1,238 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+)
+
+func testBoosts(now *time.Time) *ResolutionBoosts {
+	boosts := NewResolutionBoosts(time.Minute)
+	boosts.now = func() time.Time { return *now }
+	return boosts
+}
+
+func TestResolutionBoosts(t *testing.T) {
+	now := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	boosts := testBoosts(&now)
+	assert.Equal(t, time.Minute, boosts.interval(time.Minute))
+
+	for _, invalid := range []struct {
+		namespace, node    string
+		interval, duration time.Duration
+	}{
+		{"", "", 10 * time.Second, time.Minute},
+		{"ns1", "node1", 10 * time.Second, time.Minute},
+		{"ns1", "", time.Second, time.Minute},
+		{"ns1", "", 7 * time.Second, time.Minute},
+		{"ns1", "", time.Minute, time.Minute},
+		{"ns1", "", 10 * time.Second, 0},
+		{"ns1", "", 10 * time.Second, 2 * time.Hour},
+	} {
+		_, err := boosts.Add(invalid.namespace, invalid.node, invalid.interval, invalid.duration)
+		assert.Error(t, err, "%+v", invalid)
+	}
+
+	boost, err := boosts.Add("ns1", "", 10*time.Second, 10*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(10*time.Minute), boost.Expires)
+	_, err = boosts.Add("", "node1", 30*time.Second, 20*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, boosts.interval(time.Minute))
+
+	// Boosting an object again replaces its boost.
+	_, err = boosts.Add("ns1", "", 20*time.Second, 5*time.Minute)
+	require.NoError(t, err)
+	require.Len(t, boosts.List(), 2)
+	assert.Equal(t, 20*time.Second, boosts.interval(time.Minute))
+
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, []Boost{{Node: "node1", Interval: 30 * time.Second, Expires: now.Add(15 * time.Minute)}}, boosts.List())
+	now = now.Add(15 * time.Minute)
+	assert.Empty(t, boosts.List())
+	assert.Equal(t, time.Minute, boosts.interval(time.Minute))
+}
+
+func TestFilterBoostedMetricSets(t *testing.T) {
+	now := time.Now()
+	boosts := testBoosts(&now)
+	_, err := boosts.Add("ns1", "", 10*time.Second, time.Minute)
+	require.NoError(t, err)
+	_, err = boosts.Add("", "node1", 10*time.Second, time.Minute)
+	require.NoError(t, err)
+
+	batch := testBatch()
+	filtered := boosts.filter(batch)
+	require.NotNil(t, filtered)
+	assert.True(t, filtered.Partial)
+	assert.Equal(t, batch.Timestamp, filtered.Timestamp)
+	assert.Len(t, filtered.MetricSets, 3)
+	assert.Contains(t, filtered.MetricSets, core.PodKey("ns1", "pod1"))
+	assert.Contains(t, filtered.MetricSets, core.NamespaceKey("ns1"))
+	assert.Contains(t, filtered.MetricSets, core.NodeKey("node1"))
+
+	now = now.Add(time.Minute)
+	assert.Nil(t, boosts.filter(batch))
+}
+
+func testBatch() *core.DataBatch {
+	return &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("ns1", "pod1"): {Labels: map[string]string{
+				core.LabelNamespaceName.Key: "ns1",
+				core.LabelNodename.Key:      "node2",
+			}},
+			core.PodKey("ns2", "pod2"): {Labels: map[string]string{
+				core.LabelNamespaceName.Key: "ns2",
+				core.LabelNodename.Key:      "node2",
+			}},
+			core.NamespaceKey("ns1"): {Labels: map[string]string{core.LabelNamespaceName.Key: "ns1"}},
+			core.NodeKey("node1"):    {Labels: map[string]string{core.LabelNodename.Key: "node1"}},
+			core.NodeKey("node2"):    {Labels: map[string]string{core.LabelNodename.Key: "node2"}},
+			core.ClusterKey():        {Labels: map[string]string{}},
+		},
+	}
+}
+
+type batchSource struct{}
+
+func (this *batchSource) Name() string { return "batch" }
+
+func (this *batchSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	batch := testBatch()
+	batch.Timestamp = end
+	return batch, nil
+}
+
+type recordingSink struct {
+	lock    sync.Mutex
+	batches []*core.DataBatch
+}
+
+func (this *recordingSink) Name() string { return "recording" }
+func (this *recordingSink) Stop()        {}
+
+func (this *recordingSink) ExportData(batch *core.DataBatch) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.batches = append(this.batches, batch)
+}
+
+func (this *recordingSink) get() []*core.DataBatch {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.batches
+}
+
+func TestHousekeepBoosted(t *testing.T) {
+	sink := &recordingSink{}
+	manager, err := NewManager(&batchSource{}, nil, sink, time.Minute, time.Millisecond, 1)
+	require.NoError(t, err)
+	rm := manager.(*realManager)
+	now := time.Now()
+	boosts := testBoosts(&now)
+	_, err = boosts.Add("", "node1", 10*time.Second, time.Minute)
+	require.NoError(t, err)
+	rm.SetResolutionBoosts(boosts)
+
+	end := now.Truncate(time.Minute)
+	rm.housekeep(end.Add(-time.Minute), end)
+	rm.housekeep(end.Add(-50*time.Second), end.Add(10*time.Second))
+	require.NoError(t, waitFor(func() bool { return len(sink.get()) == 2 }))
+	var full, partial *core.DataBatch
+	for _, batch := range sink.get() {
+		if batch.Partial {
+			partial = batch
+		} else {
+			full = batch
+		}
+	}
+	require.NotNil(t, full)
+	require.NotNil(t, partial)
+	assert.Len(t, full.MetricSets, 6)
+	assert.Equal(t, []string{core.NodeKey("node1")}, keys(partial))
+}
+
+func keys(batch *core.DataBatch) []string {
+	var result []string
+	for key := range batch.MetricSets {
+		result = append(result, key)
+	}
+	return result
+}
+
+func waitFor(condition func() bool) error {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return assert.AnError
+}
+
+// nodeBatchSource scrapes the given nodes only between two regular batches.
+type nodeBatchSource struct {
+	batchSource
+	lock  sync.Mutex
+	nodes []map[string]bool
+}
+
+func (this *nodeBatchSource) ScrapeNodeMetrics(start, end time.Time, nodes map[string]bool) (*core.DataBatch, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.nodes = append(this.nodes, nodes)
+	batch := &core.DataBatch{Timestamp: end, MetricSets: map[string]*core.MetricSet{}}
+	for key, metricSet := range testBatch().MetricSets {
+		if nodes[metricSet.Labels[core.LabelNodename.Key]] {
+			batch.MetricSets[key] = metricSet
+		}
+	}
+	return batch, nil
+}
+
+func TestHousekeepBoostedScrapesBoostedNodes(t *testing.T) {
+	sink := &recordingSink{}
+	source := &nodeBatchSource{}
+	manager, err := NewManager(source, nil, sink, time.Minute, time.Millisecond, 1)
+	require.NoError(t, err)
+	rm := manager.(*realManager)
+	now := time.Now()
+	boosts := testBoosts(&now)
+	_, err = boosts.Add("ns1", "", 10*time.Second, time.Minute)
+	require.NoError(t, err)
+	rm.SetResolutionBoosts(boosts)
+
+	// The pods of the boosted namespace run on node2, as of the latest regular batch.
+	end := now.Truncate(time.Minute)
+	rm.housekeep(end.Add(-time.Minute), end)
+	rm.housekeep(end.Add(-50*time.Second), end.Add(10*time.Second))
+	require.NoError(t, waitFor(func() bool { return len(sink.get()) == 2 }))
+	source.lock.Lock()
+	assert.Equal(t, []map[string]bool{{"node2": true}}, source.nodes)
+	source.lock.Unlock()
+	for _, batch := range sink.get() {
+		if batch.Partial {
+			assert.Equal(t, []string{core.PodKey("ns1", "pod1")}, keys(batch))
+		}
+	}
+}
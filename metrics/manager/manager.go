@@ -48,34 +48,40 @@ func init() {
 type Manager interface {
 	Start()
 	Stop()
+	// SetMaxParallelism changes the number of housekeepings that may run at the same time.
+	SetMaxParallelism(maxParallelism int)
+	// SetResolutionBoosts makes the manager scrape at the resolution of the active boosts.
+	SetResolutionBoosts(boosts *ResolutionBoosts)
+	// SetDedupIdentity makes the manager set the dedup key of the batches it exports to the
+	// given cluster and replica.
+	SetDedupIdentity(cluster, replica string)
 }
 
 type realManager struct {
-	source                 core.MetricsSource
-	processors             []core.DataProcessor
-	sink                   core.DataSink
-	resolution             time.Duration
-	scrapeOffset           time.Duration
-	stopChan               chan struct{}
-	housekeepSemaphoreChan chan struct{}
-	housekeepTimeout       time.Duration
+	source           core.MetricsSource
+	processors       []core.DataProcessor
+	sink             core.DataSink
+	resolution       time.Duration
+	scrapeOffset     time.Duration
+	stopChan         chan struct{}
+	housekeepLimiter *parallelismLimiter
+	housekeepTimeout time.Duration
+	boosts           *ResolutionBoosts
+	cluster          string
+	replica          string
 }
 
 func NewManager(source core.MetricsSource, processors []core.DataProcessor, sink core.DataSink, resolution time.Duration,
 	scrapeOffset time.Duration, maxParallelism int) (Manager, error) {
 	manager := realManager{
-		source:                 source,
-		processors:             processors,
-		sink:                   sink,
-		resolution:             resolution,
-		scrapeOffset:           scrapeOffset,
-		stopChan:               make(chan struct{}),
-		housekeepSemaphoreChan: make(chan struct{}, maxParallelism),
-		housekeepTimeout:       resolution / 2,
-	}
-
-	for i := 0; i < maxParallelism; i++ {
-		manager.housekeepSemaphoreChan <- struct{}{}
+		source:           source,
+		processors:       processors,
+		sink:             sink,
+		resolution:       resolution,
+		scrapeOffset:     scrapeOffset,
+		stopChan:         make(chan struct{}),
+		housekeepLimiter: newParallelismLimiter(maxParallelism),
+		housekeepTimeout: resolution / 2,
 	}
 
 	return &manager, nil
@@ -89,12 +95,29 @@ func (rm *realManager) Stop() {
 	rm.stopChan <- struct{}{}
 }
 
+func (rm *realManager) SetMaxParallelism(maxParallelism int) {
+	if old := rm.housekeepLimiter.getLimit(); old != maxParallelism {
+		glog.V(1).Infof("Changing max housekeeping parallelism from %d to %d", old, maxParallelism)
+		rm.housekeepLimiter.setLimit(maxParallelism)
+	}
+}
+
+func (rm *realManager) SetResolutionBoosts(boosts *ResolutionBoosts) {
+	rm.boosts = boosts
+}
+
+func (rm *realManager) SetDedupIdentity(cluster, replica string) {
+	rm.cluster = cluster
+	rm.replica = replica
+}
+
 func (rm *realManager) Housekeep() {
 	for {
 		// Always try to get the newest metrics
 		now := time.Now()
-		start := now.Truncate(rm.resolution)
-		end := start.Add(rm.resolution)
+		interval := rm.boosts.interval(rm.resolution)
+		end := now.Truncate(interval).Add(interval)
+		start := end.Add(-rm.resolution)
 		timeToNextSync := end.Add(rm.scrapeOffset).Sub(now)
 
 		select {
@@ -113,19 +136,32 @@ func (rm *realManager) housekeep(start, end time.Time) {
 		return
 	}
 
-	select {
-	case <-rm.housekeepSemaphoreChan:
-		// ok, good to go
-
-	case <-time.After(rm.housekeepTimeout):
+	if !rm.housekeepLimiter.acquire(rm.housekeepTimeout) {
 		glog.Warningf("Spent too long waiting for housekeeping to start")
 		return
 	}
 
+	// Batches scraped between two regular ones because of boosts only export the boosted objects.
+	boosted := !end.Truncate(rm.resolution).Equal(end)
+
 	go func(rm *realManager) {
 		// should always give back the semaphore
-		defer func() { rm.housekeepSemaphoreChan <- struct{}{} }()
-		data, err := rm.source.ScrapeMetrics(start, end)
+		defer rm.housekeepLimiter.release()
+		var data *core.DataBatch
+		var err error
+		scraper, scrapesNodes := rm.source.(core.NodeScraper)
+		if boosted && scrapesNodes {
+			nodes := rm.boosts.nodes()
+			if len(nodes) == 0 {
+				return
+			}
+			data, err = scraper.ScrapeNodeMetrics(start, end, nodes)
+			if data != nil {
+				data.Partial = true
+			}
+		} else {
+			data, err = rm.source.ScrapeMetrics(start, end)
+		}
 
 		if err != nil {
 			glog.Errorf("Error in scraping metrics for %s: %v", rm.source.Name(), err)
@@ -137,9 +173,21 @@ func (rm *realManager) housekeep(start, end time.Time) {
 			if err == nil {
 				data = newData
 			} else {
-				glog.Errorf("Error in processor: %v", err)
+				glog.Errorf("[batch %s] Error in processor %s: %v", data.ID, p.Name(), err)
+				return
+			}
+		}
+
+		if boosted {
+			if data = rm.boosts.filter(data); data == nil {
 				return
 			}
+		} else if rm.boosts != nil {
+			rm.boosts.observe(data)
+		}
+
+		if rm.cluster != "" {
+			data.DedupKey = &core.DedupKey{Cluster: rm.cluster, Replica: rm.replica, Timestamp: data.Timestamp}
 		}
 
 		// Export data to sinks
@@ -0,0 +1,101 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of nodes that justify one additional concurrent housekeeping.
+const NodesPerParallelism = 100
+
+// ParallelismForNodes returns the maximum number of concurrent housekeepings for a cluster
+// with the given number of nodes, bounded by min and max.
+func ParallelismForNodes(nodes, min, max int) int {
+	parallelism := (nodes + NodesPerParallelism - 1) / NodesPerParallelism
+	if parallelism < min {
+		return min
+	}
+	if parallelism > max {
+		return max
+	}
+	return parallelism
+}
+
+// parallelismLimiter is a semaphore whose size can be changed while it is in use.
+type parallelismLimiter struct {
+	lock  sync.Mutex
+	limit int
+	inUse int
+	// Receives a value whenever a slot may have become available.
+	changed chan struct{}
+}
+
+func newParallelismLimiter(limit int) *parallelismLimiter {
+	return &parallelismLimiter{
+		limit:   limit,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// acquire takes a slot, waiting at most timeout for one to become available.
+func (l *parallelismLimiter) acquire(timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		l.lock.Lock()
+		if l.inUse < l.limit {
+			l.inUse++
+			l.lock.Unlock()
+			return true
+		}
+		l.lock.Unlock()
+
+		select {
+		case <-l.changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func (l *parallelismLimiter) release() {
+	l.lock.Lock()
+	l.inUse--
+	l.lock.Unlock()
+	l.notify()
+}
+
+// setLimit changes the size of the semaphore. Slots which are in use above the new
+// limit are not revoked, they are just not handed out again.
+func (l *parallelismLimiter) setLimit(limit int) {
+	l.lock.Lock()
+	l.limit = limit
+	l.lock.Unlock()
+	l.notify()
+}
+
+func (l *parallelismLimiter) getLimit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limit
+}
+
+func (l *parallelismLimiter) notify() {
+	select {
+	case l.changed <- struct{}{}:
+	default:
+	}
+}
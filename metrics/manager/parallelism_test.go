@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParallelismForNodes(t *testing.T) {
+	assert.Equal(t, 3, ParallelismForNodes(0, 3, 10))
+	assert.Equal(t, 3, ParallelismForNodes(250, 3, 10))
+	assert.Equal(t, 5, ParallelismForNodes(401, 3, 10))
+	assert.Equal(t, 10, ParallelismForNodes(5000, 3, 10))
+}
+
+func TestParallelismLimiter(t *testing.T) {
+	limiter := newParallelismLimiter(1)
+	assert.True(t, limiter.acquire(time.Millisecond))
+	assert.False(t, limiter.acquire(10*time.Millisecond))
+
+	limiter.setLimit(2)
+	assert.True(t, limiter.acquire(time.Millisecond))
+
+	// Shrinking does not revoke slots in use.
+	limiter.setLimit(1)
+	limiter.release()
+	assert.False(t, limiter.acquire(10*time.Millisecond))
+	limiter.release()
+
+	// A waiting acquire succeeds as soon as a slot is released.
+	assert.True(t, limiter.acquire(time.Millisecond))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		limiter.release()
+	}()
+	assert.True(t, limiter.acquire(time.Second))
+}
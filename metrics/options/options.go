@@ -21,10 +21,13 @@ import (
 
 	genericoptions "k8s.io/apiserver/pkg/server/options"
 	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/sources"
+	"k8s.io/heapster/metrics/util/capture"
 )
 
 type HeapsterRunOptions struct {
-	// genericoptions.ReccomendedOptions - EtcdOptions
+	// genericoptions.RecommendedOptions without the EtcdOptions: the Metrics API resources are
+	// served from the metric sink held in memory, so the API server needs no etcd.
 	SecureServing  *genericoptions.SecureServingOptions
 	Authentication *genericoptions.DelegatingAuthenticationOptions
 	Authorization  *genericoptions.DelegatingAuthorizationOptions
@@ -33,25 +36,78 @@ type HeapsterRunOptions struct {
 	// Only to be used to for testing
 	DisableAuthForTesting bool
 
-	MetricResolution      time.Duration
-	EnableAPIServer       bool
-	Port                  int
-	Ip                    string
-	MaxProcs              int
-	TLSCertFile           string
-	TLSKeyFile            string
-	TLSClientCAFile       string
-	AllowedUsers          string
-	Sources               flags.Uris
-	Sinks                 flags.Uris
-	HistoricalSource      string
-	Version               bool
-	LabelSeparator        string
-	IgnoredLabels         []string
-	StoredLabels          []string
-	DisableMetricExport   bool
-	SinkExportDataTimeout time.Duration
-	DisableMetricSink     bool
+	MetricResolution       time.Duration
+	EnableAPIServer        bool
+	Port                   int
+	Ip                     string
+	MaxProcs               int
+	TLSCertFile            string
+	TLSKeyFile             string
+	TLSClientCAFile        string
+	MetricsTokenFile       string
+	MetricsTokenReview     bool
+	APITokenFile           string
+	DedupCluster           string
+	ReplicaName            string
+	ExportLease            string
+	AllowedUsers           string
+	Sources                flags.Uris
+	Sinks                  flags.Uris
+	Processors             flags.Uris
+	HistoricalSource       string
+	Version                bool
+	LabelSeparator         string
+	IgnoredLabels          []string
+	StoredLabels           []string
+	DisableMetricExport    bool
+	SinkExportDataTimeout  time.Duration
+	DisableMetricSink      bool
+	SinkJournalDir         string
+	SinkJournalMaxBatches  int
+	ExportPriorities       []string
+	SensitiveLabels        []string
+	SensitiveLabelKeyFile  string
+	SensitiveLabelSinks    []string
+	ModelResponseCache     bool
+	DumpOpenMetrics        string
+	SourceScrapeTimeout    time.Duration
+	SourceConcurrency      int
+	ScrapeJitter           float64
+	MinParallelism         int
+	MaxParallelism         int
+	PodKeyScheme           string
+	EventCounts            bool
+	EventCountRetention    time.Duration
+	Eventer                bool
+	EventSinks             flags.Uris
+	EventFrequency         time.Duration
+	EventNamespacePolicies bool
+	Recommendations        bool
+	RecommendationHistory  time.Duration
+	IdleWorkloads          bool
+	IdleDays               int
+	IdleCpuThreshold       int64
+	IdleNetworkThreshold   float64
+	Forecast               bool
+	ForecastHistory        time.Duration
+	ForecastNodePoolLabel  string
+	ValidateMetrics        bool
+	ValidationMaxClockSkew time.Duration
+	ClockSkewPolicy        string
+	PersistentVolumeLabels bool
+	DeletedNodeGrace       time.Duration
+	StartupGrace           time.Duration
+	SidecarContainers      []string
+	SidecarAnnotation      string
+	SeparateSidecarUsage   bool
+	ResolutionBoosts       bool
+	CaptureDir             string
+	CaptureBatches         int
+	CaptureScrubbedLabels  []string
+	ProfileInterval        time.Duration
+	ProfileCPUDuration     time.Duration
+	ProfileRetention       int
+	ProfileMinHeapBytes    uint64
 }
 
 func NewHeapsterRunOptions() *HeapsterRunOptions {
@@ -71,11 +127,16 @@ func (h *HeapsterRunOptions) AddFlags(fs *pflag.FlagSet) {
 
 	fs.Var(&h.Sources, "source", "source(s) to watch")
 	fs.Var(&h.Sinks, "sink", "external sink(s) that receive data")
+	fs.Var(&h.Processors, "processor", "processor(s) the data goes through before the sinks, in the given order. "+
+		"Defaults to the kubernetes pipeline")
 	fs.DurationVar(&h.MetricResolution, "metric_resolution", 60*time.Second, "The resolution at which heapster will retain metrics.")
+	fs.DurationVar(&h.StartupGrace, "startup_grace", 0, "Duration during which the creation of the sources, the sinks and the Kubernetes clients is retried "+
+		"at startup, e.g. while the API server or a sink is not reachable yet, before Heapster exits. 0 to exit on the first failure")
 
 	// TODO: Revise these flags before Heapster v1.3 and Kubernetes v1.5
 	fs.BoolVar(&h.EnableAPIServer, "api-server", false, "Enable API server for the Metrics API. "+
-		"If set, the Metrics API will be served on --insecure-port (internally) and --secure-port (externally).")
+		"If set, the Metrics API will be served on --insecure-port (internally) and --secure-port (externally). "+
+		"The metrics are served from memory, no etcd is needed.")
 	fs.IntVar(&h.Port, "heapster-port", 8082, "port used by the Heapster-specific APIs")
 
 	fs.StringVar(&h.Ip, "listen_ip", "", "IP to listen on, defaults to all IPs")
@@ -83,6 +144,13 @@ func (h *HeapsterRunOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&h.TLSCertFile, "tls_cert", "", "file containing TLS certificate")
 	fs.StringVar(&h.TLSKeyFile, "tls_key", "", "file containing TLS key")
 	fs.StringVar(&h.TLSClientCAFile, "tls_client_ca", "", "file containing TLS client CA for client cert validation")
+	fs.StringVar(&h.MetricsTokenFile, "metrics_token_file", "", "file containing a bearer token required to access the /metrics endpoint")
+	fs.BoolVar(&h.MetricsTokenReview, "metrics_token_review", false, "authenticate bearer tokens presented to the /metrics endpoint with a TokenReview against the Kubernetes API server")
+	fs.StringVar(&h.APITokenFile, "api_token_file", "", "CSV file of bearer tokens granting read-only access to the model API of some namespaces, one token,name,namespace[,namespace...] per line. "+
+		"If set, the Heapster-specific APIs require either such a token or a client certificate")
+	fs.StringVar(&h.DedupCluster, "dedup_cluster", "", "name of the cluster in the dedup key of the exported batches, so that consumers of sinks receiving the batches of redundant replicas can keep a single copy. Empty to not set dedup keys")
+	fs.StringVar(&h.ReplicaName, "replica_name", "", "name of this replica in dedup keys and in the export lease (default: hostname)")
+	fs.StringVar(&h.ExportLease, "export_lease", "", "namespace/name of a ConfigMap through which redundant replicas scraping the same cluster claim each batch, so that only one of them exports it to the sinks")
 	fs.StringVar(&h.AllowedUsers, "allowed_users", "", "comma-separated list of allowed users")
 	fs.StringVar(&h.HistoricalSource, "historical_source", "", "which source type to use for the historical API (should be exactly the same as one of the sink URIs), or empty to disable the historical API")
 	fs.BoolVar(&h.Version, "version", false, "print version info and exit")
@@ -92,4 +160,69 @@ func (h *HeapsterRunOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&h.DisableMetricExport, "disable_export", false, "Disable exporting metrics in api/v1/metric-export")
 	fs.DurationVar(&h.SinkExportDataTimeout, "sink_export_data_timeout", 20*time.Second, "Timeout for exporting data to a sink")
 	fs.BoolVar(&h.DisableMetricSink, "disable_metric_sink", false, "Disable metric sink")
+	fs.StringVar(&h.SinkJournalDir, "sink_journal_dir", "", "Directory where the batches exported to sinks dropping duplicates (influxdb, kafka) are journaled "+
+		"until acknowledged, so they are exported again after failures and restarts. Empty to disable journaling")
+	fs.IntVar(&h.SinkJournalMaxBatches, "sink_journal_max_batches", 60, "Maximum number of batches journaled per sink, the oldest being dropped")
+	fs.StringSliceVar(&h.SensitiveLabels, "sensitive_labels", []string{}, "Labels whose values are hashed or redacted before export, as label:transform, e.g. pod_name:hash,labels:redact")
+	fs.StringVar(&h.SensitiveLabelKeyFile, "sensitive_label_key_file", "", "File containing the secret key of the HMAC hashing the values of --sensitive_labels. Values are hashed with plain SHA-256 if empty")
+	fs.StringSliceVar(&h.SensitiveLabelSinks, "sensitive_label_sinks", []string{}, "Types of the sinks, e.g. stackdriver, receiving the hashed or redacted values of --sensitive_labels (default: all sinks but the metric sink)")
+	fs.StringSliceVar(&h.ExportPriorities, "export_priority_namespaces", []string{}, "Patterns of namespaces, e.g. prod-*, whose metrics are exported first, in order, "+
+		"after those of the nodes. The remaining metrics of a batch are dropped once its export to a sink takes longer than --sink_export_data_timeout. "+
+		"Empty to export whole batches. The metric sink and journaled sinks always get whole batches")
+	fs.StringVar(&h.CaptureDir, "capture_dir", "", "Directory where the first --capture_batches batches exported to the sinks and the kubelet summaries they were built from "+
+		"are written, as fixtures for `heapster replay` and bug reports. Empty to disable capturing")
+	fs.IntVar(&h.CaptureBatches, "capture_batches", 10, "Number of batches to capture into --capture_dir")
+	fs.StringSliceVar(&h.CaptureScrubbedLabels, "capture_scrubbed_labels", capture.DefaultScrubbedLabels, "Labels whose values are replaced by salted hashes in the captured batches and summaries")
+	fs.DurationVar(&h.ProfileInterval, "profile_capture_interval", 0, "Interval at which heap and CPU profiles are captured and served under /debug/profiles/. 0 to disable capturing")
+	fs.DurationVar(&h.ProfileCPUDuration, "profile_capture_cpu_duration", 30*time.Second, "Duration of the captured CPU profiles, shorter than --profile_capture_interval")
+	fs.IntVar(&h.ProfileRetention, "profile_capture_retention", 10, "Number of the latest heap and CPU profiles kept, compressed, in memory")
+	fs.Uint64Var(&h.ProfileMinHeapBytes, "profile_capture_min_heap_bytes", 0, "Heap in use, in bytes, below which no profile is captured, so that the kept profiles cover the high-load windows")
+	fs.DurationVar(&h.SourceScrapeTimeout, "source_scrape_timeout", sources.DefaultMetricsScrapeTimeout, "Time given to each source, e.g. a kubelet, to respond to a scrape. "+
+		"Overridden for the sources of a --source by its scrape_timeout option")
+	fs.IntVar(&h.SourceConcurrency, "source_concurrency", 0, "Maximum number of sources, e.g. kubelets, scraped at the same time by each --source. "+
+		"Sources waiting longer than the scrape timeout are skipped for the cycle. 0 to scrape all sources at once")
+	fs.Float64Var(&h.ScrapeJitter, "scrape_jitter", 0, "Fraction of --metric_resolution over which the scrapes of the kubelets are spread, e.g. 0.8. "+
+		"Each kubelet is scraped at the same offset in every cycle. 0 to start all scrapes within a few seconds")
+	fs.IntVar(&h.MinParallelism, "min_parallelism", 3, "Minimum number of scrape cycles that may be processed at the same time")
+	fs.IntVar(&h.MaxParallelism, "max_parallelism", 3, "Maximum number of scrape cycles that may be processed at the same time. "+
+		"If greater than --min_parallelism, the limit grows with the number of nodes in the cluster")
+	fs.StringVar(&h.PodKeyScheme, "pod_key_scheme", "name", "How pods are identified in the collected metrics: 'name' (namespace and name) "+
+		"or 'uid' (namespace, name and UID, so recreated pods with the same name get a separate history)")
+	fs.StringVar(&h.DumpOpenMetrics, "dump_openmetrics", "", "Write the first complete batch of metrics in OpenMetrics text format to the given file ('-' for stdout) and exit")
+	fs.BoolVar(&h.ModelResponseCache, "model_response_cache", false, "Cache responses of the model API for the duration of one metric resolution")
+	fs.BoolVar(&h.Eventer, "eventer", false, "Also run the eventer in this process, exporting Kubernetes events to the event sinks")
+	fs.Var(&h.EventSinks, "event_sink", "external sink(s) that receive events when running with --eventer. "+
+		"Defaults to those of the --sink sinks which support events")
+	fs.BoolVar(&h.EventNamespacePolicies, "event_namespace_policies", false, "Export the events of each namespace as set by its "+
+		"heapster.kubernetes.io/events-* annotations when running with --eventer")
+	fs.DurationVar(&h.EventFrequency, "event_frequency", 30*time.Second, "The resolution at which events are pushed to sinks")
+	fs.BoolVar(&h.EventCounts, "event_counts", false, "Watch Kubernetes events and export their counts per namespace, reason and type as the event/count metric")
+	fs.DurationVar(&h.EventCountRetention, "event_count_retention", 24*time.Hour, "How long event counts without new events are exported before they are dropped")
+	fs.BoolVar(&h.Recommendations, "recommendations", false, "Track the resource usage of containers and serve request and limit recommendations at /api/v1/recommendations")
+	fs.DurationVar(&h.RecommendationHistory, "recommendation_history", 8*24*time.Hour, "How much usage history recommendations are based on, rounded up to whole days")
+	fs.BoolVar(&h.IdleWorkloads, "idle_workloads", false, "Track the daily peak usage of workloads and list the idle ones at /api/v1/idle-workloads")
+	fs.IntVar(&h.IdleDays, "idle_days", 7, "Number of days a workload needs to stay below the idle thresholds to be reported as idle")
+	fs.Int64Var(&h.IdleCpuThreshold, "idle_cpu_threshold", 10, "CPU usage rate in millicores below which a workload is considered idle")
+	fs.BoolVar(&h.ValidateMetrics, "validate_metrics", false, "Drop negative, non-numeric and absurdly large metric values and metric sets with timestamps in the future before processing them. "+
+		"The latest dropped values are listed at /api/v1/debug/quarantine")
+	fs.DurationVar(&h.ValidationMaxClockSkew, "validation_max_clock_skew", 5*time.Minute, "How far in the future scrape timestamps may be before their metric set is handled according to --validation_clock_skew_policy")
+	fs.StringVar(&h.ClockSkewPolicy, "validation_clock_skew_policy", "reject", "What to do with metric sets scraped further in the future than --validation_max_clock_skew: "+
+		"reject drops them, correct moves them to Heapster's time and accept only counts them")
+	fs.BoolVar(&h.Forecast, "forecast", false, "Track the hourly usage of the cluster and its node pools and serve usage forecasts at /api/v1/forecast. "+
+		"With --historical_source, the history is read from the historical source instead")
+	fs.DurationVar(&h.ForecastHistory, "forecast_history", 14*24*time.Hour, "How much usage history forecasts are based on")
+	fs.StringVar(&h.ForecastNodePoolLabel, "forecast_node_pool_label", "cloud.google.com/gke-nodepool", "Node label whose value names the node pool of a node")
+	fs.Float64Var(&h.IdleNetworkThreshold, "idle_network_threshold", 1024, "Network usage rate in bytes per second, received and transmitted, below which a workload is considered idle")
+	fs.DurationVar(&h.DeletedNodeGrace, "deleted_node_grace", 0, "Duration after their last scrape during which the last known metrics of deleted nodes and of their system containers "+
+		"keep being exported, labeled with node_deleted=true, so that rates and dashboards don't break as soon as a node is removed. 0 to drop them at once")
+	fs.StringSliceVar(&h.SidecarContainers, "sidecar_containers", []string{}, "Patterns of the names of the sidecar containers, e.g. istio-proxy or *-sidecar, "+
+		"labeled with sidecar=true")
+	fs.StringVar(&h.SidecarAnnotation, "sidecar_annotation", "", "Pod annotation listing the names of the sidecar containers of the pod, separated by commas, "+
+		"labeled with sidecar=true")
+	fs.BoolVar(&h.SeparateSidecarUsage, "separate_sidecar_usage", false, "Leave the usage of the sidecar containers out of the pod, namespace and cluster metrics, "+
+		"reporting it by pod metrics labeled with sidecar=true instead. Requires --sidecar_containers or --sidecar_annotation")
+	fs.BoolVar(&h.PersistentVolumeLabels, "persistent_volume_labels", false, "Label the filesystem metrics of pod volumes with their persistent volume claim, persistent volume and storage class. "+
+		"Requires permission to list and watch persistent volume claims and persistent volumes")
+	fs.BoolVar(&h.ResolutionBoosts, "resolution_boosts", false, "Serve /api/v1/resolution, which temporarily increases the resolution of the metrics of a namespace or a node, "+
+		"e.g. during incident investigations")
 }
@@ -28,6 +28,9 @@ func (this *ClusterAggregator) Process(batch *core.DataBatch) (*core.DataBatch,
 	clusterKey := core.ClusterKey()
 	cluster := clusterMetricSet()
 	for _, metricSet := range batch.MetricSets {
+		if core.IsFederated(metricSet) {
+			continue
+		}
 		if metricSetType, found := metricSet.Labels[core.LabelMetricSetType.Key]; found &&
 			metricSetType == core.MetricSetTypeNamespace {
 			if err := aggregate(metricSet, cluster, this.MetricsToAggregate); err != nil {
@@ -0,0 +1,117 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+)
+
+// retainedNode holds the last known metric sets of a node and of its system containers.
+type retainedNode struct {
+	metricSets map[string]*core.MetricSet
+	// Timestamp of the last batch the node was part of.
+	lastSeen time.Time
+}
+
+// DeletedNodeRetainer keeps exporting the last known metric sets of deleted nodes, labeled with
+// node_deleted=true, until grace passed since they were last scraped, so that the dashboards and
+// rates of the sinks don't break as soon as a node disappears. It should run after the
+// aggregators, so that the retained metric sets are not aggregated again.
+type DeletedNodeRetainer struct {
+	nodeLister v1listers.NodeLister
+	grace      time.Duration
+	// By node name.
+	nodes map[string]*retainedNode
+}
+
+func (this *DeletedNodeRetainer) Name() string {
+	return "deleted_node_retainer"
+}
+
+func (this *DeletedNodeRetainer) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	nodes, err := this.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		existing[node.Name] = true
+	}
+
+	current := map[string]map[string]*core.MetricSet{}
+	for key, metricSet := range batch.MetricSets {
+		metricSetType := metricSet.Labels[core.LabelMetricSetType.Key]
+		if metricSetType != core.MetricSetTypeNode && metricSetType != core.MetricSetTypeSystemContainer {
+			continue
+		}
+		nodeName := metricSet.Labels[core.LabelNodename.Key]
+		if nodeName == "" {
+			continue
+		}
+		if current[nodeName] == nil {
+			current[nodeName] = map[string]*core.MetricSet{}
+		}
+		current[nodeName][key] = metricSet
+	}
+	for nodeName, metricSets := range current {
+		this.nodes[nodeName] = &retainedNode{
+			metricSets: metricSets,
+			lastSeen:   batch.Timestamp,
+		}
+	}
+
+	for nodeName, node := range this.nodes {
+		if _, found := current[nodeName]; found {
+			continue
+		}
+		if batch.Timestamp.Sub(node.lastSeen) > this.grace {
+			glog.V(2).Infof("Dropping the metrics of node %s, last seen at %v", nodeName, node.lastSeen)
+			delete(this.nodes, nodeName)
+			continue
+		}
+		if existing[nodeName] {
+			// The node could not be scraped, its metrics are not exported with outdated values.
+			continue
+		}
+		for key, metricSet := range node.metricSets {
+			if _, found := batch.MetricSets[key]; found {
+				continue
+			}
+			retained := *metricSet
+			retained.Labels = make(map[string]string, len(metricSet.Labels)+1)
+			for k, v := range metricSet.Labels {
+				retained.Labels[k] = v
+			}
+			retained.Labels[core.LabelNodeDeleted.Key] = "true"
+			batch.MetricSets[key] = &retained
+		}
+	}
+	return batch, nil
+}
+
+// NewDeletedNodeRetainer returns a processor retaining the metric sets of the nodes missing from
+// the lister for grace.
+func NewDeletedNodeRetainer(nodeLister v1listers.NodeLister, grace time.Duration) *DeletedNodeRetainer {
+	return &DeletedNodeRetainer{
+		nodeLister: nodeLister,
+		grace:      grace,
+		nodes:      map[string]*retainedNode{},
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/core"
+)
+
+func nodeBatch(timestamp time.Time, nodes ...string) *core.DataBatch {
+	batch := &core.DataBatch{
+		Timestamp:  timestamp,
+		MetricSets: map[string]*core.MetricSet{},
+	}
+	for _, node := range nodes {
+		batch.MetricSets[core.NodeKey(node)] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+				core.LabelNodename.Key:      node,
+			},
+			ScrapeTime: timestamp,
+		}
+		batch.MetricSets[core.NodeContainerKey(node, "kubelet")] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeSystemContainer,
+				core.LabelNodename.Key:      node,
+			},
+			ScrapeTime: timestamp,
+		}
+		batch.MetricSets[core.PodKey("ns", "pod-"+node)] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePod,
+				core.LabelNodename.Key:      node,
+			},
+			ScrapeTime: timestamp,
+		}
+	}
+	return batch
+}
+
+func TestDeletedNodeRetainer(t *testing.T) {
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	node1 := &kube_api.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	store.Add(node1)
+	store.Add(&kube_api.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})
+	retainer := NewDeletedNodeRetainer(v1listers.NewNodeLister(store), 3*time.Minute)
+
+	now := time.Unix(1500000000, 0)
+	batch, err := retainer.Process(nodeBatch(now, "node1", "node2"))
+	require.NoError(t, err)
+	assert.Len(t, batch.MetricSets, 6)
+
+	// node2 could not be scraped, its metrics are not retained.
+	batch, err = retainer.Process(nodeBatch(now.Add(time.Minute), "node1"))
+	require.NoError(t, err)
+	assert.Len(t, batch.MetricSets, 3)
+
+	// node1 was deleted, its last known node and system container metrics are retained.
+	store.Delete(node1)
+	batch, err = retainer.Process(nodeBatch(now.Add(2 * time.Minute)))
+	require.NoError(t, err)
+	require.Len(t, batch.MetricSets, 2)
+	for _, key := range []string{core.NodeKey("node1"), core.NodeContainerKey("node1", "kubelet")} {
+		metricSet := batch.MetricSets[key]
+		require.NotNil(t, metricSet, key)
+		assert.Equal(t, "true", metricSet.Labels[core.LabelNodeDeleted.Key])
+		assert.Equal(t, now.Add(time.Minute), metricSet.ScrapeTime)
+	}
+
+	batch, err = retainer.Process(nodeBatch(now.Add(4 * time.Minute)))
+	require.NoError(t, err)
+	assert.Len(t, batch.MetricSets, 2)
+
+	// The grace period is over.
+	batch, err = retainer.Process(nodeBatch(now.Add(5 * time.Minute)))
+	require.NoError(t, err)
+	assert.Empty(t, batch.MetricSets)
+	assert.Empty(t, retainer.nodes)
+}
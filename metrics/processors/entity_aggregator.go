@@ -0,0 +1,71 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"github.com/golang/glog"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+// EntityAggregator sums up the aggregated metrics of registered non-Kubernetes entities into
+// their parents, creating the parents which have no metric set of their own. Entities deeper in
+// the hierarchy are aggregated first, so their metrics reach all ancestors.
+type EntityAggregator struct{}
+
+func (this *EntityAggregator) Name() string {
+	return "entity_aggregator"
+}
+
+func (this *EntityAggregator) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	entityTypes := core.EntityTypes()
+	for i := len(entityTypes) - 1; i >= 0; i-- {
+		entityType := entityTypes[i]
+		if entityType.ParentType == "" || len(entityType.AggregatedMetrics) == 0 {
+			continue
+		}
+		for key, metricSet := range batch.MetricSets {
+			if core.IsFederated(metricSet) {
+				continue
+			}
+			if metricSet.Labels[core.LabelMetricSetType.Key] != entityType.MetricSetType {
+				continue
+			}
+			parentKey, err := core.EntityKey(entityType.ParentType, metricSet.Labels)
+			if err != nil {
+				glog.Errorf("Cannot aggregate %s: %v", key, err)
+				continue
+			}
+			parent, found := batch.MetricSets[parentKey]
+			if !found {
+				labels, err := core.EntityLabels(entityType.ParentType, metricSet.Labels)
+				if err != nil {
+					glog.Errorf("Cannot aggregate %s: %v", key, err)
+					continue
+				}
+				parent = &core.MetricSet{
+					MetricValues: map[string]core.MetricValue{},
+					Labels:       labels,
+					ScrapeTime:   metricSet.ScrapeTime,
+				}
+				batch.MetricSets[parentKey] = parent
+			}
+			if err := aggregate(metricSet, parent, entityType.AggregatedMetrics); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return batch, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+var (
+	labelTestSite      = core.LabelDescriptor{Key: "test_site", Description: "Name of the site"}
+	labelTestHost      = core.LabelDescriptor{Key: "test_host", Description: "Name of the host"}
+	labelTestContainer = core.LabelDescriptor{Key: "test_container", Description: "Name of the container"}
+)
+
+func init() {
+	for _, t := range []core.EntityType{
+		{MetricSetType: "test_site", NameLabel: labelTestSite},
+		{MetricSetType: "test_host", NameLabel: labelTestHost, ParentType: "test_site",
+			AggregatedMetrics: []string{core.MetricCpuUsageRate.Name}},
+		{MetricSetType: "test_host_container", NameLabel: labelTestContainer, ParentType: "test_host",
+			AggregatedMetrics: []string{core.MetricCpuUsageRate.Name, core.MetricMemoryUsage.Name}},
+	} {
+		if err := core.RegisterEntityType(t); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func testHostContainer(host, container string, cpu, memory int64) *core.MetricSet {
+	return &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: "test_host_container",
+			labelTestSite.Key:           "site1",
+			labelTestHost.Key:           host,
+			labelTestContainer.Key:      container,
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: cpu},
+			core.MetricMemoryUsage.Name:  {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: memory},
+		},
+	}
+}
+
+func TestEntityAggregator(t *testing.T) {
+	host1 := &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: "test_host",
+			labelTestSite.Key:           "site1",
+			labelTestHost.Key:           "host1",
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricMemoryUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1000},
+		},
+	}
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			"test_site:site1/test_host:host1":                       host1,
+			"test_site:site1/test_host:host1/test_host_container:a": testHostContainer("host1", "a", 100, 10),
+			"test_site:site1/test_host:host1/test_host_container:b": testHostContainer("host1", "b", 200, 20),
+			"test_site:site1/test_host:host2/test_host_container:a": testHostContainer("host2", "a", 300, 30),
+		},
+	}
+
+	result, err := (&EntityAggregator{}).Process(batch)
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(300), result.MetricSets["test_site:site1/test_host:host1"].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	// Memory reported by the host itself is not overwritten.
+	assert.Equal(t, int64(1030), result.MetricSets["test_site:site1/test_host:host1"].MetricValues[core.MetricMemoryUsage.Name].IntValue)
+
+	host2, found := result.MetricSets["test_site:site1/test_host:host2"]
+	if assert.True(t, found) {
+		assert.Equal(t, map[string]string{
+			core.LabelMetricSetType.Key: "test_host",
+			labelTestSite.Key:           "site1",
+			labelTestHost.Key:           "host2",
+		}, host2.Labels)
+		assert.Equal(t, int64(300), host2.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	}
+
+	site, found := result.MetricSets["test_site:site1"]
+	if assert.True(t, found) {
+		assert.Equal(t, int64(600), site.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+		assert.NotContains(t, site.MetricValues, core.MetricMemoryUsage.Name)
+	}
+}
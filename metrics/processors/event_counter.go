@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"sync"
+	"time"
+
+	events_core "k8s.io/heapster/events/core"
+	"k8s.io/heapster/metrics/core"
+)
+
+type eventCountKey struct {
+	namespace string
+	reason    string
+	eventType string
+}
+
+type eventCount struct {
+	count int64
+	// Timestamp of the latest batch of events counted.
+	lastEvent time.Time
+}
+
+// EventCounter is an event sink that counts Kubernetes events, and a processor that adds the
+// running totals per reason and type to the namespace MetricSets as the event/count metric.
+// Totals without events for the retention period, or of deleted namespaces, are dropped, so
+// they start over if events come again.
+type EventCounter struct {
+	lock      sync.Mutex
+	retention time.Duration
+	counts    map[eventCountKey]*eventCount
+}
+
+func (this *EventCounter) Name() string {
+	return "event_counter"
+}
+
+func (this *EventCounter) ExportEvents(batch *events_core.EventBatch) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	// Every update of a deduplicated event is delivered again, so each delivery counts as an occurrence.
+	for _, event := range batch.Events {
+		if event.Namespace == "" {
+			continue
+		}
+		key := eventCountKey{
+			namespace: event.Namespace,
+			reason:    event.Reason,
+			eventType: event.Type,
+		}
+		count, found := this.counts[key]
+		if !found {
+			count = &eventCount{}
+			this.counts[key] = count
+		}
+		count.count++
+		if batch.Timestamp.After(count.lastEvent) {
+			count.lastEvent = batch.Timestamp
+		}
+	}
+}
+
+// DeleteNamespace drops the totals of the namespace.
+func (this *EventCounter) DeleteNamespace(namespace string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for key := range this.counts {
+		if key.namespace == namespace {
+			delete(this.counts, key)
+		}
+	}
+}
+
+func (this *EventCounter) Stop() {
+	// Do nothing.
+}
+
+func (this *EventCounter) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for key, count := range this.counts {
+		if batch.Timestamp.Sub(count.lastEvent) > this.retention {
+			delete(this.counts, key)
+			continue
+		}
+		namespaceKey := core.NamespaceKey(key.namespace)
+		namespace, found := batch.MetricSets[namespaceKey]
+		if !found {
+			namespace = namespaceMetricSet(key.namespace, "")
+			batch.MetricSets[namespaceKey] = namespace
+		}
+		namespace.LabeledMetrics = append(namespace.LabeledMetrics, core.LabeledMetric{
+			Name: core.MetricEventCount.Name,
+			Labels: map[string]string{
+				core.LabelEventReason.Key: key.reason,
+				core.LabelEventType.Key:   key.eventType,
+			},
+			MetricValue: core.MetricValue{
+				ValueType:  core.ValueInt64,
+				MetricType: core.MetricCumulative,
+				IntValue:   count.count,
+			},
+		})
+	}
+	return batch, nil
+}
+
+func NewEventCounter(retention time.Duration) *EventCounter {
+	return &EventCounter{
+		retention: retention,
+		counts:    make(map[eventCountKey]*eventCount),
+	}
+}
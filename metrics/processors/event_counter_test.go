@@ -0,0 +1,101 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	events_core "k8s.io/heapster/events/core"
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestEventCounter(t *testing.T) {
+	event := func(namespace, reason, eventType string) *kube_api.Event {
+		return &kube_api.Event{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+			Reason:     reason,
+			Type:       eventType,
+		}
+	}
+	start := time.Now()
+	counter := NewEventCounter(time.Hour)
+	events := &events_core.EventBatch{
+		Timestamp: start,
+		Events: []*kube_api.Event{
+			event("ns1", "FailedScheduling", kube_api.EventTypeWarning),
+			event("ns1", "FailedScheduling", kube_api.EventTypeWarning),
+			event("ns2", "Pulled", kube_api.EventTypeNormal),
+			event("", "NodeReady", kube_api.EventTypeNormal),
+		},
+	}
+
+	process := func(timestamp time.Time) *core.DataBatch {
+		batch, err := counter.Process(&core.DataBatch{
+			Timestamp: timestamp,
+			MetricSets: map[string]*core.MetricSet{
+				core.NamespaceKey("ns1"): namespaceMetricSet("ns1", "uid1"),
+			},
+		})
+		require.NoError(t, err)
+		return batch
+	}
+
+	counter.ExportEvents(events)
+	batch := process(start)
+	assert.Len(t, batch.MetricSets, 2)
+	ns1 := batch.MetricSets[core.NamespaceKey("ns1")]
+	require.Len(t, ns1.LabeledMetrics, 1)
+	assert.Equal(t, core.MetricEventCount.Name, ns1.LabeledMetrics[0].Name)
+	assert.Equal(t, map[string]string{
+		core.LabelEventReason.Key: "FailedScheduling",
+		core.LabelEventType.Key:   kube_api.EventTypeWarning,
+	}, ns1.LabeledMetrics[0].Labels)
+	assert.Equal(t, int64(2), ns1.LabeledMetrics[0].IntValue)
+	assert.Equal(t, core.MetricCumulative, ns1.LabeledMetrics[0].MetricType)
+
+	ns2 := batch.MetricSets[core.NamespaceKey("ns2")]
+	require.NotNil(t, ns2)
+	assert.Equal(t, core.MetricSetTypeNamespace, ns2.Labels[core.LabelMetricSetType.Key])
+	require.Len(t, ns2.LabeledMetrics, 1)
+	assert.Equal(t, int64(1), ns2.LabeledMetrics[0].IntValue)
+
+	// Counts are cumulative across batches.
+	counter.ExportEvents(events)
+	batch = process(start)
+	assert.Equal(t, int64(4), batch.MetricSets[core.NamespaceKey("ns1")].LabeledMetrics[0].IntValue)
+
+	// Counts of deleted namespaces are dropped.
+	counter.DeleteNamespace("ns2")
+	batch = process(start)
+	assert.Len(t, batch.MetricSets, 1)
+
+	// Counts without events for the retention period are dropped.
+	counter.ExportEvents(&events_core.EventBatch{
+		Timestamp: start.Add(30 * time.Minute),
+		Events:    []*kube_api.Event{event("ns2", "Pulled", kube_api.EventTypeNormal)},
+	})
+	batch = process(start.Add(80 * time.Minute))
+	assert.Empty(t, batch.MetricSets[core.NamespaceKey("ns1")].LabeledMetrics)
+	ns2 = batch.MetricSets[core.NamespaceKey("ns2")]
+	require.NotNil(t, ns2)
+	require.Len(t, ns2.LabeledMetrics, 1)
+	assert.Equal(t, int64(1), ns2.LabeledMetrics[0].IntValue)
+}
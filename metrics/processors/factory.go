@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+// DefaultMetricsToAggregate returns the metrics summed by the pod, namespace and cluster
+// aggregators of the Kubernetes pipeline.
+func DefaultMetricsToAggregate() []string {
+	metrics := []string{
+		core.MetricCpuUsageRate.Name,
+		core.MetricMemoryUsage.Name,
+		core.MetricCpuRequest.Name,
+		core.MetricCpuLimit.Name,
+		core.MetricMemoryRequest.Name,
+		core.MetricMemoryLimit.Name,
+	}
+	// Kubelet does not report the accelerators of nodes, so they are summed from the containers.
+	for _, metric := range core.AcceleratorMetrics {
+		metrics = append(metrics, metric.Name)
+	}
+	return metrics
+}
+
+// DefaultNodeMetricsToAggregate returns the metrics summed by the node aggregator of the
+// Kubernetes pipeline.
+func DefaultNodeMetricsToAggregate() []string {
+	metrics := []string{
+		core.MetricCpuRequest.Name,
+		core.MetricCpuLimit.Name,
+		core.MetricMemoryRequest.Name,
+		core.MetricMemoryLimit.Name,
+		core.MetricEphemeralStorageRequest.Name,
+		core.MetricEphemeralStorageLimit.Name,
+	}
+	for _, metric := range core.AcceleratorMetrics {
+		metrics = append(metrics, metric.Name)
+	}
+	return metrics
+}
+
+// ProcessorFactory builds the chain of processors the batches go through from --processor uris,
+// in the order they are given. The kubernetes processor stands for the default pipeline.
+type ProcessorFactory struct {
+	kubernetesUrl *url.URL
+	podLister     v1listers.PodLister
+	labelCopier   *util.LabelCopier
+	// Builds the default pipeline, which also holds the processors enabled by flags.
+	kubernetesPipeline func() ([]core.DataProcessor, error)
+}
+
+func NewProcessorFactory(kubernetesUrl *url.URL, podLister v1listers.PodLister, labelCopier *util.LabelCopier,
+	kubernetesPipeline func() ([]core.DataProcessor, error)) *ProcessorFactory {
+	return &ProcessorFactory{
+		kubernetesUrl:      kubernetesUrl,
+		podLister:          podLister,
+		labelCopier:        labelCopier,
+		kubernetesPipeline: kubernetesPipeline,
+	}
+}
+
+// Build returns the processors of the uri, several for the kubernetes pipeline.
+func (this *ProcessorFactory) Build(uri flags.Uri) ([]core.DataProcessor, error) {
+	var processor core.DataProcessor
+	var err error
+	switch uri.Key {
+	case "kubernetes":
+		return this.kubernetesPipeline()
+	case "rate":
+		processor = NewRateCalculator(core.RateMetricsMapping)
+	case "pod_enricher":
+		processor, err = NewPodBasedEnricher(this.podLister, this.labelCopier)
+	case "namespace_enricher":
+		processor, err = NewNamespaceBasedEnricher(this.kubernetesUrl)
+	case "node_autoscaling_enricher":
+		processor, err = NewNodeAutoscalingEnricher(this.kubernetesUrl, this.labelCopier)
+	case "node_condition_enricher":
+		processor, err = NewNodeConditionEnricher(this.kubernetesUrl)
+	case "pod_aggregator":
+		processor = NewPodAggregator()
+	case "namespace_aggregator":
+		processor = &NamespaceAggregator{MetricsToAggregate: getMetrics(&uri.Val, DefaultMetricsToAggregate())}
+	case "node_aggregator":
+		processor = &NodeAggregator{MetricsToAggregate: getMetrics(&uri.Val, DefaultNodeMetricsToAggregate())}
+	case "cluster_aggregator":
+		processor = &ClusterAggregator{MetricsToAggregate: getMetrics(&uri.Val, DefaultMetricsToAggregate())}
+	case "entity_aggregator":
+		processor = &EntityAggregator{}
+	case "namespace_filter":
+		processor, err = NewNamespaceFilter(&uri.Val)
+	default:
+		return nil, fmt.Errorf("Processor not recognized: %s", uri.Key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s processor: %v", uri.Key, err)
+	}
+	return []core.DataProcessor{processor}, nil
+}
+
+// BuildAll returns the processors of the uris, in the same order, or the kubernetes pipeline if
+// no uri is given.
+func (this *ProcessorFactory) BuildAll(uris flags.Uris) ([]core.DataProcessor, error) {
+	if len(uris) == 0 {
+		return this.kubernetesPipeline()
+	}
+	result := []core.DataProcessor{}
+	for _, uri := range uris {
+		processors, err := this.Build(uri)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, processors...)
+	}
+	return result, nil
+}
+
+// getMetrics returns the comma separated metrics option of the uri, or defaultMetrics.
+func getMetrics(uri *url.URL, defaultMetrics []string) []string {
+	value := uri.Query().Get("metrics")
+	if value == "" {
+		return defaultMetrics
+	}
+	metrics := []string{}
+	for _, metric := range strings.Split(value, ",") {
+		if metric = strings.TrimSpace(metric); metric != "" {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/core"
+)
+
+func parseUris(t *testing.T, values ...string) flags.Uris {
+	var uris flags.Uris
+	for _, value := range values {
+		require.NoError(t, uris.Set(value))
+	}
+	return uris
+}
+
+func processorNames(processors []core.DataProcessor) []string {
+	names := []string{}
+	for _, processor := range processors {
+		names = append(names, processor.Name())
+	}
+	return names
+}
+
+func TestProcessorFactory(t *testing.T) {
+	pipeline := []core.DataProcessor{NewPodAggregator(), &EntityAggregator{}}
+	factory := NewProcessorFactory(nil, nil, nil, func() ([]core.DataProcessor, error) {
+		return pipeline, nil
+	})
+
+	processors, err := factory.BuildAll(nil)
+	require.NoError(t, err)
+	assert.Equal(t, pipeline, processors, "default pipeline")
+
+	processors, err = factory.BuildAll(parseUris(t, "namespace_filter:?exclude=kube-*", "kubernetes", "rate",
+		"cluster_aggregator:?metrics=cpu/usage_rate"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"namespace_filter", "pod_aggregator", "entity_aggregator", "rate calculator", "cluster_aggregator"},
+		processorNames(processors))
+	assert.Equal(t, []string{"cpu/usage_rate"}, processors[4].(*ClusterAggregator).MetricsToAggregate)
+
+	_, err = factory.BuildAll(parseUris(t, "kubernetes", "unknown"))
+	assert.Error(t, err)
+	_, err = factory.BuildAll(parseUris(t, "namespace_filter"))
+	assert.Error(t, err, "namespace filter without patterns")
+}
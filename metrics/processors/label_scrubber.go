@@ -0,0 +1,230 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	// Value replacing the redacted label values.
+	RedactedLabelValue = "redacted"
+	// Number of hex digits of the hashes replacing the hashed label values.
+	hashedLabelValueLength = 16
+)
+
+// The labels holding the names of the parts of the metric set keys, e.g. pod for
+// namespace:ns/pod:name, which are scrubbed as the labels.
+var keyPartLabels = map[string]string{
+	"cluster":   core.LabelClusterName.Key,
+	"namespace": core.LabelNamespaceName.Key,
+	"pod":       core.LabelPodName.Key,
+	"uid":       core.LabelPodId.Key,
+	"container": core.LabelContainerName.Key,
+	"node":      core.LabelNodename.Key,
+}
+
+// LabelTransform replaces a sensitive label value. The key is the secret of the scrubber, empty
+// if none is configured.
+type LabelTransform func(value string, key []byte) string
+
+var (
+	labelTransformsLock sync.RWMutex
+	labelTransforms     = map[string]LabelTransform{
+		"hash":   hashLabelValue,
+		"redact": redactLabelValue,
+	}
+)
+
+// RegisterLabelTransform makes the transform available to the label scrubbers under the given
+// name, replacing the transform registered with that name, if any.
+func RegisterLabelTransform(name string, transform LabelTransform) {
+	labelTransformsLock.Lock()
+	defer labelTransformsLock.Unlock()
+	labelTransforms[name] = transform
+}
+
+// hashLabelValue replaces the value with the beginning of its HMAC-SHA256 with the key, or of its
+// SHA-256 without key, so that series stay distinguishable.
+func hashLabelValue(value string, key []byte) string {
+	if value == "" {
+		return ""
+	}
+	var sum []byte
+	if len(key) > 0 {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(value))
+		sum = mac.Sum(nil)
+	} else {
+		hash := sha256.Sum256([]byte(value))
+		sum = hash[:]
+	}
+	return hex.EncodeToString(sum)[:hashedLabelValueLength]
+}
+
+func redactLabelValue(value string, key []byte) string {
+	if value == "" {
+		return ""
+	}
+	return RedactedLabelValue
+}
+
+// LabelScrubber replaces the values of sensitive labels, e.g. the names of pods containing user
+// names, before the metrics are exported to third-party services. Batches are copied, not
+// modified, so that the other sinks still get the original values.
+type LabelScrubber struct {
+	transforms map[string]LabelTransform
+	key        []byte
+	// Secret of the suffixes of the colliding keys, the key or a random one, since the original
+	// keys could be guessed from unkeyed hashes.
+	collisionKey []byte
+}
+
+// NewLabelScrubber returns a scrubber applying the transforms given by label, e.g.
+// pod_name:hash,labels:redact. The key is the secret of the hash transform.
+func NewLabelScrubber(specs []string, key []byte) (*LabelScrubber, error) {
+	labelTransformsLock.RLock()
+	defer labelTransformsLock.RUnlock()
+	transforms := make(map[string]LabelTransform, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid sensitive label %q, expected label:transform", spec)
+		}
+		transform, found := labelTransforms[parts[1]]
+		if !found {
+			return nil, fmt.Errorf("unknown transform %q of label %s", parts[1], parts[0])
+		}
+		transforms[parts[0]] = transform
+	}
+	collisionKey := key
+	if len(collisionKey) == 0 {
+		collisionKey = make([]byte, sha256.Size)
+		if _, err := rand.Read(collisionKey); err != nil {
+			return nil, fmt.Errorf("failed to generate the key of the colliding keys: %v", err)
+		}
+	}
+	return &LabelScrubber{
+		transforms:   transforms,
+		key:          key,
+		collisionKey: collisionKey,
+	}, nil
+}
+
+func (this *LabelScrubber) Name() string {
+	return "label_scrubber"
+}
+
+func (this *LabelScrubber) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	result := *batch
+	result.MetricSets = make(map[string]*core.MetricSet, len(batch.MetricSets))
+	// The same values, e.g. the namespace names, appear in many metric sets.
+	scrubbed := map[string]string{}
+	// The keys are sorted so that the keys which collide once scrubbed, e.g. those of redacted
+	// pods, are told apart in the same way for every batch.
+	keys := make([]string, 0, len(batch.MetricSets))
+	for key := range batch.MetricSets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		metricSet := batch.MetricSets[key]
+		copied := *metricSet
+		copied.Labels = this.scrub(metricSet.Labels, scrubbed)
+		if len(metricSet.LabeledMetrics) > 0 {
+			copied.LabeledMetrics = make([]core.LabeledMetric, len(metricSet.LabeledMetrics))
+			for i, metric := range metricSet.LabeledMetrics {
+				metric.Labels = this.scrub(metric.Labels, scrubbed)
+				copied.LabeledMetrics[i] = metric
+			}
+		}
+		scrubbedKey := this.scrubKey(key, scrubbed)
+		if _, found := result.MetricSets[scrubbedKey]; found {
+			scrubbedKey += "/scrubbed:" + hashLabelValue(key, this.collisionKey)
+		}
+		result.MetricSets[scrubbedKey] = &copied
+	}
+	return &result, nil
+}
+
+// scrubKey returns the key of a metric set with the names of its parts replaced as the values of
+// their labels, since sinks export the keys too, e.g. in the keys of Kafka messages.
+func (this *LabelScrubber) scrubKey(key string, scrubbed map[string]string) string {
+	parts := strings.Split(key, "/")
+	changed := false
+	for i, part := range parts {
+		kindAndName := strings.SplitN(part, ":", 2)
+		if len(kindAndName) != 2 {
+			continue
+		}
+		label, found := keyPartLabels[kindAndName[0]]
+		if !found {
+			continue
+		}
+		if _, found := this.transforms[label]; !found {
+			continue
+		}
+		parts[i] = kindAndName[0] + ":" + this.scrubValue(label, kindAndName[1], scrubbed)
+		changed = true
+	}
+	if !changed {
+		return key
+	}
+	return strings.Join(parts, "/")
+}
+
+// scrub returns a copy of the labels with the values of the sensitive ones replaced, or the labels
+// themselves if none is sensitive.
+func (this *LabelScrubber) scrub(labels map[string]string, scrubbed map[string]string) map[string]string {
+	sensitive := false
+	for label := range labels {
+		if _, found := this.transforms[label]; found {
+			sensitive = true
+			break
+		}
+	}
+	if !sensitive {
+		return labels
+	}
+	result := make(map[string]string, len(labels))
+	for label, value := range labels {
+		if _, found := this.transforms[label]; !found {
+			result[label] = value
+			continue
+		}
+		result[label] = this.scrubValue(label, value, scrubbed)
+	}
+	return result
+}
+
+// scrubValue returns the value of the sensitive label replaced by its transform.
+func (this *LabelScrubber) scrubValue(label, value string, scrubbed map[string]string) string {
+	cacheKey := label + "=" + value
+	replaced, found := scrubbed[cacheKey]
+	if !found {
+		replaced = this.transforms[label](value, this.key)
+		scrubbed[cacheKey] = replaced
+	}
+	return replaced
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestLabelScrubber(t *testing.T) {
+	scrubber, err := NewLabelScrubber([]string{"pod_name:hash", "labels:redact"}, []byte("secret"))
+	require.NoError(t, err)
+
+	podLabels := map[string]string{
+		core.LabelPodName.Key:       "jupyter-alice",
+		core.LabelNamespaceName.Key: "notebooks",
+		core.LabelLabels.Key:        "user:alice",
+	}
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("notebooks", "jupyter-alice"): {
+				Labels: podLabels,
+				LabeledMetrics: []core.LabeledMetric{{
+					Name:   "custom/requests",
+					Labels: map[string]string{core.LabelPodName.Key: "jupyter-alice", "path": "/"},
+				}},
+			},
+			core.PodKey("notebooks", "jupyter-bob"): {
+				Labels: map[string]string{core.LabelPodName.Key: "jupyter-bob"},
+			},
+			core.NamespaceKey("notebooks"): {
+				Labels: map[string]string{core.LabelNamespaceName.Key: "notebooks"},
+			},
+		},
+	}
+	scrubbed, err := scrubber.Process(batch)
+	require.NoError(t, err)
+
+	// The keys are scrubbed as the labels, since sinks export them too.
+	require.Len(t, scrubbed.MetricSets, 3)
+	for key := range scrubbed.MetricSets {
+		assert.False(t, strings.Contains(key, "alice"), key)
+		assert.False(t, strings.Contains(key, "bob"), key)
+	}
+	assert.Contains(t, scrubbed.MetricSets, core.NamespaceKey("notebooks"))
+
+	hashed := hashLabelValue("jupyter-alice", []byte("secret"))
+	alice := scrubbed.MetricSets[core.PodKey("notebooks", hashed)]
+	require.NotNil(t, alice)
+	assert.Equal(t, hashed, alice.Labels[core.LabelPodName.Key])
+	assert.Len(t, hashed, hashedLabelValueLength)
+	assert.False(t, strings.Contains(hashed, "alice"))
+	assert.Equal(t, RedactedLabelValue, alice.Labels[core.LabelLabels.Key])
+	assert.Equal(t, "notebooks", alice.Labels[core.LabelNamespaceName.Key])
+	assert.Equal(t, hashed, alice.LabeledMetrics[0].Labels[core.LabelPodName.Key])
+	assert.Equal(t, "/", alice.LabeledMetrics[0].Labels["path"])
+	// Series stay distinguishable.
+	bob := scrubbed.MetricSets[core.PodKey("notebooks", hashLabelValue("jupyter-bob", []byte("secret")))]
+	require.NotNil(t, bob)
+	assert.NotEqual(t, hashed, bob.Labels[core.LabelPodName.Key])
+
+	// The original batch is not modified.
+	assert.Equal(t, "jupyter-alice", podLabels[core.LabelPodName.Key])
+	assert.Equal(t, "jupyter-alice", batch.MetricSets[core.PodKey("notebooks", "jupyter-alice")].LabeledMetrics[0].Labels[core.LabelPodName.Key])
+
+	// The hash depends on the key.
+	unkeyed, err := NewLabelScrubber([]string{"pod_name:hash"}, nil)
+	require.NoError(t, err)
+	scrubbed, err = unkeyed.Process(batch)
+	require.NoError(t, err)
+	assert.NotContains(t, scrubbed.MetricSets, core.PodKey("notebooks", hashed))
+	unkeyedAlice := scrubbed.MetricSets[core.PodKey("notebooks", hashLabelValue("jupyter-alice", nil))]
+	require.NotNil(t, unkeyedAlice)
+	assert.NotEqual(t, hashed, unkeyedAlice.Labels[core.LabelPodName.Key])
+}
+
+func TestLabelScrubberKeyCollisions(t *testing.T) {
+	scrubber, err := NewLabelScrubber([]string{"pod_name:redact"}, nil)
+	require.NoError(t, err)
+	scrubbed, err := scrubber.Process(&core.DataBatch{
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("default", "a"):                  {Labels: map[string]string{core.LabelPodName.Key: "a"}},
+			core.PodKey("default", "b"):                  {Labels: map[string]string{core.LabelPodName.Key: "b"}},
+			core.PodContainerKey("default", "a", "main"): {Labels: map[string]string{core.LabelPodName.Key: "a"}},
+		},
+	})
+	require.NoError(t, err)
+	// Redacted pods have the same scrubbed keys, which are told apart so that no metric set is lost.
+	assert.Len(t, scrubbed.MetricSets, 3)
+	assert.Contains(t, scrubbed.MetricSets, core.PodKey("default", RedactedLabelValue))
+	assert.Contains(t, scrubbed.MetricSets, core.PodContainerKey("default", RedactedLabelValue, "main"))
+	// Without key, the suffix is not the plain hash of the original key, which could be guessed.
+	assert.NotContains(t, scrubbed.MetricSets,
+		core.PodKey("default", RedactedLabelValue)+"/scrubbed:"+hashLabelValue(core.PodKey("default", "b"), nil))
+}
+
+func TestLabelScrubberTransforms(t *testing.T) {
+	_, err := NewLabelScrubber([]string{"pod_name"}, nil)
+	assert.Error(t, err)
+	_, err = NewLabelScrubber([]string{"pod_name:reverse"}, nil)
+	assert.Error(t, err)
+
+	RegisterLabelTransform("reverse", func(value string, key []byte) string {
+		runes := []rune(value)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes)
+	})
+	scrubber, err := NewLabelScrubber([]string{"pod_name:reverse"}, nil)
+	require.NoError(t, err)
+	scrubbed, err := scrubber.Process(&core.DataBatch{
+		MetricSets: map[string]*core.MetricSet{
+			"pod": {Labels: map[string]string{core.LabelPodName.Key: "abc"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "cba", scrubbed.MetricSets["pod"].Labels[core.LabelPodName.Key])
+}
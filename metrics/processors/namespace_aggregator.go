@@ -30,6 +30,9 @@ func (this *NamespaceAggregator) Name() string {
 func (this *NamespaceAggregator) Process(batch *core.DataBatch) (*core.DataBatch, error) {
 	namespaces := make(map[string]*core.MetricSet)
 	for key, metricSet := range batch.MetricSets {
+		if core.IsFederated(metricSet) {
+			continue
+		}
 		if metricSetType, found := metricSet.Labels[core.LabelMetricSetType.Key]; !found || metricSetType != core.MetricSetTypePod {
 			continue
 		}
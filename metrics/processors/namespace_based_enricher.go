@@ -16,22 +16,17 @@ package processors
 
 import (
 	"net/url"
-	"time"
 
 	"github.com/golang/glog"
 
 	kube_api "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/util/wait"
-	kube_client "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
-	kube_config "k8s.io/heapster/common/kubernetes"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
 )
 
 type NamespaceBasedEnricher struct {
-	store     cache.Store
-	reflector *cache.Reflector
+	store cache.Store
 }
 
 func (this *NamespaceBasedEnricher) Name() string {
@@ -40,6 +35,9 @@ func (this *NamespaceBasedEnricher) Name() string {
 
 func (this *NamespaceBasedEnricher) Process(batch *core.DataBatch) (*core.DataBatch, error) {
 	for _, ms := range batch.MetricSets {
+		if core.IsFederated(ms) {
+			continue
+		}
 		this.addNamespaceInfo(ms)
 	}
 	return batch, nil
@@ -78,20 +76,13 @@ func (this *NamespaceBasedEnricher) addNamespaceInfo(metricSet *core.MetricSet)
 }
 
 func NewNamespaceBasedEnricher(url *url.URL) (*NamespaceBasedEnricher, error) {
-	kubeConfig, err := kube_config.GetKubeClientConfig(url)
+	// watch namespaces
+	store, err := util.GetSharedNamespaceStore(url)
 	if err != nil {
 		return nil, err
 	}
-	kubeClient := kube_client.NewForConfigOrDie(kubeConfig)
-
-	// watch nodes
-	lw := cache.NewListWatchFromClient(kubeClient.CoreV1().RESTClient(), "namespaces", kube_api.NamespaceAll, fields.Everything())
-	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
-	reflector := cache.NewReflector(lw, &kube_api.Namespace{}, store, time.Hour)
-	go reflector.Run(wait.NeverStop)
 
 	return &NamespaceBasedEnricher{
-		store:     store,
-		reflector: reflector,
+		store: store,
 	}, nil
 }
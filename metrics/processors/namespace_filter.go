@@ -0,0 +1,85 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+// NamespaceFilter drops the metric sets of the namespaces which are not included, or which are
+// excluded, by patterns like kube-*. Metric sets without namespace, e.g. nodes, are kept.
+type NamespaceFilter struct {
+	include []string
+	exclude []string
+}
+
+func (this *NamespaceFilter) Name() string {
+	return "namespace_filter"
+}
+
+func (this *NamespaceFilter) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	for key, metricSet := range batch.MetricSets {
+		namespace := metricSet.Labels[core.LabelNamespaceName.Key]
+		if namespace != "" && !this.allows(namespace) {
+			delete(batch.MetricSets, key)
+		}
+	}
+	return batch, nil
+}
+
+func (this *NamespaceFilter) allows(namespace string) bool {
+	if len(this.include) > 0 && !matchesAny(this.include, namespace) {
+		return false
+	}
+	return !matchesAny(this.exclude, namespace)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNamespaceFilter returns a filter configured by the include and exclude options of the uri,
+// comma separated namespace patterns, e.g. ?exclude=kube-*,istio-system.
+func NewNamespaceFilter(uri *url.URL) (*NamespaceFilter, error) {
+	opts := uri.Query()
+	filter := &NamespaceFilter{}
+	for option, patterns := range map[string]*[]string{"include": &filter.include, "exclude": &filter.exclude} {
+		for _, value := range opts[option] {
+			for _, pattern := range strings.Split(value, ",") {
+				if pattern = strings.TrimSpace(pattern); pattern == "" {
+					continue
+				}
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("invalid %s pattern %q: %v", option, pattern, err)
+				}
+				*patterns = append(*patterns, pattern)
+			}
+		}
+	}
+	if len(filter.include) == 0 && len(filter.exclude) == 0 {
+		return nil, fmt.Errorf("at least one include or exclude pattern is required")
+	}
+	return filter, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestNamespaceFilter(t *testing.T) {
+	namespaceSet := func(namespace string) *core.MetricSet {
+		return &core.MetricSet{Labels: map[string]string{core.LabelNamespaceName.Key: namespace}}
+	}
+	newBatch := func() *core.DataBatch {
+		return &core.DataBatch{MetricSets: map[string]*core.MetricSet{
+			"ns:kube-system": namespaceSet("kube-system"),
+			"ns:team-a":      namespaceSet("team-a"),
+			"ns:team-b":      namespaceSet("team-b"),
+			"ns:other":       namespaceSet("other"),
+			"node:node-1":    {Labels: map[string]string{core.LabelNodename.Key: "node-1"}},
+		}}
+	}
+	keys := func(batch *core.DataBatch) map[string]bool {
+		result := map[string]bool{}
+		for key := range batch.MetricSets {
+			result[key] = true
+		}
+		return result
+	}
+
+	uri, _ := url.Parse("?exclude=kube-*")
+	filter, err := NewNamespaceFilter(uri)
+	require.NoError(t, err)
+	batch, err := filter.Process(newBatch())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ns:team-a": true, "ns:team-b": true, "ns:other": true, "node:node-1": true}, keys(batch))
+
+	uri, _ = url.Parse("?include=team-*&exclude=team-b")
+	filter, err = NewNamespaceFilter(uri)
+	require.NoError(t, err)
+	batch, err = filter.Process(newBatch())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ns:team-a": true, "node:node-1": true}, keys(batch))
+
+	uri, _ = url.Parse("?include=[")
+	_, err = NewNamespaceFilter(uri)
+	assert.Error(t, err)
+}
@@ -30,6 +30,9 @@ func (this *NodeAggregator) Name() string {
 
 func (this *NodeAggregator) Process(batch *core.DataBatch) (*core.DataBatch, error) {
 	for key, metricSet := range batch.MetricSets {
+		if core.IsFederated(metricSet) {
+			continue
+		}
 		if metricSetType, found := metricSet.Labels[core.LabelMetricSetType.Key]; !found || metricSetType != core.MetricSetTypePod {
 			continue
 		}
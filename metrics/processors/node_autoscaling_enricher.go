@@ -19,17 +19,13 @@ import (
 
 	kube_api "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	kube_client "k8s.io/client-go/kubernetes"
 	v1listers "k8s.io/client-go/listers/core/v1"
-	"k8s.io/client-go/tools/cache"
-	kube_config "k8s.io/heapster/common/kubernetes"
 	"k8s.io/heapster/metrics/core"
 	"k8s.io/heapster/metrics/util"
 )
 
 type NodeAutoscalingEnricher struct {
 	nodeLister  v1listers.NodeLister
-	reflector   *cache.Reflector
 	labelCopier *util.LabelCopier
 }
 
@@ -102,18 +98,14 @@ func setFloat(metricSet *core.MetricSet, metric *core.Metric, value float64) {
 }
 
 func NewNodeAutoscalingEnricher(url *url.URL, labelCopier *util.LabelCopier) (*NodeAutoscalingEnricher, error) {
-	kubeConfig, err := kube_config.GetKubeClientConfig(url)
+	// watch nodes
+	nodeLister, err := util.GetSharedNodeLister(url)
 	if err != nil {
 		return nil, err
 	}
-	kubeClient := kube_client.NewForConfigOrDie(kubeConfig)
-
-	// watch nodes
-	nodeLister, reflector, _ := util.GetNodeLister(kubeClient)
 
 	return &NodeAutoscalingEnricher{
 		nodeLister:  nodeLister,
-		reflector:   reflector,
 		labelCopier: labelCopier,
 	}, nil
 }
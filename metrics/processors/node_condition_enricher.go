@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"net/url"
+
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+// NodeConditionEnricher exports the conditions of the nodes, including those set by the node
+// problem detector, and the time of their last transition. Nodes which could not be scraped
+// are reported too, since their conditions matter most.
+type NodeConditionEnricher struct {
+	nodeLister v1listers.NodeLister
+}
+
+func (this *NodeConditionEnricher) Name() string {
+	return "node_condition_enricher"
+}
+
+func (this *NodeConditionEnricher) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	nodes, err := this.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		key := core.NodeKey(node.Name)
+		metricSet, found := batch.MetricSets[key]
+		if !found {
+			metricSet = &core.MetricSet{
+				MetricValues: map[string]core.MetricValue{},
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					core.LabelNodename.Key:      node.Name,
+					core.LabelHostname.Key:      node.Name,
+				},
+				ScrapeTime: batch.Timestamp,
+			}
+			batch.MetricSets[key] = metricSet
+		}
+		for _, condition := range node.Status.Conditions {
+			status := int64(0)
+			if condition.Status == kube_api.ConditionTrue {
+				status = 1
+			}
+			labels := map[string]string{core.LabelNodeCondition.Key: string(condition.Type)}
+			metricSet.LabeledMetrics = append(metricSet.LabeledMetrics, core.LabeledMetric{
+				Name:        core.MetricNodeCondition.Name,
+				Labels:      labels,
+				MetricValue: intValue(status),
+			})
+			if !condition.LastTransitionTime.IsZero() {
+				metricSet.LabeledMetrics = append(metricSet.LabeledMetrics, core.LabeledMetric{
+					Name:        core.MetricNodeConditionLastTransitionTime.Name,
+					Labels:      labels,
+					MetricValue: intValue(condition.LastTransitionTime.UnixNano() / 1e6),
+				})
+			}
+		}
+	}
+	return batch, nil
+}
+
+func NewNodeConditionEnricher(url *url.URL) (*NodeConditionEnricher, error) {
+	nodeLister, err := util.GetSharedNodeLister(url)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeConditionEnricher{nodeLister: nodeLister}, nil
+}
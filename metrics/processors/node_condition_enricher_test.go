@@ -0,0 +1,80 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestNodeConditionEnricher(t *testing.T) {
+	transition := time.Unix(1500000000, 0)
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	store.Add(&kube_api.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: kube_api.NodeStatus{
+			Conditions: []kube_api.NodeCondition{
+				{Type: kube_api.NodeReady, Status: kube_api.ConditionTrue, LastTransitionTime: metav1.NewTime(transition)},
+				{Type: kube_api.NodeMemoryPressure, Status: kube_api.ConditionFalse},
+			},
+		},
+	})
+	store.Add(&kube_api.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+		Status: kube_api.NodeStatus{
+			Conditions: []kube_api.NodeCondition{
+				{Type: kube_api.NodeReady, Status: kube_api.ConditionUnknown},
+			},
+		},
+	})
+	enricher := &NodeConditionEnricher{nodeLister: v1listers.NewNodeLister(store)}
+
+	batch, err := enricher.Process(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): {
+				Labels:       map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeNode},
+				MetricValues: map[string]core.MetricValue{},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	node1 := batch.MetricSets[core.NodeKey("node1")]
+	require.Len(t, node1.LabeledMetrics, 3)
+	assert.Equal(t, core.MetricNodeCondition.Name, node1.LabeledMetrics[0].Name)
+	assert.Equal(t, "Ready", node1.LabeledMetrics[0].Labels[core.LabelNodeCondition.Key])
+	assert.Equal(t, int64(1), node1.LabeledMetrics[0].IntValue)
+	assert.Equal(t, core.MetricNodeConditionLastTransitionTime.Name, node1.LabeledMetrics[1].Name)
+	assert.Equal(t, int64(1500000000000), node1.LabeledMetrics[1].IntValue)
+	assert.Equal(t, "MemoryPressure", node1.LabeledMetrics[2].Labels[core.LabelNodeCondition.Key])
+	assert.Equal(t, int64(0), node1.LabeledMetrics[2].IntValue)
+
+	// The node which was not scraped is reported too.
+	node2, found := batch.MetricSets[core.NodeKey("node2")]
+	require.True(t, found)
+	assert.Equal(t, core.MetricSetTypeNode, node2.Labels[core.LabelMetricSetType.Key])
+	require.Len(t, node2.LabeledMetrics, 1)
+	assert.Equal(t, int64(0), node2.LabeledMetrics[0].IntValue)
+}
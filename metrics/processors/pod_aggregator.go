@@ -33,6 +33,9 @@ var LabelsToPopulate = []core.LabelDescriptor{
 
 type PodAggregator struct {
 	skippedMetrics map[string]struct{}
+	// If set, the usage of the sidecar containers, labeled by the SidecarClassifier, is not part
+	// of the pod metrics, and is reported by labeled metrics of the pod with sidecar=true instead.
+	SeparateSidecars bool
 }
 
 func (this *PodAggregator) Name() string {
@@ -45,9 +48,16 @@ func (this *PodAggregator) Process(batch *core.DataBatch) (*core.DataBatch, erro
 	// If pod already has pod-level metrics, it no longer needs to aggregates its container's metrics.
 	requireAggregate := make(map[string]bool)
 	for key, metricSet := range batch.MetricSets {
+		if core.IsFederated(metricSet) {
+			continue
+		}
 		if metricSetType, found := metricSet.Labels[core.LabelMetricSetType.Key]; !found || metricSetType != core.MetricSetTypePodContainer {
 			continue
 		}
+		if metricSet.Labels[core.LabelContainerTerminated.Key] == "true" {
+			// The final sample of a terminated container is not part of the current usage of the pod.
+			continue
+		}
 
 		// Aggregating containers
 		podName, found := metricSet.Labels[core.LabelPodName.Key]
@@ -57,7 +67,7 @@ func (this *PodAggregator) Process(batch *core.DataBatch) (*core.DataBatch, erro
 			continue
 		}
 
-		podKey := core.PodKey(ns, podName)
+		podKey := core.PodKeyWithUID(ns, podName, metricSet.Labels[core.LabelPodId.Key])
 		pod, found := batch.MetricSets[podKey]
 		if !found {
 			pod, found = newPods[podKey]
@@ -68,6 +78,14 @@ func (this *PodAggregator) Process(batch *core.DataBatch) (*core.DataBatch, erro
 			}
 		}
 
+		if this.SeparateSidecars && metricSet.Labels[core.LabelSidecar.Key] == "true" {
+			if err := this.aggregateSidecar(metricSet, pod); err != nil {
+				return nil, err
+			}
+			this.subtractSidecar(metricSet, pod, podKey, requireAggregate)
+			continue
+		}
+
 		for metricName, metricValue := range metricSet.MetricValues {
 			if _, found := this.skippedMetrics[metricName]; found {
 				continue
@@ -104,6 +122,79 @@ func (this *PodAggregator) Process(batch *core.DataBatch) (*core.DataBatch, erro
 	return batch, nil
 }
 
+// aggregateSidecar adds the metrics of the sidecar container to the labeled metrics of the pod
+// reporting the usage of its sidecars.
+func (this *PodAggregator) aggregateSidecar(container, pod *core.MetricSet) error {
+	for metricName, metricValue := range container.MetricValues {
+		if _, found := this.skippedMetrics[metricName]; found {
+			continue
+		}
+		aggregated := false
+		for i := range pod.LabeledMetrics {
+			metric := &pod.LabeledMetrics[i]
+			if metric.Name != metricName || metric.Labels[core.LabelSidecar.Key] != "true" {
+				continue
+			}
+			if metric.ValueType != metricValue.ValueType {
+				glog.Errorf("PodAggregator: inconsistent type in %s", metricName)
+				aggregated = true
+				break
+			}
+			switch metric.ValueType {
+			case core.ValueInt64:
+				metric.IntValue += metricValue.IntValue
+			case core.ValueFloat:
+				metric.FloatValue += metricValue.FloatValue
+			default:
+				return fmt.Errorf("PodAggregator: type not supported in %s", metricName)
+			}
+			aggregated = true
+			break
+		}
+		if !aggregated {
+			pod.LabeledMetrics = append(pod.LabeledMetrics, core.LabeledMetric{
+				Name:        metricName,
+				Labels:      map[string]string{core.LabelSidecar.Key: "true"},
+				MetricValue: metricValue,
+			})
+		}
+	}
+	return nil
+}
+
+// subtractSidecar removes the usage of the sidecar container from the pod metrics reported by the
+// source, e.g. by the summary API of the kubelets, which include all the containers of the pod.
+// Metrics aggregated from the containers never include the sidecars.
+func (this *PodAggregator) subtractSidecar(container, pod *core.MetricSet, podKey string, requireAggregate map[string]bool) {
+	for metricName, metricValue := range container.MetricValues {
+		if _, found := this.skippedMetrics[metricName]; found {
+			continue
+		}
+		podValue, found := pod.MetricValues[metricName]
+		if !found || requireAggregate[podKey+metricName] {
+			continue
+		}
+		if podValue.ValueType != metricValue.ValueType {
+			glog.Errorf("PodAggregator: inconsistent type in %s", metricName)
+			continue
+		}
+		// The pod and its containers are not sampled at exactly the same time.
+		switch podValue.ValueType {
+		case core.ValueInt64:
+			podValue.IntValue -= metricValue.IntValue
+			if podValue.IntValue < 0 {
+				podValue.IntValue = 0
+			}
+		case core.ValueFloat:
+			podValue.FloatValue -= metricValue.FloatValue
+			if podValue.FloatValue < 0 {
+				podValue.FloatValue = 0
+			}
+		}
+		pod.MetricValues[metricName] = podValue
+	}
+}
+
 func (this *PodAggregator) podMetricSet(labels map[string]string) *core.MetricSet {
 	newLabels := map[string]string{
 		core.LabelMetricSetType.Key: core.MetricSetTypePod,
@@ -141,3 +141,75 @@ func TestPodAggregator(t *testing.T) {
 	assert.Equal(t, int64(20), m2.IntValue)
 
 }
+
+func TestPodAggregatorWithUIDKeys(t *testing.T) {
+	core.SetPodKeyFunc(core.PodKeyFuncs["uid"])
+	defer core.SetPodKeyFunc(core.PodKeyFuncs["name"])
+
+	containerMetricSet := func(uid string, value int64) *core.MetricSet {
+		return &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+				core.LabelPodName.Key:       "pod1",
+				core.LabelNamespaceName.Key: "ns1",
+				core.LabelPodId.Key:         uid,
+			},
+			MetricValues: map[string]core.MetricValue{
+				"m1": {
+					ValueType:  core.ValueInt64,
+					MetricType: core.MetricGauge,
+					IntValue:   value,
+				},
+			},
+		}
+	}
+	batch := core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKeyWithUID("ns1", "pod1", "uid1", "c1"): containerMetricSet("uid1", 10),
+			core.PodContainerKeyWithUID("ns1", "pod1", "uid2", "c1"): containerMetricSet("uid2", 20),
+		},
+	}
+	processor := PodAggregator{}
+	result, err := processor.Process(&batch)
+	assert.NoError(t, err)
+
+	// Pods recreated with the same name are aggregated separately.
+	pod1, found := result.MetricSets[core.PodKeyWithUID("ns1", "pod1", "uid1")]
+	assert.True(t, found)
+	assert.Equal(t, int64(10), pod1.MetricValues["m1"].IntValue)
+	pod2, found := result.MetricSets[core.PodKeyWithUID("ns1", "pod1", "uid2")]
+	assert.True(t, found)
+	assert.Equal(t, int64(20), pod2.MetricValues["m1"].IntValue)
+	assert.NotEqual(t, core.PodKeyWithUID("ns1", "pod1", "uid1"), core.PodKeyWithUID("ns1", "pod1", "uid2"))
+}
+
+func TestPodAggregatorSkipsFederatedMetricSets(t *testing.T) {
+	key := core.FederatedKey("a", core.PodContainerKey("ns1", "pod1", "c1"))
+	batch := core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			key: {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+					core.LabelPodName.Key:       "pod1",
+					core.LabelNamespaceName.Key: "ns1",
+					core.LabelClusterName.Key:   "a",
+				},
+				MetricValues: map[string]core.MetricValue{
+					"m1": {
+						ValueType:  core.ValueInt64,
+						MetricType: core.MetricGauge,
+						IntValue:   10,
+					},
+				},
+			},
+		},
+	}
+	processor := PodAggregator{}
+	result, err := processor.Process(&batch)
+	assert.NoError(t, err)
+	// The Heapster of the member cluster exports the pods already.
+	assert.Len(t, result.MetricSets, 1)
+	assert.Contains(t, result.MetricSets, key)
+}
@@ -16,6 +16,7 @@ package processors
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/golang/glog"
 
@@ -38,6 +39,9 @@ func (this *PodBasedEnricher) Name() string {
 func (this *PodBasedEnricher) Process(batch *core.DataBatch) (*core.DataBatch, error) {
 	newMs := make(map[string]*core.MetricSet, len(batch.MetricSets))
 	for k, v := range batch.MetricSets {
+		if core.IsFederated(v) {
+			continue
+		}
 		switch v.Labels[core.LabelMetricSetType.Key] {
 		case core.MetricSetTypePod:
 			namespace := v.Labels[core.LabelNamespaceName.Key]
@@ -78,7 +82,7 @@ func (this *PodBasedEnricher) getPod(namespace, name string) (*kube_api.Pod, err
 
 func (this *PodBasedEnricher) addContainerInfo(key string, containerMs *core.MetricSet, pod *kube_api.Pod, batch *core.DataBatch, newMs map[string]*core.MetricSet) {
 	for _, container := range pod.Spec.Containers {
-		if key == core.PodContainerKey(pod.Namespace, pod.Name, container.Name) {
+		if key == core.PodContainerKeyWithUID(pod.Namespace, pod.Name, string(pod.UID), container.Name) {
 			updateContainerResourcesAndLimits(containerMs, container)
 			if _, ok := containerMs.Labels[core.LabelContainerBaseImage.Key]; !ok {
 				containerMs.Labels[core.LabelContainerBaseImage.Key] = container.Image
@@ -88,8 +92,9 @@ func (this *PodBasedEnricher) addContainerInfo(key string, containerMs *core.Met
 	}
 
 	for _, containerStatus := range pod.Status.ContainerStatuses {
-		if key == core.PodContainerKey(pod.Namespace, pod.Name, containerStatus.Name) {
+		if key == core.PodContainerKeyWithUID(pod.Namespace, pod.Name, string(pod.UID), containerStatus.Name) {
 			containerMs.MetricValues[core.MetricRestartCount.Name] = intValue(int64(containerStatus.RestartCount))
+			addRuntimeLabels(containerMs.Labels, containerStatus)
 			if !pod.Status.StartTime.IsZero() {
 				containerMs.EntityCreateTime = pod.Status.StartTime.Time
 			}
@@ -103,7 +108,7 @@ func (this *PodBasedEnricher) addContainerInfo(key string, containerMs *core.Met
 	namespace := containerMs.Labels[core.LabelNamespaceName.Key]
 	podName := containerMs.Labels[core.LabelPodName.Key]
 
-	podKey := core.PodKey(namespace, podName)
+	podKey := core.PodKeyWithUID(namespace, podName, string(pod.UID))
 	_, oldfound := batch.MetricSets[podKey]
 	if !oldfound {
 		_, newfound := newMs[podKey]
@@ -140,7 +145,7 @@ func (this *PodBasedEnricher) addPodInfo(key string, podMs *core.MetricSet, pod
 
 	// Add cpu/mem requests and limits to containers
 	for _, container := range pod.Spec.Containers {
-		containerKey := core.PodContainerKey(pod.Namespace, pod.Name, container.Name)
+		containerKey := core.PodContainerKeyWithUID(pod.Namespace, pod.Name, string(pod.UID), container.Name)
 		if _, found := batch.MetricSets[containerKey]; found {
 			continue
 		}
@@ -163,12 +168,64 @@ func (this *PodBasedEnricher) addPodInfo(key string, podMs *core.MetricSet, pod
 			},
 			EntityCreateTime: podMs.CollectionStartTime,
 		}
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.Name == container.Name {
+				addRuntimeLabels(containerMs.Labels, containerStatus)
+				break
+			}
+		}
 		this.labelCopier.Copy(pod.Labels, containerMs.Labels)
 		updateContainerResourcesAndLimits(containerMs, container)
 		newMs[containerKey] = containerMs
 	}
 }
 
+// addRuntimeLabels labels a container with its runtime and the name, tag and digest of its
+// image, as reported in the status of its pod.
+func addRuntimeLabels(labels map[string]string, status kube_api.ContainerStatus) {
+	// Container IDs look like docker://<id> or containerd://<id>.
+	if i := strings.Index(status.ContainerID, "://"); i > 0 {
+		labels[core.LabelContainerRuntime.Key] = status.ContainerID[:i]
+	}
+	image := status.Image
+	if strings.HasPrefix(image, "sha256:") {
+		// Some runtimes report the ID of images pulled by digest instead of their name.
+		image = labels[core.LabelContainerBaseImage.Key]
+	}
+	if image == "" {
+		return
+	}
+	name, tag, digest := parseImage(image)
+	// Image IDs of images pulled from a registry look like docker-pullable://<name>@<digest>.
+	if i := strings.LastIndex(status.ImageID, "@"); i >= 0 {
+		digest = status.ImageID[i+1:]
+	}
+	labels[core.LabelImageName.Key] = name
+	if tag != "" {
+		labels[core.LabelImageTag.Key] = tag
+	}
+	if digest != "" {
+		labels[core.LabelImageDigest.Key] = digest
+	}
+}
+
+// parseImage splits an image reference like registry:5000/nginx:1.13@sha256:... into its name,
+// tag and digest. The tag defaults to latest if neither the tag nor the digest is given.
+func parseImage(image string) (name, tag, digest string) {
+	name = image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+	}
+	// A colon before the last slash separates the port of the registry.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	if tag == "" && digest == "" {
+		tag = "latest"
+	}
+	return name, tag, digest
+}
+
 func updateContainerResourcesAndLimits(metricSet *core.MetricSet, container kube_api.Container) {
 	requests := container.Resources.Requests
 
@@ -191,3 +191,85 @@ func checkLimits(t *testing.T, ms *core.MetricSet, cpu, mem int64, storage int64
 	assert.True(t, found)
 	assert.Equal(t, storage, storageVal.IntValue)
 }
+
+func TestParseImage(t *testing.T) {
+	for image, expected := range map[string][3]string{
+		"nginx":                                {"nginx", "latest", ""},
+		"nginx:1.13":                           {"nginx", "1.13", ""},
+		"registry:5000/team/nginx":             {"registry:5000/team/nginx", "latest", ""},
+		"registry:5000/team/nginx:1.13":        {"registry:5000/team/nginx", "1.13", ""},
+		"nginx@sha256:0123":                    {"nginx", "", "sha256:0123"},
+		"registry:5000/nginx:1.13@sha256:0123": {"registry:5000/nginx", "1.13", "sha256:0123"},
+	} {
+		name, tag, digest := parseImage(image)
+		assert.Equal(t, expected, [3]string{name, tag, digest}, image)
+	}
+}
+
+func TestRuntimeLabels(t *testing.T) {
+	pod := kube_api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod1",
+			Namespace: "ns1",
+		},
+		Spec: kube_api.PodSpec{
+			Containers: []kube_api.Container{
+				{Name: "c1", Image: "nginx:1.13"},
+				{Name: "c2", Image: "gcr.io/project/app@sha256:4567"},
+			},
+		},
+		Status: kube_api.PodStatus{
+			ContainerStatuses: []kube_api.ContainerStatus{
+				{
+					Name:        "c1",
+					Image:       "nginx:1.13",
+					ImageID:     "docker-pullable://nginx@sha256:0123",
+					ContainerID: "docker://abcd",
+				},
+				{
+					Name:        "c2",
+					Image:       "sha256:89ab",
+					ImageID:     "gcr.io/project/app@sha256:4567",
+					ContainerID: "containerd://efgh",
+				},
+			},
+		},
+	}
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	store.Add(&pod)
+	labelCopier, err := util.NewLabelCopier(",", []string{}, []string{})
+	assert.NoError(t, err)
+	podBasedEnricher := PodBasedEnricher{
+		podLister:   v1listers.NewPodLister(store),
+		labelCopier: labelCopier,
+	}
+
+	// c1 is scraped, c2 is added as a stub.
+	batch, err := podBasedEnricher.Process(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns1", "pod1", "c1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+					core.LabelPodName.Key:       "pod1",
+					core.LabelNamespaceName.Key: "ns1",
+					core.LabelContainerName.Key: "c1",
+				},
+				MetricValues: map[string]core.MetricValue{},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	c1 := batch.MetricSets[core.PodContainerKey("ns1", "pod1", "c1")].Labels
+	assert.Equal(t, "docker", c1[core.LabelContainerRuntime.Key])
+	assert.Equal(t, "nginx", c1[core.LabelImageName.Key])
+	assert.Equal(t, "1.13", c1[core.LabelImageTag.Key])
+	assert.Equal(t, "sha256:0123", c1[core.LabelImageDigest.Key])
+
+	c2 := batch.MetricSets[core.PodContainerKey("ns1", "pod1", "c2")].Labels
+	assert.Equal(t, "containerd", c2[core.LabelContainerRuntime.Key])
+	assert.Equal(t, "gcr.io/project/app", c2[core.LabelImageName.Key])
+	assert.NotContains(t, c2, core.LabelImageTag.Key)
+	assert.Equal(t, "sha256:4567", c2[core.LabelImageDigest.Key])
+}
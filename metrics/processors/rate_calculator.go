@@ -124,7 +124,22 @@ func (this *RateCalculator) Process(batch *core.DataBatch) (*core.DataBatch, err
 			}
 		}
 	}
-	this.previousBatch = batch
+	if !batch.Partial {
+		this.previousBatch = batch
+		return batch, nil
+	}
+	// The metric sets of the other objects remain the latest ones.
+	merged := &core.DataBatch{
+		Timestamp:  batch.Timestamp,
+		MetricSets: make(map[string]*core.MetricSet, len(this.previousBatch.MetricSets)),
+	}
+	for key, metricSet := range this.previousBatch.MetricSets {
+		merged.MetricSets[key] = metricSet
+	}
+	for key, metricSet := range batch.MetricSets {
+		merged.MetricSets[key] = metricSet
+	}
+	this.previousBatch = merged
 	return batch, nil
 }
 
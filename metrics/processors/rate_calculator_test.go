@@ -91,3 +91,44 @@ func TestRateCalculator(t *testing.T) {
 	assert.InEpsilon(t, 13, cpuRate.IntValue, 2)
 	assert.InEpsilon(t, 2, txeRate.FloatValue, 0.1)
 }
+
+func TestRateCalculatorPartialBatches(t *testing.T) {
+	now := time.Now()
+	cpuUsage := func(value int64, scrapeTime time.Time) *core.MetricSet {
+		return &core.MetricSet{
+			CollectionStartTime: now.Add(-time.Hour),
+			ScrapeTime:          scrapeTime,
+			MetricValues: map[string]core.MetricValue{
+				core.MetricCpuUsage.MetricDescriptor.Name: {
+					ValueType:  core.ValueInt64,
+					MetricType: core.MetricCumulative,
+					IntValue:   value,
+				},
+			},
+		}
+	}
+	calculator := NewRateCalculator(core.RateMetricsMapping)
+	calculator.Process(&core.DataBatch{
+		Timestamp: now.Add(-time.Minute),
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): cpuUsage(0, now.Add(-time.Minute)),
+			core.NodeKey("node2"): cpuUsage(0, now.Add(-time.Minute)),
+		},
+	})
+	// A batch of the boosted node1 only does not drop the previous values of node2.
+	calculator.Process(&core.DataBatch{
+		Timestamp:  now.Add(-50 * time.Second),
+		Partial:    true,
+		MetricSets: map[string]*core.MetricSet{core.NodeKey("node1"): cpuUsage(10e9, now.Add(-50*time.Second))},
+	})
+	batch, err := calculator.Process(&core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node1"): cpuUsage(60e9, now),
+			core.NodeKey("node2"): cpuUsage(60e9, now),
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), batch.MetricSets[core.NodeKey("node1")].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	assert.Equal(t, int64(1000), batch.MetricSets[core.NodeKey("node2")].MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+}
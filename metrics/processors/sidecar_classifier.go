@@ -0,0 +1,91 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+)
+
+// SidecarClassifier labels the sidecar containers, e.g. the proxies injected by service meshes,
+// with sidecar=true, so that their usage can be told apart from the usage of the application.
+// Sidecars are identified by the patterns of their names, e.g. istio-proxy, or listed in an
+// annotation of their pod.
+type SidecarClassifier struct {
+	patterns   []string
+	annotation string
+	podLister  v1listers.PodLister
+}
+
+func (this *SidecarClassifier) Name() string {
+	return "sidecar_classifier"
+}
+
+func (this *SidecarClassifier) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	for _, metricSet := range batch.MetricSets {
+		if core.IsFederated(metricSet) || metricSet.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePodContainer {
+			continue
+		}
+		if this.isSidecar(metricSet.Labels) {
+			metricSet.Labels[core.LabelSidecar.Key] = "true"
+		}
+	}
+	return batch, nil
+}
+
+func (this *SidecarClassifier) isSidecar(labels map[string]string) bool {
+	container := labels[core.LabelContainerName.Key]
+	for _, pattern := range this.patterns {
+		if matched, _ := path.Match(pattern, container); matched {
+			return true
+		}
+	}
+	if this.annotation == "" {
+		return false
+	}
+	namespace := labels[core.LabelNamespaceName.Key]
+	podName := labels[core.LabelPodName.Key]
+	pod, err := this.podLister.Pods(namespace).Get(podName)
+	if err != nil {
+		glog.V(3).Infof("Failed to get pod %s from cache: %v", core.PodKey(namespace, podName), err)
+		return false
+	}
+	for _, sidecar := range strings.Split(pod.Annotations[this.annotation], ",") {
+		if strings.TrimSpace(sidecar) == container {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSidecarClassifier returns a processor labeling the containers whose name matches one of the
+// patterns, or is listed in the given annotation of their pod, e.g. istio-proxy,vault-agent.
+func NewSidecarClassifier(patterns []string, annotation string, podLister v1listers.PodLister) (*SidecarClassifier, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid sidecar container pattern %q: %v", pattern, err)
+		}
+	}
+	return &SidecarClassifier{
+		patterns:   patterns,
+		annotation: annotation,
+		podLister:  podLister,
+	}, nil
+}
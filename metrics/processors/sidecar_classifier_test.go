@@ -0,0 +1,144 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/core"
+)
+
+func containerMetricSet(pod, container string, cpu int64) *core.MetricSet {
+	return &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+			core.LabelNamespaceName.Key: "ns1",
+			core.LabelPodName.Key:       pod,
+			core.LabelContainerName.Key: container,
+		},
+		MetricValues: map[string]core.MetricValue{
+			core.MetricCpuUsageRate.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: cpu},
+		},
+	}
+}
+
+func TestSidecarClassifier(t *testing.T) {
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	store.Add(&kube_api.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "ns1",
+		Name:        "pod1",
+		Annotations: map[string]string{"example.com/sidecars": "vault-agent, log-shipper"},
+	}})
+	_, err := NewSidecarClassifier([]string{"[proxy"}, "", nil)
+	assert.Error(t, err)
+	classifier, err := NewSidecarClassifier([]string{"istio-proxy", "*-sidecar"}, "example.com/sidecars", v1listers.NewPodLister(store))
+	require.NoError(t, err)
+
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey("ns1", "pod1", "app"):         containerMetricSet("pod1", "app", 100),
+			core.PodContainerKey("ns1", "pod1", "istio-proxy"): containerMetricSet("pod1", "istio-proxy", 20),
+			core.PodContainerKey("ns1", "pod1", "vault-agent"): containerMetricSet("pod1", "vault-agent", 5),
+			core.PodContainerKey("ns1", "pod1", "log-shipper"): containerMetricSet("pod1", "log-shipper", 3),
+			core.PodContainerKey("ns1", "pod2", "app"):         containerMetricSet("pod2", "app", 50),
+			core.PodContainerKey("ns1", "pod2", "dns-sidecar"): containerMetricSet("pod2", "dns-sidecar", 1),
+		},
+	}
+	batch, err = classifier.Process(batch)
+	require.NoError(t, err)
+	for key, sidecar := range map[string]bool{
+		core.PodContainerKey("ns1", "pod1", "app"):         false,
+		core.PodContainerKey("ns1", "pod1", "istio-proxy"): true,
+		core.PodContainerKey("ns1", "pod1", "vault-agent"): true,
+		core.PodContainerKey("ns1", "pod1", "log-shipper"): true,
+		core.PodContainerKey("ns1", "pod2", "app"):         false,
+		core.PodContainerKey("ns1", "pod2", "dns-sidecar"): true,
+	} {
+		_, labeled := batch.MetricSets[key].Labels[core.LabelSidecar.Key]
+		assert.Equal(t, sidecar, labeled, key)
+	}
+
+	aggregator := NewPodAggregator()
+	aggregator.SeparateSidecars = true
+	batch, err = aggregator.Process(batch)
+	require.NoError(t, err)
+
+	pod1 := batch.MetricSets[core.PodKey("ns1", "pod1")]
+	require.NotNil(t, pod1)
+	assert.Equal(t, int64(100), pod1.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	require.Len(t, pod1.LabeledMetrics, 1)
+	assert.Equal(t, core.MetricCpuUsageRate.Name, pod1.LabeledMetrics[0].Name)
+	assert.Equal(t, map[string]string{core.LabelSidecar.Key: "true"}, pod1.LabeledMetrics[0].Labels)
+	assert.Equal(t, int64(28), pod1.LabeledMetrics[0].IntValue)
+
+	pod2 := batch.MetricSets[core.PodKey("ns1", "pod2")]
+	require.NotNil(t, pod2)
+	assert.Equal(t, int64(50), pod2.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	require.Len(t, pod2.LabeledMetrics, 1)
+	assert.Equal(t, int64(1), pod2.LabeledMetrics[0].IntValue)
+}
+
+func TestSeparateSidecarsFromSummaryPodMetrics(t *testing.T) {
+	// The summary source reports the usage of the pods, including their sidecars.
+	gauge := func(value int64) core.MetricValue {
+		return core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: value}
+	}
+	app := containerMetricSet("pod1", "app", 100)
+	app.MetricValues[core.MetricMemoryUsage.Name] = gauge(700)
+	proxy := containerMetricSet("pod1", "istio-proxy", 30)
+	proxy.MetricValues[core.MetricMemoryUsage.Name] = gauge(300)
+	proxy.Labels[core.LabelSidecar.Key] = "true"
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("ns1", "pod1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelNamespaceName.Key: "ns1",
+					core.LabelPodName.Key:       "pod1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsageRate.Name: gauge(130),
+					core.MetricMemoryUsage.Name:  gauge(1000),
+				},
+			},
+			core.PodContainerKey("ns1", "pod1", "app"):         app,
+			core.PodContainerKey("ns1", "pod1", "istio-proxy"): proxy,
+		},
+	}
+
+	aggregator := NewPodAggregator()
+	aggregator.SeparateSidecars = true
+	batch, err := aggregator.Process(batch)
+	require.NoError(t, err)
+
+	pod := batch.MetricSets[core.PodKey("ns1", "pod1")]
+	assert.Equal(t, int64(100), pod.MetricValues[core.MetricCpuUsageRate.Name].IntValue)
+	assert.Equal(t, int64(700), pod.MetricValues[core.MetricMemoryUsage.Name].IntValue)
+	require.Len(t, pod.LabeledMetrics, 2)
+	for _, metric := range pod.LabeledMetrics {
+		assert.Equal(t, map[string]string{core.LabelSidecar.Key: "true"}, metric.Labels)
+		assert.Equal(t, proxy.MetricValues[metric.Name].IntValue, metric.IntValue, metric.Name)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	// Gauges of known metrics above this value are rejected. It is well above the capacity of
+	// any single machine, e.g. 1 PiB of memory or a million cores in millicores.
+	maxGaugeValue = 1 << 50
+
+	// Number of quarantined values kept for inspection.
+	quarantineSize = 100
+
+	ReasonFutureTimestamp = "future_timestamp"
+	ReasonNotANumber      = "not_a_number"
+	ReasonNegative        = "negative"
+	ReasonTooLarge        = "too_large"
+
+	// Policies for metric sets scraped further in the future than the maximum clock skew.
+	// They are either dropped, moved to the time of the batch, or kept as they are.
+	ClockSkewReject  = "reject"
+	ClockSkewCorrect = "correct"
+	ClockSkewAccept  = "accept"
+)
+
+var (
+	// Number of metric values dropped by the validator.
+	quarantinedValues = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "processor",
+			Name:      "quarantined_values_total",
+			Help:      "Number of metric values dropped because they failed validation, by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	// Number of metric sets scraped too far in the future, by node.
+	skewedMetricSets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "processor",
+			Name:      "clock_skewed_metric_sets_total",
+			Help:      "Number of metric sets with scrape timestamps beyond the maximum clock skew, by node and applied policy.",
+		},
+		[]string{"node", "policy"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(quarantinedValues)
+	prometheus.MustRegister(skewedMetricSets)
+}
+
+// QuarantinedValue is a metric value, or a whole metric set, dropped by the validator.
+type QuarantinedValue struct {
+	// Key of the metric set.
+	Key string
+	// Name of the metric. Empty if the whole metric set was dropped.
+	Metric string
+	// The rejected value, or scrape time if the whole metric set was dropped.
+	Value  string
+	Reason string
+	// Timestamp of the batch the value was dropped from.
+	Timestamp time.Time
+}
+
+// Validator drops obviously corrupt data reported by misbehaving kubelets before it reaches
+// the other processors and the sinks: metric sets scraped too far in the future, float values
+// which are not numbers, and negative or absurdly large values of known metrics. Metric sets
+// scraped in the future, e.g. from a node with a broken NTP, may be corrected instead.
+type Validator struct {
+	lock            sync.Mutex
+	maxClockSkew    time.Duration
+	clockSkewPolicy string
+	knownMetrics    map[string]bool
+	// Ring buffer of the latest quarantined values.
+	quarantine []QuarantinedValue
+	next       int
+}
+
+func NewValidator(maxClockSkew time.Duration, clockSkewPolicy string) *Validator {
+	knownMetrics := make(map[string]bool, len(core.AllMetrics))
+	for _, metric := range core.AllMetrics {
+		knownMetrics[metric.Name] = true
+	}
+	return &Validator{
+		maxClockSkew:    maxClockSkew,
+		clockSkewPolicy: clockSkewPolicy,
+		knownMetrics:    knownMetrics,
+	}
+}
+
+func (this *Validator) Name() string {
+	return "validator"
+}
+
+func (this *Validator) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	latest := batch.Timestamp.Add(this.maxClockSkew)
+	for key, ms := range batch.MetricSets {
+		if ms.ScrapeTime.After(latest) {
+			skewedMetricSets.WithLabelValues(ms.Labels[core.LabelNodename.Key], this.clockSkewPolicy).Inc()
+			switch this.clockSkewPolicy {
+			case ClockSkewCorrect:
+				glog.V(2).Infof("Moving %s scraped at %s to %s", key, ms.ScrapeTime, batch.Timestamp)
+				ms.ScrapeTime = batch.Timestamp
+				if ms.CollectionStartTime.After(batch.Timestamp) {
+					ms.CollectionStartTime = batch.Timestamp
+				}
+			case ClockSkewAccept:
+				glog.V(2).Infof("Keeping %s scraped in the future at %s", key, ms.ScrapeTime)
+			default:
+				this.reject(batch, key, "", ms.ScrapeTime.UTC().Format(time.RFC3339), ReasonFutureTimestamp)
+				delete(batch.MetricSets, key)
+				continue
+			}
+		}
+		for name, value := range ms.MetricValues {
+			if reason := this.validate(name, value); reason != "" {
+				this.reject(batch, key, name, formatMetricValue(value), reason)
+				delete(ms.MetricValues, name)
+			}
+		}
+		valid := ms.LabeledMetrics[:0]
+		for _, metric := range ms.LabeledMetrics {
+			if reason := this.validate(metric.Name, metric.MetricValue); reason != "" {
+				this.reject(batch, key, metric.Name, formatMetricValue(metric.MetricValue), reason)
+				continue
+			}
+			valid = append(valid, metric)
+		}
+		ms.LabeledMetrics = valid
+	}
+	return batch, nil
+}
+
+// validate returns the reason the value is rejected, or an empty string if it is valid.
+func (this *Validator) validate(name string, value core.MetricValue) string {
+	number := float64(value.IntValue)
+	if value.ValueType == core.ValueFloat {
+		number = value.FloatValue
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return ReasonNotANumber
+		}
+	}
+	if !this.knownMetrics[name] {
+		// Custom metrics may be negative or large.
+		return ""
+	}
+	if number < 0 {
+		return ReasonNegative
+	}
+	if value.MetricType == core.MetricGauge && number > maxGaugeValue {
+		return ReasonTooLarge
+	}
+	return ""
+}
+
+// reject counts and records a dropped value. Must be called with the lock held.
+func (this *Validator) reject(batch *core.DataBatch, key, metric, value, reason string) {
+	glog.V(2).Infof("Dropping %s %s of %s: %s", metric, value, key, reason)
+	quarantinedValues.WithLabelValues(reason).Inc()
+	entry := QuarantinedValue{
+		Key:       key,
+		Metric:    metric,
+		Value:     value,
+		Reason:    reason,
+		Timestamp: batch.Timestamp,
+	}
+	if len(this.quarantine) < quarantineSize {
+		this.quarantine = append(this.quarantine, entry)
+	} else {
+		this.quarantine[this.next] = entry
+	}
+	this.next = (this.next + 1) % quarantineSize
+}
+
+// GetQuarantine returns the latest quarantined values, oldest first.
+func (this *Validator) GetQuarantine() []QuarantinedValue {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	result := make([]QuarantinedValue, 0, len(this.quarantine))
+	if len(this.quarantine) == quarantineSize {
+		result = append(result, this.quarantine[this.next:]...)
+		result = append(result, this.quarantine[:this.next]...)
+	} else {
+		result = append(result, this.quarantine...)
+	}
+	return result
+}
+
+func formatMetricValue(value core.MetricValue) string {
+	if value.ValueType == core.ValueFloat {
+		return strconv.FormatFloat(value.FloatValue, 'g', -1, 64)
+	}
+	return strconv.FormatInt(value.IntValue, 10)
+}
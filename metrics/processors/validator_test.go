@@ -0,0 +1,151 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestValidator(t *testing.T) {
+	now := time.Now()
+	future := core.NodeKey("future")
+	node := core.NodeKey("node1")
+	container := core.PodContainerKey("ns1", "pod1", "c")
+
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			future: {
+				ScrapeTime: now.Add(time.Hour),
+				MetricValues: map[string]core.MetricValue{
+					core.MetricMemoryUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 100},
+				},
+			},
+			node: {
+				ScrapeTime: now.Add(time.Minute),
+				MetricValues: map[string]core.MetricValue{
+					core.MetricMemoryUsage.Name:  {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1 << 60},
+					core.MetricCpuUsage.Name:     {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: -5},
+					core.MetricNetworkRx.Name:    {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 1 << 60},
+					core.MetricCpuLimit.Name:     {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1000},
+					"custom/temperature_celsius": {ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: -10},
+					"custom/broken":              {ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: math.NaN()},
+				},
+				LabeledMetrics: []core.LabeledMetric{
+					{
+						Name:        core.MetricFilesystemUsage.Name,
+						Labels:      map[string]string{core.LabelResourceID.Key: "/"},
+						MetricValue: core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 2000},
+					},
+					{
+						Name:        core.MetricFilesystemUsage.Name,
+						Labels:      map[string]string{core.LabelResourceID.Key: "/data"},
+						MetricValue: core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: -1},
+					},
+				},
+			},
+			container: {
+				ScrapeTime: now,
+				MetricValues: map[string]core.MetricValue{
+					core.MetricMemoryUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 300},
+				},
+			},
+		},
+	}
+
+	validator := NewValidator(5*time.Minute, ClockSkewReject)
+	result, err := validator.Process(batch)
+	assert.NoError(t, err)
+
+	assert.NotContains(t, result.MetricSets, future)
+	assert.Equal(t, map[string]core.MetricValue{
+		core.MetricNetworkRx.Name:    {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 1 << 60},
+		core.MetricCpuLimit.Name:     {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1000},
+		"custom/temperature_celsius": {ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: -10},
+	}, result.MetricSets[node].MetricValues)
+	if assert.Len(t, result.MetricSets[node].LabeledMetrics, 1) {
+		assert.Equal(t, "/", result.MetricSets[node].LabeledMetrics[0].Labels[core.LabelResourceID.Key])
+	}
+	assert.Len(t, result.MetricSets[container].MetricValues, 1)
+
+	reasons := map[string]string{}
+	for _, value := range validator.GetQuarantine() {
+		assert.Equal(t, now, value.Timestamp)
+		reasons[value.Key+" "+value.Metric] = value.Reason
+	}
+	assert.Equal(t, map[string]string{
+		future + " ":                                 ReasonFutureTimestamp,
+		node + " " + core.MetricMemoryUsage.Name:     ReasonTooLarge,
+		node + " " + core.MetricCpuUsage.Name:        ReasonNegative,
+		node + " custom/broken":                      ReasonNotANumber,
+		node + " " + core.MetricFilesystemUsage.Name: ReasonNegative,
+	}, reasons)
+}
+
+func TestValidatorQuarantineSize(t *testing.T) {
+	validator := NewValidator(time.Minute, ClockSkewReject)
+	for i := 0; i < quarantineSize+10; i++ {
+		batch := &core.DataBatch{
+			Timestamp: time.Unix(int64(i), 0),
+			MetricSets: map[string]*core.MetricSet{
+				"node:node1": {
+					MetricValues: map[string]core.MetricValue{
+						core.MetricMemoryUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: -1},
+					},
+				},
+			},
+		}
+		validator.Process(batch)
+	}
+
+	quarantine := validator.GetQuarantine()
+	assert.Len(t, quarantine, quarantineSize)
+	assert.Equal(t, time.Unix(10, 0), quarantine[0].Timestamp)
+	assert.Equal(t, time.Unix(quarantineSize+9, 0), quarantine[quarantineSize-1].Timestamp)
+}
+
+func TestValidatorClockSkewPolicies(t *testing.T) {
+	now := time.Now()
+	newBatch := func() *core.DataBatch {
+		return &core.DataBatch{
+			Timestamp: now,
+			MetricSets: map[string]*core.MetricSet{
+				"node:node1": {
+					Labels:              map[string]string{core.LabelNodename.Key: "node1"},
+					ScrapeTime:          now.Add(time.Hour),
+					CollectionStartTime: now.Add(30 * time.Minute),
+					MetricValues:        map[string]core.MetricValue{},
+				},
+			},
+		}
+	}
+
+	result, err := NewValidator(time.Minute, ClockSkewCorrect).Process(newBatch())
+	assert.NoError(t, err)
+	assert.Equal(t, now, result.MetricSets["node:node1"].ScrapeTime)
+	assert.Equal(t, now, result.MetricSets["node:node1"].CollectionStartTime)
+
+	validator := NewValidator(time.Minute, ClockSkewAccept)
+	result, err = validator.Process(newBatch())
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), result.MetricSets["node:node1"].ScrapeTime)
+	assert.Empty(t, validator.GetQuarantine())
+}
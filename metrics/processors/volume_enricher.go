@@ -0,0 +1,155 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/golang/glog"
+	kube_api "k8s.io/api/core/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+const (
+	volumeResourcePrefix = "Volume:"
+	// Set on claims created before the StorageClassName field existed.
+	betaStorageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+)
+
+// VolumeEnricher labels the filesystem metrics of pod volumes with the persistent volume claim
+// backing them, the persistent volume bound to the claim and its storage class, so that the
+// usage of volumes can be joined with their claims.
+type VolumeEnricher struct {
+	podLister v1listers.PodLister
+	pvcLister v1listers.PersistentVolumeClaimLister
+	pvLister  v1listers.PersistentVolumeLister
+}
+
+func (this *VolumeEnricher) Name() string {
+	return "volume_enricher"
+}
+
+func (this *VolumeEnricher) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	for _, metricSet := range batch.MetricSets {
+		if core.IsFederated(metricSet) {
+			continue
+		}
+		if metricSet.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePod {
+			continue
+		}
+		// The labels of the metrics of a volume are shared, so they are enriched once.
+		enriched := map[string]map[string]string{}
+		var pod *kube_api.Pod
+		for i := range metricSet.LabeledMetrics {
+			metric := &metricSet.LabeledMetrics[i]
+			resourceID := metric.Labels[core.LabelResourceID.Key]
+			if !strings.HasPrefix(resourceID, volumeResourcePrefix) {
+				continue
+			}
+			if labels, found := enriched[resourceID]; found {
+				metric.Labels = labels
+				continue
+			}
+			claim := metric.Labels[core.LabelPersistentVolumeClaimName.Key]
+			if claim == "" {
+				// Kubelets before 1.8 do not report the claims.
+				if pod == nil {
+					pod = this.getPod(metricSet)
+				}
+				claim = getClaimName(pod, strings.TrimPrefix(resourceID, volumeResourcePrefix))
+			}
+			labels := metric.Labels
+			if claim != "" {
+				labels = this.getVolumeLabels(metric.Labels, metricSet.Labels[core.LabelNamespaceName.Key], claim)
+			}
+			enriched[resourceID] = labels
+			metric.Labels = labels
+		}
+	}
+	return batch, nil
+}
+
+func (this *VolumeEnricher) getPod(metricSet *core.MetricSet) *kube_api.Pod {
+	namespace := metricSet.Labels[core.LabelNamespaceName.Key]
+	name := metricSet.Labels[core.LabelPodName.Key]
+	pod, err := this.podLister.Pods(namespace).Get(name)
+	if err != nil {
+		glog.V(3).Infof("Failed to get pod %s from cache: %v", core.PodKey(namespace, name), err)
+		return nil
+	}
+	return pod
+}
+
+// getVolumeLabels returns a copy of the labels of the metrics of a volume backed by the claim,
+// with the labels of the claim, its volume and its storage class added.
+func (this *VolumeEnricher) getVolumeLabels(metricLabels map[string]string, namespace, claim string) map[string]string {
+	labels := make(map[string]string, len(metricLabels)+3)
+	for k, v := range metricLabels {
+		labels[k] = v
+	}
+	labels[core.LabelPersistentVolumeClaimName.Key] = claim
+
+	pvc, err := this.pvcLister.PersistentVolumeClaims(namespace).Get(claim)
+	if err != nil {
+		glog.V(3).Infof("Failed to get persistent volume claim %s/%s from cache: %v", namespace, claim, err)
+		return labels
+	}
+	if pvc.Spec.VolumeName != "" {
+		labels[core.LabelPersistentVolumeName.Key] = pvc.Spec.VolumeName
+	}
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		labels[core.LabelStorageClass.Key] = *pvc.Spec.StorageClassName
+	} else if class := pvc.Annotations[betaStorageClassAnnotation]; class != "" {
+		labels[core.LabelStorageClass.Key] = class
+	} else if pvc.Spec.VolumeName != "" {
+		// Statically provisioned volumes may carry the class only themselves.
+		if pv, err := this.pvLister.Get(pvc.Spec.VolumeName); err == nil && pv.Spec.StorageClassName != "" {
+			labels[core.LabelStorageClass.Key] = pv.Spec.StorageClassName
+		}
+	}
+	return labels
+}
+
+// getClaimName returns the name of the claim backing the volume of the pod, if any.
+func getClaimName(pod *kube_api.Pod, volumeName string) string {
+	if pod == nil {
+		return ""
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == volumeName && volume.PersistentVolumeClaim != nil {
+			return volume.PersistentVolumeClaim.ClaimName
+		}
+	}
+	return ""
+}
+
+func NewVolumeEnricher(url *url.URL, podLister v1listers.PodLister) (*VolumeEnricher, error) {
+	pvcLister, err := util.GetSharedPersistentVolumeClaimLister(url)
+	if err != nil {
+		return nil, err
+	}
+	pvLister, err := util.GetSharedPersistentVolumeLister(url)
+	if err != nil {
+		return nil, err
+	}
+	return &VolumeEnricher{
+		podLister: podLister,
+		pvcLister: pvcLister,
+		pvLister:  pvLister,
+	}, nil
+}
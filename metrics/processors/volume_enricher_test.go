@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestVolumeEnricher(t *testing.T) {
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+	claims := cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+	volumes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	fast := "fast"
+	require.NoError(t, pods.Add(&kube_api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "db"},
+		Spec: kube_api.PodSpec{Volumes: []kube_api.Volume{{
+			Name: "data",
+			VolumeSource: kube_api.VolumeSource{
+				PersistentVolumeClaim: &kube_api.PersistentVolumeClaimVolumeSource{ClaimName: "data-db"},
+			},
+		}, {
+			Name:         "tmp",
+			VolumeSource: kube_api.VolumeSource{EmptyDir: &kube_api.EmptyDirVolumeSource{}},
+		}}},
+	}))
+	require.NoError(t, claims.Add(&kube_api.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "data-db"},
+		Spec:       kube_api.PersistentVolumeClaimSpec{VolumeName: "pv-1", StorageClassName: &fast},
+	}))
+	require.NoError(t, claims.Add(&kube_api.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "logs-db"},
+		Spec:       kube_api.PersistentVolumeClaimSpec{VolumeName: "pv-2"},
+	}))
+	require.NoError(t, volumes.Add(&kube_api.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-2"},
+		Spec:       kube_api.PersistentVolumeSpec{StorageClassName: "slow"},
+	}))
+	enricher := &VolumeEnricher{
+		podLister: v1listers.NewPodLister(pods),
+		pvcLister: v1listers.NewPersistentVolumeClaimLister(claims),
+		pvLister:  v1listers.NewPersistentVolumeLister(volumes),
+	}
+
+	// The claim of Volume:data is found in the pod spec, the one of Volume:logs was reported by
+	// the kubelet.
+	dataLabels := map[string]string{core.LabelResourceID.Key: "Volume:data"}
+	logsLabels := map[string]string{core.LabelResourceID.Key: "Volume:logs", core.LabelPersistentVolumeClaimName.Key: "logs-db"}
+	tmpLabels := map[string]string{core.LabelResourceID.Key: "Volume:tmp"}
+	batch := &core.DataBatch{MetricSets: map[string]*core.MetricSet{
+		core.PodKey("ns1", "db"): {
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypePod,
+				core.LabelNamespaceName.Key: "ns1",
+				core.LabelPodName.Key:       "db",
+			},
+			LabeledMetrics: []core.LabeledMetric{
+				{Name: core.MetricFilesystemUsage.Name, Labels: dataLabels},
+				{Name: core.MetricFilesystemLimit.Name, Labels: dataLabels},
+				{Name: core.MetricFilesystemUsage.Name, Labels: logsLabels},
+				{Name: core.MetricFilesystemUsage.Name, Labels: tmpLabels},
+			},
+		},
+	}}
+	batch, err := enricher.Process(batch)
+	require.NoError(t, err)
+
+	metrics := batch.MetricSets[core.PodKey("ns1", "db")].LabeledMetrics
+	for _, metric := range metrics[:2] {
+		assert.Equal(t, map[string]string{
+			core.LabelResourceID.Key:                "Volume:data",
+			core.LabelPersistentVolumeClaimName.Key: "data-db",
+			core.LabelPersistentVolumeName.Key:      "pv-1",
+			core.LabelStorageClass.Key:              "fast",
+		}, metric.Labels)
+	}
+	assert.Equal(t, "pv-2", metrics[2].Labels[core.LabelPersistentVolumeName.Key])
+	assert.Equal(t, "slow", metrics[2].Labels[core.LabelStorageClass.Key])
+	assert.Equal(t, tmpLabels, metrics[3].Labels)
+	// The labels reported by the source are not modified.
+	assert.Len(t, dataLabels, 1)
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recommender
+
+import (
+	"math"
+	"sort"
+)
+
+// Ratio between the sizes of consecutive histogram buckets.
+const bucketGrowthRatio = 1.05
+
+// histogram counts samples in exponentially growing buckets. The first bucket holds all values
+// up to firstBucketSize, so the relative error of a percentile is at most 5% above it.
+type histogram struct {
+	firstBucketSize float64
+	// Sample counts by bucket index.
+	buckets map[int]uint32
+	samples uint32
+	max     int64
+}
+
+func newHistogram(firstBucketSize float64) *histogram {
+	return &histogram{
+		firstBucketSize: firstBucketSize,
+		buckets:         map[int]uint32{},
+	}
+}
+
+func (h *histogram) add(value int64) {
+	h.buckets[h.bucket(value)]++
+	h.samples++
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// merge adds all samples of other, which must have the same bucket sizes.
+func (h *histogram) merge(other *histogram) {
+	for bucket, count := range other.buckets {
+		h.buckets[bucket] += count
+	}
+	h.samples += other.samples
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+func (h *histogram) bucket(value int64) int {
+	if float64(value) <= h.firstBucketSize {
+		return 0
+	}
+	return int(math.Ceil(math.Log(float64(value)/h.firstBucketSize) / math.Log(bucketGrowthRatio)))
+}
+
+func (h *histogram) upperBound(bucket int) float64 {
+	return h.firstBucketSize * math.Pow(bucketGrowthRatio, float64(bucket))
+}
+
+// percentile returns the upper bound of the bucket containing the given percentile of the
+// samples, capped at the largest sample. It returns 0 for an empty histogram.
+func (h *histogram) percentile(percentile float64) int64 {
+	if h.samples == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.buckets))
+	for bucket := range h.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	threshold := percentile * float64(h.samples)
+	var count uint32
+	for _, bucket := range buckets {
+		count += h.buckets[bucket]
+		if float64(count) >= threshold {
+			return int64(math.Min(h.upperBound(bucket), float64(h.max)))
+		}
+	}
+	return h.max
+}
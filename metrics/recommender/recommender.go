@@ -0,0 +1,244 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recommender tracks the resource usage of the containers of workloads over days and
+// derives request and limit recommendations from it.
+package recommender
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+const (
+	// Usage is kept in one histogram per day, so whole days expire at once.
+	dayDuration = 24 * time.Hour
+
+	cpuFirstBucketSize    = 10      // millicores
+	memoryFirstBucketSize = 1 << 20 // bytes
+
+	// Added on top of the observed usage.
+	safetyMargin = 0.15
+)
+
+// Recommendation holds the recommended resources of a container of a workload, shared by all
+// its pods.
+type Recommendation struct {
+	Namespace string
+	// Kind of the controller of the pods, e.g. Deployment or StatefulSet, or Pod for pods
+	// without one.
+	Kind      string
+	Workload  string
+	Container string
+	// Number of usage samples the recommendation is based on.
+	Samples  uint32
+	LastSeen time.Time
+
+	CpuRequest    int64 // millicores
+	CpuLimit      int64 // millicores
+	MemoryRequest int64 // bytes
+	MemoryLimit   int64 // bytes
+}
+
+type dailyUsage struct {
+	cpu    *histogram
+	memory *histogram
+}
+
+type containerUsage struct {
+	namespace string
+	kind      string
+	workload  string
+	container string
+	lastSeen  time.Time
+	// Usage by the number of the day since the epoch.
+	days map[int64]*dailyUsage
+}
+
+// Recommender is a sink which records the cpu usage rate and the memory working set of
+// all pod containers for the configured history, by the workload of their pod and their name,
+// so that the usage of the pods replaced by rollouts or rescheduling adds up. CPU requests are
+// recommended at the 90th and limits at the 99th percentile of the usage. Memory requests are
+// recommended at the 90th percentile and limits at the peak of the working set.
+type Recommender struct {
+	lock        sync.Mutex
+	podLister   v1listers.PodLister
+	historyDays int64
+	containers  map[string]*containerUsage
+}
+
+func NewRecommender(podLister v1listers.PodLister, history time.Duration) *Recommender {
+	days := int64((history + dayDuration - 1) / dayDuration)
+	if days < 1 {
+		days = 1
+	}
+	return &Recommender{
+		podLister:   podLister,
+		historyDays: days,
+		containers:  map[string]*containerUsage{},
+	}
+}
+
+func (this *Recommender) Name() string {
+	return "Recommender"
+}
+
+func (this *Recommender) Stop() {
+	// Do nothing.
+}
+
+func (this *Recommender) ExportData(batch *core.DataBatch) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	day := dayOf(batch.Timestamp)
+	for _, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePodContainer {
+			continue
+		}
+		cpu, hasCpu := ms.MetricValues[core.MetricCpuUsageRate.Name]
+		memory, hasMemory := ms.MetricValues[core.MetricMemoryWorkingSet.Name]
+		if !hasCpu && !hasMemory {
+			continue
+		}
+
+		namespace := ms.Labels[core.LabelNamespaceName.Key]
+		pod := ms.Labels[core.LabelPodName.Key]
+		container := ms.Labels[core.LabelContainerName.Key]
+		kind, workload := util.WorkloadOf(this.podLister, namespace, pod)
+		key := namespace + "/" + kind + "/" + workload + "/" + container
+		usage, found := this.containers[key]
+		if !found {
+			usage = &containerUsage{
+				namespace: namespace,
+				kind:      kind,
+				workload:  workload,
+				container: container,
+				days:      map[int64]*dailyUsage{},
+			}
+			this.containers[key] = usage
+		}
+		if batch.Timestamp.After(usage.lastSeen) {
+			usage.lastSeen = batch.Timestamp
+		}
+		daily, found := usage.days[day]
+		if !found {
+			daily = &dailyUsage{
+				cpu:    newHistogram(cpuFirstBucketSize),
+				memory: newHistogram(memoryFirstBucketSize),
+			}
+			usage.days[day] = daily
+		}
+		if hasCpu {
+			daily.cpu.add(cpu.IntValue)
+		}
+		if hasMemory {
+			daily.memory.add(memory.IntValue)
+		}
+	}
+	this.expire(day)
+}
+
+// expire forgets the usage recorded before the history of the given day. Must be called with
+// the lock held.
+func (this *Recommender) expire(today int64) {
+	for key, usage := range this.containers {
+		for day := range usage.days {
+			if day <= today-this.historyDays {
+				delete(usage.days, day)
+			}
+		}
+		if len(usage.days) == 0 {
+			delete(this.containers, key)
+		}
+	}
+}
+
+// GetRecommendations returns the recommendations of the containers of all workloads, optionally
+// limited to a namespace and a workload of the given kind and name within it, ordered by
+// namespace, kind, workload and container.
+func (this *Recommender) GetRecommendations(namespace, kind, workload string) []Recommendation {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	result := []Recommendation{}
+	for _, usage := range this.containers {
+		if (namespace != "" && usage.namespace != namespace) || (kind != "" && usage.kind != kind) ||
+			(workload != "" && usage.workload != workload) {
+			continue
+		}
+		cpu := newHistogram(cpuFirstBucketSize)
+		memory := newHistogram(memoryFirstBucketSize)
+		for _, daily := range usage.days {
+			cpu.merge(daily.cpu)
+			memory.merge(daily.memory)
+		}
+		samples := cpu.samples
+		if memory.samples > samples {
+			samples = memory.samples
+		}
+		result = append(result, Recommendation{
+			Namespace:     usage.namespace,
+			Kind:          usage.kind,
+			Workload:      usage.workload,
+			Container:     usage.container,
+			Samples:       samples,
+			LastSeen:      usage.lastSeen,
+			CpuRequest:    withMargin(cpu.percentile(0.9)),
+			CpuLimit:      withMargin(cpu.percentile(0.99)),
+			MemoryRequest: withMargin(memory.percentile(0.9)),
+			MemoryLimit:   withMargin(memory.max),
+		})
+	}
+	sort.Sort(byContainer(result))
+	return result
+}
+
+// GetPodRecommendations returns the recommendations of the containers of the workload of the
+// pod, ordered by container.
+func (this *Recommender) GetPodRecommendations(namespace, pod string) []Recommendation {
+	kind, workload := util.WorkloadOf(this.podLister, namespace, pod)
+	return this.GetRecommendations(namespace, kind, workload)
+}
+
+func withMargin(value int64) int64 {
+	return int64(math.Ceil(float64(value) * (1 + safetyMargin)))
+}
+
+func dayOf(timestamp time.Time) int64 {
+	return timestamp.Unix() / int64(dayDuration/time.Second)
+}
+
+type byContainer []Recommendation
+
+func (r byContainer) Len() int      { return len(r) }
+func (r byContainer) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byContainer) Less(i, j int) bool {
+	if r[i].Namespace != r[j].Namespace {
+		return r[i].Namespace < r[j].Namespace
+	}
+	if r[i].Kind != r[j].Kind {
+		return r[i].Kind < r[j].Kind
+	}
+	if r[i].Workload != r[j].Workload {
+		return r[i].Workload < r[j].Workload
+	}
+	return r[i].Container < r[j].Container
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recommender
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+// podLister returns a lister of the pods of the web-5d8f replica set of the web deployment in ns1.
+func podLister(t *testing.T, pods ...string) v1listers.PodLister {
+	controller := true
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, name := range pods {
+		require.NoError(t, store.Add(&kube_api.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "ns1",
+				Name:            name,
+				Labels:          map[string]string{util.PodTemplateHashLabel: "5d8f"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-5d8f", Controller: &controller}},
+			},
+		}))
+	}
+	return v1listers.NewPodLister(store)
+}
+
+func containerBatch(timestamp time.Time, namespace, pod string, cpu, memory int64) *core.DataBatch {
+	return &core.DataBatch{
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodContainerKey(namespace, pod, "c1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+					core.LabelNamespaceName.Key: namespace,
+					core.LabelPodName.Key:       pod,
+					core.LabelContainerName.Key: "c1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsageRate.Name: {
+						IntValue:   cpu,
+						MetricType: core.MetricGauge,
+						ValueType:  core.ValueInt64,
+					},
+					core.MetricMemoryWorkingSet.Name: {
+						IntValue:   memory,
+						MetricType: core.MetricGauge,
+						ValueType:  core.ValueInt64,
+					},
+				},
+			},
+			core.PodKey(namespace, pod): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+				},
+				MetricValues: map[string]core.MetricValue{},
+			},
+		},
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	h := newHistogram(10)
+	assert.Equal(t, int64(0), h.percentile(0.9))
+	for i := int64(1); i <= 100; i++ {
+		h.add(i * 10)
+	}
+	assert.InEpsilon(t, 900, h.percentile(0.9), 0.05)
+	assert.Equal(t, int64(1000), h.percentile(1))
+	assert.Equal(t, int64(10), h.percentile(0.01))
+}
+
+func TestRecommendations(t *testing.T) {
+	start := time.Unix(0, 0).Add(10 * dayDuration)
+	recommender := NewRecommender(podLister(t, "web-5d8f-a", "web-5d8f-b"), 2*dayDuration)
+
+	// The usage of the pods of a deployment adds up, as if web-5d8f-a was replaced by web-5d8f-b.
+	for i := int64(1); i <= 100; i++ {
+		pod := "web-5d8f-a"
+		if i > 50 {
+			pod = "web-5d8f-b"
+		}
+		recommender.ExportData(containerBatch(start.Add(time.Duration(i)*time.Minute), "ns1", pod, i*10, i<<20))
+	}
+	recommender.ExportData(containerBatch(start, "ns2", "pod1", 100, 100<<20))
+
+	recommendations := recommender.GetRecommendations("", "", "")
+	assert.Len(t, recommendations, 2)
+	assert.Equal(t, "ns1", recommendations[0].Namespace)
+	assert.Equal(t, "ns2", recommendations[1].Namespace)
+	assert.Equal(t, util.KindPod, recommendations[1].Kind)
+	assert.Equal(t, "pod1", recommendations[1].Workload)
+
+	recommendation := recommender.GetRecommendations("ns1", util.KindDeployment, "web")[0]
+	assert.Equal(t, "c1", recommendation.Container)
+	assert.Equal(t, uint32(100), recommendation.Samples)
+	assert.Equal(t, start.Add(100*time.Minute), recommendation.LastSeen)
+	assert.InEpsilon(t, 900*1.15, recommendation.CpuRequest, 0.05)
+	assert.InEpsilon(t, 990*1.15, recommendation.CpuLimit, 0.05)
+	assert.InEpsilon(t, 90*1.15*(1<<20), recommendation.MemoryRequest, 0.05)
+	assert.InDelta(t, 100*1.15*(1<<20), recommendation.MemoryLimit, 1)
+
+	assert.Empty(t, recommender.GetRecommendations("ns1", util.KindDeployment, "db"))
+	// The recommendations of a pod are those of its workload.
+	assert.Equal(t, []Recommendation{recommendation}, recommender.GetPodRecommendations("ns1", "web-5d8f-a"))
+
+	// Usage expires after the history.
+	recommender.ExportData(containerBatch(start.Add(2*dayDuration), "ns1", "web-5d8f-b", 10, 1<<20))
+	recommendations = recommender.GetRecommendations("", "", "")
+	assert.Len(t, recommendations, 1)
+	assert.Equal(t, uint32(1), recommendations[0].Samples)
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	goflag "flag"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/pflag"
+
+	"k8s.io/apiserver/pkg/util/logs"
+	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks"
+	"k8s.io/heapster/metrics/util/batchfile"
+)
+
+const replayCommand = "replay"
+
+// runReplay implements `heapster replay`, which exports previously captured batches to sinks,
+// so that sink configurations and migrations can be tested without a cluster. It returns the
+// exit code of the process.
+func runReplay(args []string) int {
+	fs := pflag.NewFlagSet(replayCommand, pflag.ExitOnError)
+	fs.AddGoFlagSet(goflag.CommandLine)
+	input := fs.String("input", "", "File of captured batches, one JSON object per line, of any version of the batch format")
+	var sinkUris flags.Uris
+	fs.Var(&sinkUris, "sink", "Sink(s) to replay the batches into, configured as for the heapster command")
+	interval := fs.Duration("interval", 0, "Delay between two batches")
+	shiftTimestamps := fs.Bool("shift_timestamps", false, "Move all timestamps so that the last batch is exported at the current time, for sinks rejecting old points")
+	migrate := fs.Bool("migrate", false, "Rewrite --input in the current version of the batch format instead of replaying it")
+	fs.Parse(args)
+	logs.InitLogs()
+	defer logs.FlushLogs()
+
+	if *migrate && *input != "" {
+		count, err := batchfile.MigrateFile(*input)
+		if err != nil {
+			glog.Errorf("Failed to migrate the batches: %v", err)
+			return 1
+		}
+		glog.Infof("Migrated %d batches to version %d", count, batchfile.Version)
+		return 0
+	}
+	if *input == "" || len(sinkUris) == 0 {
+		glog.Errorf("Both --input and --sink are required")
+		return 2
+	}
+	batches, err := batchfile.ReadFile(*input)
+	if err != nil {
+		glog.Errorf("Failed to read the batches: %v", err)
+		return 1
+	}
+	factory := sinks.NewSinkFactory(nil)
+	var sinkList []core.DataSink
+	for _, uri := range sinkUris {
+		sink, err := factory.Build(uri)
+		if err != nil {
+			glog.Errorf("Failed to create %v sink: %v", uri, err)
+			return 1
+		}
+		sinkList = append(sinkList, sink)
+	}
+	if *shiftTimestamps {
+		shiftBatches(batches, time.Now())
+	}
+	replayBatches(batches, sinkList, *interval)
+	return 0
+}
+
+// replayBatches exports the batches to every sink, in order, then stops the sinks so that
+// they flush what they buffered.
+func replayBatches(batches []*core.DataBatch, sinkList []core.DataSink, interval time.Duration) {
+	for i, batch := range batches {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		for _, sink := range sinkList {
+			start := time.Now()
+			sink.ExportData(batch)
+			glog.V(2).Infof("[batch %s] Replayed %d metric sets into %s in %s", batch.ID, len(batch.MetricSets), sink.Name(), time.Since(start))
+		}
+	}
+	for _, sink := range sinkList {
+		sink.Stop()
+	}
+	glog.Infof("Replayed %d batches into %d sinks", len(batches), len(sinkList))
+}
+
+// shiftBatches moves all timestamps of the batches by the same offset, so that the timestamp
+// of the last batch is now.
+func shiftBatches(batches []*core.DataBatch, now time.Time) {
+	if len(batches) == 0 {
+		return
+	}
+	offset := now.Sub(batches[len(batches)-1].Timestamp)
+	shift := func(t *time.Time) {
+		if !t.IsZero() {
+			*t = t.Add(offset)
+		}
+	}
+	for _, batch := range batches {
+		shift(&batch.Timestamp)
+		for _, metricSet := range batch.MetricSets {
+			shift(&metricSet.ScrapeTime)
+			shift(&metricSet.CollectionStartTime)
+			shift(&metricSet.EntityCreateTime)
+		}
+	}
+}
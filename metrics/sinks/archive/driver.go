@@ -0,0 +1,377 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package archive implements a sink buffering the metrics and writing them periodically to Google
+// Cloud Storage, as JSON lines files partitioned by hour, so that they can be kept cheaply and
+// queried later with e.g. the external tables of BigQuery.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionNone = "none"
+
+	defaultFlushInterval = 15 * time.Minute
+	defaultMaxRows       = 100000
+	defaultTimeout       = time.Minute
+	gcsEndpoint          = "https://storage.googleapis.com"
+	gcsScope             = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// row is a value of a metric. The labels are kept encoded as a JSON object.
+type row struct {
+	timestamp  time.Time
+	key        string
+	metric     string
+	metricType string
+	value      float64
+	labels     []byte
+}
+
+type archiveSink struct {
+	sync.Mutex
+	storage storage
+	// The prefix of the names of the files, without trailing slash.
+	prefix      string
+	compression string
+	// The files are named after the host, so that replicas do not overwrite each other.
+	hostname      string
+	flushInterval time.Duration
+	// Number of buffered rows triggering a flush before the interval. Up to twice as many rows
+	// are kept when uploads fail.
+	maxRows int
+	rows    []row
+
+	flushChannel chan struct{}
+	stopChannel  chan struct{}
+	doneChannel  chan struct{}
+	// For tests.
+	now func() time.Time
+}
+
+func (this *archiveSink) Name() string {
+	return "Archive Sink"
+}
+
+// Stop uploads the buffered rows.
+func (this *archiveSink) Stop() {
+	close(this.stopChannel)
+	<-this.doneChannel
+}
+
+func (this *archiveSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	for key, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		setLabels := encodeLabels(metricSet.Labels, nil)
+		for name, value := range metricSet.MetricValues {
+			this.add(timestamp, key, name, value, setLabels)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			this.add(timestamp, key, metric.Name, metric.MetricValue, encodeLabels(metricSet.Labels, metric.Labels))
+		}
+	}
+	if len(this.rows) >= this.maxRows {
+		this.triggerFlush()
+	}
+}
+
+func (this *archiveSink) add(timestamp time.Time, key, name string, value core.MetricValue, labels []byte) {
+	number := float64(value.IntValue)
+	if value.ValueType == core.ValueFloat {
+		number = float64(value.FloatValue)
+	}
+	if math.IsNaN(number) || math.IsInf(number, 0) {
+		return
+	}
+	this.rows = append(this.rows, row{
+		timestamp:  timestamp,
+		key:        key,
+		metric:     name,
+		metricType: value.MetricType.String(),
+		value:      number,
+		labels:     labels,
+	})
+}
+
+// encodeLabels returns the JSON object of the labels, without the empty ones.
+func encodeLabels(labels, metricLabels map[string]string) []byte {
+	merged := map[string]string{}
+	for _, source := range []map[string]string{labels, metricLabels} {
+		for k, v := range source {
+			if v != "" {
+				merged[k] = v
+			}
+		}
+	}
+	encoded, _ := json.Marshal(merged)
+	return encoded
+}
+
+func (this *archiveSink) triggerFlush() {
+	select {
+	case this.flushChannel <- struct{}{}:
+	default:
+		// A flush is pending already.
+	}
+}
+
+func (this *archiveSink) run() {
+	defer close(this.doneChannel)
+	ticker := time.NewTicker(this.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			this.flush()
+		case <-this.flushChannel:
+			this.flush()
+		case <-this.stopChannel:
+			this.flush()
+			return
+		}
+	}
+}
+
+// flush uploads the buffered rows, a file per hour of their timestamps. The rows of the files
+// which failed are buffered again, for the next flush.
+func (this *archiveSink) flush() {
+	this.Lock()
+	rows := this.rows
+	this.rows = nil
+	this.Unlock()
+	if len(rows) == 0 {
+		return
+	}
+
+	partitions := map[time.Time][]row{}
+	for _, r := range rows {
+		hour := r.timestamp.UTC().Truncate(time.Hour)
+		partitions[hour] = append(partitions[hour], r)
+	}
+	hours := make([]time.Time, 0, len(partitions))
+	for hour := range partitions {
+		hours = append(hours, hour)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+
+	failed := []row{}
+	now := this.now()
+	for _, hour := range hours {
+		name := this.fileName(hour, now)
+		if err := this.upload(name, partitions[hour]); err != nil {
+			glog.Errorf("Failed to upload %d rows to %s/%s: %v", len(partitions[hour]), this.storage, name, err)
+			failed = append(failed, partitions[hour]...)
+			continue
+		}
+		glog.V(2).Infof("Uploaded %d rows to %s/%s", len(partitions[hour]), this.storage, name)
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	this.rows = append(failed, this.rows...)
+	if excess := len(this.rows) - 2*this.maxRows; excess > 0 {
+		glog.Errorf("Dropping the %d oldest rows of the archive, the buffer is full", excess)
+		this.rows = this.rows[excess:]
+	}
+}
+
+// fileName returns the name of a file of the hour, in a Hive partition dt=YYYY-MM-DD/hour=HH,
+// which both Athena and BigQuery detect.
+func (this *archiveSink) fileName(hour, now time.Time) string {
+	name := fmt.Sprintf("dt=%s/hour=%s/%s-%d%s", hour.Format("2006-01-02"), hour.Format("15"),
+		this.hostname, now.UnixNano()/int64(time.Millisecond), this.extension())
+	if this.prefix != "" {
+		name = this.prefix + "/" + name
+	}
+	return name
+}
+
+func (this *archiveSink) extension() string {
+	if this.compression == compressionGzip {
+		return ".json.gz"
+	}
+	return ".json"
+}
+
+func (this *archiveSink) upload(name string, rows []row) error {
+	data, err := encodeJsonl(rows, this.compression)
+	if err != nil {
+		return err
+	}
+	contentType := "application/x-ndjson"
+	if this.compression == compressionGzip {
+		contentType = "application/gzip"
+	}
+	return this.storage.upload(name, contentType, data)
+}
+
+// encodeJsonl returns a JSON object per line for the rows, with the fields timestamp, in RFC 3339,
+// key, metric, metric_type, value and labels, a JSON object.
+func encodeJsonl(rows []row, compression string) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, r := range rows {
+		err := encoder.Encode(struct {
+			Timestamp  string          `json:"timestamp"`
+			Key        string          `json:"key"`
+			Metric     string          `json:"metric"`
+			MetricType string          `json:"metric_type"`
+			Value      float64         `json:"value"`
+			Labels     json.RawMessage `json:"labels"`
+		}{
+			Timestamp:  r.timestamp.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+			Key:        r.key,
+			Metric:     r.metric,
+			MetricType: r.metricType,
+			Value:      r.value,
+			Labels:     r.labels,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if compression != compressionGzip {
+		return buffer.Bytes(), nil
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(buffer.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// NewArchiveSink creates a sink for uris of the form gs://bucket/prefix, with the compression,
+// flushInterval, maxRows and timeout options. Uploads are authenticated with the service account
+// key of the credentialsFile option, or with the default credentials of Google Cloud.
+func NewArchiveSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	if uri.Host == "" {
+		return nil, fmt.Errorf("the bucket is required, e.g. gs://bucket/prefix")
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+
+	var bucket storage
+	switch uri.Scheme {
+	case "gs":
+		var client *http.Client
+		if file := opts.Get("credentialsFile"); file != "" {
+			key, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the credentials file: %v", err)
+			}
+			config, err := google.JWTConfigFromJSON(key, gcsScope)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse the credentials file: %v", err)
+			}
+			client = config.Client(oauth2.NoContext)
+		} else {
+			var err error
+			if client, err = google.DefaultClient(oauth2.NoContext, gcsScope); err != nil {
+				return nil, fmt.Errorf("error creating oauth2 client: %v", err)
+			}
+		}
+		client.Timeout = timeout
+		gcs := &gcsStorage{client: client, endpoint: gcsEndpoint, bucket: uri.Host}
+		if value := opts.Get("endpoint"); value != "" {
+			gcs.endpoint = strings.TrimSuffix(value, "/")
+		}
+		bucket = gcs
+	default:
+		return nil, fmt.Errorf("unsupported archive scheme %q, expected gs", uri.Scheme)
+	}
+
+	sink, err := newArchiveSink(opts, bucket, strings.Trim(uri.Path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	go sink.run()
+	glog.Infof("Created archive sink writing to %s/%s every %s", bucket, sink.prefix, sink.flushInterval)
+	return sink, nil
+}
+
+func newArchiveSink(opts url.Values, bucket storage, prefix string) (*archiveSink, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hostname: %v", err)
+	}
+	sink := &archiveSink{
+		storage:       bucket,
+		prefix:        prefix,
+		compression:   compressionGzip,
+		hostname:      hostname,
+		flushInterval: defaultFlushInterval,
+		maxRows:       defaultMaxRows,
+		flushChannel:  make(chan struct{}, 1),
+		stopChannel:   make(chan struct{}),
+		doneChannel:   make(chan struct{}),
+		now:           time.Now,
+	}
+	if value := opts.Get("compression"); value != "" {
+		sink.compression = value
+	}
+	if sink.compression != compressionGzip && sink.compression != compressionNone {
+		return nil, fmt.Errorf("invalid compression %q, expected gzip or none", sink.compression)
+	}
+	if value := opts.Get("flushInterval"); value != "" {
+		if sink.flushInterval, err = time.ParseDuration(value); err != nil || sink.flushInterval < time.Second {
+			return nil, fmt.Errorf("invalid flushInterval %q, expected a duration of at least 1s", value)
+		}
+	}
+	if value := opts.Get("maxRows"); value != "" {
+		if sink.maxRows, err = strconv.Atoi(value); err != nil || sink.maxRows <= 0 {
+			return nil, fmt.Errorf("invalid maxRows option %q", value)
+		}
+	}
+	return sink, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+)
+
+// fakeStorage keeps the uploaded files, or fails the uploads while failing is set.
+type fakeStorage struct {
+	sync.Mutex
+	files   map[string][]byte
+	failing bool
+}
+
+func (this *fakeStorage) String() string {
+	return "fake://bucket"
+}
+
+func (this *fakeStorage) upload(name, contentType string, data []byte) error {
+	this.Lock()
+	defer this.Unlock()
+	if this.failing {
+		return fmt.Errorf("unavailable")
+	}
+	this.files[name] = data
+	return nil
+}
+
+func newTestSink(t *testing.T, options string) (*archiveSink, *fakeStorage) {
+	opts, err := url.ParseQuery(options)
+	require.NoError(t, err)
+	storage := &fakeStorage{files: map[string][]byte{}}
+	sink, err := newArchiveSink(opts, storage, "heapster")
+	require.NoError(t, err)
+	sink.hostname = "heapster-1"
+	sink.now = func() time.Time { return time.Unix(1500003600, 0) }
+	return sink, storage
+}
+
+func testBatch(timestamps ...time.Time) *core.DataBatch {
+	batch := &core.DataBatch{Timestamp: timestamps[0], MetricSets: map[string]*core.MetricSet{}}
+	for i, timestamp := range timestamps {
+		batch.MetricSets[fmt.Sprintf("node:node-%d", i)] = &core.MetricSet{
+			ScrapeTime: timestamp,
+			Labels:     map[string]string{"type": "node", "nodename": fmt.Sprintf("node-%d", i), "hostname": ""},
+			MetricValues: map[string]core.MetricValue{
+				"memory/usage": {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1024},
+			},
+			LabeledMetrics: []core.LabeledMetric{{
+				Name:   "filesystem/usage",
+				Labels: map[string]string{"resource_id": "/"},
+				MetricValue: core.MetricValue{
+					ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: 0.5,
+				},
+			}},
+		}
+	}
+	return batch
+}
+
+func TestFlushJsonl(t *testing.T) {
+	sink, storage := newTestSink(t, "")
+	sink.ExportData(testBatch(time.Unix(1500000000, 0), time.Unix(1500003600, 0)))
+	sink.flush()
+
+	// The rows are partitioned by hour of their timestamps.
+	require.Equal(t, 2, len(storage.files))
+	file := storage.files["heapster/dt=2017-07-14/hour=02/heapster-1-1500003600000.json.gz"]
+	require.NotNil(t, file)
+	require.NotNil(t, storage.files["heapster/dt=2017-07-14/hour=03/heapster-1-1500003600000.json.gz"])
+
+	reader, err := gzip.NewReader(bytes.NewReader(file))
+	require.NoError(t, err)
+	rows := map[string]map[string]interface{}{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		row := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+		rows[row["metric"].(string)] = row
+	}
+	require.Equal(t, 2, len(rows))
+	assert.Equal(t, map[string]interface{}{
+		"timestamp":   "2017-07-14T02:40:00.000Z",
+		"key":         "node:node-0",
+		"metric":      "memory/usage",
+		"metric_type": "gauge",
+		"value":       1024.0,
+		"labels":      map[string]interface{}{"type": "node", "nodename": "node-0"},
+	}, rows["memory/usage"])
+	assert.Equal(t, 0.5, rows["filesystem/usage"]["value"])
+	assert.Equal(t, map[string]interface{}{"type": "node", "nodename": "node-0", "resource_id": "/"}, rows["filesystem/usage"]["labels"])
+
+	// Nothing is uploaded without rows.
+	storage.files = map[string][]byte{}
+	sink.flush()
+	assert.Equal(t, 0, len(storage.files))
+}
+
+func TestFlushFailure(t *testing.T) {
+	sink, storage := newTestSink(t, "compression=none&maxRows=4")
+	storage.failing = true
+	sink.ExportData(testBatch(time.Unix(1500000000, 0)))
+	sink.flush()
+	// The rows are kept for the next flush.
+	assert.Equal(t, 2, len(sink.rows))
+
+	// Up to twice maxRows rows are kept, the oldest being dropped.
+	sink.ExportData(testBatch(time.Unix(1500000060, 0), time.Unix(1500000120, 0), time.Unix(1500000180, 0), time.Unix(1500000240, 0)))
+	sink.flush()
+	require.Equal(t, 8, len(sink.rows))
+
+	storage.failing = false
+	sink.flush()
+	require.Equal(t, 1, len(storage.files))
+	assert.Equal(t, 8, strings.Count(string(storage.files["heapster/dt=2017-07-14/hour=02/heapster-1-1500003600000.json"]), "\n"))
+	assert.Equal(t, 0, len(sink.rows))
+}
+
+func TestMaxRowsTriggersFlush(t *testing.T) {
+	sink, storage := newTestSink(t, "maxRows=2")
+	go sink.run()
+	sink.ExportData(testBatch(time.Unix(1500000000, 0)))
+	require.NoError(t, waitFor(func() bool {
+		storage.Lock()
+		defer storage.Unlock()
+		return len(storage.files) == 1
+	}))
+
+	// Stop uploads the rows left.
+	sink.ExportData(testBatch(time.Unix(1500007200, 0)))
+	sink.Stop()
+	assert.NotNil(t, storage.files["heapster/dt=2017-07-14/hour=04/heapster-1-1500003600000.json.gz"])
+}
+
+func waitFor(condition func() bool) error {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return nil
+		}
+	}
+	return fmt.Errorf("timed out")
+}
+
+func TestOptions(t *testing.T) {
+	for options, valid := range map[string]bool{
+		"":                            true,
+		"compression=none":            true,
+		"compression=snappy":          false,
+		"flushInterval=5m&maxRows=10": true,
+		"flushInterval=10ms":          false,
+		"maxRows=0":                   false,
+	} {
+		opts, err := url.ParseQuery(options)
+		require.NoError(t, err)
+		_, err = newArchiveSink(opts, &fakeStorage{}, "")
+		assert.Equal(t, valid, err == nil, options)
+	}
+}
+
+func TestGcsUpload(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"name": "object"}`))
+	}))
+	defer server.Close()
+
+	gcs := &gcsStorage{client: http.DefaultClient, endpoint: server.URL, bucket: "metrics"}
+	require.NoError(t, gcs.upload("heapster/dt=2017-07-14/hour=02/a.json.gz", "application/gzip", []byte("data")))
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "/upload/storage/v1/b/metrics/o", req.URL.Path)
+	assert.Equal(t, "media", req.URL.Query().Get("uploadType"))
+	assert.Equal(t, "heapster/dt=2017-07-14/hour=02/a.json.gz", req.URL.Query().Get("name"))
+	assert.Equal(t, "application/gzip", req.Header.Get("Content-Type"))
+	assert.Equal(t, "data", string(body))
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// storage uploads the files of the sink to a bucket.
+type storage interface {
+	// upload uploads the data as the object of the name, relative to the prefix of the sink.
+	upload(name, contentType string, data []byte) error
+	// String describes the destination of the files.
+	String() string
+}
+
+// gcsStorage uploads the objects with the JSON API of Google Cloud Storage.
+type gcsStorage struct {
+	// A client authenticated with the scope of Cloud Storage.
+	client *http.Client
+	// The url of the API, https://storage.googleapis.com unless testing.
+	endpoint string
+	bucket   string
+}
+
+func (this *gcsStorage) String() string {
+	return "gs://" + this.bucket
+}
+
+func (this *gcsStorage) upload(name, contentType string, data []byte) error {
+	uploadUrl := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		this.endpoint, url.PathEscape(this.bucket), url.QueryEscape(name))
+	req, err := http.NewRequest("POST", uploadUrl, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return do(this.client, req)
+}
+
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		contents, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("upload failed with %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package azuremonitor implements a sink sending the metrics to the custom metrics API of Azure
+// Monitor, attached to an Azure resource such as the AKS cluster Heapster runs in.
+package azuremonitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+const (
+	defaultTimeout   = 30 * time.Second
+	defaultNamespace = "heapster"
+	// Azure Monitor accepts up to 10 dimensions per metric.
+	maxDimensions = 10
+	// Number of series of a metric above which they are sent in several requests.
+	maxSeriesPerRequest = 1000
+)
+
+var defaultDimensions = []string{
+	core.LabelMetricSetType.Key,
+	core.LabelNodename.Key,
+	core.LabelNamespaceName.Key,
+	core.LabelPodName.Key,
+	core.LabelContainerName.Key,
+}
+
+// customMetric is the body of a request to the custom metrics API: the series of a metric
+// sharing the same dimension names, at a single time.
+type customMetric struct {
+	Time string `json:"time"`
+	Data struct {
+		BaseData baseData `json:"baseData"`
+	} `json:"data"`
+}
+
+type baseData struct {
+	Metric    string   `json:"metric"`
+	Namespace string   `json:"namespace"`
+	DimNames  []string `json:"dimNames,omitempty"`
+	Series    []series `json:"series"`
+}
+
+// series is a single sample, reported as an aggregate of one value.
+type series struct {
+	DimValues []string `json:"dimValues,omitempty"`
+	Min       float64  `json:"min"`
+	Max       float64  `json:"max"`
+	Sum       float64  `json:"sum"`
+	Count     int      `json:"count"`
+}
+
+type azureMonitorSink struct {
+	sync.Mutex
+	// The metrics endpoint of the resource, https://<region>.monitoring.azure.com/<resourceId>/metrics.
+	endpoint   string
+	client     *http.Client
+	tokens     tokenSource
+	namespace  string
+	dimensions []string
+}
+
+func (this *azureMonitorSink) Name() string {
+	return "Azure Monitor Sink"
+}
+
+func (this *azureMonitorSink) Stop() {}
+
+func (this *azureMonitorSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	for _, metric := range this.toCustomMetrics(batch) {
+		all := metric.Data.BaseData.Series
+		for start := 0; start < len(all); start += maxSeriesPerRequest {
+			end := start + maxSeriesPerRequest
+			if end > len(all) {
+				end = len(all)
+			}
+			metric.Data.BaseData.Series = all[start:end]
+			if err := this.send(batch, metric); err != nil {
+				glog.Errorf("[batch %s] Failed to send %d series of %s to Azure Monitor: %v", batch.ID, end-start, metric.Data.BaseData.Metric, err)
+			}
+		}
+	}
+}
+
+// toCustomMetrics groups the gauges of the batch by metric and dimension names. Cumulative
+// metrics are left out, as Azure Monitor aggregates the values it receives: their rates are
+// exported instead.
+func (this *azureMonitorSink) toCustomMetrics(batch *core.DataBatch) []*customMetric {
+	timestamp := batch.Timestamp.UTC().Format(time.RFC3339)
+	metrics := map[string]*customMetric{}
+	add := func(name string, labels map[string]string, extraLabels map[string]string, value core.MetricValue) {
+		if value.MetricType == core.MetricCumulative {
+			return
+		}
+		dimNames, dimValues := this.dimensionsOf(labels, extraLabels)
+		key := name + "|" + strings.Join(dimNames, ",")
+		metric, found := metrics[key]
+		if !found {
+			metric = &customMetric{Time: timestamp}
+			metric.Data.BaseData = baseData{Metric: name, Namespace: this.namespace, DimNames: dimNames}
+			metrics[key] = metric
+		}
+		v := floatValue(value)
+		metric.Data.BaseData.Series = append(metric.Data.BaseData.Series, series{DimValues: dimValues, Min: v, Max: v, Sum: v, Count: 1})
+	}
+	for _, metricSet := range batch.MetricSets {
+		for name, value := range metricSet.MetricValues {
+			add(name, metricSet.Labels, nil, value)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			add(metric.Name, metricSet.Labels, metric.Labels, metric.MetricValue)
+		}
+	}
+
+	keys := make([]string, 0, len(metrics))
+	for key := range metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]*customMetric, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, metrics[key])
+	}
+	return result
+}
+
+// dimensionsOf returns the configured dimensions which are set on the metric set, followed by
+// the labels of a labeled metric, up to the limit of Azure Monitor.
+func (this *azureMonitorSink) dimensionsOf(labels map[string]string, extraLabels map[string]string) ([]string, []string) {
+	names, values := []string{}, []string{}
+	for _, dimension := range this.dimensions {
+		if value := labels[dimension]; value != "" {
+			names = append(names, dimension)
+			values = append(values, value)
+		}
+	}
+	extra := make([]string, 0, len(extraLabels))
+	for key, value := range extraLabels {
+		if value != "" {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		names = append(names, key)
+		values = append(values, extraLabels[key])
+	}
+	if len(names) > maxDimensions {
+		glog.V(4).Infof("Dropping dimensions %v beyond the limit of Azure Monitor", names[maxDimensions:])
+		names, values = names[:maxDimensions], values[:maxDimensions]
+	}
+	return names, values
+}
+
+func floatValue(value core.MetricValue) float64 {
+	if value.ValueType == core.ValueFloat {
+		return float64(value.FloatValue)
+	}
+	return float64(value.IntValue)
+}
+
+// send posts the metric of the batch, requesting a new token once if the current one is rejected.
+func (this *azureMonitorSink) send(batch *core.DataBatch, metric *customMetric) error {
+	body, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		token, err := this.tokens.token()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", this.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		util.SetBatchHeaders(req.Header, batch)
+		resp, err := this.client.Do(req)
+		if err != nil {
+			return err
+		}
+		contents, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			this.tokens.invalidate()
+			continue
+		}
+		return fmt.Errorf("request failed with %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+}
+
+// NewAzureMonitorSink returns a sink sending the metrics to the custom metrics of the resource
+// of the uri, e.g. ?region=westeurope&resourceId=/subscriptions/...&auth=msi. The tokens are
+// requested for a service principal, with the tenantId, clientId and clientSecret options, or
+// for the managed identity of the node, or given as the token option.
+func NewAzureMonitorSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+
+	resourceId := opts.Get("resourceId")
+	if !strings.HasPrefix(resourceId, "/subscriptions/") {
+		return nil, fmt.Errorf("the resourceId option must be the id of an Azure resource, starting with /subscriptions/, got %q", resourceId)
+	}
+	endpoint := opts.Get("endpoint")
+	if endpoint == "" {
+		region := opts.Get("region")
+		if region == "" {
+			return nil, fmt.Errorf("one of the region and endpoint options is required")
+		}
+		endpoint = fmt.Sprintf("https://%s.monitoring.azure.com", region)
+	}
+	endpoint = strings.TrimRight(endpoint, "/") + strings.TrimRight(resourceId, "/") + "/metrics"
+
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	client := &http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+
+	creds, err := credentials.New(opts, "clientId", "clientSecret")
+	if err != nil {
+		return nil, err
+	}
+	var tokens tokenSource
+	switch auth := opts.Get("auth"); auth {
+	case "service_principal":
+		tenantId := opts.Get("tenantId")
+		if tenantId == "" || creds.Get().User == "" {
+			return nil, fmt.Errorf("the service_principal auth requires the tenantId and clientId options")
+		}
+		authorityHost := opts.Get("authorityHost")
+		if authorityHost == "" {
+			authorityHost = defaultAuthorityHost
+		}
+		tokens = newServicePrincipalTokenSource(client, authorityHost, tenantId, creds)
+	case "msi":
+		msiEndpoint := opts.Get("msiEndpoint")
+		if msiEndpoint == "" {
+			msiEndpoint = defaultMsiEndpoint
+		}
+		tokens = newMsiTokenSource(client, msiEndpoint, creds)
+	case "token":
+		tokens = &staticTokenSource{credentials: creds}
+	default:
+		return nil, fmt.Errorf("invalid auth %q, expected service_principal, msi or token", auth)
+	}
+
+	sink := &azureMonitorSink{
+		endpoint:   endpoint,
+		client:     client,
+		tokens:     tokens,
+		namespace:  defaultNamespace,
+		dimensions: defaultDimensions,
+	}
+	if value := opts.Get("namespace"); value != "" {
+		sink.namespace = value
+	}
+	if values, found := opts["dimensions"]; found {
+		sink.dimensions = []string{}
+		for _, value := range values {
+			for _, dimension := range strings.Split(value, ",") {
+				if dimension = strings.TrimSpace(dimension); dimension != "" {
+					sink.dimensions = append(sink.dimensions, dimension)
+				}
+			}
+		}
+		if len(sink.dimensions) > maxDimensions {
+			return nil, fmt.Errorf("at most %d dimensions are supported, got %d", maxDimensions, len(sink.dimensions))
+		}
+	}
+	glog.Infof("created Azure Monitor sink with endpoint: %v", endpoint)
+	return sink, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azuremonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+)
+
+// fakeAzure serves both the token endpoints and the custom metrics API.
+type fakeAzure struct {
+	sync.Mutex
+	tokenRequests []*http.Request
+	tokenForms    []url.Values
+	metrics       []customMetric
+	// Tokens accepted by the metrics API.
+	validToken string
+	issued     int
+}
+
+func (this *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.Lock()
+	defer this.Unlock()
+	switch {
+	case r.URL.Path == "/tenant-1/oauth2/token" || r.URL.Path == "/msi":
+		r.ParseForm()
+		this.tokenRequests = append(this.tokenRequests, r)
+		this.tokenForms = append(this.tokenForms, r.Form)
+		this.issued++
+		this.validToken = fmt.Sprintf("token-%d", this.issued)
+		fmt.Fprintf(w, `{"access_token": %q, "expires_on": "%d"}`, this.validToken, time.Now().Add(time.Hour).Unix())
+	case r.URL.Path == "/subscriptions/s/resourceGroups/g/providers/Microsoft.ContainerService/managedClusters/c/metrics":
+		if r.Header.Get("Authorization") != "Bearer "+this.validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		metric := customMetric{}
+		if err := json.Unmarshal(body, &metric); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		this.metrics = append(this.metrics, metric)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+const resourceId = "/subscriptions/s/resourceGroups/g/providers/Microsoft.ContainerService/managedClusters/c"
+
+func newSink(t *testing.T, options string) (*azureMonitorSink, *fakeAzure, func()) {
+	azure := &fakeAzure{}
+	server := httptest.NewServer(azure)
+	uri, err := url.Parse(fmt.Sprintf("?endpoint=%s&resourceId=%s&authorityHost=%s&msiEndpoint=%s/msi&%s",
+		server.URL, resourceId, server.URL, server.URL, options))
+	require.NoError(t, err)
+	sink, err := NewAzureMonitorSink(uri)
+	require.NoError(t, err)
+	return sink.(*azureMonitorSink), azure, server.Close
+}
+
+// testBatch adds a cpu usage rate and a cluster to the shared batch.
+func testBatch() *core.DataBatch {
+	batch := sinktest.Batch()
+	batch.MetricSets[core.NodeKey("node-1")].MetricValues[core.MetricCpuUsageRate.Name] = core.MetricValue{
+		MetricType: core.MetricGauge, ValueType: core.ValueFloat, FloatValue: 1.5}
+	batch.MetricSets[core.ClusterKey()] = &core.MetricSet{
+		Labels:       map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeCluster},
+		MetricValues: map[string]core.MetricValue{core.MetricMemoryUsage.Name: {MetricType: core.MetricGauge, ValueType: core.ValueInt64, IntValue: 300}},
+	}
+	return batch
+}
+
+func TestExportData(t *testing.T) {
+	sink, azure, stop := newSink(t, "auth=service_principal&tenantId=tenant-1&clientId=client&clientSecret=secret")
+	defer stop()
+
+	sink.ExportData(testBatch())
+	require.Equal(t, 1, len(azure.tokenForms))
+	assert.Equal(t, "client_credentials", azure.tokenForms[0].Get("grant_type"))
+	assert.Equal(t, "client", azure.tokenForms[0].Get("client_id"))
+	assert.Equal(t, "secret", azure.tokenForms[0].Get("client_secret"))
+	assert.Equal(t, monitoringResource, azure.tokenForms[0].Get("resource"))
+
+	byName := map[string][]baseData{}
+	for _, metric := range azure.metrics {
+		assert.Equal(t, "2017-07-14T02:40:00Z", metric.Time)
+		assert.Equal(t, "heapster", metric.Data.BaseData.Namespace)
+		byName[metric.Data.BaseData.Metric] = append(byName[metric.Data.BaseData.Metric], metric.Data.BaseData)
+	}
+	// The cumulative cpu usage is left out, and the memory usage of the node, of the pod and of
+	// the cluster are sent separately as they have different dimensions.
+	assert.Equal(t, 3, len(byName))
+	assert.Equal(t, 3, len(byName[core.MetricMemoryUsage.Name]))
+	rate := byName[core.MetricCpuUsageRate.Name]
+	require.Equal(t, 1, len(rate))
+	assert.Equal(t, []string{"type", "nodename"}, rate[0].DimNames)
+	assert.Equal(t, []series{{DimValues: []string{"node", "node-1"}, Min: 1.5, Max: 1.5, Sum: 1.5, Count: 1}}, rate[0].Series)
+	filesystem := byName[core.MetricFilesystemUsage.Name]
+	require.Equal(t, 1, len(filesystem))
+	assert.Equal(t, []string{"type", "nodename", "resource_id"}, filesystem[0].DimNames)
+
+	// The cached token is used until it is rejected.
+	azure.metrics = nil
+	sink.ExportData(testBatch())
+	assert.Equal(t, 1, len(azure.tokenForms))
+	assert.Equal(t, 5, len(azure.metrics))
+	azure.validToken = "rotated"
+	azure.metrics = nil
+	sink.ExportData(testBatch())
+	assert.Equal(t, 2, len(azure.tokenForms))
+	assert.Equal(t, 5, len(azure.metrics))
+}
+
+func TestMsiToken(t *testing.T) {
+	sink, azure, stop := newSink(t, "auth=msi&clientId=identity")
+	defer stop()
+
+	sink.ExportData(testBatch())
+	require.Equal(t, 1, len(azure.tokenRequests))
+	assert.Equal(t, "true", azure.tokenRequests[0].Header.Get("Metadata"))
+	assert.Equal(t, "identity", azure.tokenForms[0].Get("client_id"))
+	assert.Equal(t, msiApiVersion, azure.tokenForms[0].Get("api-version"))
+	assert.Equal(t, 5, len(azure.metrics))
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []string{
+		"region=westeurope&auth=msi",
+		"resourceId=" + resourceId + "&auth=msi",
+		"region=westeurope&resourceId=" + resourceId,
+		"region=westeurope&resourceId=" + resourceId + "&auth=service_principal&clientId=client",
+		"region=westeurope&resourceId=" + resourceId + "&auth=msi&dimensions=a,b,c,d,e,f,g,h,i,j,k",
+	} {
+		uri, err := url.Parse("?" + options)
+		require.NoError(t, err)
+		_, err = NewAzureMonitorSink(uri)
+		assert.Error(t, err, options)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azuremonitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/heapster/common/credentials"
+)
+
+const (
+	// The resource the tokens of the custom metrics API are requested for.
+	monitoringResource   = "https://monitoring.azure.com/"
+	defaultAuthorityHost = "https://login.microsoftonline.com"
+	// The token endpoint of the Instance Metadata Service, reachable from Azure VMs only.
+	defaultMsiEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	msiApiVersion      = "2018-02-01"
+	// Tokens are renewed this long before they expire.
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// tokenSource returns the bearer token of the requests to Azure Monitor.
+type tokenSource interface {
+	token() (string, error)
+	// invalidate drops the cached token, after it was rejected.
+	invalidate()
+}
+
+// staticTokenSource returns the token option of the sink, e.g. a token mounted from a secret.
+type staticTokenSource struct {
+	credentials *credentials.Credentials
+}
+
+func (this *staticTokenSource) token() (string, error) {
+	return this.credentials.Get().Token, nil
+}
+
+func (this *staticTokenSource) invalidate() {}
+
+// aadTokenSource caches the tokens issued by Azure Active Directory until shortly before they
+// expire. The request is built by newRequest, for a service principal or a managed identity.
+type aadTokenSource struct {
+	sync.Mutex
+	client     *http.Client
+	newRequest func() (*http.Request, error)
+	cached     string
+	expiresOn  time.Time
+}
+
+// aadToken is the response of both the AAD and the Instance Metadata Service token endpoints.
+// The expiry is a number of seconds since the epoch, sent as a string.
+type aadToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresOn   string `json:"expires_on"`
+	ExpiresIn   string `json:"expires_in"`
+}
+
+func (this *aadTokenSource) token() (string, error) {
+	this.Lock()
+	defer this.Unlock()
+	if this.cached != "" && time.Now().Add(tokenRefreshMargin).Before(this.expiresOn) {
+		return this.cached, nil
+	}
+	req, err := this.newRequest()
+	if err != nil {
+		return "", err
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request an AAD token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the AAD token: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("AAD token request failed with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	parsed := aadToken{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", fmt.Errorf("failed to parse the AAD token: %v", err)
+	}
+	if parsed.AccessToken == "" {
+		return "", fmt.Errorf("no access token in the AAD response")
+	}
+	this.cached = parsed.AccessToken
+	this.expiresOn = time.Now()
+	if seconds, err := strconv.ParseInt(parsed.ExpiresOn, 10, 64); err == nil {
+		this.expiresOn = time.Unix(seconds, 0)
+	} else if seconds, err := strconv.ParseInt(parsed.ExpiresIn, 10, 64); err == nil {
+		this.expiresOn = this.expiresOn.Add(time.Duration(seconds) * time.Second)
+	}
+	return this.cached, nil
+}
+
+func (this *aadTokenSource) invalidate() {
+	this.Lock()
+	defer this.Unlock()
+	this.cached = ""
+}
+
+// newServicePrincipalTokenSource requests tokens with the client credentials grant of the
+// tenant, the client id being given as clientId and the secret as clientSecret.
+func newServicePrincipalTokenSource(client *http.Client, authorityHost, tenantId string, creds *credentials.Credentials) tokenSource {
+	endpoint := fmt.Sprintf("%s/%s/oauth2/token", strings.TrimRight(authorityHost, "/"), url.PathEscape(tenantId))
+	return &aadTokenSource{
+		client: client,
+		newRequest: func() (*http.Request, error) {
+			values := creds.Get()
+			form := url.Values{
+				"grant_type":    {"client_credentials"},
+				"client_id":     {values.User},
+				"client_secret": {values.Password},
+				"resource":      {monitoringResource},
+			}
+			req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req, nil
+		},
+	}
+}
+
+// newMsiTokenSource requests tokens of the managed identity of the VM, the identity assigned
+// by the user of the client id if set, the system assigned identity otherwise.
+func newMsiTokenSource(client *http.Client, endpoint string, creds *credentials.Credentials) tokenSource {
+	return &aadTokenSource{
+		client: client,
+		newRequest: func() (*http.Request, error) {
+			query := url.Values{"api-version": {msiApiVersion}, "resource": {monitoringResource}}
+			if clientId := creds.Get().User; clientId != "" {
+				query.Set("client_id", clientId)
+			}
+			req, err := http.NewRequest("GET", endpoint+"?"+query.Encode(), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Metadata", "true")
+			return req, nil
+		},
+	}
+}
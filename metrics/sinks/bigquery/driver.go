@@ -0,0 +1,472 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bigquery implements a sink streaming the metrics into tables of BigQuery, partitioned
+// by day of the scrape time.
+package bigquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	gce_util "k8s.io/heapster/common/gce"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+const (
+	bigqueryScope   = "https://www.googleapis.com/auth/bigquery"
+	defaultEndpoint = "https://bigquery.googleapis.com/bigquery/v2"
+	defaultDataset  = "heapster"
+	defaultTable    = "metrics"
+	// The number of rows of a request recommended by BigQuery.
+	defaultBatchSize = 500
+	defaultTimeout   = 30 * time.Second
+
+	// All the metrics in a single table, or a table per metric.
+	tablesSingle = "single"
+	tablesMetric = "metric"
+)
+
+// Names of datasets and tables.
+var identifierRegexp = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// The characters replaced by underscores in the names of the tables of the metrics.
+var invalidTableCharacters = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// The labels which have a column of their own. The other labels are in the other_labels column.
+var labelColumns = []core.LabelDescriptor{
+	core.LabelMetricSetType,
+	core.LabelClusterName,
+	core.LabelNodename,
+	core.LabelHostname,
+	core.LabelHostID,
+	core.LabelNamespaceName,
+	core.LabelPodNamespaceUID,
+	core.LabelPodName,
+	core.LabelPodId,
+	core.LabelContainerName,
+	core.LabelContainerBaseImage,
+	core.LabelResourceID,
+	core.LabelLabels,
+}
+
+// field is a field of the schema of a table, see
+// https://cloud.google.com/bigquery/docs/reference/rest/v2/tables#TableFieldSchema.
+type field struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Mode        string  `json:"mode,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Fields      []field `json:"fields,omitempty"`
+}
+
+// schema returns the fields of the tables, the same for the single table and for the table of
+// each metric.
+func schema() []field {
+	fields := []field{
+		{Name: "timestamp", Type: "TIMESTAMP", Mode: "REQUIRED", Description: "The time of the scrape"},
+		{Name: "metric", Type: "STRING", Mode: "REQUIRED", Description: "The name of the metric, e.g. memory/usage"},
+		{Name: "metric_type", Type: "STRING", Description: "gauge or cumulative"},
+		{Name: "value", Type: "FLOAT", Mode: "REQUIRED"},
+	}
+	for _, label := range labelColumns {
+		fields = append(fields, field{Name: label.Key, Type: "STRING", Description: label.Description})
+	}
+	return append(fields, field{
+		Name: "other_labels", Type: "RECORD", Mode: "REPEATED", Fields: []field{
+			{Name: "key", Type: "STRING"},
+			{Name: "value", Type: "STRING"},
+		},
+	})
+}
+
+// keyValue is an element of the other_labels column.
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// apiError is the error of a response of the API.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (this *apiError) Error() string {
+	return fmt.Sprintf("request failed with %d: %s", this.status, this.message)
+}
+
+type bigquerySink struct {
+	sync.Mutex
+	client   *http.Client
+	endpoint string
+	project  string
+	dataset  string
+	location string
+	// The single table, or the prefix of the tables of the metrics.
+	table  string
+	tables string
+	// Expiration of the partitions of the tables which are created, if positive.
+	partitionExpiration time.Duration
+	// Number of rows of an insert request.
+	batchSize int
+
+	datasetReady bool
+	// The tables which exist.
+	readyTables map[string]bool
+}
+
+func (this *bigquerySink) Name() string {
+	return "BigQuery Sink"
+}
+
+func (this *bigquerySink) Stop() {}
+
+func (this *bigquerySink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	if !this.datasetReady {
+		if err := this.ensureDataset(batch); err != nil {
+			glog.Errorf("[batch %s] Failed to create dataset %s of %s: %v", batch.ID, this.dataset, this.project, err)
+			return
+		}
+		this.datasetReady = true
+	}
+
+	rowsByTable := map[string][]map[string]interface{}{}
+	add := func(name string, labels map[string]string, value core.MetricValue, timestamp time.Time) {
+		number := float64(value.IntValue)
+		if value.ValueType == core.ValueFloat {
+			number = float64(value.FloatValue)
+		}
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return
+		}
+		table := this.table
+		if this.tables == tablesMetric {
+			table += "_" + invalidTableCharacters.ReplaceAllString(name, "_")
+		}
+		rowsByTable[table] = append(rowsByTable[table], toRow(name, labels, value, number, timestamp))
+	}
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range metricSet.MetricValues {
+			add(name, metricSet.Labels, value, timestamp)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			labels := map[string]string{}
+			for _, source := range []map[string]string{metricSet.Labels, metric.Labels} {
+				for k, v := range source {
+					labels[k] = v
+				}
+			}
+			add(metric.Name, labels, metric.MetricValue, timestamp)
+		}
+	}
+
+	tables := make([]string, 0, len(rowsByTable))
+	for table := range rowsByTable {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		if !this.readyTables[table] {
+			if err := this.ensureTable(batch, table); err != nil {
+				glog.Errorf("[batch %s] Failed to create table %s.%s: %v", batch.ID, this.dataset, table, err)
+				continue
+			}
+			this.readyTables[table] = true
+		}
+		rows := rowsByTable[table]
+		for start := 0; start < len(rows); start += this.batchSize {
+			end := start + this.batchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			if err := this.insert(batch, table, rows[start:end]); err != nil {
+				glog.Errorf("[batch %s] Failed to insert %d rows into %s.%s: %v", batch.ID, end-start, this.dataset, table, err)
+			}
+		}
+	}
+}
+
+// toRow returns the row of a value, the labels being in their columns or in other_labels.
+func toRow(name string, labels map[string]string, value core.MetricValue, number float64, timestamp time.Time) map[string]interface{} {
+	row := map[string]interface{}{
+		"timestamp":   timestamp.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		"metric":      name,
+		"metric_type": value.MetricType.String(),
+		"value":       number,
+	}
+	columns := map[string]bool{}
+	for _, label := range labelColumns {
+		columns[label.Key] = true
+		if value := labels[label.Key]; value != "" {
+			row[label.Key] = value
+		}
+	}
+	others := []keyValue{}
+	for k, v := range labels {
+		if !columns[k] && v != "" {
+			others = append(others, keyValue{Key: k, Value: v})
+		}
+	}
+	if len(others) > 0 {
+		sort.Slice(others, func(i, j int) bool { return others[i].Key < others[j].Key })
+		row["other_labels"] = others
+	}
+	return row
+}
+
+// insert streams the rows of the batch into the table.
+func (this *bigquerySink) insert(batch *core.DataBatch, table string, rows []map[string]interface{}) error {
+	requestRows := make([]interface{}, len(rows))
+	for i, row := range rows {
+		requestRows[i] = map[string]interface{}{"json": row}
+	}
+	request := map[string]interface{}{"rows": requestRows}
+	response := struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}{}
+	if err := this.call(batch, "POST", this.tablesPath()+"/"+table+"/insertAll", request, &response); err != nil {
+		return err
+	}
+	if len(response.InsertErrors) > 0 {
+		first := response.InsertErrors[0]
+		message := ""
+		if len(first.Errors) > 0 {
+			message = first.Errors[0].Reason + ": " + first.Errors[0].Message
+		}
+		return fmt.Errorf("%d rows were rejected, the row %d with %s", len(response.InsertErrors), first.Index, message)
+	}
+	return nil
+}
+
+func (this *bigquerySink) datasetPath() string {
+	return fmt.Sprintf("/projects/%s/datasets/%s", this.project, this.dataset)
+}
+
+func (this *bigquerySink) tablesPath() string {
+	return this.datasetPath() + "/tables"
+}
+
+// ensureDataset creates the dataset unless it exists, before the first export of the batch.
+func (this *bigquerySink) ensureDataset(batch *core.DataBatch) error {
+	err := this.call(batch, "GET", this.datasetPath(), nil, nil)
+	if apiErr, ok := err.(*apiError); !ok || apiErr.status != http.StatusNotFound {
+		return err
+	}
+	dataset := map[string]interface{}{
+		"datasetReference": map[string]string{"projectId": this.project, "datasetId": this.dataset},
+	}
+	if this.location != "" {
+		dataset["location"] = this.location
+	}
+	return ignoreConflict(this.call(batch, "POST", fmt.Sprintf("/projects/%s/datasets", this.project), dataset, nil))
+}
+
+// ensureTable creates the table unless it exists, partitioned by day of the timestamp and
+// clustered by metric, before the first export of the batch to the table.
+func (this *bigquerySink) ensureTable(batch *core.DataBatch, table string) error {
+	err := this.call(batch, "GET", this.tablesPath()+"/"+table, nil, nil)
+	if apiErr, ok := err.(*apiError); !ok || apiErr.status != http.StatusNotFound {
+		return err
+	}
+	partitioning := map[string]interface{}{"type": "DAY", "field": "timestamp"}
+	if this.partitionExpiration > 0 {
+		partitioning["expirationMs"] = strconv.FormatInt(int64(this.partitionExpiration/time.Millisecond), 10)
+	}
+	return ignoreConflict(this.call(batch, "POST", this.tablesPath(), map[string]interface{}{
+		"tableReference":   map[string]string{"projectId": this.project, "datasetId": this.dataset, "tableId": table},
+		"schema":           map[string]interface{}{"fields": schema()},
+		"timePartitioning": partitioning,
+		"clustering":       map[string]interface{}{"fields": []string{"metric", core.LabelMetricSetType.Key}},
+	}, nil))
+}
+
+// ignoreConflict ignores the error of the creation of a resource which exists, e.g. created by
+// another replica since it was looked up.
+func ignoreConflict(err error) error {
+	if apiErr, ok := err.(*apiError); ok && apiErr.status == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// call sends the request exporting the batch to the path of the API and decodes the response
+// into the result.
+func (this *bigquerySink) call(batch *core.DataBatch, method, path string, request, result interface{}) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, this.endpoint+path, &body)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	util.SetBatchHeaders(req.Header, batch)
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// The errors of the API are of the form {"error": {"code": 404, "message": "..."}}.
+		response := struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}{}
+		message := strings.TrimSpace(string(contents))
+		if json.Unmarshal(contents, &response) == nil && response.Error.Message != "" {
+			message = response.Error.Message
+		}
+		return &apiError{status: resp.StatusCode, message: message}
+	}
+	if result != nil {
+		return json.Unmarshal(contents, result)
+	}
+	return nil
+}
+
+// NewBigquerySink creates a sink for uris of the form ?project=my-project&dataset=heapster, with
+// the table, tables, location, partitionExpiration, batchSize, credentialsFile, endpoint and
+// timeout options. The requests are authenticated with the service account key of the
+// credentialsFile option, or with the default credentials of Google Cloud.
+func NewBigquerySink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	project := opts.Get("project")
+	var client *http.Client
+	if file := opts.Get("credentialsFile"); file != "" {
+		key, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the credentials file: %v", err)
+		}
+		config, err := google.JWTConfigFromJSON(key, bigqueryScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the credentials file: %v", err)
+		}
+		client = config.Client(oauth2.NoContext)
+		if project == "" {
+			// The project of the service account.
+			account := struct {
+				ProjectId string `json:"project_id"`
+			}{}
+			if err := json.Unmarshal(key, &account); err == nil {
+				project = account.ProjectId
+			}
+		}
+	} else {
+		var err error
+		if client, err = google.DefaultClient(oauth2.NoContext, bigqueryScope); err != nil {
+			return nil, fmt.Errorf("error creating oauth2 client: %v", err)
+		}
+	}
+	if project == "" {
+		var err error
+		if project, err = gce_util.GetProjectId(); err != nil {
+			return nil, fmt.Errorf("the project option is required: %v", err)
+		}
+	}
+	opts.Set("project", project)
+	return newBigquerySink(opts, client)
+}
+
+func newBigquerySink(opts url.Values, client *http.Client) (*bigquerySink, error) {
+	sink := &bigquerySink{
+		client:      client,
+		endpoint:    defaultEndpoint,
+		project:     opts.Get("project"),
+		dataset:     defaultDataset,
+		table:       defaultTable,
+		tables:      tablesSingle,
+		location:    opts.Get("location"),
+		batchSize:   defaultBatchSize,
+		readyTables: map[string]bool{},
+	}
+	if value := opts.Get("endpoint"); value != "" {
+		sink.endpoint = strings.TrimSuffix(value, "/")
+	}
+	for option, target := range map[string]*string{"dataset": &sink.dataset, "table": &sink.table} {
+		if value := opts.Get(option); value != "" {
+			*target = value
+		}
+		if !identifierRegexp.MatchString(*target) {
+			return nil, fmt.Errorf("invalid %s %q", option, *target)
+		}
+	}
+	if value := opts.Get("tables"); value != "" {
+		sink.tables = value
+	}
+	if sink.tables != tablesSingle && sink.tables != tablesMetric {
+		return nil, fmt.Errorf("invalid tables option %q, expected single or metric", sink.tables)
+	}
+	var err error
+	if value := opts.Get("partitionExpiration"); value != "" {
+		if sink.partitionExpiration, err = time.ParseDuration(value); err != nil || sink.partitionExpiration < time.Hour {
+			return nil, fmt.Errorf("invalid partitionExpiration %q, expected a duration of at least 1h", value)
+		}
+	}
+	if value := opts.Get("batchSize"); value != "" {
+		if sink.batchSize, err = strconv.Atoi(value); err != nil || sink.batchSize <= 0 {
+			return nil, fmt.Errorf("invalid batchSize option %q", value)
+		}
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	timed := *client
+	timed.Timeout = timeout
+	sink.client = &timed
+
+	glog.Infof("Created BigQuery sink streaming into %s.%s of %s", sink.dataset, sink.table, sink.project)
+	return sink, nil
+}
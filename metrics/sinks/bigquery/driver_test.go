@@ -0,0 +1,224 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bigquery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+)
+
+// fakeBigquery serves the datasets and tables it holds, and records the requests.
+type fakeBigquery struct {
+	sync.Mutex
+	server   *httptest.Server
+	datasets map[string]bool
+	tables   map[string]map[string]interface{}
+	requests []string
+	inserted map[string][]map[string]interface{}
+	// Whether the inserts are rejected.
+	rejectRows bool
+}
+
+func newFakeBigquery(t *testing.T) *fakeBigquery {
+	fake := &fakeBigquery{
+		datasets: map[string]bool{},
+		tables:   map[string]map[string]interface{}{},
+		inserted: map[string][]map[string]interface{}{},
+	}
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.Lock()
+		defer fake.Unlock()
+		fake.requests = append(fake.requests, r.Method+" "+r.URL.Path)
+		body := map[string]interface{}{}
+		if r.Method == "POST" {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/bigquery/v2/projects/my-project/datasets")
+		parts := strings.Split(strings.Trim(path, "/"), "/")
+		notFound := func() {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "Not found"}}`))
+		}
+		switch {
+		case r.Method == "POST" && path == "":
+			fake.datasets[body["datasetReference"].(map[string]interface{})["datasetId"].(string)] = true
+		case r.Method == "GET" && len(parts) == 1:
+			if !fake.datasets[parts[0]] {
+				notFound()
+			}
+		case r.Method == "POST" && len(parts) == 2 && parts[1] == "tables":
+			fake.tables[body["tableReference"].(map[string]interface{})["tableId"].(string)] = body
+		case r.Method == "GET" && len(parts) == 3:
+			if fake.tables[parts[2]] == nil {
+				notFound()
+			}
+		case r.Method == "POST" && len(parts) == 4 && parts[3] == "insertAll":
+			if fake.tables[parts[2]] == nil {
+				notFound()
+				return
+			}
+			if fake.rejectRows {
+				w.Write([]byte(`{"insertErrors": [{"index": 1, "errors": [{"reason": "invalid", "message": "no such field"}]}]}`))
+				return
+			}
+			for _, row := range body["rows"].([]interface{}) {
+				fake.inserted[parts[2]] = append(fake.inserted[parts[2]], row.(map[string]interface{})["json"].(map[string]interface{}))
+			}
+			w.Write([]byte(`{"kind": "bigquery#tableDataInsertAllResponse"}`))
+		default:
+			notFound()
+		}
+	}))
+	return fake
+}
+
+func newTestSink(t *testing.T, fake *fakeBigquery, query string) *bigquerySink {
+	opts, err := url.ParseQuery("project=my-project&endpoint=" + url.QueryEscape(fake.server.URL+"/bigquery/v2") + "&" + query)
+	require.NoError(t, err)
+	sink, err := newBigquerySink(opts, http.DefaultClient)
+	require.NoError(t, err)
+	return sink
+}
+
+// testBatch gives the pod of the shared batch a sub-second scrape time, an empty node name and
+// a label that has no column of its own.
+func testBatch() *core.DataBatch {
+	batch := sinktest.Batch()
+	pod := batch.MetricSets[core.PodKey("default", "web-1")]
+	pod.ScrapeTime = pod.ScrapeTime.Add(250 * time.Millisecond)
+	pod.Labels[core.LabelNodename.Key] = ""
+	pod.Labels[core.LabelDeploymentName.Key] = "web"
+	return batch
+}
+
+func TestSingleTable(t *testing.T) {
+	fake := newFakeBigquery(t)
+	defer fake.server.Close()
+	sink := newTestSink(t, fake, "dataset=monitoring&location=EU&partitionExpiration=720h")
+
+	sink.ExportData(testBatch())
+	sink.ExportData(testBatch())
+
+	fake.Lock()
+	defer fake.Unlock()
+	// The dataset and the table are created once.
+	assert.Equal(t, []string{
+		"GET /bigquery/v2/projects/my-project/datasets/monitoring",
+		"POST /bigquery/v2/projects/my-project/datasets",
+		"GET /bigquery/v2/projects/my-project/datasets/monitoring/tables/metrics",
+		"POST /bigquery/v2/projects/my-project/datasets/monitoring/tables",
+		"POST /bigquery/v2/projects/my-project/datasets/monitoring/tables/metrics/insertAll",
+		"POST /bigquery/v2/projects/my-project/datasets/monitoring/tables/metrics/insertAll",
+	}, fake.requests)
+
+	table := fake.tables["metrics"]
+	require.NotNil(t, table)
+	assert.Equal(t, map[string]interface{}{"type": "DAY", "field": "timestamp", "expirationMs": "2592000000"}, table["timePartitioning"])
+	fields := table["schema"].(map[string]interface{})["fields"].([]interface{})
+	assert.Equal(t, len(schema()), len(fields))
+	assert.Equal(t, "timestamp", fields[0].(map[string]interface{})["name"])
+
+	rows := fake.inserted["metrics"]
+	require.Equal(t, 8, len(rows))
+	byMetric := map[string]map[string]interface{}{}
+	for _, row := range rows[:4] {
+		byMetric[row["type"].(string)+"/"+row["metric"].(string)] = row
+	}
+	memory := byMetric["pod/"+core.MetricMemoryUsage.Name]
+	require.NotNil(t, memory)
+	assert.Equal(t, "2017-07-14T02:40:00.250000Z", memory["timestamp"])
+	assert.Equal(t, "gauge", memory["metric_type"])
+	assert.Equal(t, 100.0, memory["value"])
+	assert.Equal(t, "pod", memory["type"])
+	assert.Equal(t, "web-1", memory["pod_name"])
+	_, found := memory["nodename"]
+	assert.False(t, found)
+	assert.Equal(t, []interface{}{map[string]interface{}{"key": "deployment_name", "value": "web"}}, memory["other_labels"])
+	assert.Equal(t, 1024.0, byMetric["node/"+core.MetricMemoryUsage.Name]["value"])
+	filesystem := byMetric["node/"+core.MetricFilesystemUsage.Name]
+	assert.Equal(t, "2017-07-14T02:40:00.000000Z", filesystem["timestamp"])
+	assert.Equal(t, "/dev/sda1", filesystem["resource_id"])
+	assert.Equal(t, 0.5, filesystem["value"])
+}
+
+func TestTablePerMetric(t *testing.T) {
+	fake := newFakeBigquery(t)
+	defer fake.server.Close()
+	fake.datasets["heapster"] = true
+	sink := newTestSink(t, fake, "tables=metric&table=k8s&batchSize=1")
+
+	sink.ExportData(testBatch())
+
+	fake.Lock()
+	defer fake.Unlock()
+	assert.Equal(t, 3, len(fake.tables))
+	assert.Equal(t, 1, len(fake.inserted["k8s_cpu_usage"]))
+	assert.Equal(t, 2, len(fake.inserted["k8s_memory_usage"]))
+	assert.Equal(t, 1, len(fake.inserted["k8s_filesystem_usage"]))
+	assert.Equal(t, nil, fake.tables["k8s_cpu_usage"]["timePartitioning"].(map[string]interface{})["expirationMs"])
+}
+
+func TestInsertErrors(t *testing.T) {
+	fake := newFakeBigquery(t)
+	defer fake.server.Close()
+	fake.datasets["heapster"] = true
+	fake.tables["metrics"] = map[string]interface{}{}
+	fake.rejectRows = true
+	sink := newTestSink(t, fake, "")
+
+	err := sink.insert(&core.DataBatch{}, "metrics", []map[string]interface{}{{}, {}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such field")
+
+	err = sink.insert(&core.DataBatch{}, "missing", []map[string]interface{}{{}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, err.(*apiError).status)
+	assert.Equal(t, "Not found", err.(*apiError).message)
+}
+
+func TestNewBigquerySink(t *testing.T) {
+	sink, err := newBigquerySink(url.Values{"project": {"my-project"}}, http.DefaultClient)
+	require.NoError(t, err)
+	assert.Equal(t, defaultEndpoint, sink.endpoint)
+	assert.Equal(t, defaultDataset, sink.dataset)
+	assert.Equal(t, defaultTable, sink.table)
+	assert.Equal(t, tablesSingle, sink.tables)
+	assert.Equal(t, defaultBatchSize, sink.batchSize)
+
+	for _, invalid := range []string{
+		"dataset=my-dataset",
+		"table=1metrics",
+		"tables=namespace",
+		"partitionExpiration=10m",
+		"batchSize=0",
+		"timeout=never",
+	} {
+		opts, err := url.ParseQuery("project=my-project&" + invalid)
+		require.NoError(t, err)
+		_, err = newBigquerySink(opts, http.DefaultClient)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package circonus
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultApiUrl = "https://api.circonus.com/v2"
+	apiAppName    = "heapster"
+	// The period of the checks, which does not matter for HTTPTrap checks as the metrics are pushed.
+	checkPeriod = 60
+)
+
+// checkBundle is a check bundle of the Circonus API, see https://login.circonus.com/resources/api/calls/check_bundle.
+type checkBundle struct {
+	Cid           string            `json:"_cid,omitempty"`
+	Brokers       []string          `json:"brokers"`
+	Config        map[string]string `json:"config"`
+	DisplayName   string            `json:"display_name"`
+	Metrics       []interface{}     `json:"metrics"`
+	MetricFilters [][]string        `json:"metric_filters"`
+	Period        int               `json:"period"`
+	Status        string            `json:"status,omitempty"`
+	Tags          []string          `json:"tags"`
+	Target        string            `json:"target"`
+	Type          string            `json:"type"`
+}
+
+// broker is a broker of the Circonus API, with the modules of each of its instances.
+type broker struct {
+	Cid     string `json:"_cid"`
+	Details []struct {
+		Status  string   `json:"status"`
+		Modules []string `json:"modules"`
+	} `json:"_details"`
+}
+
+// apiClient calls the Circonus API with an API token.
+type apiClient struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func (this *apiClient) call(method, path string, request, result interface{}) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, this.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Circonus-Auth-Token", this.token)
+	req.Header.Set("X-Circonus-App-Name", apiAppName)
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s failed with %s: %s", method, path, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return json.Unmarshal(contents, result)
+}
+
+// submissionUrl returns the submission url of the active HTTPTrap check bundle of the target,
+// which is created with the display name and the tags if it does not exist. The bundle is
+// created on the broker, or on the first active broker supporting HTTPTrap if empty. The
+// bundle accepts all the metrics, so that it does not need to be updated when new ones appear.
+func (this *apiClient) submissionUrl(target, displayName, brokerCid string, tags []string) (string, error) {
+	bundles := []checkBundle{}
+	query := url.Values{"f_type": {"httptrap"}, "f_target": {target}, "f_status": {"active"}}
+	if err := this.call("GET", "/check_bundle?"+query.Encode(), nil, &bundles); err != nil {
+		return "", err
+	}
+	for _, bundle := range bundles {
+		if bundle.Config["submission_url"] != "" {
+			return bundle.Config["submission_url"], nil
+		}
+	}
+
+	if brokerCid == "" {
+		var err error
+		if brokerCid, err = this.httptrapBroker(); err != nil {
+			return "", err
+		}
+	}
+	secret := make([]byte, 8)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	bundle := checkBundle{
+		Brokers:       []string{brokerCid},
+		Config:        map[string]string{"asynch_metrics": "true", "secret": hex.EncodeToString(secret)},
+		DisplayName:   displayName,
+		Metrics:       []interface{}{},
+		MetricFilters: [][]string{{"allow", "^.+$", ""}},
+		Period:        checkPeriod,
+		Tags:          tags,
+		Target:        target,
+		Type:          "httptrap",
+	}
+	created := checkBundle{}
+	if err := this.call("POST", "/check_bundle", bundle, &created); err != nil {
+		return "", err
+	}
+	if created.Config["submission_url"] == "" {
+		return "", fmt.Errorf("check bundle %s has no submission url", created.Cid)
+	}
+	return created.Config["submission_url"], nil
+}
+
+// httptrapBroker returns the first broker with an active instance supporting HTTPTrap.
+func (this *apiClient) httptrapBroker() (string, error) {
+	brokers := []broker{}
+	if err := this.call("GET", "/broker", nil, &brokers); err != nil {
+		return "", err
+	}
+	for _, broker := range brokers {
+		for _, details := range broker.Details {
+			if details.Status != "active" {
+				continue
+			}
+			for _, module := range details.Modules {
+				if module == "httptrap" {
+					return broker.Cid, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("no active broker supports httptrap checks")
+}
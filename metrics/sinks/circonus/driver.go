@@ -0,0 +1,380 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package circonus implements sinks submitting the metrics to a Circonus HTTPTrap check, which
+// can be provisioned with the Circonus API, or to the raw ingestion endpoint of IRONdb. The
+// labels of the metrics are sent as stream tags.
+package circonus
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+const (
+	defaultMaxMetrics = 1000
+	defaultTimeout    = 30 * time.Second
+	irondbRawPath     = "/raw"
+
+	// The types of the values: signed 64-bit integers and doubles.
+	typeInt64  = "l"
+	typeDouble = "n"
+)
+
+// The characters of tag categories and values which are sent as they are. Other tags are
+// encoded in base64, as b"...".
+var plainTagRegexp = regexp.MustCompile("^[a-zA-Z0-9_./-]+$")
+
+var uuidRegexp = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// point is a value of a metric, named with its stream tags, e.g. cpu/usage|ST[nodename:node-1].
+type point struct {
+	name      string
+	kind      string
+	value     float64
+	intValue  int64
+	timestamp time.Time
+}
+
+type circonusSink struct {
+	sync.Mutex
+	client *http.Client
+	// The submission url of the HTTPTrap check, or the raw endpoint of IRONdb.
+	url string
+	// The url without its secret, for the logs.
+	displayUrl string
+	// The check of the metrics in IRONdb, empty for HTTPTrap.
+	checkUUID string
+	prefix    string
+	// Tags added to all the metrics.
+	tags map[string]string
+	// Number of metrics above which a batch is split in several requests.
+	maxMetrics int
+}
+
+func (this *circonusSink) Name() string {
+	if this.checkUUID != "" {
+		return "IRONdb Sink"
+	}
+	return "Circonus Sink"
+}
+
+func (this *circonusSink) Stop() {}
+
+func (this *circonusSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	points := this.points(batch)
+	for start := 0; start < len(points); start += this.maxMetrics {
+		end := start + this.maxMetrics
+		if end > len(points) {
+			end = len(points)
+		}
+		var err error
+		if this.checkUUID != "" {
+			err = this.send(batch, encodeRaw(points[start:end], this.checkUUID))
+		} else {
+			err = this.send(batch, encodeHttptrap(points[start:end]))
+		}
+		if err != nil {
+			glog.Errorf("[batch %s] Failed to submit %d metrics to %s: %v", batch.ID, end-start, this.displayUrl, err)
+		}
+	}
+}
+
+func (this *circonusSink) points(batch *core.DataBatch) []point {
+	points := []point{}
+	add := func(name string, labels, metricLabels map[string]string, value core.MetricValue, timestamp time.Time) {
+		p := point{kind: typeInt64, intValue: value.IntValue, timestamp: timestamp}
+		if value.ValueType == core.ValueFloat {
+			p.kind = typeDouble
+			p.value = float64(value.FloatValue)
+			if math.IsNaN(p.value) || math.IsInf(p.value, 0) {
+				return
+			}
+		}
+		tags := map[string]string{}
+		for _, source := range []map[string]string{this.tags, labels, metricLabels} {
+			for k, v := range source {
+				if v != "" {
+					tags[k] = v
+				}
+			}
+		}
+		p.name = metricName(this.prefix+name, tags)
+		points = append(points, p)
+	}
+
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range metricSet.MetricValues {
+			add(name, metricSet.Labels, nil, value, timestamp)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			add(metric.Name, metricSet.Labels, metric.Labels, metric.MetricValue, timestamp)
+		}
+	}
+	return points
+}
+
+// metricName returns the name of the metric with its stream tags, sorted by category, e.g.
+// cpu/usage|ST[nodename:node-1,type:node].
+func metricName(name string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	categories := make([]string, 0, len(tags))
+	for category := range tags {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	pairs := make([]string, len(categories))
+	for i, category := range categories {
+		pairs[i] = encodeTag(category) + ":" + encodeTag(tags[category])
+	}
+	return name + "|ST[" + strings.Join(pairs, ",") + "]"
+}
+
+func encodeTag(tag string) string {
+	if plainTagRegexp.MatchString(tag) {
+		return tag
+	}
+	return `b"` + base64.StdEncoding.EncodeToString([]byte(tag)) + `"`
+}
+
+// encodeHttptrap returns the JSON document of the points, e.g.
+// {"cpu/usage|ST[nodename:node-1]": {"_type": "l", "_value": 100, "_ts": 1500000000000}}.
+func encodeHttptrap(points []point) []byte {
+	metrics := make(map[string]interface{}, len(points))
+	for _, p := range points {
+		var value interface{} = p.intValue
+		if p.kind == typeDouble {
+			value = p.value
+		}
+		metrics[p.name] = map[string]interface{}{
+			"_type":  p.kind,
+			"_value": value,
+			"_ts":    p.timestamp.UnixNano() / int64(time.Millisecond),
+		}
+	}
+	document, _ := json.Marshal(metrics)
+	return document
+}
+
+// encodeRaw returns the M records of the points, tab separated, e.g.
+// M	1500000000.000	<check uuid>	cpu/usage|ST[nodename:node-1]	l	100
+func encodeRaw(points []point, checkUUID string) []byte {
+	var records bytes.Buffer
+	for _, p := range points {
+		milliseconds := p.timestamp.UnixNano() / int64(time.Millisecond)
+		value := strconv.FormatInt(p.intValue, 10)
+		if p.kind == typeDouble {
+			value = strconv.FormatFloat(p.value, 'g', -1, 64)
+		}
+		fmt.Fprintf(&records, "M\t%d.%03d\t%s\t%s\t%s\t%s\n",
+			milliseconds/1000, milliseconds%1000, checkUUID, p.name, p.kind, value)
+	}
+	return records.Bytes()
+}
+
+// send puts the body of the batch to the url, which both HTTPTrap and IRONdb accept.
+func (this *circonusSink) send(batch *core.DataBatch, body []byte) error {
+	req, err := http.NewRequest("PUT", this.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if this.checkUUID != "" {
+		req.Header.Set("Content-Type", "text/plain")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	util.SetBatchHeaders(req.Header, batch)
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		contents, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return nil
+}
+
+// parseTags parses tags given as category:value, separated by commas.
+func parseTags(values []string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid tag %q, expected category:value", pair)
+			}
+			tags[parts[0]] = parts[1]
+		}
+	}
+	return tags, nil
+}
+
+// newSink returns a sink with the options common to Circonus and IRONdb: prefix, tags,
+// maxMetricsPerRequest, insecure and timeout.
+func newSink(opts url.Values) (*circonusSink, error) {
+	tags, err := parseTags(opts["tags"])
+	if err != nil {
+		return nil, err
+	}
+	sink := &circonusSink{
+		prefix:     opts.Get("prefix"),
+		tags:       tags,
+		maxMetrics: defaultMaxMetrics,
+	}
+	if value := opts.Get("maxMetricsPerRequest"); value != "" {
+		if sink.maxMetrics, err = strconv.Atoi(value); err != nil || sink.maxMetrics <= 0 {
+			return nil, fmt.Errorf("invalid maxMetricsPerRequest option %q", value)
+		}
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if value := opts.Get("insecure"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure option %q: %v", value, err)
+		}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	}
+	sink.client = &http.Client{Timeout: timeout, Transport: transport}
+	return sink, nil
+}
+
+// NewCirconusSink creates a sink submitting to the HTTPTrap check of the submission url of the
+// uri, e.g. https://trap.noit.circonus.net/module/httptrap/<CHECK_UUID>/<SECRET>. Without url,
+// the check is looked up, or created, with the Circonus API and the API token of the token
+// option, by the checkTarget, checkDisplayName, checkTags, broker and apiUrl options.
+func NewCirconusSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	sink, err := newSink(opts)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Host != "" {
+		submission := *uri
+		if submission.Scheme == "" {
+			submission.Scheme = "https"
+		}
+		// The options of the sink are not forwarded to the check.
+		submission.RawQuery = ""
+		sink.url = submission.String()
+	} else {
+		creds, err := credentials.New(opts, "user", "password")
+		if err != nil {
+			return nil, err
+		}
+		token := creds.Get().Token
+		if token == "" {
+			return nil, fmt.Errorf("either the submission url of the check or the token option is required")
+		}
+		target := opts.Get("checkTarget")
+		if target == "" {
+			if target, err = os.Hostname(); err != nil {
+				return nil, fmt.Errorf("failed to get the hostname: %v", err)
+			}
+		}
+		displayName := opts.Get("checkDisplayName")
+		if displayName == "" {
+			displayName = "heapster " + target
+		}
+		checkTags := []string{}
+		for _, value := range opts["checkTags"] {
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					checkTags = append(checkTags, tag)
+				}
+			}
+		}
+		api := &apiClient{client: sink.client, url: defaultApiUrl, token: token}
+		if value := opts.Get("apiUrl"); value != "" {
+			api.url = strings.TrimSuffix(value, "/")
+		}
+		if sink.url, err = api.submissionUrl(target, displayName, opts.Get("broker"), checkTags); err != nil {
+			return nil, fmt.Errorf("failed to provision the check of %s: %v", target, err)
+		}
+	}
+	submission, err := url.Parse(sink.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid submission url: %v", err)
+	}
+	sink.displayUrl = submission.Scheme + "://" + submission.Host
+
+	glog.Infof("Created Circonus sink submitting to the HTTPTrap check of %s", sink.displayUrl)
+	return sink, nil
+}
+
+// NewIrondbSink creates a sink writing to the raw endpoint of the IRONdb node of the uri, e.g.
+// http://irondb:8112?checkUUID=<CHECK_UUID>, the metrics being stored as those of the check.
+func NewIrondbSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing host in IRONdb endpoint %q", uri.String())
+	}
+	sink, err := newSink(opts)
+	if err != nil {
+		return nil, err
+	}
+	sink.checkUUID = opts.Get("checkUUID")
+	if !uuidRegexp.MatchString(sink.checkUUID) {
+		return nil, fmt.Errorf("invalid checkUUID %q, expected a UUID", sink.checkUUID)
+	}
+	endpoint := *uri
+	if endpoint.Scheme == "" {
+		endpoint.Scheme = "http"
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + irondbRawPath
+	endpoint.RawQuery = ""
+	sink.url = endpoint.String()
+	sink.displayUrl = sink.url
+
+	glog.Infof("Created IRONdb sink writing to %s", sink.url)
+	return sink, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package circonus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+const checkUUID = "1b2e3c4d-5e6f-4a8b-9c0d-1e2f3a4b5c6d"
+
+func TestHttptrap(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := sinktest.NewServer(t, requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"stats": 4}`))
+	})
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "/module/httptrap/" + checkUUID + "/secret?tags=cluster:prod&prefix=k8s.")
+	require.NoError(t, err)
+	sink, err := NewCirconusSink(uri)
+	require.NoError(t, err)
+	assert.NotContains(t, sink.(*circonusSink).displayUrl, "secret")
+	batch := sinktest.Batch()
+	batch.MetricSets[core.NodeKey("node-1")].LabeledMetrics[0].Labels[core.LabelResourceID.Key] = "/dev/sda 1"
+	sink.ExportData(batch)
+
+	req := <-requests
+	assert.Equal(t, "PUT", req.Method)
+	assert.Equal(t, "/module/httptrap/"+checkUUID+"/secret", req.Path)
+	assert.Equal(t, sinktest.BatchID, req.Header.Get(util.BatchIDHeader))
+	metrics := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(req.Body, &metrics))
+	assert.Equal(t, map[string]interface{}{
+		"k8s.cpu/usage|ST[cluster:prod,nodename:node-1,type:node]": map[string]interface{}{
+			"_type": "l", "_value": 100.0, "_ts": 1500000000000.0,
+		},
+		"k8s.memory/usage|ST[cluster:prod,nodename:node-1,type:node]": map[string]interface{}{
+			"_type": "l", "_value": 1024.0, "_ts": 1500000000000.0,
+		},
+		`k8s.filesystem/usage|ST[cluster:prod,nodename:node-1,resource_id:b"L2Rldi9zZGEgMQ==",type:node]`: map[string]interface{}{
+			"_type": "n", "_value": 0.5, "_ts": 1500000000000.0,
+		},
+		"k8s.memory/usage|ST[cluster:prod,namespace_name:default,pod_name:web-1,type:pod]": map[string]interface{}{
+			"_type": "l", "_value": 100.0, "_ts": 1500000000000.0,
+		},
+	}, metrics)
+}
+
+func TestIrondb(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := sinktest.NewServer(t, requests, nil)
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "?checkUUID=" + checkUUID + "&maxMetricsPerRequest=2")
+	require.NoError(t, err)
+	sink, err := NewIrondbSink(uri)
+	require.NoError(t, err)
+	sink.ExportData(sinktest.Batch())
+
+	records := []string{}
+	for i := 0; i < 2; i++ {
+		req := <-requests
+		assert.Equal(t, "PUT", req.Method)
+		assert.Equal(t, "/raw", req.Path)
+		records = append(records, req.Lines()...)
+	}
+	sort.Strings(records)
+	assert.Equal(t, []string{
+		"M\t1500000000.000\t" + checkUUID + "\tcpu/usage|ST[nodename:node-1,type:node]\tl\t100",
+		"M\t1500000000.000\t" + checkUUID + "\tfilesystem/usage|ST[nodename:node-1,resource_id:/dev/sda1,type:node]\tn\t0.5",
+		"M\t1500000000.000\t" + checkUUID + "\tmemory/usage|ST[namespace_name:default,pod_name:web-1,type:pod]\tl\t100",
+		"M\t1500000000.000\t" + checkUUID + "\tmemory/usage|ST[nodename:node-1,type:node]\tl\t1024",
+	}, records)
+}
+
+func TestProvisioning(t *testing.T) {
+	trapRequests := make(chan sinktest.Request, 10)
+	trap := sinktest.NewServer(t, trapRequests, nil)
+	defer trap.Close()
+
+	requests := make(chan sinktest.Request, 10)
+	bundles := []checkBundle{}
+	api := sinktest.NewServer(t, requests, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/check_bundle":
+			json.NewEncoder(w).Encode(bundles)
+		case r.Method == "GET" && r.URL.Path == "/broker":
+			w.Write([]byte(`[
+				{"_cid": "/broker/1", "_details": [{"status": "active", "modules": ["http", "ping_icmp"]}]},
+				{"_cid": "/broker/2", "_details": [{"status": "unprovisioned", "modules": ["httptrap"]}]},
+				{"_cid": "/broker/3", "_details": [{"status": "active", "modules": ["httptrap"]}]}
+			]`))
+		case r.Method == "POST" && r.URL.Path == "/check_bundle":
+			bundle := checkBundle{}
+			json.NewDecoder(r.Body).Decode(&bundle)
+			bundle.Cid = "/check_bundle/7"
+			bundle.Config["submission_url"] = trap.URL + "/module/httptrap/" + checkUUID + "/" + bundle.Config["secret"]
+			json.NewEncoder(w).Encode(bundle)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer api.Close()
+
+	uri, err := url.Parse("?token=api-token&apiUrl=" + url.QueryEscape(api.URL) + "&checkTarget=cluster-1&checkTags=env:prod")
+	require.NoError(t, err)
+	sink, err := NewCirconusSink(uri)
+	require.NoError(t, err)
+
+	search := <-requests
+	assert.Equal(t, "/check_bundle", search.Path)
+	assert.Equal(t, url.Values{"f_status": {"active"}, "f_target": {"cluster-1"}, "f_type": {"httptrap"}}, search.Query)
+	assert.Equal(t, "api-token", search.Header.Get("X-Circonus-Auth-Token"))
+	assert.Equal(t, "heapster", search.Header.Get("X-Circonus-App-Name"))
+	assert.Equal(t, "/broker", (<-requests).Path)
+	create := <-requests
+	created := checkBundle{}
+	require.NoError(t, json.Unmarshal(create.Body, &created))
+	assert.Equal(t, []string{"/broker/3"}, created.Brokers)
+	assert.Equal(t, "heapster cluster-1", created.DisplayName)
+	assert.Equal(t, "httptrap", created.Type)
+	assert.Equal(t, []string{"env:prod"}, created.Tags)
+	assert.Equal(t, "true", created.Config["asynch_metrics"])
+	assert.Equal(t, 16, len(created.Config["secret"]))
+
+	sink.ExportData(sinktest.Batch())
+	assert.Equal(t, "/module/httptrap/"+checkUUID+"/"+created.Config["secret"], (<-trapRequests).Path)
+
+	// The existing check is used.
+	bundles = []checkBundle{{Cid: "/check_bundle/7", Config: map[string]string{"submission_url": trap.URL + "/existing"}}}
+	sink, err = NewCirconusSink(uri)
+	require.NoError(t, err)
+	<-requests
+	assert.Equal(t, trap.URL+"/existing", sink.(*circonusSink).url)
+}
+
+func TestOptions(t *testing.T) {
+	for uri, valid := range map[string]bool{
+		"https://trap.noit.circonus.net/module/httptrap/" + checkUUID + "/secret":   true,
+		"https://trap.noit.circonus.net/module/httptrap/x/y?tags=cluster":           false,
+		"https://trap.noit.circonus.net/module/httptrap/x/y?maxMetricsPerRequest=0": false,
+		"?checkTarget=cluster-1": false,
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewCirconusSink(parsed)
+		assert.Equal(t, valid, err == nil, uri)
+	}
+	for uri, valid := range map[string]bool{
+		"http://irondb:8112?checkUUID=" + checkUUID: true,
+		"http://irondb:8112?checkUUID=check":        false,
+		"http://irondb:8112":                        false,
+		"?checkUUID=" + checkUUID:                   false,
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewIrondbSink(parsed)
+		assert.Equal(t, valid, err == nil, uri)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+)
+
+type fakeClickhouse struct {
+	sync.Mutex
+	server   *httptest.Server
+	requests chan sinktest.Request
+	// Status of the responses to the inserts, OK if zero.
+	insertStatus int
+}
+
+func newFakeClickhouse(t *testing.T) *fakeClickhouse {
+	fake := &fakeClickhouse{requests: make(chan sinktest.Request, 10)}
+	fake.server = sinktest.NewServer(t, fake.requests, func(w http.ResponseWriter, r *http.Request) {
+		fake.Lock()
+		defer fake.Unlock()
+		if fake.insertStatus != 0 && strings.HasPrefix(r.URL.Query().Get("query"), "INSERT") {
+			w.WriteHeader(fake.insertStatus)
+			w.Write([]byte("Code: 27. DB::Exception: Cannot parse input"))
+		}
+	})
+	return fake
+}
+
+func (this *fakeClickhouse) recorded() []sinktest.Request {
+	requests := []sinktest.Request{}
+	for {
+		select {
+		case r := <-this.requests:
+			requests = append(requests, r)
+		default:
+			return requests
+		}
+	}
+}
+
+func newTestSink(t *testing.T, uri string) core.DataSink {
+	parsed, err := url.Parse(uri)
+	require.NoError(t, err)
+	sink, err := NewClickhouseSink(parsed)
+	require.NoError(t, err)
+	return sink
+}
+
+// testBatch gives the node of the shared batch a sub-second scrape time.
+func testBatch() *core.DataBatch {
+	batch := sinktest.Batch()
+	node := batch.MetricSets[core.NodeKey("node-1")]
+	node.ScrapeTime = node.ScrapeTime.Add(250 * time.Millisecond)
+	return batch
+}
+
+// decodeRows returns the rows of the body by type and name.
+func decodeRows(t *testing.T, body []byte) map[string]row {
+	rows := map[string]row{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		r := row{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		rows[r.Labels[core.LabelMetricSetType.Key]+"/"+r.Name] = r
+	}
+	return rows
+}
+
+func TestInsert(t *testing.T) {
+	fake := newFakeClickhouse(t)
+	defer fake.server.Close()
+	sink := newTestSink(t, strings.Replace(fake.server.URL, "http://", "http://heapster:secret@", 1)+"?database=monitoring&ttl=720h")
+
+	sink.ExportData(testBatch())
+	sink.ExportData(testBatch())
+
+	requests := fake.recorded()
+	// The table is created once.
+	require.Equal(t, 4, len(requests))
+	assert.Equal(t, "CREATE DATABASE IF NOT EXISTS monitoring", string(requests[0].Body))
+	assert.True(t, strings.HasPrefix(string(requests[1].Body), "CREATE TABLE IF NOT EXISTS monitoring.metrics ("), string(requests[1].Body))
+	assert.True(t, strings.HasSuffix(string(requests[1].Body),
+		"ENGINE = MergeTree PARTITION BY toDate(timestamp) ORDER BY (name, timestamp) TTL toDateTime(timestamp) + INTERVAL 2592000 SECOND"), string(requests[1].Body))
+
+	insert := requests[2]
+	assert.Equal(t, "INSERT INTO monitoring.metrics FORMAT JSONEachRow", insert.Query.Get("query"))
+	assert.Equal(t, "", insert.Query.Get("async_insert"))
+	assert.Equal(t, "heapster", insert.Header.Get("X-ClickHouse-User"))
+	assert.Equal(t, "secret", insert.Header.Get("X-ClickHouse-Key"))
+	rows := decodeRows(t, insert.Body)
+	require.Equal(t, 4, len(rows))
+	memory := rows["node/"+core.MetricMemoryUsage.Name]
+	assert.Equal(t, "2017-07-14 02:40:00.250", memory.Timestamp)
+	assert.Equal(t, "gauge", memory.Type)
+	assert.Equal(t, 1024.0, memory.Value)
+	assert.Equal(t, map[string]string{"type": "node", "nodename": "node-1"}, memory.Labels)
+	assert.Equal(t, "cumulative", rows["node/"+core.MetricCpuUsage.Name].Type)
+	pod := rows["pod/"+core.MetricMemoryUsage.Name]
+	assert.Equal(t, "2017-07-14 02:40:00.000", pod.Timestamp)
+	assert.Equal(t, map[string]string{"type": "pod", "namespace_name": "default", "pod_name": "web-1"}, pod.Labels)
+	filesystem := rows["node/"+core.MetricFilesystemUsage.Name]
+	assert.Equal(t, 0.5, filesystem.Value)
+	assert.Equal(t, "/dev/sda1", filesystem.Labels["resource_id"])
+}
+
+func TestAsyncInsert(t *testing.T) {
+	fake := newFakeClickhouse(t)
+	defer fake.server.Close()
+	sink := newTestSink(t, fake.server.URL+"?createTable=false&asyncInsert=true&waitForAsyncInsert=false&batchSize=2")
+
+	sink.ExportData(testBatch())
+
+	requests := fake.recorded()
+	require.Equal(t, 2, len(requests))
+	for _, r := range requests {
+		assert.Equal(t, "INSERT INTO heapster.metrics FORMAT JSONEachRow", r.Query.Get("query"))
+		assert.Equal(t, "1", r.Query.Get("async_insert"))
+		assert.Equal(t, "0", r.Query.Get("wait_for_async_insert"))
+		assert.Equal(t, "", r.Header.Get("X-ClickHouse-User"))
+	}
+	assert.Equal(t, 2, len(decodeRows(t, requests[0].Body)))
+	assert.Equal(t, 2, len(decodeRows(t, requests[1].Body)))
+}
+
+func TestCustomEngine(t *testing.T) {
+	fake := newFakeClickhouse(t)
+	defer fake.server.Close()
+	engine := "ReplicatedMergeTree('/clickhouse/tables/{shard}/metrics', '{replica}') ORDER BY (name, timestamp)"
+	sink := newTestSink(t, fake.server.URL+"?engine="+url.QueryEscape(engine))
+
+	sink.ExportData(testBatch())
+
+	requests := fake.recorded()
+	require.Equal(t, 3, len(requests))
+	assert.True(t, strings.HasSuffix(string(requests[1].Body), "ENGINE = "+engine), string(requests[1].Body))
+}
+
+func TestFailedInsert(t *testing.T) {
+	fake := newFakeClickhouse(t)
+	defer fake.server.Close()
+	fake.insertStatus = http.StatusBadRequest
+	sink := newTestSink(t, fake.server.URL+"?createTable=false").(*clickhouseSink)
+
+	err := sink.insert(&core.DataBatch{}, []*row{{Name: "cpu/usage"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Cannot parse input")
+}
+
+func TestNewClickhouseSink(t *testing.T) {
+	sink := newTestSink(t, "https://clickhouse.example.com").(*clickhouseSink)
+	assert.Equal(t, "https://clickhouse.example.com:8443/", sink.endpoint)
+	sink = newTestSink(t, "http://clickhouse.example.com").(*clickhouseSink)
+	assert.Equal(t, "http://clickhouse.example.com:8123/", sink.endpoint)
+	assert.Equal(t, defaultDatabase, sink.database)
+	assert.Equal(t, defaultTable, sink.table)
+	assert.Equal(t, defaultEngine, sink.engine)
+	assert.True(t, sink.createTable)
+
+	for _, invalid := range []string{
+		"tcp://clickhouse.example.com:9000",
+		"http:///heapster",
+		"http://clickhouse.example.com?table=metrics-1",
+		"http://clickhouse.example.com?database=1db",
+		"http://clickhouse.example.com?ttl=1ms",
+		"http://clickhouse.example.com?batchSize=0",
+		"http://clickhouse.example.com?asyncInsert=maybe",
+	} {
+		uri, err := url.Parse(invalid)
+		require.NoError(t, err)
+		_, err = NewClickhouseSink(uri)
+		assert.Error(t, err, invalid)
+	}
+}
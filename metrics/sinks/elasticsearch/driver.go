@@ -72,12 +72,12 @@ func (sink *elasticSearchSink) ExportData(dataBatch *core.DataBatch) {
 		for family, dataPoints := range familyPoints {
 			err := sink.saveData(dataBatch.Timestamp.UTC(), string(family), dataPoints)
 			if err != nil {
-				glog.Warningf("Failed to export data to ElasticSearch sink: %v", err)
+				glog.Warningf("[batch %s] Failed to export data to ElasticSearch sink: %v", dataBatch.ID, err)
 			}
 		}
 		err := sink.flushData()
 		if err != nil {
-			glog.Warningf("Failed to flushing data to ElasticSearch sink: %v", err)
+			glog.Warningf("[batch %s] Failed to flushing data to ElasticSearch sink: %v", dataBatch.ID, err)
 		}
 	}
 }
@@ -15,13 +15,22 @@
 package sinks
 
 import (
+	"crypto/sha1"
 	"fmt"
+	"net/url"
+	"path/filepath"
 	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/heapster/common/flags"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/archive"
+	"k8s.io/heapster/metrics/sinks/azuremonitor"
+	"k8s.io/heapster/metrics/sinks/bigquery"
+	"k8s.io/heapster/metrics/sinks/circonus"
+	"k8s.io/heapster/metrics/sinks/clickhouse"
 	"k8s.io/heapster/metrics/sinks/elasticsearch"
+	"k8s.io/heapster/metrics/sinks/file"
 	"k8s.io/heapster/metrics/sinks/gcm"
 	"k8s.io/heapster/metrics/sinks/graphite"
 	"k8s.io/heapster/metrics/sinks/hawkular"
@@ -29,26 +38,111 @@ import (
 	"k8s.io/heapster/metrics/sinks/influxdb"
 	"k8s.io/heapster/metrics/sinks/kafka"
 	"k8s.io/heapster/metrics/sinks/librato"
+	"k8s.io/heapster/metrics/sinks/lineprotocol"
 	logsink "k8s.io/heapster/metrics/sinks/log"
 	metricsink "k8s.io/heapster/metrics/sinks/metric"
+	"k8s.io/heapster/metrics/sinks/mqtt"
 	"k8s.io/heapster/metrics/sinks/opentsdb"
+	"k8s.io/heapster/metrics/sinks/pushgateway"
+	"k8s.io/heapster/metrics/sinks/remotewrite"
 	"k8s.io/heapster/metrics/sinks/riemann"
+	"k8s.io/heapster/metrics/sinks/signalfx"
+	"k8s.io/heapster/metrics/sinks/splunk"
 	"k8s.io/heapster/metrics/sinks/stackdriver"
 	"k8s.io/heapster/metrics/sinks/statsd"
+	"k8s.io/heapster/metrics/sinks/syslog"
+	"k8s.io/heapster/metrics/sinks/victoriametrics"
+	"k8s.io/heapster/metrics/sinks/warp10"
 	"k8s.io/heapster/metrics/sinks/wavefront"
 )
 
 type SinkFactory struct {
+	// Address of the Kubernetes API server, for sinks looking up objects. Nil if Heapster does
+	// not run in Kubernetes.
+	kubernetesUrl *url.URL
+	// Directory where the batches exported to deduplicating sinks are journaled, empty if
+	// journaling is disabled.
+	journalDir        string
+	journalMaxBatches int
+	// Patterns of the namespaces whose metrics are exported first, empty to export batches whole.
+	priorityNamespaces []string
+	priorityBudget     time.Duration
+	// Lease through which redundant replicas claim the batches to export, nil if this replica
+	// exports all of them.
+	exportLease ExportLease
+	// Processor replacing the sensitive label values of the batches of the sinks of the given
+	// types, or of all sinks but the metric sink if none is given. Nil if labels are exported as
+	// they are.
+	labelScrubber      core.DataProcessor
+	labelScrubberSinks []string
+}
+
+// EnableJournal makes the sinks dropping duplicates journal their batches in a subdirectory
+// of dir, keeping at most maxBatches of them.
+func (this *SinkFactory) EnableJournal(dir string, maxBatches int) {
+	this.journalDir = dir
+	this.journalMaxBatches = maxBatches
+}
+
+// EnablePriorities makes the sinks export the metrics of nodes and of the namespaces matching
+// the patterns first, dropping the remaining metrics of a batch once its export took longer
+// than budget. The metric sink and journaled sinks always get whole batches.
+func (this *SinkFactory) EnablePriorities(namespaces []string, budget time.Duration) {
+	this.priorityNamespaces = namespaces
+	this.priorityBudget = budget
+}
+
+// EnableExportLease makes the sinks export only the batches claimed by this replica in the
+// lease. The metric sink always gets all batches.
+func (this *SinkFactory) EnableExportLease(lease ExportLease) {
+	this.exportLease = lease
+}
+
+// EnableLabelScrubbing makes the sinks of the given types, e.g. stackdriver, or all sinks if none
+// is given, export the batches processed by the scrubber. The metric sink always gets the original
+// batches.
+func (this *SinkFactory) EnableLabelScrubbing(scrubber core.DataProcessor, sinkTypes []string) {
+	this.labelScrubber = scrubber
+	this.labelScrubberSinks = sinkTypes
+}
+
+// scrubsLabels returns true if the labels of the batches exported to the sink of the given type
+// are scrubbed.
+func (this *SinkFactory) scrubsLabels(sinkType string) bool {
+	if this.labelScrubber == nil || sinkType == "metric" {
+		return false
+	}
+	if len(this.labelScrubberSinks) == 0 {
+		return true
+	}
+	for _, scrubbed := range this.labelScrubberSinks {
+		if scrubbed == sinkType {
+			return true
+		}
+	}
+	return false
 }
 
 func (this *SinkFactory) Build(uri flags.Uri) (core.DataSink, error) {
 	switch uri.Key {
+	case "archive":
+		return archive.NewArchiveSink(&uri.Val)
+	case "azure_monitor":
+		return azuremonitor.NewAzureMonitorSink(&uri.Val)
+	case "bigquery":
+		return bigquery.NewBigquerySink(&uri.Val)
+	case "circonus":
+		return circonus.NewCirconusSink(&uri.Val)
+	case "clickhouse":
+		return clickhouse.NewClickhouseSink(&uri.Val)
 	case "elasticsearch":
 		return elasticsearch.NewElasticSearchSink(&uri.Val)
+	case "file":
+		return file.NewFileSink(&uri.Val)
 	case "gcm":
 		return gcm.CreateGCMSink(&uri.Val)
 	case "stackdriver":
-		return stackdriver.CreateStackdriverSink(&uri.Val)
+		return stackdriver.CreateStackdriverSink(&uri.Val, this.kubernetesUrl)
 	case "statsd":
 		return statsd.NewStatsdSink(&uri.Val)
 	case "graphite":
@@ -57,22 +151,42 @@ func (this *SinkFactory) Build(uri flags.Uri) (core.DataSink, error) {
 		return hawkular.NewHawkularSink(&uri.Val)
 	case "influxdb":
 		return influxdb.CreateInfluxdbSink(&uri.Val)
+	case "irondb":
+		return circonus.NewIrondbSink(&uri.Val)
 	case "kafka":
 		return kafka.NewKafkaSink(&uri.Val)
 	case "librato":
 		return librato.CreateLibratoSink(&uri.Val)
+	case "lineprotocol":
+		return lineprotocol.NewLineProtocolSink(&uri.Val)
 	case "log":
 		return logsink.NewLogSink(), nil
 	case "metric":
 		return metricsink.NewMetricSink(140*time.Second, 15*time.Minute, []string{
 			core.MetricCpuUsageRate.MetricDescriptor.Name,
 			core.MetricMemoryUsage.MetricDescriptor.Name}), nil
+	case "mqtt":
+		return mqtt.NewMqttSink(&uri.Val)
 	case "opentsdb":
 		return opentsdb.CreateOpenTSDBSink(&uri.Val)
+	case "victoriametrics":
+		return victoriametrics.NewVictoriaMetricsSink(&uri.Val)
+	case "warp10":
+		return warp10.NewWarp10Sink(&uri.Val)
 	case "wavefront":
 		return wavefront.NewWavefrontSink(&uri.Val)
+	case "pushgateway":
+		return pushgateway.NewPushgatewaySink(&uri.Val)
+	case "remote_write":
+		return remotewrite.NewRemoteWriteSink(&uri.Val)
 	case "riemann":
 		return riemann.CreateRiemannSink(&uri.Val)
+	case "signalfx":
+		return signalfx.NewSignalFxSink(&uri.Val)
+	case "splunk":
+		return splunk.NewSplunkSink(&uri.Val)
+	case "syslog":
+		return syslog.NewSyslogSink(&uri.Val)
 	case "honeycomb":
 		return honeycomb.NewHoneycombSink(&uri.Val)
 	default:
@@ -80,16 +194,32 @@ func (this *SinkFactory) Build(uri flags.Uri) (core.DataSink, error) {
 	}
 }
 
-func (this *SinkFactory) BuildAll(uris flags.Uris, historicalUri string, disableMetricSink bool) (*metricsink.MetricSink, []core.DataSink, core.HistoricalSource) {
+// BuildAll creates the sinks of the uris. Sinks which fail to be created are skipped, an error is
+// returned only if none of them could be created.
+func (this *SinkFactory) BuildAll(uris flags.Uris, historicalUri string, disableMetricSink bool) (*metricsink.MetricSink, []core.DataSink, core.HistoricalSource, error) {
 	result := make([]core.DataSink, 0, len(uris))
 	var metric *metricsink.MetricSink
 	var historical core.HistoricalSource
+	// Reported when no sink could be created.
+	var lastErr error
 	for _, uri := range uris {
-		sink, err := this.Build(uri)
+		sinkUri, pipeline := splitPipeline(uri)
+		filter, err := NewPipelineFilter(pipeline)
+		if err == nil && filter != nil && uri.Key == "metric" {
+			err = fmt.Errorf("the metric sink gets all metrics")
+		}
 		if err != nil {
 			glog.Errorf("Failed to create %v sink: %v", uri, err)
+			lastErr = fmt.Errorf("failed to create %v sink: %v", uri, err)
 			continue
 		}
+		sink, err := this.Build(sinkUri)
+		if err != nil {
+			glog.Errorf("Failed to create %v sink: %v", uri, err)
+			lastErr = fmt.Errorf("failed to create %v sink: %v", uri, err)
+			continue
+		}
+		built := sink
 		if uri.Key == "metric" {
 			metric = sink.(*metricsink.MetricSink)
 		}
@@ -100,11 +230,38 @@ func (this *SinkFactory) BuildAll(uris flags.Uris, historicalUri string, disable
 				glog.Errorf("Sink type %q does not support being used for historical access", uri.Key)
 			}
 		}
+		if deduplicating, ok := sink.(core.DeduplicatingDataSink); ok && len(this.journalDir) > 0 {
+			journaled, err := NewJournaledSink(deduplicating, this.journalPath(uri), this.journalMaxBatches)
+			if err != nil {
+				glog.Errorf("Failed to journal %v sink, exporting without journal: %v", uri, err)
+			} else {
+				sink = journaled
+			}
+		} else if uri.Key != "metric" && len(this.priorityNamespaces) > 0 {
+			prioritized, err := NewPrioritizedSink(sink, this.priorityNamespaces, this.priorityBudget)
+			if err != nil {
+				glog.Errorf("Failed to prioritize the export to %v sink, exporting whole batches: %v", uri, err)
+			} else {
+				sink = prioritized
+			}
+		}
+		if this.scrubsLabels(uri.Key) {
+			sink = NewProcessedSink(sink, this.labelScrubber)
+		}
+		if filter != nil {
+			sink = NewProcessedSink(sink, filter)
+		}
+		if !acceptsPartialBatches(built) {
+			sink = NewWholeBatchSink(sink)
+		}
+		if uri.Key != "metric" && this.exportLease != nil {
+			sink = NewLeasedSink(sink, this.exportLease)
+		}
 		result = append(result, sink)
 	}
 
 	if len([]flags.Uri(uris)) != 0 && len(result) == 0 {
-		glog.Fatal("No available sink to use")
+		return nil, nil, nil, fmt.Errorf("no available sink to use, %v", lastErr)
 	}
 
 	if metric == nil && !disableMetricSink {
@@ -121,9 +278,15 @@ func (this *SinkFactory) BuildAll(uris flags.Uris, historicalUri string, disable
 	if len(historicalUri) > 0 && historical == nil {
 		glog.Errorf("Error while initializing historical access: unable to use sink %q as a historical source", historicalUri)
 	}
-	return metric, result, historical
+	return metric, result, historical, nil
+}
+
+// journalPath returns the journal directory of the sink, distinct for sinks of the same type.
+func (this *SinkFactory) journalPath(uri flags.Uri) string {
+	sum := sha1.Sum([]byte(uri.String()))
+	return filepath.Join(this.journalDir, fmt.Sprintf("%s-%x", uri.Key, sum[:4]))
 }
 
-func NewSinkFactory() *SinkFactory {
-	return &SinkFactory{}
+func NewSinkFactory(kubernetesUrl *url.URL) *SinkFactory {
+	return &SinkFactory{kubernetesUrl: kubernetesUrl}
 }
@@ -0,0 +1,391 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file implements a sink writing the metrics to a local file, as JSON lines or CSV,
+// rotated by size and age, e.g. for air-gapped clusters whose metrics are shipped offline.
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	formatJsonl = "jsonl"
+	formatCsv   = "csv"
+
+	defaultMaxSize = 100 * 1024 * 1024
+	// The time of the rotation in the names of the rotated files, which sort chronologically.
+	rotationTimeFormat = "2006-01-02T15-04-05.000"
+	timestampFormat    = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// The columns of the CSV files.
+var csvHeader = []string{"timestamp", "key", "metric", "metric_type", "value", "labels"}
+
+type fileSink struct {
+	sync.Mutex
+	path   string
+	format string
+	// The file is rotated when it exceeds maxSize bytes, or when it is older than maxAge if
+	// positive.
+	maxSize int64
+	maxAge  time.Duration
+	// Number of rotated files kept, all if zero.
+	maxFiles int
+	// Whether the rotated files are compressed with gzip.
+	compress bool
+
+	file   *os.File
+	size   int64
+	opened time.Time
+	// For tests.
+	now func() time.Time
+}
+
+func (this *fileSink) Name() string {
+	return "File Sink"
+}
+
+// The values of partial batches are appended like those of the whole batches.
+func (this *fileSink) AcceptsPartialBatches() bool {
+	return true
+}
+
+func (this *fileSink) Stop() {
+	this.Lock()
+	defer this.Unlock()
+	if this.file != nil {
+		this.file.Close()
+		this.file = nil
+	}
+}
+
+func (this *fileSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	now := this.now()
+	// The parts of a batch, see core.BatchPart, are written to the same file.
+	firstPart := batch.Part == nil || batch.Part.Index == 0
+	if this.file != nil && firstPart && (this.size >= this.maxSize || (this.maxAge > 0 && now.Sub(this.opened) >= this.maxAge)) {
+		if err := this.rotate(now); err != nil {
+			glog.Errorf("[batch %s] Failed to rotate %s: %v", batch.ID, this.path, err)
+		}
+	}
+	if this.file == nil {
+		if err := this.open(now); err != nil {
+			glog.Errorf("[batch %s] Failed to open %s: %v", batch.ID, this.path, err)
+			return
+		}
+	}
+
+	counter := &countingWriter{writer: this.file}
+	buffer := bufio.NewWriter(counter)
+	var err error
+	if this.format == formatCsv {
+		err = this.writeCsv(buffer, batch)
+	} else {
+		err = this.writeJsonl(buffer, batch)
+	}
+	if err == nil {
+		err = buffer.Flush()
+	}
+	this.size += counter.count
+	if err != nil {
+		glog.Errorf("[batch %s] Failed to write to %s: %v", batch.ID, this.path, err)
+	}
+}
+
+// countingWriter counts the bytes written to the file.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (this *countingWriter) Write(data []byte) (int, error) {
+	n, err := this.writer.Write(data)
+	this.count += int64(n)
+	return n, err
+}
+
+// open opens the file for appending, so that the values written before a restart are kept.
+func (this *fileSink) open(now time.Time) error {
+	file, err := os.OpenFile(this.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	this.file = file
+	this.size = info.Size()
+	this.opened = now
+	if this.format == formatCsv && this.size == 0 {
+		counter := &countingWriter{writer: file}
+		writer := csv.NewWriter(counter)
+		writer.Write(csvHeader)
+		writer.Flush()
+		this.size += counter.count
+		return writer.Error()
+	}
+	return nil
+}
+
+// rotate renames the file after the time of the rotation, e.g. metrics-2017-07-14T02-40-00.000.jsonl,
+// compresses it if configured and removes the oldest rotated files. The file is opened again by
+// the next export.
+func (this *fileSink) rotate(now time.Time) error {
+	if err := this.file.Close(); err != nil {
+		glog.Warningf("Failed to close %s: %v", this.path, err)
+	}
+	this.file = nil
+	base, extension := this.splitPath()
+	rotated := base + "-" + now.UTC().Format(rotationTimeFormat) + extension
+	if err := os.Rename(this.path, rotated); err != nil {
+		return err
+	}
+	glog.V(2).Infof("Rotated %s to %s", this.path, rotated)
+	if this.compress {
+		if err := compressFile(rotated); err != nil {
+			return fmt.Errorf("failed to compress %s: %v", rotated, err)
+		}
+	}
+	return this.removeOldFiles()
+}
+
+// splitPath returns the path without its extension, and the extension.
+func (this *fileSink) splitPath() (string, string) {
+	extension := filepath.Ext(this.path)
+	return strings.TrimSuffix(this.path, extension), extension
+}
+
+// compressFile replaces the file with a gzip compressed file of the same name with a .gz suffix.
+func compressFile(name string) error {
+	source, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(target)
+	if _, err := io.Copy(writer, source); err != nil {
+		target.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		target.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := target.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// removeOldFiles removes the oldest rotated files beyond maxFiles.
+func (this *fileSink) removeOldFiles() error {
+	if this.maxFiles == 0 {
+		return nil
+	}
+	base, extension := this.splitPath()
+	names, err := filepath.Glob(base + "-*" + extension + "*")
+	if err != nil {
+		return err
+	}
+	rotated := []string{}
+	for _, name := range names {
+		suffix := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), extension)
+		if _, err := time.Parse(rotationTimeFormat, strings.TrimPrefix(suffix, base+"-")); err == nil {
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > this.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		glog.V(2).Infof("Removed %s", rotated[0])
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// forEachValue calls write with the values of the batch, with the labels of the metric set and
+// of the metric without the empty ones. NaN and infinite values are skipped.
+func forEachValue(batch *core.DataBatch, write func(timestamp time.Time, key, name string, value core.MetricValue, number float64, labels map[string]string) error) error {
+	keys := make([]string, 0, len(batch.MetricSets))
+	for key := range batch.MetricSets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		metricSet := batch.MetricSets[key]
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		names := make([]string, 0, len(metricSet.MetricValues))
+		for name := range metricSet.MetricValues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make([]core.LabeledMetric, 0, len(names)+len(metricSet.LabeledMetrics))
+		for _, name := range names {
+			values = append(values, core.LabeledMetric{Name: name, MetricValue: metricSet.MetricValues[name]})
+		}
+		values = append(values, metricSet.LabeledMetrics...)
+		for _, metric := range values {
+			number := float64(metric.IntValue)
+			if metric.ValueType == core.ValueFloat {
+				number = float64(metric.FloatValue)
+			}
+			if math.IsNaN(number) || math.IsInf(number, 0) {
+				continue
+			}
+			labels := map[string]string{}
+			for _, source := range []map[string]string{metricSet.Labels, metric.Labels} {
+				for k, v := range source {
+					if v != "" {
+						labels[k] = v
+					}
+				}
+			}
+			if err := write(timestamp, key, metric.Name, metric.MetricValue, number, labels); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeJsonl writes a JSON object per value.
+func (this *fileSink) writeJsonl(writer io.Writer, batch *core.DataBatch) error {
+	encoder := json.NewEncoder(writer)
+	return forEachValue(batch, func(timestamp time.Time, key, name string, value core.MetricValue, number float64, labels map[string]string) error {
+		return encoder.Encode(struct {
+			Timestamp  string            `json:"timestamp"`
+			Key        string            `json:"key"`
+			Metric     string            `json:"metric"`
+			MetricType string            `json:"metric_type"`
+			Value      float64           `json:"value"`
+			Labels     map[string]string `json:"labels"`
+		}{
+			Timestamp:  timestamp.UTC().Format(timestampFormat),
+			Key:        key,
+			Metric:     name,
+			MetricType: value.MetricType.String(),
+			Value:      number,
+			Labels:     labels,
+		})
+	})
+}
+
+// writeCsv writes a record per value, the labels being a JSON object.
+func (this *fileSink) writeCsv(writer io.Writer, batch *core.DataBatch) error {
+	records := csv.NewWriter(writer)
+	err := forEachValue(batch, func(timestamp time.Time, key, name string, value core.MetricValue, number float64, labels map[string]string) error {
+		encodedLabels, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		return records.Write([]string{
+			timestamp.UTC().Format(timestampFormat),
+			key,
+			name,
+			value.MetricType.String(),
+			strconv.FormatFloat(number, 'g', -1, 64),
+			string(encodedLabels),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	records.Flush()
+	return records.Error()
+}
+
+// NewFileSink creates a sink for uris of the form /var/lib/heapster/metrics.jsonl, with the
+// format, maxSize, maxAge, maxFiles and compress options. The directory is created if needed.
+func NewFileSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	sink := &fileSink{
+		path:    uri.Path,
+		format:  formatJsonl,
+		maxSize: defaultMaxSize,
+		now:     time.Now,
+	}
+	if sink.path == "" {
+		return nil, fmt.Errorf("the path of the file is required, e.g. file:/var/lib/heapster/metrics.jsonl")
+	}
+	if value := opts.Get("format"); value != "" {
+		sink.format = value
+	}
+	if sink.format != formatJsonl && sink.format != formatCsv {
+		return nil, fmt.Errorf("invalid format %q, expected jsonl or csv", sink.format)
+	}
+	if value := opts.Get("maxSize"); value != "" {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil || quantity.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid maxSize %q, expected a size such as 100Mi", value)
+		}
+		sink.maxSize = quantity.Value()
+	}
+	var err error
+	if value := opts.Get("maxAge"); value != "" {
+		if sink.maxAge, err = time.ParseDuration(value); err != nil || sink.maxAge < 0 {
+			return nil, fmt.Errorf("invalid maxAge %q", value)
+		}
+	}
+	if value := opts.Get("maxFiles"); value != "" {
+		if sink.maxFiles, err = strconv.Atoi(value); err != nil || sink.maxFiles < 0 {
+			return nil, fmt.Errorf("invalid maxFiles option %q", value)
+		}
+	}
+	if value := opts.Get("compress"); value != "" {
+		if sink.compress, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid compress option %q", value)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(sink.path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the directory of %s: %v", sink.path, err)
+	}
+	glog.Infof("Created file sink writing %s to %s", sink.format, sink.path)
+	return sink, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+)
+
+func newTestSink(t *testing.T, dir, options string) (*fileSink, *time.Time) {
+	uri, err := url.Parse(filepath.Join(dir, "metrics", "metrics.jsonl") + "?" + options)
+	require.NoError(t, err)
+	sink, err := NewFileSink(uri)
+	require.NoError(t, err)
+	now := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	sink.(*fileSink).now = func() time.Time { return now }
+	return sink.(*fileSink), &now
+}
+
+func TestWriteJsonl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heapster-file-sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sink, _ := newTestSink(t, dir, "")
+	sink.ExportData(sinktest.Batch())
+	sink.Stop()
+
+	contents, err := ioutil.ReadFile(sink.path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	// The metric sets are written in the order of their keys.
+	require.Equal(t, 4, len(lines))
+	row := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &row))
+	assert.Equal(t, core.PodKey("default", "web-1"), row["key"])
+	row = map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &row))
+	assert.Equal(t, map[string]interface{}{
+		"timestamp":   "2017-07-14T02:40:00.000Z",
+		"key":         "node:node-1",
+		"metric":      "memory/usage",
+		"metric_type": "gauge",
+		"value":       1024.0,
+		"labels":      map[string]interface{}{"type": "node", "nodename": "node-1"},
+	}, row)
+	require.NoError(t, json.Unmarshal([]byte(lines[3]), &row))
+	assert.Equal(t, "filesystem/usage", row["metric"])
+	assert.Equal(t, map[string]interface{}{"type": "node", "nodename": "node-1", "resource_id": "/dev/sda1"}, row["labels"])
+
+	// The file is appended to after a restart.
+	sink, _ = newTestSink(t, dir, "")
+	sink.ExportData(sinktest.Batch())
+	sink.Stop()
+	contents, err = ioutil.ReadFile(sink.path)
+	require.NoError(t, err)
+	assert.Equal(t, 8, strings.Count(string(contents), "\n"))
+}
+
+func TestWriteCsv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heapster-file-sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sink, _ := newTestSink(t, dir, "format=csv")
+	sink.ExportData(sinktest.Batch())
+	sink.ExportData(sinktest.Batch())
+	sink.Stop()
+
+	file, err := os.Open(sink.path)
+	require.NoError(t, err)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	// The header is written once.
+	require.Equal(t, 9, len(records))
+	assert.Equal(t, csvHeader, records[0])
+	assert.Equal(t, []string{"2017-07-14T02:40:00.000Z", "namespace:default/pod:web-1", "memory/usage", "gauge", "100",
+		`{"namespace_name":"default","pod_name":"web-1","type":"pod"}`}, records[1])
+	assert.Equal(t, []string{"2017-07-14T02:40:00.000Z", "node:node-1", "memory/usage", "gauge", "1024", `{"nodename":"node-1","type":"node"}`}, records[3])
+	assert.Equal(t, []string{"2017-07-14T02:40:00.000Z", "node:node-1", "filesystem/usage", "gauge", "0.5", `{"nodename":"node-1","resource_id":"/dev/sda1","type":"node"}`}, records[4])
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heapster-file-sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sink, now := newTestSink(t, dir, "maxSize=100&maxAge=1h&maxFiles=2&compress=true")
+
+	// The file is rotated by the export following the one which exceeded the size.
+	sink.ExportData(sinktest.Batch())
+	*now = now.Add(time.Minute)
+	sink.ExportData(sinktest.Batch())
+	*now = now.Add(time.Minute)
+	sink.ExportData(sinktest.Batch())
+	assert.Equal(t, []string{"metrics-2017-07-14T02-41-00.000.jsonl.gz", "metrics-2017-07-14T02-42-00.000.jsonl.gz", "metrics.jsonl"},
+		listFiles(t, filepath.Dir(sink.path)))
+
+	compressed, err := ioutil.ReadFile(filepath.Join(filepath.Dir(sink.path), "metrics-2017-07-14T02-41-00.000.jsonl.gz"))
+	require.NoError(t, err)
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	contents, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 4, strings.Count(string(contents), "\n"))
+
+	// The parts of a batch following the first one are written to the same file.
+	part := sinktest.Batch()
+	part.Part = &core.BatchPart{Index: 1, Last: true}
+	*now = now.Add(time.Minute)
+	sink.ExportData(part)
+	assert.Equal(t, []string{"metrics-2017-07-14T02-41-00.000.jsonl.gz", "metrics-2017-07-14T02-42-00.000.jsonl.gz", "metrics.jsonl"},
+		listFiles(t, filepath.Dir(sink.path)))
+
+	// Only maxFiles rotated files are kept. The file is also rotated once older than maxAge.
+	sink.maxSize = defaultMaxSize
+	*now = now.Add(time.Hour)
+	sink.ExportData(sinktest.Batch())
+	sink.Stop()
+	assert.Equal(t, []string{"metrics-2017-07-14T02-42-00.000.jsonl.gz", "metrics-2017-07-14T03-43-00.000.jsonl.gz", "metrics.jsonl"},
+		listFiles(t, filepath.Dir(sink.path)))
+}
+
+func TestOptions(t *testing.T) {
+	for uri, valid := range map[string]bool{
+		"/tmp/metrics.jsonl": true,
+		"/tmp/metrics.csv?format=csv&maxSize=1Gi&maxAge=24h": true,
+		"/tmp/metrics.jsonl?maxFiles=10&compress=true":       true,
+		"?format=csv":                       false,
+		"/tmp/metrics.xml?format=xml":       false,
+		"/tmp/metrics.jsonl?maxSize=big":    false,
+		"/tmp/metrics.jsonl?maxSize=0":      false,
+		"/tmp/metrics.jsonl?maxFiles=-1":    false,
+		"/tmp/metrics.jsonl?compress=maybe": false,
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewFileSink(parsed)
+		assert.Equal(t, valid, err == nil, uri)
+	}
+}
@@ -162,10 +162,10 @@ func fullProjectName(name string) string {
 	return fmt.Sprintf("projects/%s", name)
 }
 
-func (sink *gcmSink) sendRequest(req *gcm.CreateTimeSeriesRequest) {
+func (sink *gcmSink) sendRequest(dataBatch *core.DataBatch, req *gcm.CreateTimeSeriesRequest) {
 	_, err := sink.gcmService.Projects.TimeSeries.Create(fullProjectName(sink.project), req).Do()
 	if err != nil {
-		glog.Errorf("Error while sending request to GCM %v", err)
+		glog.Errorf("[batch %s] Error while sending request to GCM %v", dataBatch.ID, err)
 	} else {
 		glog.V(4).Infof("Successfully sent %v timeserieses to GCM", len(req.TimeSeries))
 	}
@@ -173,7 +173,7 @@ func (sink *gcmSink) sendRequest(req *gcm.CreateTimeSeriesRequest) {
 
 func (sink *gcmSink) ExportData(dataBatch *core.DataBatch) {
 	if err := sink.registerAllMetrics(); err != nil {
-		glog.Warningf("Error during metrics registration: %v", err)
+		glog.Warningf("[batch %s] Error during metrics registration: %v", dataBatch.ID, err)
 		return
 	}
 
@@ -185,7 +185,7 @@ func (sink *gcmSink) ExportData(dataBatch *core.DataBatch) {
 				req.TimeSeries = append(req.TimeSeries, point)
 			}
 			if len(req.TimeSeries) >= maxTimeseriesPerRequest {
-				sink.sendRequest(req)
+				sink.sendRequest(dataBatch, req)
 				req = getReq()
 			}
 		}
@@ -195,13 +195,13 @@ func (sink *gcmSink) ExportData(dataBatch *core.DataBatch) {
 				req.TimeSeries = append(req.TimeSeries, point)
 			}
 			if len(req.TimeSeries) >= maxTimeseriesPerRequest {
-				sink.sendRequest(req)
+				sink.sendRequest(dataBatch, req)
 				req = getReq()
 			}
 		}
 	}
 	if len(req.TimeSeries) > 0 {
-		sink.sendRequest(req)
+		sink.sendRequest(dataBatch, req)
 	}
 }
 
@@ -285,6 +285,7 @@ func (sink *gcmSink) register(metrics []core.Metric) error {
 			MetricKind:  metricKind,
 			ValueType:   valueType,
 			Type:        metricType,
+			Unit:        metricUnit(metric.MetricDescriptor.Units),
 		}
 
 		if _, err := sink.gcmService.Projects.MetricDescriptors.Create(fullProjectName(sink.project), desc).Do(); err != nil {
@@ -296,6 +297,22 @@ func (sink *gcmSink) register(metrics []core.Metric) error {
 	return nil
 }
 
+// metricUnit returns the unit of the metric descriptors, in the subset of the Unified Code for
+// Units of Measure supported by Stackdriver.
+func metricUnit(units core.UnitsType) string {
+	switch units {
+	case core.UnitsBytes:
+		return "By"
+	case core.UnitsMilliseconds:
+		return "ms"
+	case core.UnitsNanoseconds:
+		return "ns"
+	case core.UnitsMillicores:
+		return "{millicores}"
+	}
+	return "1"
+}
+
 func CreateGCMSink(uri *url.URL) (core.DataSink, error) {
 	if len(uri.Scheme) > 0 {
 		return nil, fmt.Errorf("scheme should not be set for GCM sink")
@@ -331,7 +331,7 @@ func toBatches(m []metrics.MetricHeader, batchSize int) chan []metrics.MetricHea
 	return c
 }
 
-func (h *hawkularSink) sendData(tmhs map[string][]metrics.MetricHeader, wg *sync.WaitGroup) {
+func (h *hawkularSink) sendData(db *core.DataBatch, tmhs map[string][]metrics.MetricHeader, wg *sync.WaitGroup) {
 	for k, v := range tmhs {
 		parts := toBatches(v, h.batchSize)
 		close(parts)
@@ -345,7 +345,7 @@ func (h *hawkularSink) sendData(tmhs map[string][]metrics.MetricHeader, wg *sync
 				copy(m, h.modifiers)
 				m = append(m, metrics.Tenant(tenant))
 				if err := h.client.Write(batch, m...); err != nil {
-					glog.Errorf(err.Error())
+					glog.Errorf("[batch %s] %v", db.ID, err)
 				}
 			}(p, k)
 		}
@@ -19,7 +19,6 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"net/url"
 	"strconv"
 	"sync"
@@ -29,6 +28,7 @@ import (
 
 	kube_client "k8s.io/client-go/rest"
 	kubeClientCmd "k8s.io/client-go/tools/clientcmd"
+	"k8s.io/heapster/common/credentials"
 	"k8s.io/heapster/metrics/core"
 )
 
@@ -122,7 +122,7 @@ func (h *hawkularSink) ExportData(db *core.DataBatch) {
 				mH, err := h.pointToLabeledMetricHeader(ms, labeledMetric, db.Timestamp)
 				if err != nil {
 					// One transformation error should not prevent the whole process
-					glog.Errorf(err.Error())
+					glog.Errorf("[batch %s] %v", db.ID, err)
 					continue
 				}
 
@@ -133,7 +133,7 @@ func (h *hawkularSink) ExportData(db *core.DataBatch) {
 				tmhs[tenant] = append(tmhs[tenant], *mH)
 			}
 		}
-		h.sendData(tmhs, wg) // Send to a limited channel? Only batches.. egg.
+		h.sendData(db, tmhs, wg) // Send to a limited channel? Only batches.. egg.
 		wg.Wait()
 		// glog.V(4).Infof("ExportData updated %d tags, total size of cached tags is %d\n", updatedTags, len(h.reg))
 	}
@@ -258,16 +258,15 @@ func (h *hawkularSink) init() error {
 		}
 	}
 
-	if u, found := opts["user"]; found {
+	creds, err := credentials.New(opts, "user", "pass")
+	if err != nil {
+		return err
+	}
+	if creds.IsSet() {
 		if _, wrong := opts["useServiceAccount"]; wrong {
-			return fmt.Errorf("If user and password are used, serviceAccount cannot be used")
-		}
-		if p, f := opts["pass"]; f {
-			h.modifiers = append(h.modifiers, func(req *http.Request) error {
-				req.SetBasicAuth(u[0], p[0])
-				return nil
-			})
+			return fmt.Errorf("If user and password or a token are used, serviceAccount cannot be used")
 		}
+		h.modifiers = append(h.modifiers, creds.Authorize)
 	}
 
 	if v, found := opts["caCert"]; found {
@@ -73,7 +73,7 @@ func (sink *honeycombSink) ExportData(dataBatch *core.DataBatch) {
 	}
 	err := sink.client.SendBatch(batch)
 	if err != nil {
-		glog.Warningf("Failed to send metrics batch: %v", err)
+		glog.Warningf("[batch %s] Failed to send metrics batch: %v", dataBatch.ID, err)
 	}
 }
 
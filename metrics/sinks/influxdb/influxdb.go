@@ -16,13 +16,16 @@ package influxdb
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	influxdb_common "k8s.io/heapster/common/influxdb"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
 
 	"github.com/golang/glog"
 	influxdb "github.com/influxdata/influxdb/client"
@@ -63,9 +66,18 @@ func (sink *influxdbSink) resetConnection() {
 }
 
 func (sink *influxdbSink) ExportData(dataBatch *core.DataBatch) {
+	// Failures are logged already.
+	sink.ExportDataWithAck(dataBatch)
+}
+
+// ExportDataWithAck exports the batch and returns an error if any of its points could not be
+// written. Points are identified by their series and timestamp, so writing a batch again
+// overwrites the points written before.
+func (sink *influxdbSink) ExportDataWithAck(dataBatch *core.DataBatch) error {
 	sink.Lock()
 	defer sink.Unlock()
 
+	var failed int32
 	dataPoints := make([]influxdb.Point, 0, 0)
 	for _, metricSet := range dataBatch.MetricSets {
 		for metricName, metricValue := range metricSet.MetricValues {
@@ -84,17 +96,7 @@ func (sink *influxdbSink) ExportData(dataBatch *core.DataBatch) {
 				continue
 			}
 
-			// Prepare measurement without fields
-			fieldName := "value"
-			measurementName := metricName
-			if sink.c.WithFields {
-				// Prepare measurement and field names
-				serieName := strings.SplitN(metricName, "/", 2)
-				measurementName = serieName[0]
-				if len(serieName) > 1 {
-					fieldName = serieName[1]
-				}
-			}
+			measurementName, fieldName := MeasurementAndField(metricName, sink.c.WithFields)
 
 			point := influxdb.Point{
 				Measurement: measurementName,
@@ -112,11 +114,14 @@ func (sink *influxdbSink) ExportData(dataBatch *core.DataBatch) {
 				}
 			}
 
-			point.Tags["cluster_name"] = sink.c.ClusterName
+			// Metrics scraped from a federated Heapster keep the name of their member cluster.
+			if _, found := point.Tags[core.LabelClusterName.Key]; !found {
+				point.Tags[core.LabelClusterName.Key] = sink.c.ClusterName
+			}
 
 			dataPoints = append(dataPoints, point)
 			if len(dataPoints) >= maxSendBatchSize {
-				sink.concurrentSendData(dataPoints)
+				sink.concurrentSendData(dataBatch, dataPoints, &failed)
 				dataPoints = make([]influxdb.Point, 0, 0)
 			}
 		}
@@ -137,17 +142,7 @@ func (sink *influxdbSink) ExportData(dataBatch *core.DataBatch) {
 				continue
 			}
 
-			// Prepare measurement without fields
-			fieldName := "value"
-			measurementName := labeledMetric.Name
-			if sink.c.WithFields {
-				// Prepare measurement and field names
-				serieName := strings.SplitN(labeledMetric.Name, "/", 2)
-				measurementName = serieName[0]
-				if len(serieName) > 1 {
-					fieldName = serieName[1]
-				}
-			}
+			measurementName, fieldName := MeasurementAndField(labeledMetric.Name, sink.c.WithFields)
 
 			point := influxdb.Point{
 				Measurement: measurementName,
@@ -172,32 +167,55 @@ func (sink *influxdbSink) ExportData(dataBatch *core.DataBatch) {
 					}
 				}
 			}
-			point.Tags["cluster_name"] = sink.c.ClusterName
+			// Metrics scraped from a federated Heapster keep the name of their member cluster.
+			if _, found := point.Tags[core.LabelClusterName.Key]; !found {
+				point.Tags[core.LabelClusterName.Key] = sink.c.ClusterName
+			}
 
 			dataPoints = append(dataPoints, point)
 			if len(dataPoints) >= maxSendBatchSize {
-				sink.concurrentSendData(dataPoints)
+				sink.concurrentSendData(dataBatch, dataPoints, &failed)
 				dataPoints = make([]influxdb.Point, 0, 0)
 			}
 		}
 	}
 	if len(dataPoints) > 0 {
-		sink.concurrentSendData(dataPoints)
+		sink.concurrentSendData(dataBatch, dataPoints, &failed)
 	}
 
 	sink.wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d writes of batch %s failed", failed, dataBatch.ID)
+	}
+	return nil
+}
+
+// MeasurementAndField returns the measurement and the field the metric is written to: the
+// metric name and the value field, or with fields, e.g. "cpu" and "usage_rate" for cpu/usage_rate.
+func MeasurementAndField(metricName string, withFields bool) (string, string) {
+	if withFields {
+		serieName := strings.SplitN(metricName, "/", 2)
+		if len(serieName) > 1 {
+			return serieName[0], serieName[1]
+		}
+		return serieName[0], valueField
+	}
+	return metricName, valueField
 }
 
-func (sink *influxdbSink) concurrentSendData(dataPoints []influxdb.Point) {
+// concurrentSendData sends the points of the batch in the background and increments failed if this fails.
+func (sink *influxdbSink) concurrentSendData(batch *core.DataBatch, dataPoints []influxdb.Point, failed *int32) {
 	sink.wg.Add(1)
 	// use the channel to block until there's less than the maximum number of concurrent requests running
 	sink.conChan <- struct{}{}
 	go func(dataPoints []influxdb.Point) {
-		sink.sendData(dataPoints)
+		if !sink.sendData(batch, dataPoints) {
+			atomic.AddInt32(failed, 1)
+		}
 	}(dataPoints)
 }
 
-func (sink *influxdbSink) sendData(dataPoints []influxdb.Point) {
+func (sink *influxdbSink) sendData(batch *core.DataBatch, dataPoints []influxdb.Point) bool {
 	defer func() {
 		// empty an item from the channel so the next waiting request can run
 		<-sink.conChan
@@ -205,8 +223,8 @@ func (sink *influxdbSink) sendData(dataPoints []influxdb.Point) {
 	}()
 
 	if err := sink.createDatabase(); err != nil {
-		glog.Errorf("Failed to create influxdb: %v", err)
-		return
+		glog.Errorf("[batch %s] Failed to create influxdb: %v", batch.ID, err)
+		return false
 	}
 	bp := influxdb.BatchPoints{
 		Points:          dataPoints,
@@ -214,25 +232,34 @@ func (sink *influxdbSink) sendData(dataPoints []influxdb.Point) {
 		RetentionPolicy: "default",
 	}
 
+	header := http.Header{}
+	util.SetBatchHeaders(header, batch)
+
 	start := time.Now()
-	if _, err := sink.client.Write(bp); err != nil {
-		glog.Errorf("InfluxDB write failed: %v", err)
+	if _, err := influxdb_common.WriteWithHeaders(sink.client, bp, header); err != nil {
+		glog.Errorf("[batch %s] InfluxDB write failed: %v", batch.ID, err)
 		if strings.Contains(err.Error(), dbNotFoundError) {
 			sink.resetConnection()
 		} else if _, _, err := sink.client.Ping(); err != nil {
-			glog.Errorf("InfluxDB ping failed: %v", err)
+			glog.Errorf("[batch %s] InfluxDB ping failed: %v", batch.ID, err)
 			sink.resetConnection()
 		}
-		return
+		return false
 	}
 	end := time.Now()
-	glog.V(4).Infof("Exported %d data to influxDB in %s", len(dataPoints), end.Sub(start))
+	glog.V(4).Infof("[batch %s] Exported %d data to influxDB in %s", batch.ID, len(dataPoints), end.Sub(start))
+	return true
 }
 
 func (sink *influxdbSink) Name() string {
 	return "InfluxDB Sink"
 }
 
+// The points of partial batches add to those of the whole batches.
+func (sink *influxdbSink) AcceptsPartialBatches() bool {
+	return true
+}
+
 func (sink *influxdbSink) Stop() {
 	// nothing needs to be done.
 }
@@ -20,14 +20,17 @@ import (
 	"testing"
 	"time"
 
+	"net/http"
 	"net/http/httptest"
 	"net/url"
 
 	influx_models "github.com/influxdata/influxdb/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	util "k8s.io/client-go/util/testing"
 	influxdb_common "k8s.io/heapster/common/influxdb"
 	"k8s.io/heapster/metrics/core"
+	sink_util "k8s.io/heapster/metrics/sinks/util"
 )
 
 type fakeInfluxDBDataSink struct {
@@ -173,6 +176,42 @@ func TestCreateInfluxdbSink(t *testing.T) {
 	assert.Equal(t, sink.Name(), "InfluxDB Sink")
 }
 
+func TestWriteBatchID(t *testing.T) {
+	batchIDs := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			w.Write([]byte(`{"results": [{}]}`))
+		case "/write":
+			assert.Equal(t, "k8s", r.URL.Query().Get("db"))
+			batchIDs <- r.Header.Get(sink_util.BatchIDHeader)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	sink, err := CreateInfluxdbSink(uri)
+	require.NoError(t, err)
+	err = sink.(*influxdbSink).ExportDataWithAck(&core.DataBatch{
+		Timestamp: time.Now(),
+		ID:        "20170714T022640Z-00000001",
+		MetricSets: map[string]*core.MetricSet{
+			"node:node-1": {
+				Labels: map[string]string{core.LabelNodename.Key: "node-1"},
+				MetricValues: map[string]core.MetricValue{
+					"cpu/usage": {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 1},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "20170714T022640Z-00000001", <-batchIDs)
+}
+
 func makeRow(results [][]string) influx_models.Row {
 	resRow := influx_models.Row{
 		Values: make([][]interface{}, len(results)),
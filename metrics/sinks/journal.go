@@ -0,0 +1,270 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	journalEntrySuffix = ".batch"
+	// Delay before retrying the export of journaled batches after a failure.
+	journalRetryInterval = 10 * time.Second
+)
+
+var (
+	// Number of batches journaled but not yet acknowledged by the sink.
+	journalPendingBatches = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "exporter",
+			Name:      "journal_pending_batches",
+			Help:      "Number of batches journaled but not yet acknowledged by the sink.",
+		},
+		[]string{"exporter"},
+	)
+
+	// Number of journaled batches dropped because the journal was full.
+	journalDroppedBatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "exporter",
+			Name:      "journal_dropped_batches_total",
+			Help:      "Number of journaled batches dropped because the journal was full.",
+		},
+		[]string{"exporter"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(journalPendingBatches)
+	prometheus.MustRegister(journalDroppedBatches)
+}
+
+// journaledSink writes every batch to a journal on disk before exporting it, and removes it
+// from the journal only once the sink acknowledged it. Batches which failed, or were not
+// exported because Heapster was restarted, are exported again, oldest first, so the sink
+// has neither gaps nor, since it drops duplicates, double-counted points.
+//
+// Batches are exported in the background, so that the sink manager never drops a batch
+// because the sink is still busy retrying the previous ones.
+type journaledSink struct {
+	sink       core.DeduplicatingDataSink
+	dir        string
+	maxEntries int
+
+	flushChannel chan struct{}
+	stopChannel  chan struct{}
+	doneChannel  chan struct{}
+}
+
+// NewJournaledSink returns a sink journaling the batches exported to sink in dir, which is
+// created if needed. Batches journaled before, e.g. by a previous Heapster process, are
+// exported first. At most maxEntries batches are kept, the oldest being dropped.
+func NewJournaledSink(sink core.DeduplicatingDataSink, dir string, maxEntries int) (core.DataSink, error) {
+	if maxEntries < 1 {
+		return nil, fmt.Errorf("the journal should keep at least one batch, got %d", maxEntries)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the journal directory %s: %v", dir, err)
+	}
+	this := &journaledSink{
+		sink:         sink,
+		dir:          dir,
+		maxEntries:   maxEntries,
+		flushChannel: make(chan struct{}, 1),
+		stopChannel:  make(chan struct{}),
+		doneChannel:  make(chan struct{}),
+	}
+	entries, err := this.entries()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		glog.Infof("Found %d journaled batches for %s, exporting them again", len(entries), sink.Name())
+	}
+	journalPendingBatches.WithLabelValues(sink.Name()).Set(float64(len(entries)))
+	go this.run()
+	this.triggerFlush()
+	return this, nil
+}
+
+func (this *journaledSink) Name() string {
+	return this.sink.Name()
+}
+
+func (this *journaledSink) ExportData(batch *core.DataBatch) {
+	if err := this.write(batch); err != nil {
+		// Exporting the batch without journaling it is better than losing it.
+		glog.Errorf("[batch %s] Failed to journal the batch for %s, exporting it directly: %v", batch.ID, this.Name(), err)
+		if err := this.sink.ExportDataWithAck(batch); err != nil {
+			glog.Errorf("[batch %s] Failed to export the batch to %s: %v", batch.ID, this.Name(), err)
+		}
+		return
+	}
+	this.triggerFlush()
+}
+
+func (this *journaledSink) Stop() {
+	close(this.stopChannel)
+	<-this.doneChannel
+	this.sink.Stop()
+}
+
+func (this *journaledSink) triggerFlush() {
+	select {
+	case this.flushChannel <- struct{}{}:
+	default:
+		// A flush is pending already.
+	}
+}
+
+func (this *journaledSink) run() {
+	defer close(this.doneChannel)
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-this.flushChannel:
+		case <-retry:
+		case <-this.stopChannel:
+			return
+		}
+		retry = nil
+		if !this.flush() {
+			retry = time.After(journalRetryInterval)
+		}
+	}
+}
+
+// flush exports the journaled batches, oldest first, until one fails. It returns false if
+// some batches are left in the journal.
+func (this *journaledSink) flush() bool {
+	entries, err := this.entries()
+	if err != nil {
+		glog.Errorf("Failed to list the journal of %s: %v", this.Name(), err)
+		return false
+	}
+	defer func() {
+		entries, _ := this.entries()
+		journalPendingBatches.WithLabelValues(this.Name()).Set(float64(len(entries)))
+	}()
+	for _, entry := range entries {
+		select {
+		case <-this.stopChannel:
+			// The remaining batches are exported after the restart.
+			return false
+		default:
+		}
+		path := filepath.Join(this.dir, entry)
+		batch, err := readJournalEntry(path)
+		if err != nil {
+			glog.Errorf("Dropping the corrupted journal entry %s: %v", path, err)
+			os.Remove(path)
+			continue
+		}
+		if err := this.sink.ExportDataWithAck(batch); err != nil {
+			glog.Warningf("[batch %s] Failed to export the journaled batch to %s, retrying later: %v", batch.ID, this.Name(), err)
+			return false
+		}
+		// The entry may have been dropped meanwhile because the journal was full.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			glog.Errorf("[batch %s] Failed to remove the acknowledged batch from the journal: %v", batch.ID, err)
+		}
+	}
+	return true
+}
+
+// write adds the batch to the journal, dropping the oldest batches if it is full.
+func (this *journaledSink) write(batch *core.DataBatch) error {
+	entries, err := this.entries()
+	if err != nil {
+		return err
+	}
+	for len(entries) >= this.maxEntries {
+		glog.Warningf("The journal of %s is full, dropping batch %s", this.Name(), entries[0])
+		if err := os.Remove(filepath.Join(this.dir, entries[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		journalDroppedBatches.WithLabelValues(this.Name()).Inc()
+		entries = entries[1:]
+	}
+
+	file, err := ioutil.TempFile(this.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(batch)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Renaming makes the entry visible only once it is complete.
+		err = os.Rename(file.Name(), filepath.Join(this.dir, journalEntryName(batch)))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	journalPendingBatches.WithLabelValues(this.Name()).Set(float64(len(entries) + 1))
+	return nil
+}
+
+// entries returns the names of the journaled batches, oldest first.
+func (this *journaledSink) entries() ([]string, error) {
+	files, err := ioutil.ReadDir(this.dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), journalEntrySuffix) {
+			result = append(result, file.Name())
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// journalEntryName returns the name of the entry of the batch, sorting by the timestamp.
+func journalEntryName(batch *core.DataBatch) string {
+	return fmt.Sprintf("%020d-%s%s", batch.Timestamp.UnixNano(), batch.ID, journalEntrySuffix)
+}
+
+func readJournalEntry(path string) (*core.DataBatch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	batch := &core.DataBatch{}
+	if err := gob.NewDecoder(file).Decode(batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+type fakeDeduplicatingSink struct {
+	sync.Mutex
+	failures int
+	exported []*core.DataBatch
+}
+
+func (this *fakeDeduplicatingSink) Name() string {
+	return "fake"
+}
+
+func (this *fakeDeduplicatingSink) ExportData(batch *core.DataBatch) {
+	this.ExportDataWithAck(batch)
+}
+
+func (this *fakeDeduplicatingSink) ExportDataWithAck(batch *core.DataBatch) error {
+	this.Lock()
+	defer this.Unlock()
+	if this.failures > 0 {
+		this.failures--
+		return fmt.Errorf("unavailable")
+	}
+	this.exported = append(this.exported, batch)
+	return nil
+}
+
+func (this *fakeDeduplicatingSink) Stop() {}
+
+func (this *fakeDeduplicatingSink) exportedIDs() []string {
+	this.Lock()
+	defer this.Unlock()
+	var ids []string
+	for _, batch := range this.exported {
+		ids = append(ids, batch.ID)
+	}
+	return ids
+}
+
+func newJournalBatch(id string, timestamp time.Time) *core.DataBatch {
+	return &core.DataBatch{
+		ID:        id,
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			"node:n1": {
+				MetricValues: map[string]core.MetricValue{
+					"cpu/usage": {ValueType: core.ValueFloat, FloatValue: math.NaN()},
+				},
+			},
+		},
+	}
+}
+
+func waitForExports(t *testing.T, sink *fakeDeduplicatingSink, count int) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if len(sink.exportedIDs()) >= count {
+			return
+		}
+	}
+	t.Fatalf("expected %d exported batches, got %v", count, sink.exportedIDs())
+}
+
+func TestJournaledSinkReplays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	fake := &fakeDeduplicatingSink{failures: 1000}
+	sink, err := NewJournaledSink(fake, dir, 2)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		sink.ExportData(newJournalBatch(fmt.Sprintf("b%d", i), now.Add(time.Duration(i)*time.Minute)))
+	}
+	sink.Stop()
+	assert.Empty(t, fake.exportedIDs())
+
+	// The oldest batch was dropped, the others are exported after the restart.
+	fake.failures = 0
+	sink, err = NewJournaledSink(fake, dir, 2)
+	require.NoError(t, err)
+	waitForExports(t, fake, 2)
+	sink.ExportData(newJournalBatch("b3", now.Add(3*time.Minute)))
+	waitForExports(t, fake, 3)
+	sink.Stop()
+
+	assert.Equal(t, []string{"b1", "b2", "b3"}, fake.exportedIDs())
+	assert.True(t, math.IsNaN(fake.exported[0].MetricSets["node:n1"].MetricValues["cpu/usage"].FloatValue))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestJournaledSinkValidatesSize(t *testing.T) {
+	_, err := NewJournaledSink(&fakeDeduplicatingSink{}, os.TempDir(), 0)
+	assert.Error(t, err)
+}
@@ -15,7 +15,9 @@
 package kafka
 
 import (
+	"fmt"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -29,11 +31,22 @@ type KafkaSinkPoint struct {
 	MetricsValue     interface{}
 	MetricsTimestamp time.Time
 	MetricsTags      map[string]string
+	// ID of the batch of the point, see core.DataBatch.
+	BatchID string `json:",omitempty"`
+	// Checksum of the batch of the point and number of points in the batch, with the checksum
+	// option. See core.BatchChecksum.
+	BatchChecksum string `json:",omitempty"`
+	BatchPoints   int    `json:",omitempty"`
+	// Cluster and replica of the dedup key of the batch of the point, if set. Consumers receiving
+	// the points of redundant replicas keep those of one replica for each cluster and timestamp.
+	Cluster string `json:",omitempty"`
+	Replica string `json:",omitempty"`
 }
 
 type kafkaSink struct {
 	kafka_common.KafkaClient
 	sync.RWMutex
+	checksum bool
 }
 
 func (sink *kafkaSink) Name() string {
@@ -41,10 +54,29 @@ func (sink *kafkaSink) Name() string {
 }
 
 func (sink *kafkaSink) ExportData(dataBatch *core.DataBatch) {
+	// Failures are logged already.
+	sink.ExportDataWithAck(dataBatch)
+}
+
+// ExportDataWithAck exports the batch and returns an error if any message could not be
+// produced. Messages are keyed by the timestamp, metric set and metric, so consumers and log
+// compaction can drop the duplicates of batches exported again.
+func (sink *kafkaSink) ExportDataWithAck(dataBatch *core.DataBatch) error {
 	sink.Lock()
 	defer sink.Unlock()
 
-	for _, metricSet := range dataBatch.MetricSets {
+	failed := 0
+	timestamp := dataBatch.Timestamp.UTC()
+	var checksum string
+	var points int
+	if sink.checksum {
+		checksum, points = core.BatchChecksum(dataBatch)
+	}
+	var cluster, replica string
+	if dataBatch.DedupKey != nil {
+		cluster, replica = dataBatch.DedupKey.Cluster, dataBatch.DedupKey.Replica
+	}
+	for key, metricSet := range dataBatch.MetricSets {
 		for metricName, metricValue := range metricSet.MetricValues {
 			point := KafkaSinkPoint{
 				MetricsName: metricName,
@@ -52,11 +84,17 @@ func (sink *kafkaSink) ExportData(dataBatch *core.DataBatch) {
 				MetricsValue: map[string]interface{}{
 					"value": metricValue.GetValue(),
 				},
-				MetricsTimestamp: dataBatch.Timestamp.UTC(),
+				MetricsTimestamp: timestamp,
+				BatchID:          dataBatch.ID,
+				BatchChecksum:    checksum,
+				BatchPoints:      points,
+				Cluster:          cluster,
+				Replica:          replica,
 			}
-			err := sink.ProduceKafkaMessage(point)
+			err := sink.ProduceKeyedKafkaMessage(messageKey(timestamp, key, metricName, nil), point)
 			if err != nil {
-				glog.Errorf("Failed to produce metric message: %s", err)
+				glog.Errorf("[batch %s] Failed to produce metric message: %s", dataBatch.ID, err)
+				failed++
 			}
 		}
 		for _, metric := range metricSet.LabeledMetrics {
@@ -73,17 +111,41 @@ func (sink *kafkaSink) ExportData(dataBatch *core.DataBatch) {
 				MetricsValue: map[string]interface{}{
 					"value": metric.GetValue(),
 				},
-				MetricsTimestamp: dataBatch.Timestamp.UTC(),
+				MetricsTimestamp: timestamp,
+				BatchID:          dataBatch.ID,
+				BatchChecksum:    checksum,
+				BatchPoints:      points,
+				Cluster:          cluster,
+				Replica:          replica,
 			}
-			err := sink.ProduceKafkaMessage(point)
+			err := sink.ProduceKeyedKafkaMessage(messageKey(timestamp, key, metric.Name, metric.Labels), point)
 			if err != nil {
-				glog.Errorf("Failed to produce metric message: %s", err)
+				glog.Errorf("[batch %s] Failed to produce metric message: %s", dataBatch.ID, err)
+				failed++
 			}
 		}
 	}
+	if failed > 0 {
+		return fmt.Errorf("failed to produce %d messages of batch %s", failed, dataBatch.ID)
+	}
+	return nil
+}
+
+// messageKey identifies a point, e.g. "1500000000000000000/node:n1/cpu/usage" or
+// "1500000000000000000/node:n1/filesystem/usage{resource_id=/}". Without the timestamp, it is
+// the key of the point in the checksum of its batch.
+func messageKey(timestamp time.Time, metricSetKey, metricName string, labels map[string]string) string {
+	return fmt.Sprintf("%d/%s", timestamp.UnixNano(), core.PointKey(metricSetKey, metricName, labels))
 }
 
 func NewKafkaSink(uri *url.URL) (core.DataSink, error) {
+	checksum := false
+	if values := uri.Query()["checksum"]; len(values) > 0 {
+		var err error
+		if checksum, err = strconv.ParseBool(values[0]); err != nil {
+			return nil, fmt.Errorf("invalid checksum option %q: %v", values[0], err)
+		}
+	}
 	client, err := kafka_common.NewKafkaClient(uri, kafka_common.TimeSeriesTopic)
 	if err != nil {
 		return nil, err
@@ -91,5 +153,6 @@ func NewKafkaSink(uri *url.URL) (core.DataSink, error) {
 
 	return &kafkaSink{
 		KafkaClient: client,
+		checksum:    checksum,
 	}, nil
 }
@@ -17,15 +17,19 @@ package kafka
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/processors"
 )
 
 type fakeKafkaClient struct {
 	points []KafkaSinkPoint
+	keys   []string
 }
 
 type fakeKafkaSink struct {
@@ -34,7 +38,7 @@ type fakeKafkaSink struct {
 }
 
 func NewFakeKafkaClient() *fakeKafkaClient {
-	return &fakeKafkaClient{[]KafkaSinkPoint{}}
+	return &fakeKafkaClient{points: []KafkaSinkPoint{}}
 }
 
 func (client *fakeKafkaClient) ProduceKafkaMessage(msgData interface{}) error {
@@ -45,6 +49,11 @@ func (client *fakeKafkaClient) ProduceKafkaMessage(msgData interface{}) error {
 	return nil
 }
 
+func (client *fakeKafkaClient) ProduceKeyedKafkaMessage(key string, msgData interface{}) error {
+	client.keys = append(client.keys, key)
+	return client.ProduceKafkaMessage(msgData)
+}
+
 func (client *fakeKafkaClient) Name() string {
 	return "Apache Kafka Sink"
 }
@@ -191,3 +200,101 @@ func TestStoreMultipleDataInput(t *testing.T) {
 	}
 
 }
+
+func TestMessageKeys(t *testing.T) {
+	fakeSink := NewFakeSink()
+	timestamp := time.Unix(1500000000, 0)
+	data := core.DataBatch{
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			"node:n1": {
+				MetricValues: map[string]core.MetricValue{
+					"cpu/usage": {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 1},
+				},
+				LabeledMetrics: []core.LabeledMetric{
+					{
+						Name:   "filesystem/usage",
+						Labels: map[string]string{"resource_id": "/", "device": "sda1"},
+						MetricValue: core.MetricValue{
+							ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 2,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	assert.NoError(t, fakeSink.DataSink.(*kafkaSink).ExportDataWithAck(&data))
+	assert.Equal(t, []string{
+		"1500000000000000000/node:n1/cpu/usage",
+		"1500000000000000000/node:n1/filesystem/usage{device=sda1,resource_id=/}",
+	}, fakeSink.fakeProducer.keys)
+}
+
+func TestBatchChecksum(t *testing.T) {
+	fakeSink := NewFakeSink()
+	fakeSink.DataSink.(*kafkaSink).checksum = true
+	data := core.DataBatch{
+		Timestamp: time.Unix(1500000000, 0),
+		ID:        "20170714T024000Z-00000001",
+		DedupKey:  &core.DedupKey{Cluster: "prod", Replica: "heapster-1", Timestamp: time.Unix(1500000000, 0)},
+		MetricSets: map[string]*core.MetricSet{
+			"node:n1": {
+				MetricValues: map[string]core.MetricValue{
+					"cpu/usage":            {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 1},
+					"cpu/node_utilization": {ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: 0.25},
+				},
+			},
+		},
+	}
+	assert.NoError(t, fakeSink.DataSink.(*kafkaSink).ExportDataWithAck(&data))
+
+	checksum, points := core.BatchChecksum(&data)
+	// sha256 of "node:n1/cpu/node_utilization 0.25\nnode:n1/cpu/usage 1\n".
+	assert.Equal(t, "caad8c3e38f4a1fb6432aff432fd50e2896a7e255ac4167015ddc00f46e919c7", checksum)
+	assert.Equal(t, 2, points)
+	assert.Len(t, fakeSink.fakeProducer.points, 2)
+	for _, point := range fakeSink.fakeProducer.points {
+		assert.Equal(t, checksum, point.BatchChecksum)
+		assert.Equal(t, 2, point.BatchPoints)
+		assert.Equal(t, "20170714T024000Z-00000001", point.BatchID)
+		assert.Equal(t, "prod", point.Cluster)
+		assert.Equal(t, "heapster-1", point.Replica)
+	}
+}
+
+func TestScrubbedPodNames(t *testing.T) {
+	scrubber, err := processors.NewLabelScrubber([]string{"pod_name:hash"}, []byte("secret"))
+	require.NoError(t, err)
+	labels := map[string]string{
+		core.LabelNamespaceName.Key: "notebooks",
+		core.LabelPodName.Key:       "jupyter-alice",
+		core.LabelContainerName.Key: "notebook",
+	}
+	value := core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 1}
+	batch, err := scrubber.Process(&core.DataBatch{
+		Timestamp: time.Unix(1500000000, 0),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("notebooks", "jupyter-alice"): {
+				Labels:       labels,
+				MetricValues: map[string]core.MetricValue{"cpu/usage": value},
+			},
+			core.PodContainerKey("notebooks", "jupyter-alice", "notebook"): {
+				Labels:       labels,
+				MetricValues: map[string]core.MetricValue{"cpu/usage": value},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	fakeSink := NewFakeSink()
+	assert.NoError(t, fakeSink.DataSink.(*kafkaSink).ExportDataWithAck(batch))
+	require.Len(t, fakeSink.fakeProducer.keys, 2)
+	for _, key := range fakeSink.fakeProducer.keys {
+		assert.False(t, strings.Contains(key, "alice"), key)
+		assert.True(t, strings.Contains(key, "namespace:notebooks/"), key)
+	}
+	messages, err := json.Marshal(fakeSink.fakeProducer.points)
+	require.NoError(t, err)
+	assert.False(t, strings.Contains(string(messages), "alice"), string(messages))
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	// Annotations of the lease ConfigMap holding the replica which claimed the latest batch.
+	LeaseHolderAnnotation = "heapster.kubernetes.io/export-lease-holder"
+	LeaseBatchAnnotation  = "heapster.kubernetes.io/export-lease-batch"
+
+	maxLeaseAttempts = 3
+)
+
+var (
+	// Number of batches not exported because another replica claimed them.
+	leasedBatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "exporter",
+			Name:      "batches_exported_by_other_replica_total",
+			Help:      "Number of batches not exported because another replica of Heapster claimed them.",
+		},
+		[]string{"exporter"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(leasedBatches)
+}
+
+// ExportLease decides which of the redundant replicas of Heapster scraping the same cluster
+// exports each batch.
+type ExportLease interface {
+	// Claim returns true if this replica should export the batches with the given timestamp.
+	Claim(timestamp time.Time) bool
+}
+
+// configMapClient defines the methods of the ConfigMaps client used by the lease.
+type configMapClient interface {
+	Get(name string, options metav1.GetOptions) (*kube_api.ConfigMap, error)
+	Create(*kube_api.ConfigMap) (*kube_api.ConfigMap, error)
+	Update(*kube_api.ConfigMap) (*kube_api.ConfigMap, error)
+}
+
+// configMapLease claims each batch by writing its timestamp and the name of the replica to the
+// annotations of a ConfigMap, relying on the optimistic concurrency of the API server: the first
+// replica to claim a batch exports it. Batches are exported if the lease can't be read or
+// written, as duplicates are better than gaps.
+type configMapLease struct {
+	client  configMapClient
+	name    string
+	replica string
+
+	lock          sync.Mutex
+	lastTimestamp time.Time
+	lastClaimed   bool
+}
+
+// NewConfigMapLease returns a lease claiming the batches of the replica in the given ConfigMap,
+// which is created if it doesn't exist.
+func NewConfigMapLease(client configMapClient, name, replica string) ExportLease {
+	return &configMapLease{
+		client:  client,
+		name:    name,
+		replica: replica,
+	}
+}
+
+func (this *configMapLease) Claim(timestamp time.Time) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	// The sinks claim the same batch in turn.
+	if !timestamp.Equal(this.lastTimestamp) {
+		this.lastTimestamp = timestamp
+		this.lastClaimed = this.claim(timestamp)
+	}
+	return this.lastClaimed
+}
+
+func (this *configMapLease) claim(timestamp time.Time) bool {
+	annotations := map[string]string{
+		LeaseHolderAnnotation: this.replica,
+		LeaseBatchAnnotation:  timestamp.UTC().Format(time.RFC3339Nano),
+	}
+	for i := 0; i < maxLeaseAttempts; i++ {
+		configMap, err := this.client.Get(this.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = this.client.Create(&kube_api.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: this.name, Annotations: annotations},
+			})
+			if errors.IsAlreadyExists(err) {
+				continue
+			}
+			if err != nil {
+				glog.Warningf("Failed to create export lease %s, exporting the batch of %s: %v", this.name, timestamp, err)
+			}
+			return true
+		}
+		if err != nil {
+			glog.Warningf("Failed to get export lease %s, exporting the batch of %s: %v", this.name, timestamp, err)
+			return true
+		}
+
+		// Batches older than the latest claimed one are exported by the replica which claimed it.
+		claimed, err := time.Parse(time.RFC3339Nano, configMap.Annotations[LeaseBatchAnnotation])
+		if err == nil && !claimed.Before(timestamp) {
+			holder := configMap.Annotations[LeaseHolderAnnotation]
+			if holder != this.replica {
+				glog.V(2).Infof("Batch of %s claimed by replica %s in export lease %s", timestamp, holder, this.name)
+			}
+			return holder == this.replica
+		}
+
+		if configMap.Annotations == nil {
+			configMap.Annotations = map[string]string{}
+		}
+		for k, v := range annotations {
+			configMap.Annotations[k] = v
+		}
+		_, err = this.client.Update(configMap)
+		if errors.IsConflict(err) {
+			continue
+		}
+		if err != nil {
+			glog.Warningf("Failed to update export lease %s, exporting the batch of %s: %v", this.name, timestamp, err)
+		}
+		return true
+	}
+	glog.Warningf("Failed to claim the batch of %s in export lease %s after %d attempts, exporting it", timestamp, this.name, maxLeaseAttempts)
+	return true
+}
+
+// leasedSink only exports the batches claimed by this replica.
+type leasedSink struct {
+	sink  core.DataSink
+	lease ExportLease
+}
+
+// NewLeasedSink returns a sink exporting the batches claimed in the lease only, so that the sink
+// receives a single copy of the batches of redundant replicas.
+func NewLeasedSink(sink core.DataSink, lease ExportLease) core.DataSink {
+	return &leasedSink{
+		sink:  sink,
+		lease: lease,
+	}
+}
+
+func (this *leasedSink) Name() string {
+	return this.sink.Name()
+}
+
+func (this *leasedSink) ExportData(batch *core.DataBatch) {
+	if !this.lease.Claim(batch.Timestamp) {
+		leasedBatches.WithLabelValues(this.sink.Name()).Inc()
+		return
+	}
+	this.sink.ExportData(batch)
+}
+
+func (this *leasedSink) Stop() {
+	this.sink.Stop()
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+var configMapsResource = schema.GroupResource{Resource: "configmaps"}
+
+// fakeConfigMaps stores a single ConfigMap, rejecting updates of stale versions like the API server.
+type fakeConfigMaps struct {
+	lock      sync.Mutex
+	configMap *kube_api.ConfigMap
+	version   int
+	err       error
+}
+
+func (this *fakeConfigMaps) Get(name string, options metav1.GetOptions) (*kube_api.ConfigMap, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.err != nil {
+		return nil, this.err
+	}
+	if this.configMap == nil {
+		return nil, errors.NewNotFound(configMapsResource, name)
+	}
+	result := *this.configMap
+	result.Annotations = map[string]string{}
+	for k, v := range this.configMap.Annotations {
+		result.Annotations[k] = v
+	}
+	return &result, nil
+}
+
+func (this *fakeConfigMaps) Create(configMap *kube_api.ConfigMap) (*kube_api.ConfigMap, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.configMap != nil {
+		return nil, errors.NewAlreadyExists(configMapsResource, configMap.Name)
+	}
+	return this.store(configMap), nil
+}
+
+func (this *fakeConfigMaps) Update(configMap *kube_api.ConfigMap) (*kube_api.ConfigMap, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if configMap.ResourceVersion != strconv.Itoa(this.version) {
+		return nil, errors.NewConflict(configMapsResource, configMap.Name, fmt.Errorf("stale version"))
+	}
+	return this.store(configMap), nil
+}
+
+func (this *fakeConfigMaps) store(configMap *kube_api.ConfigMap) *kube_api.ConfigMap {
+	this.version++
+	stored := *configMap
+	stored.ResourceVersion = strconv.Itoa(this.version)
+	this.configMap = &stored
+	return &stored
+}
+
+func TestConfigMapLease(t *testing.T) {
+	configMaps := &fakeConfigMaps{}
+	replica1 := NewConfigMapLease(configMaps, "heapster-export", "replica-1")
+	replica2 := NewConfigMapLease(configMaps, "heapster-export", "replica-2")
+	now := time.Unix(1500000000, 0)
+
+	// The first replica to claim a batch exports it.
+	assert.True(t, replica1.Claim(now))
+	assert.True(t, replica1.Claim(now))
+	assert.False(t, replica2.Claim(now))
+	assert.Equal(t, "replica-1", configMaps.configMap.Annotations[LeaseHolderAnnotation])
+
+	now = now.Add(time.Minute)
+	assert.True(t, replica2.Claim(now))
+	assert.False(t, replica1.Claim(now))
+	// Older batches are exported by the holder of the latest one.
+	assert.True(t, replica2.Claim(now.Add(-30*time.Second)))
+	assert.False(t, replica1.Claim(now.Add(-30*time.Second)))
+
+	// Batches are exported if the lease can't be read.
+	configMaps.err = fmt.Errorf("connection refused")
+	now = now.Add(time.Minute)
+	assert.True(t, replica1.Claim(now))
+	assert.True(t, replica2.Claim(now))
+}
+
+func TestLeasedSink(t *testing.T) {
+	configMaps := &fakeConfigMaps{}
+	now := time.Unix(1500000000, 0)
+	assert.True(t, NewConfigMapLease(configMaps, "heapster-export", "replica-1").Claim(now))
+
+	sink := util.NewDummySink("sink", 0)
+	leased := NewLeasedSink(sink, NewConfigMapLease(configMaps, "heapster-export", "replica-2"))
+	leased.ExportData(&core.DataBatch{Timestamp: now})
+	assert.Equal(t, 0, sink.GetExportCount())
+	leased.ExportData(&core.DataBatch{Timestamp: now.Add(time.Minute)})
+	assert.Equal(t, 1, sink.GetExportCount())
+}
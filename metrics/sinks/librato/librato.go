@@ -109,7 +109,7 @@ func (sink *libratoSink) ExportData(dataBatch *core.DataBatch) {
 
 			measurements = append(measurements, measurement)
 			if len(measurements) >= maxSendBatchSize {
-				sink.sendData(measurements)
+				sink.sendData(dataBatch, measurements)
 				measurements = make([]librato_common.Measurement, 0, 0)
 			}
 		}
@@ -142,20 +142,20 @@ func (sink *libratoSink) ExportData(dataBatch *core.DataBatch) {
 
 			measurements = append(measurements, measurement)
 			if len(measurements) >= maxSendBatchSize {
-				sink.sendData(measurements)
+				sink.sendData(dataBatch, measurements)
 				measurements = make([]librato_common.Measurement, 0, 0)
 			}
 		}
 	}
 	if len(measurements) >= 0 {
-		sink.sendData(measurements)
+		sink.sendData(dataBatch, measurements)
 	}
 }
 
-func (sink *libratoSink) sendData(measurements []librato_common.Measurement) {
+func (sink *libratoSink) sendData(dataBatch *core.DataBatch, measurements []librato_common.Measurement) {
 	start := time.Now()
 	if err := sink.client.Write(measurements); err != nil {
-		glog.Errorf("Librato write failed: %v", err)
+		glog.Errorf("[batch %s] Librato write failed: %v", dataBatch.ID, err)
 	}
 	end := time.Now()
 	glog.V(4).Infof("Exported %d data to librato in %s", len(measurements), end.Sub(start))
@@ -0,0 +1,343 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lineprotocol implements a sink writing one line per metric value to a TCP or UDP
+// endpoint, in the InfluxDB line protocol, the Carbon plaintext protocol, the Wavefront data
+// format or a custom template.
+package lineprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/golang/glog"
+
+	"k8s.io/heapster/common/endpoints"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	defaultFormat  = "influx"
+	defaultTimeout = 10 * time.Second
+	// Largest UDP payload not fragmented on an Ethernet link, with room for IP options and tunnels.
+	defaultMaxDatagramSize = 1432
+	// Source of the Wavefront points of the metric sets of no node.
+	defaultWavefrontSource = "heapster"
+)
+
+// Templates of the supported formats. Tags are rendered in the order of their names.
+var formats = map[string]string{
+	// InfluxDB line protocol, also accepted by Telegraf and VictoriaMetrics.
+	"influx": `{{influxEscape .Name}}{{range $k, $v := .Tags}},{{influxEscape $k}}={{influxEscape $v}}{{end}} value={{.Value}} {{.Timestamp.UnixNano}}`,
+	// Carbon plaintext protocol with tags, as supported by Graphite 1.1.
+	"carbon": `{{carbonEscape .Name}}{{range $k, $v := .Tags}};{{carbonEscape $k}}={{carbonEscape $v}}{{end}} {{.Value}} {{.Timestamp.Unix}}`,
+	// Wavefront data format, as accepted by the Wavefront proxy. The source is the node of the metric set.
+	"wavefront": `{{wavefrontName .Name}} {{.Value}} {{.Timestamp.Unix}} source={{wavefrontQuote (wavefrontSource .Tags)}}{{range $k, $v := .Tags}} {{wavefrontTag $k}}={{wavefrontQuote $v}}{{end}}`,
+}
+
+var templateFuncs = template.FuncMap{
+	"influxEscape": strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace,
+	"carbonEscape": strings.NewReplacer("/", ".", ";", "_", "=", "_", " ", "_", "~", "_").Replace,
+	"replace":      strings.Replace,
+	// Wavefront names may only have letters, digits and the characters -_./, tag keys the same but /.
+	"wavefrontName": func(name string) string {
+		return wavefrontEscape(name, "-_./")
+	},
+	"wavefrontTag": func(key string) string {
+		return wavefrontEscape(key, "-_.")
+	},
+	"wavefrontQuote": func(value string) string {
+		return `"` + wavefrontQuoteEscaper.Replace(value) + `"`
+	},
+	"wavefrontSource": func(tags map[string]string) string {
+		for _, label := range []string{core.LabelNodename.Key, core.LabelHostname.Key} {
+			if source := tags[label]; source != "" {
+				return source
+			}
+		}
+		return defaultWavefrontSource
+	},
+}
+
+var wavefrontQuoteEscaper = strings.NewReplacer(`"`, `\"`, "\n", " ")
+
+// wavefrontEscape replaces by _ the characters of s other than ASCII letters, digits and those of allowed.
+func wavefrontEscape(s, allowed string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(allowed, r)) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Point is a single metric value, as passed to the template.
+type Point struct {
+	// Name of the metric, including the configured prefix.
+	Name  string
+	Value string
+	// Labels of the metric set and of the metric. Labels with empty values are omitted.
+	Tags      map[string]string
+	Timestamp time.Time
+}
+
+type lineProtocolSink struct {
+	sync.Mutex
+	network  string
+	address  string
+	prefix   string
+	timeout  time.Duration
+	template *template.Template
+	// Size above which the lines are split in several datagrams, with udp.
+	maxDatagramSize int
+	conn            net.Conn
+	// Rotates over the addresses of the host of address, nil to dial the host name.
+	resolver *endpoints.Resolver
+	// Address the connection was dialed to, when resolved.
+	connAddress string
+}
+
+func (sink *lineProtocolSink) Name() string {
+	return "Line Protocol Sink"
+}
+
+func (sink *lineProtocolSink) Stop() {
+	sink.Lock()
+	defer sink.Unlock()
+	sink.disconnect()
+}
+
+func (sink *lineProtocolSink) ExportData(batch *core.DataBatch) {
+	sink.Lock()
+	defer sink.Unlock()
+
+	var buf bytes.Buffer
+	if err := sink.render(&buf, batch); err != nil {
+		glog.Errorf("[batch %s] Failed to render metrics for %s: %v", batch.ID, sink.address, err)
+		return
+	}
+	if err := sink.send(buf.Bytes()); err != nil {
+		glog.Errorf("[batch %s] Failed to send metrics to %s: %v", batch.ID, sink.address, err)
+	}
+}
+
+// render writes one line per metric value of the batch to w.
+func (sink *lineProtocolSink) render(w *bytes.Buffer, batch *core.DataBatch) error {
+	for _, ms := range batch.MetricSets {
+		timestamp := ms.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range ms.MetricValues {
+			if err := sink.renderPoint(w, name, value, ms.Labels, nil, timestamp); err != nil {
+				return err
+			}
+		}
+		for _, metric := range ms.LabeledMetrics {
+			if err := sink.renderPoint(w, metric.Name, metric.MetricValue, ms.Labels, metric.Labels, timestamp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sink *lineProtocolSink) renderPoint(w *bytes.Buffer, name string, value core.MetricValue,
+	labels, metricLabels map[string]string, timestamp time.Time) error {
+	tags := make(map[string]string, len(labels)+len(metricLabels))
+	for _, source := range []map[string]string{labels, metricLabels} {
+		for k, v := range source {
+			if v != "" {
+				tags[k] = v
+			}
+		}
+	}
+	point := Point{
+		Name:      sink.prefix + name,
+		Value:     formatValue(value),
+		Tags:      tags,
+		Timestamp: timestamp,
+	}
+	if err := sink.template.Execute(w, point); err != nil {
+		return err
+	}
+	w.WriteByte('\n')
+	return nil
+}
+
+func formatValue(value core.MetricValue) string {
+	if value.ValueType == core.ValueFloat {
+		return strconv.FormatFloat(value.FloatValue, 'f', -1, 64)
+	}
+	return strconv.FormatInt(value.IntValue, 10)
+}
+
+// send writes the data to the endpoint, reconnecting once if the connection was lost.
+func (sink *lineProtocolSink) send(data []byte) error {
+	var err error
+	if sink.conn != nil && sink.resolver != nil && !sink.resolver.Has(sink.connAddress) {
+		glog.V(2).Infof("Address %s of %s is no longer current, reconnecting", sink.connAddress, sink.address)
+		sink.disconnect()
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if sink.conn == nil {
+			if err = sink.connect(); err != nil {
+				return err
+			}
+		}
+		sink.conn.SetWriteDeadline(time.Now().Add(sink.timeout))
+		if err = sink.write(data); err == nil {
+			return nil
+		}
+		if sink.resolver != nil {
+			sink.resolver.MarkFailed(sink.connAddress)
+		}
+		sink.disconnect()
+	}
+	return err
+}
+
+// write writes the data to the connection. With udp, the lines are sent in datagrams of at most
+// maxDatagramSize bytes, so that no line is split across datagrams. Longer lines are sent alone.
+func (sink *lineProtocolSink) write(data []byte) error {
+	if sink.network != "udp" {
+		writer := bufio.NewWriter(sink.conn)
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+	for _, datagram := range splitDatagrams(data, sink.maxDatagramSize) {
+		if _, err := sink.conn.Write(datagram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitDatagrams splits the lines of data in chunks of at most size bytes, but for the lines
+// longer than size.
+func splitDatagrams(data []byte, size int) [][]byte {
+	datagrams := [][]byte{}
+	start := 0
+	for end := 0; end < len(data); {
+		next := bytes.IndexByte(data[end:], '\n') + 1
+		if next == 0 {
+			next = len(data) - end
+		}
+		if end > start && end+next-start > size {
+			datagrams = append(datagrams, data[start:end])
+			start = end
+		}
+		end += next
+	}
+	if start < len(data) {
+		datagrams = append(datagrams, data[start:])
+	}
+	return datagrams
+}
+
+func (sink *lineProtocolSink) connect() error {
+	address := sink.address
+	if sink.resolver != nil {
+		var err error
+		if address, err = sink.resolver.Next(); err != nil {
+			return err
+		}
+	}
+	conn, err := net.DialTimeout(sink.network, address, sink.timeout)
+	if err != nil {
+		if sink.resolver != nil {
+			sink.resolver.MarkFailed(address)
+		}
+		return err
+	}
+	sink.conn = conn
+	sink.connAddress = address
+	return nil
+}
+
+func (sink *lineProtocolSink) disconnect() {
+	if sink.conn != nil {
+		sink.conn.Close()
+		sink.conn = nil
+	}
+}
+
+// NewLineProtocolSink creates a sink for uris of the form tcp://host:port?format=influx.
+func NewLineProtocolSink(uri *url.URL) (core.DataSink, error) {
+	if uri.Scheme != "tcp" && uri.Scheme != "udp" {
+		return nil, fmt.Errorf("unsupported protocol %q, expected tcp or udp", uri.Scheme)
+	}
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing address of the line protocol endpoint")
+	}
+	sink := &lineProtocolSink{
+		network:         uri.Scheme,
+		address:         uri.Host,
+		timeout:         defaultTimeout,
+		maxDatagramSize: defaultMaxDatagramSize,
+	}
+
+	opts := uri.Query()
+	format := defaultFormat
+	if len(opts["format"]) > 0 {
+		format = opts["format"][0]
+	}
+	text, found := formats[format]
+	if !found {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if len(opts["template"]) > 0 {
+		text = opts["template"][0]
+	}
+	tmpl, err := template.New("line").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	sink.template = tmpl
+
+	if len(opts["prefix"]) > 0 {
+		sink.prefix = opts["prefix"][0]
+	}
+	if len(opts["timeout"]) > 0 {
+		timeout, err := time.ParseDuration(opts["timeout"][0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse timeout: %v", err)
+		}
+		sink.timeout = timeout
+	}
+	if len(opts["max_datagram_size"]) > 0 {
+		size, err := strconv.Atoi(opts["max_datagram_size"][0])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid max_datagram_size %q", opts["max_datagram_size"][0])
+		}
+		sink.maxDatagramSize = size
+	}
+	if sink.resolver, err = endpoints.NewResolverFromOptions(sink.address, opts); err != nil {
+		return nil, err
+	}
+
+	glog.Infof("Created line protocol sink writing %s to %s://%s", format, sink.network, sink.address)
+	return sink, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lineprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+)
+
+// testBatch gives the pod of the shared batch a name and the filesystem a resource id that
+// have to be escaped.
+func testBatch() *core.DataBatch {
+	batch := sinktest.Batch()
+	batch.MetricSets[core.PodKey("default", "web-1")].Labels[core.LabelPodName.Key] = "web 1"
+	batch.MetricSets[core.NodeKey("node-1")].LabeledMetrics[0].Labels[core.LabelResourceID.Key] = "/"
+	return batch
+}
+
+func newTestSink(t *testing.T, uri string) *lineProtocolSink {
+	parsed, err := url.Parse(uri)
+	require.NoError(t, err)
+	sink, err := NewLineProtocolSink(parsed)
+	require.NoError(t, err)
+	return sink.(*lineProtocolSink)
+}
+
+func render(t *testing.T, sink *lineProtocolSink) []string {
+	var buf bytes.Buffer
+	require.NoError(t, sink.render(&buf, testBatch()))
+	lines := []string{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestFormats(t *testing.T) {
+	influx := newTestSink(t, "tcp://localhost:8089")
+	assert.Equal(t, []string{
+		`cpu/usage,nodename=node-1,type=node value=100 1500000000000000000`,
+		`filesystem/usage,nodename=node-1,resource_id=/,type=node value=0.5 1500000000000000000`,
+		`memory/usage,namespace_name=default,pod_name=web\ 1,type=pod value=100 1500000000000000000`,
+		`memory/usage,nodename=node-1,type=node value=1024 1500000000000000000`,
+	}, render(t, influx))
+
+	carbon := newTestSink(t, "tcp://localhost:2003?format=carbon&prefix=heapster/")
+	assert.Equal(t, []string{
+		`heapster.cpu.usage;nodename=node-1;type=node 100 1500000000`,
+		`heapster.filesystem.usage;nodename=node-1;resource_id=.;type=node 0.5 1500000000`,
+		`heapster.memory.usage;namespace_name=default;pod_name=web_1;type=pod 100 1500000000`,
+		`heapster.memory.usage;nodename=node-1;type=node 1024 1500000000`,
+	}, render(t, carbon))
+
+	wavefront := newTestSink(t, "tcp://localhost:2878?format=wavefront&prefix=heapster.")
+	assert.Equal(t, []string{
+		`heapster.cpu/usage 100 1500000000 source="node-1" nodename="node-1" type="node"`,
+		`heapster.filesystem/usage 0.5 1500000000 source="node-1" nodename="node-1" resource_id="/" type="node"`,
+		`heapster.memory/usage 100 1500000000 source="heapster" namespace_name="default" pod_name="web 1" type="pod"`,
+		`heapster.memory/usage 1024 1500000000 source="node-1" nodename="node-1" type="node"`,
+	}, render(t, wavefront))
+
+	custom := newTestSink(t, `tcp://localhost:2003?template={{replace .Name "/" "_" -1}}{{with .Tags.pod_name}} pod="{{.}}"{{end}} {{.Value}}`)
+	assert.Equal(t, []string{
+		`cpu_usage 100`,
+		`filesystem_usage 0.5`,
+		`memory_usage 1024`,
+		`memory_usage pod="web 1" 100`,
+	}, render(t, custom))
+}
+
+func TestInvalidUri(t *testing.T) {
+	for _, uri := range []string{
+		"http://localhost:8089",
+		"tcp://",
+		"tcp://localhost:8089?format=unknown",
+		"tcp://localhost:8089?template={{.Name",
+		"tcp://localhost:8089?timeout=never",
+		"udp://localhost:8089?max_datagram_size=0",
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewLineProtocolSink(parsed)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestExportData(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					received <- scanner.Text()
+				}
+			}(conn)
+		}
+	}()
+
+	sink := newTestSink(t, "tcp://"+listener.Addr().String()+"?format=carbon")
+	defer sink.Stop()
+
+	sink.ExportData(testBatch())
+	// The connection is reestablished after it is closed.
+	sink.Lock()
+	sink.disconnect()
+	sink.Unlock()
+	sink.ExportData(testBatch())
+
+	for i := 0; i < 8; i++ {
+		select {
+		case line := <-received:
+			assert.Contains(t, line, " 1500000000")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d lines", i)
+		}
+	}
+}
+
+func TestWavefrontSource(t *testing.T) {
+	sink := newTestSink(t, "tcp://localhost:2878?format=wavefront")
+	var buf bytes.Buffer
+	require.NoError(t, sink.renderPoint(&buf, "cpu/usage rate", core.MetricValue{IntValue: 1},
+		map[string]string{core.LabelNodename.Key: "node-1", "quoted": `a "b"`}, nil, time.Unix(1500000000, 0)))
+	assert.Equal(t, "cpu/usage_rate 1 1500000000 source=\"node-1\" nodename=\"node-1\" quoted=\"a \\\"b\\\"\"\n", buf.String())
+}
+
+func TestSplitDatagrams(t *testing.T) {
+	data := []byte("aaaa\nbbbb\ncccccccccc\ndd\n")
+	assert.Equal(t, [][]byte{
+		[]byte("aaaa\nbbbb\n"),
+		[]byte("cccccccccc\n"),
+		[]byte("dd\n"),
+	}, splitDatagrams(data, 10))
+	assert.Equal(t, [][]byte{data}, splitDatagrams(data, len(data)))
+	assert.Equal(t, [][]byte{}, splitDatagrams(nil, 10))
+}
+
+func TestExportDataUdp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink := newTestSink(t, "udp://"+conn.LocalAddr().String()+"?max_datagram_size=100")
+	defer sink.Stop()
+	sink.ExportData(testBatch())
+
+	// The lines, of more than 50 bytes, are sent in a datagram each.
+	lines := []string{}
+	buf := make([]byte, 65536)
+	for len(lines) < 4 {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		datagram := string(buf[:n])
+		assert.True(t, strings.HasSuffix(datagram, "\n"), datagram)
+		assert.Equal(t, 1, strings.Count(datagram, "\n"), datagram)
+		lines = append(lines, datagram)
+	}
+	assert.Contains(t, strings.Join(lines, ""), "memory/usage,")
+	assert.Contains(t, strings.Join(lines, ""), "filesystem/usage,")
+}
@@ -30,13 +30,21 @@ func (this *LogSink) Name() string {
 	return "Log Sink"
 }
 
+func (this *LogSink) AcceptsPartialBatches() bool {
+	return true
+}
+
 func (this *LogSink) Stop() {
 	// Do nothing.
 }
 
 func batchToString(batch *core.DataBatch) string {
 	var buffer bytes.Buffer
-	buffer.WriteString(fmt.Sprintf("DataBatch     Timestamp: %s\n\n", batch.Timestamp))
+	buffer.WriteString(fmt.Sprintf("DataBatch     Timestamp: %s     ID: %s", batch.Timestamp, batch.ID))
+	if batch.DedupKey != nil {
+		buffer.WriteString(fmt.Sprintf("     Dedup key: %s", batch.DedupKey))
+	}
+	buffer.WriteString("\n\n")
 	for _, key := range sortedMetricSetKeys(batch.MetricSets) {
 		ms := batch.MetricSets[key]
 		buffer.WriteString(fmt.Sprintf("MetricSet: %s\n", key))
@@ -109,13 +109,13 @@ func (this *sinkManager) ExportData(data *core.DataBatch) {
 		wg.Add(1)
 		go func(sh sinkHolder, wg *sync.WaitGroup) {
 			defer wg.Done()
-			glog.V(2).Infof("Pushing data to: %s", sh.sink.Name())
+			glog.V(2).Infof("[batch %s] Pushing data to: %s", data.ID, sh.sink.Name())
 			select {
 			case sh.dataBatchChannel <- data:
-				glog.V(2).Infof("Data push completed: %s", sh.sink.Name())
+				glog.V(2).Infof("[batch %s] Data push completed: %s", data.ID, sh.sink.Name())
 				// everything ok
 			case <-time.After(this.exportDataTimeout):
-				glog.Warningf("Failed to push data to sink: %s", sh.sink.Name())
+				glog.Warningf("[batch %s] Failed to push data to sink: %s", data.ID, sh.sink.Name())
 			}
 		}(sh, &wg)
 	}
@@ -158,4 +158,5 @@ func export(s core.DataSink, data *core.DataBatch) {
 	}()
 
 	s.ExportData(data)
+	glog.V(4).Infof("[batch %s] Exported data to %s in %s", data.ID, s.Name(), time.Since(startTime))
 }
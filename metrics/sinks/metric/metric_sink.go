@@ -15,6 +15,8 @@
 package metric
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -38,6 +40,20 @@ type MetricSink struct {
 	shortStore []*core.DataBatch
 	// Memory-efficient long/mid term storage for metrics.
 	longStore []*multimetricStore
+	// Incarnations of pods seen within the long store duration, by namespace/name, in the
+	// order in which they appeared.
+	podIncarnations map[string][]*PodIncarnation
+}
+
+// PodIncarnation describes a single instance of a pod. A pod that is deleted and recreated with the
+// same name gets a new UID and thus a new incarnation.
+type PodIncarnation struct {
+	// Key of the MetricSet of the pod.
+	Key string
+	UID string
+	// Timestamps of the first and the last batch in which the incarnation was present.
+	FirstSeen time.Time
+	LastSeen  time.Time
 }
 
 // Stores values of a single metrics for different MetricSets.
@@ -74,6 +90,11 @@ func (this *MetricSink) Name() string {
 	return "Metric Sink"
 }
 
+// Partial batches are stored too, GetLatestDataBatch skips them.
+func (this *MetricSink) AcceptsPartialBatches() bool {
+	return true
+}
+
 func (this *MetricSink) Stop() {
 	// Do nothing.
 }
@@ -87,16 +108,136 @@ func (this *MetricSink) ExportData(batch *core.DataBatch) {
 	this.longStore = append(popOldStore(this.longStore, now.Add(-this.longStoreDuration)),
 		buildMultimetricStore(this.longStoreMetrics, batch))
 	this.shortStore = append(popOld(this.shortStore, now.Add(-this.shortStoreDuration)), batch)
+	this.updatePodIncarnations(batch, now.Add(-this.longStoreDuration))
+}
+
+// updatePodIncarnations records the pods of the batch and forgets incarnations that were last
+// seen before cutoffTime. Must be called with the lock held.
+func (this *MetricSink) updatePodIncarnations(batch *core.DataBatch, cutoffTime time.Time) {
+	if this.podIncarnations == nil {
+		this.podIncarnations = make(map[string][]*PodIncarnation)
+	}
+	for key, ms := range batch.MetricSets {
+		if ms.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypePod {
+			continue
+		}
+		pod := ms.Labels[core.LabelNamespaceName.Key] + "/" + ms.Labels[core.LabelPodName.Key]
+		uid := ms.Labels[core.LabelPodId.Key]
+
+		var incarnation *PodIncarnation
+		for _, existing := range this.podIncarnations[pod] {
+			if existing.Key == key && existing.UID == uid {
+				incarnation = existing
+				break
+			}
+		}
+		if incarnation == nil {
+			incarnation = &PodIncarnation{Key: key, UID: uid, FirstSeen: batch.Timestamp}
+			this.podIncarnations[pod] = append(this.podIncarnations[pod], incarnation)
+		}
+		if incarnation.LastSeen.Before(batch.Timestamp) {
+			incarnation.LastSeen = batch.Timestamp
+		}
+	}
+
+	for pod, incarnations := range this.podIncarnations {
+		current := make([]*PodIncarnation, 0, len(incarnations))
+		for _, incarnation := range incarnations {
+			if incarnation.LastSeen.After(cutoffTime) {
+				current = append(current, incarnation)
+			}
+		}
+		if len(current) == 0 {
+			delete(this.podIncarnations, pod)
+		} else {
+			this.podIncarnations[pod] = current
+		}
+	}
+}
+
+// DeleteNamespace removes all stored metrics of the namespace and of the pods and containers
+// within it, so they are no longer returned once the namespace is deleted.
+func (this *MetricSink) DeleteNamespace(namespace string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for i, batch := range this.shortStore {
+		// Batches are shared with other sinks, so a purged copy replaces the stored batch.
+		var purged *core.DataBatch
+		for key := range batch.MetricSets {
+			if !core.InNamespace(key, namespace) {
+				continue
+			}
+			if purged == nil {
+				purged = &core.DataBatch{
+					Timestamp:  batch.Timestamp,
+					ID:         batch.ID,
+					MetricSets: make(map[string]*core.MetricSet, len(batch.MetricSets)),
+				}
+				for k, ms := range batch.MetricSets {
+					purged.MetricSets[k] = ms
+				}
+			}
+			delete(purged.MetricSets, key)
+		}
+		if purged != nil {
+			this.shortStore[i] = purged
+		}
+	}
+	for _, store := range this.longStore {
+		for _, substore := range store.store {
+			for key := range substore {
+				if core.InNamespace(key, namespace) {
+					delete(substore, key)
+				}
+			}
+		}
+	}
+	for pod := range this.podIncarnations {
+		if strings.HasPrefix(pod, namespace+"/") {
+			delete(this.podIncarnations, pod)
+		}
+	}
 }
 
+// GetPodIncarnations returns all known incarnations of the given pod, oldest first.
+func (this *MetricSink) GetPodIncarnations(namespace, pod string) []PodIncarnation {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	incarnations := this.podIncarnations[namespace+"/"+pod]
+	result := make([]PodIncarnation, 0, len(incarnations))
+	for _, incarnation := range incarnations {
+		result = append(result, *incarnation)
+	}
+	return result
+}
+
+// GetLatestDataBatch returns the latest batch holding all objects, skipping partial batches.
 func (this *MetricSink) GetLatestDataBatch() *core.DataBatch {
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
-	if len(this.shortStore) == 0 {
-		return nil
+	for i := len(this.shortStore) - 1; i >= 0; i-- {
+		if !this.shortStore[i].Partial {
+			return this.shortStore[i]
+		}
 	}
-	return this.shortStore[len(this.shortStore)-1]
+	return nil
+}
+
+// ReadBack returns the whole batch of the timestamp kept in the short store, for the end-to-end
+// checks.
+func (this *MetricSink) ReadBack(timestamp time.Time) (*core.DataBatch, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for i := len(this.shortStore) - 1; i >= 0; i-- {
+		if batch := this.shortStore[i]; !batch.Partial && batch.Timestamp.Equal(timestamp) {
+			return batch, nil
+		}
+	}
+	return nil, fmt.Errorf("no batch of %s in the short store", timestamp)
 }
 
 func (this *MetricSink) GetShortStore() []*core.DataBatch {
@@ -265,6 +406,28 @@ func (this *MetricSink) GetNodes() []string {
 		func(key string, ms *core.MetricSet) string { return ms.Labels[core.LabelHostname.Key] })
 }
 
+// GetEntities returns the names of the registered non-Kubernetes entities of the given type
+// whose labels match ancestors, e.g. the names of the ancestors' name labels.
+func (this *MetricSink) GetEntities(metricSetType string, ancestors map[string]string) []string {
+	entityType, found := core.GetEntityType(metricSetType)
+	if !found {
+		return []string{}
+	}
+	return this.getAllNames(
+		func(ms *core.MetricSet) bool {
+			if ms.Labels[core.LabelMetricSetType.Key] != metricSetType {
+				return false
+			}
+			for k, v := range ancestors {
+				if ms.Labels[k] != v {
+					return false
+				}
+			}
+			return true
+		},
+		func(key string, ms *core.MetricSet) string { return ms.Labels[entityType.NameLabel.Key] })
+}
+
 func (this *MetricSink) GetPods() []string {
 	return this.getAllNames(
 		func(ms *core.MetricSet) bool { return ms.Labels[core.LabelMetricSetType.Key] == core.MetricSetTypePod },
@@ -292,6 +455,16 @@ func (this *MetricSink) GetPodsFromNamespace(namespace string) []string {
 		})
 }
 
+// GetPodKey returns the key of the MetricSet of the latest incarnation of the given pod. If the
+// pod is not known, the name based key is returned.
+func (this *MetricSink) GetPodKey(namespace, pod string) string {
+	incarnations := this.GetPodIncarnations(namespace, pod)
+	if len(incarnations) == 0 {
+		return core.PodKey(namespace, pod)
+	}
+	return incarnations[len(incarnations)-1].Key
+}
+
 func (this *MetricSink) GetContainersForPodFromNamespace(namespace, pod string) []string {
 	return this.getAllNames(
 		func(ms *core.MetricSet) bool {
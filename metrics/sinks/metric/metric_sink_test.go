@@ -15,6 +15,7 @@
 package metric
 
 import (
+	"sort"
 	"testing"
 	"time"
 
@@ -262,3 +263,123 @@ func TestGetNames(t *testing.T) {
 	assert.Contains(t, metrics.GetMetricSetKeys(), key)
 	assert.Contains(t, metrics.GetMetricSetKeys(), otherKey)
 }
+
+func TestGetPodIncarnations(t *testing.T) {
+	now := time.Now()
+	podBatch := func(timestamp time.Time, uid string) *core.DataBatch {
+		return &core.DataBatch{
+			Timestamp: timestamp,
+			MetricSets: map[string]*core.MetricSet{
+				core.PodKey("ns1", "pod1"): {
+					Labels: map[string]string{
+						core.LabelMetricSetType.Key: core.MetricSetTypePod,
+						core.LabelNamespaceName.Key: "ns1",
+						core.LabelPodName.Key:       "pod1",
+						core.LabelPodId.Key:         uid,
+					},
+					MetricValues: map[string]core.MetricValue{},
+				},
+			},
+		}
+	}
+
+	metrics := NewMetricSink(time.Hour, time.Hour, []string{})
+	assert.Equal(t, core.PodKey("ns1", "pod1"), metrics.GetPodKey("ns1", "pod1"))
+	assert.Empty(t, metrics.GetPodIncarnations("ns1", "pod1"))
+
+	metrics.ExportData(podBatch(now.Add(-3*time.Minute), "uid1"))
+	metrics.ExportData(podBatch(now.Add(-2*time.Minute), "uid1"))
+	metrics.ExportData(podBatch(now.Add(-time.Minute), "uid2"))
+
+	incarnations := metrics.GetPodIncarnations("ns1", "pod1")
+	assert.Equal(t, []PodIncarnation{
+		{
+			Key:       core.PodKey("ns1", "pod1"),
+			UID:       "uid1",
+			FirstSeen: now.Add(-3 * time.Minute),
+			LastSeen:  now.Add(-2 * time.Minute),
+		},
+		{
+			Key:       core.PodKey("ns1", "pod1"),
+			UID:       "uid2",
+			FirstSeen: now.Add(-time.Minute),
+			LastSeen:  now.Add(-time.Minute),
+		},
+	}, incarnations)
+
+	// Incarnations are forgotten once they are older than the long store.
+	metrics.longStoreDuration = 90 * time.Second
+	metrics.ExportData(podBatch(now, "uid2"))
+	incarnations = metrics.GetPodIncarnations("ns1", "pod1")
+	assert.Len(t, incarnations, 1)
+	assert.Equal(t, "uid2", incarnations[0].UID)
+	assert.Equal(t, now, incarnations[0].LastSeen)
+}
+
+func TestDeleteNamespace(t *testing.T) {
+	now := time.Now()
+	podMetricSet := func(namespace, metricSetType string) *core.MetricSet {
+		return &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: metricSetType,
+				core.LabelNamespaceName.Key: namespace,
+				core.LabelPodName.Key:       "pod1",
+			},
+			MetricValues: map[string]core.MetricValue{
+				"m1": {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1},
+			},
+		}
+	}
+	batch := &core.DataBatch{
+		Timestamp: now,
+		MetricSets: map[string]*core.MetricSet{
+			core.NamespaceKey("ns1"):                   {Labels: map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeNamespace, core.LabelNamespaceName.Key: "ns1"}},
+			core.PodKey("ns1", "pod1"):                 podMetricSet("ns1", core.MetricSetTypePod),
+			core.PodContainerKey("ns1", "pod1", "c1"):  podMetricSet("ns1", core.MetricSetTypePodContainer),
+			core.PodKey("ns10", "pod1"):                podMetricSet("ns10", core.MetricSetTypePod),
+			core.PodContainerKey("ns10", "pod1", "c1"): podMetricSet("ns10", core.MetricSetTypePodContainer),
+		},
+	}
+
+	metrics := NewMetricSink(time.Hour, time.Hour, []string{"m1"})
+	metrics.ExportData(batch)
+	metrics.DeleteNamespace("ns1")
+
+	assert.Equal(t, []string{core.PodKey("ns10", "pod1"), core.PodContainerKey("ns10", "pod1", "c1")},
+		sortedKeys(metrics.GetLatestDataBatch().MetricSets))
+	// The exported batch is not modified.
+	assert.Len(t, batch.MetricSets, 5)
+
+	values := metrics.GetMetric("m1", []string{core.PodKey("ns1", "pod1"), core.PodKey("ns10", "pod1")},
+		now.Add(-time.Minute), now)
+	assert.Len(t, values, 1)
+	assert.Contains(t, values, core.PodKey("ns10", "pod1"))
+	assert.Empty(t, metrics.GetPodIncarnations("ns1", "pod1"))
+	assert.Len(t, metrics.GetPodIncarnations("ns10", "pod1"), 1)
+}
+
+func sortedKeys(metricSets map[string]*core.MetricSet) []string {
+	keys := make([]string, 0, len(metricSets))
+	for key := range metricSets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestReadBack(t *testing.T) {
+	now := time.Now()
+	batch1, batch2, _ := makeBatches(now, "key", "other")
+	partial := core.DataBatch{Timestamp: batch2.Timestamp, Partial: true}
+	metrics := NewMetricSink(120*time.Second, 240*time.Second, []string{"m1"})
+	metrics.ExportData(&batch1)
+	metrics.ExportData(&batch2)
+	metrics.ExportData(&partial)
+
+	stored, err := metrics.ReadBack(batch2.Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, &batch2, stored)
+
+	_, err = metrics.ReadBack(now)
+	assert.Error(t, err)
+}
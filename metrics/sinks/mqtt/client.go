@@ -0,0 +1,273 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// The client side of MQTT 3.1.1 needed to publish, from
+// http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/mqtt-v3.1.1.html, as no MQTT client is vendored.
+
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPuback     = 4
+	packetPubrec     = 5
+	packetPubrel     = 6
+	packetPubcomp    = 7
+	packetDisconnect = 14
+
+	protocolLevel      = 4
+	flagCleanSession   = 0x02
+	flagPassword       = 0x40
+	flagUsername       = 0x80
+	publishRetain      = 0x01
+	pubrelFixedFlags   = 0x02
+	maxRemainingLength = 268435455
+	// Messages of QoS 1 and 2 published before waiting for acknowledgements, well below the
+	// number of packet identifiers and the receive maximum of common brokers.
+	maxInFlight = 100
+)
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func appendString(buffer *bytes.Buffer, value string) {
+	binary.Write(buffer, binary.BigEndian, uint16(len(value)))
+	buffer.WriteString(value)
+}
+
+func writePacket(w *bufio.Writer, kind, flags byte, body []byte) error {
+	if len(body) > maxRemainingLength {
+		return fmt.Errorf("packet of %d bytes exceeds the maximum size of MQTT", len(body))
+	}
+	w.WriteByte(kind<<4 | flags)
+	// The remaining length is encoded 7 bits at a time, lowest first.
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		w.WriteByte(digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return nil, fmt.Errorf("malformed remaining length")
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// mqttConn is a connection to an MQTT broker, used to publish only.
+type mqttConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	timeout  time.Duration
+	packetId uint16
+	// Identifiers of the messages published with QoS 1 or 2 and not yet acknowledged.
+	pending map[uint16]bool
+}
+
+// dialMqtt connects to the broker with a clean session, upgrading the connection to TLS if
+// tlsConfig is set, and authenticates with the user and password if set.
+func dialMqtt(address string, tlsConfig *tls.Config, clientId, user, password string, timeout time.Duration) (*mqttConn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		conn = tls.Client(conn, config)
+	}
+	this := &mqttConn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		writer:  bufio.NewWriter(conn),
+		timeout: timeout,
+		pending: map[uint16]bool{},
+	}
+	if err := this.connect(clientId, user, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return this, nil
+}
+
+func (this *mqttConn) connect(clientId, user, password string) error {
+	this.conn.SetDeadline(time.Now().Add(this.timeout))
+	var body bytes.Buffer
+	appendString(&body, "MQTT")
+	body.WriteByte(protocolLevel)
+	flags := byte(flagCleanSession)
+	if user != "" {
+		flags |= flagUsername
+		if password != "" {
+			flags |= flagPassword
+		}
+	}
+	body.WriteByte(flags)
+	// No keep alive: the broker does not disconnect the sink between batches.
+	binary.Write(&body, binary.BigEndian, uint16(0))
+	appendString(&body, clientId)
+	if user != "" {
+		appendString(&body, user)
+		if password != "" {
+			appendString(&body, password)
+		}
+	}
+	writePacket(this.writer, packetConnect, 0, body.Bytes())
+	if err := this.writer.Flush(); err != nil {
+		return err
+	}
+	connack, err := readPacket(this.reader)
+	if err != nil {
+		return err
+	}
+	if connack.kind != packetConnack || len(connack.body) != 2 {
+		return fmt.Errorf("expected CONNACK from the broker, got packet of type %d", connack.kind)
+	}
+	if code := connack.body[1]; code != 0 {
+		if message, found := connackErrors[code]; found {
+			return fmt.Errorf("connection refused: %s", message)
+		}
+		return fmt.Errorf("connection refused with code %d", code)
+	}
+	return nil
+}
+
+// Publish buffers a message, sent by the next Flush. With QoS 1 and 2, once maxInFlight messages
+// are not acknowledged yet, it first sends the buffered messages and waits for acknowledgements.
+func (this *mqttConn) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	var body bytes.Buffer
+	appendString(&body, topic)
+	if qos > 0 {
+		if len(this.pending) >= maxInFlight {
+			if err := this.awaitAcks(maxInFlight - 1); err != nil {
+				return err
+			}
+		}
+		// Identifiers of messages not acknowledged yet are not reused.
+		for {
+			this.packetId++
+			if this.packetId != 0 && !this.pending[this.packetId] {
+				break
+			}
+		}
+		binary.Write(&body, binary.BigEndian, this.packetId)
+		this.pending[this.packetId] = true
+	}
+	body.Write(payload)
+	flags := qos << 1
+	if retain {
+		flags |= publishRetain
+	}
+	return writePacket(this.writer, packetPublish, flags, body.Bytes())
+}
+
+// Flush sends the buffered messages and, for QoS 1 and 2, waits for the broker to acknowledge
+// them.
+func (this *mqttConn) Flush() error {
+	return this.awaitAcks(0)
+}
+
+// awaitAcks sends the buffered messages and reads the acknowledgements of the broker until at
+// most limit messages are not acknowledged, releasing the messages of QoS 2 on reception.
+func (this *mqttConn) awaitAcks(limit int) error {
+	this.conn.SetDeadline(time.Now().Add(this.timeout))
+	if err := this.writer.Flush(); err != nil {
+		return err
+	}
+	for len(this.pending) > limit {
+		ack, err := readPacket(this.reader)
+		if err != nil {
+			return err
+		}
+		if len(ack.body) < 2 {
+			return fmt.Errorf("malformed packet of type %d", ack.kind)
+		}
+		id := binary.BigEndian.Uint16(ack.body)
+		switch ack.kind {
+		case packetPuback, packetPubcomp:
+			delete(this.pending, id)
+		case packetPubrec:
+			writePacket(this.writer, packetPubrel, pubrelFixedFlags, ack.body[:2])
+			if err := this.writer.Flush(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected packet of type %d", ack.kind)
+		}
+	}
+	return nil
+}
+
+func (this *mqttConn) Close() error {
+	this.conn.SetDeadline(time.Now().Add(this.timeout))
+	writePacket(this.writer, packetDisconnect, 0, nil)
+	this.writer.Flush()
+	return this.conn.Close()
+}
@@ -0,0 +1,320 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mqtt implements a sink publishing the metrics to the topics of an MQTT broker, as a
+// JSON message per metric set or a plain value per metric, e.g. for edge clusters reporting to
+// an IoT platform.
+package mqtt
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	defaultTimeout        = 10 * time.Second
+	defaultCluster        = "heapster"
+	defaultMetricSetTopic = `{{.Cluster}}/{{.Key}}`
+	defaultMetricTopic    = `{{.Cluster}}/{{.Key}}/{{.Metric}}{{if .Resource}}/{{.Resource}}{{end}}`
+	payloadMetricSet      = "metricset"
+	payloadMetric         = "metric"
+)
+
+// Topic is passed to the topic template, for each metric set or metric.
+type Topic struct {
+	// The cluster option of the sink.
+	Cluster string
+	// Key of the metric set, e.g. namespace:default/pod:web-1.
+	Key       string
+	Type      string
+	Namespace string
+	Pod       string
+	Container string
+	Node      string
+	// Name of the metric, e.g. cpu/usage, and the resource of a labeled metric, e.g. a device.
+	// Empty with metric set payloads.
+	Metric   string
+	Resource string
+}
+
+// metricSetPayload is the JSON payload of a metric set.
+type metricSetPayload struct {
+	Timestamp      time.Time              `json:"timestamp"`
+	Labels         map[string]string      `json:"labels,omitempty"`
+	Metrics        map[string]interface{} `json:"metrics,omitempty"`
+	LabeledMetrics []labeledMetric        `json:"labeledMetrics,omitempty"`
+	// ID of the batch of the metric set, see core.DataBatch.
+	BatchID string `json:"batchId,omitempty"`
+	// Cluster and replica of the dedup key of the batch, if set.
+	Cluster string `json:"cluster,omitempty"`
+	Replica string `json:"replica,omitempty"`
+}
+
+type labeledMetric struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  interface{}       `json:"value"`
+}
+
+type message struct {
+	topic   string
+	payload []byte
+}
+
+type mqttSink struct {
+	sync.Mutex
+	address     string
+	tlsConfig   *tls.Config
+	clientId    string
+	credentials *credentials.Credentials
+	timeout     time.Duration
+	conn        *mqttConn
+
+	cluster string
+	topic   *template.Template
+	// Whether a message is published per metric, with the value as payload, instead of per
+	// metric set.
+	perMetric bool
+	qos       byte
+	retain    bool
+}
+
+func (this *mqttSink) Name() string {
+	return "MQTT Sink"
+}
+
+func (this *mqttSink) Stop() {
+	this.Lock()
+	defer this.Unlock()
+	this.disconnect()
+}
+
+func (this *mqttSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	messages := []message{}
+	for key, metricSet := range batch.MetricSets {
+		if this.perMetric {
+			messages = this.appendMetrics(messages, batch, key, metricSet)
+			continue
+		}
+		topic, err := this.topicOf(key, metricSet, "", "")
+		if err != nil {
+			glog.Errorf("[batch %s] Failed to render the MQTT topic of %s: %v", batch.ID, key, err)
+			continue
+		}
+		payload := metricSetPayload{
+			Timestamp: batch.Timestamp.UTC(),
+			BatchID:   batch.ID,
+			Labels:    metricSet.Labels,
+			Metrics:   map[string]interface{}{},
+		}
+		if batch.DedupKey != nil {
+			payload.Cluster, payload.Replica = batch.DedupKey.Cluster, batch.DedupKey.Replica
+		}
+		for name, value := range metricSet.MetricValues {
+			payload.Metrics[name] = value.GetValue()
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			payload.LabeledMetrics = append(payload.LabeledMetrics, labeledMetric{Name: metric.Name, Labels: metric.Labels, Value: metric.GetValue()})
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			glog.Errorf("[batch %s] Failed to encode the metrics of %s: %v", batch.ID, key, err)
+			continue
+		}
+		messages = append(messages, message{topic: topic, payload: body})
+	}
+	if err := this.publish(messages); err != nil {
+		glog.Errorf("[batch %s] Failed to publish %d messages to MQTT broker %s: %v", batch.ID, len(messages), this.address, err)
+	}
+}
+
+func (this *mqttSink) appendMetrics(messages []message, batch *core.DataBatch, key string, metricSet *core.MetricSet) []message {
+	add := func(name, resource string, value core.MetricValue) {
+		topic, err := this.topicOf(key, metricSet, name, resource)
+		if err != nil {
+			glog.Errorf("[batch %s] Failed to render the MQTT topic of %s of %s: %v", batch.ID, name, key, err)
+			return
+		}
+		messages = append(messages, message{topic: topic, payload: []byte(formatValue(value))})
+	}
+	for name, value := range metricSet.MetricValues {
+		add(name, "", value)
+	}
+	for _, metric := range metricSet.LabeledMetrics {
+		add(metric.Name, metric.Labels[core.LabelResourceID.Key], metric.MetricValue)
+	}
+	return messages
+}
+
+func formatValue(value core.MetricValue) string {
+	if value.ValueType == core.ValueFloat {
+		return strconv.FormatFloat(value.FloatValue, 'f', -1, 64)
+	}
+	return strconv.FormatInt(value.IntValue, 10)
+}
+
+// topicOf renders the topic of a metric set, or of one of its metrics, which must be a valid
+// topic to publish to.
+func (this *mqttSink) topicOf(key string, metricSet *core.MetricSet, metric, resource string) (string, error) {
+	var topic bytes.Buffer
+	err := this.topic.Execute(&topic, Topic{
+		Cluster:   this.cluster,
+		Key:       key,
+		Type:      metricSet.Labels[core.LabelMetricSetType.Key],
+		Namespace: metricSet.Labels[core.LabelNamespaceName.Key],
+		Pod:       metricSet.Labels[core.LabelPodName.Key],
+		Container: metricSet.Labels[core.LabelContainerName.Key],
+		Node:      metricSet.Labels[core.LabelNodename.Key],
+		Metric:    metric,
+		Resource:  strings.Trim(resource, "/"),
+	})
+	if err != nil {
+		return "", err
+	}
+	name := topic.String()
+	if name == "" || strings.ContainsAny(name, "+#\x00") {
+		return "", fmt.Errorf("invalid topic %q", name)
+	}
+	return name, nil
+}
+
+// publish publishes the messages, reconnecting once if the connection was lost.
+func (this *mqttSink) publish(messages []message) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if this.conn == nil {
+			values := this.credentials.Get()
+			if this.conn, err = dialMqtt(this.address, this.tlsConfig, this.clientId, values.User, values.Password, this.timeout); err != nil {
+				return err
+			}
+		}
+		for _, m := range messages {
+			if err = this.conn.Publish(m.topic, m.payload, this.qos, this.retain); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			if err = this.conn.Flush(); err == nil {
+				return nil
+			}
+		}
+		this.disconnect()
+	}
+	return err
+}
+
+func (this *mqttSink) disconnect() {
+	if this.conn != nil {
+		this.conn.Close()
+		this.conn = nil
+	}
+}
+
+// NewMqttSink creates a sink for uris of the form tcp://host:1883 or ssl://host:8883, with the
+// topic, payload, cluster, qos, retain, clientId, user, password, insecure and timeout options.
+func NewMqttSink(uri *url.URL) (core.DataSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing address of the MQTT broker")
+	}
+	opts := uri.Query()
+	sink := &mqttSink{
+		address: uri.Host,
+		timeout: defaultTimeout,
+		cluster: defaultCluster,
+	}
+	switch uri.Scheme {
+	case "tcp", "mqtt":
+		if uri.Port() == "" {
+			sink.address += ":1883"
+		}
+	case "ssl", "tls", "mqtts":
+		if uri.Port() == "" {
+			sink.address += ":8883"
+		}
+		sink.tlsConfig = &tls.Config{}
+		if value := opts.Get("insecure"); value != "" {
+			insecure, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid insecure option %q: %v", value, err)
+			}
+			sink.tlsConfig.InsecureSkipVerify = insecure
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q, expected tcp or ssl", uri.Scheme)
+	}
+
+	var err error
+	if sink.credentials, err = credentials.New(opts, "user", "password"); err != nil {
+		return nil, err
+	}
+	sink.clientId = opts.Get("clientId")
+	if sink.clientId == "" {
+		hostname, _ := os.Hostname()
+		sink.clientId = "heapster-" + hostname
+	}
+	if value := opts.Get("cluster"); value != "" {
+		sink.cluster = value
+	}
+	if value := opts.Get("qos"); value != "" {
+		qos, err := strconv.Atoi(value)
+		if err != nil || qos < 0 || qos > 2 {
+			return nil, fmt.Errorf("invalid qos %q, expected 0, 1 or 2", value)
+		}
+		sink.qos = byte(qos)
+	}
+	if value := opts.Get("retain"); value != "" {
+		if sink.retain, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid retain option %q: %v", value, err)
+		}
+	}
+	topic := defaultMetricSetTopic
+	switch payload := opts.Get("payload"); payload {
+	case "", payloadMetricSet:
+	case payloadMetric:
+		sink.perMetric = true
+		topic = defaultMetricTopic
+	default:
+		return nil, fmt.Errorf("invalid payload %q, expected metricset or metric", payload)
+	}
+	if value := opts.Get("topic"); value != "" {
+		topic = value
+	}
+	if sink.topic, err = template.New("topic").Parse(topic); err != nil {
+		return nil, fmt.Errorf("failed to parse topic template: %v", err)
+	}
+	if value := opts.Get("timeout"); value != "" {
+		if sink.timeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("failed to parse timeout: %v", err)
+		}
+	}
+
+	glog.Infof("Created MQTT sink publishing to %s://%s with QoS %d", uri.Scheme, sink.address, sink.qos)
+	return sink, nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+)
+
+type published struct {
+	qos     byte
+	retain  bool
+	payload string
+}
+
+// fakeBroker is an MQTT broker recording the connections and the published messages by topic.
+type fakeBroker struct {
+	sync.Mutex
+	listener net.Listener
+	// Client id, user and password of the connections.
+	connects [][3]string
+	messages map[string]published
+	released int
+	// Return code of the CONNACK.
+	refuse byte
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	this := &fakeBroker{listener: listener, messages: map[string]published{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go this.serve(conn)
+		}
+	}()
+	return this
+}
+
+func readString(body []byte) (string, []byte) {
+	length := binary.BigEndian.Uint16(body)
+	return string(body[2 : 2+length]), body[2+length:]
+}
+
+func (this *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		p, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		this.Lock()
+		switch p.kind {
+		case packetConnect:
+			protocol, rest := readString(p.body)
+			flags := rest[1]
+			var connect [3]string
+			connect[0], rest = readString(rest[4:])
+			if flags&flagUsername != 0 {
+				connect[1], rest = readString(rest)
+			}
+			if flags&flagPassword != 0 {
+				connect[2], rest = readString(rest)
+			}
+			if protocol == "MQTT" {
+				this.connects = append(this.connects, connect)
+			}
+			writePacket(writer, packetConnack, 0, []byte{0, this.refuse})
+		case packetPublish:
+			qos := (p.flags >> 1) & 3
+			topic, rest := readString(p.body)
+			if qos > 0 {
+				id := rest[:2]
+				rest = rest[2:]
+				if qos == 1 {
+					writePacket(writer, packetPuback, 0, id)
+				} else {
+					writePacket(writer, packetPubrec, 0, id)
+				}
+			}
+			this.messages[topic] = published{qos: qos, retain: p.flags&publishRetain != 0, payload: string(rest)}
+		case packetPubrel:
+			this.released++
+			writePacket(writer, packetPubcomp, 0, p.body)
+		}
+		writer.Flush()
+		this.Unlock()
+	}
+}
+
+func newSink(t *testing.T, broker *fakeBroker, options string) *mqttSink {
+	uri, err := url.Parse(fmt.Sprintf("tcp://%s?clientId=edge-1&%s", broker.listener.Addr(), options))
+	require.NoError(t, err)
+	sink, err := NewMqttSink(uri)
+	require.NoError(t, err)
+	return sink.(*mqttSink)
+}
+
+func TestExportDataMetricSets(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.listener.Close()
+	sink := newSink(t, broker, "user=heapster&password=secret&qos=1&retain=true&cluster=edge")
+	defer sink.Stop()
+
+	sink.ExportData(sinktest.Batch())
+	broker.Lock()
+	defer broker.Unlock()
+	assert.Equal(t, [][3]string{{"edge-1", "heapster", "secret"}}, broker.connects)
+	require.Equal(t, 2, len(broker.messages))
+
+	pod, found := broker.messages["edge/namespace:default/pod:web-1"]
+	require.True(t, found)
+	assert.Equal(t, byte(1), pod.qos)
+	assert.True(t, pod.retain)
+	payload := metricSetPayload{}
+	require.NoError(t, json.Unmarshal([]byte(pod.payload), &payload))
+	assert.Equal(t, float64(100), payload.Metrics[core.MetricMemoryUsage.Name])
+	assert.Equal(t, "web-1", payload.Labels[core.LabelPodName.Key])
+	assert.True(t, time.Unix(1500000000, 0).Equal(payload.Timestamp))
+	assert.Equal(t, sinktest.BatchID, payload.BatchID)
+	assert.Equal(t, "prod", payload.Cluster)
+	assert.Equal(t, "heapster-1", payload.Replica)
+
+	node, found := broker.messages["edge/node:node-1"]
+	require.True(t, found)
+	payload = metricSetPayload{}
+	require.NoError(t, json.Unmarshal([]byte(node.payload), &payload))
+	assert.Equal(t, map[string]interface{}{core.MetricCpuUsage.Name: float64(100), core.MetricMemoryUsage.Name: float64(1024)}, payload.Metrics)
+	assert.Equal(t, []labeledMetric{{Name: core.MetricFilesystemUsage.Name, Labels: map[string]string{core.LabelResourceID.Key: "/dev/sda1"}, Value: 0.5}}, payload.LabeledMetrics)
+}
+
+func TestExportDataMetrics(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.listener.Close()
+	sink := newSink(t, broker, "payload=metric&qos=2")
+	defer sink.Stop()
+
+	sink.ExportData(sinktest.Batch())
+	broker.Lock()
+	defer broker.Unlock()
+	assert.Equal(t, map[string]published{
+		"heapster/namespace:default/pod:web-1/memory/usage": {qos: 2, payload: "100"},
+		"heapster/node:node-1/cpu/usage":                    {qos: 2, payload: "100"},
+		"heapster/node:node-1/memory/usage":                 {qos: 2, payload: "1024"},
+		"heapster/node:node-1/filesystem/usage/dev/sda1":    {qos: 2, payload: "0.5"},
+	}, broker.messages)
+	// All the messages of QoS 2 were released.
+	assert.Equal(t, 4, broker.released)
+}
+
+func TestConnectionRefused(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.listener.Close()
+	broker.refuse = 5
+	sink := newSink(t, broker, "")
+	defer sink.Stop()
+
+	err := sink.publish([]message{{topic: "topic", payload: []byte("1")}})
+	assert.EqualError(t, err, "connection refused: not authorized")
+	assert.Nil(t, sink.conn)
+}
+
+func TestPublishBoundsInFlightMessages(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.listener.Close()
+	conn, err := dialMqtt(broker.listener.Addr().String(), nil, "edge-1", "", "", 10*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// More messages than packet identifiers, whose acknowledgements would fill the buffers if
+	// they were not read while publishing.
+	for i := 0; i < 70000; i++ {
+		require.NoError(t, conn.Publish(fmt.Sprintf("heapster/%d", i), []byte("1"), 1, false))
+		require.True(t, len(conn.pending) <= maxInFlight, "%d messages in flight", len(conn.pending))
+	}
+	require.NoError(t, conn.Flush())
+	assert.Empty(t, conn.pending)
+	broker.Lock()
+	defer broker.Unlock()
+	assert.Equal(t, 70000, len(broker.messages))
+}
+
+func TestRemainingLength(t *testing.T) {
+	for _, length := range []int{0, 127, 128, 16383, 16384, 2097151, 2097152} {
+		var buffer bytes.Buffer
+		writer := bufio.NewWriter(&buffer)
+		require.NoError(t, writePacket(writer, packetPublish, 0, make([]byte, length)))
+		writer.Flush()
+		p, err := readPacket(bufio.NewReader(&buffer))
+		require.NoError(t, err)
+		assert.Equal(t, length, len(p.body))
+	}
+}
+
+func TestNewMqttSink(t *testing.T) {
+	for _, uri := range []string{
+		"tcp://",
+		"ws://broker",
+		"tcp://broker?qos=3",
+		"tcp://broker?retain=maybe",
+		"tcp://broker?payload=csv",
+		"tcp://broker?topic={{.Key",
+		"ssl://broker?insecure=maybe",
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewMqttSink(parsed)
+		assert.Error(t, err, uri)
+	}
+	parsed, err := url.Parse("ssl://broker?insecure=true&payload=metric")
+	require.NoError(t, err)
+	sink, err := NewMqttSink(parsed)
+	require.NoError(t, err)
+	assert.Equal(t, "broker:8883", sink.(*mqttSink).address)
+	assert.True(t, sink.(*mqttSink).tlsConfig.InsecureSkipVerify)
+
+	_, err = sink.(*mqttSink).topicOf("node:node-1", &core.MetricSet{}, "cpu/usage", "#")
+	assert.Error(t, err)
+}
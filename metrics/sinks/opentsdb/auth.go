@@ -0,0 +1,95 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	opentsdbclient "github.com/bluebreezecf/opentsdb-goclient/client"
+	"k8s.io/heapster/common/credentials"
+)
+
+const authenticatedClientTimeout = 30 * time.Second
+
+// authenticatedClient writes datapoints to an OpenTSDB server behind an authenticating proxy.
+// OpenTSDB does not authenticate requests itself, so the OpenTSDB client library cannot send
+// credentials.
+type authenticatedClient struct {
+	endpoint    string
+	host        string
+	credentials *credentials.Credentials
+	client      *http.Client
+}
+
+func newAuthenticatedClient(scheme, host string, creds *credentials.Credentials) *authenticatedClient {
+	return &authenticatedClient{
+		endpoint:    fmt.Sprintf("%s://%s%s", scheme, host, opentsdbclient.PutPath),
+		host:        host,
+		credentials: creds,
+		client:      &http.Client{Timeout: authenticatedClientTimeout},
+	}
+}
+
+func (this *authenticatedClient) Ping() error {
+	conn, err := net.DialTimeout("tcp", this.host, opentsdbclient.DefaultDialTimeout)
+	if err != nil {
+		return fmt.Errorf("the target OpenTSDB is unreachable: %v", err)
+	}
+	return conn.Close()
+}
+
+func (this *authenticatedClient) Put(datapoints []opentsdbclient.DataPoint, queryParam string) (*opentsdbclient.PutResponse, error) {
+	body, err := json.Marshal(datapoints)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := this.endpoint
+	if queryParam != "" {
+		endpoint += "?" + queryParam
+	}
+	req, err := http.NewRequest(opentsdbclient.PostMethod, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	this.credentials.Authorize(req)
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	putResp := &opentsdbclient.PutResponse{}
+	putResp.SetStatus(resp.StatusCode)
+	if resp.StatusCode/100 != 2 {
+		return putResp, fmt.Errorf("put to %s failed with status %s", this.endpoint, resp.Status)
+	}
+	if len(contents) > 0 {
+		if err := json.Unmarshal(contents, putResp); err != nil {
+			return putResp, fmt.Errorf("failed to parse the response of %s: %v", this.endpoint, err)
+		}
+	}
+	return putResp, nil
+}
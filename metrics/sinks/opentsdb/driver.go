@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +26,7 @@ import (
 	opentsdbclient "github.com/bluebreezecf/opentsdb-goclient/client"
 	opentsdbcfg "github.com/bluebreezecf/opentsdb-goclient/config"
 	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
 	"k8s.io/heapster/metrics/core"
 )
 
@@ -59,21 +61,30 @@ type openTSDBSink struct {
 	writeFailures int
 	clusterName   string
 	host          string
+	// Set when the metrics are described in the UID metadata of OpenTSDB.
+	metadataClient metadataClient
+	// Names of the series whose metric was described already.
+	described map[string]bool
 }
 
 func (tsdbSink *openTSDBSink) ExportData(data *core.DataBatch) {
 	if err := tsdbSink.client.Ping(); err != nil {
-		glog.Warningf("Failed to ping opentsdb: %v", err)
+		glog.Warningf("[batch %s] Failed to ping opentsdb: %v", data.ID, err)
 		return
 	}
 	dataPoints := make([]opentsdbclient.DataPoint, 0, batchSize)
+	pendingMetadata := map[string]core.MetricDescriptor{}
 	for _, metricSet := range data.MetricSets {
 		for metricName, metricValue := range metricSet.MetricValues {
-			dataPoints = append(dataPoints, tsdbSink.metricToPoint(metricName, metricValue, data.Timestamp, metricSet.Labels))
+			dataPoint := tsdbSink.metricToPoint(metricName, metricValue, data.Timestamp, metricSet.Labels)
+			dataPoints = append(dataPoints, dataPoint)
+			if tsdbSink.metadataClient != nil {
+				tsdbSink.addMetadata(dataPoint.Metric, metricName, pendingMetadata)
+			}
 			if len(dataPoints) >= batchSize {
 				_, err := tsdbSink.client.Put(dataPoints, opentsdbclient.PutRespWithSummary)
 				if err != nil {
-					glog.Errorf("failed to write metrics to opentsdb - %v", err)
+					glog.Errorf("[batch %s] failed to write metrics to opentsdb - %v", data.ID, err)
 					tsdbSink.recordWriteFailure()
 					return
 				}
@@ -84,11 +95,14 @@ func (tsdbSink *openTSDBSink) ExportData(data *core.DataBatch) {
 	if len(dataPoints) >= 0 {
 		_, err := tsdbSink.client.Put(dataPoints, opentsdbclient.PutRespWithSummary)
 		if err != nil {
-			glog.Errorf("failed to write metrics to opentsdb - %v", err)
+			glog.Errorf("[batch %s] failed to write metrics to opentsdb - %v", data.ID, err)
 			tsdbSink.recordWriteFailure()
 			return
 		}
 	}
+	if tsdbSink.metadataClient != nil {
+		tsdbSink.writeMetadata(pendingMetadata)
+	}
 }
 
 func (tsdbSink *openTSDBSink) Name() string {
@@ -183,16 +197,41 @@ func CreateOpenTSDBSink(uri *url.URL) (core.DataSink, error) {
 		host = uri.Host
 	}
 
-	config := opentsdbcfg.OpenTSDBConfig{OpentsdbHost: host}
-	opentsdbClient, err := opentsdbclient.NewClient(config)
+	creds, err := credentials.New(uri.Query(), "user", "password")
 	if err != nil {
 		return nil, err
 	}
+	var client openTSDBClient
+	if creds.IsSet() {
+		scheme := "http"
+		if uri.Scheme == "https" {
+			scheme = uri.Scheme
+		}
+		client = newAuthenticatedClient(scheme, host, creds)
+	} else {
+		config := opentsdbcfg.OpenTSDBConfig{OpentsdbHost: host}
+		if client, err = opentsdbclient.NewClient(config); err != nil {
+			return nil, err
+		}
+	}
 
 	sink := &openTSDBSink{
-		client:      opentsdbClient,
+		client:      client,
 		clusterName: clusterName,
 		host:        host,
+		described:   map[string]bool{},
+	}
+	if len(uri.Query()["metadata"]) > 0 {
+		metadata, err := strconv.ParseBool(uri.Query()["metadata"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata option: %v", err)
+		}
+		if metadata {
+			if creds.IsSet() {
+				return nil, fmt.Errorf("metadata is not supported with credentials")
+			}
+			sink.metadataClient = client.(metadataClient)
+		}
 	}
 
 	glog.Infof("created opentsdb sink with host: %v, clusterName: %v", host, clusterName)
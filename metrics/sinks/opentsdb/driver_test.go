@@ -15,8 +15,12 @@
 package opentsdb
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sort"
 	"testing"
 	"time"
 
@@ -167,6 +171,35 @@ func TestCreateOpenTSDBSinkWithCustomValues(t *testing.T) {
 	}
 }
 
+func TestCreateOpenTSDBSinkWithCredentials(t *testing.T) {
+	var received []opentsdb.DataPoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "heapster" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, opentsdb.PutPath, r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		fmt.Fprintf(w, `{"failed": 0, "success": %d}`, len(received))
+	}))
+	defer server.Close()
+	uri, err := url.Parse(server.URL + "?user=heapster&password=secret")
+	assert.NoError(t, err)
+
+	sink, err := CreateOpenTSDBSink(uri)
+	assert.NoError(t, err)
+	batch := generateFakeBatch()
+	sink.ExportData(batch)
+	assert.Equal(t, len(batch.MetricSets), len(received))
+	assert.Equal(t, 0, sink.(*openTSDBSink).writeFailures)
+
+	uri.RawQuery = "user=heapster&password=wrong"
+	sink, err = CreateOpenTSDBSink(uri)
+	assert.NoError(t, err)
+	sink.ExportData(batch)
+	assert.Equal(t, 1, sink.(*openTSDBSink).writeFailures)
+}
+
 func generateFakeBatch() *core.DataBatch {
 	batch := core.DataBatch{
 		Timestamp:  time.Now(),
@@ -196,3 +229,60 @@ func generateMetricSet(name string, metricType core.MetricType, value int64) *co
 		},
 	}
 }
+
+func TestMetadata(t *testing.T) {
+	var assigned []string
+	described := map[string]opentsdb.UIDMetaData{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case opentsdb.PutPath:
+			w.Write([]byte(`{"failed": 0, "success": 3}`))
+		case opentsdb.UIDAssignPath:
+			var param opentsdb.UIDAssignParam
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&param))
+			assigned = append(assigned, param.Metric...)
+			// cpu_usage_cumulative exists already.
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"metric": {"uptime_cumulative": "000002"},
+				"metric_errors": {"cpu_usage_cumulative": "Name already exists with UID: 000001"}}`))
+		case opentsdb.UIDMetaDataPath:
+			var meta opentsdb.UIDMetaData
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&meta))
+			described[meta.Uid] = meta
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	sink, err := CreateOpenTSDBSink(&url.URL{Host: serverURL.Host, RawQuery: "metadata=true"})
+	assert.NoError(t, err)
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			"m1": generateMetricSet("cpu/usage", core.MetricCumulative, 43363664),
+			"m2": generateMetricSet("uptime", core.MetricCumulative, 910823),
+			"m3": generateMetricSet("custom/requests", core.MetricCumulative, 10),
+		},
+	}
+	sink.ExportData(batch)
+	sort.Strings(assigned)
+	assert.Equal(t, []string{"cpu_usage_cumulative", "uptime_cumulative"}, assigned)
+	assert.Equal(t, opentsdb.UIDMetaData{
+		Uid:         "000001",
+		Type:        "metric",
+		Description: core.MetricCpuUsage.Description,
+		Custom:      map[string]string{"type": "cumulative", "valueType": "int64", "units": "ns"},
+	}, described["000001"])
+	assert.Equal(t, core.MetricUptime.Description, described["000002"].Description)
+
+	// The metrics are described once.
+	sink.ExportData(batch)
+	assert.Len(t, assigned, 2)
+
+	_, err = CreateOpenTSDBSink(&url.URL{Host: serverURL.Host, RawQuery: "metadata=maybe"})
+	assert.Error(t, err)
+}
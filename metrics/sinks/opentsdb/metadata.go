@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opentsdb
+
+import (
+	"fmt"
+	"regexp"
+
+	opentsdbclient "github.com/bluebreezecf/opentsdb-goclient/client"
+	"github.com/golang/glog"
+	"k8s.io/heapster/metrics/core"
+)
+
+// Matches the UID of metrics which were assigned one already in the errors of /api/uid/assign.
+var existingUIDRegexp = regexp.MustCompile(`UID: ([0-9A-Fa-f]+)`)
+
+// metadataClient defines the methods used to describe the metrics in the UID metadata of
+// OpenTSDB, so that dashboards can show the description, type and units of the metrics.
+type metadataClient interface {
+	AssignUID(assignParam opentsdbclient.UIDAssignParam) (*opentsdbclient.UIDAssignResponse, error)
+	UpdateUIDMetaData(uidMetaData opentsdbclient.UIDMetaData) (*opentsdbclient.UIDMetaDataResponse, error)
+}
+
+// addMetadata records the descriptor of the series of a known metric, to be written by
+// writeMetadata unless it was already.
+func (tsdbSink *openTSDBSink) addMetadata(seriesName, metricName string, pending map[string]core.MetricDescriptor) {
+	if _, found := tsdbSink.described[seriesName]; found {
+		return
+	}
+	if descriptor, found := core.MetricDescriptorForName(metricName); found {
+		pending[seriesName] = descriptor
+	}
+}
+
+// writeMetadata writes the descriptors of the series to the UID metadata of their metric.
+// Series which fail are retried with the next batch.
+func (tsdbSink *openTSDBSink) writeMetadata(pending map[string]core.MetricDescriptor) {
+	if len(pending) == 0 {
+		return
+	}
+	names := make([]string, 0, len(pending))
+	for name := range pending {
+		names = append(names, name)
+	}
+	resp, err := tsdbSink.metadataClient.AssignUID(opentsdbclient.UIDAssignParam{Metric: names})
+	if err != nil {
+		glog.Warningf("Failed to get the UIDs of the metrics from opentsdb: %v", err)
+		return
+	}
+	for name, descriptor := range pending {
+		uid, err := metricUID(resp, name)
+		if err != nil {
+			glog.Warningf("Failed to describe metric %s in opentsdb: %v", name, err)
+			continue
+		}
+		_, err = tsdbSink.metadataClient.UpdateUIDMetaData(opentsdbclient.UIDMetaData{
+			Uid:         uid,
+			Type:        "metric",
+			Description: descriptor.Description,
+			Custom: map[string]string{
+				"type":      descriptor.Type.String(),
+				"valueType": descriptor.ValueType.String(),
+				"units":     descriptor.Units.String(),
+			},
+		})
+		if err != nil {
+			glog.Warningf("Failed to describe metric %s in opentsdb: %v", name, err)
+			continue
+		}
+		tsdbSink.described[name] = true
+	}
+}
+
+// metricUID returns the UID of the metric from the response of /api/uid/assign, which reports
+// the UIDs of metrics existing already as errors.
+func metricUID(resp *opentsdbclient.UIDAssignResponse, name string) (string, error) {
+	if uid, found := resp.Metric[name]; found {
+		return uid, nil
+	}
+	message, found := resp.MetricErrors[name]
+	if !found {
+		return "", fmt.Errorf("no UID assigned")
+	}
+	if match := existingUIDRegexp.FindStringSubmatch(message); match != nil {
+		return match[1], nil
+	}
+	return "", fmt.Errorf("failed to assign a UID: %s", message)
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/heapster/metrics/core"
+)
+
+// wholeBatchSink drops the partial batches, see core.DataBatch.Partial, for sinks which expect
+// every batch to hold the metric sets of all the objects.
+type wholeBatchSink struct {
+	sink core.DataSink
+}
+
+// NewWholeBatchSink returns a sink exporting only the whole batches to the given sink.
+func NewWholeBatchSink(sink core.DataSink) core.DataSink {
+	return &wholeBatchSink{sink: sink}
+}
+
+// acceptsPartialBatches returns whether the sink opted in to partial batches.
+func acceptsPartialBatches(sink core.DataSink) bool {
+	partial, ok := sink.(core.PartialBatchSink)
+	return ok && partial.AcceptsPartialBatches()
+}
+
+func (this *wholeBatchSink) Name() string {
+	return this.sink.Name()
+}
+
+func (this *wholeBatchSink) ExportData(batch *core.DataBatch) {
+	if batch.Partial {
+		glog.V(4).Infof("[batch %s] Not exporting the partial batch to %s", batch.ID, this.sink.Name())
+		return
+	}
+	this.sink.ExportData(batch)
+}
+
+func (this *wholeBatchSink) Stop() {
+	this.sink.Stop()
+}
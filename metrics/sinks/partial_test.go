@@ -0,0 +1,45 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+type partialRecordingSink struct {
+	recordingSink
+}
+
+func (this *partialRecordingSink) AcceptsPartialBatches() bool {
+	return true
+}
+
+func TestWholeBatchSink(t *testing.T) {
+	partial := &core.DataBatch{ID: "2", Partial: true}
+	whole := &core.DataBatch{ID: "1"}
+
+	recording := &recordingSink{}
+	assert.False(t, acceptsPartialBatches(recording))
+	sink := NewWholeBatchSink(recording)
+	sink.ExportData(whole)
+	sink.ExportData(partial)
+	assert.Equal(t, []*core.DataBatch{whole}, recording.batches)
+
+	assert.True(t, acceptsPartialBatches(&partialRecordingSink{}))
+}
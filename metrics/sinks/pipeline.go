@@ -0,0 +1,94 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"fmt"
+
+	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	// Option of the sink URIs selecting the metrics exported to the sink, handled by the factory
+	// for all sinks.
+	PipelineOption = "pipeline"
+	// Metrics of the nodes, of their system containers and of the cluster.
+	PipelineSystem = "system"
+	// Metrics of the pods, of their containers and of the namespaces.
+	PipelineWorkload = "workload"
+	// All metrics.
+	PipelineAll = "all"
+)
+
+var pipelineMetricSetTypes = map[string][]string{
+	PipelineSystem:   {core.MetricSetTypeNode, core.MetricSetTypeSystemContainer, core.MetricSetTypeCluster},
+	PipelineWorkload: {core.MetricSetTypePod, core.MetricSetTypePodContainer, core.MetricSetTypeNamespace},
+}
+
+// pipelineFilter keeps the metric sets of a pipeline, so that e.g. the system metrics are exported
+// to the sinks of the infrastructure team and the workload metrics to those of the tenants.
+type pipelineFilter struct {
+	pipeline string
+	types    map[string]bool
+}
+
+// NewPipelineFilter returns the processor keeping the metric sets of the given pipeline, nil for
+// all metric sets.
+func NewPipelineFilter(pipeline string) (core.DataProcessor, error) {
+	if pipeline == "" || pipeline == PipelineAll {
+		return nil, nil
+	}
+	types, found := pipelineMetricSetTypes[pipeline]
+	if !found {
+		return nil, fmt.Errorf("unknown pipeline %q, expected %s, %s or %s", pipeline, PipelineSystem, PipelineWorkload, PipelineAll)
+	}
+	filter := &pipelineFilter{
+		pipeline: pipeline,
+		types:    map[string]bool{},
+	}
+	for _, metricSetType := range types {
+		filter.types[metricSetType] = true
+	}
+	return filter, nil
+}
+
+func (this *pipelineFilter) Name() string {
+	return this.pipeline + "_pipeline_filter"
+}
+
+func (this *pipelineFilter) Process(batch *core.DataBatch) (*core.DataBatch, error) {
+	result := *batch
+	result.MetricSets = make(map[string]*core.MetricSet, len(batch.MetricSets))
+	for key, metricSet := range batch.MetricSets {
+		if this.types[metricSet.Labels[core.LabelMetricSetType.Key]] {
+			result.MetricSets[key] = metricSet
+		}
+	}
+	return &result, nil
+}
+
+// splitPipeline returns the sink URI without the pipeline option, which the sinks don't know,
+// and the value of the option.
+func splitPipeline(uri flags.Uri) (flags.Uri, string) {
+	opts := uri.Val.Query()
+	if _, found := opts[PipelineOption]; !found {
+		return uri, ""
+	}
+	pipeline := opts.Get(PipelineOption)
+	opts.Del(PipelineOption)
+	uri.Val.RawQuery = opts.Encode()
+	return uri, pipeline
+}
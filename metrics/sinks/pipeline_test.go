@@ -0,0 +1,78 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/common/flags"
+	"k8s.io/heapster/metrics/core"
+)
+
+func typedMetricSet(metricSetType string) *core.MetricSet {
+	return &core.MetricSet{Labels: map[string]string{core.LabelMetricSetType.Key: metricSetType}}
+}
+
+func TestPipelineFilter(t *testing.T) {
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			"cluster":                           typedMetricSet(core.MetricSetTypeCluster),
+			"node:n1":                           typedMetricSet(core.MetricSetTypeNode),
+			"node:n1/container:kubelet":         typedMetricSet(core.MetricSetTypeSystemContainer),
+			"namespace:ns1":                     typedMetricSet(core.MetricSetTypeNamespace),
+			"namespace:ns1/pod:p1":              typedMetricSet(core.MetricSetTypePod),
+			"namespace:ns1/pod:p1/container:c1": typedMetricSet(core.MetricSetTypePodContainer),
+		},
+	}
+
+	recording := &recordingSink{}
+	for _, pipeline := range []string{PipelineSystem, PipelineWorkload} {
+		filter, err := NewPipelineFilter(pipeline)
+		require.NoError(t, err)
+		NewProcessedSink(recording, filter).ExportData(batch)
+	}
+	assert.Equal(t, [][]string{
+		{"cluster", "node:n1", "node:n1/container:kubelet"},
+		{"namespace:ns1", "namespace:ns1/pod:p1", "namespace:ns1/pod:p1/container:c1"},
+	}, recording.exported)
+	assert.Len(t, batch.MetricSets, 6)
+
+	for _, pipeline := range []string{"", PipelineAll} {
+		filter, err := NewPipelineFilter(pipeline)
+		assert.NoError(t, err)
+		assert.Nil(t, filter)
+	}
+	_, err := NewPipelineFilter("tenant")
+	assert.Error(t, err)
+}
+
+func TestSplitPipeline(t *testing.T) {
+	uri := flags.Uri{}
+	require.NoError(t, uri.Set("influxdb:http://influxdb:8086?db=infra&pipeline=system"))
+	sinkUri, pipeline := splitPipeline(uri)
+	assert.Equal(t, PipelineSystem, pipeline)
+	assert.Equal(t, "db=infra", sinkUri.Val.RawQuery)
+	assert.Equal(t, "db=infra&pipeline=system", uri.Val.RawQuery)
+
+	require.NoError(t, uri.Set("influxdb:http://influxdb:8086?db=k8s"))
+	sinkUri, pipeline = splitPipeline(uri)
+	assert.Equal(t, "", pipeline)
+	assert.Equal(t, uri, sinkUri)
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/heapster/metrics/core"
+)
+
+var (
+	// Number of metric sets not exported because the higher priority ones took the whole export time.
+	truncatedMetricSets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "exporter",
+			Name:      "truncated_metric_sets_total",
+			Help:      "Number of metric sets not exported because the higher priority ones took the whole export time.",
+		},
+		[]string{"exporter"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(truncatedMetricSets)
+}
+
+// prioritizedSink exports a batch in parts of decreasing priority: first the metric sets of
+// nodes and of the cluster, then those of the namespaces matching each pattern in order, then
+// the remaining ones. Once the export took longer than the budget, the parts not exported yet
+// are dropped, so that a slow sink still gets the metrics of the important workloads, e.g.
+// those used for autoscaling, instead of missing whole batches. The parts are marked with their
+// position in the batch, see core.BatchPart.
+type prioritizedSink struct {
+	sink       core.DataSink
+	namespaces []string
+	budget     time.Duration
+}
+
+// NewPrioritizedSink returns a sink exporting the metric sets of the namespaces matching the
+// given patterns, e.g. `prod-*`, first, in the given order.
+func NewPrioritizedSink(sink core.DataSink, namespaces []string, budget time.Duration) (core.DataSink, error) {
+	for _, pattern := range namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %v", pattern, err)
+		}
+	}
+	return &prioritizedSink{
+		sink:       sink,
+		namespaces: namespaces,
+		budget:     budget,
+	}, nil
+}
+
+func (this *prioritizedSink) Name() string {
+	return this.sink.Name()
+}
+
+func (this *prioritizedSink) ExportData(batch *core.DataBatch) {
+	startTime := time.Now()
+	parts := this.split(batch)
+	for i, part := range parts {
+		if i > 0 && time.Since(startTime) >= this.budget {
+			dropped := 0
+			for _, remaining := range parts[i:] {
+				dropped += len(remaining.MetricSets)
+			}
+			truncatedMetricSets.WithLabelValues(this.Name()).Add(float64(dropped))
+			glog.Warningf("[batch %s] Export to %s took %s, dropping %d lower priority metric sets",
+				batch.ID, this.Name(), time.Since(startTime), dropped)
+			return
+		}
+		this.sink.ExportData(part)
+	}
+}
+
+func (this *prioritizedSink) Stop() {
+	this.sink.Stop()
+}
+
+// split returns the non-empty parts of the batch, by decreasing priority, marked with their
+// position. A batch holding a single part is returned unchanged.
+func (this *prioritizedSink) split(batch *core.DataBatch) []*core.DataBatch {
+	parts := make([]*core.DataBatch, len(this.namespaces)+2)
+	for i := range parts {
+		// The parts have the fields of the batch, e.g. its dedup key, but only some of its metric sets.
+		part := *batch
+		part.MetricSets = map[string]*core.MetricSet{}
+		parts[i] = &part
+	}
+	for key, metricSet := range batch.MetricSets {
+		parts[this.priority(metricSet)].MetricSets[key] = metricSet
+	}
+	result := make([]*core.DataBatch, 0, len(parts))
+	for _, part := range parts {
+		if len(part.MetricSets) > 0 {
+			result = append(result, part)
+		}
+	}
+	if len(result) <= 1 {
+		return []*core.DataBatch{batch}
+	}
+	for i, part := range result {
+		part.Part = &core.BatchPart{Index: i, Last: i == len(result)-1}
+	}
+	return result
+}
+
+func (this *prioritizedSink) priority(metricSet *core.MetricSet) int {
+	namespace := metricSet.Labels[core.LabelNamespaceName.Key]
+	if namespace == "" {
+		return 0
+	}
+	for i, pattern := range this.namespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return i + 1
+		}
+	}
+	return len(this.namespaces) + 1
+}
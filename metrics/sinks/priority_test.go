@@ -0,0 +1,133 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+type recordingSink struct {
+	latency  time.Duration
+	exported [][]string
+	batches  []*core.DataBatch
+}
+
+func (this *recordingSink) Name() string {
+	return "recording"
+}
+
+func (this *recordingSink) ExportData(batch *core.DataBatch) {
+	keys := []string{}
+	for key := range batch.MetricSets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	this.exported = append(this.exported, keys)
+	this.batches = append(this.batches, batch)
+	time.Sleep(this.latency)
+}
+
+func (this *recordingSink) Stop() {}
+
+func namespacedMetricSet(namespace string) *core.MetricSet {
+	return &core.MetricSet{Labels: map[string]string{core.LabelNamespaceName.Key: namespace}}
+}
+
+func TestPrioritizedSink(t *testing.T) {
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		ID:        "1",
+		MetricSets: map[string]*core.MetricSet{
+			"node:n1":          {Labels: map[string]string{}},
+			"namespace:batch1": namespacedMetricSet("batch1"),
+			"namespace:prod-a": namespacedMetricSet("prod-a"),
+			"namespace:prod-b": namespacedMetricSet("prod-b"),
+			"namespace:system": namespacedMetricSet("kube-system"),
+		},
+	}
+
+	recording := &recordingSink{}
+	sink, err := NewPrioritizedSink(recording, []string{"prod-*", "kube-system"}, time.Minute)
+	require.NoError(t, err)
+	sink.ExportData(batch)
+	assert.Equal(t, [][]string{
+		{"node:n1"},
+		{"namespace:prod-a", "namespace:prod-b"},
+		{"namespace:system"},
+		{"namespace:batch1"},
+	}, recording.exported)
+	for i, part := range recording.batches {
+		require.NotNil(t, part.Part)
+		assert.Equal(t, core.BatchPart{Index: i, Last: i == 3}, *part.Part)
+	}
+
+	// A batch with a single part is exported as is.
+	recording = &recordingSink{}
+	sink, err = NewPrioritizedSink(recording, []string{"prod-*", "kube-system"}, time.Minute)
+	require.NoError(t, err)
+	single := &core.DataBatch{ID: "2", MetricSets: map[string]*core.MetricSet{"node:n1": {Labels: map[string]string{}}}}
+	sink.ExportData(single)
+	require.Len(t, recording.batches, 1)
+	assert.True(t, recording.batches[0] == single)
+
+	// Once the budget is spent, the lower priority metric sets are dropped.
+	recording = &recordingSink{latency: 100 * time.Millisecond}
+	sink, err = NewPrioritizedSink(recording, []string{"prod-*", "kube-system"}, 150*time.Millisecond)
+	require.NoError(t, err)
+	sink.ExportData(batch)
+	assert.Equal(t, [][]string{
+		{"node:n1"},
+		{"namespace:prod-a", "namespace:prod-b"},
+	}, recording.exported)
+
+	_, err = NewPrioritizedSink(recording, []string{"prod-["}, time.Minute)
+	assert.Error(t, err)
+}
+
+func TestPrioritizedSinkKeepsBatchFields(t *testing.T) {
+	timestamp := time.Now()
+	dedupKey := &core.DedupKey{Cluster: "prod", Replica: "heapster-1", Timestamp: timestamp}
+	batch := &core.DataBatch{
+		Timestamp: timestamp,
+		ID:        "1",
+		Partial:   true,
+		DedupKey:  dedupKey,
+		MetricSets: map[string]*core.MetricSet{
+			"node:n1":          {Labels: map[string]string{}},
+			"namespace:prod-a": namespacedMetricSet("prod-a"),
+		},
+	}
+
+	recording := &recordingSink{}
+	sink, err := NewPrioritizedSink(recording, []string{"prod-*"}, time.Minute)
+	require.NoError(t, err)
+	sink.ExportData(batch)
+	require.Equal(t, 2, len(recording.batches))
+	for _, part := range recording.batches {
+		assert.Equal(t, timestamp, part.Timestamp)
+		assert.Equal(t, "1", part.ID)
+		assert.True(t, part.Partial)
+		assert.Equal(t, dedupKey, part.DedupKey)
+	}
+	// The batch itself is left unchanged.
+	assert.Equal(t, 2, len(batch.MetricSets))
+}
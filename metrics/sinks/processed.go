@@ -0,0 +1,52 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sinks
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/heapster/metrics/core"
+)
+
+// processedSink runs a processor on the batches of a single sink before exporting them. The
+// processor should return a new batch rather than modify the given one, which is shared by the
+// other sinks.
+type processedSink struct {
+	sink      core.DataSink
+	processor core.DataProcessor
+}
+
+func NewProcessedSink(sink core.DataSink, processor core.DataProcessor) core.DataSink {
+	return &processedSink{
+		sink:      sink,
+		processor: processor,
+	}
+}
+
+func (this *processedSink) Name() string {
+	return this.sink.Name()
+}
+
+func (this *processedSink) ExportData(batch *core.DataBatch) {
+	processed, err := this.processor.Process(batch)
+	if err != nil {
+		glog.Errorf("[batch %s] Error in processor %s of %s, not exporting the batch: %v", batch.ID, this.processor.Name(), this.sink.Name(), err)
+		return
+	}
+	this.sink.ExportData(processed)
+}
+
+func (this *processedSink) Stop() {
+	this.sink.Stop()
+}
@@ -0,0 +1,312 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pushgateway implements a sink pushing the metrics to a Prometheus Pushgateway, for
+// Prometheus servers which cannot scrape Heapster directly.
+package pushgateway
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+	"k8s.io/heapster/metrics/util/openmetrics"
+)
+
+const (
+	defaultJob           = "heapster"
+	defaultInstanceLabel = "nodename"
+	defaultTimeout       = 30 * time.Second
+	// Labels of the grouping key, which the pushed series must not hold.
+	jobLabel      = "job"
+	instanceLabel = "instance"
+)
+
+// group is the set of series pushed under a grouping key, replaced at every push.
+type group struct {
+	instance string
+	families map[string]*dto.MetricFamily
+}
+
+type pushgatewaySink struct {
+	sync.Mutex
+	baseUrl     string
+	job         string
+	client      *http.Client
+	credentials *credentials.Credentials
+	// Metric set label whose value is the instance of the grouping key.
+	instanceLabel string
+	// Instances pushed, deleted once a whole batch without them was exported.
+	pushed map[string]bool
+	// Groups of the parts of the batch being exported, see core.BatchPart, so that the groups
+	// spanning several parts are pushed with the series of all of them.
+	partsBatchID string
+	partGroups   map[string]*group
+}
+
+func (this *pushgatewaySink) Name() string {
+	return "Prometheus Pushgateway Sink"
+}
+
+// Partial batches replace the groups they hold without deleting the others.
+func (this *pushgatewaySink) AcceptsPartialBatches() bool {
+	return true
+}
+
+func (this *pushgatewaySink) Stop() {}
+
+func (this *pushgatewaySink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	groups := this.groups(batch)
+	exported := groups
+	if batch.Part != nil {
+		if batch.Part.Index == 0 || batch.ID != this.partsBatchID {
+			this.partsBatchID, this.partGroups = batch.ID, map[string]*group{}
+		}
+		groups = this.addPart(groups)
+		exported = this.partGroups
+	}
+	if this.pushed == nil {
+		this.pushed = map[string]bool{}
+	}
+	for instance, group := range groups {
+		if err := this.push(batch, group); err != nil {
+			glog.Errorf("[batch %s] Failed to push the metrics of %s to the Pushgateway: %v", batch.ID, this.groupPath(instance), err)
+			continue
+		}
+		this.pushed[instance] = true
+	}
+	// Batches holding the metric sets of only some objects, and parts of batches but the last
+	// one, do not tell which objects were removed.
+	if batch.Partial || (batch.Part != nil && !batch.Part.Last) {
+		return
+	}
+	this.partsBatchID, this.partGroups = "", nil
+	// The Pushgateway keeps the metrics of a group until it is deleted, e.g. of removed nodes.
+	for instance := range this.pushed {
+		if _, found := exported[instance]; found {
+			continue
+		}
+		if err := this.request(batch, "DELETE", this.groupPath(instance), nil); err != nil {
+			glog.Errorf("[batch %s] Failed to delete the metrics of %s from the Pushgateway: %v", batch.ID, this.groupPath(instance), err)
+			continue
+		}
+		delete(this.pushed, instance)
+	}
+}
+
+// addPart adds the groups of a part of a batch to those of its previous parts, and returns the
+// groups of the part with the series of the previous parts.
+func (this *pushgatewaySink) addPart(groups map[string]*group) map[string]*group {
+	result := make(map[string]*group, len(groups))
+	for instance, g := range groups {
+		previous, found := this.partGroups[instance]
+		if !found {
+			this.partGroups[instance] = g
+			result[instance] = g
+			continue
+		}
+		for name, family := range g.families {
+			if previousFamily, found := previous.families[name]; found {
+				previousFamily.Metric = append(previousFamily.Metric, family.Metric...)
+			} else {
+				previous.families[name] = family
+			}
+		}
+		result[instance] = previous
+	}
+	return result
+}
+
+// groups returns the series of the batch by instance, named and labeled as in the Prometheus
+// exposition of Heapster. Metric sets without the instance label are grouped by job only.
+func (this *pushgatewaySink) groups(batch *core.DataBatch) map[string]*group {
+	groups := map[string]*group{}
+	add := func(instance, name string, labels, metricLabels map[string]string, value core.MetricValue) {
+		g, found := groups[instance]
+		if !found {
+			g = &group{instance: instance, families: map[string]*dto.MetricFamily{}}
+			groups[instance] = g
+		}
+		metricName := openmetrics.MetricName(name, value.MetricType)
+		family, found := g.families[metricName]
+		if !found {
+			family = &dto.MetricFamily{Name: proto.String(metricName), Help: proto.String("Heapster metric " + name)}
+			if value.MetricType == core.MetricCumulative {
+				family.Type = dto.MetricType_COUNTER.Enum()
+			} else {
+				family.Type = dto.MetricType_GAUGE.Enum()
+			}
+			g.families[metricName] = family
+		}
+		metric := &dto.Metric{Label: toLabelPairs(labels, metricLabels)}
+		if value.MetricType == core.MetricCumulative {
+			metric.Counter = &dto.Counter{Value: proto.Float64(floatValue(value))}
+		} else {
+			metric.Gauge = &dto.Gauge{Value: proto.Float64(floatValue(value))}
+		}
+		family.Metric = append(family.Metric, metric)
+	}
+
+	for _, metricSet := range batch.MetricSets {
+		instance := metricSet.Labels[this.instanceLabel]
+		for name, value := range metricSet.MetricValues {
+			add(instance, name, metricSet.Labels, nil, value)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			add(instance, metric.Name, metricSet.Labels, metric.Labels, metric.MetricValue)
+		}
+	}
+	return groups
+}
+
+func toLabelPairs(labelSets ...map[string]string) []*dto.LabelPair {
+	merged := map[string]string{}
+	for _, labels := range labelSets {
+		for key, value := range labels {
+			if value != "" {
+				merged[openmetrics.LabelName(key)] = value
+			}
+		}
+	}
+	delete(merged, jobLabel)
+	delete(merged, instanceLabel)
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]*dto.LabelPair, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(merged[name])})
+	}
+	return pairs
+}
+
+func floatValue(value core.MetricValue) float64 {
+	if value.ValueType == core.ValueFloat {
+		return float64(value.FloatValue)
+	}
+	return float64(value.IntValue)
+}
+
+// push replaces the metrics of the group in the Pushgateway by those of the batch.
+func (this *pushgatewaySink) push(batch *core.DataBatch, g *group) error {
+	names := make([]string, 0, len(g.families))
+	for name := range g.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var body bytes.Buffer
+	encoder := expfmt.NewEncoder(&body, expfmt.FmtText)
+	for _, name := range names {
+		if err := encoder.Encode(g.families[name]); err != nil {
+			return err
+		}
+	}
+	return this.request(batch, "PUT", this.groupPath(g.instance), &body)
+}
+
+// groupPath returns the path of the grouping key of the instance.
+func (this *pushgatewaySink) groupPath(instance string) string {
+	path := "/metrics/job/" + url.PathEscape(this.job)
+	if instance != "" {
+		path += "/" + instanceLabel + "/" + url.PathEscape(instance)
+	}
+	return path
+}
+
+func (this *pushgatewaySink) request(batch *core.DataBatch, method, path string, body *bytes.Buffer) error {
+	var req *http.Request
+	var err error
+	if body != nil {
+		req, err = http.NewRequest(method, this.baseUrl+path, body)
+	} else {
+		req, err = http.NewRequest(method, this.baseUrl+path, nil)
+	}
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.FmtText))
+	util.SetBatchHeaders(req.Header, batch)
+	if err := this.credentials.Authorize(req); err != nil {
+		return err
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		contents, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s failed with status %s: %s", method, path, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return nil
+}
+
+// NewPushgatewaySink returns a sink pushing to the Pushgateway at the uri, e.g.
+// http://pushgateway:9091?job=heapster&instance=nodename.
+func NewPushgatewaySink(uri *url.URL) (core.DataSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing host of the Pushgateway in %q", uri.String())
+	}
+	opts := uri.Query()
+	base := url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: strings.TrimSuffix(uri.Path, "/")}
+	if base.Scheme == "" {
+		base.Scheme = "http"
+	}
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	sink := &pushgatewaySink{
+		baseUrl:       base.String(),
+		job:           defaultJob,
+		client:        &http.Client{Timeout: timeout},
+		credentials:   creds,
+		instanceLabel: defaultInstanceLabel,
+		pushed:        map[string]bool{},
+	}
+	if value := opts.Get("job"); value != "" {
+		sink.job = value
+	}
+	if value, found := opts["instance"]; found {
+		// An empty label pushes all metrics in the group of the job.
+		sink.instanceLabel = value[0]
+	}
+	glog.Infof("created Pushgateway sink pushing job %s to %s, grouped by %q", sink.job, sink.baseUrl, sink.instanceLabel)
+	return sink, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pushgateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+)
+
+type fakePushgateway struct {
+	sync.Mutex
+	// Requests as "METHOD path", and the bodies of the PUT requests by path.
+	requests []string
+	bodies   map[string]string
+	status   int
+}
+
+func (this *fakePushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.Lock()
+	defer this.Unlock()
+	this.requests = append(this.requests, r.Method+" "+r.URL.Path)
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Method == "PUT" {
+		this.bodies[r.URL.Path] = string(body)
+	}
+	if this.status != 0 {
+		w.WriteHeader(this.status)
+	}
+}
+
+func (this *fakePushgateway) sortedRequests() []string {
+	this.Lock()
+	defer this.Unlock()
+	requests := append([]string{}, this.requests...)
+	sort.Strings(requests)
+	this.requests = nil
+	return requests
+}
+
+func gauge(value int64) core.MetricValue {
+	return core.MetricValue{MetricType: core.MetricGauge, ValueType: core.ValueInt64, IntValue: value}
+}
+
+func batchOfNodes(nodes ...string) *core.DataBatch {
+	batch := &core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.ClusterKey(): {
+				Labels:       map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeCluster},
+				MetricValues: map[string]core.MetricValue{core.MetricMemoryUsage.Name: gauge(300)},
+			},
+		},
+	}
+	for _, node := range nodes {
+		batch.MetricSets[core.NodeKey(node)] = &core.MetricSet{
+			Labels: map[string]string{
+				core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+				core.LabelNodename.Key:      node,
+				"instance":                  "ignored",
+			},
+			MetricValues: map[string]core.MetricValue{
+				core.MetricMemoryUsage.Name: gauge(100),
+				core.MetricCpuUsage.Name:    {MetricType: core.MetricCumulative, ValueType: core.ValueInt64, IntValue: 7},
+			},
+		}
+	}
+	return batch
+}
+
+func newSink(t *testing.T, options string) (*pushgatewaySink, *fakePushgateway, func()) {
+	gateway := &fakePushgateway{bodies: map[string]string{}}
+	server := httptest.NewServer(gateway)
+	uri, err := url.Parse(server.URL + "/?" + options)
+	require.NoError(t, err)
+	sink, err := NewPushgatewaySink(uri)
+	require.NoError(t, err)
+	return sink.(*pushgatewaySink), gateway, server.Close
+}
+
+func TestExportData(t *testing.T) {
+	sink, gateway, stop := newSink(t, "job=k8s")
+	defer stop()
+
+	sink.ExportData(batchOfNodes("node-1", "node-2"))
+	assert.Equal(t, []string{
+		"PUT /metrics/job/k8s",
+		"PUT /metrics/job/k8s/instance/node-1",
+		"PUT /metrics/job/k8s/instance/node-2",
+	}, gateway.sortedRequests())
+	assert.Equal(t, `# HELP heapster_cpu_usage_total Heapster metric cpu/usage
+# TYPE heapster_cpu_usage_total counter
+heapster_cpu_usage_total{nodename="node-1",type="node"} 7
+# HELP heapster_memory_usage Heapster metric memory/usage
+# TYPE heapster_memory_usage gauge
+heapster_memory_usage{nodename="node-1",type="node"} 100
+`, gateway.bodies["/metrics/job/k8s/instance/node-1"])
+	assert.Equal(t, `# HELP heapster_memory_usage Heapster metric memory/usage
+# TYPE heapster_memory_usage gauge
+heapster_memory_usage{type="cluster"} 300
+`, gateway.bodies["/metrics/job/k8s"])
+
+	// The group of the removed node is deleted.
+	sink.ExportData(batchOfNodes("node-1"))
+	assert.Equal(t, []string{
+		"DELETE /metrics/job/k8s/instance/node-2",
+		"PUT /metrics/job/k8s",
+		"PUT /metrics/job/k8s/instance/node-1",
+	}, gateway.sortedRequests())
+	sink.ExportData(batchOfNodes("node-1"))
+	assert.Equal(t, []string{
+		"PUT /metrics/job/k8s",
+		"PUT /metrics/job/k8s/instance/node-1",
+	}, gateway.sortedRequests())
+}
+
+func TestExportDataSingleGroup(t *testing.T) {
+	sink, gateway, stop := newSink(t, "instance=")
+	defer stop()
+
+	sink.ExportData(batchOfNodes("node-1", "node-2"))
+	assert.Equal(t, []string{"PUT /metrics/job/heapster"}, gateway.sortedRequests())
+	assert.Contains(t, gateway.bodies["/metrics/job/heapster"], `heapster_memory_usage{nodename="node-2",type="node"} 100`)
+}
+
+func TestExportDataFailure(t *testing.T) {
+	sink, gateway, stop := newSink(t, "")
+	defer stop()
+
+	gateway.status = http.StatusBadRequest
+	sink.ExportData(batchOfNodes("node-1"))
+	assert.Equal(t, 2, len(gateway.sortedRequests()))
+	// Groups which failed to be pushed are not deleted.
+	gateway.status = 0
+	sink.ExportData(batchOfNodes())
+	assert.Equal(t, []string{"PUT /metrics/job/heapster"}, gateway.sortedRequests())
+}
+
+func TestExportDataPartialBatches(t *testing.T) {
+	sink, gateway, stop := newSink(t, "job=k8s")
+	defer stop()
+
+	sink.ExportData(batchOfNodes("node-1", "node-2"))
+	gateway.sortedRequests()
+
+	// Batches of only some objects do not delete the groups of the others.
+	partial := batchOfNodes("node-1")
+	partial.Partial = true
+	sink.ExportData(partial)
+	assert.Equal(t, []string{
+		"PUT /metrics/job/k8s",
+		"PUT /metrics/job/k8s/instance/node-1",
+	}, gateway.sortedRequests())
+
+	// The groups spanning several parts of a batch are pushed with the series of all of them, and
+	// the groups of removed nodes are deleted with the last part.
+	first := batchOfNodes("node-1")
+	first.ID = "b1"
+	first.Part = &core.BatchPart{Index: 0}
+	last := &core.DataBatch{
+		ID:   "b1",
+		Part: &core.BatchPart{Index: 1, Last: true},
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("ns", "pod-1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelNodename.Key:      "node-1",
+					core.LabelPodName.Key:       "pod-1",
+				},
+				MetricValues: map[string]core.MetricValue{core.MetricMemoryUsage.Name: gauge(50)},
+			},
+		},
+	}
+	sink.ExportData(first)
+	assert.Equal(t, []string{
+		"PUT /metrics/job/k8s",
+		"PUT /metrics/job/k8s/instance/node-1",
+	}, gateway.sortedRequests())
+	sink.ExportData(last)
+	assert.Equal(t, []string{
+		"DELETE /metrics/job/k8s/instance/node-2",
+		"PUT /metrics/job/k8s/instance/node-1",
+	}, gateway.sortedRequests())
+	body := gateway.bodies["/metrics/job/k8s/instance/node-1"]
+	assert.Contains(t, body, `heapster_memory_usage{nodename="node-1",type="node"} 100`)
+	assert.Contains(t, body, `heapster_memory_usage{nodename="node-1",pod_name="pod-1",type="pod"} 50`)
+}
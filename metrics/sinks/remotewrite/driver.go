@@ -0,0 +1,275 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotewrite implements a sink pushing the metrics with the Prometheus remote write
+// protocol, to Prometheus compatible stores such as Cortex, Thanos Receive or VictoriaMetrics.
+package remotewrite
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/snappy"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/common/endpoints"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+	"k8s.io/heapster/metrics/util/openmetrics"
+)
+
+const (
+	defaultTimeout       = 30 * time.Second
+	defaultMaxSamples    = 5000
+	defaultMaxRetries    = 2
+	retryBackoff         = time.Second
+	remoteWriteVersion   = "0.1.0"
+	metricNameLabel      = "__name__"
+	remoteWriteUserAgent = "heapster"
+)
+
+type remoteWriteSink struct {
+	sync.Mutex
+	endpoint  string
+	client    *http.Client
+	transport *http.Transport
+	// Rotates over the addresses of the host of the endpoint, nil to dial the host name.
+	resolver       *endpoints.Resolver
+	credentials    *credentials.Credentials
+	externalLabels map[string]string
+	// Number of samples above which a batch is split in several requests.
+	maxSamples int
+	// Number of times a request failing with a 5xx or 429 status is retried.
+	maxRetries int
+}
+
+func (this *remoteWriteSink) Name() string {
+	return "Prometheus Remote Write Sink"
+}
+
+func (this *remoteWriteSink) Stop() {}
+
+func (this *remoteWriteSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	series := this.toTimeSeries(batch)
+	for start := 0; start < len(series); start += this.maxSamples {
+		end := start + this.maxSamples
+		if end > len(series) {
+			end = len(series)
+		}
+		if err := this.send(batch, encodeWriteRequest(series[start:end])); err != nil {
+			glog.Errorf("[batch %s] Failed to write %d samples to %s: %v", batch.ID, end-start, this.endpoint, err)
+		}
+	}
+	if this.resolver != nil {
+		// The next batch dials the next address, which also drops the addresses removed from DNS.
+		this.transport.CloseIdleConnections()
+	}
+}
+
+// toTimeSeries returns a series for each metric of the batch, named and labeled as by the
+// Prometheus exposition of Heapster.
+func (this *remoteWriteSink) toTimeSeries(batch *core.DataBatch) []timeSeries {
+	series := []timeSeries{}
+	add := func(name string, labels map[string]string, extraLabels map[string]string, value core.MetricValue, timestamp time.Time) {
+		merged := map[string]string{}
+		for key, value := range this.externalLabels {
+			merged[key] = value
+		}
+		for _, labelSet := range []map[string]string{labels, extraLabels} {
+			for key, value := range labelSet {
+				if value != "" {
+					merged[openmetrics.LabelName(key)] = value
+				}
+			}
+		}
+		merged[metricNameLabel] = openmetrics.MetricName(name, value.MetricType)
+		ts := timeSeries{
+			labels:    make([]label, 0, len(merged)),
+			value:     floatValue(value),
+			timestamp: timestamp.UnixNano() / int64(time.Millisecond),
+		}
+		for key, value := range merged {
+			ts.labels = append(ts.labels, label{name: key, value: value})
+		}
+		sort.Slice(ts.labels, func(i, j int) bool { return ts.labels[i].name < ts.labels[j].name })
+		series = append(series, ts)
+	}
+
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range metricSet.MetricValues {
+			add(name, metricSet.Labels, nil, value, timestamp)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			add(metric.Name, metricSet.Labels, metric.Labels, metric.MetricValue, timestamp)
+		}
+	}
+	return series
+}
+
+func floatValue(value core.MetricValue) float64 {
+	if value.ValueType == core.ValueFloat {
+		return float64(value.FloatValue)
+	}
+	return float64(value.IntValue)
+}
+
+// send posts the compressed request of the batch, retrying the failures which the remote write
+// protocol defines as recoverable.
+func (this *remoteWriteSink) send(batch *core.DataBatch, request []byte) error {
+	body := snappy.Encode(nil, request)
+	var err error
+	for attempt := 0; attempt <= this.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoff << uint(attempt-1))
+		}
+		var recoverable bool
+		if recoverable, err = this.post(batch, body); err == nil || !recoverable {
+			return err
+		}
+		glog.V(2).Infof("[batch %s] Remote write to %s failed, attempt %d: %v", batch.ID, this.endpoint, attempt+1, err)
+	}
+	return err
+}
+
+// post returns whether the failure of the request is recoverable.
+func (this *remoteWriteSink) post(batch *core.DataBatch, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", this.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", remoteWriteUserAgent)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+	util.SetBatchHeaders(req.Header, batch)
+	if err := this.credentials.Authorize(req); err != nil {
+		return false, err
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	contents, _ := ioutil.ReadAll(resp.Body)
+	recoverable := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+	return recoverable, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+}
+
+// parseExternalLabels parses labels given as name:value, separated by commas.
+func parseExternalLabels(values []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid external label %q, expected name:value", pair)
+			}
+			labels[openmetrics.LabelName(parts[0])] = parts[1]
+		}
+	}
+	return labels, nil
+}
+
+// NewRemoteWriteSink returns a sink writing to the remote write endpoint of the uri, e.g.
+// https://cortex/api/v1/push?externalLabels=cluster:prod. The scheme defaults to http.
+func NewRemoteWriteSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing host in remote write endpoint %q", uri.String())
+	}
+	endpoint := *uri
+	if endpoint.Scheme == "" {
+		endpoint.Scheme = "http"
+	}
+	// The options of the sink are not forwarded to the endpoint.
+	endpoint.RawQuery = ""
+
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+	externalLabels, err := parseExternalLabels(opts["externalLabels"])
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if value := opts.Get("insecure"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure option %q: %v", value, err)
+		}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	}
+	hostport := endpoint.Host
+	if endpoint.Port() == "" {
+		hostport = net.JoinHostPort(endpoint.Hostname(), map[string]string{"http": "80", "https": "443"}[endpoint.Scheme])
+	}
+	resolver, err := endpoints.NewResolverFromOptions(hostport, opts)
+	if err != nil {
+		return nil, err
+	}
+	if resolver != nil {
+		transport.Dial = resolver.Dial
+	}
+	sink := &remoteWriteSink{
+		endpoint:       endpoint.String(),
+		client:         &http.Client{Timeout: timeout, Transport: transport},
+		transport:      transport,
+		resolver:       resolver,
+		credentials:    creds,
+		externalLabels: externalLabels,
+		maxSamples:     defaultMaxSamples,
+		maxRetries:     defaultMaxRetries,
+	}
+	for option, target := range map[string]*int{"maxSamplesPerRequest": &sink.maxSamples, "maxRetries": &sink.maxRetries} {
+		if value := opts.Get(option); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 || (option == "maxSamplesPerRequest" && parsed == 0) {
+				return nil, fmt.Errorf("invalid %s option %q", option, value)
+			}
+			*target = parsed
+		}
+	}
+	glog.Infof("created remote write sink with endpoint: %v", sink.endpoint)
+	return sink, nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+// decodeFields returns the values of the fields of a message by field number: the bytes of
+// length-delimited fields, the little-endian bytes of fixed64 fields and the value of varints.
+func decodeFields(t *testing.T, data []byte) map[uint64][]interface{} {
+	fields := map[uint64][]interface{}{}
+	for len(data) > 0 {
+		tag, n := proto.DecodeVarint(data)
+		require.NotZero(t, n)
+		data = data[n:]
+		var value interface{}
+		switch tag & 7 {
+		case wireVarint:
+			x, n := proto.DecodeVarint(data)
+			require.NotZero(t, n)
+			value, data = x, data[n:]
+		case wireFixed64:
+			value, data = binary.LittleEndian.Uint64(data[:8]), data[8:]
+		case wireBytes:
+			length, n := proto.DecodeVarint(data)
+			require.NotZero(t, n)
+			value, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields[tag>>3] = append(fields[tag>>3], value)
+	}
+	return fields
+}
+
+func decodeWriteRequest(t *testing.T, data []byte) []timeSeries {
+	series := []timeSeries{}
+	for _, message := range decodeFields(t, data)[1] {
+		fields := decodeFields(t, message.([]byte))
+		ts := timeSeries{}
+		for _, labelMessage := range fields[1] {
+			labelFields := decodeFields(t, labelMessage.([]byte))
+			ts.labels = append(ts.labels, label{
+				name:  string(labelFields[1][0].([]byte)),
+				value: string(labelFields[2][0].([]byte)),
+			})
+		}
+		require.Equal(t, 1, len(fields[2]))
+		sample := decodeFields(t, fields[2][0].([]byte))
+		ts.value = math.Float64frombits(sample[1][0].(uint64))
+		ts.timestamp = int64(sample[2][0].(uint64))
+		series = append(series, ts)
+	}
+	return series
+}
+
+type fakeReceiver struct {
+	sync.Mutex
+	requests []*http.Request
+	series   []timeSeries
+	// Statuses of the next responses, 200 when empty.
+	statuses []int
+}
+
+func (this *fakeReceiver) handle(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		this.Lock()
+		defer this.Unlock()
+		this.requests = append(this.requests, r)
+		if len(this.statuses) > 0 {
+			status := this.statuses[0]
+			this.statuses = this.statuses[1:]
+			if status != http.StatusOK {
+				http.Error(w, "failed", status)
+				return
+			}
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, body)
+		require.NoError(t, err)
+		this.series = append(this.series, decodeWriteRequest(t, data)...)
+	}
+}
+
+func newSink(t *testing.T, receiver *fakeReceiver, options string) (*remoteWriteSink, func()) {
+	server := httptest.NewServer(receiver.handle(t))
+	uri, err := url.Parse(server.URL + "/api/v1/push?" + options)
+	require.NoError(t, err)
+	sink, err := NewRemoteWriteSink(uri)
+	require.NoError(t, err)
+	return sink.(*remoteWriteSink), server.Close
+}
+
+func TestExportData(t *testing.T) {
+	receiver := &fakeReceiver{}
+	sink, stop := newSink(t, receiver, "externalLabels=cluster:prod,region:eu&user=admin&password=secret&pipeline=system")
+	defer stop()
+
+	batch := sinktest.Batch()
+	node := batch.MetricSets[core.NodeKey("node-1")]
+	node.ScrapeTime = node.ScrapeTime.Add(-time.Second)
+	node.Labels["cluster"] = "staging"
+	node.Labels[core.LabelPodName.Key] = ""
+	sink.ExportData(batch)
+
+	require.Equal(t, 1, len(receiver.requests))
+	request := receiver.requests[0]
+	assert.Equal(t, "/api/v1/push", request.URL.Path)
+	assert.Equal(t, "", request.URL.RawQuery)
+	assert.Equal(t, "snappy", request.Header.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", request.Header.Get("Content-Type"))
+	assert.Equal(t, "0.1.0", request.Header.Get("X-Prometheus-Remote-Write-Version"))
+	assert.Equal(t, sinktest.BatchID, request.Header.Get(util.BatchIDHeader))
+	user, password, ok := request.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "secret", password)
+
+	series := receiver.series
+	sort.Slice(series, func(i, j int) bool { return fmt.Sprint(series[i].labels) < fmt.Sprint(series[j].labels) })
+	ms := sinktest.Timestamp.UnixNano() / int64(time.Millisecond)
+	assert.Equal(t, []timeSeries{
+		{
+			labels: []label{
+				{"__name__", "heapster_cpu_usage_total"},
+				{"cluster", "staging"},
+				{"nodename", "node-1"},
+				{"region", "eu"},
+				{"type", "node"},
+			},
+			value:     100,
+			timestamp: ms - 1000,
+		},
+		{
+			labels: []label{
+				{"__name__", "heapster_filesystem_usage"},
+				{"cluster", "staging"},
+				{"nodename", "node-1"},
+				{"region", "eu"},
+				{"resource_id", "/dev/sda1"},
+				{"type", "node"},
+			},
+			value:     0.5,
+			timestamp: ms - 1000,
+		},
+		{
+			labels: []label{
+				{"__name__", "heapster_memory_usage"},
+				{"cluster", "prod"},
+				{"namespace_name", "default"},
+				{"pod_name", "web-1"},
+				{"region", "eu"},
+				{"type", "pod"},
+			},
+			value:     100,
+			timestamp: ms,
+		},
+		{
+			labels: []label{
+				{"__name__", "heapster_memory_usage"},
+				{"cluster", "staging"},
+				{"nodename", "node-1"},
+				{"region", "eu"},
+				{"type", "node"},
+			},
+			value:     1024,
+			timestamp: ms - 1000,
+		},
+	}, series)
+}
+
+func TestExportDataRetries(t *testing.T) {
+	receiver := &fakeReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}}
+	sink, stop := newSink(t, receiver, "maxSamplesPerRequest=1&maxRetries=1&token=secret")
+	defer stop()
+
+	value := core.MetricValue{MetricType: core.MetricGauge, ValueType: core.ValueInt64, IntValue: 1}
+	sink.ExportData(&core.DataBatch{
+		Timestamp: time.Now(),
+		MetricSets: map[string]*core.MetricSet{
+			core.ClusterKey(): {
+				Labels: map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeCluster},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricMemoryUsage.Name: value,
+					core.MetricCpuRequest.Name:  value,
+				},
+			},
+		},
+	})
+
+	// The first request is retried after the 503, the second is dropped after the 400.
+	assert.Equal(t, 3, len(receiver.requests))
+	assert.Equal(t, 1, len(receiver.series))
+	assert.Equal(t, "Bearer secret", receiver.requests[0].Header.Get("Authorization"))
+}
+
+func TestNewRemoteWriteSinkOptions(t *testing.T) {
+	for _, options := range []string{
+		"externalLabels=cluster",
+		"timeout=never",
+		"maxSamplesPerRequest=0",
+		"maxRetries=-1",
+		"insecure=maybe",
+	} {
+		uri, err := url.Parse("http://cortex/api/v1/push?" + options)
+		require.NoError(t, err)
+		_, err = NewRemoteWriteSink(uri)
+		assert.Error(t, err, options)
+	}
+	uri, err := url.Parse("/api/v1/push")
+	require.NoError(t, err)
+	_, err = NewRemoteWriteSink(uri)
+	assert.Error(t, err)
+}
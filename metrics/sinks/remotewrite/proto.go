@@ -0,0 +1,79 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"math"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// The messages of the remote write protocol, from prometheus/prompb/types.proto and
+// remote.proto, which are encoded by hand as the generated code is not vendored:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+type timeSeries struct {
+	// Sorted by name, as required by the receivers.
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+func encodeTag(buffer *proto.Buffer, field, wireType uint64) {
+	buffer.EncodeVarint(field<<3 | wireType)
+}
+
+// encodeWriteRequest returns the WriteRequest holding a sample of each series.
+func encodeWriteRequest(series []timeSeries) []byte {
+	request := proto.NewBuffer(nil)
+	for _, ts := range series {
+		message := proto.NewBuffer(nil)
+		for _, l := range ts.labels {
+			labelMessage := proto.NewBuffer(nil)
+			encodeTag(labelMessage, 1, wireBytes)
+			labelMessage.EncodeStringBytes(l.name)
+			encodeTag(labelMessage, 2, wireBytes)
+			labelMessage.EncodeStringBytes(l.value)
+			encodeTag(message, 1, wireBytes)
+			message.EncodeRawBytes(labelMessage.Bytes())
+		}
+		sample := proto.NewBuffer(nil)
+		encodeTag(sample, 1, wireFixed64)
+		sample.EncodeFixed64(math.Float64bits(ts.value))
+		encodeTag(sample, 2, wireVarint)
+		sample.EncodeVarint(uint64(ts.timestamp))
+		encodeTag(message, 2, wireBytes)
+		message.EncodeRawBytes(sample.Bytes())
+
+		encodeTag(request, 1, wireBytes)
+		request.EncodeRawBytes(message.Bytes())
+	}
+	return request.Bytes()
+}
@@ -93,7 +93,7 @@ func (sink *RiemannSink) ExportData(dataBatch *core.DataBatch) {
 		// the client could be nil here, so we reconnect
 		client, err := riemannCommon.GetRiemannClient(sink.config)
 		if err != nil {
-			glog.Warningf("Riemann sink not connected: %v", err)
+			glog.Warningf("[batch %s] Riemann sink not connected: %v", dataBatch.ID, err)
 			return
 		}
 		sink.client = client
@@ -129,7 +129,7 @@ func (sink *RiemannSink) ExportData(dataBatch *core.DataBatch) {
 	if len(events) > 0 {
 		err := riemannCommon.SendData(sink.client, events)
 		if err != nil {
-			glog.Warningf("Error sending events to Riemann: %v", err)
+			glog.Warningf("[batch %s] Error sending events to Riemann: %v", dataBatch.ID, err)
 			// client will reconnect later
 			sink.client = nil
 		}
@@ -0,0 +1,278 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signalfx implements a sink sending the metrics to the ingest API of SignalFx, now
+// Splunk Observability Cloud.
+package signalfx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+const (
+	defaultRealm         = "us0"
+	defaultTimeout       = 30 * time.Second
+	defaultPrefix        = "heapster."
+	defaultMaxDatapoints = 5000
+	datapointPath        = "/v2/datapoint"
+	// Longest dimension value accepted by SignalFx.
+	maxDimensionValue = 256
+)
+
+// datapoint is a value of the ingest API, with its timestamp in milliseconds.
+type datapoint struct {
+	Metric     string            `json:"metric"`
+	Value      interface{}       `json:"value"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Timestamp  int64             `json:"timestamp"`
+}
+
+// datapoints is the body of a request to the ingest API, the values grouped by metric type.
+type datapoints struct {
+	Gauge             []datapoint `json:"gauge,omitempty"`
+	CumulativeCounter []datapoint `json:"cumulative_counter,omitempty"`
+}
+
+func (this *datapoints) len() int {
+	return len(this.Gauge) + len(this.CumulativeCounter)
+}
+
+type signalFxSink struct {
+	sync.Mutex
+	endpoint    string
+	client      *http.Client
+	credentials *credentials.Credentials
+	prefix      string
+	dimensions  map[string]string
+	// Number of datapoints above which a batch is split in several requests.
+	maxDatapoints int
+}
+
+func (this *signalFxSink) Name() string {
+	return "SignalFx Sink"
+}
+
+func (this *signalFxSink) Stop() {}
+
+func (this *signalFxSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	request := &datapoints{}
+	flush := func() {
+		if request.len() == 0 {
+			return
+		}
+		if err := this.send(batch, request); err != nil {
+			glog.Errorf("[batch %s] Failed to send %d datapoints to SignalFx: %v", batch.ID, request.len(), err)
+		}
+		request = &datapoints{}
+	}
+	add := func(name string, labels map[string]string, extraLabels map[string]string, value core.MetricValue, timestamp time.Time) {
+		point := datapoint{
+			Metric:     this.prefix + strings.Replace(name, "/", ".", -1),
+			Value:      value.GetValue(),
+			Dimensions: this.dimensionsOf(labels, extraLabels),
+			Timestamp:  timestamp.UnixNano() / int64(time.Millisecond),
+		}
+		if value.MetricType == core.MetricCumulative {
+			request.CumulativeCounter = append(request.CumulativeCounter, point)
+		} else {
+			request.Gauge = append(request.Gauge, point)
+		}
+		if request.len() >= this.maxDatapoints {
+			flush()
+		}
+	}
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range metricSet.MetricValues {
+			add(name, metricSet.Labels, nil, value, timestamp)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			add(metric.Name, metricSet.Labels, metric.Labels, metric.MetricValue, timestamp)
+		}
+	}
+	flush()
+}
+
+// dimensionsOf returns the labels of a value as dimensions, with the dimensions of the sink.
+func (this *signalFxSink) dimensionsOf(labels map[string]string, extraLabels map[string]string) map[string]string {
+	dimensions := map[string]string{}
+	for key, value := range this.dimensions {
+		dimensions[key] = value
+	}
+	for _, labelSet := range []map[string]string{labels, extraLabels} {
+		for key, value := range labelSet {
+			if value == "" {
+				continue
+			}
+			if len(value) > maxDimensionValue {
+				value = value[:maxDimensionValue]
+			}
+			dimensions[dimensionName(key)] = value
+		}
+	}
+	return dimensions
+}
+
+// dimensionName returns a valid dimension name: letters, digits, underscores and dashes,
+// starting with a letter. The prefixes reserved by SignalFx, such as sf_, are escaped.
+func dimensionName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			name[i] = '_'
+		}
+	}
+	result := string(name)
+	if result == "" || !(result[0] >= 'a' && result[0] <= 'z' || result[0] >= 'A' && result[0] <= 'Z') {
+		result = "k8s" + result
+	}
+	for _, reserved := range []string{"sf_", "aws_", "gcp_", "azure_"} {
+		if strings.HasPrefix(result, reserved) {
+			result = "k8s_" + result
+		}
+	}
+	return result
+}
+
+func (this *signalFxSink) send(batch *core.DataBatch, request *datapoints) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", this.endpoint, &compressed)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-SF-Token", this.credentials.Get().Token)
+	util.SetBatchHeaders(req.Header, batch)
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		contents, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return nil
+}
+
+// parseDimensions parses dimensions given as name:value, separated by commas.
+func parseDimensions(values []string) (map[string]string, error) {
+	dimensions := map[string]string{}
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid dimension %q, expected name:value", pair)
+			}
+			dimensions[dimensionName(parts[0])] = parts[1]
+		}
+	}
+	return dimensions, nil
+}
+
+// NewSignalFxSink returns a sink sending the metrics to the ingest API of the realm of the uri,
+// e.g. ?realm=us1&tokenFile=/etc/signalfx/token, or to the ingest URL it is given, e.g.
+// http://smart-agent:9080?token=... for a local proxy.
+func NewSignalFxSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+
+	endpoint := ""
+	if uri.Host != "" {
+		ingest := *uri
+		if ingest.Scheme == "" {
+			ingest.Scheme = "https"
+		}
+		ingest.RawQuery = ""
+		ingest.Path = strings.TrimRight(ingest.Path, "/") + datapointPath
+		endpoint = ingest.String()
+	} else {
+		realm := opts.Get("realm")
+		if realm == "" {
+			realm = defaultRealm
+		}
+		endpoint = fmt.Sprintf("https://ingest.%s.signalfx.com%s", realm, datapointPath)
+	}
+
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+	if creds.Get().Token == "" {
+		return nil, fmt.Errorf("the access token of the organization is required, as the token option")
+	}
+	dimensions, err := parseDimensions(opts["dimensions"])
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	sink := &signalFxSink{
+		endpoint:      endpoint,
+		client:        &http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}},
+		credentials:   creds,
+		prefix:        defaultPrefix,
+		dimensions:    dimensions,
+		maxDatapoints: defaultMaxDatapoints,
+	}
+	if values, found := opts["prefix"]; found && len(values) > 0 {
+		sink.prefix = values[0]
+	}
+	if value := opts.Get("maxDatapointsPerRequest"); value != "" {
+		if sink.maxDatapoints, err = strconv.Atoi(value); err != nil || sink.maxDatapoints <= 0 {
+			return nil, fmt.Errorf("invalid maxDatapointsPerRequest option %q", value)
+		}
+	}
+	glog.Infof("created SignalFx sink with endpoint: %v", endpoint)
+	return sink, nil
+}
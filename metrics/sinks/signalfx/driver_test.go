@@ -0,0 +1,103 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signalfx
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+func TestExportData(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := sinktest.NewServer(t, requests, nil)
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "?token=secret&dimensions=cluster:prod&maxDatapointsPerRequest=2")
+	require.NoError(t, err)
+	sink, err := NewSignalFxSink(uri)
+	require.NoError(t, err)
+
+	batch := sinktest.Batch()
+	node := batch.MetricSets[core.NodeKey("node-1")]
+	node.Labels["sf_hostname"] = "reserved"
+	node.Labels["app.kubernetes.io/name"] = "web"
+	sink.ExportData(batch)
+
+	// Four datapoints are sent in two requests.
+	require.Equal(t, 2, len(requests))
+	gauges := map[string]datapoint{}
+	counters := map[string]datapoint{}
+	for i := 0; i < 2; i++ {
+		req := <-requests
+		assert.Equal(t, "/v2/datapoint", req.Path)
+		assert.Equal(t, "secret", req.Header.Get("X-SF-Token"))
+		assert.Equal(t, sinktest.BatchID, req.Header.Get(util.BatchIDHeader))
+		request := datapoints{}
+		require.NoError(t, json.Unmarshal(req.Body, &request))
+		for _, point := range request.Gauge {
+			gauges[point.Dimensions["type"]+"/"+point.Metric] = point
+		}
+		for _, point := range request.CumulativeCounter {
+			counters[point.Dimensions["type"]+"/"+point.Metric] = point
+		}
+	}
+	assert.Equal(t, 3, len(gauges))
+	assert.Equal(t, 1, len(counters))
+	cpu, found := counters["node/heapster.cpu.usage"]
+	require.True(t, found)
+	assert.Equal(t, float64(100), cpu.Value)
+	assert.Equal(t, int64(1500000000000), cpu.Timestamp)
+	assert.Equal(t, map[string]string{
+		"type":                   "node",
+		"nodename":               "node-1",
+		"k8s_sf_hostname":        "reserved",
+		"app_kubernetes_io_name": "web",
+		"cluster":                "prod",
+	}, cpu.Dimensions)
+	assert.Equal(t, float64(100), gauges["pod/heapster.memory.usage"].Value)
+	assert.Equal(t, float64(1024), gauges["node/heapster.memory.usage"].Value)
+	filesystem, found := gauges["node/heapster.filesystem.usage"]
+	require.True(t, found)
+	assert.Equal(t, 0.5, filesystem.Value)
+	assert.Equal(t, "/dev/sda1", filesystem.Dimensions["resource_id"])
+}
+
+func TestEndpoints(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"?token=t":                         "https://ingest.us0.signalfx.com/v2/datapoint",
+		"?token=t&realm=eu0":               "https://ingest.eu0.signalfx.com/v2/datapoint",
+		"http://smart-agent:9080/?token=t": "http://smart-agent:9080/v2/datapoint",
+		"//ingest.example.com/sfx?token=t": "https://ingest.example.com/sfx/v2/datapoint",
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := NewSignalFxSink(parsed)
+		require.NoError(t, err, uri)
+		assert.Equal(t, expected, sink.(*signalFxSink).endpoint, uri)
+	}
+	for _, uri := range []string{"?realm=us1", "?token=t&dimensions=cluster", "?token=t&maxDatapointsPerRequest=0"} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewSignalFxSink(parsed)
+		assert.Error(t, err, uri)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sinktest holds the fixtures shared by the tests of the sinks.
+package sinktest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+// Timestamp of the batch returned by Batch.
+var Timestamp = time.Unix(1500000000, 0)
+
+// BatchID is the ID of the batch returned by Batch.
+const BatchID = "20170714T024000Z-00000001"
+
+// Batch returns a batch with a node and a pod, with metrics of every value and metric type,
+// a labeled metric, and an empty label which the sinks dropping empty labels skip. Tests
+// change its copy to cover the escaping of their wire format.
+func Batch() *core.DataBatch {
+	return &core.DataBatch{
+		Timestamp: Timestamp,
+		ID:        BatchID,
+		DedupKey:  &core.DedupKey{Cluster: "prod", Replica: "heapster-1", Timestamp: Timestamp},
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("node-1"): {
+				ScrapeTime: Timestamp,
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					core.LabelNodename.Key:      "node-1",
+					core.LabelHostname.Key:      "",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsage.Name:    {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 100},
+					core.MetricMemoryUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1024},
+				},
+				LabeledMetrics: []core.LabeledMetric{{
+					Name:        core.MetricFilesystemUsage.Name,
+					Labels:      map[string]string{core.LabelResourceID.Key: "/dev/sda1"},
+					MetricValue: core.MetricValue{ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: 0.5},
+				}},
+			},
+			core.PodKey("default", "web-1"): {
+				ScrapeTime: Timestamp,
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelNamespaceName.Key: "default",
+					core.LabelPodName.Key:       "web-1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricMemoryUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 100},
+				},
+			},
+		},
+	}
+}
+
+// Request is a request received by the server of NewServer.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// The body, decompressed if it was gzipped.
+	Body []byte
+}
+
+// Lines returns the lines of the body, sorted.
+func (this Request) Lines() []string {
+	lines := strings.Split(strings.TrimSpace(string(this.Body)), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+// BasicAuth returns the credentials of the request, as http.Request.BasicAuth.
+func (this Request) BasicAuth() (string, string, bool) {
+	return (&http.Request{Header: this.Header}).BasicAuth()
+}
+
+// NewServer returns a server sending the requests it receives to the channel before passing
+// them to the handler, if any.
+func NewServer(t *testing.T, requests chan<- Request, handler http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read the request: %v", err)
+		}
+		decoded := body
+		if r.Header.Get("Content-Encoding") == "gzip" || r.Header.Get("Content-Type") == "application/gzip" {
+			reader, err := gzip.NewReader(bytes.NewReader(body))
+			if err == nil {
+				decoded, err = ioutil.ReadAll(reader)
+			}
+			if err != nil {
+				t.Errorf("Failed to decompress the request: %v", err)
+			}
+		}
+		requests <- Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header, Body: decoded}
+		if handler != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			handler(w, r)
+		}
+	}))
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package splunk implements a sink posting the metrics to a Splunk HTTP Event Collector, as
+// events of a metrics index.
+package splunk
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultSource     = "heapster"
+	defaultSourcetype = "heapster:metrics"
+	defaultMaxEvents  = 1000
+	eventPath         = "/services/collector/event"
+	// The prefix of the fields holding the values of the multiple-metric events of Splunk 8.
+	metricNamePrefix = "metric_name:"
+)
+
+// event is a metric event of the collector: the values of a metric set, or of a labeled metric,
+// as metric_name:<name> fields next to the labels, which are the dimensions.
+type event struct {
+	Time       float64                `json:"time"`
+	Event      string                 `json:"event"`
+	Host       string                 `json:"host,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	Sourcetype string                 `json:"sourcetype,omitempty"`
+	Index      string                 `json:"index,omitempty"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+type splunkSink struct {
+	sync.Mutex
+	endpoint    string
+	client      *http.Client
+	credentials *credentials.Credentials
+	source      string
+	sourcetype  string
+	index       string
+	// Number of events above which a batch is sent in several requests.
+	maxEvents int
+}
+
+func (this *splunkSink) Name() string {
+	return "Splunk HEC Sink"
+}
+
+func (this *splunkSink) Stop() {}
+
+func (this *splunkSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	events := this.toEvents(batch)
+	for start := 0; start < len(events); start += this.maxEvents {
+		end := start + this.maxEvents
+		if end > len(events) {
+			end = len(events)
+		}
+		if err := this.send(batch, events[start:end]); err != nil {
+			glog.Errorf("[batch %s] Failed to send %d events to the Splunk collector %s: %v", batch.ID, end-start, this.endpoint, err)
+		}
+	}
+}
+
+func (this *splunkSink) toEvents(batch *core.DataBatch) []*event {
+	events := []*event{}
+	newEvent := func(labels map[string]string, extraLabels map[string]string, timestamp time.Time) *event {
+		e := &event{
+			Time:       float64(timestamp.UnixNano()/int64(time.Millisecond)) / 1000,
+			Event:      "metric",
+			Host:       labels[core.LabelHostname.Key],
+			Source:     this.source,
+			Sourcetype: this.sourcetype,
+			Index:      this.index,
+			Fields:     map[string]interface{}{},
+		}
+		for _, labelSet := range []map[string]string{labels, extraLabels} {
+			for key, value := range labelSet {
+				if value != "" {
+					e.Fields[key] = value
+				}
+			}
+		}
+		return e
+	}
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		if len(metricSet.MetricValues) > 0 {
+			e := newEvent(metricSet.Labels, nil, timestamp)
+			for name, value := range metricSet.MetricValues {
+				e.Fields[metricNamePrefix+name] = value.GetValue()
+			}
+			events = append(events, e)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			e := newEvent(metricSet.Labels, metric.Labels, timestamp)
+			e.Fields[metricNamePrefix+metric.Name] = metric.GetValue()
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// send posts the events of the batch, concatenated as the collector expects them.
+func (this *splunkSink) send(batch *core.DataBatch, events []*event) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest("POST", this.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+this.credentials.Get().Token)
+	util.SetBatchHeaders(req.Header, batch)
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		contents, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return nil
+}
+
+// NewSplunkSink returns a sink posting to the collector of the uri, e.g.
+// https://splunk:8088?tokenFile=/etc/splunk/hec-token&index=k8s_metrics. The event endpoint of
+// the collector is used if the uri has no path, and the scheme defaults to https.
+func NewSplunkSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing host in Splunk collector endpoint %q", uri.String())
+	}
+	endpoint := *uri
+	if endpoint.Scheme == "" {
+		endpoint.Scheme = "https"
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = eventPath
+	}
+	endpoint.RawQuery = ""
+
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+	if creds.Get().Token == "" {
+		return nil, fmt.Errorf("the token of the collector is required, as the token option")
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if value := opts.Get("insecure"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure option %q: %v", value, err)
+		}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	}
+	sink := &splunkSink{
+		endpoint:    endpoint.String(),
+		client:      &http.Client{Timeout: timeout, Transport: transport},
+		credentials: creds,
+		source:      defaultSource,
+		sourcetype:  defaultSourcetype,
+		index:       opts.Get("index"),
+		maxEvents:   defaultMaxEvents,
+	}
+	if values, found := opts["source"]; found && len(values) > 0 {
+		sink.source = values[0]
+	}
+	if values, found := opts["sourcetype"]; found && len(values) > 0 {
+		sink.sourcetype = values[0]
+	}
+	if value := opts.Get("maxEventsPerRequest"); value != "" {
+		if sink.maxEvents, err = strconv.Atoi(value); err != nil || sink.maxEvents <= 0 {
+			return nil, fmt.Errorf("invalid maxEventsPerRequest option %q", value)
+		}
+	}
+	glog.Infof("created Splunk HEC sink with endpoint: %v", sink.endpoint)
+	return sink, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package splunk
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+func TestExportData(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := sinktest.NewServer(t, requests, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"text": "Success", "code": 0}`))
+	})
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "?token=secret&index=k8s&sourcetype=kube:metrics&maxEventsPerRequest=1")
+	require.NoError(t, err)
+	sink, err := NewSplunkSink(uri)
+	require.NoError(t, err)
+
+	batch := sinktest.Batch()
+	nodeSet := batch.MetricSets[core.NodeKey("node-1")]
+	nodeSet.ScrapeTime = nodeSet.ScrapeTime.Add(1500 * time.Millisecond)
+	nodeSet.Labels[core.LabelHostname.Key] = "node-1.example.com"
+	sink.ExportData(batch)
+
+	// The metric values of a set are sent in one event, and each labeled metric in an event of its own.
+	require.Equal(t, 3, len(requests))
+	events := map[string]map[string]interface{}{}
+	for i := 0; i < 3; i++ {
+		req := <-requests
+		assert.Equal(t, "/services/collector/event", req.Path)
+		assert.Equal(t, "Splunk secret", req.Header.Get("Authorization"))
+		assert.Equal(t, sinktest.BatchID, req.Header.Get(util.BatchIDHeader))
+		decoder := json.NewDecoder(bytes.NewReader(req.Body))
+		for {
+			e := map[string]interface{}{}
+			if err := decoder.Decode(&e); err == io.EOF {
+				break
+			} else {
+				require.NoError(t, err)
+			}
+			fields := e["fields"].(map[string]interface{})
+			key := fields["type"].(string)
+			if resource, found := fields["resource_id"]; found {
+				key += "/" + resource.(string)
+			}
+			events[key] = e
+		}
+	}
+	node := events["node"]
+	assert.Equal(t, 1500000001.5, node["time"])
+	assert.Equal(t, "metric", node["event"])
+	assert.Equal(t, "node-1.example.com", node["host"])
+	assert.Equal(t, "heapster", node["source"])
+	assert.Equal(t, "kube:metrics", node["sourcetype"])
+	assert.Equal(t, "k8s", node["index"])
+	assert.Equal(t, map[string]interface{}{
+		"type":                     "node",
+		"nodename":                 "node-1",
+		"hostname":                 "node-1.example.com",
+		"metric_name:memory/usage": float64(1024),
+		"metric_name:cpu/usage":    float64(100),
+	}, node["fields"])
+	filesystem := events["node//dev/sda1"]["fields"].(map[string]interface{})
+	assert.Equal(t, 0.5, filesystem["metric_name:filesystem/usage"])
+	_, found := filesystem["metric_name:memory/usage"]
+	assert.False(t, found)
+	pod := events["pod"]
+	assert.Equal(t, 1500000000.0, pod["time"])
+	assert.Equal(t, float64(100), pod["fields"].(map[string]interface{})["metric_name:memory/usage"])
+}
+
+func TestNewSplunkSink(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"//splunk:8088?token=t":                              "https://splunk:8088/services/collector/event",
+		"http://splunk:8088/services/collector?token=t":      "http://splunk:8088/services/collector",
+		"https://splunk:8088/?token=t&insecure=true&index=i": "https://splunk:8088/services/collector/event",
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := NewSplunkSink(parsed)
+		require.NoError(t, err, uri)
+		assert.Equal(t, expected, sink.(*splunkSink).endpoint, uri)
+	}
+	for _, uri := range []string{"?token=t", "//splunk:8088", "//splunk:8088?token=t&insecure=maybe"} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewSplunkSink(parsed)
+		assert.Error(t, err, uri)
+	}
+}
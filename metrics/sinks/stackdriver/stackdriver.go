@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -34,8 +35,11 @@ import (
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
 	grpc_codes "google.golang.org/grpc/codes"
 	grpc_status "google.golang.org/grpc/status"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
 	gce_util "k8s.io/heapster/common/gce"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
 )
 
 const (
@@ -56,6 +60,12 @@ type StackdriverSink struct {
 	initialDelaySec       int
 	useOldResourceModel   bool
 	useNewResourceModel   bool
+	// Metrics of namespaced objects are exported to the project named by this annotation of
+	// their namespace, if set.
+	projectAnnotation string
+	namespaceStore    cache.Store
+	// Projects of namespaces without the annotation, by namespace name.
+	namespaceProjects map[string]string
 }
 
 type metricMetadata struct {
@@ -131,8 +141,31 @@ func (sink *StackdriverSink) ExportData(dataBatch *core.DataBatch) {
 	}
 	sink.lastExportTime = dataBatch.Timestamp
 
+	go sink.sendRequests(dataBatch, sink.buildRequests(dataBatch))
+}
+
+// buildRequests translates the batch into requests of at most maxTimeseriesPerRequest time
+// series, each sent to the project of the objects it contains.
+func (sink *StackdriverSink) buildRequests(dataBatch *core.DataBatch) []*monitoringpb.CreateTimeSeriesRequest {
 	requests := []*monitoringpb.CreateTimeSeriesRequest{}
-	req := getReq(sink.project)
+	// Requests being filled, by project.
+	pending := map[string]*monitoringpb.CreateTimeSeriesRequest{}
+	add := func(project string, ts *monitoringpb.TimeSeries) {
+		if project != sink.project {
+			ts.Resource.Labels["project_id"] = project
+		}
+		req, found := pending[project]
+		if !found {
+			req = getReq(project)
+			pending[project] = req
+		}
+		req.TimeSeries = append(req.TimeSeries, ts)
+		if len(req.TimeSeries) >= maxTimeseriesPerRequest {
+			requests = append(requests, req)
+			delete(pending, project)
+		}
+	}
+
 	for key, metricSet := range dataBatch.MetricSets {
 		switch metricSet.Labels["type"] {
 		case core.MetricSetTypeNode, core.MetricSetTypePod, core.MetricSetTypePodContainer, core.MetricSetTypeSystemContainer:
@@ -160,47 +193,60 @@ func (sink *StackdriverSink) ExportData(dataBatch *core.DataBatch) {
 
 		timeseries = append(timeseries, derivedTimeseries...)
 
+		project := sink.projectOf(metricSet.Labels)
 		for _, ts := range timeseries {
-			req.TimeSeries = append(req.TimeSeries, ts)
-			if len(req.TimeSeries) >= maxTimeseriesPerRequest {
-				requests = append(requests, req)
-				req = getReq(sink.project)
-			}
+			add(project, ts)
 		}
 
 		for _, metric := range metricSet.LabeledMetrics {
 			if sink.useOldResourceModel {
 				if point := sink.LegacyTranslateLabeledMetric(dataBatch.Timestamp, metricSet.Labels, metric, metricSet.CollectionStartTime); point != nil {
-					req.TimeSeries = append(req.TimeSeries, point)
-				}
-
-				if len(req.TimeSeries) >= maxTimeseriesPerRequest {
-					requests = append(requests, req)
-					req = getReq(sink.project)
+					add(project, point)
 				}
 			}
 			if sink.useNewResourceModel {
-				point := sink.TranslateLabeledMetric(dataBatch.Timestamp, metricSet.Labels, metric, metricSet.CollectionStartTime)
-				if point != nil {
-					req.TimeSeries = append(req.TimeSeries, point)
-				}
-
-				if len(req.TimeSeries) >= maxTimeseriesPerRequest {
-					requests = append(requests, req)
-					req = getReq(sink.project)
+				if point := sink.TranslateLabeledMetric(dataBatch.Timestamp, metricSet.Labels, metric, metricSet.CollectionStartTime); point != nil {
+					add(project, point)
 				}
 			}
 		}
 	}
 
-	if len(req.TimeSeries) > 0 {
-		requests = append(requests, req)
+	projects := make([]string, 0, len(pending))
+	for project := range pending {
+		projects = append(projects, project)
 	}
+	sort.Strings(projects)
+	for _, project := range projects {
+		requests = append(requests, pending[project])
+	}
+	return requests
+}
 
-	go sink.sendRequests(requests)
+// projectOf returns the project metrics of the object with the given labels are exported to.
+// Objects outside of namespaces, like nodes, always go to the project Heapster runs in.
+func (sink *StackdriverSink) projectOf(labels map[string]string) string {
+	namespace := labels[core.LabelNamespaceName.Key]
+	if namespace == "" {
+		return sink.project
+	}
+	if sink.namespaceStore != nil {
+		obj, exists, err := sink.namespaceStore.GetByKey(namespace)
+		if err != nil {
+			glog.Warningf("Failed to get namespace %s: %v", namespace, err)
+		} else if ns, ok := obj.(*kube_api.Namespace); exists && ok {
+			if project := ns.Annotations[sink.projectAnnotation]; project != "" {
+				return project
+			}
+		}
+	}
+	if project, found := sink.namespaceProjects[namespace]; found {
+		return project
+	}
+	return sink.project
 }
 
-func (sink *StackdriverSink) sendRequests(requests []*monitoringpb.CreateTimeSeriesRequest) {
+func (sink *StackdriverSink) sendRequests(dataBatch *core.DataBatch, requests []*monitoringpb.CreateTimeSeriesRequest) {
 	// Each worker can handle at least batchExportTimeout/sdRequestLatencySec requests within the specified period.
 	// 5 extra workers just in case.
 	workers := 5 + len(requests)/(sink.batchExportTimeoutSec/sdRequestLatencySec)
@@ -209,7 +255,7 @@ func (sink *StackdriverSink) sendRequests(requests []*monitoringpb.CreateTimeSer
 
 	// Launch Go routines responsible for sending requests
 	for i := 0; i < workers; i++ {
-		go sink.requestSender(requestQueue, completedQueue)
+		go sink.requestSender(dataBatch, requestQueue, completedQueue)
 	}
 
 	timeout := time.Duration(sink.batchExportTimeoutSec) * time.Second
@@ -222,7 +268,7 @@ forloop:
 		case requestQueue <- r:
 			// yet another request added to queue
 		case <-timeoutSending:
-			glog.Warningf("Timeout while exporting metrics to Stackdriver. Dropping %d out of %d requests.", len(requests)-i, len(requests))
+			glog.Warningf("[batch %s] Timeout while exporting metrics to Stackdriver. Dropping %d out of %d requests.", dataBatch.ID, len(requests)-i, len(requests))
 			// TODO(piosz): consider cancelling requests in flight
 			// Report dropped requests in metrics.
 			for _, req := range requests[i:] {
@@ -248,19 +294,19 @@ forloop:
 				return
 			}
 		case <-timeoutCompleted:
-			glog.Warningf("Only %d out of %d workers successfully finished sending requests to SD. Some metrics might be lost.", workersCompleted, workers)
+			glog.Warningf("[batch %s] Only %d out of %d workers successfully finished sending requests to SD. Some metrics might be lost.", dataBatch.ID, workersCompleted, workers)
 			return
 		}
 	}
 }
 
-func (sink *StackdriverSink) requestSender(reqQueue chan *monitoringpb.CreateTimeSeriesRequest, completedQueue chan bool) {
+func (sink *StackdriverSink) requestSender(dataBatch *core.DataBatch, reqQueue chan *monitoringpb.CreateTimeSeriesRequest, completedQueue chan bool) {
 	defer func() {
 		completedQueue <- true
 	}()
 	time.Sleep(time.Duration(rand.Intn(1000*sink.initialDelaySec)) * time.Millisecond)
 	for req := range reqQueue {
-		sink.sendOneRequest(req)
+		sink.sendOneRequest(dataBatch, req)
 	}
 }
 
@@ -273,13 +319,13 @@ func marshalRequestAndLog(printer func([]byte), req *monitoringpb.CreateTimeSeri
 	}
 }
 
-func (sink *StackdriverSink) sendOneRequest(req *monitoringpb.CreateTimeSeriesRequest) {
+func (sink *StackdriverSink) sendOneRequest(dataBatch *core.DataBatch, req *monitoringpb.CreateTimeSeriesRequest) {
 	startTime := time.Now()
 	err := sink.stackdriverClient.CreateTimeSeries(context.Background(), req)
 
 	var responseCode grpc_codes.Code
 	if err != nil {
-		glog.Warningf("Error while sending request to Stackdriver %v", err)
+		glog.Warningf("[batch %s] Error while sending request to Stackdriver %v", dataBatch.ID, err)
 		// Convert request to json and log it, but only if logging level is equal to 2 or more.
 		if glog.V(2) {
 			marshalRequestAndLog(func(reqJson []byte) {
@@ -308,7 +354,7 @@ func (sink *StackdriverSink) sendOneRequest(req *monitoringpb.CreateTimeSeriesRe
 	requestLatency.Observe(time.Since(startTime).Seconds() / time.Millisecond.Seconds())
 }
 
-func CreateStackdriverSink(uri *url.URL) (core.DataSink, error) {
+func CreateStackdriverSink(uri *url.URL, kubernetesUrl *url.URL) (core.DataSink, error) {
 	if len(uri.Scheme) > 0 {
 		return nil, fmt.Errorf("Scheme should not be set for Stackdriver sink")
 	}
@@ -351,6 +397,29 @@ func CreateStackdriverSink(uri *url.URL) (core.DataSink, error) {
 		}
 	}
 
+	namespaceProjects := map[string]string{}
+	if len(opts["namespace_projects"]) >= 1 && opts["namespace_projects"][0] != "" {
+		for _, mapping := range strings.Split(opts["namespace_projects"][0], ",") {
+			parts := strings.SplitN(mapping, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("Namespace projects should be a comma-separated list of namespace:project pairs, found: %v", mapping)
+			}
+			namespaceProjects[parts[0]] = parts[1]
+		}
+	}
+
+	var projectAnnotation string
+	var namespaceStore cache.Store
+	if len(opts["project_annotation"]) >= 1 && opts["project_annotation"][0] != "" {
+		projectAnnotation = opts["project_annotation"][0]
+		if kubernetesUrl == nil {
+			return nil, fmt.Errorf("Project annotation requires a Kubernetes source")
+		}
+		if namespaceStore, err = util.GetSharedNamespaceStore(kubernetesUrl); err != nil {
+			return nil, err
+		}
+	}
+
 	var projectId, heapsterZone string
 	// Cluster name and location are required when useNewResourceModel is true.
 	var clusterName, clusterLocation string
@@ -441,6 +510,9 @@ func CreateStackdriverSink(uri *url.URL) (core.DataSink, error) {
 		initialDelaySec:       initialDelaySec,
 		useOldResourceModel:   useOldResourceModel,
 		useNewResourceModel:   useNewResourceModel,
+		projectAnnotation:     projectAnnotation,
+		namespaceStore:        namespaceStore,
+		namespaceProjects:     namespaceProjects,
 	}
 
 	// Register sink metrics
@@ -20,6 +20,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/heapster/metrics/core"
 )
 
@@ -377,3 +380,60 @@ func TestTranslateMetricSet(t *testing.T) {
 	as.Equal(int64(6), containerEphemeralStorageRequest.GetInt64Value())
 	as.Equal(int64(7), containerEphemeralStorageLimit.GetInt64Value())
 }
+
+func TestExportToNamespaceProjects(t *testing.T) {
+	as := assert.New(t)
+
+	namespaceStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	namespaceStore.Add(&kube_api.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team-b",
+		Annotations: map[string]string{"example.com/project": "project-b"},
+	}})
+	routingSink := &StackdriverSink{
+		project:             testProjectId,
+		useNewResourceModel: true,
+		projectAnnotation:   "example.com/project",
+		namespaceStore:      namespaceStore,
+		namespaceProjects:   map[string]string{"team-a": "project-a", "team-b": "ignored"},
+	}
+
+	timestamp := time.Now()
+	metricSet := func(labels map[string]string) *core.MetricSet {
+		return &core.MetricSet{
+			Labels:              labels,
+			CollectionStartTime: timestamp.Add(-time.Hour),
+			MetricValues: map[string]core.MetricValue{
+				core.MetricNetworkRx.MetricDescriptor.Name: generateIntMetric(1),
+			},
+		}
+	}
+	podIn := func(namespace string) map[string]string {
+		return map[string]string{"type": "pod", "namespace_name": namespace, "pod_name": "web"}
+	}
+	requests := routingSink.buildRequests(&core.DataBatch{
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			"node:n1":               metricSet(map[string]string{"type": "node", "nodename": "n1"}),
+			"namespace:team-a/pod:": metricSet(podIn("team-a")),
+			"namespace:team-b/pod:": metricSet(podIn("team-b")),
+			"namespace:team-c/pod:": metricSet(podIn("team-c")),
+		},
+	})
+
+	projects := map[string][]string{}
+	for _, req := range requests {
+		for _, ts := range req.TimeSeries {
+			as.Equal(fullProjectName(ts.Resource.Labels["project_id"]), req.Name)
+			projects[req.Name] = append(projects[req.Name], ts.Resource.Type+"/"+ts.Resource.Labels["namespace_name"])
+		}
+	}
+	as.Equal(map[string][]string{
+		"projects/project-a": {"k8s_pod/team-a"},
+		"projects/project-b": {"k8s_pod/team-b"},
+	}, map[string][]string{
+		"projects/project-a": projects["projects/project-a"],
+		"projects/project-b": projects["projects/project-b"],
+	})
+	as.Len(projects[fullProjectName(testProjectId)], 2)
+	as.Len(requests, 3)
+}
@@ -129,7 +129,7 @@ func (sink *statsdSink) ExportData(dataBatch *core.DataBatch) {
 		for metricName, metricValue := range metricSet.MetricValues {
 			tmpstr, err = sink.formatter.Format(sink.config.prefix, metricName, metricSetLabels, sink.config.customizeLabel, metricValue)
 			if err != nil {
-				glog.Errorf("statsd metrics sink - failed to format metrics : %s", err.Error())
+				glog.Errorf("[batch %s] statsd metrics sink - failed to format metrics : %s", dataBatch.ID, err.Error())
 				continue
 			}
 			metrics = append(metrics, tmpstr)
@@ -147,7 +147,7 @@ func (sink *statsdSink) ExportData(dataBatch *core.DataBatch) {
 			}
 			tmpstr, err = sink.formatter.Format(sink.config.prefix, metric.Name, labels, sink.config.customizeLabel, metric.MetricValue)
 			if err != nil {
-				glog.Errorf("statsd metrics sink - failed to format labeled metrics : %v", err)
+				glog.Errorf("[batch %s] statsd metrics sink - failed to format labeled metrics : %v", dataBatch.ID, err)
 				continue
 			}
 			metrics = append(metrics, tmpstr)
@@ -156,7 +156,7 @@ func (sink *statsdSink) ExportData(dataBatch *core.DataBatch) {
 	glog.V(5).Infof("Sending metrics --- %s", metrics)
 	err = sink.client.send(metrics)
 	if err != nil {
-		glog.Errorf("statsd metrics sink - failed to send some metrics : %v", err)
+		glog.Errorf("[batch %s] statsd metrics sink - failed to send some metrics : %v", dataBatch.ID, err)
 	}
 }
 
@@ -0,0 +1,324 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package syslog implements a sink sending one RFC 5424 syslog message per metric value, over
+// UDP, TCP or TLS, with the labels of the value as structured data.
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/lineprotocol"
+)
+
+const (
+	defaultTimeout  = 10 * time.Second
+	defaultAppName  = "heapster"
+	defaultMsgId    = "metric"
+	defaultTemplate = `{{.Name}}={{.Value}}`
+	// The SD-ID of the structured data, under the enterprise number reserved for documentation
+	// by RFC 5612.
+	defaultSdId = "heapster@32473"
+	// The informational severity.
+	severity = 6
+	// Longest parameter name of the structured data.
+	maxParamName = 32
+	nilValue     = "-"
+)
+
+// Facilities by name, as in RFC 5424.
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+type syslogSink struct {
+	sync.Mutex
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	conn      net.Conn
+
+	facility int
+	hostname string
+	appName  string
+	procId   string
+	sdId     string
+	// Renders the MSG part of the messages, given a lineprotocol.Point.
+	template *template.Template
+}
+
+func (this *syslogSink) Name() string {
+	return "Syslog Sink"
+}
+
+func (this *syslogSink) Stop() {
+	this.Lock()
+	defer this.Unlock()
+	this.disconnect()
+}
+
+func (this *syslogSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	messages := [][]byte{}
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range metricSet.MetricValues {
+			messages = this.appendMessage(messages, batch, name, value, metricSet.Labels, nil, timestamp)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			messages = this.appendMessage(messages, batch, metric.Name, metric.MetricValue, metricSet.Labels, metric.Labels, timestamp)
+		}
+	}
+	if err := this.send(messages); err != nil {
+		glog.Errorf("[batch %s] Failed to send %d syslog messages to %s: %v", batch.ID, len(messages), this.address, err)
+	}
+}
+
+func (this *syslogSink) appendMessage(messages [][]byte, batch *core.DataBatch, name string, value core.MetricValue,
+	labels, metricLabels map[string]string, timestamp time.Time) [][]byte {
+	tags := map[string]string{}
+	for _, source := range []map[string]string{labels, metricLabels} {
+		for k, v := range source {
+			if v != "" {
+				tags[k] = v
+			}
+		}
+	}
+	point := lineprotocol.Point{Name: name, Value: formatValue(value), Tags: tags, Timestamp: timestamp}
+	var msg bytes.Buffer
+	if err := this.template.Execute(&msg, point); err != nil {
+		glog.Errorf("[batch %s] Failed to render the syslog message of %s: %v", batch.ID, name, err)
+		return messages
+	}
+	hostname := this.hostname
+	if nodename := tags[core.LabelNodename.Key]; nodename != "" {
+		hostname = nodename
+	}
+	return append(messages, []byte(formatMessage(this.facility*8+severity, timestamp, hostname, this.appName,
+		this.procId, defaultMsgId, this.structuredData(name, point), msg.String())))
+}
+
+// structuredData returns the SD-ELEMENT of a point, with the name, the value and the tags of the
+// point as parameters.
+func (this *syslogSink) structuredData(name string, point lineprotocol.Point) string {
+	keys := make([]string, 0, len(point.Tags))
+	for key := range point.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sd bytes.Buffer
+	fmt.Fprintf(&sd, `[%s name="%s" value="%s"`, this.sdId, sdValueEscaper.Replace(name), point.Value)
+	for _, key := range keys {
+		paramName := sdName(key)
+		if paramName == "name" || paramName == "value" {
+			continue
+		}
+		fmt.Fprintf(&sd, ` %s="%s"`, paramName, sdValueEscaper.Replace(point.Tags[key]))
+	}
+	sd.WriteByte(']')
+	return sd.String()
+}
+
+// sdName returns a valid SD-NAME: up to 32 printable characters, without '=', ' ', ']' and '"'.
+func sdName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			name[i] = '_'
+		}
+	}
+	if len(name) > maxParamName {
+		name = name[:maxParamName]
+	}
+	return string(name)
+}
+
+// formatMessage formats a message as in RFC 5424, the empty fields as the nil value.
+func formatMessage(priority int, timestamp time.Time, hostname, appName, procId, msgId, sd, msg string) string {
+	fields := []string{hostname, appName, procId, msgId}
+	for i, field := range fields {
+		if field == "" {
+			fields[i] = nilValue
+		}
+	}
+	message := fmt.Sprintf("<%d>1 %s %s %s %s %s %s", priority, timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		fields[0], fields[1], fields[2], fields[3], sd)
+	if msg != "" {
+		message += " " + msg
+	}
+	return message
+}
+
+func formatValue(value core.MetricValue) string {
+	if value.ValueType == core.ValueFloat {
+		return strconv.FormatFloat(value.FloatValue, 'f', -1, 64)
+	}
+	return strconv.FormatInt(value.IntValue, 10)
+}
+
+// send writes the messages, one per datagram over UDP, framed with their length over TCP and TLS
+// as in RFC 6587. It reconnects once if the connection was lost.
+func (this *syslogSink) send(messages [][]byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if this.conn == nil {
+			if err = this.connect(); err != nil {
+				return err
+			}
+		}
+		this.conn.SetWriteDeadline(time.Now().Add(this.timeout))
+		if err = this.write(messages); err == nil {
+			return nil
+		}
+		this.disconnect()
+	}
+	return err
+}
+
+func (this *syslogSink) write(messages [][]byte) error {
+	if this.network == "udp" {
+		for _, message := range messages {
+			if _, err := this.conn.Write(message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	writer := bufio.NewWriter(this.conn)
+	for _, message := range messages {
+		if _, err := fmt.Fprintf(writer, "%d %s", len(message), message); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func (this *syslogSink) connect() error {
+	var conn net.Conn
+	var err error
+	if this.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: this.timeout}, "tcp", this.address, this.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout(this.network, this.address, this.timeout)
+	}
+	if err != nil {
+		return err
+	}
+	this.conn = conn
+	return nil
+}
+
+func (this *syslogSink) disconnect() {
+	if this.conn != nil {
+		this.conn.Close()
+		this.conn = nil
+	}
+}
+
+// NewSyslogSink creates a sink for uris of the form udp://host:514, tcp://host:601 or
+// tls://host:6514, with the facility, appName, sdId, template and timeout options.
+func NewSyslogSink(uri *url.URL) (core.DataSink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing address of the syslog server")
+	}
+	opts := uri.Query()
+	sink := &syslogSink{
+		network:  uri.Scheme,
+		address:  uri.Host,
+		timeout:  defaultTimeout,
+		facility: facilities["local0"],
+		appName:  defaultAppName,
+		procId:   strconv.Itoa(os.Getpid()),
+		sdId:     defaultSdId,
+	}
+	switch uri.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		sink.network = "tcp"
+		sink.tlsConfig = &tls.Config{}
+		if value := opts.Get("insecure"); value != "" {
+			insecure, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid insecure option %q: %v", value, err)
+			}
+			sink.tlsConfig.InsecureSkipVerify = insecure
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q, expected udp, tcp or tls", uri.Scheme)
+	}
+
+	if value := opts.Get("facility"); value != "" {
+		facility, found := facilities[value]
+		if !found {
+			return nil, fmt.Errorf("unknown facility %q", value)
+		}
+		sink.facility = facility
+	}
+	if value := opts.Get("appName"); value != "" {
+		sink.appName = value
+	}
+	if value := opts.Get("sdId"); value != "" {
+		if sdName(value) != value {
+			return nil, fmt.Errorf("invalid sdId %q", value)
+		}
+		sink.sdId = value
+	}
+	if value := opts.Get("hostname"); value != "" {
+		sink.hostname = value
+	} else if hostname, err := os.Hostname(); err == nil {
+		sink.hostname = hostname
+	}
+	text := defaultTemplate
+	if len(opts["template"]) > 0 {
+		text = opts["template"][0]
+	}
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	sink.template = tmpl
+	if value := opts.Get("timeout"); value != "" {
+		if sink.timeout, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("failed to parse timeout: %v", err)
+		}
+	}
+
+	glog.Infof("Created syslog sink writing to %s://%s", uri.Scheme, sink.address)
+	return sink, nil
+}
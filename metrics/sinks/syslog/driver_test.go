@@ -0,0 +1,135 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+)
+
+// testBatch gives the node of the shared batch a label that has to be escaped.
+func testBatch() *core.DataBatch {
+	batch := sinktest.Batch()
+	batch.MetricSets[core.NodeKey("node-1")].Labels["note"] = `a "quoted" ] value`
+	return batch
+}
+
+func newSink(t *testing.T, uri string) *syslogSink {
+	parsed, err := url.Parse(uri)
+	require.NoError(t, err)
+	sink, err := NewSyslogSink(parsed)
+	require.NoError(t, err)
+	return sink.(*syslogSink)
+}
+
+func TestExportDataTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan []string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		messages := []string{}
+		for len(messages) < 4 {
+			// Messages are framed by their length.
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			message := make([]byte, n)
+			if _, err := io.ReadFull(reader, message); err != nil {
+				break
+			}
+			messages = append(messages, string(message))
+		}
+		received <- messages
+	}()
+
+	sink := newSink(t, fmt.Sprintf("tcp://%s?facility=local3&hostname=heapster-1&template={{.Name}} is {{.Value}}", listener.Addr()))
+	defer sink.Stop()
+	sink.ExportData(testBatch())
+
+	messages := <-received
+	sort.Strings(messages)
+	pid := os.Getpid()
+	assert.Equal(t, []string{
+		// The priority of local3 and informational is 3*8+6.
+		fmt.Sprintf(`<158>1 2017-07-14T02:40:00.000000Z heapster-1 heapster %d metric [heapster@32473 name="memory/usage" value="100" namespace_name="default" pod_name="web-1" type="pod"] memory/usage is 100`, pid),
+		fmt.Sprintf(`<158>1 2017-07-14T02:40:00.000000Z node-1 heapster %d metric [heapster@32473 name="cpu/usage" value="100" nodename="node-1" note="a \"quoted\" \] value" type="node"] cpu/usage is 100`, pid),
+		fmt.Sprintf(`<158>1 2017-07-14T02:40:00.000000Z node-1 heapster %d metric [heapster@32473 name="filesystem/usage" value="0.5" nodename="node-1" note="a \"quoted\" \] value" resource_id="/dev/sda1" type="node"] filesystem/usage is 0.5`, pid),
+		fmt.Sprintf(`<158>1 2017-07-14T02:40:00.000000Z node-1 heapster %d metric [heapster@32473 name="memory/usage" value="1024" nodename="node-1" note="a \"quoted\" \] value" type="node"] memory/usage is 1024`, pid),
+	}, messages)
+}
+
+func TestExportDataUdp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink := newSink(t, fmt.Sprintf("udp://%s?appName=kube-metrics", conn.LocalAddr()))
+	defer sink.Stop()
+	sink.ExportData(testBatch())
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 4096)
+	for i := 0; i < 4; i++ {
+		// Each datagram holds a single message.
+		n, _, err := conn.ReadFrom(buffer)
+		require.NoError(t, err)
+		message := string(buffer[:n])
+		assert.True(t, strings.HasPrefix(message, "<134>1 2017-07-14T02:40:00.000000Z "), message)
+		assert.Contains(t, message, " kube-metrics ")
+		assert.Equal(t, 1, strings.Count(message, "<134>1"), message)
+	}
+}
+
+func TestNewSyslogSink(t *testing.T) {
+	for _, uri := range []string{
+		"udp://",
+		"unix:///dev/log",
+		"tcp://syslog:601?facility=local9",
+		"tls://syslog:6514?insecure=maybe",
+		"tcp://syslog:601?sdId=a%20b",
+		"tcp://syslog:601?template={{.Name",
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewSyslogSink(parsed)
+		assert.Error(t, err, uri)
+	}
+	sink := newSink(t, "tls://syslog:6514?insecure=true")
+	assert.Equal(t, "tcp", sink.network)
+	assert.True(t, sink.tlsConfig.InsecureSkipVerify)
+}
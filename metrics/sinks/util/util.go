@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package util holds the helpers shared by the sinks.
+package util
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+// Header of the requests of the HTTP sinks holding the ID of the batch they export, so that
+// the requests logged by the backends can be matched with the log lines of Heapster.
+const BatchIDHeader = "X-Heapster-Batch-ID"
+
+// Header of the requests of the HTTP sinks holding the dedup key of the batch they export, if
+// set, so that the backends can drop the copies exported by the redundant replicas.
+const DedupKeyHeader = "X-Heapster-Dedup-Key"
+
+// Headers of the requests of the HTTP sinks holding the checksum of the batch they export and
+// its number of points, so that the backends can detect points lost or altered on the way, e.g.
+// by proxies. See core.BatchChecksum.
+const (
+	BatchChecksumHeader = "X-Heapster-Batch-Checksum"
+	BatchPointsHeader   = "X-Heapster-Batch-Points"
+)
+
+// Header of the requests of the HTTP sinks exporting a part of a batch, see core.BatchPart,
+// holding the index of the part, followed by "; last" on the last part. The checksum and the
+// number of points are those of the part.
+const BatchPartHeader = "X-Heapster-Batch-Part"
+
+// The checksum of the last batch with an ID, which the sinks export at the same time, often in
+// several requests.
+var (
+	checksumLock    sync.Mutex
+	checksumBatch   *core.DataBatch
+	checksumBatchID string
+	checksum        string
+	checksumPoints  int
+)
+
+// SetBatchHeaders sets the headers identifying the batch on a request exporting it, and its
+// checksum.
+func SetBatchHeaders(header http.Header, batch *core.DataBatch) {
+	if batch.ID != "" {
+		header.Set(BatchIDHeader, batch.ID)
+	}
+	if batch.DedupKey != nil {
+		header.Set(DedupKeyHeader, batch.DedupKey.String())
+	}
+	if batch.Part != nil {
+		part := strconv.Itoa(batch.Part.Index)
+		if batch.Part.Last {
+			part += "; last"
+		}
+		header.Set(BatchPartHeader, part)
+	}
+	sum, points := batchChecksum(batch)
+	header.Set(BatchChecksumHeader, sum)
+	header.Set(BatchPointsHeader, strconv.Itoa(points))
+}
+
+func batchChecksum(batch *core.DataBatch) (string, int) {
+	if batch.ID == "" {
+		return core.BatchChecksum(batch)
+	}
+	checksumLock.Lock()
+	defer checksumLock.Unlock()
+	if batch != checksumBatch || batch.ID != checksumBatchID {
+		checksum, checksumPoints = core.BatchChecksum(batch)
+		checksumBatch, checksumBatchID = batch, batch.ID
+	}
+	return checksum, checksumPoints
+}
+
+// NonEmptyLabels returns the labels with a value, those of the metric overriding those of the set.
+func NonEmptyLabels(labels, metricLabels map[string]string) map[string]string {
+	result := map[string]string{}
+	for _, source := range []map[string]string{labels, metricLabels} {
+		for k, v := range source {
+			if v != "" {
+				result[k] = v
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestSetBatchHeaders(t *testing.T) {
+	header := http.Header{}
+	SetBatchHeaders(header, &core.DataBatch{})
+	assert.Empty(t, header.Get(BatchIDHeader))
+	assert.Empty(t, header.Get(DedupKeyHeader))
+	checksum, _ := core.BatchChecksum(&core.DataBatch{})
+	assert.Equal(t, checksum, header.Get(BatchChecksumHeader))
+	assert.Equal(t, "0", header.Get(BatchPointsHeader))
+
+	SetBatchHeaders(header, &core.DataBatch{ID: "20171017T120000Z-5f3a9c1e"})
+	assert.Equal(t, "20171017T120000Z-5f3a9c1e", header.Get(BatchIDHeader))
+	assert.Empty(t, header.Get(DedupKeyHeader))
+
+	SetBatchHeaders(header, &core.DataBatch{
+		ID:       "20171017T120000Z-5f3a9c1e",
+		DedupKey: &core.DedupKey{Cluster: "prod", Replica: "heapster-1", Timestamp: time.Unix(1508241600, 0)},
+	})
+	assert.Equal(t, "prod/heapster-1/20171017T120000Z", header.Get(DedupKeyHeader))
+
+	batch := &core.DataBatch{
+		ID: "20171017T120000Z-5f3a9c1e",
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"): {MetricValues: map[string]core.MetricValue{
+				"cpu/usage_rate": {ValueType: core.ValueInt64, IntValue: 250},
+			}},
+		},
+	}
+	SetBatchHeaders(header, batch)
+	checksum, _ = core.BatchChecksum(batch)
+	assert.Equal(t, checksum, header.Get(BatchChecksumHeader))
+	assert.Equal(t, "1", header.Get(BatchPointsHeader))
+	assert.Empty(t, header.Get(BatchPartHeader))
+
+	header = http.Header{}
+	SetBatchHeaders(header, &core.DataBatch{ID: "20171017T120000Z-5f3a9c1e", Part: &core.BatchPart{Index: 1}})
+	assert.Equal(t, "1", header.Get(BatchPartHeader))
+	SetBatchHeaders(header, &core.DataBatch{ID: "20171017T120000Z-5f3a9c1e", Part: &core.BatchPart{Index: 2, Last: true}})
+	assert.Equal(t, "2; last", header.Get(BatchPartHeader))
+}
+
+func TestNonEmptyLabels(t *testing.T) {
+	assert.Equal(t, map[string]string{}, NonEmptyLabels(nil, nil))
+	assert.Equal(t, map[string]string{"pod_name": "web-1", "resource_id": "/dev/sda1"}, NonEmptyLabels(
+		map[string]string{"pod_name": "web-1", "labels": "", "resource_id": "/"},
+		map[string]string{"resource_id": "/dev/sda1", "device": ""}))
+}
@@ -0,0 +1,324 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package victoriametrics implements a sink writing the metrics to the import endpoints of
+// VictoriaMetrics, single node or cluster, as JSON lines or in the InfluxDB line protocol.
+package victoriametrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+	"k8s.io/heapster/metrics/util/openmetrics"
+)
+
+const (
+	// The JSON lines of /api/v1/import, see https://docs.victoriametrics.com/#how-to-import-data-in-json-line-format.
+	formatJson = "json"
+	// The InfluxDB line protocol of /write.
+	formatInflux = "influx"
+
+	defaultTimeout    = 30 * time.Second
+	defaultMaxSamples = 10000
+	metricNameLabel   = "__name__"
+	// The measurement of the lines of the InfluxDB line protocol. VictoriaMetrics names the metrics
+	// measurement_field, so the metrics have the same names in both formats.
+	influxMeasurement = "heapster"
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+type victoriaMetricsSink struct {
+	sync.Mutex
+	client      *http.Client
+	endpoint    string
+	format      string
+	credentials *credentials.Credentials
+	// Whether the requests are compressed with gzip.
+	gzip bool
+	// Number of samples above which a batch is split in several requests.
+	maxSamples int
+}
+
+func (this *victoriaMetricsSink) Name() string {
+	return "VictoriaMetrics Sink"
+}
+
+func (this *victoriaMetricsSink) Stop() {}
+
+func (this *victoriaMetricsSink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	lines := this.encode(batch)
+	for start := 0; start < len(lines); start += this.maxSamples {
+		end := start + this.maxSamples
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if err := this.send(batch, lines[start:end]); err != nil {
+			glog.Errorf("[batch %s] Failed to import %d samples to %s: %v", batch.ID, end-start, this.endpoint, err)
+		}
+	}
+}
+
+// encode returns a line per value of the batch, named and labeled as by the Prometheus
+// exposition of Heapster.
+func (this *victoriaMetricsSink) encode(batch *core.DataBatch) [][]byte {
+	lines := [][]byte{}
+	add := func(name string, labels, metricLabels map[string]string, value core.MetricValue, timestamp time.Time) {
+		number := float64(value.IntValue)
+		if value.ValueType == core.ValueFloat {
+			number = float64(value.FloatValue)
+		}
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return
+		}
+		merged := map[string]string{}
+		for _, source := range []map[string]string{labels, metricLabels} {
+			for k, v := range source {
+				if v != "" {
+					merged[openmetrics.LabelName(k)] = v
+				}
+			}
+		}
+		name = openmetrics.MetricName(name, value.MetricType)
+		milliseconds := timestamp.UnixNano() / int64(time.Millisecond)
+		if this.format == formatInflux {
+			lines = append(lines, influxLine(name, merged, number, milliseconds))
+			return
+		}
+		merged[metricNameLabel] = name
+		line, _ := json.Marshal(map[string]interface{}{
+			"metric":     merged,
+			"values":     []float64{number},
+			"timestamps": []int64{milliseconds},
+		})
+		lines = append(lines, line)
+	}
+
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range metricSet.MetricValues {
+			add(name, metricSet.Labels, nil, value, timestamp)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			add(metric.Name, metricSet.Labels, metric.Labels, metric.MetricValue, timestamp)
+		}
+	}
+	return lines
+}
+
+// influxLine returns the line of the value, e.g. heapster,nodename=node-1 cpu_usage_total=100 1500000000000,
+// the timestamp being in milliseconds.
+func influxLine(name string, labels map[string]string, value float64, milliseconds int64) []byte {
+	var line bytes.Buffer
+	line.WriteString(influxMeasurement)
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line.WriteString("," + influxEscaper.Replace(k) + "=" + influxEscaper.Replace(labels[k]))
+	}
+	field := strings.TrimPrefix(name, influxMeasurement+"_")
+	line.WriteString(" " + influxEscaper.Replace(field) + "=" + strconv.FormatFloat(value, 'g', -1, 64))
+	line.WriteString(" " + strconv.FormatInt(milliseconds, 10))
+	return line.Bytes()
+}
+
+// send posts the lines of the batch to the endpoint.
+func (this *victoriaMetricsSink) send(batch *core.DataBatch, lines [][]byte) error {
+	var body bytes.Buffer
+	if this.gzip {
+		writer := gzip.NewWriter(&body)
+		for _, line := range lines {
+			writer.Write(line)
+			writer.Write([]byte{'\n'})
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+	} else {
+		for _, line := range lines {
+			body.Write(line)
+			body.WriteByte('\n')
+		}
+	}
+	req, err := http.NewRequest("POST", this.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	util.SetBatchHeaders(req.Header, batch)
+	if this.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if err := this.credentials.Authorize(req); err != nil {
+		return err
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		contents, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(contents)))
+	}
+	return nil
+}
+
+// importPath returns the path of the import endpoint of the format, of the tenant of the
+// cluster version if accountID is set, e.g. /insert/42:1/prometheus/api/v1/import.
+func importPath(format, accountID, projectID string) string {
+	if accountID == "" {
+		if format == formatInflux {
+			return "/write"
+		}
+		return "/api/v1/import"
+	}
+	tenant := accountID
+	if projectID != "" {
+		tenant += ":" + projectID
+	}
+	if format == formatInflux {
+		return "/insert/" + tenant + "/influx/write"
+	}
+	return "/insert/" + tenant + "/prometheus/api/v1/import"
+}
+
+// NewVictoriaMetricsSink creates a sink for uris of the form http://victoriametrics:8428 for the
+// single node version, or http://vminsert:8480?accountID=42 for a tenant of the cluster version,
+// with the format, projectID, extraLabels, gzip, maxSamplesPerRequest, insecure and timeout options.
+// The requests are authenticated with the user and password, or token, options.
+func NewVictoriaMetricsSink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing host in VictoriaMetrics endpoint %q", uri.String())
+	}
+	format := formatJson
+	if value := opts.Get("format"); value != "" {
+		format = value
+	}
+	if format != formatJson && format != formatInflux {
+		return nil, fmt.Errorf("invalid format %q, expected json or influx", format)
+	}
+	accountID, projectID := opts.Get("accountID"), opts.Get("projectID")
+	for option, value := range map[string]string{"accountID": accountID, "projectID": projectID} {
+		if _, err := strconv.ParseUint(value, 10, 32); value != "" && err != nil {
+			return nil, fmt.Errorf("invalid %s option %q, expected a 32-bit unsigned integer", option, value)
+		}
+	}
+	if projectID != "" && accountID == "" {
+		return nil, fmt.Errorf("the projectID option requires the accountID option")
+	}
+
+	if uri.User != nil {
+		if _, found := opts["user"]; !found {
+			opts.Set("user", uri.User.Username())
+		}
+		if password, set := uri.User.Password(); set {
+			if _, found := opts["password"]; !found {
+				opts.Set("password", password)
+			}
+		}
+	}
+
+	endpoint := *uri
+	endpoint.User = nil
+	if endpoint.Scheme == "" {
+		endpoint.Scheme = "http"
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + importPath(format, accountID, projectID)
+	// The options of the sink are not forwarded to the endpoint, but the extra labels, which
+	// VictoriaMetrics adds to all the samples.
+	query := url.Values{}
+	for _, value := range opts["extraLabels"] {
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid extra label %q, expected name:value", pair)
+			}
+			query.Add("extra_label", openmetrics.LabelName(parts[0])+"="+parts[1])
+		}
+	}
+	if format == formatInflux {
+		query.Set("precision", "ms")
+	}
+	endpoint.RawQuery = query.Encode()
+
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+	sink := &victoriaMetricsSink{
+		endpoint:    endpoint.String(),
+		format:      format,
+		credentials: creds,
+		gzip:        true,
+		maxSamples:  defaultMaxSamples,
+	}
+	if value := opts.Get("gzip"); value != "" {
+		if sink.gzip, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid gzip option %q: %v", value, err)
+		}
+	}
+	if value := opts.Get("maxSamplesPerRequest"); value != "" {
+		if sink.maxSamples, err = strconv.Atoi(value); err != nil || sink.maxSamples <= 0 {
+			return nil, fmt.Errorf("invalid maxSamplesPerRequest option %q", value)
+		}
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if value := opts.Get("insecure"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure option %q: %v", value, err)
+		}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	}
+	sink.client = &http.Client{Timeout: timeout, Transport: transport}
+
+	glog.Infof("Created VictoriaMetrics sink importing %s to %s", format, sink.endpoint)
+	return sink, nil
+}
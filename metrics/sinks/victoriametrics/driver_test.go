@@ -0,0 +1,132 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package victoriametrics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+func newSink(t *testing.T, server *httptest.Server, options string) *victoriaMetricsSink {
+	uri, err := url.Parse(server.URL + "?" + options)
+	require.NoError(t, err)
+	sink, err := NewVictoriaMetricsSink(uri)
+	require.NoError(t, err)
+	return sink.(*victoriaMetricsSink)
+}
+
+func TestImportJson(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := sinktest.NewServer(t, requests, nil)
+	defer server.Close()
+
+	sink := newSink(t, server, "extraLabels=cluster:prod&user=heapster&password=secret")
+	batch := sinktest.Batch()
+	batch.MetricSets[core.NodeKey("node-1")].LabeledMetrics[0].Labels[core.LabelResourceID.Key] = "/dev/sda 1"
+	sink.ExportData(batch)
+	req := <-requests
+	assert.Equal(t, "/api/v1/import", req.Path)
+	assert.Equal(t, []string{"cluster=prod"}, req.Query["extra_label"])
+	user, password, _ := req.BasicAuth()
+	assert.Equal(t, "heapster", user)
+	assert.Equal(t, "secret", password)
+	assert.Equal(t, sinktest.BatchID, req.Header.Get(util.BatchIDHeader))
+	lines := req.Lines()
+	require.Equal(t, 4, len(lines))
+
+	rows := []map[string]interface{}{}
+	for _, line := range lines {
+		row := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &row))
+		rows = append(rows, row)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"metric":     map[string]interface{}{"__name__": "heapster_cpu_usage_total", "type": "node", "nodename": "node-1"},
+		"values":     []interface{}{100.0},
+		"timestamps": []interface{}{1500000000000.0},
+	}, rows[0])
+	assert.Equal(t, map[string]interface{}{
+		"metric":     map[string]interface{}{"__name__": "heapster_filesystem_usage", "type": "node", "nodename": "node-1", "resource_id": "/dev/sda 1"},
+		"values":     []interface{}{0.5},
+		"timestamps": []interface{}{1500000000000.0},
+	}, rows[1])
+	assert.Equal(t, map[string]interface{}{
+		"metric":     map[string]interface{}{"__name__": "heapster_memory_usage", "type": "pod", "namespace_name": "default", "pod_name": "web-1"},
+		"values":     []interface{}{100.0},
+		"timestamps": []interface{}{1500000000000.0},
+	}, rows[2])
+}
+
+func TestImportInfluxTenant(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := sinktest.NewServer(t, requests, nil)
+	defer server.Close()
+
+	sink := newSink(t, server, "format=influx&accountID=42&projectID=1&gzip=false&maxSamplesPerRequest=2")
+	batch := sinktest.Batch()
+	batch.MetricSets[core.NodeKey("node-1")].LabeledMetrics[0].Labels[core.LabelResourceID.Key] = "/dev/sda 1"
+	sink.ExportData(batch)
+	lines := []string{}
+	for i := 0; i < 2; i++ {
+		req := <-requests
+		assert.Equal(t, "/insert/42:1/influx/write", req.Path)
+		assert.Equal(t, "ms", req.Query.Get("precision"))
+		lines = append(lines, req.Lines()...)
+	}
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		`heapster,namespace_name=default,pod_name=web-1,type=pod memory_usage=100 1500000000000`,
+		`heapster,nodename=node-1,resource_id=/dev/sda\ 1,type=node filesystem_usage=0.5 1500000000000`,
+		`heapster,nodename=node-1,type=node cpu_usage_total=100 1500000000000`,
+		`heapster,nodename=node-1,type=node memory_usage=1024 1500000000000`,
+	}, lines)
+
+	// The JSON lines of a tenant are imported with the API of Prometheus.
+	sink = newSink(t, server, "accountID=0")
+	sink.ExportData(sinktest.Batch())
+	assert.Equal(t, "/insert/0/prometheus/api/v1/import", (<-requests).Path)
+}
+
+func TestOptions(t *testing.T) {
+	for uri, valid := range map[string]bool{
+		"http://vm:8428": true,
+		"https://user:password@vm/prefix?format=json":                 true,
+		"http://vminsert:8480?accountID=42&projectID=7&format=influx": true,
+		"http://vm:8428?format=csv":                                   false,
+		"http://vminsert:8480?accountID=tenant":                       false,
+		"http://vminsert:8480?projectID=7":                            false,
+		"http://vm:8428?extraLabels=cluster":                          false,
+		"http://vm:8428?gzip=maybe":                                   false,
+		"http://vm:8428?maxSamplesPerRequest=0":                       false,
+		"?format=json":                                                false,
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := NewVictoriaMetricsSink(parsed)
+		assert.Equal(t, valid, err == nil, uri)
+		if err == nil {
+			assert.NotContains(t, sink.(*victoriaMetricsSink).endpoint, "password")
+		}
+	}
+}
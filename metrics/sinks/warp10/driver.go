@@ -0,0 +1,282 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package warp10 implements a sink pushing the metrics to the update endpoint of Warp 10, in the
+// input format of its Geo Time Series.
+package warp10
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+const (
+	updatePath       = "/api/v0/update"
+	tokenHeader      = "X-Warp10-Token"
+	defaultPrefix    = "heapster."
+	defaultMaxPoints = 10000
+	defaultTimeout   = 30 * time.Second
+)
+
+// The time units of a platform, set by warp.timeunits in its configuration.
+var timeUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+type warp10Sink struct {
+	sync.Mutex
+	client   *http.Client
+	endpoint string
+	// The write token is the token of the credentials.
+	credentials *credentials.Credentials
+	// The prefix of the classes.
+	prefix   string
+	timeUnit time.Duration
+	// Whether the requests are compressed with gzip.
+	gzip bool
+	// Number of points above which a batch is split in several requests.
+	maxPoints int
+}
+
+func (this *warp10Sink) Name() string {
+	return "Warp 10 Sink"
+}
+
+func (this *warp10Sink) Stop() {}
+
+func (this *warp10Sink) ExportData(batch *core.DataBatch) {
+	this.Lock()
+	defer this.Unlock()
+
+	lines := this.encode(batch)
+	for start := 0; start < len(lines); start += this.maxPoints {
+		end := start + this.maxPoints
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if err := this.send(batch, lines[start:end]); err != nil {
+			glog.Errorf("[batch %s] Failed to push %d points to %s: %v", batch.ID, end-start, this.endpoint, err)
+		}
+	}
+}
+
+// encode returns a line per value of the batch, e.g. 1500000000000000// heapster.cpu.usage{nodename=node-1} 100,
+// the class being the name of the metric with dots instead of slashes.
+func (this *warp10Sink) encode(batch *core.DataBatch) []string {
+	lines := []string{}
+	add := func(name string, labels, metricLabels map[string]string, value core.MetricValue, timestamp time.Time) {
+		var formatted string
+		if value.ValueType == core.ValueFloat {
+			number := float64(value.FloatValue)
+			if math.IsNaN(number) || math.IsInf(number, 0) {
+				return
+			}
+			formatted = formatDouble(number)
+		} else {
+			formatted = strconv.FormatInt(value.IntValue, 10)
+		}
+		merged := map[string]string{}
+		for _, source := range []map[string]string{labels, metricLabels} {
+			for k, v := range source {
+				// Labels starting with a dot are reserved for the producer, owner and application
+				// of the tokens.
+				if v != "" && !strings.HasPrefix(k, ".") {
+					merged[k] = v
+				}
+			}
+		}
+		keys := make([]string, 0, len(merged))
+		for k := range merged {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, k := range keys {
+			pairs[i] = encodeName(k) + "=" + encodeName(merged[k])
+		}
+		class := encodeName(this.prefix + strings.Replace(name, "/", ".", -1))
+		lines = append(lines, fmt.Sprintf("%d// %s{%s} %s",
+			timestamp.UnixNano()/int64(this.timeUnit), class, strings.Join(pairs, ","), formatted))
+	}
+
+	for _, metricSet := range batch.MetricSets {
+		timestamp := metricSet.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range metricSet.MetricValues {
+			add(name, metricSet.Labels, nil, value, timestamp)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			add(metric.Name, metricSet.Labels, metric.Labels, metric.MetricValue, timestamp)
+		}
+	}
+	return lines
+}
+
+// formatDouble formats the value so that Warp 10 reads it as a double, rather than as a long.
+func formatDouble(value float64) string {
+	formatted := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(formatted, ".e") {
+		formatted += ".0"
+	}
+	return formatted
+}
+
+// encodeName percent-encodes the characters which are not allowed in classes, label names and
+// label values: the separators of the input format, percent signs and control characters.
+func encodeName(name string) string {
+	var encoded bytes.Buffer
+	for _, b := range []byte(name) {
+		switch {
+		case b <= ' ' || b == 0x7f || b == '%' || b == '{' || b == '}' || b == ',' || b == '=':
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		default:
+			encoded.WriteByte(b)
+		}
+	}
+	return encoded.String()
+}
+
+// send pushes the lines of the batch to the endpoint.
+func (this *warp10Sink) send(batch *core.DataBatch, lines []string) error {
+	var body bytes.Buffer
+	data := strings.Join(lines, "\n") + "\n"
+	if this.gzip {
+		writer := gzip.NewWriter(&body)
+		writer.Write([]byte(data))
+		if err := writer.Close(); err != nil {
+			return err
+		}
+	} else {
+		body.WriteString(data)
+	}
+	req, err := http.NewRequest("POST", this.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	if this.gzip {
+		req.Header.Set("Content-Type", "application/gzip")
+	} else {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	req.Header.Set(tokenHeader, this.credentials.Get().Token)
+	util.SetBatchHeaders(req.Header, batch)
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		contents, _ := ioutil.ReadAll(resp.Body)
+		message := strings.TrimSpace(string(contents))
+		// Warp 10 describes the errors in a header, the body being an HTML page.
+		if header := resp.Header.Get("X-Warp10-Error-Message"); header != "" {
+			message = header
+		}
+		return fmt.Errorf("server returned %s: %s", resp.Status, message)
+	}
+	return nil
+}
+
+// NewWarp10Sink creates a sink for uris of the form https://warp10:8080?token=<WRITE_TOKEN>, with
+// the prefix, timeUnits, gzip, maxPointsPerRequest, insecure and timeout options. The path of the
+// update endpoint, /api/v0/update, is appended to the path of the uri unless it ends with it.
+func NewWarp10Sink(uri *url.URL) (core.DataSink, error) {
+	opts := uri.Query()
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing host in Warp 10 endpoint %q", uri.String())
+	}
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+	if creds.Get().Token == "" {
+		return nil, fmt.Errorf("the write token is required, e.g. token=<WRITE_TOKEN>")
+	}
+
+	endpoint := *uri
+	if endpoint.Scheme == "" {
+		endpoint.Scheme = "http"
+	}
+	if !strings.HasSuffix(endpoint.Path, updatePath) {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + updatePath
+	}
+	// The options of the sink, which hold the token, are not forwarded to the endpoint.
+	endpoint.RawQuery = ""
+
+	sink := &warp10Sink{
+		endpoint:    endpoint.String(),
+		credentials: creds,
+		prefix:      defaultPrefix,
+		timeUnit:    time.Microsecond,
+		gzip:        true,
+		maxPoints:   defaultMaxPoints,
+	}
+	if values, found := opts["prefix"]; found {
+		sink.prefix = values[0]
+	}
+	if value := opts.Get("timeUnits"); value != "" {
+		var found bool
+		if sink.timeUnit, found = timeUnits[value]; !found {
+			return nil, fmt.Errorf("invalid timeUnits %q, expected ms, us or ns", value)
+		}
+	}
+	if value := opts.Get("gzip"); value != "" {
+		if sink.gzip, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid gzip option %q: %v", value, err)
+		}
+	}
+	if value := opts.Get("maxPointsPerRequest"); value != "" {
+		if sink.maxPoints, err = strconv.Atoi(value); err != nil || sink.maxPoints <= 0 {
+			return nil, fmt.Errorf("invalid maxPointsPerRequest option %q", value)
+		}
+	}
+	timeout := defaultTimeout
+	if value := opts.Get("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if value := opts.Get("insecure"); value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure option %q: %v", value, err)
+		}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	}
+	sink.client = &http.Client{Timeout: timeout, Transport: transport}
+
+	glog.Infof("Created Warp 10 sink pushing to %s", sink.endpoint)
+	return sink, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package warp10
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/sinktest"
+	"k8s.io/heapster/metrics/sinks/util"
+)
+
+// newServer returns a server rejecting the requests without the write token, as Warp 10 does.
+func newServer(t *testing.T, requests chan<- sinktest.Request) *httptest.Server {
+	return sinktest.NewServer(t, requests, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(tokenHeader) != "write-token" {
+			w.Header().Set("X-Warp10-Error-Message", "Invalid token.")
+			http.Error(w, "<html>Error</html>", http.StatusInternalServerError)
+		}
+	})
+}
+
+func newSink(t *testing.T, uri string) *warp10Sink {
+	parsed, err := url.Parse(uri)
+	require.NoError(t, err)
+	sink, err := NewWarp10Sink(parsed)
+	require.NoError(t, err)
+	return sink.(*warp10Sink)
+}
+
+func TestPush(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := newServer(t, requests)
+	defer server.Close()
+
+	sink := newSink(t, server.URL+"?token=write-token")
+	batch := sinktest.Batch()
+	node := batch.MetricSets[core.NodeKey("node-1")]
+	node.Labels[".owner"] = "spoofed"
+	node.LabeledMetrics[0].Labels[core.LabelResourceID.Key] = "/dev/sda 1,{x=y}%"
+	node.LabeledMetrics[0].FloatValue = 2
+	sink.ExportData(batch)
+	req := <-requests
+	assert.Equal(t, "/api/v0/update", req.Path)
+	assert.Equal(t, "write-token", req.Header.Get(tokenHeader))
+	assert.Equal(t, sinktest.BatchID, req.Header.Get(util.BatchIDHeader))
+	assert.Equal(t, []string{
+		"1500000000000000// heapster.cpu.usage{nodename=node-1,type=node} 100",
+		"1500000000000000// heapster.filesystem.usage{nodename=node-1,resource_id=/dev/sda%201%2C%7Bx%3Dy%7D%25,type=node} 2.0",
+		"1500000000000000// heapster.memory.usage{namespace_name=default,pod_name=web-1,type=pod} 100",
+		"1500000000000000// heapster.memory.usage{nodename=node-1,type=node} 1024",
+	}, req.Lines())
+}
+
+func TestPushOptions(t *testing.T) {
+	requests := make(chan sinktest.Request, 10)
+	server := newServer(t, requests)
+	defer server.Close()
+
+	sink := newSink(t, server.URL+"/warp10/api/v0/update?token=write-token&prefix=k8s.&timeUnits=ms&gzip=false&maxPointsPerRequest=2")
+	sink.ExportData(sinktest.Batch())
+	lines := []string{}
+	for i := 0; i < 2; i++ {
+		req := <-requests
+		assert.Equal(t, "/warp10/api/v0/update", req.Path)
+		lines = append(lines, req.Lines()...)
+	}
+	sort.Strings(lines)
+	require.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[0], "1500000000000// k8s.cpu.usage{"))
+
+	// The errors of Warp 10 are read from its header.
+	sink = newSink(t, server.URL+"?token=read-token")
+	err := sink.send(sinktest.Batch(), []string{"1// heapster.test{} 1"})
+	<-requests
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid token.")
+}
+
+func TestFormatDouble(t *testing.T) {
+	for value, expected := range map[float64]string{
+		2:      "2.0",
+		0.5:    "0.5",
+		-3:     "-3.0",
+		1e21:   "1e+21",
+		1.5e-7: "1.5e-07",
+	} {
+		assert.Equal(t, expected, formatDouble(value))
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for uri, valid := range map[string]bool{
+		"http://warp10:8080?token=secret":                       true,
+		"https://warp10?token=secret&timeUnits=ns":              true,
+		"https://warp10?token=secret&timeUnits=s":               false,
+		"https://warp10":                                        false,
+		"?token=secret":                                         false,
+		"http://warp10:8080?token=secret&gzip=maybe":            false,
+		"http://warp10:8080?token=secret&maxPointsPerRequest=0": false,
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		sink, err := NewWarp10Sink(parsed)
+		assert.Equal(t, valid, err == nil, uri)
+		if err == nil {
+			assert.NotContains(t, sink.(*warp10Sink).endpoint, "secret")
+		}
+	}
+}
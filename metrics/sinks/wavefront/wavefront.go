@@ -206,7 +206,7 @@ func (wfSink *wavefrontSink) ExportData(batch *core.DataBatch) {
 	//make sure we're Connected before sending a real batch
 	err := wfSink.connect()
 	if err != nil {
-		glog.Warning(err)
+		glog.Warningf("[batch %s] %v", batch.ID, err)
 	}
 
 	if wfSink.Conn != nil && err == nil {
@@ -0,0 +1,314 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package collectd implements a source receiving the metrics sent by collectd agents with their
+// network plugin, in the collectd binary protocol, so that the nodes running collectd can share
+// the sinks of Heapster.
+package collectd
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	// Default port of the network plugin of collectd.
+	defaultPort = "25826"
+	// Values not received again for this long are not reported anymore.
+	defaultStaleness = 2 * time.Minute
+	// Maximum size of the packets, see the MaxPacketSize option of the network plugin.
+	maxPacketSize = 65535
+
+	// Labels of the collectd metrics.
+	LabelPluginInstance = "plugin_instance"
+	LabelTypeInstance   = "type_instance"
+	LabelDataSource     = "data_source"
+)
+
+var receivedPackets = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "collectd",
+		Name:      "received_packets_total",
+		Help:      "Number of packets received by the collectd sources, by result.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(receivedPackets)
+}
+
+// seriesKey identifies the values of a data source.
+type seriesKey struct {
+	host           string
+	plugin         string
+	pluginInstance string
+	typeName       string
+	typeInstance   string
+	dataSource     string
+}
+
+type sample struct {
+	value    core.MetricValue
+	time     time.Time
+	received time.Time
+}
+
+type collectdProvider struct {
+	source *collectdSource
+	conn   net.PacketConn
+}
+
+func (this *collectdProvider) GetMetricsSources() []core.MetricsSource {
+	return []core.MetricsSource{this.source}
+}
+
+// Close stops receiving the packets, e.g. when the sources are built again at startup.
+func (this *collectdProvider) Close() error {
+	close(this.source.closed)
+	return this.conn.Close()
+}
+
+// collectdSource keeps the latest value of each data source received from the collectd agents,
+// and reports them as labeled metrics of the nodes named after the hosts of the agents.
+type collectdSource struct {
+	name      string
+	parser    *parser
+	staleness time.Duration
+	// Names of the data sources of the types, from types.db. Data sources of unknown types are
+	// named after their index.
+	types map[string][]string
+
+	lock    sync.Mutex
+	samples map[seriesKey]*sample
+	// Closed when the connection is closed.
+	closed chan struct{}
+}
+
+// NewCollectdProvider creates a provider receiving the packets of the collectd agents on the
+// UDP address of the uri, e.g. `collectd:udp://0.0.0.0:25826?securityLevel=sign&authFile=/etc/collectd/auth`.
+func NewCollectdProvider(uri *url.URL) (core.MetricsSourceProvider, error) {
+	source, err := newCollectdSource(uri)
+	if err != nil {
+		return nil, err
+	}
+	host := uri.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, defaultPort)
+	}
+	addr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Receiving collectd packets on %s", conn.LocalAddr())
+	go source.serve(conn)
+	return &collectdProvider{source: source, conn: conn}, nil
+}
+
+func newCollectdSource(uri *url.URL) (*collectdSource, error) {
+	if uri.Scheme != "" && uri.Scheme != "udp" {
+		return nil, fmt.Errorf("unsupported collectd scheme %q, expected udp", uri.Scheme)
+	}
+	opts := uri.Query()
+	source := &collectdSource{
+		name: "collectd:" + uri.Host,
+		parser: &parser{
+			securityLevel: SecurityNone,
+			passwords:     map[string]string{},
+		},
+		staleness: defaultStaleness,
+		types:     map[string][]string{},
+		samples:   map[seriesKey]*sample{},
+		closed:    make(chan struct{}),
+	}
+	if len(opts["securityLevel"]) > 0 {
+		switch level := opts["securityLevel"][0]; level {
+		case SecurityNone, SecuritySign, SecurityEncrypt:
+			source.parser.securityLevel = level
+		default:
+			return nil, fmt.Errorf("unknown securityLevel %q, expected none, sign or encrypt", level)
+		}
+	}
+	if len(opts["authFile"]) > 0 {
+		passwords, err := readAuthFile(opts["authFile"][0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read authFile: %v", err)
+		}
+		source.parser.passwords = passwords
+	} else if source.parser.securityLevel != SecurityNone {
+		return nil, fmt.Errorf("securityLevel %s requires an authFile", source.parser.securityLevel)
+	}
+	if len(opts["typesDB"]) > 0 {
+		types, err := readTypesDB(opts["typesDB"][0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read typesDB: %v", err)
+		}
+		source.types = types
+	}
+	if len(opts["staleness"]) > 0 {
+		staleness, err := time.ParseDuration(opts["staleness"][0])
+		if err != nil || staleness <= 0 {
+			return nil, fmt.Errorf("invalid staleness %q, should be a positive duration", opts["staleness"][0])
+		}
+		source.staleness = staleness
+	}
+	return source, nil
+}
+
+func (this *collectdSource) serve(conn net.PacketConn) {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			select {
+			case <-this.closed:
+				return
+			default:
+			}
+			glog.Errorf("Failed to receive collectd packet: %v", err)
+			continue
+		}
+		if err := this.receive(buffer[:n]); err != nil {
+			glog.V(2).Infof("Dropping collectd packet from %s: %v", addr, err)
+		}
+	}
+}
+
+// receive stores the values of the packet.
+func (this *collectdSource) receive(packet []byte) error {
+	lists, err := this.parser.parse(packet)
+	if err != nil {
+		receivedPackets.WithLabelValues("rejected").Inc()
+		return err
+	}
+	receivedPackets.WithLabelValues("accepted").Inc()
+	now := time.Now()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, list := range lists {
+		if list.host == "" || list.plugin == "" || list.typeName == "" {
+			continue
+		}
+		names := this.types[list.typeName]
+		for i, value := range list.values {
+			key := seriesKey{
+				host:           list.host,
+				plugin:         list.plugin,
+				pluginInstance: list.pluginInstance,
+				typeName:       list.typeName,
+				typeInstance:   list.typeInstance,
+			}
+			if len(names) == len(list.values) {
+				key.dataSource = names[i]
+			} else if len(list.values) > 1 || len(names) > 0 {
+				key.dataSource = strconv.Itoa(i)
+			}
+			timestamp := list.time
+			if timestamp.IsZero() {
+				timestamp = now
+			}
+			this.samples[key] = &sample{
+				value:    toMetricValue(value),
+				time:     timestamp,
+				received: now,
+			}
+		}
+	}
+	return nil
+}
+
+func toMetricValue(value value) core.MetricValue {
+	switch value.dsType {
+	case dsTypeGauge:
+		return core.MetricValue{ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: value.gauge}
+	case dsTypeDerive:
+		return core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: value.derive}
+	case dsTypeAbsolute:
+		// Absolute counters are reset when they are read.
+		return core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricDelta, IntValue: int64(value.counter)}
+	default:
+		return core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: int64(value.counter)}
+	}
+}
+
+func (this *collectdSource) Name() string {
+	return this.name
+}
+
+// ScrapeMetrics returns the latest values received, as the labeled metrics
+// collectd/<plugin>/<type> of the nodes named after the hosts.
+func (this *collectdSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	result := &core.DataBatch{
+		Timestamp:  end,
+		MetricSets: map[string]*core.MetricSet{},
+	}
+	now := time.Now()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for key, sample := range this.samples {
+		if now.Sub(sample.received) > this.staleness {
+			delete(this.samples, key)
+			continue
+		}
+		// The source managers merge the set with the one of the kubelet of the host, if any.
+		setKey := core.NodeKey(key.host)
+		metricSet, found := result.MetricSets[setKey]
+		if !found {
+			metricSet = &core.MetricSet{
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypeNode,
+					core.LabelNodename.Key:      key.host,
+					core.LabelHostname.Key:      key.host,
+				},
+				MetricValues:   map[string]core.MetricValue{},
+				LabeledMetrics: []core.LabeledMetric{},
+			}
+			result.MetricSets[setKey] = metricSet
+		}
+		if sample.time.After(metricSet.ScrapeTime) {
+			metricSet.ScrapeTime = sample.time
+		}
+		labels := map[string]string{}
+		if key.pluginInstance != "" {
+			labels[LabelPluginInstance] = key.pluginInstance
+		}
+		if key.typeInstance != "" {
+			labels[LabelTypeInstance] = key.typeInstance
+		}
+		if key.dataSource != "" {
+			labels[LabelDataSource] = key.dataSource
+		}
+		metricSet.LabeledMetrics = append(metricSet.LabeledMetrics, core.LabeledMetric{
+			Name:        fmt.Sprintf("collectd/%s/%s", key.plugin, key.typeName),
+			Labels:      labels,
+			MetricValue: sample.value,
+		})
+	}
+	return result, nil
+}
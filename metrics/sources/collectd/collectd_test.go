@@ -0,0 +1,263 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+// packetWriter encodes packets in the collectd binary protocol.
+type packetWriter struct {
+	bytes.Buffer
+}
+
+func (this *packetWriter) part(partType uint16, body []byte) {
+	binary.Write(this, binary.BigEndian, partType)
+	binary.Write(this, binary.BigEndian, uint16(partHeaderLength+len(body)))
+	this.Write(body)
+}
+
+func (this *packetWriter) string(partType uint16, value string) {
+	this.part(partType, append([]byte(value), 0))
+}
+
+func (this *packetWriter) number(partType uint16, value uint64) {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint64(body, value)
+	this.part(partType, body)
+}
+
+func (this *packetWriter) gauges(values ...float64) {
+	body := []byte{0, byte(len(values))}
+	for range values {
+		body = append(body, dsTypeGauge)
+	}
+	for _, value := range values {
+		raw := make([]byte, 8)
+		binary.LittleEndian.PutUint64(raw, math.Float64bits(value))
+		body = append(body, raw...)
+	}
+	this.part(partValues, body)
+}
+
+func (this *packetWriter) derives(values ...int64) {
+	body := []byte{0, byte(len(values))}
+	for range values {
+		body = append(body, dsTypeDerive)
+	}
+	for _, value := range values {
+		raw := make([]byte, 8)
+		binary.BigEndian.PutUint64(raw, uint64(value))
+		body = append(body, raw...)
+	}
+	this.part(partValues, body)
+}
+
+func testPacket() []byte {
+	packet := &packetWriter{}
+	packet.string(partHost, "node1")
+	packet.number(partTimeHR, uint64(1500000000)<<30)
+	packet.number(partIntervalHR, uint64(10)<<30)
+	packet.string(partPlugin, "load")
+	packet.string(partType, "load")
+	packet.gauges(0.5, 0.75, 1)
+	packet.string(partPlugin, "interface")
+	packet.string(partPluginInstance, "eth0")
+	packet.string(partType, "if_octets")
+	packet.derives(1000, 2000)
+	packet.string(partPlugin, "memory")
+	packet.string(partPluginInstance, "")
+	packet.string(partType, "memory")
+	packet.string(partTypeInstance, "used")
+	packet.gauges(1024)
+	return packet.Bytes()
+}
+
+func sign(packet []byte, user, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(user))
+	mac.Write(packet)
+	signed := &packetWriter{}
+	signed.part(partSignature, append(mac.Sum(nil), user...))
+	signed.Write(packet)
+	return signed.Bytes()
+}
+
+func encrypt(packet []byte, user, password string) []byte {
+	key := sha256.Sum256([]byte(password))
+	block, _ := aes.NewCipher(key[:])
+	iv := bytes.Repeat([]byte{7}, ivLength)
+	checksum := sha1.Sum(packet)
+	plain := append(checksum[:], packet...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewOFB(block, iv).XORKeyStream(encrypted, plain)
+
+	body := []byte{0, byte(len(user))}
+	body = append(body, user...)
+	body = append(body, iv...)
+	body = append(body, encrypted...)
+	result := &packetWriter{}
+	result.part(partEncryption, body)
+	return result.Bytes()
+}
+
+func metricsByName(metricSet *core.MetricSet) map[string]core.LabeledMetric {
+	result := map[string]core.LabeledMetric{}
+	for _, metric := range metricSet.LabeledMetrics {
+		name := metric.Name
+		for _, label := range []string{LabelPluginInstance, LabelTypeInstance, LabelDataSource} {
+			if value, found := metric.Labels[label]; found {
+				name += "," + label + "=" + value
+			}
+		}
+		result[name] = metric
+	}
+	return result
+}
+
+func TestCollectdSource(t *testing.T) {
+	types, err := parseTypesDB(strings.NewReader("# types\nif_octets rx:DERIVE:0:U, tx:DERIVE:0:U\nload shortterm:GAUGE:0:5000, midterm:GAUGE:0:5000, longterm:GAUGE:0:5000\n"))
+	require.NoError(t, err)
+	source, err := newCollectdSource(&url.URL{Scheme: "udp", Host: "localhost"})
+	require.NoError(t, err)
+	source.types = types
+	require.NoError(t, source.receive(testPacket()))
+
+	batch, err := source.ScrapeMetrics(time.Now().Add(-time.Minute), time.Now())
+	require.NoError(t, err)
+	require.Len(t, batch.MetricSets, 1)
+	node := batch.MetricSets[core.NodeKey("node1")]
+	require.NotNil(t, node)
+	assert.Equal(t, core.MetricSetTypeNode, node.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, time.Unix(1500000000, 0), node.ScrapeTime)
+
+	metrics := metricsByName(node)
+	assert.Len(t, metrics, 6)
+	assert.Equal(t, 0.75, metrics["collectd/load/load,data_source=midterm"].FloatValue)
+	rx := metrics["collectd/interface/if_octets,plugin_instance=eth0,data_source=rx"]
+	assert.Equal(t, int64(1000), rx.IntValue)
+	assert.Equal(t, core.MetricCumulative, rx.MetricType)
+	assert.Equal(t, int64(2000), metrics["collectd/interface/if_octets,plugin_instance=eth0,data_source=tx"].IntValue)
+	used := metrics["collectd/memory/memory,type_instance=used"]
+	assert.Equal(t, 1024.0, used.FloatValue)
+	assert.Equal(t, core.MetricGauge, used.MetricType)
+
+	// Values which are not received anymore are dropped.
+	source.staleness = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	batch, err = source.ScrapeMetrics(time.Now().Add(-time.Minute), time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, batch.MetricSets)
+	assert.Empty(t, source.samples)
+}
+
+func TestCollectdSecurity(t *testing.T) {
+	passwords, err := parseAuthFile(strings.NewReader("# users\nalice: secret\nbob:  hunter2 \n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"alice": "secret", "bob": "hunter2"}, passwords)
+	_, err = parseAuthFile(strings.NewReader("alice\n"))
+	assert.Error(t, err)
+
+	packet := testPacket()
+	for _, test := range []struct {
+		level    string
+		packet   []byte
+		accepted bool
+	}{
+		{SecurityNone, packet, true},
+		{SecurityNone, sign(packet, "alice", "secret"), true},
+		{SecurityNone, sign(packet, "alice", "wrong"), false},
+		{SecurityNone, sign(packet, "carol", "unknown"), true},
+		{SecuritySign, packet, false},
+		{SecuritySign, sign(packet, "alice", "secret"), true},
+		{SecuritySign, sign(packet, "alice", "wrong"), false},
+		{SecuritySign, sign(packet, "carol", "unknown"), false},
+		{SecuritySign, encrypt(packet, "bob", "hunter2"), true},
+		{SecurityEncrypt, sign(packet, "alice", "secret"), false},
+		{SecurityEncrypt, encrypt(packet, "bob", "hunter2"), true},
+		{SecurityEncrypt, encrypt(packet, "bob", "wrong"), false},
+		{SecurityEncrypt, encrypt(packet, "carol", "unknown"), false},
+	} {
+		parser := &parser{securityLevel: test.level, passwords: passwords}
+		lists, err := parser.parse(test.packet)
+		if test.accepted {
+			assert.NoError(t, err, test.level)
+			assert.Len(t, lists, 3, test.level)
+		} else {
+			assert.Error(t, err, test.level)
+		}
+	}
+}
+
+func TestCollectdInvalidPackets(t *testing.T) {
+	parser := &parser{securityLevel: SecurityNone}
+	packet := testPacket()
+	for _, invalid := range [][]byte{
+		packet[:len(packet)-3],
+		{0, 0, 0, 2},
+		{0, 0, 0, 4},
+	} {
+		_, err := parser.parse(invalid)
+		assert.Error(t, err)
+	}
+}
+
+func TestCollectdProvider(t *testing.T) {
+	_, err := NewCollectdProvider(&url.URL{Scheme: "tcp", Host: "localhost:0"})
+	assert.Error(t, err)
+	_, err = NewCollectdProvider(&url.URL{Scheme: "udp", Host: "localhost:0", RawQuery: "securityLevel=sign"})
+	assert.Error(t, err)
+
+	provider, err := NewCollectdProvider(&url.URL{Scheme: "udp", Host: "127.0.0.1:0"})
+	require.NoError(t, err)
+	source := provider.GetMetricsSources()[0].(*collectdSource)
+	// The port is chosen by the system, so the packet is received by the source directly.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	go source.serve(conn)
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write(testPacket())
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		batch, err := source.ScrapeMetrics(time.Now(), time.Now())
+		require.NoError(t, err)
+		if len(batch.MetricSets) > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("packet not received")
+}
@@ -0,0 +1,362 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Part types of the collectd binary protocol, see https://collectd.org/wiki/index.php/Binary_protocol.
+const (
+	partHost           = 0x0000
+	partTime           = 0x0001
+	partPlugin         = 0x0002
+	partPluginInstance = 0x0003
+	partType           = 0x0004
+	partTypeInstance   = 0x0005
+	partValues         = 0x0006
+	partInterval       = 0x0007
+	partTimeHR         = 0x0008
+	partIntervalHR     = 0x0009
+	partSignature      = 0x0200
+	partEncryption     = 0x0210
+)
+
+// Data source types of the values.
+const (
+	dsTypeCounter  = 0
+	dsTypeGauge    = 1
+	dsTypeDerive   = 2
+	dsTypeAbsolute = 3
+)
+
+// Security levels of the source, as in the network plugin of collectd.
+const (
+	SecurityNone    = "none"
+	SecuritySign    = "sign"
+	SecurityEncrypt = "encrypt"
+)
+
+const (
+	signatureLength = sha256.Size
+	ivLength        = aes.BlockSize
+	checksumLength  = sha1.Size
+	// Parts are at least a type and a length.
+	partHeaderLength = 4
+)
+
+// value is a single data source of a collectd value list.
+type value struct {
+	dsType  byte
+	counter uint64
+	gauge   float64
+	derive  int64
+}
+
+// valueList holds the values of a plugin instance and type instance reported by a host.
+type valueList struct {
+	host           string
+	plugin         string
+	pluginInstance string
+	typeName       string
+	typeInstance   string
+	time           time.Time
+	interval       time.Duration
+	values         []value
+}
+
+// parser decodes collectd packets, verifying and decrypting them with the passwords of the users.
+type parser struct {
+	securityLevel string
+	// Passwords by user name.
+	passwords map[string]string
+}
+
+// parse returns the value lists of the packet. Packets not meeting the security level are rejected.
+func (this *parser) parse(packet []byte) ([]valueList, error) {
+	return this.parseParts(packet, false)
+}
+
+func (this *parser) parseParts(packet []byte, secured bool) ([]valueList, error) {
+	result := []valueList{}
+	// The state carries over from one value list to the next.
+	current := valueList{}
+	for len(packet) > 0 {
+		if len(packet) < partHeaderLength {
+			return nil, fmt.Errorf("truncated part header")
+		}
+		kind := binary.BigEndian.Uint16(packet[0:2])
+		length := int(binary.BigEndian.Uint16(packet[2:4]))
+		if length < partHeaderLength || length > len(packet) {
+			return nil, fmt.Errorf("invalid length %d of part %#x", length, kind)
+		}
+		body := packet[partHeaderLength:length]
+		rest := packet[length:]
+
+		switch kind {
+		case partSignature:
+			if err := this.verify(body, rest); err != nil {
+				return nil, err
+			}
+			lists, err := this.parseParts(rest, true)
+			return append(result, lists...), err
+		case partEncryption:
+			decrypted, err := this.decrypt(body)
+			if err != nil {
+				return nil, err
+			}
+			lists, err := this.parseParts(decrypted, true)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, lists...)
+			packet = rest
+			continue
+		}
+		if !secured && this.securityLevel != SecurityNone {
+			return nil, fmt.Errorf("rejecting unsecured packet, security level is %s", this.securityLevel)
+		}
+
+		var err error
+		switch kind {
+		case partHost:
+			current.host, err = parseString(body)
+		case partPlugin:
+			current.plugin, err = parseString(body)
+		case partPluginInstance:
+			current.pluginInstance, err = parseString(body)
+		case partType:
+			current.typeName, err = parseString(body)
+		case partTypeInstance:
+			current.typeInstance, err = parseString(body)
+		case partTime:
+			var seconds uint64
+			if seconds, err = parseNumber(body); err == nil {
+				current.time = time.Unix(int64(seconds), 0)
+			}
+		case partTimeHR:
+			var hr uint64
+			if hr, err = parseNumber(body); err == nil {
+				current.time = fromHighResolution(hr)
+			}
+		case partInterval:
+			var seconds uint64
+			if seconds, err = parseNumber(body); err == nil {
+				current.interval = time.Duration(seconds) * time.Second
+			}
+		case partIntervalHR:
+			var hr uint64
+			if hr, err = parseNumber(body); err == nil {
+				current.interval = fromHighResolution(hr).Sub(time.Unix(0, 0))
+			}
+		case partValues:
+			if current.values, err = parseValues(body); err == nil {
+				result = append(result, current)
+			}
+		default:
+			// Notifications and unknown parts are ignored.
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid part %#x: %v", kind, err)
+		}
+		packet = rest
+	}
+	return result, nil
+}
+
+// verify checks the HMAC-SHA256 of the signed part of the packet.
+func (this *parser) verify(body, signed []byte) error {
+	if len(body) < signatureLength {
+		return fmt.Errorf("truncated signature")
+	}
+	user := string(body[signatureLength:])
+	password, found := this.passwords[user]
+	if !found {
+		if this.securityLevel == SecurityNone {
+			// As collectd does, signed packets of unknown users are accepted without security.
+			return nil
+		}
+		return fmt.Errorf("unknown user %q", user)
+	}
+	if this.securityLevel == SecurityEncrypt {
+		return fmt.Errorf("rejecting signed packet of %q, security level is %s", user, this.securityLevel)
+	}
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write(body[signatureLength:])
+	mac.Write(signed)
+	if !hmac.Equal(mac.Sum(nil), body[:signatureLength]) {
+		return fmt.Errorf("invalid signature of %q", user)
+	}
+	return nil
+}
+
+// decrypt returns the parts encrypted with AES-256 in OFB mode, with the SHA-256 of the password
+// of the user as key, after checking their SHA-1.
+func (this *parser) decrypt(body []byte) ([]byte, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("truncated encryption header")
+	}
+	userLength := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) < 2+userLength+ivLength+checksumLength {
+		return nil, fmt.Errorf("truncated encrypted part")
+	}
+	user := string(body[2 : 2+userLength])
+	password, found := this.passwords[user]
+	if !found {
+		return nil, fmt.Errorf("unknown user %q", user)
+	}
+	iv := body[2+userLength : 2+userLength+ivLength]
+	encrypted := body[2+userLength+ivLength:]
+
+	key := sha256.Sum256([]byte(password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewOFB(block, iv).XORKeyStream(decrypted, encrypted)
+	checksum := sha1.Sum(decrypted[checksumLength:])
+	if !bytes.Equal(checksum[:], decrypted[:checksumLength]) {
+		return nil, fmt.Errorf("invalid checksum of the packet of %q, wrong password?", user)
+	}
+	return decrypted[checksumLength:], nil
+}
+
+func parseString(body []byte) (string, error) {
+	if len(body) == 0 || body[len(body)-1] != 0 {
+		return "", fmt.Errorf("string not null-terminated")
+	}
+	return string(body[:len(body)-1]), nil
+}
+
+func parseNumber(body []byte) (uint64, error) {
+	if len(body) != 8 {
+		return 0, fmt.Errorf("invalid number length %d", len(body))
+	}
+	return binary.BigEndian.Uint64(body), nil
+}
+
+// fromHighResolution converts times and intervals in units of 2^-30 seconds.
+func fromHighResolution(hr uint64) time.Time {
+	seconds := hr >> 30
+	nanos := (hr & (1<<30 - 1)) * uint64(time.Second) >> 30
+	return time.Unix(int64(seconds), int64(nanos))
+}
+
+func parseValues(body []byte) ([]value, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("truncated values")
+	}
+	count := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) != 2+9*count {
+		return nil, fmt.Errorf("invalid length %d of %d values", len(body), count)
+	}
+	types := body[2 : 2+count]
+	data := body[2+count:]
+	result := make([]value, count)
+	for i := range result {
+		raw := data[8*i : 8*i+8]
+		result[i].dsType = types[i]
+		switch types[i] {
+		case dsTypeCounter, dsTypeAbsolute:
+			result[i].counter = binary.BigEndian.Uint64(raw)
+		case dsTypeGauge:
+			// Gauges are the only little-endian values of the protocol.
+			result[i].gauge = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+		case dsTypeDerive:
+			result[i].derive = int64(binary.BigEndian.Uint64(raw))
+		default:
+			return nil, fmt.Errorf("unknown data source type %d", types[i])
+		}
+	}
+	return result, nil
+}
+
+// readAuthFile reads the passwords of the users from a file in the format of the AuthFile of
+// collectd, one `user: password` per line.
+func readAuthFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseAuthFile(file)
+}
+
+func parseAuthFile(reader io.Reader) (map[string]string, error) {
+	passwords := map[string]string{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid line %d, expected user: password", line)
+		}
+		passwords[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return passwords, scanner.Err()
+}
+
+// readTypesDB reads the names of the data sources of the types from a types.db file of
+// collectd, e.g. `if_octets rx:DERIVE:0:U, tx:DERIVE:0:U`.
+func readTypesDB(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseTypesDB(file)
+}
+
+func parseTypesDB(reader io.Reader) (map[string][]string, error) {
+	types := map[string][]string{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid line %d, expected a type and its data sources", line)
+		}
+		names := []string{}
+		for _, ds := range strings.Split(strings.Join(fields[1:], ""), ",") {
+			name := strings.SplitN(ds, ":", 2)[0]
+			if name == "" {
+				return nil, fmt.Errorf("invalid data source %q on line %d", ds, line)
+			}
+			names = append(names, name)
+		}
+		types[fields[0]] = names
+	}
+	return types, scanner.Err()
+}
@@ -16,10 +16,17 @@ package sources
 
 import (
 	"fmt"
+	"io"
+	"time"
 
+	"github.com/golang/glog"
 	"k8s.io/heapster/common/flags"
 	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sources/collectd"
+	"k8s.io/heapster/metrics/sources/federated"
 	"k8s.io/heapster/metrics/sources/kubelet"
+	"k8s.io/heapster/metrics/sources/plugin"
+	"k8s.io/heapster/metrics/sources/state"
 	"k8s.io/heapster/metrics/sources/summary"
 )
 
@@ -31,19 +38,78 @@ func (this *SourceFactory) Build(uri flags.Uri) (core.MetricsSourceProvider, err
 	case "kubernetes":
 		provider, err := kubelet.NewKubeletProvider(&uri.Val)
 		return provider, err
+	case "kubernetes.cadvisor":
+		provider, err := kubelet.NewCadvisorPrometheusProvider(&uri.Val)
+		return provider, err
+	case "kubernetes.docker":
+		provider, err := kubelet.NewDockerProvider(&uri.Val)
+		return provider, err
 	case "kubernetes.summary_api":
 		provider, err := summary.NewSummaryProvider(&uri.Val)
 		return provider, err
+	case "kubernetes.state":
+		provider, err := state.NewStateProvider(&uri.Val)
+		return provider, err
+	case "heapster":
+		provider, err := federated.NewFederatedProvider(&uri.Val)
+		return provider, err
+	case "collectd":
+		provider, err := collectd.NewCollectdProvider(&uri.Val)
+		return provider, err
+	case "plugin":
+		provider, err := plugin.NewPluginProvider(&uri.Val)
+		return provider, err
 	default:
 		return nil, fmt.Errorf("Source not recognized: %s", uri.Key)
 	}
 }
 
-func (this *SourceFactory) BuildAll(uris flags.Uris) (core.MetricsSourceProvider, error) {
-	if len(uris) != 1 {
-		return nil, fmt.Errorf("Only one source is supported")
+// BuildAll returns a provider for each of the uris, in the same order.
+func (this *SourceFactory) BuildAll(uris flags.Uris) ([]core.MetricsSourceProvider, error) {
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("No source specified")
+	}
+	result := make([]core.MetricsSourceProvider, 0, len(uris))
+	for _, uri := range uris {
+		provider, err := this.Build(uri)
+		if err != nil {
+			// The sources may be built again, e.g. while the API server starts, and some hold
+			// resources such as listening sockets.
+			closeAll(result)
+			return nil, err
+		}
+		result = append(result, provider)
+	}
+	return result, nil
+}
+
+// closeAll closes the providers which hold resources, i.e. implement io.Closer.
+func closeAll(providers []core.MetricsSourceProvider) {
+	for _, provider := range providers {
+		if closer, ok := provider.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				glog.Warningf("Failed to close source provider: %v", err)
+			}
+		}
+	}
+}
+
+// GetScrapeTimeout returns the scrape_timeout option of the source, e.g. scrape_timeout=45s, or
+// defaultTimeout if it is not set. It lets the kubelets of a source on a congested network be
+// given more time than the others.
+func GetScrapeTimeout(uri flags.Uri, defaultTimeout time.Duration) (time.Duration, error) {
+	value := uri.Val.Query().Get("scrape_timeout")
+	if value == "" {
+		return defaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid scrape_timeout %q of source %s: %v", value, uri.Key, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("scrape_timeout of source %s must be positive, got %s", uri.Key, timeout)
 	}
-	return this.Build(uris[0])
+	return timeout, nil
 }
 
 func NewSourceFactory() *SourceFactory {
@@ -0,0 +1,67 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/common/flags"
+)
+
+func TestGetScrapeTimeout(t *testing.T) {
+	for _, test := range []struct {
+		source   string
+		expected time.Duration
+		err      bool
+	}{
+		{source: "kubernetes:https://kubernetes.default", expected: DefaultMetricsScrapeTimeout},
+		{source: "kubernetes.summary_api:https://kubernetes.default?scrape_timeout=45s", expected: 45 * time.Second},
+		{source: "kubernetes:?scrape_timeout=forever", err: true},
+		{source: "kubernetes:?scrape_timeout=-1s", err: true},
+	} {
+		var uri flags.Uri
+		require.NoError(t, uri.Set(test.source))
+		timeout, err := GetScrapeTimeout(uri, DefaultMetricsScrapeTimeout)
+		if test.err {
+			assert.Error(t, err, test.source)
+			continue
+		}
+		assert.NoError(t, err, test.source)
+		assert.Equal(t, test.expected, timeout, test.source)
+	}
+}
+
+func TestBuildAllClosesBuiltSources(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	require.NoError(t, conn.Close())
+
+	var collectd, unknown flags.Uri
+	require.NoError(t, collectd.Set("collectd:udp://"+addr))
+	require.NoError(t, unknown.Set("unknown:"))
+	_, err = NewSourceFactory().BuildAll(flags.Uris{collectd, unknown})
+	require.Error(t, err)
+
+	// The collectd listener of the failed attempt is closed, so the sources can be built again.
+	providers, err := NewSourceFactory().BuildAll(flags.Uris{collectd})
+	require.NoError(t, err)
+	closeAll(providers)
+}
@@ -0,0 +1,282 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federated
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	exportPath = "/api/v1/metric-export"
+	// Scrapes outliving it are abandoned by the source manager anyway.
+	requestTimeout = 30 * time.Second
+	// Container names given by the export API to the node and pod metric sets.
+	nodeContainerName = "machine"
+	podContainerName  = "/pod"
+)
+
+var metricDescriptors = map[string]core.MetricDescriptor{}
+
+func init() {
+	for _, metric := range core.AllMetrics {
+		metricDescriptors[metric.Name] = metric.MetricDescriptor
+	}
+}
+
+type federatedProvider struct {
+	source *federatedSource
+}
+
+func (this *federatedProvider) GetMetricsSources() []core.MetricsSource {
+	return []core.MetricsSource{this.source}
+}
+
+// federatedSource scrapes the metrics exported by the Heapster of a member cluster.
+type federatedSource struct {
+	cluster     string
+	url         string
+	client      *http.Client
+	credentials *credentials.Credentials
+}
+
+// NewFederatedProvider creates a provider scraping the metric export API of the Heapster of a
+// member cluster, e.g. `heapster:https://heapster.cluster-a.example.com?cluster=cluster-a`. The
+// metric sets are keyed and labeled with the name of the cluster, so that the sets of several
+// clusters do not collide.
+func NewFederatedProvider(uri *url.URL) (core.MetricsSourceProvider, error) {
+	opts := uri.Query()
+	if len(opts["cluster"]) == 0 || opts["cluster"][0] == "" {
+		return nil, fmt.Errorf("the cluster option is required")
+	}
+	if uri.Host == "" {
+		return nil, fmt.Errorf("the url of the Heapster of cluster %s is required", opts["cluster"][0])
+	}
+	creds, err := credentials.New(opts, "user", "password")
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(opts["caCert"]) > 0 {
+		caCert, err := ioutil.ReadFile(opts["caCert"][0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificate found in %s", opts["caCert"][0])
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(opts["clientCert"]) > 0 || len(opts["clientKey"]) > 0 {
+		cert, err := tls.LoadX509KeyPair(opts.Get("clientCert"), opts.Get("clientKey"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(opts["insecure"]) > 0 {
+		insecure, err := strconv.ParseBool(opts["insecure"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure option %q: %v", opts["insecure"][0], err)
+		}
+		tlsConfig.InsecureSkipVerify = insecure
+	}
+
+	target := url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: strings.TrimSuffix(uri.Path, "/") + exportPath}
+	if target.Scheme == "" {
+		target.Scheme = "https"
+	}
+	return &federatedProvider{
+		source: &federatedSource{
+			cluster: opts["cluster"][0],
+			url:     target.String(),
+			client: &http.Client{
+				Timeout: requestTimeout,
+				Transport: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: tlsConfig,
+				},
+			},
+			credentials: creds,
+		},
+	}, nil
+}
+
+func (this *federatedSource) Name() string {
+	return "federated:" + this.cluster
+}
+
+func (this *federatedSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	timeseries, err := this.getTimeseries()
+	if err != nil {
+		return nil, err
+	}
+	result := &core.DataBatch{
+		Timestamp:  end,
+		MetricSets: make(map[string]*core.MetricSet, len(timeseries)),
+	}
+	for _, ts := range timeseries {
+		key, metricSet := this.decodeTimeseries(ts)
+		if metricSet == nil {
+			continue
+		}
+		result.MetricSets[key] = metricSet
+	}
+	glog.V(3).Infof("Scraped %d metric sets from cluster %s", len(result.MetricSets), this.cluster)
+	return result, nil
+}
+
+func (this *federatedSource) getTimeseries() ([]*types.Timeseries, error) {
+	req, err := http.NewRequest("GET", this.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := this.credentials.Authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape cluster %s: %v", this.cluster, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to scrape cluster %s: %s returned %s: %s", this.cluster, this.url,
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the metrics of cluster %s: %v", this.cluster, err)
+	}
+	// Heapsters predating the checksums do not send the header.
+	if digest := resp.Header.Get(types.DigestHeader); digest != "" && digest != types.Digest(body) {
+		return nil, fmt.Errorf("the metrics of cluster %s do not match their checksum, %d bytes received", this.cluster, len(body))
+	}
+	var timeseries []*types.Timeseries
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// Keeps the precision of the integer values.
+	decoder.UseNumber()
+	if err := decoder.Decode(&timeseries); err != nil {
+		return nil, fmt.Errorf("failed to decode the metrics of cluster %s: %v", this.cluster, err)
+	}
+	return timeseries, nil
+}
+
+// decodeTimeseries converts a timeseries of the export API back to the metric set it was made
+// of, restoring the type and the key the export API drops.
+func (this *federatedSource) decodeTimeseries(ts *types.Timeseries) (string, *core.MetricSet) {
+	labels := make(map[string]string, len(ts.Labels)+2)
+	for name, value := range ts.Labels {
+		labels[name] = value
+	}
+	var key string
+	containerName := labels[core.LabelContainerName.Key]
+	namespace := labels[core.LabelNamespaceName.Key]
+	podName := labels[core.LabelPodName.Key]
+	nodeName := labels[core.LabelNodename.Key]
+	switch {
+	case containerName == nodeContainerName && podName == "":
+		labels[core.LabelMetricSetType.Key] = core.MetricSetTypeNode
+		delete(labels, core.LabelContainerName.Key)
+		key = core.NodeKey(nodeName)
+	case containerName == podContainerName:
+		labels[core.LabelMetricSetType.Key] = core.MetricSetTypePod
+		delete(labels, core.LabelContainerName.Key)
+		key = core.PodKey(namespace, podName)
+	case podName != "":
+		labels[core.LabelMetricSetType.Key] = core.MetricSetTypePodContainer
+		key = core.PodContainerKey(namespace, podName, containerName)
+	case containerName != "":
+		labels[core.LabelMetricSetType.Key] = core.MetricSetTypeSystemContainer
+		key = core.NodeContainerKey(nodeName, containerName)
+	default:
+		glog.V(4).Infof("Ignoring timeseries of cluster %s with unknown labels %v", this.cluster, ts.Labels)
+		return "", nil
+	}
+	labels[core.LabelClusterName.Key] = this.cluster
+
+	metricSet := &core.MetricSet{
+		Labels:         labels,
+		MetricValues:   map[string]core.MetricValue{},
+		LabeledMetrics: []core.LabeledMetric{},
+	}
+	for name, points := range ts.Metrics {
+		for _, point := range points {
+			value, ok := decodeValue(name, point.Value)
+			if !ok {
+				glog.V(4).Infof("Ignoring value %v of %s in cluster %s", point.Value, name, this.cluster)
+				continue
+			}
+			if point.End.After(metricSet.ScrapeTime) {
+				metricSet.ScrapeTime = point.End
+			}
+			if value.MetricType == core.MetricCumulative {
+				metricSet.CollectionStartTime = point.Start
+			}
+			if len(point.Labels) == 0 {
+				metricSet.MetricValues[name] = value
+			} else {
+				metricSet.LabeledMetrics = append(metricSet.LabeledMetrics, core.LabeledMetric{
+					Name:        name,
+					Labels:      point.Labels,
+					MetricValue: value,
+				})
+			}
+		}
+	}
+	return core.FederatedKey(this.cluster, key), metricSet
+}
+
+func decodeValue(name string, value interface{}) (core.MetricValue, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return core.MetricValue{}, false
+	}
+	result := core.MetricValue{MetricType: core.MetricGauge, ValueType: core.ValueInt64}
+	descriptor, known := metricDescriptors[name]
+	if known {
+		result.MetricType = descriptor.Type
+		result.ValueType = descriptor.ValueType
+	}
+	intValue, err := number.Int64()
+	if err != nil || (known && descriptor.ValueType == core.ValueFloat) {
+		floatValue, err := number.Float64()
+		if err != nil {
+			return core.MetricValue{}, false
+		}
+		result.ValueType = core.ValueFloat
+		result.FloatValue = floatValue
+		return result, true
+	}
+	result.IntValue = intValue
+	return result, true
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package federated
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/api/v1/types"
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestScrapeFederatedHeapster(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	start := now.Add(-time.Hour)
+	point := func(value interface{}, labels map[string]string) []types.Point {
+		return []types.Point{{Start: now, End: now, Labels: labels, Value: value}}
+	}
+	export := []*types.Timeseries{
+		{
+			Labels: map[string]string{"nodename": "node1", "container_name": "machine"},
+			Metrics: map[string][]types.Point{
+				core.MetricMemoryUsage.Name: point(int64(1)<<60+1, nil),
+				core.MetricCpuUsage.Name:    {{Start: start, End: now, Value: 123}},
+				core.MetricFilesystemUsage.Name: point(456, map[string]string{
+					core.LabelResourceID.Key: "/",
+				}),
+			},
+		},
+		{
+			Labels:  map[string]string{"nodename": "node1", "namespace_name": "ns1", "pod_name": "web", "container_name": "/pod"},
+			Metrics: map[string][]types.Point{core.MetricNodeCpuUtilization.Name: point(0.5, nil)},
+		},
+		{
+			Labels:  map[string]string{"nodename": "node1", "namespace_name": "ns1", "pod_name": "web", "container_name": "nginx"},
+			Metrics: map[string][]types.Point{core.MetricMemoryUsage.Name: point(10, nil)},
+		},
+		{
+			Labels:  map[string]string{"nodename": "node1", "container_name": "kubelet"},
+			Metrics: map[string][]types.Point{core.MetricMemoryUsage.Name: point(20, nil)},
+		},
+	}
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/heapster/api/v1/metric-export", r.URL.Path)
+		authorization = r.Header.Get("Authorization")
+		body, err := json.Marshal(export)
+		require.NoError(t, err)
+		w.Header().Set(types.DigestHeader, types.Digest(body))
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "/heapster?cluster=a&token=secret")
+	require.NoError(t, err)
+	provider, err := NewFederatedProvider(uri)
+	require.NoError(t, err)
+	sources := provider.GetMetricsSources()
+	require.Len(t, sources, 1)
+	assert.Equal(t, "federated:a", sources[0].Name())
+
+	batch, err := sources[0].ScrapeMetrics(now.Add(-time.Minute), now)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer secret", authorization)
+	require.Len(t, batch.MetricSets, 4)
+
+	node := batch.MetricSets[core.FederatedKey("a", core.NodeKey("node1"))]
+	require.NotNil(t, node)
+	assert.True(t, core.IsFederated(node))
+	assert.Equal(t, "a", node.Labels[core.LabelClusterName.Key])
+	assert.Equal(t, core.MetricSetTypeNode, node.Labels[core.LabelMetricSetType.Key])
+	assert.NotContains(t, node.Labels, core.LabelContainerName.Key)
+	assert.Equal(t, int64(1)<<60+1, node.MetricValues[core.MetricMemoryUsage.Name].IntValue)
+	assert.Equal(t, core.MetricCumulative, node.MetricValues[core.MetricCpuUsage.Name].MetricType)
+	assert.Equal(t, start, node.CollectionStartTime.UTC())
+	assert.Equal(t, now, node.ScrapeTime.UTC())
+	require.Len(t, node.LabeledMetrics, 1)
+	assert.Equal(t, "/", node.LabeledMetrics[0].Labels[core.LabelResourceID.Key])
+	assert.Equal(t, int64(456), node.LabeledMetrics[0].IntValue)
+
+	pod := batch.MetricSets[core.FederatedKey("a", core.PodKey("ns1", "web"))]
+	require.NotNil(t, pod)
+	assert.Equal(t, core.MetricSetTypePod, pod.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, core.ValueFloat, pod.MetricValues[core.MetricNodeCpuUtilization.Name].ValueType)
+	assert.Equal(t, 0.5, pod.MetricValues[core.MetricNodeCpuUtilization.Name].FloatValue)
+
+	container := batch.MetricSets[core.FederatedKey("a", core.PodContainerKey("ns1", "web", "nginx"))]
+	require.NotNil(t, container)
+	assert.Equal(t, core.MetricSetTypePodContainer, container.Labels[core.LabelMetricSetType.Key])
+
+	system := batch.MetricSets[core.FederatedKey("a", core.NodeContainerKey("node1", "kubelet"))]
+	require.NotNil(t, system)
+	assert.Equal(t, core.MetricSetTypeSystemContainer, system.Labels[core.LabelMetricSetType.Key])
+}
+
+func TestFederatedScrapeFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "?cluster=a")
+	require.NoError(t, err)
+	provider, err := NewFederatedProvider(uri)
+	require.NoError(t, err)
+	_, err = provider.GetMetricsSources()[0].ScrapeMetrics(time.Now(), time.Now())
+	assert.Error(t, err)
+}
+
+func TestFederatedProviderOptions(t *testing.T) {
+	for _, uri := range []string{
+		"https://heapster.example.com",
+		"?cluster=a",
+		"https://heapster.example.com?cluster=a&insecure=maybe",
+		"https://heapster.example.com?cluster=a&caCert=/does/not/exist",
+		"https://heapster.example.com?cluster=a&token=a&tokenEnv=B",
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewFederatedProvider(parsed)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestFederatedChecksumMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(types.DigestHeader, types.Digest([]byte("[]")))
+		w.Write([]byte("[{}]"))
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "?cluster=a")
+	require.NoError(t, err)
+	provider, err := NewFederatedProvider(uri)
+	require.NoError(t, err)
+	_, err = provider.GetMetricsSources()[0].ScrapeMetrics(time.Now(), time.Now())
+	assert.Error(t, err)
+}
@@ -0,0 +1,326 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	cadvisor "github.com/google/cadvisor/info/v1"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	cadvisorMetricsPath          = "/metrics/cadvisor"
+	prometheusTextContentType    = "text/plain; version=0.0.4"
+	kubernetesPodsCgroupPrefix   = "/kubepods"
+	cadvisorTotalCPU             = "total"
+	cadvisorContainerMemoryScope = "container"
+)
+
+// GetCadvisorMetrics scrapes the Prometheus endpoint of the cAdvisor embedded in the kubelet and
+// returns the containers it reports, as if they were returned by the stats endpoint.
+func (self *KubeletClient) GetCadvisorMetrics(host Host) ([]cadvisor.ContainerInfo, error) {
+	url := self.getUrl(host, cadvisorMetricsPath)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", prometheusTextContentType)
+	client := self.getClient()
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body - %v", err)
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return nil, &ErrNotFound{url}
+	} else if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed - %q, response: %q", response.Status, string(body))
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return nil, &core.DecodeError{Err: fmt.Errorf("failed to parse the metrics of %s: %v", url, err)}
+	}
+	return containersFromPrometheus(families, time.Now()), nil
+}
+
+// cadvisorContainer accumulates the samples of a container.
+type cadvisorContainer struct {
+	info cadvisor.ContainerInfo
+	// Per CPU usage, reported by cAdvisor versions which do not report the total.
+	perCPUUsage uint64
+	hasTotalCPU bool
+	interfaces  map[string]*cadvisor.InterfaceStats
+	filesystems map[string]*cadvisor.FsStats
+	diskIo      map[string]*cadvisor.PerDiskStats
+}
+
+// containersFromPrometheus converts the metric families of cAdvisor to containers with a single
+// sample each, timestamped with the latest timestamp of their samples or now.
+func containersFromPrometheus(families map[string]*dto.MetricFamily, now time.Time) []cadvisor.ContainerInfo {
+	containers := map[string]*cadvisorContainer{}
+	for name, family := range families {
+		for _, metric := range family.Metric {
+			labels := make(map[string]string, len(metric.Label))
+			for _, pair := range metric.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			container, networkOnly := getCadvisorContainer(containers, labels)
+			if container == nil {
+				continue
+			}
+			if metric.TimestampMs != nil {
+				timestamp := time.Unix(0, metric.GetTimestampMs()*int64(time.Millisecond))
+				if timestamp.After(container.info.Stats[0].Timestamp) {
+					container.info.Stats[0].Timestamp = timestamp
+				}
+			}
+			container.add(name, labels, sampleValue(family.GetType(), metric), networkOnly)
+		}
+	}
+
+	result := make([]cadvisor.ContainerInfo, 0, len(containers))
+	for _, container := range containers {
+		result = append(result, container.finish(now))
+	}
+	return result
+}
+
+// getCadvisorContainer returns the container of the sample, nil if it should be ignored, and
+// whether only the network metrics of the sample should be kept: the samples of pod cgroups
+// only contribute the network of the pod, their usage being the sum of their containers.
+func getCadvisorContainer(containers map[string]*cadvisorContainer, labels map[string]string) (*cadvisorContainer, bool) {
+	id := labels["id"]
+	if id == "" {
+		return nil, false
+	}
+	// The labels were renamed in Kubernetes 1.16.
+	containerName := firstLabel(labels, "container", "container_name")
+	podName := firstLabel(labels, "pod", "pod_name")
+	namespace := labels["namespace"]
+
+	key := id
+	networkOnly := false
+	if podName != "" && namespace != "" {
+		if containerName == "" {
+			// The cgroup of the pod, holding the network of the pod with some runtimes.
+			networkOnly = true
+			containerName = infraContainerName
+		}
+		if containerName == infraContainerName {
+			key = namespace + "/" + podName
+		}
+	} else if strings.HasPrefix(id, kubernetesPodsCgroupPrefix) {
+		// Cgroups of the QoS classes and of pods not known to the kubelet yet.
+		return nil, false
+	}
+
+	container, found := containers[key]
+	if !found {
+		container = &cadvisorContainer{
+			info: cadvisor.ContainerInfo{
+				ContainerReference: cadvisor.ContainerReference{Name: id},
+				Stats:              []*cadvisor.ContainerStats{{}},
+			},
+			interfaces:  map[string]*cadvisor.InterfaceStats{},
+			filesystems: map[string]*cadvisor.FsStats{},
+			diskIo:      map[string]*cadvisor.PerDiskStats{},
+		}
+		if podName != "" && namespace != "" {
+			container.info.Spec.Labels = map[string]string{
+				kubernetesContainerLabel:    containerName,
+				kubernetesPodNameLabel:      podName,
+				kubernetesPodNamespaceLabel: namespace,
+			}
+		}
+		containers[key] = container
+	}
+	if !networkOnly {
+		// The infra container takes precedence over the cgroup of its pod.
+		container.info.Name = id
+		if image := labels["image"]; image != "" {
+			container.info.Spec.Image = image
+		}
+	}
+	return container, networkOnly
+}
+
+func (this *cadvisorContainer) add(family string, labels map[string]string, value float64, networkOnly bool) {
+	spec := &this.info.Spec
+	stats := this.info.Stats[0]
+	if strings.HasPrefix(family, "container_network_") {
+		spec.HasNetwork = true
+		iface := this.getInterface(labels["interface"])
+		switch family {
+		case "container_network_receive_bytes_total":
+			iface.RxBytes = uint64(value)
+		case "container_network_receive_errors_total":
+			iface.RxErrors = uint64(value)
+		case "container_network_transmit_bytes_total":
+			iface.TxBytes = uint64(value)
+		case "container_network_transmit_errors_total":
+			iface.TxErrors = uint64(value)
+		}
+		return
+	}
+	if networkOnly {
+		return
+	}
+
+	switch family {
+	case "container_start_time_seconds":
+		spec.CreationTime = time.Unix(0, int64(value*float64(time.Second)))
+	case "container_cpu_usage_seconds_total":
+		spec.HasCpu = true
+		usage := uint64(value * float64(time.Second))
+		if cpu := labels["cpu"]; cpu == "" || cpu == cadvisorTotalCPU {
+			stats.Cpu.Usage.Total = usage
+			this.hasTotalCPU = true
+		} else {
+			this.perCPUUsage += usage
+		}
+	case "container_cpu_load_average_10s":
+		spec.HasCpu = true
+		stats.Cpu.LoadAverage = int32(value)
+	case "container_memory_usage_bytes":
+		spec.HasMemory = true
+		stats.Memory.Usage = uint64(value)
+	case "container_memory_working_set_bytes":
+		spec.HasMemory = true
+		stats.Memory.WorkingSet = uint64(value)
+	case "container_memory_rss":
+		spec.HasMemory = true
+		stats.Memory.RSS = uint64(value)
+	case "container_memory_cache":
+		spec.HasMemory = true
+		stats.Memory.Cache = uint64(value)
+	case "container_memory_failures_total":
+		if scope := labels["scope"]; scope != "" && scope != cadvisorContainerMemoryScope {
+			return
+		}
+		switch firstLabel(labels, "failure_type", "type") {
+		case "pgfault":
+			stats.Memory.ContainerData.Pgfault = uint64(value)
+		case "pgmajfault":
+			stats.Memory.ContainerData.Pgmajfault = uint64(value)
+		}
+	case "container_fs_usage_bytes":
+		spec.HasFilesystem = true
+		this.getFilesystem(labels["device"]).Usage = uint64(value)
+	case "container_fs_limit_bytes":
+		spec.HasFilesystem = true
+		this.getFilesystem(labels["device"]).Limit = uint64(value)
+	case "container_fs_inodes_total":
+		fs := this.getFilesystem(labels["device"])
+		fs.HasInodes = true
+		fs.Inodes = uint64(value)
+	case "container_fs_inodes_free":
+		fs := this.getFilesystem(labels["device"])
+		fs.HasInodes = true
+		fs.InodesFree = uint64(value)
+	case "container_fs_reads_bytes_total":
+		spec.HasDiskIo = true
+		this.getDiskIo(labels["device"]).Stats["Read"] = uint64(value)
+	case "container_fs_writes_bytes_total":
+		spec.HasDiskIo = true
+		this.getDiskIo(labels["device"]).Stats["Write"] = uint64(value)
+	}
+}
+
+func (this *cadvisorContainer) getInterface(name string) *cadvisor.InterfaceStats {
+	iface, found := this.interfaces[name]
+	if !found {
+		iface = &cadvisor.InterfaceStats{Name: name}
+		this.interfaces[name] = iface
+	}
+	return iface
+}
+
+func (this *cadvisorContainer) getFilesystem(device string) *cadvisor.FsStats {
+	fs, found := this.filesystems[device]
+	if !found {
+		fs = &cadvisor.FsStats{Device: device}
+		this.filesystems[device] = fs
+	}
+	return fs
+}
+
+func (this *cadvisorContainer) getDiskIo(device string) *cadvisor.PerDiskStats {
+	disk, found := this.diskIo[device]
+	if !found {
+		disk = &cadvisor.PerDiskStats{Device: device, Stats: map[string]uint64{}}
+		this.diskIo[device] = disk
+	}
+	return disk
+}
+
+func (this *cadvisorContainer) finish(now time.Time) cadvisor.ContainerInfo {
+	stats := this.info.Stats[0]
+	if stats.Timestamp.IsZero() {
+		stats.Timestamp = now
+	}
+	if !this.hasTotalCPU {
+		stats.Cpu.Usage.Total = this.perCPUUsage
+	}
+	for _, iface := range this.interfaces {
+		stats.Network.Interfaces = append(stats.Network.Interfaces, *iface)
+	}
+	for _, fs := range this.filesystems {
+		if fs.Limit >= fs.Usage {
+			fs.Available = fs.Limit - fs.Usage
+		}
+		stats.Filesystem = append(stats.Filesystem, *fs)
+	}
+	for _, disk := range this.diskIo {
+		stats.DiskIo.IoServiceBytes = append(stats.DiskIo.IoServiceBytes, *disk)
+	}
+	return this.info
+}
+
+func sampleValue(metricType dto.MetricType, metric *dto.Metric) float64 {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return metric.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return metric.GetGauge().GetValue()
+	default:
+		return metric.GetUntyped().GetValue()
+	}
+}
+
+func firstLabel(labels map[string]string, names ...string) string {
+	for _, name := range names {
+		if value := labels[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+)
+
+const cadvisorMetrics = `# TYPE container_cpu_usage_seconds_total counter
+container_cpu_usage_seconds_total{cpu="total",id="/",image="",name="",namespace="",pod=""} 1000.5 1504267200000
+container_cpu_usage_seconds_total{cpu="total",id="/system.slice/docker.service",image="",name="",namespace="",pod=""} 20
+container_cpu_usage_seconds_total{cpu="total",id="/kubepods/burstable",image="",name="",namespace="",pod=""} 300
+container_cpu_usage_seconds_total{container="",cpu="total",id="/kubepods/burstable/pod1",image="",name="",namespace="ns1",pod="web"} 11
+container_cpu_usage_seconds_total{container="POD",cpu="total",id="/kubepods/burstable/pod1/abc",image="pause:3.0",name="k8s_POD_web",namespace="ns1",pod="web"} 0.5
+container_cpu_usage_seconds_total{container="nginx",cpu="total",id="/kubepods/burstable/pod1/def",image="nginx:1.13",name="k8s_nginx_web",namespace="ns1",pod="web"} 10.5
+# TYPE container_memory_working_set_bytes gauge
+container_memory_working_set_bytes{id="/",image="",name="",namespace="",pod=""} 4e+09
+container_memory_working_set_bytes{container="",id="/kubepods/burstable/pod1",image="",name="",namespace="ns1",pod="web"} 3e+08
+container_memory_working_set_bytes{container="nginx",id="/kubepods/burstable/pod1/def",image="nginx:1.13",name="k8s_nginx_web",namespace="ns1",pod="web"} 2e+08
+# TYPE container_memory_failures_total counter
+container_memory_failures_total{container="nginx",failure_type="pgmajfault",id="/kubepods/burstable/pod1/def",image="nginx:1.13",name="k8s_nginx_web",namespace="ns1",pod="web",scope="container"} 7
+container_memory_failures_total{container="nginx",failure_type="pgmajfault",id="/kubepods/burstable/pod1/def",image="nginx:1.13",name="k8s_nginx_web",namespace="ns1",pod="web",scope="hierarchy"} 9
+# TYPE container_network_receive_bytes_total counter
+container_network_receive_bytes_total{container="",id="/kubepods/burstable/pod1",image="",interface="eth0",name="",namespace="ns1",pod="web"} 1000
+container_network_receive_bytes_total{id="/",image="",interface="eth0",name="",namespace="",pod=""} 5000
+container_network_receive_bytes_total{id="/",image="",interface="eth1",name="",namespace="",pod=""} 500
+# TYPE container_fs_usage_bytes gauge
+container_fs_usage_bytes{container="nginx",device="/dev/sda1",id="/kubepods/burstable/pod1/def",image="nginx:1.13",name="k8s_nginx_web",namespace="ns1",pod="web"} 4096
+# TYPE container_fs_limit_bytes gauge
+container_fs_limit_bytes{container="nginx",device="/dev/sda1",id="/kubepods/burstable/pod1/def",image="nginx:1.13",name="k8s_nginx_web",namespace="ns1",pod="web"} 10240
+# TYPE container_fs_writes_bytes_total counter
+container_fs_writes_bytes_total{container="nginx",device="/dev/sda",id="/kubepods/burstable/pod1/def",image="nginx:1.13",name="k8s_nginx_web",namespace="ns1",pod="web"} 123
+`
+
+func TestScrapeCadvisorPrometheus(t *testing.T) {
+	var accept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != cadvisorMetricsPath {
+			http.NotFound(w, r)
+			return
+		}
+		accept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", prometheusTextContentType)
+		w.Write([]byte(cadvisorMetrics))
+	}))
+	defer server.Close()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	source := newKubeletMetricsSource(Host{IP: net.ParseIP(host), Port: portNum}, &KubeletClient{},
+		"node1", "node1.example.com", "id1", "true", "v1.9.0", nil, nil, true)
+	assert.Equal(t, "kubelet_cadvisor:"+server.URL[len("http://"):], source.Name())
+
+	end := time.Now()
+	batch, err := source.ScrapeMetrics(end.Add(-time.Minute), end)
+	require.NoError(t, err)
+	assert.Equal(t, prometheusTextContentType, accept)
+
+	// The QoS class cgroup is dropped.
+	assert.Len(t, batch.MetricSets, 4)
+
+	node := batch.MetricSets[core.NodeKey("node1")]
+	require.NotNil(t, node)
+	assert.Equal(t, core.MetricSetTypeNode, node.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, int64(1000500000000), node.MetricValues[core.MetricCpuUsage.Name].IntValue)
+	assert.Equal(t, int64(4e9), node.MetricValues[core.MetricMemoryWorkingSet.Name].IntValue)
+	assert.Equal(t, int64(5500), node.MetricValues[core.MetricNetworkRx.Name].IntValue)
+	assert.Equal(t, time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC), node.ScrapeTime.UTC())
+
+	system := batch.MetricSets[core.NodeContainerKey("node1", "system.slice/docker.service")]
+	require.NotNil(t, system)
+	assert.Equal(t, core.MetricSetTypeSystemContainer, system.Labels[core.LabelMetricSetType.Key])
+
+	// The network of the pod comes from its cgroup, its usage from the infra container.
+	pod := batch.MetricSets[core.PodKey("ns1", "web")]
+	require.NotNil(t, pod)
+	assert.Equal(t, core.MetricSetTypePod, pod.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, int64(1000), pod.MetricValues[core.MetricNetworkRx.Name].IntValue)
+	assert.Equal(t, int64(500000000), pod.MetricValues[core.MetricCpuUsage.Name].IntValue)
+
+	container := batch.MetricSets[core.PodContainerKey("ns1", "web", "nginx")]
+	require.NotNil(t, container)
+	assert.Equal(t, "nginx:1.13", container.Labels[core.LabelContainerBaseImage.Key])
+	assert.Equal(t, int64(10500000000), container.MetricValues[core.MetricCpuUsage.Name].IntValue)
+	assert.Equal(t, int64(2e8), container.MetricValues[core.MetricMemoryWorkingSet.Name].IntValue)
+	assert.Equal(t, int64(7), container.MetricValues[core.MetricMemoryMajorPageFaults.Name].IntValue)
+	labeled := map[string]int64{}
+	for _, metric := range container.LabeledMetrics {
+		labeled[metric.Name+":"+metric.Labels[core.LabelResourceID.Key]] = metric.IntValue
+	}
+	assert.Equal(t, int64(4096), labeled[core.MetricFilesystemUsage.Name+":/dev/sda1"])
+	assert.Equal(t, int64(10240), labeled[core.MetricFilesystemLimit.Name+":/dev/sda1"])
+	assert.Equal(t, int64(6144), labeled[core.MetricFilesystemAvailable.Name+":/dev/sda1"])
+	assert.Equal(t, int64(123), labeled[core.MetricDiskIOWrite.Name+":/dev/sda"])
+}
+
+func TestCadvisorPerCPUUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`container_cpu_usage_seconds_total{cpu="cpu00",id="/"} 1
+container_cpu_usage_seconds_total{cpu="cpu01",id="/"} 2
+`))
+	}))
+	defer server.Close()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	client := &KubeletClient{}
+	containers, err := client.GetCadvisorMetrics(Host{IP: net.ParseIP(host), Port: portNum})
+	require.NoError(t, err)
+	require.Len(t, containers, 1)
+	assert.Equal(t, uint64(3e9), containers[0].Stats[0].Cpu.Usage.Total)
+	assert.False(t, containers[0].Stats[0].Timestamp.IsZero())
+}
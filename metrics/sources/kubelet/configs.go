@@ -15,13 +15,20 @@
 package kubelet
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
 	kube_client "k8s.io/client-go/rest"
 	kube_config "k8s.io/heapster/common/kubernetes"
 	kubelet_client "k8s.io/heapster/metrics/sources/kubelet/util"
+	"k8s.io/heapster/metrics/util"
 )
 
 const (
@@ -32,6 +39,13 @@ const (
 	defaultUseServiceAccount  = false
 	defaultServiceAccountFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
 	defaultInClusterConfig    = true
+
+	// Prefix of the labels giving the roles of the nodes, e.g. node-role.kubernetes.io/master.
+	nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+	// Label giving the role of the nodes in older clusters, e.g. set up by kops.
+	legacyNodeRoleLabel = "kubernetes.io/role"
+	masterNodeRole      = "master"
+	workerNodeRole      = "worker"
 )
 
 func GetKubeConfigs(uri *url.URL) (*kube_client.Config, *kubelet_client.KubeletClientConfig, error) {
@@ -67,6 +81,68 @@ func GetKubeConfigs(uri *url.URL) (*kube_client.Config, *kubelet_client.KubeletC
 		TLSClientConfig: kubeConfig.TLSClientConfig,
 		BearerToken:     kubeConfig.BearerToken,
 	}
+	// Reload the token of the service account when it is rotated.
+	if len(kubeConfig.BearerToken) > 0 {
+		if contents, err := ioutil.ReadFile(defaultServiceAccountFile); err == nil &&
+			strings.TrimSpace(string(contents)) == strings.TrimSpace(kubeConfig.BearerToken) {
+			kubeletConfig.BearerTokenFile = defaultServiceAccountFile
+		}
+	}
 
 	return kubeConfig, kubeletConfig, nil
 }
+
+// nodeRoleSelector returns the label selector of the nodes with the role given by the nodeRole
+// option, or of the non-master nodes with excludeMasterNodes=true, empty to select all nodes.
+func nodeRoleSelector(opts url.Values) (string, error) {
+	excludeMasters := false
+	if len(opts["excludeMasterNodes"]) >= 1 {
+		var err error
+		if excludeMasters, err = strconv.ParseBool(opts["excludeMasterNodes"][0]); err != nil {
+			return "", fmt.Errorf("invalid excludeMasterNodes %q: %v", opts["excludeMasterNodes"][0], err)
+		}
+	}
+	role := opts.Get("nodeRole")
+	if excludeMasters && role != "" && role != workerNodeRole {
+		return "", fmt.Errorf("nodeRole=%s can't be combined with excludeMasterNodes", role)
+	}
+	switch {
+	case excludeMasters || role == workerNodeRole:
+		// Workers usually have no role label, so they are the nodes without the master one.
+		return fmt.Sprintf("!%s%s,%s!=%s", nodeRoleLabelPrefix, masterNodeRole, legacyNodeRoleLabel, masterNodeRole), nil
+	case role != "":
+		return nodeRoleLabelPrefix + role, nil
+	}
+	return "", nil
+}
+
+// GetNodeLister returns the lister of the nodes to scrape, i.e. those matching the labelSelector,
+// fieldSelector, nodeRole and excludeMasterNodes options of uri, e.g.
+// labelSelector=cloud.google.com/gke-nodepool=pool-1.
+func GetNodeLister(uri *url.URL) (v1listers.NodeLister, error) {
+	opts := uri.Query()
+	labelSelector := opts.Get("labelSelector")
+	if _, err := labels.Parse(labelSelector); err != nil {
+		return nil, fmt.Errorf("invalid labelSelector %q: %v", labelSelector, err)
+	}
+	roleSelector, err := nodeRoleSelector(opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := labels.Parse(roleSelector); err != nil {
+		return nil, fmt.Errorf("invalid nodeRole %q: %v", opts.Get("nodeRole"), err)
+	}
+	if roleSelector != "" && labelSelector != "" {
+		labelSelector += "," + roleSelector
+	} else if roleSelector != "" {
+		labelSelector = roleSelector
+	}
+	fieldSelector := opts.Get("fieldSelector")
+	if _, err := fields.ParseSelector(fieldSelector); err != nil {
+		return nil, fmt.Errorf("invalid fieldSelector %q: %v", fieldSelector, err)
+	}
+	if labelSelector != "" || fieldSelector != "" {
+		glog.Infof("Scraping only the nodes matching label selector %q and field selector %q", labelSelector, fieldSelector)
+	}
+	return util.GetFilteredNodeLister(uri, labelSelector, fieldSelector)
+}
@@ -0,0 +1,303 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	cadvisor "github.com/google/cadvisor/info/v1"
+	. "k8s.io/heapster/metrics/core"
+)
+
+const (
+	defaultDockerSocket = "/var/run/docker.sock"
+	// The host of the requests sent over the unix socket, ignored by the daemon.
+	dockerSocketHost = "docker"
+	// The Docker API version of the requests, supported by Docker 1.12 and later.
+	dockerApiVersion = "v1.24"
+)
+
+// dockerContainer is an entry of the /containers/json endpoint of the Docker API.
+type dockerContainer struct {
+	Id      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	Labels  map[string]string `json:"Labels"`
+	Created int64             `json:"Created"`
+}
+
+// dockerStats is the response of the /containers/{id}/stats endpoint of the Docker API.
+type dockerStats struct {
+	Read     time.Time `json:"read"`
+	CpuStats struct {
+		CpuUsage struct {
+			TotalUsage        uint64   `json:"total_usage"`
+			PercpuUsage       []uint64 `json:"percpu_usage"`
+			UsageInKernelmode uint64   `json:"usage_in_kernelmode"`
+			UsageInUsermode   uint64   `json:"usage_in_usermode"`
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes  uint64 `json:"rx_bytes"`
+		RxErrors uint64 `json:"rx_errors"`
+		TxBytes  uint64 `json:"tx_bytes"`
+		TxErrors uint64 `json:"tx_errors"`
+	} `json:"networks"`
+}
+
+// DockerClient reads the containers and their stats from the API of a Docker daemon, served
+// on a unix socket or over tcp.
+type DockerClient struct {
+	baseUrl string
+	client  *http.Client
+}
+
+// NewDockerClient returns a client of the daemon at the uri, unix:///var/run/docker.sock or
+// tcp://host:port. An empty uri stands for the default socket.
+func NewDockerClient(uri *url.URL) (*DockerClient, error) {
+	switch uri.Scheme {
+	case "", "unix":
+		socket := uri.Path
+		if socket == "" {
+			socket = defaultDockerSocket
+		}
+		transport := &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		}
+		return &DockerClient{
+			baseUrl: fmt.Sprintf("http://%s/%s", dockerSocketHost, dockerApiVersion),
+			client:  &http.Client{Transport: transport},
+		}, nil
+	case "tcp", "http":
+		if uri.Host == "" {
+			return nil, fmt.Errorf("missing host in docker endpoint %q", uri.String())
+		}
+		return &DockerClient{
+			baseUrl: fmt.Sprintf("http://%s/%s", uri.Host, dockerApiVersion),
+			client:  http.DefaultClient,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker endpoint %q, expected unix:// or tcp://", uri.String())
+	}
+}
+
+func (self *DockerClient) get(path string, value interface{}) error {
+	url := self.baseUrl + path
+	response, err := self.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body - %v", err)
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return &ErrNotFound{url}
+	} else if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed - %q, response: %q", response.Status, string(body))
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		return &DecodeError{Err: fmt.Errorf("failed to parse output of %s: %v", url, err)}
+	}
+	return nil
+}
+
+// GetAllContainers returns the running containers with a single sample of their stats each, as
+// if they were returned by the stats endpoint of the kubelet. Containers which stopped before
+// their stats were read are left out.
+func (self *DockerClient) GetAllContainers() ([]cadvisor.ContainerInfo, error) {
+	containers := []dockerContainer{}
+	if err := self.get("/containers/json", &containers); err != nil {
+		return nil, err
+	}
+
+	result := make([]cadvisor.ContainerInfo, len(containers))
+	errs := make([]error, len(containers))
+	var wg sync.WaitGroup
+	for i := range containers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Without stream=false, the daemon sends a sample every second.
+			stats := dockerStats{}
+			errs[i] = self.get(fmt.Sprintf("/containers/%s/stats?stream=false", containers[i].Id), &stats)
+			if errs[i] == nil {
+				result[i] = containerFromDocker(&containers[i], &stats)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	infos := make([]cadvisor.ContainerInfo, 0, len(containers))
+	for i, err := range errs {
+		if IsNotFoundError(err) {
+			glog.V(4).Infof("container %s stopped before its stats were read", containers[i].Id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		infos = append(infos, result[i])
+	}
+	return infos, nil
+}
+
+// containerFromDocker converts a Docker container and its stats to a cAdvisor container. The
+// containers of the kubelet carry the same Kubernetes labels with Docker as with cAdvisor.
+func containerFromDocker(container *dockerContainer, stats *dockerStats) cadvisor.ContainerInfo {
+	name := container.Id
+	if len(container.Names) > 0 {
+		name = container.Names[0]
+	}
+
+	cpu := &stats.CpuStats.CpuUsage
+	memory := &stats.MemoryStats
+	sample := &cadvisor.ContainerStats{Timestamp: stats.Read}
+	sample.Cpu.Usage.Total = cpu.TotalUsage
+	sample.Cpu.Usage.PerCpu = cpu.PercpuUsage
+	sample.Cpu.Usage.User = cpu.UsageInUsermode
+	sample.Cpu.Usage.System = cpu.UsageInKernelmode
+	sample.Memory.Usage = memory.Usage
+	sample.Memory.RSS = memory.Stats["rss"]
+	sample.Memory.Cache = memory.Stats["cache"]
+	sample.Memory.ContainerData.Pgfault = memory.Stats["pgfault"]
+	sample.Memory.ContainerData.Pgmajfault = memory.Stats["pgmajfault"]
+	// Computed as by cAdvisor: the usage without the inactive page cache.
+	sample.Memory.WorkingSet = memory.Usage
+	if inactive := memory.Stats["total_inactive_file"]; inactive < memory.Usage {
+		sample.Memory.WorkingSet = memory.Usage - inactive
+	}
+	interfaces := make([]string, 0, len(stats.Networks))
+	for iface := range stats.Networks {
+		interfaces = append(interfaces, iface)
+	}
+	sort.Strings(interfaces)
+	for _, iface := range interfaces {
+		network := stats.Networks[iface]
+		sample.Network.Interfaces = append(sample.Network.Interfaces, cadvisor.InterfaceStats{
+			Name:     iface,
+			RxBytes:  network.RxBytes,
+			RxErrors: network.RxErrors,
+			TxBytes:  network.TxBytes,
+			TxErrors: network.TxErrors,
+		})
+	}
+	if sample.Timestamp.IsZero() {
+		sample.Timestamp = time.Now()
+	}
+
+	return cadvisor.ContainerInfo{
+		ContainerReference: cadvisor.ContainerReference{Name: name, Id: container.Id},
+		Spec: cadvisor.ContainerSpec{
+			CreationTime: time.Unix(container.Created, 0),
+			Labels:       container.Labels,
+			Image:        container.Image,
+			HasCpu:       true,
+			HasMemory:    true,
+			// Only the infra container of a pod reports the network of the pod.
+			HasNetwork: len(interfaces) > 0,
+		},
+		Stats: []*cadvisor.ContainerStats{sample},
+	}
+}
+
+// dockerMetricsSource scrapes the containers of the Docker daemon of a node, decoded as the
+// containers returned by the kubelet. The daemon does not report the node itself.
+type dockerMetricsSource struct {
+	kubeletMetricsSource
+	dockerClient *DockerClient
+}
+
+func (this *dockerMetricsSource) Name() string {
+	return this.String()
+}
+
+func (this *dockerMetricsSource) String() string {
+	return fmt.Sprintf("kubernetes.docker:%s", this.nodename)
+}
+
+func (this *dockerMetricsSource) ScrapeMetrics(start, end time.Time) (*DataBatch, error) {
+	containers, err := this.dockerClient.GetAllContainers()
+	if err != nil {
+		return nil, err
+	}
+	glog.V(2).Infof("successfully obtained stats from the docker daemon of %s for %v containers", this.nodename, len(containers))
+
+	result := &DataBatch{
+		Timestamp:  end,
+		MetricSets: map[string]*MetricSet{},
+	}
+	for _, c := range containers {
+		name, metrics := this.decodeMetrics(&c)
+		if name == "" || metrics == nil {
+			continue
+		}
+		result.MetricSets[name] = metrics
+	}
+	return result, nil
+}
+
+type dockerProvider struct {
+	source *dockerMetricsSource
+}
+
+func (this *dockerProvider) GetMetricsSources() []MetricsSource {
+	return []MetricsSource{this.source}
+}
+
+// NewDockerProvider returns a provider scraping the local Docker daemon, for nodes whose kubelet
+// stats endpoints are disabled. The containers are mapped to their pods by the labels the
+// kubelet sets on them. The node is named by the node_name option, the NODE_NAME environment
+// variable or the hostname, in this order.
+func NewDockerProvider(uri *url.URL) (MetricsSourceProvider, error) {
+	client, err := NewDockerClient(uri)
+	if err != nil {
+		return nil, err
+	}
+	nodeName := uri.Query().Get("node_name")
+	if nodeName == "" {
+		nodeName = os.Getenv("NODE_NAME")
+	}
+	if nodeName == "" {
+		if nodeName, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to get the hostname: %v", err)
+		}
+	}
+	return &dockerProvider{
+		source: &dockerMetricsSource{
+			kubeletMetricsSource: kubeletMetricsSource{
+				nodename: nodeName,
+				hostname: nodeName,
+			},
+			dockerClient: client,
+		},
+	}, nil
+}
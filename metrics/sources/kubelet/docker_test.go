@@ -0,0 +1,125 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	dockerContainers = `[
+  {"Id": "app", "Names": ["/k8s_app_web-1_default_uid-1_0"], "Image": "nginx:1.13", "Created": 1500000000,
+   "Labels": {"io.kubernetes.container.name": "app", "io.kubernetes.pod.name": "web-1",
+              "io.kubernetes.pod.namespace": "default", "io.kubernetes.pod.uid": "uid-1"}},
+  {"Id": "sandbox", "Names": ["/k8s_POD_web-1_default_uid-1_0"], "Image": "pause:3.0", "Created": 1500000000,
+   "Labels": {"io.kubernetes.container.name": "POD", "io.kubernetes.pod.name": "web-1",
+              "io.kubernetes.pod.namespace": "default", "io.kubernetes.pod.uid": "uid-1"}},
+  {"Id": "registry", "Names": ["/registry"], "Image": "registry:2", "Created": 1500000000},
+  {"Id": "stopped", "Names": ["/stopped"], "Image": "busybox", "Created": 1500000000}
+]`
+	dockerAppStats = `{"read": "2017-07-14T02:40:00Z",
+  "cpu_stats": {"cpu_usage": {"total_usage": 2000000000, "percpu_usage": [1500000000, 500000000]}},
+  "memory_stats": {"usage": 3000, "stats": {"rss": 1000, "cache": 2000, "total_inactive_file": 500, "pgfault": 7}}}`
+	dockerSandboxStats = `{"read": "2017-07-14T02:40:00Z",
+  "cpu_stats": {"cpu_usage": {"total_usage": 1000}},
+  "memory_stats": {"usage": 100},
+  "networks": {"eth0": {"rx_bytes": 10, "tx_bytes": 20, "rx_errors": 1}}}`
+	dockerRegistryStats = `{"read": "2017-07-14T02:40:00Z", "cpu_stats": {"cpu_usage": {"total_usage": 5}}}`
+)
+
+func newDockerServer() *httptest.Server {
+	responses := map[string]string{
+		"/v1.24/containers/json":           dockerContainers,
+		"/v1.24/containers/app/stats":      dockerAppStats,
+		"/v1.24/containers/sandbox/stats":  dockerSandboxStats,
+		"/v1.24/containers/registry/stats": dockerRegistryStats,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, found := responses[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+}
+
+func TestDockerSource(t *testing.T) {
+	server := newDockerServer()
+	defer server.Close()
+	serverUrl, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	uri, err := url.Parse("tcp://" + serverUrl.Host + "?node_name=node-1")
+	require.NoError(t, err)
+	provider, err := NewDockerProvider(uri)
+	require.NoError(t, err)
+	sources := provider.GetMetricsSources()
+	require.Equal(t, 1, len(sources))
+	assert.Equal(t, "kubernetes.docker:node-1", sources[0].Name())
+
+	batch, err := sources[0].ScrapeMetrics(time.Now().Add(-time.Minute), time.Now())
+	require.NoError(t, err)
+	// The stopped container is left out.
+	require.Equal(t, 3, len(batch.MetricSets))
+
+	container := batch.MetricSets[core.PodContainerKeyWithUID("default", "web-1", "uid-1", "app")]
+	require.NotNil(t, container)
+	assert.Equal(t, core.MetricSetTypePodContainer, container.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, "nginx:1.13", container.Labels[core.LabelContainerBaseImage.Key])
+	assert.Equal(t, "node-1", container.Labels[core.LabelNodename.Key])
+	assert.Equal(t, "uid-1", container.Labels[core.LabelPodId.Key])
+	assert.Equal(t, time.Unix(1500000000, 0), container.CollectionStartTime)
+	assert.Equal(t, uint64(2000000000), uint64(container.MetricValues[core.MetricCpuUsage.Name].IntValue))
+	assert.Equal(t, int64(3000), container.MetricValues[core.MetricMemoryUsage.Name].IntValue)
+	assert.Equal(t, int64(2500), container.MetricValues[core.MetricMemoryWorkingSet.Name].IntValue)
+	assert.Equal(t, int64(1000), container.MetricValues[core.MetricMemoryRSS.Name].IntValue)
+	_, found := container.MetricValues[core.MetricNetworkRx.Name]
+	assert.False(t, found)
+
+	pod := batch.MetricSets[core.PodKeyWithUID("default", "web-1", "uid-1")]
+	require.NotNil(t, pod)
+	assert.Equal(t, core.MetricSetTypePod, pod.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, int64(10), pod.MetricValues[core.MetricNetworkRx.Name].IntValue)
+	assert.Equal(t, int64(20), pod.MetricValues[core.MetricNetworkTx.Name].IntValue)
+	assert.Equal(t, int64(1), pod.MetricValues[core.MetricNetworkRxErrors.Name].IntValue)
+
+	registry := batch.MetricSets[core.NodeContainerKey("node-1", "registry")]
+	require.NotNil(t, registry)
+	assert.Equal(t, core.MetricSetTypeSystemContainer, registry.Labels[core.LabelMetricSetType.Key])
+}
+
+func TestDockerClientEndpoints(t *testing.T) {
+	for endpoint, valid := range map[string]bool{
+		"":                            true,
+		"unix:///var/run/docker.sock": true,
+		"tcp://10.0.0.5:2375":         true,
+		"tcp://":                      false,
+		"npipe:////./pipe/docker":     false,
+	} {
+		uri, err := url.Parse(endpoint)
+		require.NoError(t, err)
+		_, err = NewDockerClient(uri)
+		assert.Equal(t, valid, err == nil, endpoint)
+	}
+}
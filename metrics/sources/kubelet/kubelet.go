@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,8 +32,6 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	kube_client "k8s.io/client-go/kubernetes"
 	v1listers "k8s.io/client-go/listers/core/v1"
-	"k8s.io/client-go/tools/cache"
-	"k8s.io/heapster/metrics/util"
 )
 
 const (
@@ -63,22 +62,38 @@ func init() {
 
 // Kubelet-provided metrics for pod and system container.
 type kubeletMetricsSource struct {
-	host          Host
-	kubeletClient *KubeletClient
-	nodename      string
-	hostname      string
-	hostId        string
-	schedulable   string
+	host           Host
+	kubeletClient  *KubeletClient
+	nodename       string
+	hostname       string
+	hostId         string
+	schedulable    string
+	kubeletVersion string
+	notifier       *ScrapeFailureNotifier
+	backoff        *ScrapeBackoff
+	// Scrape the Prometheus endpoint of cAdvisor instead of the stats endpoint.
+	cadvisorPrometheus bool
+}
+
+func NewKubeletMetricsSource(host Host, client *KubeletClient, nodeName string, hostName string, hostId string, schedulable string,
+	kubeletVersion string, notifier *ScrapeFailureNotifier, backoff *ScrapeBackoff) MetricsSource {
+	return newKubeletMetricsSource(host, client, nodeName, hostName, hostId, schedulable, kubeletVersion, notifier, backoff, false)
 }
 
-func NewKubeletMetricsSource(host Host, client *KubeletClient, nodeName string, hostName string, hostId string, schedulable string) MetricsSource {
+func newKubeletMetricsSource(host Host, client *KubeletClient, nodeName string, hostName string, hostId string, schedulable string,
+	kubeletVersion string, notifier *ScrapeFailureNotifier, backoff *ScrapeBackoff, cadvisorPrometheus bool) *kubeletMetricsSource {
 	return &kubeletMetricsSource{
-		host:          host,
-		kubeletClient: client,
-		nodename:      nodeName,
-		hostname:      hostName,
-		hostId:        hostId,
-		schedulable:   schedulable,
+		host:           host,
+		kubeletClient:  client,
+		nodename:       nodeName,
+		hostname:       hostName,
+		hostId:         hostId,
+		notifier:       notifier,
+		backoff:        backoff,
+		schedulable:    schedulable,
+		kubeletVersion: kubeletVersion,
+
+		cadvisorPrometheus: cadvisorPrometheus,
 	}
 }
 
@@ -86,7 +101,18 @@ func (this *kubeletMetricsSource) Name() string {
 	return this.String()
 }
 
+func (this *kubeletMetricsSource) NodeName() string {
+	return this.nodename
+}
+
+func (this *kubeletMetricsSource) KubeletVersion() string {
+	return this.kubeletVersion
+}
+
 func (this *kubeletMetricsSource) String() string {
+	if this.cadvisorPrometheus {
+		return fmt.Sprintf("kubelet_cadvisor:%s:%d", this.host.IP, this.host.Port)
+	}
 	return fmt.Sprintf("kubelet:%s:%d", this.host.IP, this.host.Port)
 }
 
@@ -103,16 +129,17 @@ func (this *kubeletMetricsSource) handleSystemContainer(c *cadvisor.ContainerInf
 
 func (this *kubeletMetricsSource) handleKubernetesContainer(cName, ns, podName string, c *cadvisor.ContainerInfo, cMetrics *MetricSet) string {
 	var metricSetKey string
+	podUID := c.Spec.Labels[kubernetesPodUID]
 	if cName == infraContainerName {
-		metricSetKey = PodKey(ns, podName)
+		metricSetKey = PodKeyWithUID(ns, podName, podUID)
 		cMetrics.Labels[LabelMetricSetType.Key] = MetricSetTypePod
 	} else {
-		metricSetKey = PodContainerKey(ns, podName, cName)
+		metricSetKey = PodContainerKeyWithUID(ns, podName, podUID, cName)
 		cMetrics.Labels[LabelMetricSetType.Key] = MetricSetTypePodContainer
 		cMetrics.Labels[LabelContainerName.Key] = cName
 		cMetrics.Labels[LabelContainerBaseImage.Key] = c.Spec.Image
 	}
-	cMetrics.Labels[LabelPodId.Key] = c.Spec.Labels[kubernetesPodUID]
+	cMetrics.Labels[LabelPodId.Key] = podUID
 	cMetrics.Labels[LabelPodName.Key] = podName
 	cMetrics.Labels[LabelNamespaceName.Key] = ns
 	return metricSetKey
@@ -235,6 +262,8 @@ metricloop:
 
 func (this *kubeletMetricsSource) ScrapeMetrics(start, end time.Time) (*DataBatch, error) {
 	containers, err := this.scrapeKubelet(this.kubeletClient, this.host, start, end)
+	this.notifier.Observe(this.nodename, err)
+	this.backoff.Observe(this.nodename, err)
 
 	if err != nil {
 		return nil, err
@@ -263,13 +292,19 @@ func (this *kubeletMetricsSource) scrapeKubelet(client *KubeletClient, host Host
 	defer func() {
 		kubeletRequestLatency.WithLabelValues(this.hostname).Observe(float64(time.Since(startTime)) / float64(time.Millisecond))
 	}()
+	if this.cadvisorPrometheus {
+		return client.GetCadvisorMetrics(host)
+	}
 	return client.GetAllRawContainers(host, start, end)
 }
 
 type kubeletProvider struct {
 	nodeLister    v1listers.NodeLister
-	reflector     *cache.Reflector
 	kubeletClient *KubeletClient
+	notifier      *ScrapeFailureNotifier
+	backoff       *ScrapeBackoff
+	// Scrape the Prometheus endpoint of cAdvisor instead of the stats endpoint.
+	cadvisorPrometheus bool
 }
 
 func (this *kubeletProvider) GetMetricsSources() []MetricsSource {
@@ -283,26 +318,40 @@ func (this *kubeletProvider) GetMetricsSources() []MetricsSource {
 		glog.Error("No nodes received from APIserver.")
 		return sources
 	}
+	this.kubeletClient.ScaleToNodes(len(nodes))
+	this.backoff.Retain(nodes)
 
 	for _, node := range nodes {
+		if !this.backoff.ShouldScrape(node.Name) {
+			continue
+		}
 		hostname, ip, err := GetNodeHostnameAndIP(node)
 		if err != nil {
 			glog.Errorf("%v", err)
 			continue
 		}
-		sources = append(sources, NewKubeletMetricsSource(
-			Host{IP: ip, Port: this.kubeletClient.GetPort()},
+		host, err := GetNodeHost(node, ip, this.kubeletClient.GetPort())
+		if err != nil {
+			glog.Errorf("%v", err)
+			continue
+		}
+		sources = append(sources, newKubeletMetricsSource(
+			host,
 			this.kubeletClient,
 			node.Name,
 			hostname,
 			node.Spec.ExternalID,
-			getNodeSchedulableStatus(node),
+			GetNodeSchedulableStatus(node),
+			node.Status.NodeInfo.KubeletVersion,
+			this.notifier,
+			this.backoff,
+			this.cadvisorPrometheus,
 		))
 	}
 	return sources
 }
 
-func getNodeSchedulableStatus(node *kube_api.Node) string {
+func GetNodeSchedulableStatus(node *kube_api.Node) string {
 	if node.Spec.Unschedulable {
 		return "false"
 	}
@@ -336,7 +385,45 @@ func GetNodeHostnameAndIP(node *kube_api.Node) (string, net.IP, error) {
 	return "", nil, fmt.Errorf("node %v has no valid hostname and/or IP address: %v %v", node.Name, hostname, ip)
 }
 
+const (
+	// Annotations of the nodes whose kubelet is not served on the port or with the scheme of the
+	// configuration of the source, e.g. nodes only exposing the kubelet over HTTPS.
+	PortAnnotation   = "heapster.kubernetes.io/port"
+	SchemeAnnotation = "heapster.kubernetes.io/scheme"
+)
+
+// GetNodeHost returns the endpoint of the kubelet of the node at the given IP, on the given
+// default port unless the annotations of the node override it.
+func GetNodeHost(node *kube_api.Node, ip net.IP, defaultPort int) (Host, error) {
+	host := Host{IP: ip, Port: defaultPort}
+	if port, found := node.Annotations[PortAnnotation]; found {
+		parsed, err := strconv.Atoi(port)
+		if err != nil || parsed <= 0 || parsed > 65535 {
+			return Host{}, fmt.Errorf("node %v has an invalid %s annotation: %q", node.Name, PortAnnotation, port)
+		}
+		host.Port = parsed
+	}
+	if scheme, found := node.Annotations[SchemeAnnotation]; found {
+		if scheme != "http" && scheme != "https" {
+			return Host{}, fmt.Errorf("node %v has an invalid %s annotation: %q, expected http or https", node.Name, SchemeAnnotation, scheme)
+		}
+		host.Scheme = scheme
+	}
+	return host, nil
+}
+
 func NewKubeletProvider(uri *url.URL) (MetricsSourceProvider, error) {
+	return newKubeletProvider(uri, false)
+}
+
+// NewCadvisorPrometheusProvider returns a provider scraping the Prometheus endpoint of the
+// cAdvisor embedded in the kubelets, /metrics/cadvisor, which is kept by kubelets deprecating
+// the stats endpoints. It supports the same options as NewKubeletProvider.
+func NewCadvisorPrometheusProvider(uri *url.URL) (MetricsSourceProvider, error) {
+	return newKubeletProvider(uri, true)
+}
+
+func newKubeletProvider(uri *url.URL, cadvisorPrometheus bool) (MetricsSourceProvider, error) {
 	// create clients
 	kubeConfig, kubeletConfig, err := GetKubeConfigs(uri)
 	if err != nil {
@@ -353,12 +440,28 @@ func NewKubeletProvider(uri *url.URL) (MetricsSourceProvider, error) {
 		glog.Errorf("Failed to load nodes: %v", err)
 	}
 
+	notifier, err := NewScrapeFailureNotifier(uri, kubeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff, err := NewScrapeBackoff(uri)
+	if err != nil {
+		return nil, err
+	}
+
 	// watch nodes
-	nodeLister, reflector, _ := util.GetNodeLister(kubeClient)
+	nodeLister, err := GetNodeLister(uri)
+	if err != nil {
+		return nil, err
+	}
 
 	return &kubeletProvider{
 		nodeLister:    nodeLister,
-		reflector:     reflector,
 		kubeletClient: kubeletClient,
+		notifier:      notifier,
+		backoff:       backoff,
+
+		cadvisorPrometheus: cadvisorPrometheus,
 	}, nil
 }
@@ -25,11 +25,15 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	cadvisor "github.com/google/cadvisor/info/v1"
 	jsoniter "github.com/json-iterator/go"
+	"k8s.io/heapster/common/credentials"
+	"k8s.io/heapster/metrics/core"
 	kubelet_client "k8s.io/heapster/metrics/sources/kubelet/util"
 	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 )
@@ -38,15 +42,31 @@ type Host struct {
 	IP       net.IP
 	Port     int
 	Resource string
+	// Scheme of the kubelet endpoint, http or https. The one of the client configuration if empty.
+	Scheme string
 }
 
 func (h Host) String() string {
 	return net.JoinHostPort(h.IP.String(), strconv.Itoa(h.Port))
 }
 
+const (
+	// Bounds of the kubelet connection pool size, which is derived from the number of nodes.
+	MinIdleConnectionPoolSize = 100
+	MaxIdleConnectionPoolSize = 5000
+)
+
 type KubeletClient struct {
 	config *kubelet_client.KubeletClientConfig
+
+	lock   sync.RWMutex
 	client *http.Client
+	// Bearer token file and CA file of the configuration, if set, read again when they change,
+	// e.g. because the service account token was rotated.
+	tokenFile *credentials.File
+	caFile    *credentials.File
+	// Contents of the CA file when the client was created.
+	caData string
 }
 
 type ErrNotFound struct {
@@ -93,7 +113,7 @@ func (self *KubeletClient) postRequestAndGetValue(client *http.Client, req *http
 
 	err = jsoniter.ConfigFastest.Unmarshal(body, value)
 	if err != nil {
-		return fmt.Errorf("failed to parse output. Response: %q. Error: %v", string(body), err)
+		return &core.DecodeError{Err: fmt.Errorf("failed to parse output. Response: %q. Error: %v", string(body), err)}
 	}
 	return nil
 }
@@ -144,6 +164,9 @@ func (self *KubeletClient) getUrl(host Host, path string) string {
 		Host:   host.String(),
 		Path:   path,
 	}
+	if host.Scheme != "" {
+		url.Scheme = host.Scheme
+	}
 
 	return url.String()
 }
@@ -163,7 +186,7 @@ func (self *KubeletClient) GetSummary(host Host) (*stats.Summary, error) {
 		return nil, err
 	}
 	summary := &stats.Summary{}
-	client := self.client
+	client := self.getClient()
 	if client == nil {
 		client = http.DefaultClient
 	}
@@ -171,6 +194,87 @@ func (self *KubeletClient) GetSummary(host Host) (*stats.Summary, error) {
 	return summary, err
 }
 
+func (self *KubeletClient) getClient() *http.Client {
+	self.reloadCredentials()
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.client
+}
+
+// ScaleToNodes resizes the pool of idle kubelet connections to fit the given number of nodes,
+// within MinIdleConnectionPoolSize and MaxIdleConnectionPoolSize. Resizing replaces the
+// transport and so drops the pooled connections, hence the size is rounded up to a multiple of
+// MinIdleConnectionPoolSize so that nodes joining and leaving do not reset the pool every time.
+func (self *KubeletClient) ScaleToNodes(nodes int) {
+	size := (nodes + MinIdleConnectionPoolSize - 1) / MinIdleConnectionPoolSize * MinIdleConnectionPoolSize
+	if size < MinIdleConnectionPoolSize {
+		size = MinIdleConnectionPoolSize
+	}
+	if size > MaxIdleConnectionPoolSize {
+		size = MaxIdleConnectionPoolSize
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.config == nil || self.config.MaxIdleConns == size {
+		return
+	}
+	config := *self.config
+	config.MaxIdleConns = size
+	client, err := newHttpClient(&config)
+	if err != nil {
+		glog.Errorf("Failed to resize kubelet connection pool to %d: %v", size, err)
+		return
+	}
+	glog.V(1).Infof("Resized kubelet connection pool from %d to %d", self.config.MaxIdleConns, size)
+	self.config = &config
+	self.client = client
+}
+
+// reloadCredentials replaces the transport if the bearer token file or the CA file of the
+// configuration changed. Files which can't be read are logged and their last contents are used.
+func (self *KubeletClient) reloadCredentials() {
+	if self.tokenFile == nil && self.caFile == nil {
+		return
+	}
+	var token, caData string
+	if self.tokenFile != nil {
+		token = self.tokenFile.Get()
+	}
+	if self.caFile != nil {
+		caData = self.caFile.Get()
+	}
+	changed := func() bool {
+		return (self.tokenFile != nil && len(token) > 0 && token != strings.TrimSpace(self.config.BearerToken)) ||
+			(self.caFile != nil && caData != self.caData)
+	}
+	self.lock.RLock()
+	reload := changed()
+	self.lock.RUnlock()
+	if !reload {
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	// Replaced by a concurrent request.
+	if !changed() {
+		return
+	}
+	config := *self.config
+	if self.tokenFile != nil && len(token) > 0 {
+		config.BearerToken = token
+	}
+	client, err := newHttpClient(&config)
+	if err != nil {
+		glog.Errorf("Failed to replace the kubelet client with the reloaded credentials: %v", err)
+		return
+	}
+	self.config = &config
+	self.client = client
+	self.caData = caData
+}
+
 func (self *KubeletClient) GetPort() int {
 	return int(self.config.Port)
 }
@@ -195,12 +299,14 @@ func (self *KubeletClient) getAllContainers(url string, start, end time.Time) ([
 	req.Header.Set("Content-Type", "application/json")
 
 	var containers map[string]cadvisor.ContainerInfo
-	client := self.client
+	client := self.getClient()
 	if client == nil {
 		client = http.DefaultClient
 	}
 	err = self.postRequestAndGetValue(client, req, &containers)
-	if err != nil {
+	if decodeErr, ok := err.(*core.DecodeError); ok {
+		return nil, &core.DecodeError{Err: fmt.Errorf("failed to get all container stats from Kubelet URL %q: %v", url, decodeErr.Err)}
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to get all container stats from Kubelet URL %q: %v", url, err)
 	}
 	result := make([]cadvisor.ContainerInfo, 0, len(containers))
@@ -213,17 +319,36 @@ func (self *KubeletClient) getAllContainers(url string, start, end time.Time) ([
 	return result, nil
 }
 
-func NewKubeletClient(kubeletConfig *kubelet_client.KubeletClientConfig) (*KubeletClient, error) {
+func newHttpClient(kubeletConfig *kubelet_client.KubeletClientConfig) (*http.Client, error) {
 	transport, err := kubelet_client.MakeTransport(kubeletConfig)
 	if err != nil {
 		return nil, err
 	}
-	c := &http.Client{
+	return &http.Client{
 		Transport: transport,
 		Timeout:   kubeletConfig.HTTPTimeout,
+	}, nil
+}
+
+func NewKubeletClient(kubeletConfig *kubelet_client.KubeletClientConfig) (*KubeletClient, error) {
+	c, err := newHttpClient(kubeletConfig)
+	if err != nil {
+		return nil, err
 	}
-	return &KubeletClient{
+	client := &KubeletClient{
 		config: kubeletConfig,
 		client: c,
-	}, nil
+	}
+	if len(kubeletConfig.BearerTokenFile) > 0 {
+		if client.tokenFile, err = credentials.NewFile("kubelet bearer token", kubeletConfig.BearerTokenFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(kubeletConfig.CAFile) > 0 {
+		if client.caFile, err = credentials.NewFile("kubelet CA bundle", kubeletConfig.CAFile); err != nil {
+			return nil, err
+		}
+		client.caData = client.caFile.Get()
+	}
+	return client, nil
 }
@@ -15,7 +15,14 @@
 package kubelet
 
 import (
+	"io/ioutil"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -24,6 +31,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	util "k8s.io/client-go/util/testing"
+	kubelet_client "k8s.io/heapster/metrics/sources/kubelet/util"
 )
 
 func checkContainer(t *testing.T, expected cadvisor_api.ContainerInfo, actual cadvisor_api.ContainerInfo) {
@@ -102,3 +110,103 @@ func TestAllContainers(t *testing.T) {
 	checkContainer(t, rootContainer, containers[0])
 	checkContainer(t, subcontainer, containers[1])
 }
+
+func TestKubeletConnectionsReused(t *testing.T) {
+	var lock sync.Mutex
+	connections := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"node":{"nodeName":"node1"}}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			connections++
+			lock.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	kubeletClient, err := NewKubeletClient(&kubelet_client.KubeletClientConfig{HTTPTimeout: time.Second})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := kubeletClient.GetSummary(Host{IP: net.ParseIP(host), Port: portNum})
+		require.NoError(t, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 1, connections)
+}
+
+func TestScaleToNodes(t *testing.T) {
+	kubeletClient, err := NewKubeletClient(&kubelet_client.KubeletClientConfig{})
+	require.NoError(t, err)
+
+	kubeletClient.ScaleToNodes(10)
+	assert.Equal(t, MinIdleConnectionPoolSize, kubeletClient.config.MaxIdleConns)
+	kubeletClient.ScaleToNodes(1050)
+	assert.Equal(t, 1100, kubeletClient.config.MaxIdleConns)
+	client := kubeletClient.getClient()
+
+	// Small changes of the number of nodes keep the pooled connections.
+	kubeletClient.ScaleToNodes(1020)
+	assert.True(t, client == kubeletClient.getClient())
+	kubeletClient.ScaleToNodes(100000)
+	assert.Equal(t, MaxIdleConnectionPoolSize, kubeletClient.config.MaxIdleConns)
+}
+
+func TestReloadCredentials(t *testing.T) {
+	var lock sync.Mutex
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		authorization = r.Header.Get("Authorization")
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"node":{"nodeName":"node1"}}`))
+	}))
+	defer server.Close()
+
+	tokenFile, err := ioutil.TempFile("", "token")
+	require.NoError(t, err)
+	defer os.Remove(tokenFile.Name())
+	require.NoError(t, ioutil.WriteFile(tokenFile.Name(), []byte("old\n"), 0600))
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "https://"))
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	kubeletClient, err := NewKubeletClient(&kubelet_client.KubeletClientConfig{
+		EnableHttps:     true,
+		BearerToken:     "old",
+		BearerTokenFile: tokenFile.Name(),
+		HTTPTimeout:     time.Second,
+	})
+	require.NoError(t, err)
+	getAuthorization := func() string {
+		_, err := kubeletClient.GetSummary(Host{IP: net.ParseIP(host), Port: portNum})
+		require.NoError(t, err)
+		lock.Lock()
+		defer lock.Unlock()
+		return authorization
+	}
+	assert.Equal(t, "Bearer old", getAuthorization())
+
+	// Unchanged credentials keep the pooled connections.
+	client := kubeletClient.getClient()
+	assert.True(t, client == kubeletClient.getClient())
+
+	require.NoError(t, ioutil.WriteFile(tokenFile.Name(), []byte("new\n"), 0600))
+	require.NoError(t, os.Chtimes(tokenFile.Name(), time.Now(), time.Now().Add(time.Second)))
+	assert.Equal(t, "Bearer new", getAuthorization())
+
+	// The previous token is kept while the file can't be read.
+	require.NoError(t, os.Remove(tokenFile.Name()))
+	assert.Equal(t, "Bearer new", getAuthorization())
+}
@@ -16,6 +16,7 @@ package kubelet
 import (
 	"net"
 	"net/http/httptest"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
@@ -27,6 +28,7 @@ import (
 	"github.com/stretchr/testify/require"
 	kube_api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	util "k8s.io/client-go/util/testing"
 	"k8s.io/heapster/metrics/core"
 )
@@ -406,6 +408,30 @@ func TestGetNodeHostnameAndIP(t *testing.T) {
 	}
 }
 
+func TestGetNodeHost(t *testing.T) {
+	ip := net.ParseIP("127.0.0.1")
+	node := &kube_api.Node{ObjectMeta: metav1.ObjectMeta{Name: "testNode"}}
+	host, err := GetNodeHost(node, ip, 10255)
+	require.NoError(t, err)
+	assert.Equal(t, Host{IP: ip, Port: 10255}, host)
+
+	node.Annotations = map[string]string{PortAnnotation: "10250", SchemeAnnotation: "https"}
+	host, err = GetNodeHost(node, ip, 10255)
+	require.NoError(t, err)
+	assert.Equal(t, Host{IP: ip, Port: 10250, Scheme: "https"}, host)
+	assert.Equal(t, "https://127.0.0.1:10250/stats/summary/", (&KubeletClient{}).getUrl(host, "/stats/summary/"))
+
+	for _, annotations := range []map[string]string{
+		{PortAnnotation: "abc"},
+		{PortAnnotation: "70000"},
+		{SchemeAnnotation: "ftp"},
+	} {
+		node.Annotations = annotations
+		_, err = GetNodeHost(node, ip, 10255)
+		assert.Error(t, err, "%v", annotations)
+	}
+}
+
 func TestScrapeMetrics(t *testing.T) {
 	rootContainer := cadvisor_api.ContainerInfo{
 		ContainerReference: cadvisor_api.ContainerReference{
@@ -512,9 +538,41 @@ func TestGetNodeSchedulableStatus(t *testing.T) {
 	}
 
 	for _, meta := range metas {
-		got := getNodeSchedulableStatus(meta.Node)
+		got := GetNodeSchedulableStatus(meta.Node)
 		if got != meta.Wanted {
 			t.Errorf("get node schedulable status error. wanted: %s, got: %s", meta.Wanted, got)
 		}
 	}
 }
+
+func TestGetNodeListerValidatesSelectors(t *testing.T) {
+	for _, query := range []string{"labelSelector=pool+in+(a", "fieldSelector=spec.unschedulable", "nodeRole=a+b",
+		"excludeMasterNodes=maybe", "excludeMasterNodes=true&nodeRole=master"} {
+		_, err := GetNodeLister(&url.URL{RawQuery: query})
+		assert.Error(t, err, query)
+	}
+}
+
+func TestNodeRoleSelector(t *testing.T) {
+	for query, expected := range map[string]string{
+		"":                         "",
+		"excludeMasterNodes=false": "",
+		"excludeMasterNodes=true":  "!node-role.kubernetes.io/master,kubernetes.io/role!=master",
+		"nodeRole=worker":          "!node-role.kubernetes.io/master,kubernetes.io/role!=master",
+		"nodeRole=master":          "node-role.kubernetes.io/master",
+		"nodeRole=ingress":         "node-role.kubernetes.io/ingress",
+	} {
+		opts, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		selector, err := nodeRoleSelector(opts)
+		require.NoError(t, err, query)
+		assert.Equal(t, expected, selector, query)
+		parsed, err := labels.Parse(selector)
+		require.NoError(t, err, query)
+		if query == "excludeMasterNodes=true" {
+			assert.True(t, parsed.Matches(labels.Set{"node-role.kubernetes.io/node": ""}))
+			assert.False(t, parsed.Matches(labels.Set{"node-role.kubernetes.io/master": ""}))
+			assert.False(t, parsed.Matches(labels.Set{"kubernetes.io/role": "master"}))
+		}
+	}
+}
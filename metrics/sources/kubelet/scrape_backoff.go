@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// Number of consecutive failures after which a node is backed off, so that a single
+	// failed scrape does not cause a gap.
+	backoffFailureThreshold = 2
+	// Maximum factor by which the backoff delays are randomly extended, so that nodes which
+	// failed together are not all retried in the same cycle.
+	backoffJitter         = 0.2
+	defaultBackoffInitial = time.Minute
+)
+
+var (
+	// Number of nodes which are not scraped because they failed repeatedly.
+	backedOffNodes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "kubelet",
+			Name:      "backed_off_nodes",
+			Help:      "Number of nodes which are not scraped because they failed repeatedly.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(backedOffNodes)
+}
+
+type nodeBackoff struct {
+	failures int
+	// The node is not scraped until then.
+	until time.Time
+}
+
+// ScrapeBackoff scrapes the nodes whose kubelet fails repeatedly less and less often, doubling
+// the delay between scrapes after each failure up to a maximum. A successful scrape resets the
+// backoff of the node. A nil backoff is valid and scrapes all nodes every cycle.
+type ScrapeBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	now     func() time.Time
+
+	lock  sync.Mutex
+	nodes map[string]*nodeBackoff
+}
+
+// NewScrapeBackoff creates a backoff based on the scrapeBackoffInitial and scrapeBackoffMax
+// source options. It returns nil if scrapeBackoffMax is not set or set to 0.
+func NewScrapeBackoff(uri *url.URL) (*ScrapeBackoff, error) {
+	opts := uri.Query()
+	if len(opts["scrapeBackoffMax"]) < 1 {
+		return nil, nil
+	}
+	max, err := time.ParseDuration(opts["scrapeBackoffMax"][0])
+	if err != nil {
+		return nil, err
+	}
+	if max == 0 {
+		return nil, nil
+	}
+	initial := defaultBackoffInitial
+	if len(opts["scrapeBackoffInitial"]) > 0 {
+		if initial, err = time.ParseDuration(opts["scrapeBackoffInitial"][0]); err != nil {
+			return nil, err
+		}
+	}
+	if initial <= 0 || max < initial {
+		return nil, fmt.Errorf("scrape backoff must satisfy 0 < scrapeBackoffInitial <= scrapeBackoffMax, got %s and %s", initial, max)
+	}
+	glog.Infof("Backing off nodes failing repeatedly for %s to %s", initial, max)
+	return newScrapeBackoff(initial, max, time.Now), nil
+}
+
+func newScrapeBackoff(initial, max time.Duration, now func() time.Time) *ScrapeBackoff {
+	return &ScrapeBackoff{
+		initial: initial,
+		max:     max,
+		now:     now,
+		nodes:   map[string]*nodeBackoff{},
+	}
+}
+
+// ShouldScrape returns false if the node is backed off.
+func (this *ScrapeBackoff) ShouldScrape(nodeName string) bool {
+	if this == nil {
+		return true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, found := this.nodes[nodeName]
+	if !found || !this.now().Before(node.until) {
+		return true
+	}
+	glog.V(2).Infof("Not scraping node %s after %d consecutive failures until %s", nodeName, node.failures, node.until)
+	return false
+}
+
+// Observe records the result of a single scrape of the given node.
+func (this *ScrapeBackoff) Observe(nodeName string, scrapeErr error) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	defer this.updateBackedOffNodes()
+
+	if scrapeErr == nil {
+		if node, found := this.nodes[nodeName]; found && node.failures >= backoffFailureThreshold {
+			glog.Infof("Node %s recovered after %d consecutive failures", nodeName, node.failures)
+		}
+		delete(this.nodes, nodeName)
+		return
+	}
+	node, found := this.nodes[nodeName]
+	if !found {
+		node = &nodeBackoff{}
+		this.nodes[nodeName] = node
+	}
+	node.failures++
+	if node.failures < backoffFailureThreshold {
+		return
+	}
+	delay := this.max
+	if shift := uint(node.failures - backoffFailureThreshold); shift < 32 && this.initial<<shift < this.max {
+		delay = this.initial << shift
+	}
+	node.until = this.now().Add(wait.Jitter(delay, backoffJitter))
+}
+
+// Retain drops the state of the nodes which are not in the list anymore.
+func (this *ScrapeBackoff) Retain(nodes []*kube_api.Node) {
+	if this == nil {
+		return
+	}
+	listed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		listed[node.Name] = true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for nodeName := range this.nodes {
+		if !listed[nodeName] {
+			delete(this.nodes, nodeName)
+		}
+	}
+	this.updateBackedOffNodes()
+}
+
+func (this *ScrapeBackoff) updateBackedOffNodes() {
+	count := 0
+	for _, node := range this.nodes {
+		if node.failures >= backoffFailureThreshold {
+			count++
+		}
+	}
+	backedOffNodes.Set(float64(count))
+}
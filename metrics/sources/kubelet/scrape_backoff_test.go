@@ -0,0 +1,74 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScrapeBackoff(t *testing.T) {
+	now := time.Now()
+	backoff := newScrapeBackoff(time.Minute, 3*time.Minute, func() time.Time { return now })
+	scrapeErr := errors.New("connection refused")
+
+	// A single failure is retried in the next cycle.
+	backoff.Observe("node1", scrapeErr)
+	assert.True(t, backoff.ShouldScrape("node1"))
+
+	// The delays double up to the maximum, extended by the jitter.
+	for _, delay := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		backoff.Observe("node1", scrapeErr)
+		now = now.Add(delay - time.Second)
+		assert.False(t, backoff.ShouldScrape("node1"), "%s", delay)
+		assert.True(t, backoff.ShouldScrape("node2"))
+		now = now.Add(time.Duration(float64(delay)*backoffJitter) + time.Second)
+		assert.True(t, backoff.ShouldScrape("node1"), "%s", delay)
+	}
+
+	// The node recovers.
+	backoff.Observe("node1", nil)
+	backoff.Observe("node1", scrapeErr)
+	assert.True(t, backoff.ShouldScrape("node1"))
+
+	backoff.Observe("node1", scrapeErr)
+	assert.False(t, backoff.ShouldScrape("node1"))
+	backoff.Retain([]*kube_api.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}})
+	assert.True(t, backoff.ShouldScrape("node1"))
+}
+
+func TestScrapeBackoffDisabled(t *testing.T) {
+	var backoff *ScrapeBackoff
+	// Must not panic.
+	backoff.Observe("node1", errors.New("connection refused"))
+	assert.True(t, backoff.ShouldScrape("node1"))
+
+	uri, err := url.Parse("https://kubernetes.default")
+	assert.NoError(t, err)
+	backoff, err = NewScrapeBackoff(uri)
+	assert.NoError(t, err)
+	assert.Nil(t, backoff)
+
+	uri, err = url.Parse("https://kubernetes.default?scrapeBackoffMax=5m&scrapeBackoffInitial=10m")
+	assert.NoError(t, err)
+	_, err = NewScrapeBackoff(uri)
+	assert.Error(t, err)
+}
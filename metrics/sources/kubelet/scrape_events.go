@@ -0,0 +1,131 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kube_client "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Reason set on the events emitted for nodes that cannot be scraped.
+	ScrapeFailedEventReason = "FailedToScrapeKubelet"
+
+	scrapeFailedEventComponent = "heapster"
+)
+
+// ScrapeFailureNotifier counts consecutive scrape failures per node and emits
+// a Kubernetes Event on the Node object once the configured threshold is reached.
+// A nil notifier is valid and does nothing.
+type ScrapeFailureNotifier struct {
+	threshold   int
+	createEvent func(event *kube_api.Event) error
+
+	lock     sync.Mutex
+	failures map[string]int
+}
+
+// NewScrapeFailureNotifier creates a notifier based on the scrapeFailureEventThreshold
+// source option. It returns nil if the option is not set or set to 0.
+func NewScrapeFailureNotifier(uri *url.URL, kubeClient kube_client.Interface) (*ScrapeFailureNotifier, error) {
+	opts := uri.Query()
+	if len(opts["scrapeFailureEventThreshold"]) < 1 {
+		return nil, nil
+	}
+	threshold, err := strconv.Atoi(opts["scrapeFailureEventThreshold"][0])
+	if err != nil {
+		return nil, err
+	}
+	if threshold < 0 {
+		return nil, fmt.Errorf("scrapeFailureEventThreshold must not be negative: %d", threshold)
+	}
+	if threshold == 0 {
+		return nil, nil
+	}
+	glog.Infof("Emitting node events after %d consecutive scrape failures", threshold)
+
+	events := kubeClient.CoreV1().Events(metav1.NamespaceDefault)
+	return newScrapeFailureNotifier(threshold, func(event *kube_api.Event) error {
+		_, err := events.Create(event)
+		return err
+	}), nil
+}
+
+func newScrapeFailureNotifier(threshold int, createEvent func(event *kube_api.Event) error) *ScrapeFailureNotifier {
+	return &ScrapeFailureNotifier{
+		threshold:   threshold,
+		createEvent: createEvent,
+		failures:    map[string]int{},
+	}
+}
+
+// Observe records the result of a single scrape of the given node.
+func (this *ScrapeFailureNotifier) Observe(nodeName string, scrapeErr error) {
+	if this == nil {
+		return
+	}
+
+	this.lock.Lock()
+	if scrapeErr == nil {
+		delete(this.failures, nodeName)
+		this.lock.Unlock()
+		return
+	}
+	this.failures[nodeName]++
+	count := this.failures[nodeName]
+	this.lock.Unlock()
+
+	// Only emit once per streak of failures, the event is repeated when the
+	// node recovers and starts failing again.
+	if count != this.threshold {
+		return
+	}
+	event := newScrapeFailedEvent(nodeName, count, scrapeErr)
+	if err := this.createEvent(event); err != nil {
+		glog.Errorf("Failed to create scrape failure event for node %s: %v", nodeName, err)
+	}
+}
+
+func newScrapeFailedEvent(nodeName string, failures int, scrapeErr error) *kube_api.Event {
+	now := metav1.NewTime(time.Now())
+	return &kube_api.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", nodeName, now.UnixNano()),
+			Namespace: metav1.NamespaceDefault,
+		},
+		InvolvedObject: kube_api.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			// Kubelet uses the node name as the UID of node references.
+			UID: types.UID(nodeName),
+		},
+		Reason:         ScrapeFailedEventReason,
+		Message:        fmt.Sprintf("Failed to scrape kubelet for %d consecutive cycles: %v", failures, scrapeErr),
+		Source:         kube_api.EventSource{Component: scrapeFailedEventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           kube_api.EventTypeWarning,
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubelet
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	kube_api "k8s.io/api/core/v1"
+)
+
+func TestScrapeFailureNotifier(t *testing.T) {
+	events := []*kube_api.Event{}
+	notifier := newScrapeFailureNotifier(3, func(event *kube_api.Event) error {
+		events = append(events, event)
+		return nil
+	})
+	scrapeErr := errors.New("connection refused")
+
+	notifier.Observe("node1", scrapeErr)
+	notifier.Observe("node1", scrapeErr)
+	notifier.Observe("node2", scrapeErr)
+	assert.Empty(t, events)
+
+	notifier.Observe("node1", scrapeErr)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "node1", events[0].InvolvedObject.Name)
+	assert.Equal(t, "Node", events[0].InvolvedObject.Kind)
+	assert.Equal(t, ScrapeFailedEventReason, events[0].Reason)
+	assert.Equal(t, kube_api.EventTypeWarning, events[0].Type)
+
+	// No more events until the node recovers.
+	notifier.Observe("node1", scrapeErr)
+	assert.Len(t, events, 1)
+
+	notifier.Observe("node1", nil)
+	for i := 0; i < 3; i++ {
+		notifier.Observe("node1", scrapeErr)
+	}
+	assert.Len(t, events, 2)
+}
+
+func TestScrapeFailureNotifierDisabled(t *testing.T) {
+	var notifier *ScrapeFailureNotifier
+	// Must not panic.
+	notifier.Observe("node1", errors.New("connection refused"))
+
+	uri, err := url.Parse("https://kubernetes.default?scrapeFailureEventThreshold=0")
+	assert.NoError(t, err)
+	notifier, err = NewScrapeFailureNotifier(uri, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, notifier)
+
+	uri, err = url.Parse("https://kubernetes.default?scrapeFailureEventThreshold=-1")
+	assert.NoError(t, err)
+	_, err = NewScrapeFailureNotifier(uri, nil)
+	assert.Error(t, err)
+}
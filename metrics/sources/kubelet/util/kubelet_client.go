@@ -15,6 +15,7 @@
 package client
 
 import (
+	"net"
 	"net/http"
 	"time"
 
@@ -37,27 +38,57 @@ type KubeletClientConfig struct {
 
 	// Server requires Bearer authentication
 	BearerToken string
+	// BearerTokenFile is the file BearerToken was read from, if any, e.g. the token of the
+	// service account. It is read again when it changes, as is CAFile, so that rotated tokens
+	// and CA bundles are used without a restart.
+	BearerTokenFile string
 
 	// HTTPTimeout is used by the client to timeout http requests to Kubelet.
 	HTTPTimeout time.Duration
 
 	// Dial is a custom dialer used for the client
 	Dial utilnet.DialFunc
+
+	// MaxIdleConns is the size of the pool of idle connections to all kubelets.
+	// If zero, defaultMaxIdleConns is used.
+	MaxIdleConns int
 }
 
+const (
+	// Idle connections are closed after this time, so that connections pooled by
+	// replaced transports do not leak.
+	idleConnTimeout = 90 * time.Second
+	// Connections kept open to each kubelet between scrapes. The summary and the stats of a
+	// node may be scraped at the same time.
+	maxIdleConnsPerHost = 2
+	defaultMaxIdleConns = 100
+	dialTimeout         = 30 * time.Second
+	keepAlivePeriod     = 30 * time.Second
+)
+
 func MakeTransport(config *KubeletClientConfig) (http.RoundTripper, error) {
 	tlsConfig, err := transport.TLSConfigFor(config.transportConfig())
 	if err != nil {
 		return nil, err
 	}
 
-	rt := http.DefaultTransport
-	if config.Dial != nil || tlsConfig != nil {
-		rt = utilnet.SetOldTransportDefaults(&http.Transport{
-			Dial:            config.Dial,
-			TLSClientConfig: tlsConfig,
-		})
+	// The transport is dedicated to kubelets, so that their connections are kept alive and
+	// reused by the following scrapes instead of competing with other clients for the pool.
+	maxIdleConns := config.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	dial := config.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlivePeriod}).Dial
 	}
+	rt := utilnet.SetOldTransportDefaults(&http.Transport{
+		Dial:                dial,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+	})
 
 	return transport.HTTPWrappersForConfig(config.transportConfig(), rt)
 }
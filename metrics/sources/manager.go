@@ -15,7 +15,11 @@
 package sources
 
 import (
+	"fmt"
+	"hash/fnv"
 	"math/rand"
+	"sort"
+	"sync"
 	"time"
 
 	. "k8s.io/heapster/metrics/core"
@@ -52,23 +56,133 @@ var (
 		},
 		[]string{"source"},
 	)
+
+	// Number of failed scrapes by source, node and reason.
+	scrapeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "heapster",
+			Subsystem: "scraper",
+			Name:      "errors_total",
+			Help:      "Number of failed scrapes by source, node and reason (timeout, decode or request).",
+		},
+		[]string{"source", "node", "reason"},
+	)
+
+	// Number of sources waiting for a scrape slot when the scrape concurrency is bounded.
+	scrapesWaiting = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "heapster",
+			Subsystem: "scraper",
+			Name:      "waiting_sources",
+			Help:      "Number of sources waiting for a scrape slot when the scrape concurrency is bounded.",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(lastScrapeTimestamp)
 	prometheus.MustRegister(scraperDuration)
+	prometheus.MustRegister(scrapesWaiting)
+	prometheus.MustRegister(scrapeErrors)
 }
 
-func NewSourceManager(metricsSourceProvider MetricsSourceProvider, metricsScrapeTimeout time.Duration) (MetricsSource, error) {
-	return &sourceManager{
+// Reasons of failed scrapes.
+const (
+	// The source did not respond within the scrape timeout.
+	ScrapeErrorTimeout = "timeout"
+	// The response of the source could not be decoded.
+	ScrapeErrorDecode = "decode"
+	// Any other failure, e.g. the source could not be reached or answered an error.
+	ScrapeErrorRequest = "request"
+)
+
+var scrapeErrorReasons = []string{ScrapeErrorTimeout, ScrapeErrorDecode, ScrapeErrorRequest}
+
+// SourceStatus is the outcome of the latest scrape of a source.
+type SourceStatus struct {
+	Source string
+	// Node and kubelet version of sources scraping a single kubelet, empty otherwise.
+	NodeName       string
+	KubeletVersion string
+	LastScrapeTime time.Time
+	ScrapeDuration time.Duration
+	// Number of metric sets returned by the scrape.
+	MetricSets int
+	// Empty if the scrape succeeded.
+	Error string
+
+	// Start of the latest successful scrape, zero if none succeeded yet.
+	LastSuccessTime time.Time
+	// Error and start of the latest failed scrape, even if later scrapes succeeded.
+	LastError     string
+	LastErrorTime time.Time
+	// Number of scrapes which failed since the latest successful one.
+	ConsecutiveFailures int
+	// Number of failed scrapes since Heapster started, by reason.
+	Errors map[string]int64
+
+	// Set if the status reports a scrape which did not finish within the timeout, and which
+	// will be reported again once it finishes.
+	pending bool
+	// Reason of the failure if the scrape failed.
+	reason string
+}
+
+// NodeMetricsSource is implemented by sources scraping the kubelet of a single node.
+type NodeMetricsSource interface {
+	MetricsSource
+	NodeName() string
+	KubeletVersion() string
+}
+
+// SourceManager scrapes all sources of a provider and keeps the outcome of their latest scrapes.
+type SourceManager interface {
+	MetricsSource
+	// GetSourceStatuses returns the status of all current sources, ordered by name.
+	GetSourceStatuses() []SourceStatus
+}
+
+// NewSourceManager creates a manager scraping at most concurrency sources at the same time,
+// or all of them at once if concurrency is 0. If jitterWindow is set, the scrape of each source
+// starts at a fixed offset within the window after the start of the cycle, derived from its name,
+// so that the time between two scrapes of a source stays the same. Metric sets reported under the
+// same key by several sources are merged, with sources earlier in the list of the provider taking
+// precedence for labels and metrics reported by more than one of them.
+func NewSourceManager(metricsSourceProvider MetricsSourceProvider, metricsScrapeTimeout time.Duration, concurrency int,
+	jitterWindow time.Duration) (SourceManager, error) {
+	if concurrency < 0 {
+		return nil, fmt.Errorf("source concurrency must not be negative, got %d", concurrency)
+	}
+	if jitterWindow < 0 {
+		return nil, fmt.Errorf("scrape jitter window must not be negative, got %s", jitterWindow)
+	}
+	manager := &sourceManager{
 		metricsSourceProvider: metricsSourceProvider,
 		metricsScrapeTimeout:  metricsScrapeTimeout,
-	}, nil
+		jitterWindow:          jitterWindow,
+		statuses:              map[string]SourceStatus{},
+		startTime:             time.Now(),
+	}
+	if concurrency > 0 {
+		manager.scrapeSlots = make(chan struct{}, concurrency)
+	}
+	return manager, nil
 }
 
 type sourceManager struct {
 	metricsSourceProvider MetricsSourceProvider
 	metricsScrapeTimeout  time.Duration
+	// Window over which the scrapes are spread, 0 for a short random delay growing with the
+	// number of sources.
+	jitterWindow time.Duration
+	// Bounds the number of sources scraped at the same time, nil if unbounded.
+	scrapeSlots chan struct{}
+
+	statusLock sync.Mutex
+	// Status of the current sources by name.
+	statuses map[string]SourceStatus
+	// Start of the error counts.
+	startTime time.Time
 }
 
 func (this *sourceManager) Name() string {
@@ -76,71 +190,109 @@ func (this *sourceManager) Name() string {
 }
 
 func (this *sourceManager) ScrapeMetrics(start, end time.Time) (*DataBatch, error) {
-	glog.V(1).Infof("Scraping metrics start: %s, end: %s", start, end)
 	sources := this.metricsSourceProvider.GetMetricsSources()
+	this.forgetRemovedSources(sources)
+	return this.scrape(start, end, sources, nil)
+}
 
-	responseChannel := make(chan *DataBatch)
+// ScrapeNodeMetrics scrapes the kubelets of the given nodes only, without jitter. The other
+// sources, e.g. those not related to a node, are not scraped.
+func (this *sourceManager) ScrapeNodeMetrics(start, end time.Time, nodes map[string]bool) (*DataBatch, error) {
+	var sources []MetricsSource
+	for _, source := range this.metricsSourceProvider.GetMetricsSources() {
+		if nodeSource, ok := source.(NodeMetricsSource); ok && nodes[nodeSource.NodeName()] {
+			sources = append(sources, source)
+		}
+	}
+	return this.scrape(start, end, sources, nodes)
+}
+
+// scrape scrapes the sources, of the given nodes only if nodes is set.
+func (this *sourceManager) scrape(start, end time.Time, sources []MetricsSource, nodes map[string]bool) (*DataBatch, error) {
+	batchID := NewBatchID(end)
+	glog.V(1).Infof("[batch %s] Scraping metrics start: %s, end: %s", batchID, start, end)
+	jitterWindow := this.jitterWindow
+	if nodes != nil {
+		jitterWindow = 0
+	}
+
+	responseChannel := make(chan sourceResponse)
 	startTime := time.Now()
-	timeoutTime := startTime.Add(this.metricsScrapeTimeout)
+	// Sources scraped at the end of the jitter window get the whole scrape timeout.
+	timeoutTime := startTime.Add(jitterWindow + this.metricsScrapeTimeout)
 
 	delayMs := DelayPerSourceMs * len(sources)
 	if delayMs > MaxDelayMs {
 		delayMs = MaxDelayMs
 	}
 
-	for _, source := range sources {
+	for i, source := range sources {
 
-		go func(source MetricsSource, channel chan *DataBatch, start, end, timeoutTime time.Time, delayInMs int) {
+		go func(index int, source MetricsSource, channel chan sourceResponse, start, end, timeoutTime time.Time, delayInMs int) {
 
 			// Prevents network congestion.
-			time.Sleep(time.Duration(rand.Intn(delayMs)) * time.Millisecond)
+			if jitterWindow > 0 {
+				time.Sleep(jitterDelay(source.Name(), jitterWindow))
+			} else {
+				time.Sleep(time.Duration(rand.Intn(delayMs)) * time.Millisecond)
+			}
+
+			if !this.acquireScrapeSlot(timeoutTime) {
+				glog.Warningf("[batch %s] No scrape slot available for %s within the scrape timeout", batchID, source)
+				return
+			}
+			defer this.releaseScrapeSlot()
 
-			glog.V(2).Infof("Querying source: %s", source)
+			glog.V(2).Infof("[batch %s] Querying source: %s", batchID, source)
+			scrapeStart := time.Now()
 			metrics, err := scrape(source, start, end)
+			now := time.Now()
 			if err != nil {
-				glog.Errorf("Error in scraping containers from %s: %v", source.Name(), err)
+				this.recordStatus(source, scrapeStart, now.Sub(scrapeStart), nil, err)
+				glog.Errorf("[batch %s] Error in scraping containers from %s: %v", batchID, source.Name(), err)
 				return
 			}
 
-			now := time.Now()
 			if !now.Before(timeoutTime) {
-				glog.Warningf("Failed to get %s response in time", source)
+				this.recordStatus(source, scrapeStart, now.Sub(scrapeStart), metrics, this.timeoutError())
+				glog.Warningf("[batch %s] Failed to get %s response in time", batchID, source)
 				return
 			}
+			this.recordStatus(source, scrapeStart, now.Sub(scrapeStart), metrics, nil)
 			timeForResponse := timeoutTime.Sub(now)
 
 			select {
-			case channel <- metrics:
+			case channel <- sourceResponse{index: index, batch: metrics}:
 				// passed the response correctly.
 				return
 			case <-time.After(timeForResponse):
-				glog.Warningf("Failed to send the response back %s", source)
+				glog.Warningf("[batch %s] Failed to send the response back %s", batchID, source)
 				return
 			}
-		}(source, responseChannel, start, end, timeoutTime, delayMs)
+		}(i, source, responseChannel, start, end, timeoutTime, delayMs)
 	}
 	response := DataBatch{
 		Timestamp:  end,
+		ID:         batchID,
 		MetricSets: map[string]*MetricSet{},
 	}
 
 	latencies := make([]int, 11)
+	// Batches in the order of the sources, so that merging them does not depend on which of the
+	// sources responded first.
+	batches := make([]*DataBatch, len(sources))
 
 responseloop:
 	for i := range sources {
 		now := time.Now()
 		if !now.Before(timeoutTime) {
-			glog.Warningf("Failed to get all responses in time (got %d/%d)", i, len(sources))
+			glog.Warningf("[batch %s] Failed to get all responses in time (got %d/%d)", batchID, i, len(sources))
 			break
 		}
 
 		select {
-		case dataBatch := <-responseChannel:
-			if dataBatch != nil {
-				for key, value := range dataBatch.MetricSets {
-					response.MetricSets[key] = value
-				}
-			}
+		case sourceResponse := <-responseChannel:
+			batches[sourceResponse.index] = sourceResponse.batch
 			latency := now.Sub(startTime)
 			bucket := int(latency.Seconds())
 			if bucket >= len(latencies) {
@@ -149,18 +301,77 @@ responseloop:
 			latencies[bucket]++
 
 		case <-time.After(timeoutTime.Sub(now)):
-			glog.Warningf("Failed to get all responses in time (got %d/%d)", i, len(sources))
+			glog.Warningf("[batch %s] Failed to get all responses in time (got %d/%d)", batchID, i, len(sources))
 			break responseloop
 		}
 	}
 
-	glog.V(1).Infof("ScrapeMetrics: time: %s size: %d", time.Since(startTime), len(response.MetricSets))
+	for _, batch := range batches {
+		if batch == nil {
+			continue
+		}
+		for key, metricSet := range batch.MetricSets {
+			if existing, found := response.MetricSets[key]; found {
+				glog.V(4).Infof("[batch %s] Merging metric set %s reported by several sources", batchID, key)
+				mergeMetricSet(existing, metricSet)
+			} else {
+				response.MetricSets[key] = metricSet
+			}
+		}
+	}
+
+	this.recordTimeouts(sources, startTime)
+	this.addScrapeErrorMetrics(&response, nodes)
+
+	glog.V(1).Infof("[batch %s] ScrapeMetrics: time: %s size: %d", batchID, time.Since(startTime), len(response.MetricSets))
 	for i, value := range latencies {
 		glog.V(1).Infof("   scrape  bucket %d: %d", i, value)
 	}
 	return &response, nil
 }
 
+// sourceResponse is the batch scraped from the source at index in the list of the provider.
+type sourceResponse struct {
+	index int
+	batch *DataBatch
+}
+
+// jitterDelay returns the offset within the window at which the source is scraped. It is the
+// same in every cycle, so that the sources are spread evenly over the window while each of them
+// is still scraped once per resolution.
+func jitterDelay(sourceName string, window time.Duration) time.Duration {
+	hash := fnv.New64a()
+	hash.Write([]byte(sourceName))
+	return time.Duration(hash.Sum64() % uint64(window))
+}
+
+// acquireScrapeSlot waits until fewer than the configured number of sources are being scraped.
+// It returns false if no slot was freed before the deadline.
+func (this *sourceManager) acquireScrapeSlot(deadline time.Time) bool {
+	if this.scrapeSlots == nil {
+		return true
+	}
+	select {
+	case this.scrapeSlots <- struct{}{}:
+		return true
+	default:
+	}
+	scrapesWaiting.Inc()
+	defer scrapesWaiting.Dec()
+	select {
+	case this.scrapeSlots <- struct{}{}:
+		return true
+	case <-time.After(deadline.Sub(time.Now())):
+		return false
+	}
+}
+
+func (this *sourceManager) releaseScrapeSlot() {
+	if this.scrapeSlots != nil {
+		<-this.scrapeSlots
+	}
+}
+
 func scrape(s MetricsSource, start, end time.Time) (*DataBatch, error) {
 	sourceName := s.Name()
 	startTime := time.Now()
@@ -175,3 +386,166 @@ func scrape(s MetricsSource, start, end time.Time) (*DataBatch, error) {
 
 	return s.ScrapeMetrics(start, end)
 }
+
+func (this *sourceManager) recordStatus(source MetricsSource, scrapeStart time.Time, duration time.Duration,
+	metrics *DataBatch, err error) {
+	this.storeStatus(newSourceStatus(source, scrapeStart, duration, metrics, err))
+}
+
+func newSourceStatus(source MetricsSource, scrapeStart time.Time, duration time.Duration,
+	metrics *DataBatch, err error) SourceStatus {
+	status := SourceStatus{
+		Source:         source.Name(),
+		LastScrapeTime: scrapeStart,
+		ScrapeDuration: duration,
+	}
+	if nodeSource, ok := source.(NodeMetricsSource); ok {
+		status.NodeName = nodeSource.NodeName()
+		status.KubeletVersion = nodeSource.KubeletVersion()
+	}
+	if metrics != nil {
+		status.MetricSets = len(metrics.MetricSets)
+	}
+	if err != nil {
+		status.Error = err.Error()
+		status.reason = ScrapeErrorRequest
+		if _, ok := err.(*DecodeError); ok {
+			status.reason = ScrapeErrorDecode
+		}
+	}
+	return status
+}
+
+// storeStatus replaces the status of the source, carrying over the outcome of its earlier scrapes.
+func (this *sourceManager) storeStatus(status SourceStatus) {
+	this.statusLock.Lock()
+	defer this.statusLock.Unlock()
+	status.Errors = map[string]int64{}
+	// Set if the scrape reported as timed out finished late.
+	lateScrape := false
+	if previous, found := this.statuses[status.Source]; found {
+		status.LastSuccessTime = previous.LastSuccessTime
+		status.LastError = previous.LastError
+		status.LastErrorTime = previous.LastErrorTime
+		status.ConsecutiveFailures = previous.ConsecutiveFailures
+		for reason, count := range previous.Errors {
+			status.Errors[reason] = count
+		}
+		// The scrapes of a cycle start within the scrape timeout. If the scrape reported as timed
+		// out finished, it is counted once.
+		if previous.pending && !status.pending &&
+			status.LastScrapeTime.Before(previous.LastScrapeTime.Add(this.metricsScrapeTimeout)) {
+			status.ConsecutiveFailures--
+			lateScrape = true
+		}
+	}
+	if status.Error != "" {
+		status.LastError = status.Error
+		status.LastErrorTime = status.LastScrapeTime
+		status.ConsecutiveFailures++
+		if !lateScrape {
+			status.Errors[status.reason]++
+			scrapeErrors.WithLabelValues(status.Source, status.NodeName, status.reason).Inc()
+		}
+	} else {
+		status.LastSuccessTime = status.LastScrapeTime
+		status.ConsecutiveFailures = 0
+	}
+	this.statuses[status.Source] = status
+}
+
+// recordTimeouts marks the sources which did not finish their scrape started at startTime
+// as timed out. They are updated once their scrape finishes.
+func (this *sourceManager) recordTimeouts(sources []MetricsSource, startTime time.Time) {
+	for _, source := range sources {
+		this.statusLock.Lock()
+		status, found := this.statuses[source.Name()]
+		this.statusLock.Unlock()
+		if found && !status.LastScrapeTime.Before(startTime) {
+			continue
+		}
+		status = newSourceStatus(source, startTime, time.Since(startTime), nil, this.timeoutError())
+		status.pending = true
+		status.reason = ScrapeErrorTimeout
+		this.storeStatus(status)
+	}
+}
+
+func (this *sourceManager) timeoutError() error {
+	return fmt.Errorf("no response within the scrape timeout of %s", this.metricsScrapeTimeout)
+}
+
+// forgetRemovedSources drops the status of sources which are no longer provided, e.g. of
+// deleted nodes.
+func (this *sourceManager) forgetRemovedSources(sources []MetricsSource) {
+	current := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		current[source.Name()] = true
+	}
+
+	this.statusLock.Lock()
+	defer this.statusLock.Unlock()
+	for name, status := range this.statuses {
+		if !current[name] {
+			delete(this.statuses, name)
+			for _, reason := range scrapeErrorReasons {
+				scrapeErrors.DeleteLabelValues(name, status.NodeName, reason)
+			}
+		}
+	}
+}
+
+// addScrapeErrorMetrics adds the number of failed scrapes of the kubelets to the metric sets of
+// their nodes, also for nodes which could not be scraped, so that nodes which stop reporting
+// can be alerted on. If nodes is set, only the nodes in it get the metric.
+func (this *sourceManager) addScrapeErrorMetrics(batch *DataBatch, nodes map[string]bool) {
+	for _, status := range this.GetSourceStatuses() {
+		if status.NodeName == "" || (nodes != nil && !nodes[status.NodeName]) {
+			continue
+		}
+		key := NodeKey(status.NodeName)
+		metricSet, found := batch.MetricSets[key]
+		if !found {
+			metricSet = &MetricSet{
+				MetricValues: map[string]MetricValue{},
+				Labels: map[string]string{
+					LabelMetricSetType.Key: MetricSetTypeNode,
+					LabelNodename.Key:      status.NodeName,
+					LabelHostname.Key:      status.NodeName,
+				},
+				ScrapeTime:          batch.Timestamp,
+				CollectionStartTime: this.startTime,
+			}
+			batch.MetricSets[key] = metricSet
+		}
+		for _, reason := range scrapeErrorReasons {
+			metricSet.LabeledMetrics = append(metricSet.LabeledMetrics, LabeledMetric{
+				Name:   MetricScrapeErrors.Name,
+				Labels: map[string]string{LabelScrapeErrorReason.Key: reason},
+				MetricValue: MetricValue{
+					ValueType:  ValueInt64,
+					MetricType: MetricCumulative,
+					IntValue:   status.Errors[reason],
+				},
+			})
+		}
+	}
+}
+
+func (this *sourceManager) GetSourceStatuses() []SourceStatus {
+	this.statusLock.Lock()
+	defer this.statusLock.Unlock()
+
+	result := make([]SourceStatus, 0, len(this.statuses))
+	for _, status := range this.statuses {
+		result = append(result, status)
+	}
+	sort.Sort(byName(result))
+	return result
+}
+
+type byName []SourceStatus
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Source < s[j].Source }
@@ -15,9 +15,13 @@
 package sources
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"k8s.io/heapster/metrics/core"
 	"k8s.io/heapster/metrics/util"
 )
 
@@ -26,7 +30,7 @@ func TestAllSourcesReplyInTime(t *testing.T) {
 		util.NewDummyMetricsSource("s1", time.Second),
 		util.NewDummyMetricsSource("s2", time.Second))
 
-	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3)
+	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3, 0, 0)
 	now := time.Now()
 	end := now.Truncate(10 * time.Second)
 	dataBatch, err := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
@@ -51,6 +55,10 @@ func TestAllSourcesReplyInTime(t *testing.T) {
 	if _, ok := present["s2"]; !ok {
 		t.Fatal("s2 not found")
 	}
+
+	if dataBatch.ID == "" {
+		t.Fatal("batch ID not set")
+	}
 }
 
 func TestOneSourcesReplyInTime(t *testing.T) {
@@ -58,7 +66,7 @@ func TestOneSourcesReplyInTime(t *testing.T) {
 		util.NewDummyMetricsSource("s1", time.Second),
 		util.NewDummyMetricsSource("s2", 30*time.Second))
 
-	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3)
+	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3, 0, 0)
 	now := time.Now()
 	end := now.Truncate(10 * time.Second)
 	dataBatch, err := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
@@ -94,7 +102,7 @@ func TestNoSourcesReplyInTime(t *testing.T) {
 		util.NewDummyMetricsSource("s1", 30*time.Second),
 		util.NewDummyMetricsSource("s2", 30*time.Second))
 
-	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3)
+	manager, _ := NewSourceManager(metricsSourceProvider, time.Second*3, 0, 0)
 	now := time.Now()
 	end := now.Truncate(10 * time.Second)
 	dataBatch, err := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
@@ -124,3 +132,350 @@ func TestNoSourcesReplyInTime(t *testing.T) {
 		t.Fatal("s2 found")
 	}
 }
+
+type fakeNodeSource struct {
+	name    string
+	latency time.Duration
+	err     error
+}
+
+func (f *fakeNodeSource) Name() string           { return f.name }
+func (f *fakeNodeSource) NodeName() string       { return "node-" + f.name }
+func (f *fakeNodeSource) KubeletVersion() string { return "v1.8.0" }
+func (f *fakeNodeSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	time.Sleep(f.latency)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &core.DataBatch{
+		Timestamp:  end,
+		MetricSets: map[string]*core.MetricSet{core.NodeKey(f.NodeName()): {}},
+	}, nil
+}
+
+type fakeSourceProvider struct {
+	sources []core.MetricsSource
+}
+
+func (p *fakeSourceProvider) GetMetricsSources() []core.MetricsSource {
+	return p.sources
+}
+
+func TestSourceStatuses(t *testing.T) {
+	provider := &fakeSourceProvider{sources: []core.MetricsSource{
+		&fakeNodeSource{name: "s1"},
+		&fakeNodeSource{name: "s2", err: errors.New("connection refused")},
+		&fakeNodeSource{name: "s3", latency: 10 * time.Second},
+	}}
+	manager, _ := NewSourceManager(provider, time.Second, 0, 0)
+	end := time.Now()
+	manager.ScrapeMetrics(end.Add(-10*time.Second), end)
+
+	statuses := manager.GetSourceStatuses()
+	if len(statuses) != 3 {
+		t.Fatalf("Expected 3 statuses, got %+v", statuses)
+	}
+	for i, expected := range []struct{ source, err string }{
+		{"s1", ""},
+		{"s2", "connection refused"},
+		{"s3", "no response within the scrape timeout of 1s"},
+	} {
+		status := statuses[i]
+		if status.Source != expected.source || status.Error != expected.err {
+			t.Errorf("Unexpected status %+v, expected source %s with error %q", status, expected.source, expected.err)
+		}
+		if status.NodeName != "node-"+expected.source || status.KubeletVersion != "v1.8.0" {
+			t.Errorf("Missing node of %+v", status)
+		}
+	}
+	if statuses[0].MetricSets != 1 {
+		t.Errorf("Expected 1 metric set from s1, got %d", statuses[0].MetricSets)
+	}
+
+	if statuses[0].LastSuccessTime.IsZero() || statuses[0].ConsecutiveFailures != 0 {
+		t.Errorf("Expected a successful scrape of s1, got %+v", statuses[0])
+	}
+	if statuses[1].LastError != "connection refused" || statuses[1].ConsecutiveFailures != 1 || !statuses[1].LastSuccessTime.IsZero() {
+		t.Errorf("Expected a failed scrape of s2, got %+v", statuses[1])
+	}
+
+	provider.sources = provider.sources[:1]
+	manager.ScrapeMetrics(end, end.Add(10*time.Second))
+	if statuses = manager.GetSourceStatuses(); len(statuses) != 1 || statuses[0].Source != "s1" {
+		t.Errorf("Expected only the status of s1, got %+v", statuses)
+	}
+}
+
+// countingSource records the highest number of sources scraped at the same time.
+type countingSource struct {
+	fakeNodeSource
+	lock       *sync.Mutex
+	running    *int
+	maxRunning *int
+}
+
+func (c *countingSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	c.lock.Lock()
+	*c.running++
+	if *c.running > *c.maxRunning {
+		*c.maxRunning = *c.running
+	}
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		*c.running--
+		c.lock.Unlock()
+	}()
+	return c.fakeNodeSource.ScrapeMetrics(start, end)
+}
+
+func TestSourceConcurrency(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	provider := &fakeSourceProvider{}
+	for _, name := range []string{"s1", "s2", "s3", "s4", "s5", "s6"} {
+		provider.sources = append(provider.sources, &countingSource{
+			fakeNodeSource: fakeNodeSource{name: name, latency: 500 * time.Millisecond},
+			lock:           &lock,
+			running:        &running,
+			maxRunning:     &maxRunning,
+		})
+	}
+
+	manager, err := NewSourceManager(provider, 3*time.Second, 2, 0)
+	if err != nil {
+		t.Fatalf("NewSourceManager error. %v", err)
+	}
+	end := time.Now()
+	dataBatch, _ := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
+	if len(dataBatch.MetricSets) != 6 {
+		t.Errorf("Expected the metrics of all 6 sources, got %d", len(dataBatch.MetricSets))
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expected 2 sources scraped at the same time, got %d", maxRunning)
+	}
+
+	// Sources still waiting for a slot at the scrape timeout are not scraped.
+	manager, _ = NewSourceManager(provider, time.Second, 1, 0)
+	manager.ScrapeMetrics(end, end.Add(10*time.Second))
+	timeouts := 0
+	for _, status := range manager.GetSourceStatuses() {
+		timeouts += int(status.Errors[ScrapeErrorTimeout])
+	}
+	if timeouts == 0 {
+		t.Error("Expected some sources to time out")
+	}
+
+	if _, err := NewSourceManager(provider, time.Second, -1, 0); err == nil {
+		t.Error("Expected an error for a negative concurrency")
+	}
+}
+
+func TestScrapeJitter(t *testing.T) {
+	window := 10 * time.Second
+	if jitterDelay("kubelet:node-1", window) != jitterDelay("kubelet:node-1", window) {
+		t.Error("Expected the same delay for a source in every cycle")
+	}
+	var minDelay, maxDelay time.Duration = window, 0
+	for i := 0; i < 100; i++ {
+		delay := jitterDelay(fmt.Sprintf("kubelet:node-%d", i), window)
+		if delay < 0 || delay >= window {
+			t.Fatalf("Delay %s outside of the jitter window", delay)
+		}
+		if delay < minDelay {
+			minDelay = delay
+		}
+		if delay > maxDelay {
+			maxDelay = delay
+		}
+	}
+	if maxDelay-minDelay < window/2 {
+		t.Errorf("Expected the delays to be spread over the window, got %s to %s", minDelay, maxDelay)
+	}
+
+	// Sources scraped at the end of the window still get the whole scrape timeout.
+	provider := &fakeSourceProvider{}
+	for i := 0; i < 5; i++ {
+		provider.sources = append(provider.sources, &fakeNodeSource{name: fmt.Sprintf("s%d", i), latency: 500 * time.Millisecond})
+	}
+	manager, _ := NewSourceManager(provider, time.Second, 0, time.Second)
+	end := time.Now()
+	dataBatch, _ := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
+	if len(dataBatch.MetricSets) != 5 {
+		t.Errorf("Expected the metrics of all 5 sources, got %d", len(dataBatch.MetricSets))
+	}
+	if !dataBatch.Timestamp.Equal(end) {
+		t.Errorf("Expected the batch to be aligned to %s, got %s", end, dataBatch.Timestamp)
+	}
+}
+
+func TestSourceStatusHistory(t *testing.T) {
+	source := &fakeNodeSource{name: "s1", err: errors.New("connection refused")}
+	manager, _ := NewSourceManager(&fakeSourceProvider{sources: []core.MetricsSource{source}}, 100*time.Millisecond, 0, 0)
+	end := time.Now()
+	scrape := func() SourceStatus {
+		manager.ScrapeMetrics(end.Add(-10*time.Second), end)
+		return manager.GetSourceStatuses()[0]
+	}
+
+	scrape()
+	status := scrape()
+	if status.ConsecutiveFailures != 2 || status.LastError != "connection refused" || status.LastErrorTime.IsZero() {
+		t.Errorf("Expected 2 failures, got %+v", status)
+	}
+
+	source.err = nil
+	status = scrape()
+	if status.ConsecutiveFailures != 0 || status.Error != "" || status.LastSuccessTime.IsZero() {
+		t.Errorf("Expected a successful scrape, got %+v", status)
+	}
+	// The latest error is kept after a success.
+	if status.LastError != "connection refused" {
+		t.Errorf("Expected the latest error to be kept, got %+v", status)
+	}
+
+	// A scrape finishing after the timeout is counted once.
+	source.latency = 200 * time.Millisecond
+	scrape()
+	time.Sleep(300 * time.Millisecond)
+	status = manager.GetSourceStatuses()[0]
+	if status.ConsecutiveFailures != 1 || status.LastError != "no response within the scrape timeout of 100ms" {
+		t.Errorf("Expected 1 timeout, got %+v", status)
+	}
+}
+
+func TestScrapeErrors(t *testing.T) {
+	source := &fakeNodeSource{name: "s1", err: &core.DecodeError{Err: errors.New("unexpected EOF")}}
+	other := &fakeNodeSource{name: "s2", err: errors.New("connection refused")}
+	manager, _ := NewSourceManager(&fakeSourceProvider{sources: []core.MetricsSource{source, other}}, 100*time.Millisecond, 0, 0)
+	end := time.Now()
+	scrape := func() *core.DataBatch {
+		batch, _ := manager.ScrapeMetrics(end.Add(-10*time.Second), end)
+		return batch
+	}
+	scrapeErrors := func(batch *core.DataBatch, node string) map[string]int64 {
+		metricSet, found := batch.MetricSets[core.NodeKey(node)]
+		if !found {
+			t.Fatalf("No metric set for %s", node)
+		}
+		result := map[string]int64{}
+		for _, metric := range metricSet.LabeledMetrics {
+			if metric.Name == core.MetricScrapeErrors.Name {
+				result[metric.Labels[core.LabelScrapeErrorReason.Key]] = metric.IntValue
+			}
+		}
+		return result
+	}
+
+	scrape()
+	batch := scrape()
+	// Nodes which could not be scraped still report their errors.
+	expected := map[string]int64{ScrapeErrorTimeout: 0, ScrapeErrorDecode: 2, ScrapeErrorRequest: 0}
+	if actual := scrapeErrors(batch, "node-s1"); fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v for node-s1, got %v", expected, actual)
+	}
+	metricSet := batch.MetricSets[core.NodeKey("node-s1")]
+	if metricSet.Labels[core.LabelMetricSetType.Key] != core.MetricSetTypeNode || metricSet.Labels[core.LabelNodename.Key] != "node-s1" {
+		t.Errorf("Expected the labels of node-s1, got %v", metricSet.Labels)
+	}
+	expected = map[string]int64{ScrapeErrorTimeout: 0, ScrapeErrorDecode: 0, ScrapeErrorRequest: 2}
+	if actual := scrapeErrors(batch, "node-s2"); fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v for node-s2, got %v", expected, actual)
+	}
+
+	// A scrape finishing after the timeout is counted once, as a timeout.
+	source.err = nil
+	source.latency = 200 * time.Millisecond
+	batch = scrape()
+	expected = map[string]int64{ScrapeErrorTimeout: 1, ScrapeErrorDecode: 2, ScrapeErrorRequest: 0}
+	if actual := scrapeErrors(batch, "node-s1"); fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v for node-s1, got %v", expected, actual)
+	}
+	time.Sleep(300 * time.Millisecond)
+	status := manager.GetSourceStatuses()[0]
+	if len(status.Errors) != 2 || status.Errors[ScrapeErrorTimeout] != 1 || status.Errors[ScrapeErrorDecode] != 2 {
+		t.Errorf("Expected 1 timeout and 2 decode errors, got %+v", status)
+	}
+}
+
+type batchSource struct {
+	name    string
+	latency time.Duration
+	batch   *core.DataBatch
+}
+
+func (s *batchSource) Name() string { return s.name }
+func (s *batchSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	time.Sleep(s.latency)
+	return s.batch, nil
+}
+
+func TestSameKeyMetricSetsMerged(t *testing.T) {
+	// The first source takes precedence although it replies last.
+	summary := &batchSource{
+		name:    "summary",
+		latency: 50 * time.Millisecond,
+		batch: &core.DataBatch{MetricSets: map[string]*core.MetricSet{
+			"namespace:ns/pod:p": {
+				Labels:       map[string]string{"source": "summary"},
+				MetricValues: map[string]core.MetricValue{"cpu/usage": intValue(1)},
+			},
+		}},
+	}
+	state := &batchSource{
+		name: "state",
+		batch: &core.DataBatch{MetricSets: map[string]*core.MetricSet{
+			"namespace:ns/pod:p": {
+				Labels:       map[string]string{"source": "state", "phase": "Running"},
+				MetricValues: map[string]core.MetricValue{"cpu/usage": intValue(2), "restart_count": intValue(3)},
+			},
+		}},
+	}
+
+	manager, err := NewSourceManager(&fakeSourceProvider{sources: []core.MetricsSource{summary, state}}, time.Second, 0, 0)
+	if err != nil {
+		t.Fatalf("NewSourceManager error. %v", err)
+	}
+	end := time.Now()
+	batch, err := manager.ScrapeMetrics(end.Add(-time.Minute), end)
+	if err != nil {
+		t.Fatalf("ScrapeMetrics error. %v", err)
+	}
+
+	pod, found := batch.MetricSets["namespace:ns/pod:p"]
+	if !found {
+		t.Fatal("pod not found")
+	}
+	if pod.Labels["source"] != "summary" || pod.Labels["phase"] != "Running" {
+		t.Errorf("unexpected labels %v", pod.Labels)
+	}
+	if pod.MetricValues["cpu/usage"].IntValue != 1 {
+		t.Errorf("cpu/usage %d, expected the value of the first source", pod.MetricValues["cpu/usage"].IntValue)
+	}
+	if pod.MetricValues["restart_count"].IntValue != 3 {
+		t.Errorf("restart_count not merged: %v", pod.MetricValues)
+	}
+}
+
+func TestScrapeNodeMetrics(t *testing.T) {
+	provider := &fakeSourceProvider{sources: []core.MetricsSource{
+		&fakeNodeSource{name: "a"},
+		&fakeNodeSource{name: "b"},
+		util.NewDummyMetricsSource("other", 0),
+	}}
+	manager, err := NewSourceManager(provider, time.Second, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("NewSourceManager error. %v", err)
+	}
+	end := time.Now()
+	batch, err := manager.(*sourceManager).ScrapeNodeMetrics(end.Add(-time.Minute), end, map[string]bool{"node-b": true})
+	if err != nil {
+		t.Fatalf("ScrapeNodeMetrics error. %v", err)
+	}
+	if len(batch.MetricSets) != 1 {
+		t.Fatalf("expected the metric set of node-b only, got %v", batch.MetricSets)
+	}
+	if _, found := batch.MetricSets[core.NodeKey("node-b")]; !found {
+		t.Fatalf("node-b not found in %v", batch.MetricSets)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"sort"
+	"time"
+
+	. "k8s.io/heapster/metrics/core"
+
+	"github.com/golang/glog"
+)
+
+// NewMergingSourceManager returns a SourceManager scraping all managers concurrently and
+// merging their batches, e.g. to combine the metrics of the kubelets with those of an
+// auxiliary source. Metric sets reported under the same key by several managers are merged,
+// with managers earlier in the list taking precedence for labels and metrics reported by more
+// than one of them.
+func NewMergingSourceManager(managers []SourceManager) SourceManager {
+	return &mergingSourceManager{managers: managers}
+}
+
+type mergingSourceManager struct {
+	managers []SourceManager
+}
+
+func (this *mergingSourceManager) Name() string {
+	return "merging_source_manager"
+}
+
+func (this *mergingSourceManager) ScrapeMetrics(start, end time.Time) (*DataBatch, error) {
+	return this.scrape(start, end, func(manager SourceManager) (*DataBatch, error) {
+		return manager.ScrapeMetrics(start, end)
+	})
+}
+
+// ScrapeNodeMetrics scrapes the kubelets of the given nodes only, with the managers able to.
+func (this *mergingSourceManager) ScrapeNodeMetrics(start, end time.Time, nodes map[string]bool) (*DataBatch, error) {
+	return this.scrape(start, end, func(manager SourceManager) (*DataBatch, error) {
+		if scraper, ok := manager.(NodeScraper); ok {
+			return scraper.ScrapeNodeMetrics(start, end, nodes)
+		}
+		return nil, nil
+	})
+}
+
+func (this *mergingSourceManager) scrape(start, end time.Time, scrape func(SourceManager) (*DataBatch, error)) (*DataBatch, error) {
+	// Each manager enforces the scrape timeout of its sources.
+	batches := make([]*DataBatch, len(this.managers))
+	done := make(chan bool)
+	for i, manager := range this.managers {
+		go func(i int, manager SourceManager) {
+			batch, err := scrape(manager)
+			if err != nil {
+				glog.Errorf("Error in scraping %s: %v", manager.Name(), err)
+			}
+			batches[i] = batch
+			done <- true
+		}(i, manager)
+	}
+	for range this.managers {
+		<-done
+	}
+
+	response := &DataBatch{
+		Timestamp:  end,
+		MetricSets: map[string]*MetricSet{},
+	}
+	for _, batch := range batches {
+		if batch == nil {
+			continue
+		}
+		if response.ID == "" {
+			response.ID = batch.ID
+		}
+		for key, metricSet := range batch.MetricSets {
+			if existing, found := response.MetricSets[key]; found {
+				glog.V(4).Infof("[batch %s] Merging metric set %s reported by several sources", response.ID, key)
+				mergeMetricSet(existing, metricSet)
+			} else {
+				response.MetricSets[key] = metricSet
+			}
+		}
+	}
+	if response.ID == "" {
+		response.ID = NewBatchID(end)
+	}
+	return response, nil
+}
+
+// mergeMetricSet adds the labels and metrics of other which are missing from metricSet.
+func mergeMetricSet(metricSet, other *MetricSet) {
+	if metricSet.Labels == nil {
+		metricSet.Labels = map[string]string{}
+	}
+	for name, value := range other.Labels {
+		if _, found := metricSet.Labels[name]; !found {
+			metricSet.Labels[name] = value
+		}
+	}
+	if metricSet.MetricValues == nil {
+		metricSet.MetricValues = map[string]MetricValue{}
+	}
+	for name, value := range other.MetricValues {
+		if _, found := metricSet.MetricValues[name]; !found {
+			metricSet.MetricValues[name] = value
+		}
+	}
+	for _, metric := range other.LabeledMetrics {
+		if !hasLabeledMetric(metricSet, metric) {
+			metricSet.LabeledMetrics = append(metricSet.LabeledMetrics, metric)
+		}
+	}
+	if metricSet.ScrapeTime.IsZero() {
+		metricSet.ScrapeTime = other.ScrapeTime
+	}
+	if metricSet.CollectionStartTime.IsZero() {
+		metricSet.CollectionStartTime = other.CollectionStartTime
+	}
+	if metricSet.EntityCreateTime.IsZero() {
+		metricSet.EntityCreateTime = other.EntityCreateTime
+	}
+}
+
+func hasLabeledMetric(metricSet *MetricSet, metric LabeledMetric) bool {
+	for _, existing := range metricSet.LabeledMetrics {
+		if existing.Name != metric.Name || len(existing.Labels) != len(metric.Labels) {
+			continue
+		}
+		equal := true
+		for name, value := range metric.Labels {
+			if existing.Labels[name] != value {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *mergingSourceManager) GetSourceStatuses() []SourceStatus {
+	var result []SourceStatus
+	for _, manager := range this.managers {
+		result = append(result, manager.GetSourceStatuses()...)
+	}
+	sort.Sort(byName(result))
+	return result
+}
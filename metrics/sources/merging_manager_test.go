@@ -0,0 +1,110 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+type fakeSourceManager struct {
+	name    string
+	latency time.Duration
+	batch   *core.DataBatch
+}
+
+func (this *fakeSourceManager) Name() string {
+	return this.name
+}
+
+func (this *fakeSourceManager) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	time.Sleep(this.latency)
+	return this.batch, nil
+}
+
+func (this *fakeSourceManager) GetSourceStatuses() []SourceStatus {
+	return []SourceStatus{{Source: this.name}}
+}
+
+func intValue(value int64) core.MetricValue {
+	return core.MetricValue{IntValue: value, MetricType: core.MetricGauge, ValueType: core.ValueInt64}
+}
+
+func TestMergingSourceManager(t *testing.T) {
+	end := time.Now()
+	// The first manager takes precedence although it replies last.
+	first := &fakeSourceManager{
+		name:    "summary",
+		latency: 50 * time.Millisecond,
+		batch: &core.DataBatch{
+			ID: "first",
+			MetricSets: map[string]*core.MetricSet{
+				"node:a": {
+					Labels:       map[string]string{"source": "summary"},
+					MetricValues: map[string]core.MetricValue{"cpu/usage": intValue(1)},
+					LabeledMetrics: []core.LabeledMetric{
+						{Name: "filesystem/usage", Labels: map[string]string{"resource_id": "/"}, MetricValue: intValue(10)},
+					},
+				},
+			},
+		},
+	}
+	second := &fakeSourceManager{
+		name: "auxiliary",
+		batch: &core.DataBatch{
+			ID: "second",
+			MetricSets: map[string]*core.MetricSet{
+				"node:a": {
+					Labels:       map[string]string{"source": "auxiliary", "rack": "r1"},
+					MetricValues: map[string]core.MetricValue{"cpu/usage": intValue(2), "power": intValue(3)},
+					ScrapeTime:   end,
+					LabeledMetrics: []core.LabeledMetric{
+						{Name: "filesystem/usage", Labels: map[string]string{"resource_id": "/"}, MetricValue: intValue(20)},
+						{Name: "filesystem/usage", Labels: map[string]string{"resource_id": "/data"}, MetricValue: intValue(30)},
+					},
+				},
+				"node:b": {
+					MetricValues: map[string]core.MetricValue{"power": intValue(4)},
+				},
+			},
+		},
+	}
+
+	manager := NewMergingSourceManager([]SourceManager{first, second})
+	batch, err := manager.ScrapeMetrics(end.Add(-time.Minute), end)
+	require.NoError(t, err)
+	assert.Equal(t, "first", batch.ID)
+	assert.Equal(t, end, batch.Timestamp)
+	require.Len(t, batch.MetricSets, 2)
+
+	node := batch.MetricSets["node:a"]
+	assert.Equal(t, map[string]string{"source": "summary", "rack": "r1"}, node.Labels)
+	assert.Equal(t, map[string]core.MetricValue{"cpu/usage": intValue(1), "power": intValue(3)}, node.MetricValues)
+	assert.Equal(t, end, node.ScrapeTime)
+	require.Len(t, node.LabeledMetrics, 2)
+	assert.Equal(t, int64(10), node.LabeledMetrics[0].IntValue)
+	assert.Equal(t, "/data", node.LabeledMetrics[1].Labels["resource_id"])
+	assert.Equal(t, int64(4), batch.MetricSets["node:b"].MetricValues["power"].IntValue)
+
+	statuses := manager.GetSourceStatuses()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "auxiliary", statuses[0].Source)
+	assert.Equal(t, "summary", statuses[1].Source)
+}
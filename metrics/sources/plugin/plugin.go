@@ -0,0 +1,303 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugin implements sources delegating the scrapes to out-of-tree plugins: executables
+// run for each scrape, or HTTP endpoints. Both receive a ScrapeRequest and answer a
+// ScrapeResponse, encoded in JSON.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/metrics/core"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	// Bounds the output of the plugins kept in error messages.
+	maxErrorOutput = 1024
+)
+
+// ScrapeRequest is sent to the plugin for each scrape, on the standard input of executables and
+// as the body of a POST request to endpoints.
+type ScrapeRequest struct {
+	// Window of the scrape, the plugin should return the latest values in it.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Options of the source, except the ones interpreted by Heapster.
+	Options map[string][]string `json:"options,omitempty"`
+}
+
+// ScrapeResponse is returned by the plugin, on the standard output of executables and as the
+// body of the response of endpoints.
+type ScrapeResponse struct {
+	// Metric sets by key. Sets with the key of a set of another source, e.g. "node:<name>", are
+	// merged with it; labels and metrics reported by both are taken from the source given first.
+	MetricSets map[string]MetricSet `json:"metricSets"`
+}
+
+type MetricSet struct {
+	// Labels of the set, which should include the type label, e.g. "type": "node".
+	Labels              map[string]string `json:"labels"`
+	Metrics             map[string]Value  `json:"metrics,omitempty"`
+	LabeledMetrics      []LabeledValue    `json:"labeledMetrics,omitempty"`
+	CollectionStartTime time.Time         `json:"collectionStartTime,omitempty"`
+	ScrapeTime          time.Time         `json:"scrapeTime,omitempty"`
+}
+
+type Value struct {
+	// Integer values are exported as int64, the others as floats.
+	Value json.Number `json:"value"`
+	// One of gauge (default), cumulative or delta.
+	Type string `json:"type,omitempty"`
+}
+
+type LabeledValue struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value
+}
+
+// runner performs a scrape request in a plugin and returns its response body.
+type runner interface {
+	run(ctx context.Context, request []byte) ([]byte, error)
+}
+
+type pluginProvider struct {
+	source *pluginSource
+}
+
+func (this *pluginProvider) GetMetricsSources() []core.MetricsSource {
+	return []core.MetricsSource{this.source}
+}
+
+type pluginSource struct {
+	name    string
+	runner  runner
+	timeout time.Duration
+	options map[string][]string
+}
+
+// NewPluginProvider creates a provider for the plugin of the uri: an HTTP(S) endpoint, e.g.
+// `plugin:http://localhost:8080/scrape`, or the path of an executable, e.g.
+// `plugin:/opt/heapster/vsphere?arg=--datacenter=dc1`.
+func NewPluginProvider(uri *url.URL) (core.MetricsSourceProvider, error) {
+	opts := uri.Query()
+	source := &pluginSource{
+		timeout: defaultTimeout,
+		options: map[string][]string{},
+	}
+	if len(opts["timeout"]) > 0 {
+		timeout, err := time.ParseDuration(opts["timeout"][0])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q, should be a positive duration", opts["timeout"][0])
+		}
+		source.timeout = timeout
+	}
+	for name, values := range opts {
+		if name != "timeout" && name != "arg" && name != "name" {
+			source.options[name] = values
+		}
+	}
+
+	switch uri.Scheme {
+	case "http", "https":
+		endpoint := *uri
+		endpoint.RawQuery = ""
+		source.name = "plugin:" + endpoint.Host
+		source.runner = &endpointRunner{url: endpoint.String(), client: &http.Client{}}
+	case "":
+		if uri.Path == "" {
+			return nil, fmt.Errorf("the path of the plugin executable is required")
+		}
+		path, err := exec.LookPath(uri.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin executable: %v", err)
+		}
+		source.name = "plugin:" + filepath.Base(path)
+		source.runner = &execRunner{path: path, args: opts["arg"]}
+	default:
+		return nil, fmt.Errorf("unsupported plugin scheme %q, expected http, https or the path of an executable", uri.Scheme)
+	}
+	if len(opts["name"]) > 0 {
+		source.name = "plugin:" + opts["name"][0]
+	}
+	return &pluginProvider{source: source}, nil
+}
+
+func (this *pluginSource) Name() string {
+	return this.name
+}
+
+func (this *pluginSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	request, err := json.Marshal(&ScrapeRequest{Start: start, End: end, Options: this.options})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+	body, err := this.runner.run(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %v", this.name, err)
+	}
+
+	var response ScrapeResponse
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode the response of plugin %s: %v", this.name, err)
+	}
+	result := &core.DataBatch{
+		Timestamp:  end,
+		MetricSets: make(map[string]*core.MetricSet, len(response.MetricSets)),
+	}
+	for key, set := range response.MetricSets {
+		metricSet, err := decodeMetricSet(&set)
+		if err != nil {
+			glog.Warningf("Dropping metric set %s of plugin %s: %v", key, this.name, err)
+			continue
+		}
+		if metricSet.ScrapeTime.IsZero() {
+			metricSet.ScrapeTime = end
+		}
+		result.MetricSets[key] = metricSet
+	}
+	return result, nil
+}
+
+func decodeMetricSet(set *MetricSet) (*core.MetricSet, error) {
+	result := &core.MetricSet{
+		Labels:              set.Labels,
+		MetricValues:        make(map[string]core.MetricValue, len(set.Metrics)),
+		LabeledMetrics:      make([]core.LabeledMetric, 0, len(set.LabeledMetrics)),
+		CollectionStartTime: set.CollectionStartTime,
+		ScrapeTime:          set.ScrapeTime,
+	}
+	if result.Labels == nil {
+		result.Labels = map[string]string{}
+	}
+	for name, value := range set.Metrics {
+		metricValue, err := decodeValue(&value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %v", name, err)
+		}
+		result.MetricValues[name] = metricValue
+	}
+	for _, value := range set.LabeledMetrics {
+		metricValue, err := decodeValue(&value.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %v", value.Name, err)
+		}
+		result.LabeledMetrics = append(result.LabeledMetrics, core.LabeledMetric{
+			Name:        value.Name,
+			Labels:      value.Labels,
+			MetricValue: metricValue,
+		})
+	}
+	return result, nil
+}
+
+func decodeValue(value *Value) (core.MetricValue, error) {
+	var result core.MetricValue
+	switch value.Type {
+	case "", "gauge":
+		result.MetricType = core.MetricGauge
+	case "cumulative":
+		result.MetricType = core.MetricCumulative
+	case "delta":
+		result.MetricType = core.MetricDelta
+	default:
+		return result, fmt.Errorf("unknown type %q", value.Type)
+	}
+	if intValue, err := value.Value.Int64(); err == nil {
+		result.ValueType = core.ValueInt64
+		result.IntValue = intValue
+		return result, nil
+	}
+	floatValue, err := value.Value.Float64()
+	if err != nil {
+		return result, err
+	}
+	result.ValueType = core.ValueFloat
+	result.FloatValue = floatValue
+	return result, nil
+}
+
+// execRunner runs the executable of the plugin for each scrape.
+type execRunner struct {
+	path string
+	args []string
+}
+
+func (this *execRunner) run(ctx context.Context, request []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, this.path, this.args...)
+	cmd.Stdin = bytes.NewReader(request)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("%v: %s", err, truncate(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		glog.V(2).Infof("Plugin %s: %s", this.path, truncate(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// endpointRunner posts the scrape requests to the endpoint of the plugin.
+type endpointRunner struct {
+	url    string
+	client *http.Client
+}
+
+func (this *endpointRunner) run(ctx context.Context, request []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", this.url, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := this.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorOutput))
+		return nil, fmt.Errorf("%s returned %s: %s", this.url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func truncate(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxErrorOutput {
+		return output[:maxErrorOutput] + "..."
+	}
+	return output
+}
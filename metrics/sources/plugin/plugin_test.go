@@ -0,0 +1,160 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/heapster/metrics/core"
+)
+
+const response = `{"metricSets": {
+  "node:esx-1": {
+    "labels": {"type": "node", "nodename": "esx-1"},
+    "metrics": {
+      "cpu/usage": {"value": 12000000000, "type": "cumulative"},
+      "cpu/node_utilization": {"value": 0.25}
+    },
+    "labeledMetrics": [
+      {"name": "filesystem/usage", "labels": {"resource_id": "datastore1"}, "value": 4096}
+    ]
+  },
+  "node:broken": {
+    "labels": {"type": "node"},
+    "metrics": {"cpu/usage": {"value": 1, "type": "histogram"}}
+  }
+}}`
+
+func checkBatch(t *testing.T, batch *core.DataBatch, end time.Time) {
+	// The set with an invalid metric is dropped.
+	require.Len(t, batch.MetricSets, 1)
+	node := batch.MetricSets["node:esx-1"]
+	require.NotNil(t, node)
+	assert.Equal(t, core.MetricSetTypeNode, node.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, end, node.ScrapeTime)
+	assert.Equal(t, core.MetricValue{IntValue: 12e9, MetricType: core.MetricCumulative, ValueType: core.ValueInt64},
+		node.MetricValues[core.MetricCpuUsage.Name])
+	assert.Equal(t, core.MetricValue{FloatValue: 0.25, MetricType: core.MetricGauge, ValueType: core.ValueFloat},
+		node.MetricValues[core.MetricNodeCpuUtilization.Name])
+	require.Len(t, node.LabeledMetrics, 1)
+	assert.Equal(t, "datastore1", node.LabeledMetrics[0].Labels[core.LabelResourceID.Key])
+	assert.Equal(t, int64(4096), node.LabeledMetrics[0].IntValue)
+}
+
+func TestEndpointPlugin(t *testing.T) {
+	var request ScrapeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "/scrape?datacenter=dc1&timeout=5s")
+	require.NoError(t, err)
+	provider, err := NewPluginProvider(uri)
+	require.NoError(t, err)
+	sources := provider.GetMetricsSources()
+	require.Len(t, sources, 1)
+
+	end := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	batch, err := sources[0].ScrapeMetrics(end.Add(-time.Minute), end)
+	require.NoError(t, err)
+	checkBatch(t, batch, end)
+	assert.Equal(t, end, request.End.UTC())
+	assert.Equal(t, map[string][]string{"datacenter": {"dc1"}}, request.Options)
+}
+
+func writeScript(t *testing.T, dir, script string) string {
+	path := filepath.Join(dir, "plugin.sh")
+	require.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return path
+}
+
+func TestExecPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// The plugin saves its arguments and request, and answers the canned response.
+	path := writeScript(t, dir, "echo \"$@\" > "+dir+"/args\n"+
+		"cat > "+dir+"/request\n"+
+		"cat <<'END'\n"+response+"\nEND\n")
+
+	uri, err := url.Parse(path + "?arg=--datacenter&arg=dc1&name=vsphere")
+	require.NoError(t, err)
+	provider, err := NewPluginProvider(uri)
+	require.NoError(t, err)
+	source := provider.GetMetricsSources()[0]
+	assert.Equal(t, "plugin:vsphere", source.Name())
+
+	end := time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC)
+	batch, err := source.ScrapeMetrics(end.Add(-time.Minute), end)
+	require.NoError(t, err)
+	checkBatch(t, batch, end)
+
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "--datacenter dc1\n", string(args))
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "request"))
+	require.NoError(t, err)
+	var request ScrapeRequest
+	require.NoError(t, json.Unmarshal(contents, &request))
+	assert.Equal(t, end.Add(-time.Minute), request.Start.UTC())
+	assert.Empty(t, request.Options)
+}
+
+func TestExecPluginFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, script := range []string{
+		"echo 'no credentials' >&2; exit 1",
+		"echo 'not json'",
+		"exec sleep 5",
+	} {
+		path := writeScript(t, dir, script)
+		uri, err := url.Parse(path + "?timeout=100ms")
+		require.NoError(t, err)
+		provider, err := NewPluginProvider(uri)
+		require.NoError(t, err)
+		_, err = provider.GetMetricsSources()[0].ScrapeMetrics(time.Now(), time.Now())
+		assert.Error(t, err, script)
+	}
+}
+
+func TestPluginProviderOptions(t *testing.T) {
+	for _, uri := range []string{
+		"",
+		"/does/not/exist",
+		"ftp://example.com/plugin",
+		"http://example.com/scrape?timeout=never",
+	} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		_, err = NewPluginProvider(parsed)
+		assert.Error(t, err, uri)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package state implements a source exporting the state of the Kubernetes objects, e.g. the
+// phase of the pods or the available replicas of the deployments, as metrics, so that the sinks
+// receive them along with the utilization of the objects.
+package state
+
+import (
+	"net/url"
+	"time"
+
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util"
+)
+
+// Phases exported for every pod, so that pods can be counted by phase.
+var podPhases = []kube_api.PodPhase{
+	kube_api.PodPending,
+	kube_api.PodRunning,
+	kube_api.PodSucceeded,
+	kube_api.PodFailed,
+	kube_api.PodUnknown,
+}
+
+type stateProvider struct {
+	source *stateSource
+}
+
+func (this *stateProvider) GetMetricsSources() []core.MetricsSource {
+	return []core.MetricsSource{this.source}
+}
+
+// stateSource reports the state of the pods, their containers and the deployments from the
+// caches of the shared informers. The metric sets have the same keys as those of the kubelets,
+// so the state is merged into the metric sets of the objects.
+type stateSource struct {
+	podLister        v1listers.PodLister
+	deploymentLister appslisters.DeploymentLister
+}
+
+// NewStateProvider creates a provider of the state of the objects of the Kubernetes API server
+// configured by uri, e.g. `kubernetes.state:https://kubernetes.default`.
+func NewStateProvider(uri *url.URL) (core.MetricsSourceProvider, error) {
+	factory, err := util.GetSharedInformerFactory(uri)
+	if err != nil {
+		return nil, err
+	}
+	source := &stateSource{
+		podLister:        factory.Core().V1().Pods().Lister(),
+		deploymentLister: factory.Apps().V1().Deployments().Lister(),
+	}
+	factory.Start(wait.NeverStop)
+	return &stateProvider{source: source}, nil
+}
+
+func (this *stateSource) Name() string {
+	return "kubernetes.state"
+}
+
+func (this *stateSource) ScrapeMetrics(start, end time.Time) (*core.DataBatch, error) {
+	result := &core.DataBatch{
+		Timestamp:  end,
+		MetricSets: map[string]*core.MetricSet{},
+	}
+	pods, err := this.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		addPodState(result, pod, end)
+	}
+	deployments, err := this.deploymentLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments {
+		namespace := namespaceMetricSet(result, deployment.Namespace, end)
+		desired := int64(1)
+		if deployment.Spec.Replicas != nil {
+			desired = int64(*deployment.Spec.Replicas)
+		}
+		labels := map[string]string{core.LabelDeploymentName.Key: deployment.Name}
+		namespace.LabeledMetrics = append(namespace.LabeledMetrics,
+			labeledMetric(core.MetricDeploymentDesiredReplicas.Name, labels, desired),
+			labeledMetric(core.MetricDeploymentAvailableReplicas.Name, labels, int64(deployment.Status.AvailableReplicas)),
+			labeledMetric(core.MetricDeploymentUpdatedReplicas.Name, labels, int64(deployment.Status.UpdatedReplicas)))
+	}
+	return result, nil
+}
+
+// addPodState adds the phase of the pod, and the readiness and restarts of its containers.
+func addPodState(batch *core.DataBatch, pod *kube_api.Pod, timestamp time.Time) {
+	podLabels := map[string]string{
+		core.LabelMetricSetType.Key: core.MetricSetTypePod,
+		core.LabelNamespaceName.Key: pod.Namespace,
+		core.LabelPodName.Key:       pod.Name,
+		core.LabelPodId.Key:         string(pod.UID),
+	}
+	if pod.Spec.NodeName != "" {
+		podLabels[core.LabelNodename.Key] = pod.Spec.NodeName
+		podLabels[core.LabelHostname.Key] = pod.Spec.NodeName
+	}
+	podSet := &core.MetricSet{
+		Labels:         podLabels,
+		MetricValues:   map[string]core.MetricValue{},
+		LabeledMetrics: []core.LabeledMetric{},
+		ScrapeTime:     timestamp,
+	}
+	if pod.Status.StartTime != nil {
+		podSet.EntityCreateTime = pod.Status.StartTime.Time
+	}
+	for _, phase := range podPhases {
+		value := int64(0)
+		if pod.Status.Phase == phase {
+			value = 1
+		}
+		podSet.LabeledMetrics = append(podSet.LabeledMetrics,
+			labeledMetric(core.MetricPodPhase.Name, map[string]string{core.LabelPodPhase.Key: string(phase)}, value))
+	}
+	podKey := core.PodKeyWithUID(pod.Namespace, pod.Name, string(pod.UID))
+	batch.MetricSets[podKey] = podSet
+
+	for _, status := range pod.Status.ContainerStatuses {
+		containerLabels := map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypePodContainer,
+			core.LabelContainerName.Key: status.Name,
+		}
+		for _, label := range []string{core.LabelNamespaceName.Key, core.LabelPodName.Key, core.LabelPodId.Key,
+			core.LabelNodename.Key, core.LabelHostname.Key} {
+			if value, found := podLabels[label]; found {
+				containerLabels[label] = value
+			}
+		}
+		ready := int64(0)
+		if status.Ready {
+			ready = 1
+		}
+		batch.MetricSets[core.ContainerKeyForPod(podKey, status.Name)] = &core.MetricSet{
+			Labels: containerLabels,
+			MetricValues: map[string]core.MetricValue{
+				core.MetricContainerReady.Name: intValue(ready),
+				core.MetricRestartCount.Name:   intValue(int64(status.RestartCount)),
+			},
+			ScrapeTime:       timestamp,
+			EntityCreateTime: podSet.EntityCreateTime,
+		}
+	}
+}
+
+func namespaceMetricSet(batch *core.DataBatch, namespace string, timestamp time.Time) *core.MetricSet {
+	key := core.NamespaceKey(namespace)
+	if metricSet, found := batch.MetricSets[key]; found {
+		return metricSet
+	}
+	metricSet := &core.MetricSet{
+		Labels: map[string]string{
+			core.LabelMetricSetType.Key: core.MetricSetTypeNamespace,
+			core.LabelNamespaceName.Key: namespace,
+		},
+		MetricValues:   map[string]core.MetricValue{},
+		LabeledMetrics: []core.LabeledMetric{},
+		ScrapeTime:     timestamp,
+	}
+	batch.MetricSets[key] = metricSet
+	return metricSet
+}
+
+func labeledMetric(name string, labels map[string]string, value int64) core.LabeledMetric {
+	return core.LabeledMetric{
+		Name:        name,
+		Labels:      labels,
+		MetricValue: intValue(value),
+	}
+}
+
+func intValue(value int64) core.MetricValue {
+	return core.MetricValue{
+		ValueType:  core.ValueInt64,
+		MetricType: core.MetricGauge,
+		IntValue:   value,
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apps "k8s.io/api/apps/v1"
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+func labeledValue(metricSet *core.MetricSet, name, label, value string) (int64, bool) {
+	for _, metric := range metricSet.LabeledMetrics {
+		if metric.Name == name && metric.Labels[label] == value {
+			return metric.IntValue, true
+		}
+	}
+	return 0, false
+}
+
+func TestStateSource(t *testing.T) {
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	podIndexer.Add(&kube_api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "web-1", UID: "uid-1"},
+		Spec:       kube_api.PodSpec{NodeName: "node1"},
+		Status: kube_api.PodStatus{
+			Phase: kube_api.PodRunning,
+			ContainerStatuses: []kube_api.ContainerStatus{
+				{Name: "app", Ready: true, RestartCount: 2},
+				{Name: "proxy", Ready: false},
+			},
+		},
+	})
+	podIndexer.Add(&kube_api.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "web-2", UID: "uid-2"},
+		Status:     kube_api.PodStatus{Phase: kube_api.PodPending},
+	})
+	deploymentIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	replicas := int32(3)
+	deploymentIndexer.Add(&apps.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "web"},
+		Spec:       apps.DeploymentSpec{Replicas: &replicas},
+		Status:     apps.DeploymentStatus{AvailableReplicas: 1, UpdatedReplicas: 2},
+	})
+	source := &stateSource{
+		podLister:        v1listers.NewPodLister(podIndexer),
+		deploymentLister: appslisters.NewDeploymentLister(deploymentIndexer),
+	}
+
+	now := time.Now()
+	batch, err := source.ScrapeMetrics(now.Add(-time.Minute), now)
+	require.NoError(t, err)
+	assert.Len(t, batch.MetricSets, 5)
+
+	running := batch.MetricSets[core.PodKey("ns1", "web-1")]
+	require.NotNil(t, running)
+	assert.Equal(t, "node1", running.Labels[core.LabelNodename.Key])
+	value, found := labeledValue(running, core.MetricPodPhase.Name, core.LabelPodPhase.Key, "Running")
+	assert.True(t, found)
+	assert.Equal(t, int64(1), value)
+	value, found = labeledValue(running, core.MetricPodPhase.Name, core.LabelPodPhase.Key, "Pending")
+	assert.True(t, found)
+	assert.Equal(t, int64(0), value)
+
+	pending := batch.MetricSets[core.PodKey("ns1", "web-2")]
+	require.NotNil(t, pending)
+	_, found = pending.Labels[core.LabelNodename.Key]
+	assert.False(t, found)
+	value, _ = labeledValue(pending, core.MetricPodPhase.Name, core.LabelPodPhase.Key, "Pending")
+	assert.Equal(t, int64(1), value)
+
+	app := batch.MetricSets[core.PodContainerKey("ns1", "web-1", "app")]
+	require.NotNil(t, app)
+	assert.Equal(t, core.MetricSetTypePodContainer, app.Labels[core.LabelMetricSetType.Key])
+	assert.Equal(t, "web-1", app.Labels[core.LabelPodName.Key])
+	assert.Equal(t, int64(1), app.MetricValues[core.MetricContainerReady.Name].IntValue)
+	assert.Equal(t, int64(2), app.MetricValues[core.MetricRestartCount.Name].IntValue)
+	proxy := batch.MetricSets[core.PodContainerKey("ns1", "web-1", "proxy")]
+	require.NotNil(t, proxy)
+	assert.Equal(t, int64(0), proxy.MetricValues[core.MetricContainerReady.Name].IntValue)
+
+	namespace := batch.MetricSets[core.NamespaceKey("ns1")]
+	require.NotNil(t, namespace)
+	value, _ = labeledValue(namespace, core.MetricDeploymentDesiredReplicas.Name, core.LabelDeploymentName.Key, "web")
+	assert.Equal(t, int64(3), value)
+	value, _ = labeledValue(namespace, core.MetricDeploymentAvailableReplicas.Name, core.LabelDeploymentName.Key, "web")
+	assert.Equal(t, int64(1), value)
+	value, _ = labeledValue(namespace, core.MetricDeploymentUpdatedReplicas.Name, core.LabelDeploymentName.Key, "web")
+	assert.Equal(t, int64(2), value)
+}
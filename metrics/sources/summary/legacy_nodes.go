@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+)
+
+var legacyNodesCount = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "heapster",
+		Subsystem: "kubelet_summary",
+		Name:      "legacy_nodes",
+		Help:      "Number of nodes scraped through the legacy stats API because their kubelet does not serve the Summary API.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(legacyNodesCount)
+}
+
+// LegacyNodes remembers the nodes whose kubelet does not serve the Summary API, so that they are
+// scraped through the legacy stats API in mixed-version clusters. The decision is kept as long as
+// the kubelet version of the node does not change: the Summary API is tried again once the kubelet
+// is upgraded. A nil LegacyNodes never falls back.
+type LegacyNodes struct {
+	lock sync.Mutex
+	// Kubelet version of the nodes without Summary API, by node name.
+	nodes map[string]string
+}
+
+func NewLegacyNodes() *LegacyNodes {
+	return &LegacyNodes{nodes: map[string]string{}}
+}
+
+// IsLegacy returns whether the kubelet of the node, at the given version, is known not to serve
+// the Summary API.
+func (this *LegacyNodes) IsLegacy(nodeName, kubeletVersion string) bool {
+	if this == nil {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	version, found := this.nodes[nodeName]
+	if found && version != kubeletVersion {
+		glog.Infof("Kubelet of node %s changed from %s to %s, trying the Summary API again", nodeName, version, kubeletVersion)
+		delete(this.nodes, nodeName)
+		legacyNodesCount.Set(float64(len(this.nodes)))
+		return false
+	}
+	return found
+}
+
+// SetLegacy records that the kubelet of the node, at the given version, does not serve the Summary
+// API.
+func (this *LegacyNodes) SetLegacy(nodeName, kubeletVersion string) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, found := this.nodes[nodeName]; !found {
+		glog.Infof("Kubelet %s of node %s does not serve the Summary API, falling back to the legacy stats API", kubeletVersion, nodeName)
+	}
+	this.nodes[nodeName] = kubeletVersion
+	legacyNodesCount.Set(float64(len(this.nodes)))
+}
+
+// Retain forgets the nodes which are not listed anymore.
+func (this *LegacyNodes) Retain(nodes []*kube_api.Node) {
+	if this == nil {
+		return
+	}
+	listed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		listed[node.Name] = true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for nodeName := range this.nodes {
+		if !listed[nodeName] {
+			delete(this.nodes, nodeName)
+		}
+	}
+	legacyNodesCount.Set(float64(len(this.nodes)))
+}
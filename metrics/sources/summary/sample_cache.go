@@ -0,0 +1,115 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package summary
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kube_api "k8s.io/api/core/v1"
+	. "k8s.io/heapster/metrics/core"
+)
+
+var reusedMetricSets = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "heapster",
+		Subsystem: "kubelet_summary",
+		Name:      "reused_metric_sets_count",
+		Help:      "Number of container and pod metric sets reused because kubelet reported no new sample since the previous scrape.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(reusedMetricSets)
+}
+
+// cachedMetricSet is a container metric set as decoded from the sample of the given time, or a
+// pod metric set as decoded from the samples identified by the fingerprint.
+type cachedMetricSet struct {
+	startTime   time.Time
+	sampleTime  time.Time
+	fingerprint string
+	metricSet   *MetricSet
+}
+
+// SampleCache keeps the container and pod metric sets decoded in the previous scrape of each
+// node, so that the containers and pods for which kubelet reports no new sample, e.g. terminated
+// containers, idle pods or containers whose stats are cached by cAdvisor between housekeeping
+// cycles, are not decoded again. A nil SampleCache caches nothing.
+type SampleCache struct {
+	lock  sync.Mutex
+	nodes map[string]map[string]*cachedMetricSet
+}
+
+func NewSampleCache() *SampleCache {
+	return &SampleCache{nodes: map[string]map[string]*cachedMetricSet{}}
+}
+
+// get returns the metric sets decoded in the previous scrape of the node, by key.
+func (this *SampleCache) get(nodeName string) map[string]*cachedMetricSet {
+	if this == nil {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.nodes[nodeName]
+}
+
+// set replaces the metric sets of the node by the ones of the latest scrape, dropping the
+// containers which are gone.
+func (this *SampleCache) set(nodeName string, metricSets map[string]*cachedMetricSet) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.nodes[nodeName] = metricSets
+}
+
+// Retain drops the metric sets of the nodes which are not listed anymore.
+func (this *SampleCache) Retain(nodes []*kube_api.Node) {
+	if this == nil {
+		return
+	}
+	listed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		listed[node.Name] = true
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for nodeName := range this.nodes {
+		if !listed[nodeName] {
+			delete(this.nodes, nodeName)
+		}
+	}
+}
+
+// copyMetricSet returns a copy of the metric set which can be modified by the processors
+// without altering the cached one. The labels of the labeled metrics are shared.
+func copyMetricSet(metricSet *MetricSet) *MetricSet {
+	result := *metricSet
+	result.Labels = make(map[string]string, len(metricSet.Labels))
+	for k, v := range metricSet.Labels {
+		result.Labels[k] = v
+	}
+	result.MetricValues = make(map[string]MetricValue, len(metricSet.MetricValues))
+	for k, v := range metricSet.MetricValues {
+		result.MetricValues[k] = v
+	}
+	result.LabeledMetrics = make([]LabeledMetric, len(metricSet.LabeledMetrics))
+	copy(result.LabeledMetrics, metricSet.LabeledMetrics)
+	return &result
+}
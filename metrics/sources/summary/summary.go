@@ -17,10 +17,13 @@ package summary
 import (
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	. "k8s.io/heapster/metrics/core"
 	"k8s.io/heapster/metrics/sources/kubelet"
+	"k8s.io/heapster/metrics/util/capture"
 
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
@@ -28,8 +31,6 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	kube_client "k8s.io/client-go/kubernetes"
 	v1listers "k8s.io/client-go/listers/core/v1"
-	"k8s.io/client-go/tools/cache"
-	"k8s.io/heapster/metrics/util"
 	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 )
 
@@ -48,6 +49,9 @@ var (
 // Prefix used for the LabelResourceID for volume metrics.
 const VolumeResourcePrefix = "Volume:"
 
+// Operating system of Windows nodes, as reported in the kubernetes.io/os label and the node status.
+const OperatingSystemWindows = "windows"
+
 func init() {
 	prometheus.MustRegister(summaryRequestLatency)
 }
@@ -58,25 +62,80 @@ type NodeInfo struct {
 	HostName       string
 	HostID         string
 	KubeletVersion string
+	// Number of images reported in the node status. Kubelet caps the reported list, see
+	// --node-status-max-images.
+	ImageCount int
+	// Operating system of the node, e.g. linux or windows. Windows kubelets report a subset of
+	// the summary stats, with different semantics.
+	OperatingSystem string
+	// Container runtime of the node, e.g. docker, containerd or cri-o.
+	ContainerRuntime string
+	// Whether the node is schedulable, true or false, reported by the legacy stats API.
+	Schedulable string
 }
 
+// How the stats of terminated containers are handled. Kubelet keeps reporting a restarted
+// container until the terminated instance is garbage collected, next to the one replacing it.
+const (
+	// The terminated instance is dropped.
+	TerminatedContainersDrop = "drop"
+	// The final sample of the terminated instance is exported as a separate metric set labeled
+	// with terminated=true.
+	TerminatedContainersLabel = "label"
+	// The cumulative metrics of the terminated instance are added to the instance replacing it,
+	// so the totals of the container and its pod include them. The collection start time of the
+	// totals is the start of the oldest terminated instance still reported.
+	TerminatedContainersMerge = "merge"
+)
+
 // Kubelet-provided metrics for pod and system container.
 type summaryMetricsSource struct {
-	node          NodeInfo
-	kubeletClient *kubelet.KubeletClient
+	node                 NodeInfo
+	kubeletClient        *kubelet.KubeletClient
+	notifier             *kubelet.ScrapeFailureNotifier
+	backoff              *kubelet.ScrapeBackoff
+	terminatedContainers string
+	cache                *SampleCache
+	// Nodes scraped through the legacy stats API, nil if the source does not fall back to it.
+	legacyNodes *LegacyNodes
+	// Container metric sets decoded in the previous and the current scrape, by cache key.
+	previous map[string]*cachedMetricSet
+	current  map[string]*cachedMetricSet
 }
 
-func NewSummaryMetricsSource(node NodeInfo, client *kubelet.KubeletClient) MetricsSource {
+func NewSummaryMetricsSource(node NodeInfo, client *kubelet.KubeletClient, notifier *kubelet.ScrapeFailureNotifier,
+	backoff *kubelet.ScrapeBackoff, terminatedContainers string, cache *SampleCache, legacyNodes *LegacyNodes) MetricsSource {
 	return &summaryMetricsSource{
-		node:          node,
-		kubeletClient: client,
+		node:                 node,
+		kubeletClient:        client,
+		notifier:             notifier,
+		backoff:              backoff,
+		terminatedContainers: terminatedContainers,
+		cache:                cache,
+		legacyNodes:          legacyNodes,
 	}
 }
 
+// newLegacyMetricsSource returns a source scraping the node through the legacy stats API, for
+// kubelets which do not serve the Summary API.
+func newLegacyMetricsSource(node NodeInfo, client *kubelet.KubeletClient, notifier *kubelet.ScrapeFailureNotifier,
+	backoff *kubelet.ScrapeBackoff) MetricsSource {
+	return kubelet.NewKubeletMetricsSource(node.Host, client, node.NodeName, node.HostName, node.HostID, node.Schedulable,
+		node.KubeletVersion, notifier, backoff)
+}
+
 func (this *summaryMetricsSource) Name() string {
 	return this.String()
 }
 
+func (this *summaryMetricsSource) NodeName() string {
+	return this.node.NodeName
+}
+
+func (this *summaryMetricsSource) KubeletVersion() string {
+	return this.node.KubeletVersion
+}
+
 func (this *summaryMetricsSource) String() string {
 	return fmt.Sprintf("kubelet_summary:%s:%d", this.node.IP, this.node.Port)
 }
@@ -94,10 +153,18 @@ func (this *summaryMetricsSource) ScrapeMetrics(start, end time.Time) (*DataBatc
 		}()
 		return this.kubeletClient.GetSummary(this.node.Host)
 	}()
+	if kubelet.IsNotFoundError(err) && this.legacyNodes != nil {
+		// The kubelet predates the Summary API, it is scraped through the legacy stats API from now on.
+		this.legacyNodes.SetLegacy(this.node.NodeName, this.node.KubeletVersion)
+		return newLegacyMetricsSource(this.node, this.kubeletClient, this.notifier, this.backoff).ScrapeMetrics(start, end)
+	}
+	this.notifier.Observe(this.node.NodeName, err)
+	this.backoff.Observe(this.node.NodeName, err)
 
 	if err != nil {
 		return nil, err
 	}
+	capture.Summary(this.node.NodeName, summary)
 
 	result.MetricSets = this.decodeSummary(summary)
 
@@ -105,8 +172,9 @@ func (this *summaryMetricsSource) ScrapeMetrics(start, end time.Time) (*DataBatc
 }
 
 const (
-	RootFsKey = "/"
-	LogsKey   = "logs"
+	RootFsKey  = "/"
+	LogsKey    = "logs"
+	ImageFsKey = "imagefs"
 )
 
 // For backwards compatibility, map summary system names into original names.
@@ -116,10 +184,20 @@ var systemNameMap = map[string]string{
 	stats.SystemContainerMisc:    "system",
 }
 
+// Names of the runtime system container by container runtime, as reported in the node status.
+// The runtime of nodes which do not report one is assumed to be docker.
+var runtimeContainerNames = map[string]string{
+	"docker":     "docker-daemon",
+	"containerd": "containerd",
+	"cri-o":      "crio",
+}
+
 // decodeSummary translates the kubelet statsSummary API into the flattened heapster MetricSet API.
 func (this *summaryMetricsSource) decodeSummary(summary *stats.Summary) map[string]*MetricSet {
 	glog.V(9).Infof("Begin summary decode")
 	result := map[string]*MetricSet{}
+	this.previous = this.cache.get(this.node.NodeName)
+	this.current = map[string]*cachedMetricSet{}
 
 	labels := map[string]string{
 		LabelNodename.Key: this.node.NodeName,
@@ -128,9 +206,15 @@ func (this *summaryMetricsSource) decodeSummary(summary *stats.Summary) map[stri
 	}
 
 	this.decodeNodeStats(result, labels, &summary.Node)
+	nodeMetrics := result[NodeKey(summary.Node.NodeName)]
 	for _, pod := range summary.Pods {
 		this.decodePodStats(result, labels, &pod)
+		ref := pod.PodRef
+		nodeMetrics.LabeledMetrics = appendAcceleratorMetrics(nodeMetrics.LabeledMetrics,
+			result[PodKeyWithUID(ref.Namespace, ref.Name, ref.UID)])
 	}
+	this.decodeNodeCounts(result, summary.Node.NodeName)
+	this.cache.set(this.node.NodeName, this.current)
 
 	glog.V(9).Infof("End summary decode")
 	return result
@@ -162,17 +246,166 @@ func (this *summaryMetricsSource) decodeNodeStats(metrics map[string]*MetricSet,
 	this.decodeNetworkStats(nodeMetrics, node.Network)
 	this.decodeFsStats(nodeMetrics, RootFsKey, node.Fs)
 	this.decodeEphemeralStorageStats(nodeMetrics, node.Fs)
+	this.decodeNodeEphemeralStorageStats(nodeMetrics, node.Fs)
+	if node.Runtime != nil {
+		this.decodeFsStats(nodeMetrics, ImageFsKey, node.Runtime.ImageFs)
+	}
 	metrics[NodeKey(node.NodeName)] = nodeMetrics
 
 	for _, container := range node.SystemContainers {
 		key := NodeContainerKey(node.NodeName, this.getSystemContainerName(&container))
-		containerMetrics := this.decodeContainerStats(labels, &container, true)
+		containerMetrics := this.decodeCachedContainerStats(key, labels, &container, true)
 		containerMetrics.Labels[LabelMetricSetType.Key] = MetricSetTypeSystemContainer
+		if container.Name == stats.SystemContainerRuntime && this.node.ContainerRuntime != "" {
+			containerMetrics.Labels[LabelContainerRuntime.Key] = this.node.ContainerRuntime
+		}
 		metrics[key] = containerMetrics
 	}
 }
 
+// decodeNodeCounts adds the number of pods, containers and images on the node to the node MetricSet.
+func (this *summaryMetricsSource) decodeNodeCounts(metrics map[string]*MetricSet, nodeName string) {
+	nodeMetrics, found := metrics[NodeKey(nodeName)]
+	if !found {
+		return
+	}
+	var pods, containers int64
+	for _, ms := range metrics {
+		switch ms.Labels[LabelMetricSetType.Key] {
+		case MetricSetTypePod:
+			pods++
+		case MetricSetTypePodContainer:
+			if ms.Labels[LabelContainerTerminated.Key] != "true" {
+				containers++
+			}
+		}
+	}
+	nodeMetrics.MetricValues[MetricNodePodCount.Name] = MetricValue{
+		ValueType:  ValueInt64,
+		MetricType: MetricNodePodCount.Type,
+		IntValue:   pods,
+	}
+	nodeMetrics.MetricValues[MetricNodeContainerCount.Name] = MetricValue{
+		ValueType:  ValueInt64,
+		MetricType: MetricNodeContainerCount.Type,
+		IntValue:   containers,
+	}
+	nodeMetrics.MetricValues[MetricNodeImageCount.Name] = MetricValue{
+		ValueType:  ValueInt64,
+		MetricType: MetricNodeImageCount.Type,
+		IntValue:   int64(this.node.ImageCount),
+	}
+}
+
 func (this *summaryMetricsSource) decodePodStats(metrics map[string]*MetricSet, nodeLabels map[string]string, pod *stats.PodStats) {
+	ref := pod.PodRef
+	key := PodKeyWithUID(ref.Namespace, ref.Name, ref.UID)
+	podMetrics := this.decodeCachedPodStats(key, nodeLabels, pod)
+	metrics[key] = podMetrics
+
+	// If kubelet reports two containers with the same name, the older one has terminated.
+	running := map[string]*stats.ContainerStats{}
+	terminated := []*stats.ContainerStats{}
+	for i := range pod.Containers {
+		container := &pod.Containers[i]
+		previous, exist := running[container.Name]
+		if !exist {
+			running[container.Name] = container
+			continue
+		}
+		glog.V(2).Infof("Metrics reported from two containers with the same name: %s/%s/%s. Create time of "+
+			"containers are %v and %v. The older container is handled as terminated (%s).", ref.Namespace, ref.Name,
+			container.Name, container.StartTime.Time, previous.StartTime.Time, this.terminatedContainers)
+		if container.StartTime.Time.Before(previous.StartTime.Time) {
+			terminated = append(terminated, container)
+		} else {
+			running[container.Name] = container
+			terminated = append(terminated, previous)
+		}
+	}
+	for name, container := range running {
+		key := PodContainerKeyWithUID(ref.Namespace, ref.Name, ref.UID, name)
+		metrics[key] = this.decodeCachedContainerStats(key, podMetrics.Labels, container, false)
+		// An accelerator is attached to a single container, so its metrics are also reported by
+		// the pod, labeled with the accelerator.
+		podMetrics.LabeledMetrics = appendAcceleratorMetrics(podMetrics.LabeledMetrics, metrics[key])
+	}
+	for _, container := range terminated {
+		key := PodContainerKeyWithUID(ref.Namespace, ref.Name, ref.UID, container.Name)
+		this.decodeTerminatedContainerStats(metrics, key, podMetrics.Labels, container)
+	}
+}
+
+// decodeCachedPodStats returns a copy of the pod metric set decoded in the previous scrape if
+// kubelet reports the same samples of the pod, e.g. of long-idle pods, and decodes its stats
+// otherwise. Kubelet has no way to report only the pods which changed, so the whole summary is
+// still fetched.
+func (this *summaryMetricsSource) decodeCachedPodStats(key string, nodeLabels map[string]string, pod *stats.PodStats) *MetricSet {
+	fingerprint := podSampleFingerprint(pod)
+	if fingerprint == "" {
+		return this.decodePodMetrics(nodeLabels, pod)
+	}
+	cached, found := this.previous[key]
+	if !found || cached.fingerprint != fingerprint || !cached.startTime.Equal(pod.StartTime.Time) {
+		cached = &cachedMetricSet{
+			startTime:   pod.StartTime.Time,
+			fingerprint: fingerprint,
+			metricSet:   this.decodePodMetrics(nodeLabels, pod),
+		}
+	} else {
+		reusedMetricSets.Inc()
+	}
+	this.current[key] = cached
+	podMetrics := copyMetricSet(cached.metricSet)
+	this.decodeUptime(podMetrics, pod.StartTime.Time)
+	return podMetrics
+}
+
+// podSampleFingerprint identifies the samples the pod stats are decoded from by their times,
+// empty if kubelet reports none.
+func podSampleFingerprint(pod *stats.PodStats) string {
+	times := []time.Time{}
+	if pod.CPU != nil {
+		times = append(times, pod.CPU.Time.Time)
+	}
+	if pod.Memory != nil {
+		times = append(times, pod.Memory.Time.Time)
+	}
+	if pod.Network != nil {
+		times = append(times, pod.Network.Time.Time)
+	}
+	if pod.EphemeralStorage != nil {
+		times = append(times, pod.EphemeralStorage.Time.Time)
+	} else {
+		// The ephemeral storage usage is computed from the containers.
+		for _, container := range pod.Containers {
+			if container.Rootfs != nil {
+				times = append(times, container.Rootfs.Time.Time)
+			}
+			if container.Logs != nil {
+				times = append(times, container.Logs.Time.Time)
+			}
+		}
+	}
+	for _, volume := range pod.VolumeStats {
+		times = append(times, volume.Time.Time)
+	}
+	sampled := false
+	fingerprint := make([]byte, 0, 16*len(times))
+	for _, t := range times {
+		if !t.IsZero() {
+			sampled = true
+		}
+		fingerprint = strconv.AppendInt(fingerprint, t.UnixNano(), 36)
+		fingerprint = append(fingerprint, ',')
+	}
+	if !sampled {
+		return ""
+	}
+	return string(fingerprint)
+}
+
+func (this *summaryMetricsSource) decodePodMetrics(nodeLabels map[string]string, pod *stats.PodStats) *MetricSet {
 	glog.V(9).Infof("Decoding pod stats for pod %s/%s (%s)...", pod.PodRef.Namespace, pod.PodRef.Name, pod.PodRef.UID)
 	podMetrics := &MetricSet{
 		Labels:              this.cloneLabels(nodeLabels),
@@ -191,26 +424,75 @@ func (this *summaryMetricsSource) decodePodStats(metrics map[string]*MetricSet,
 	this.decodeNetworkStats(podMetrics, pod.Network)
 	this.decodeCPUStats(podMetrics, pod.CPU)
 	this.decodeMemoryStats(podMetrics, pod.Memory)
-	this.decodeEphemeralStorageStats(podMetrics, pod.EphemeralStorage)
-	for _, vol := range pod.VolumeStats {
-		this.decodeFsStats(podMetrics, VolumeResourcePrefix+vol.Name, &vol.FsStats)
+	if pod.EphemeralStorage != nil {
+		this.decodeEphemeralStorageStats(podMetrics, pod.EphemeralStorage)
+	} else {
+		this.decodePodEphemeralStorageFallback(podMetrics, pod)
+	}
+	for i := range pod.VolumeStats {
+		this.decodeVolumeStats(podMetrics, &pod.VolumeStats[i])
 	}
-	metrics[PodKey(ref.Namespace, ref.Name)] = podMetrics
+	return podMetrics
+}
 
-	for _, container := range pod.Containers {
-		key := PodContainerKey(ref.Namespace, ref.Name, container.Name)
-		// This check ensures that we are not replacing metrics of running container with metrics of terminated one if
-		// there are two exactly same containers reported by kubelet.
-		if _, exist := metrics[key]; exist {
-			glog.V(2).Infof("Metrics reported from two containers with the same key: %v. Create time of "+
-				"containers are %v and %v. Metrics from the older container are going to be dropped.", key,
-				container.StartTime.Time, metrics[key].CollectionStartTime)
-			if container.StartTime.Time.Before(metrics[key].CollectionStartTime) {
-				continue
-			}
+// decodeTerminatedContainerStats handles the stats of a terminated instance of the container
+// with the given key according to the configured policy.
+func (this *summaryMetricsSource) decodeTerminatedContainerStats(metrics map[string]*MetricSet, key string,
+	podLabels map[string]string, container *stats.ContainerStats) {
+	if this.terminatedContainers != TerminatedContainersLabel && this.terminatedContainers != TerminatedContainersMerge {
+		return
+	}
+	terminatedKey := TerminatedContainerKey(key, container.StartTime.Time)
+	containerMetrics := this.decodeCachedContainerStats(terminatedKey, podLabels, container, false)
+	// The uptime keeps growing after the container terminated.
+	delete(containerMetrics.MetricValues, MetricUptime.Name)
+
+	if this.terminatedContainers == TerminatedContainersLabel {
+		containerMetrics.Labels[LabelContainerTerminated.Key] = "true"
+		metrics[terminatedKey] = containerMetrics
+		return
+	}
+
+	replacement := metrics[key]
+	// The totals are collected since the oldest instance merged into them started. Kubelet
+	// garbage collects the oldest terminated instances first, which lowers the totals, so the
+	// changed collection start time keeps rates from being computed across the drop.
+	if container.StartTime.Time.Before(replacement.CollectionStartTime) {
+		replacement.CollectionStartTime = container.StartTime.Time
+	}
+	for name, value := range containerMetrics.MetricValues {
+		total, found := replacement.MetricValues[name]
+		if value.MetricType != MetricCumulative || !found || total.ValueType != value.ValueType {
+			continue
+		}
+		total.IntValue += value.IntValue
+		total.FloatValue += value.FloatValue
+		replacement.MetricValues[name] = total
+	}
+}
+
+// decodeCachedContainerStats returns a copy of the metric set decoded in the previous scrape if
+// kubelet reports the same sample of the container, and decodes its stats otherwise.
+func (this *summaryMetricsSource) decodeCachedContainerStats(key string, podLabels map[string]string,
+	container *stats.ContainerStats, isSystemContainer bool) *MetricSet {
+	sampleTime := this.getScrapeTime(container.CPU, container.Memory, nil)
+	if sampleTime.IsZero() {
+		return this.decodeContainerStats(podLabels, container, isSystemContainer)
+	}
+	cached, found := this.previous[key]
+	if !found || !cached.sampleTime.Equal(sampleTime) || !cached.startTime.Equal(container.StartTime.Time) {
+		cached = &cachedMetricSet{
+			startTime:  container.StartTime.Time,
+			sampleTime: sampleTime,
+			metricSet:  this.decodeContainerStats(podLabels, container, isSystemContainer),
 		}
-		metrics[key] = this.decodeContainerStats(podMetrics.Labels, &container, false)
+	} else {
+		reusedMetricSets.Inc()
 	}
+	this.current[key] = cached
+	containerMetrics := copyMetricSet(cached.metricSet)
+	this.decodeUptime(containerMetrics, container.StartTime.Time)
+	return containerMetrics
 }
 
 func (this *summaryMetricsSource) decodeContainerStats(podLabels map[string]string, container *stats.ContainerStats, isSystemContainer bool) *MetricSet {
@@ -268,8 +550,49 @@ func (this *summaryMetricsSource) decodeEphemeralStorageStats(metrics *MetricSet
 	this.addIntMetric(metrics, &MetricEphemeralStorageUsage, storage.UsedBytes)
 }
 
+// decodeNodeEphemeralStorageStats adds the capacity of the node root filesystem, which backs the
+// ephemeral storage, and the space left on it. The capacity is replaced by the one reported in
+// the node status if the node autoscaling enricher finds the node.
+func (this *summaryMetricsSource) decodeNodeEphemeralStorageStats(metrics *MetricSet, storage *stats.FsStats) {
+	if storage == nil {
+		return
+	}
+	if storage.CapacityBytes != nil {
+		metrics.MetricValues[MetricNodeEphemeralStorageCapacity.Name] = MetricValue{
+			ValueType:  ValueFloat,
+			MetricType: MetricNodeEphemeralStorageCapacity.Type,
+			FloatValue: float64(*storage.CapacityBytes),
+		}
+	}
+	this.addIntMetric(metrics, &MetricNodeEphemeralStorageAvailable, storage.AvailableBytes)
+}
+
+// decodePodEphemeralStorageFallback computes the ephemeral storage usage of a pod for kubelets
+// which do not report it, as the sum of the rootfs and logs of its containers and of its volumes
+// not backed by a persistent volume claim, e.g. emptyDirs.
+func (this *summaryMetricsSource) decodePodEphemeralStorageFallback(metrics *MetricSet, pod *stats.PodStats) {
+	var usage uint64
+	for _, container := range pod.Containers {
+		if container.Rootfs == nil || container.Rootfs.UsedBytes == nil ||
+			container.Logs == nil || container.Logs.UsedBytes == nil {
+			glog.V(9).Infof("missing storage usage metric!")
+			return
+		}
+		usage += *container.Rootfs.UsedBytes + *container.Logs.UsedBytes
+	}
+	for _, vol := range pod.VolumeStats {
+		if vol.PVCRef == nil && vol.UsedBytes != nil {
+			usage += *vol.UsedBytes
+		}
+	}
+	if len(pod.Containers) == 0 && len(pod.VolumeStats) == 0 {
+		return
+	}
+	this.addIntMetric(metrics, &MetricEphemeralStorageUsage, &usage)
+}
+
 func (this *summaryMetricsSource) decodeEphemeralStorageStatsForContainer(metrics *MetricSet, rootfs *stats.FsStats, logs *stats.FsStats) {
-	if rootfs == nil || logs == nil {
+	if rootfs == nil || logs == nil || rootfs.UsedBytes == nil || logs.UsedBytes == nil {
 		glog.V(9).Infof("missing storage usage metric!")
 		return
 	}
@@ -285,12 +608,34 @@ func (this *summaryMetricsSource) decodeMemoryStats(metrics *MetricSet, memory *
 
 	this.addIntMetric(metrics, &MetricMemoryUsage, memory.UsageBytes)
 	this.addIntMetric(metrics, &MetricMemoryWorkingSet, memory.WorkingSetBytes)
+	if this.isWindows() {
+		// Windows kubelets report the commit charge as usage, and zero or nothing for the
+		// RSS and page faults, which Windows does not account.
+		this.addIntMetric(metrics, &MetricMemoryCommitted, memory.UsageBytes)
+		return
+	}
 	this.addIntMetric(metrics, &MetricMemoryRSS, memory.RSSBytes)
 	this.addIntMetric(metrics, &MetricMemoryPageFaults, memory.PageFaults)
 	this.addIntMetric(metrics, &MetricMemoryMajorPageFaults, memory.MajorPageFaults)
 }
 
 func (this *summaryMetricsSource) decodeAcceleratorStats(metrics *MetricSet, accelerators []stats.AcceleratorStats) {
+	if len(accelerators) == 0 {
+		return
+	}
+	// The totals are aggregated to pods, nodes, namespaces and the cluster.
+	count := uint64(len(accelerators))
+	var dutyCycle, memoryUsed, memoryTotal uint64
+	for _, accelerator := range accelerators {
+		dutyCycle += accelerator.DutyCycle
+		memoryUsed += accelerator.MemoryUsed
+		memoryTotal += accelerator.MemoryTotal
+	}
+	this.addIntMetric(metrics, &MetricGpuCount, &count)
+	this.addIntMetric(metrics, &MetricGpuUsage, &dutyCycle)
+	this.addIntMetric(metrics, &MetricGpuMemoryUsed, &memoryUsed)
+	this.addIntMetric(metrics, &MetricGpuMemoryTotal, &memoryTotal)
+
 	for _, accelerator := range accelerators {
 		acceleratorLabels := map[string]string{
 			LabelAcceleratorMake.Key:  accelerator.Make,
@@ -303,6 +648,18 @@ func (this *summaryMetricsSource) decodeAcceleratorStats(metrics *MetricSet, acc
 	}
 }
 
+// appendAcceleratorMetrics appends the metrics of the accelerators of the metric set, labeled
+// with the accelerator, to metrics.
+func appendAcceleratorMetrics(metrics []LabeledMetric, metricSet *MetricSet) []LabeledMetric {
+	for _, metric := range metricSet.LabeledMetrics {
+		switch metric.Name {
+		case MetricAcceleratorMemoryTotal.Name, MetricAcceleratorMemoryUsed.Name, MetricAcceleratorDutyCycle.Name:
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
+
 func (this *summaryMetricsSource) decodeNetworkStats(metrics *MetricSet, network *stats.NetworkStats) {
 	if network == nil {
 		glog.V(9).Infof("missing network metrics!")
@@ -321,10 +678,27 @@ func (this *summaryMetricsSource) decodeFsStats(metrics *MetricSet, fsKey string
 		return
 	}
 
-	fsLabels := map[string]string{LabelResourceID.Key: fsKey}
+	this.decodeFsStatsWithLabels(metrics, map[string]string{LabelResourceID.Key: fsKey}, fs)
+}
+
+// decodeVolumeStats adds the filesystem metrics of a pod volume, labeled with the claim backing
+// it if any.
+func (this *summaryMetricsSource) decodeVolumeStats(metrics *MetricSet, vol *stats.VolumeStats) {
+	fsLabels := map[string]string{LabelResourceID.Key: VolumeResourcePrefix + vol.Name}
+	if vol.PVCRef != nil {
+		fsLabels[LabelPersistentVolumeClaimName.Key] = vol.PVCRef.Name
+	}
+	this.decodeFsStatsWithLabels(metrics, fsLabels, &vol.FsStats)
+}
+
+func (this *summaryMetricsSource) decodeFsStatsWithLabels(metrics *MetricSet, fsLabels map[string]string, fs *stats.FsStats) {
 	this.addLabeledIntMetric(metrics, &MetricFilesystemUsage, fsLabels, fs.UsedBytes)
 	this.addLabeledIntMetric(metrics, &MetricFilesystemLimit, fsLabels, fs.CapacityBytes)
 	this.addLabeledIntMetric(metrics, &MetricFilesystemAvailable, fsLabels, fs.AvailableBytes)
+	if this.isWindows() {
+		// NTFS has no inode limit, Windows kubelets report zeros if anything.
+		return
+	}
 	this.addLabeledIntMetric(metrics, &MetricFilesystemInodes, fsLabels, fs.Inodes)
 	this.addLabeledIntMetric(metrics, &MetricFilesystemInodesFree, fsLabels, fs.InodesFree)
 }
@@ -400,7 +774,17 @@ func (this *summaryMetricsSource) addLabeledIntMetric(metrics *MetricSet, metric
 }
 
 // Translate system container names to the legacy names for backwards compatibility.
+func (this *summaryMetricsSource) isWindows() bool {
+	return this.node.OperatingSystem == OperatingSystemWindows
+}
+
 func (this *summaryMetricsSource) getSystemContainerName(c *stats.ContainerStats) string {
+	if c.Name == stats.SystemContainerRuntime && this.node.ContainerRuntime != "" {
+		if name, found := runtimeContainerNames[this.node.ContainerRuntime]; found {
+			return name
+		}
+		return this.node.ContainerRuntime
+	}
 	if legacyName, ok := systemNameMap[c.Name]; ok {
 		return legacyName
 	}
@@ -409,10 +793,14 @@ func (this *summaryMetricsSource) getSystemContainerName(c *stats.ContainerStats
 
 // TODO: The summaryProvider duplicates a lot of code from kubeletProvider, and should be refactored.
 type summaryProvider struct {
-	nodeLister       v1listers.NodeLister
-	reflector        *cache.Reflector
-	kubeletClient    *kubelet.KubeletClient
-	hostIDAnnotation string
+	nodeLister           v1listers.NodeLister
+	kubeletClient        *kubelet.KubeletClient
+	hostIDAnnotation     string
+	notifier             *kubelet.ScrapeFailureNotifier
+	backoff              *kubelet.ScrapeBackoff
+	terminatedContainers string
+	cache                *SampleCache
+	legacyNodes          *LegacyNodes
 }
 
 func (this *summaryProvider) GetMetricsSources() []MetricsSource {
@@ -422,14 +810,26 @@ func (this *summaryProvider) GetMetricsSources() []MetricsSource {
 		glog.Errorf("error while listing nodes: %v", err)
 		return sources
 	}
+	this.kubeletClient.ScaleToNodes(len(nodes))
+	this.backoff.Retain(nodes)
+	this.cache.Retain(nodes)
+	this.legacyNodes.Retain(nodes)
 
 	for _, node := range nodes {
+		if !this.backoff.ShouldScrape(node.Name) {
+			continue
+		}
 		info, err := this.getNodeInfo(node)
 		if err != nil {
 			glog.Errorf("%v", err)
 			continue
 		}
-		sources = append(sources, NewSummaryMetricsSource(info, this.kubeletClient))
+		if this.legacyNodes.IsLegacy(info.NodeName, info.KubeletVersion) {
+			sources = append(sources, newLegacyMetricsSource(info, this.kubeletClient, this.notifier, this.backoff))
+			continue
+		}
+		sources = append(sources, NewSummaryMetricsSource(info, this.kubeletClient, this.notifier, this.backoff,
+			this.terminatedContainers, this.cache, this.legacyNodes))
 	}
 	return sources
 }
@@ -443,23 +843,55 @@ func (this *summaryProvider) getNodeInfo(node *kube_api.Node) (NodeInfo, error)
 	if hostname == "" {
 		hostname = node.Name
 	}
+	host, err := kubelet.GetNodeHost(node, ip, this.kubeletClient.GetPort())
+	if err != nil {
+		return NodeInfo{}, err
+	}
 	hostID := node.Spec.ExternalID
 	if hostID == "" && this.hostIDAnnotation != "" {
 		hostID = node.Annotations[this.hostIDAnnotation]
 	}
 	info := NodeInfo{
-		NodeName: node.Name,
-		HostName: hostname,
-		HostID:   hostID,
-		Host: kubelet.Host{
-			IP:   ip,
-			Port: this.kubeletClient.GetPort(),
-		},
-		KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+		NodeName:         node.Name,
+		HostName:         hostname,
+		HostID:           hostID,
+		Host:             host,
+		KubeletVersion:   node.Status.NodeInfo.KubeletVersion,
+		ImageCount:       len(node.Status.Images),
+		OperatingSystem:  getNodeOperatingSystem(node),
+		ContainerRuntime: getNodeContainerRuntime(node),
+		Schedulable:      kubelet.GetNodeSchedulableStatus(node),
 	}
 	return info, nil
 }
 
+// getNodeContainerRuntime returns the name of the container runtime of the node from its status,
+// e.g. containerd for containerd://1.1.0, empty if the kubelet does not report it.
+func getNodeContainerRuntime(node *kube_api.Node) string {
+	version := node.Status.NodeInfo.ContainerRuntimeVersion
+	if i := strings.Index(version, "://"); i > 0 {
+		return strings.ToLower(version[:i])
+	}
+	return ""
+}
+
+// getNodeOperatingSystem returns the operating system of the node from its labels, falling back
+// to the node status for nodes registered by kubelets which do not label it.
+func getNodeOperatingSystem(node *kube_api.Node) string {
+	for _, label := range []string{"kubernetes.io/os", "beta.kubernetes.io/os"} {
+		if os := node.Labels[label]; os != "" {
+			return os
+		}
+	}
+	if os := node.Status.NodeInfo.OperatingSystem; os != "" {
+		return os
+	}
+	if strings.Contains(strings.ToLower(node.Status.NodeInfo.OSImage), OperatingSystemWindows) {
+		return OperatingSystemWindows
+	}
+	return ""
+}
+
 func NewSummaryProvider(uri *url.URL) (MetricsSourceProvider, error) {
 	opts := uri.Query()
 
@@ -467,6 +899,26 @@ func NewSummaryProvider(uri *url.URL) (MetricsSourceProvider, error) {
 	if len(opts["host_id_annotation"]) > 0 {
 		hostIDAnnotation = opts["host_id_annotation"][0]
 	}
+	terminatedContainers := TerminatedContainersDrop
+	if len(opts["terminated_containers"]) > 0 {
+		terminatedContainers = opts["terminated_containers"][0]
+		switch terminatedContainers {
+		case TerminatedContainersDrop, TerminatedContainersLabel, TerminatedContainersMerge:
+		default:
+			return nil, fmt.Errorf("unknown terminated_containers policy %q, expected drop, label or merge", terminatedContainers)
+		}
+	}
+	var legacyNodes *LegacyNodes
+	legacyFallback := true
+	if len(opts["legacy_fallback"]) > 0 {
+		var err error
+		if legacyFallback, err = strconv.ParseBool(opts["legacy_fallback"][0]); err != nil {
+			return nil, fmt.Errorf("invalid legacy_fallback %q: %v", opts["legacy_fallback"][0], err)
+		}
+	}
+	if legacyFallback {
+		legacyNodes = NewLegacyNodes()
+	}
 	// create clients
 	kubeConfig, kubeletConfig, err := kubelet.GetKubeConfigs(uri)
 	if err != nil {
@@ -477,13 +929,28 @@ func NewSummaryProvider(uri *url.URL) (MetricsSourceProvider, error) {
 	if err != nil {
 		return nil, err
 	}
+	notifier, err := kubelet.NewScrapeFailureNotifier(uri, kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	backoff, err := kubelet.NewScrapeBackoff(uri)
+	if err != nil {
+		return nil, err
+	}
 	// watch nodes
-	nodeLister, reflector, _ := util.GetNodeLister(kubeClient)
+	nodeLister, err := kubelet.GetNodeLister(uri)
+	if err != nil {
+		return nil, err
+	}
 
 	return &summaryProvider{
-		nodeLister:       nodeLister,
-		reflector:        reflector,
-		kubeletClient:    kubeletClient,
-		hostIDAnnotation: hostIDAnnotation,
+		nodeLister:           nodeLister,
+		kubeletClient:        kubeletClient,
+		hostIDAnnotation:     hostIDAnnotation,
+		notifier:             notifier,
+		backoff:              backoff,
+		terminatedContainers: terminatedContainers,
+		cache:                NewSampleCache(),
+		legacyNodes:          legacyNodes,
 	}, nil
 }
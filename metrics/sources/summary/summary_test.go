@@ -17,6 +17,7 @@ package summary
 import (
 	"encoding/json"
 	"net"
+	"net/http"
 	"net/http/httptest"
 	"strconv"
 	"strings"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	kube_api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	util "k8s.io/client-go/util/testing"
 	"k8s.io/heapster/metrics/core"
@@ -112,6 +114,7 @@ var nodeInfo = NodeInfo{
 	HostName:       "test-hostname",
 	HostID:         "1234567890",
 	KubeletVersion: "1.2",
+	ImageCount:     5,
 }
 
 type fakeSource struct {
@@ -147,6 +150,9 @@ func TestDecodeSummaryMetrics(t *testing.T) {
 				genTestSummaryContainer(stats.SystemContainerMisc, seedMisc),
 			},
 			Fs: genTestSummaryFsStats(seedNode),
+			Runtime: &stats.RuntimeStats{
+				ImageFs: genTestSummaryFsStats(seedNode),
+			},
 		},
 		Pods: []stats.PodStats{{
 			PodRef: stats.PodReference{
@@ -179,6 +185,7 @@ func TestDecodeSummaryMetrics(t *testing.T) {
 			}, {
 				Name:    "B",
 				FsStats: *genTestSummaryFsStats(seedPod1),
+				PVCRef:  &stats.PVCReference{Name: "claim", Namespace: namespace0},
 			}},
 		}, {
 			PodRef: stats.PodReference{
@@ -256,7 +263,7 @@ func TestDecodeSummaryMetrics(t *testing.T) {
 		memory:           true,
 		network:          true,
 		ephemeralstorage: true,
-		fs:               []string{"/"},
+		fs:               []string{"/", "imagefs"},
 	}, {
 		key:     core.NodeContainerKey(nodeInfo.NodeName, "kubelet"),
 		setType: core.MetricSetTypeSystemContainer,
@@ -363,6 +370,32 @@ func TestDecodeSummaryMetrics(t *testing.T) {
 	}}
 
 	metrics := ms.decodeSummary(&summary)
+	nodeMetrics := metrics[core.NodeKey(nodeInfo.NodeName)]
+	checkIntMetric(t, nodeMetrics, "node", core.MetricNodePodCount, 6)
+	checkIntMetric(t, nodeMetrics, "node", core.MetricNodeContainerCount, 8)
+	checkIntMetric(t, nodeMetrics, "node", core.MetricNodeImageCount, 5)
+	checkIntMetric(t, nodeMetrics, "node", core.MetricNodeEphemeralStorageAvailable, seedNode+offsetFsAvailable)
+	assert.Equal(t, float64(seedNode+offsetFsCapacity), nodeMetrics.MetricValues[core.MetricNodeEphemeralStorageCapacity.Name].FloatValue)
+	// The kubelet does not report the ephemeral storage of pod1: it is the usage of its container
+	// and of its volumes, except the one backed by a persistent volume claim.
+	checkIntMetric(t, metrics[core.PodKey(namespace0, pName1)], "pod1", core.MetricEphemeralStorageUsage,
+		2*(seedPod1Container+offsetFsUsed)+seedPod1+offsetFsUsed)
+	for _, metric := range metrics[core.PodKey(namespace0, pName1)].LabeledMetrics {
+		if metric.Labels[core.LabelResourceID.Key] == "Volume:B" {
+			assert.Equal(t, "claim", metric.Labels[core.LabelPersistentVolumeClaimName.Key])
+		} else {
+			assert.NotContains(t, metric.Labels, core.LabelPersistentVolumeClaimName.Key)
+		}
+	}
+	// The metrics of the accelerator of pod5 are also reported by the pod and the node.
+	for _, key := range []string{core.PodKey(namespace0, pName5), core.NodeKey(nodeInfo.NodeName)} {
+		checkAcceleratorMetric(t, metrics[key], key, core.MetricAcceleratorDutyCycle, seedPod5Container0+offsetAcceleratorDutyCycle)
+		for _, metric := range metrics[key].LabeledMetrics {
+			if metric.Name == core.MetricAcceleratorDutyCycle.Name {
+				assert.NotEmpty(t, metric.Labels[core.LabelAcceleratorID.Key], key)
+			}
+		}
+	}
 	for _, e := range expectations {
 		m, ok := metrics[e.key]
 		if !assert.True(t, ok, "missing metric %q", e.key) {
@@ -391,6 +424,10 @@ func TestDecodeSummaryMetrics(t *testing.T) {
 			checkAcceleratorMetric(t, m, e.key, core.MetricAcceleratorMemoryTotal, e.seed+offsetAcceleratorMemoryTotal)
 			checkAcceleratorMetric(t, m, e.key, core.MetricAcceleratorMemoryUsed, e.seed+offsetAcceleratorMemoryUsed)
 			checkAcceleratorMetric(t, m, e.key, core.MetricAcceleratorDutyCycle, e.seed+offsetAcceleratorDutyCycle)
+			checkIntMetric(t, m, e.key, core.MetricGpuCount, 1)
+			checkIntMetric(t, m, e.key, core.MetricGpuUsage, e.seed+offsetAcceleratorDutyCycle)
+			checkIntMetric(t, m, e.key, core.MetricGpuMemoryUsed, e.seed+offsetAcceleratorMemoryUsed)
+			checkIntMetric(t, m, e.key, core.MetricGpuMemoryTotal, e.seed+offsetAcceleratorMemoryTotal)
 		}
 		if e.ephemeralstorage {
 			checkIntMetric(t, m, e.key, core.MetricEphemeralStorageUsage, e.seed+offsetFsUsed)
@@ -620,3 +657,316 @@ func TestScrapeSummaryMetrics(t *testing.T) {
 	assert.Nil(t, err, "scrape error")
 	assert.Equal(t, res.MetricSets["node:test"].Labels[core.LabelMetricSetType.Key], core.MetricSetTypeNode)
 }
+
+func TestLegacyFallback(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats/summary/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	legacyScrapes := 0
+	mux.HandleFunc("/stats/container/", func(w http.ResponseWriter, r *http.Request) {
+		legacyScrapes++
+		w.Write([]byte("{}"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ms := testingSummaryMetricsSource()
+	ms.legacyNodes = NewLegacyNodes()
+	split := strings.SplitN(strings.Replace(server.URL, "http://", "", 1), ":", 2)
+	ms.node.IP = net.ParseIP(split[0])
+	port, err := strconv.Atoi(split[1])
+	require.NoError(t, err)
+	ms.node.Port = port
+	ms.node.KubeletVersion = "v1.1.0"
+
+	_, err = ms.ScrapeMetrics(time.Now(), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, legacyScrapes, "legacy scrapes")
+	assert.True(t, ms.legacyNodes.IsLegacy(nodeInfo.NodeName, "v1.1.0"))
+	// The Summary API is tried again once the kubelet is upgraded.
+	assert.False(t, ms.legacyNodes.IsLegacy(nodeInfo.NodeName, "v1.2.0"))
+	assert.False(t, ms.legacyNodes.IsLegacy(nodeInfo.NodeName, "v1.1.0"))
+
+	ms.legacyNodes.SetLegacy(nodeInfo.NodeName, "v1.1.0")
+	ms.legacyNodes.Retain(nil)
+	assert.False(t, ms.legacyNodes.IsLegacy(nodeInfo.NodeName, "v1.1.0"), "removed node")
+
+	// Without fallback, the scrape fails.
+	ms.legacyNodes = nil
+	_, err = ms.ScrapeMetrics(time.Now(), time.Now())
+	assert.True(t, kubelet.IsNotFoundError(err), "expected not found, got %v", err)
+	assert.Equal(t, 1, legacyScrapes, "legacy scrapes")
+}
+
+func TestDecodeTerminatedContainers(t *testing.T) {
+	summary := stats.Summary{
+		Node: stats.NodeStats{
+			NodeName:  nodeInfo.NodeName,
+			StartTime: metav1.NewTime(startTime),
+		},
+		Pods: []stats.PodStats{{
+			PodRef: stats.PodReference{
+				Name:      pName0,
+				Namespace: namespace0,
+			},
+			StartTime: metav1.NewTime(startTime),
+			Containers: []stats.ContainerStats{
+				genTestSummaryTerminatedContainer(cName00, seedPod0Container0),
+				genTestSummaryContainer(cName00, seedPod0Container1),
+			},
+		}},
+	}
+	key := core.PodContainerKey(namespace0, pName0, cName00)
+	terminatedKey := core.TerminatedContainerKey(key, startTime.Add(-time.Minute))
+
+	ms := testingSummaryMetricsSource()
+	ms.terminatedContainers = TerminatedContainersDrop
+	metrics := ms.decodeSummary(&summary)
+	assert.NotContains(t, metrics, terminatedKey)
+	checkIntMetric(t, metrics[key], key, core.MetricCpuUsage, seedPod0Container1+offsetCPUUsageCoreSeconds)
+
+	ms.terminatedContainers = TerminatedContainersLabel
+	metrics = ms.decodeSummary(&summary)
+	checkIntMetric(t, metrics[key], key, core.MetricCpuUsage, seedPod0Container1+offsetCPUUsageCoreSeconds)
+	assert.NotContains(t, metrics[key].Labels, core.LabelContainerTerminated.Key)
+	if assert.Contains(t, metrics, terminatedKey) {
+		terminated := metrics[terminatedKey]
+		assert.Equal(t, "true", terminated.Labels[core.LabelContainerTerminated.Key])
+		assert.Equal(t, cName00, terminated.Labels[core.LabelContainerName.Key])
+		checkIntMetric(t, terminated, terminatedKey, core.MetricMemoryPageFaults, seedPod0Container0+offsetMemPageFaults)
+		assert.NotContains(t, terminated.MetricValues, core.MetricUptime.Name)
+	}
+	checkIntMetric(t, metrics[core.NodeKey(nodeInfo.NodeName)], "node", core.MetricNodeContainerCount, 1)
+
+	ms.terminatedContainers = TerminatedContainersMerge
+	metrics = ms.decodeSummary(&summary)
+	assert.NotContains(t, metrics, terminatedKey)
+	// Cumulative metrics include the terminated container, gauges do not.
+	checkIntMetric(t, metrics[key], key, core.MetricMemoryPageFaults,
+		seedPod0Container0+seedPod0Container1+2*offsetMemPageFaults)
+	checkIntMetric(t, metrics[key], key, core.MetricMemoryUsage, seedPod0Container1+offsetMemUsageBytes)
+	assert.Equal(t, startTime.Add(-time.Minute), metrics[key].CollectionStartTime)
+
+	// Once the terminated container is garbage collected, the totals drop and their collection start
+	// time changes, so that no negative rate is computed.
+	summary.Pods[0].Containers = summary.Pods[0].Containers[1:]
+	metrics = ms.decodeSummary(&summary)
+	checkIntMetric(t, metrics[key], key, core.MetricMemoryPageFaults, seedPod0Container1+offsetMemPageFaults)
+	assert.Equal(t, startTime, metrics[key].CollectionStartTime)
+}
+
+func TestReuseUnchangedContainers(t *testing.T) {
+	summary := stats.Summary{
+		Node: stats.NodeStats{
+			NodeName:  nodeInfo.NodeName,
+			StartTime: metav1.NewTime(startTime),
+		},
+		Pods: []stats.PodStats{{
+			PodRef: stats.PodReference{
+				Name:      pName0,
+				Namespace: namespace0,
+			},
+			StartTime: metav1.NewTime(startTime),
+			Containers: []stats.ContainerStats{
+				genTestSummaryTerminatedContainer(cName00, seedPod0Container0),
+				genTestSummaryContainer(cName00, seedPod0Container1),
+			},
+		}},
+	}
+	key := core.PodContainerKey(namespace0, pName0, cName00)
+	terminatedKey := core.TerminatedContainerKey(key, startTime.Add(-time.Minute))
+
+	ms := testingSummaryMetricsSource()
+	ms.terminatedContainers = TerminatedContainersLabel
+	ms.cache = NewSampleCache()
+	first := ms.decodeSummary(&summary)
+	cached := ms.cache.get(nodeInfo.NodeName)
+	require.Contains(t, cached, key)
+	require.Contains(t, cached, terminatedKey)
+	// Processors may modify the metric sets of the batch without altering the cached ones.
+	first[key].Labels[core.LabelPodNamespaceUID.Key] = "uid"
+	first[key].MetricValues[core.MetricCpuRequest.Name] = core.MetricValue{IntValue: 100}
+
+	second := ms.decodeSummary(&summary)
+	assert.Equal(t, cached[key], ms.cache.get(nodeInfo.NodeName)[key])
+	assert.NotContains(t, second[key].Labels, core.LabelPodNamespaceUID.Key)
+	assert.NotContains(t, second[key].MetricValues, core.MetricCpuRequest.Name)
+	checkIntMetric(t, second[key], key, core.MetricCpuUsage, seedPod0Container1+offsetCPUUsageCoreSeconds)
+	assert.Contains(t, second[key].MetricValues, core.MetricUptime.Name)
+	assert.Equal(t, first[terminatedKey].MetricValues, second[terminatedKey].MetricValues)
+	assert.NotContains(t, second[terminatedKey].MetricValues, core.MetricUptime.Name)
+
+	// New samples are decoded.
+	summary.Pods[0].Containers[1] = genTestSummaryContainer(cName00, seedPod0Container1+10)
+	summary.Pods[0].Containers[1].CPU.Time = metav1.NewTime(scrapeTime.Add(time.Second))
+	third := ms.decodeSummary(&summary)
+	assert.NotEqual(t, cached[key], ms.cache.get(nodeInfo.NodeName)[key])
+	assert.Equal(t, cached[terminatedKey], ms.cache.get(nodeInfo.NodeName)[terminatedKey])
+	checkIntMetric(t, third[key], key, core.MetricCpuUsage, seedPod0Container1+10+offsetCPUUsageCoreSeconds)
+
+	// Containers which are gone are dropped from the cache.
+	summary.Pods = nil
+	ms.decodeSummary(&summary)
+	assert.Empty(t, ms.cache.get(nodeInfo.NodeName))
+	ms.cache.Retain(nil)
+	assert.Empty(t, ms.cache.nodes)
+}
+
+func TestReuseUnchangedPods(t *testing.T) {
+	rxBytes := uint64(100)
+	used := uint64(4000)
+	summary := stats.Summary{
+		Node: stats.NodeStats{
+			NodeName:  nodeInfo.NodeName,
+			StartTime: metav1.NewTime(startTime),
+		},
+		Pods: []stats.PodStats{{
+			PodRef:    stats.PodReference{Name: pName0, Namespace: namespace0},
+			StartTime: metav1.NewTime(startTime),
+			Network: &stats.NetworkStats{
+				Time:           metav1.NewTime(scrapeTime),
+				InterfaceStats: stats.InterfaceStats{RxBytes: &rxBytes},
+			},
+			VolumeStats: []stats.VolumeStats{{
+				Name:    "data",
+				FsStats: stats.FsStats{Time: metav1.NewTime(scrapeTime), UsedBytes: &used},
+			}},
+		}},
+	}
+	key := core.PodKey(namespace0, pName0)
+
+	ms := testingSummaryMetricsSource()
+	ms.cache = NewSampleCache()
+	first := ms.decodeSummary(&summary)
+	cached := ms.cache.get(nodeInfo.NodeName)
+	require.Contains(t, cached, key)
+	first[key].MetricValues[core.MetricCpuRequest.Name] = core.MetricValue{IntValue: 100}
+
+	second := ms.decodeSummary(&summary)
+	assert.Equal(t, cached[key], ms.cache.get(nodeInfo.NodeName)[key])
+	assert.NotContains(t, second[key].MetricValues, core.MetricCpuRequest.Name)
+	checkIntMetric(t, second[key], key, core.MetricNetworkRx, int64(rxBytes))
+	assert.Contains(t, second[key].MetricValues, core.MetricUptime.Name)
+
+	// A new sample of any of the stats of the pod is decoded.
+	used = 5000
+	summary.Pods[0].VolumeStats[0].Time = metav1.NewTime(scrapeTime.Add(time.Second))
+	ms.decodeSummary(&summary)
+	assert.NotEqual(t, cached[key], ms.cache.get(nodeInfo.NodeName)[key])
+
+	// Pods without samples are always decoded.
+	assert.Empty(t, podSampleFingerprint(&stats.PodStats{}))
+}
+
+func TestDecodeWindowsSummary(t *testing.T) {
+	zero := uint64(0)
+	usage := uint64(3000)
+	workingSet := uint64(2000)
+	capacity := uint64(10000)
+	used := uint64(4000)
+	summary := stats.Summary{
+		Node: stats.NodeStats{
+			NodeName:  nodeInfo.NodeName,
+			StartTime: metav1.NewTime(startTime),
+			Memory: &stats.MemoryStats{
+				Time:            metav1.NewTime(scrapeTime),
+				UsageBytes:      &usage,
+				WorkingSetBytes: &workingSet,
+				RSSBytes:        &zero,
+				PageFaults:      &zero,
+			},
+			Fs: &stats.FsStats{CapacityBytes: &capacity, UsedBytes: &used, Inodes: &zero, InodesFree: &zero},
+		},
+		Pods: []stats.PodStats{{
+			PodRef:    stats.PodReference{Name: pName0, Namespace: namespace0},
+			StartTime: metav1.NewTime(startTime),
+			Containers: []stats.ContainerStats{{
+				Name:      cName00,
+				StartTime: metav1.NewTime(startTime),
+				Memory:    &stats.MemoryStats{Time: metav1.NewTime(scrapeTime), UsageBytes: &usage},
+				// Windows kubelets do not report the usage of the container logs.
+				Rootfs: &stats.FsStats{UsedBytes: &used},
+				Logs:   &stats.FsStats{},
+			}},
+		}},
+	}
+
+	ms := testingSummaryMetricsSource()
+	ms.node.OperatingSystem = OperatingSystemWindows
+	metrics := ms.decodeSummary(&summary)
+
+	node := metrics[core.NodeKey(nodeInfo.NodeName)]
+	checkIntMetric(t, node, "node", core.MetricMemoryCommitted, int64(usage))
+	checkIntMetric(t, node, "node", core.MetricMemoryWorkingSet, int64(workingSet))
+	assert.NotContains(t, node.MetricValues, core.MetricMemoryRSS.Name)
+	assert.NotContains(t, node.MetricValues, core.MetricMemoryPageFaults.Name)
+	checkFsMetric(t, node, "node", RootFsKey, core.MetricFilesystemUsage, int64(used))
+	for _, metric := range node.LabeledMetrics {
+		assert.NotEqual(t, core.MetricFilesystemInodes.Name, metric.Name)
+		assert.NotEqual(t, core.MetricFilesystemInodesFree.Name, metric.Name)
+	}
+
+	key := core.PodContainerKey(namespace0, pName0, cName00)
+	checkIntMetric(t, metrics[key], key, core.MetricMemoryCommitted, int64(usage))
+	assert.NotContains(t, metrics[key].MetricValues, core.MetricEphemeralStorageUsage.Name)
+
+	// Linux nodes keep reporting the RSS, and no committed memory.
+	ms.node.OperatingSystem = "linux"
+	metrics = ms.decodeSummary(&summary)
+	node = metrics[core.NodeKey(nodeInfo.NodeName)]
+	checkIntMetric(t, node, "node", core.MetricMemoryRSS, 0)
+	assert.NotContains(t, node.MetricValues, core.MetricMemoryCommitted.Name)
+}
+
+func TestGetNodeOperatingSystem(t *testing.T) {
+	for _, test := range []struct {
+		labels   map[string]string
+		info     kube_api.NodeSystemInfo
+		expected string
+	}{
+		{labels: map[string]string{"kubernetes.io/os": "windows"}, expected: "windows"},
+		{labels: map[string]string{"beta.kubernetes.io/os": "linux"}, expected: "linux"},
+		{info: kube_api.NodeSystemInfo{OperatingSystem: "windows"}, expected: "windows"},
+		{info: kube_api.NodeSystemInfo{OSImage: "Windows Server 2019 Datacenter"}, expected: "windows"},
+		{info: kube_api.NodeSystemInfo{OSImage: "Container-Optimized OS from Google"}, expected: ""},
+	} {
+		node := &kube_api.Node{
+			ObjectMeta: metav1.ObjectMeta{Labels: test.labels},
+			Status:     kube_api.NodeStatus{NodeInfo: test.info},
+		}
+		assert.Equal(t, test.expected, getNodeOperatingSystem(node), "%v %v", test.labels, test.info)
+	}
+}
+
+func TestRuntimeSystemContainerName(t *testing.T) {
+	runtime := &stats.ContainerStats{Name: stats.SystemContainerRuntime}
+	for version, expected := range map[string]string{
+		"":                     "docker-daemon",
+		"docker://17.3.2":      "docker-daemon",
+		"containerd://1.1.0":   "containerd",
+		"cri-o://1.9.10":       "crio",
+		"rkt://1.29.0":         "rkt",
+		"unknown runtime name": "docker-daemon",
+	} {
+		ms := testingSummaryMetricsSource()
+		ms.node.ContainerRuntime = getNodeContainerRuntime(&kube_api.Node{
+			Status: kube_api.NodeStatus{NodeInfo: kube_api.NodeSystemInfo{ContainerRuntimeVersion: version}},
+		})
+		assert.Equal(t, expected, ms.getSystemContainerName(runtime), version)
+		assert.Equal(t, "system", ms.getSystemContainerName(&stats.ContainerStats{Name: stats.SystemContainerMisc}), version)
+		assert.Equal(t, "kubelet", ms.getSystemContainerName(&stats.ContainerStats{Name: stats.SystemContainerKubelet}), version)
+	}
+
+	ms := testingSummaryMetricsSource()
+	ms.node.ContainerRuntime = "containerd"
+	metrics := map[string]*core.MetricSet{}
+	ms.decodeNodeStats(metrics, map[string]string{}, &stats.NodeStats{
+		NodeName:         nodeInfo.NodeName,
+		SystemContainers: []stats.ContainerStats{*runtime},
+	})
+	containerd := metrics[core.NodeContainerKey(nodeInfo.NodeName, "containerd")]
+	require.NotNil(t, containerd)
+	assert.Equal(t, "containerd", containerd.Labels[core.LabelContainerName.Key])
+	assert.Equal(t, "containerd", containerd.Labels[core.LabelContainerRuntime.Key])
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apiserver/pkg/util/logs"
+)
+
+// failureClass groups the fatal failures of Heapster by cause. Each class has its own exit code,
+// so that a crash loop can be diagnosed from the status of the pod.
+type failureClass struct {
+	name string
+	code int
+	hint string
+}
+
+var (
+	failureInternal = failureClass{
+		name: "internal error",
+		code: 1,
+	}
+	failureFlags = failureClass{
+		name: "invalid flags",
+		code: 2,
+		hint: "Check the command line flags, see heapster --help.",
+	}
+	failureSource = failureClass{
+		name: "invalid source",
+		code: 3,
+		hint: "Check the --source flags, see docs/source-configuration.md.",
+	}
+	failureSink = failureClass{
+		name: "sink failure",
+		code: 4,
+		hint: "Check the --sink flags and the credentials of the sinks, see docs/sink-configuration.md.",
+	}
+	failureKubeConfig = failureClass{
+		name: "Kubernetes configuration error",
+		code: 5,
+		hint: "Check the access of Heapster to the API server, e.g. its service account. Use --startup_grace to retry while the API server is unavailable.",
+	}
+	failureServing = failureClass{
+		name: "serving error",
+		code: 6,
+		hint: "Check that the port is free and that the TLS certificate and key are valid.",
+	}
+)
+
+// Kubernetes shows the contents of this file in the last state of the container.
+var terminationMessagePath = "/dev/termination-log"
+
+// Overridden in tests.
+var (
+	exit           = os.Exit
+	startupBackoff = time.Second
+)
+
+const maxStartupBackoff = 30 * time.Second
+
+// fail prints a summary of the failure and exits with the exit code of its class.
+func fail(class failureClass, format string, args ...interface{}) {
+	summary := failureSummary(class, fmt.Sprintf(format, args...))
+	glog.Errorf("%s", summary)
+	logs.FlushLogs()
+	fmt.Fprint(os.Stderr, summary)
+	// The file only exists when running in a Kubernetes container.
+	if _, err := os.Stat(terminationMessagePath); err == nil {
+		if err := ioutil.WriteFile(terminationMessagePath, []byte(summary), 0644); err != nil {
+			glog.Warningf("Failed to write the termination message: %v", err)
+		}
+	}
+	exit(class.code)
+}
+
+func failureSummary(class failureClass, message string) string {
+	summary := fmt.Sprintf("Heapster failed: %s\nFailure class: %s (exit code %d)\n", message, class.name, class.code)
+	if class.hint != "" {
+		summary += class.hint + "\n"
+	}
+	return summary
+}
+
+// retryStartup calls f until it succeeds or the grace period expires, and returns the last error.
+// It covers the failures which may be transient while a cluster starts, e.g. an API server or a
+// sink which is not reachable yet, so that Heapster does not crash loop because of them.
+func retryStartup(grace time.Duration, what string, f func() error) error {
+	deadline := time.Now().Add(grace)
+	backoff := startupBackoff
+	for {
+		err := f()
+		if err == nil || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		glog.Warningf("Failed to %s, retrying in %s: %v", what, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+}
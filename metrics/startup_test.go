@@ -0,0 +1,84 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "startup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "termination-log")
+	require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+
+	defer func(path string) { terminationMessagePath = path }(terminationMessagePath)
+	defer func() { exit = os.Exit }()
+	terminationMessagePath = path
+	code := 0
+	exit = func(c int) { code = c }
+
+	fail(failureSink, "failed to create %s sink: %v", "influxdb", errors.New("unauthorized"))
+	assert.Equal(t, 4, code)
+	message, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Heapster failed: failed to create influxdb sink: unauthorized\n"+
+		"Failure class: sink failure (exit code 4)\n"+failureSink.hint+"\n", string(message))
+
+	// Without a termination log, only the exit code is set.
+	terminationMessagePath = filepath.Join(dir, "missing")
+	fail(failureInternal, "failed")
+	assert.Equal(t, 1, code)
+	_, err = os.Stat(terminationMessagePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRetryStartup(t *testing.T) {
+	defer func(backoff time.Duration) { startupBackoff = backoff }(startupBackoff)
+	startupBackoff = time.Millisecond
+
+	calls := 0
+	failTwice := func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+	assert.NoError(t, retryStartup(time.Minute, "test", failTwice))
+	assert.Equal(t, 3, calls)
+
+	// Without grace, the first failure is returned.
+	calls = 0
+	assert.Error(t, retryStartup(0, "test", failTwice))
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	err := retryStartup(20*time.Millisecond, "test", func() error {
+		calls++
+		return errors.New("unavailable")
+	})
+	assert.EqualError(t, err, "unavailable")
+	assert.True(t, calls > 1)
+}
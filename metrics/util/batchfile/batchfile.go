@@ -0,0 +1,206 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package batchfile reads and writes data batches as JSON lines, one batch per line, so that
+// batches captured from a running Heapster can be replayed into sinks.
+//
+// Each line is an envelope {"version": N, "batch": {...}} holding the batch in the schema of
+// version N, which is independent of the in-memory types of Heapster, so that the batches written
+// by a release can be read by the later ones:
+//   - Fields may be added to a version. Readers ignore the fields they don't know, and fields
+//     missing from older lines take their zero value.
+//   - Any other change, e.g. renaming a field or changing its meaning, introduces a new version.
+//     Readers keep decoding all previous versions, and Migrate rewrites them in the current one.
+//   - Lines of a version newer than the reader are rejected with an error asking for an upgrade,
+//     instead of being silently misread.
+//
+// Lines without envelope are the batches written before the format was versioned, version 0.
+package batchfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+// Version of the schema the batches are written in.
+const Version = 1
+
+// envelope is a line of a batch file.
+type envelope struct {
+	Version int             `json:"version"`
+	Batch   json.RawMessage `json:"batch"`
+}
+
+// Encode returns the batch in the current version, without the trailing newline. It fails if
+// the batch holds values which cannot be represented in JSON, such as NaN.
+func Encode(batch *core.DataBatch) ([]byte, error) {
+	data, err := json.Marshal(toV1(batch))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&envelope{Version: Version, Batch: data})
+}
+
+// Decode returns the batch encoded in any supported version.
+func Decode(data []byte) (*core.DataBatch, error) {
+	var line envelope
+	if err := json.Unmarshal(data, &line); err != nil {
+		return nil, err
+	}
+	if line.Version == 0 && line.Batch == nil {
+		// Written before the format was versioned, the line is the batch itself.
+		batch := &core.DataBatch{}
+		if err := json.Unmarshal(data, batch); err != nil {
+			return nil, err
+		}
+		return batch, nil
+	}
+	switch line.Version {
+	case 1:
+		batch := &batchV1{}
+		if err := json.Unmarshal(line.Batch, batch); err != nil {
+			return nil, err
+		}
+		return fromV1(batch)
+	}
+	if line.Version > Version {
+		return nil, fmt.Errorf("batch of version %d is newer than the supported version %d, upgrade Heapster to read it", line.Version, Version)
+	}
+	return nil, fmt.Errorf("unsupported batch version %d", line.Version)
+}
+
+// Writer writes data batches to a stream, one per line.
+type Writer struct {
+	writer io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: w}
+}
+
+// Write appends the batch to the stream, in the current version.
+func (this *Writer) Write(batch *core.DataBatch) error {
+	data, err := Encode(batch)
+	if err != nil {
+		return err
+	}
+	_, err = this.writer.Write(append(data, '\n'))
+	return err
+}
+
+// Reader reads data batches written by a Writer of this or any previous version.
+type Reader struct {
+	reader *bufio.Reader
+	count  int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Read returns the next batch, or io.EOF once all batches were read.
+func (this *Reader) Read() (*core.DataBatch, error) {
+	for {
+		line, err := this.reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		batch, err := Decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode batch %d: %v", this.count+1, err)
+		}
+		this.count++
+		return batch, nil
+	}
+}
+
+// ReadFile returns all batches of the file.
+func ReadFile(path string) ([]*core.DataBatch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := NewReader(file)
+	var batches []*core.DataBatch
+	for {
+		batch, err := reader.Read()
+		if err == io.EOF {
+			return batches, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		batches = append(batches, batch)
+	}
+}
+
+// Migrate rewrites the batches of r, of any supported version, in the current version to w, and
+// returns the number of batches.
+func Migrate(r io.Reader, w io.Writer) (int, error) {
+	reader := NewReader(r)
+	writer := NewWriter(w)
+	for {
+		batch, err := reader.Read()
+		if err == io.EOF {
+			return reader.count, nil
+		}
+		if err != nil {
+			return reader.count, err
+		}
+		if err := writer.Write(batch); err != nil {
+			return reader.count, fmt.Errorf("failed to encode batch %d: %v", reader.count, err)
+		}
+	}
+}
+
+// MigrateFile rewrites the file in the current version in place, and returns the number of
+// batches. The file is left unchanged if any of its batches can't be migrated.
+func MigrateFile(path string) (int, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	count, err := Migrate(in, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), path)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batchfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+func newBatch(id string, timestamp time.Time) *core.DataBatch {
+	return &core.DataBatch{
+		ID:        id,
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			core.NodeKey("n1"): {
+				ScrapeTime: timestamp,
+				Labels:     map[string]string{core.LabelMetricSetType.Key: core.MetricSetTypeNode},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 42},
+				},
+				LabeledMetrics: []core.LabeledMetric{{
+					Name:        core.MetricFilesystemUsage.Name,
+					Labels:      map[string]string{core.LabelResourceID.Key: "/"},
+					MetricValue: core.MetricValue{ValueType: core.ValueFloat, MetricType: core.MetricGauge, FloatValue: 0.5},
+				}},
+			},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	batches := []*core.DataBatch{newBatch("b1", now), newBatch("b2", now.Add(time.Minute))}
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer)
+	for _, batch := range batches {
+		require.NoError(t, writer.Write(batch))
+	}
+	assert.Equal(t, 2, strings.Count(buffer.String(), "\n"))
+
+	dir, err := ioutil.TempDir("", "batchfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "batches.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, buffer.Bytes(), 0600))
+	read, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, batches, read)
+}
+
+func TestReadErrors(t *testing.T) {
+	reader := NewReader(strings.NewReader("{\"ID\": \"b1\"}\n{\"ID\": \n"))
+	batch, err := reader.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "b1", batch.ID)
+	_, err = reader.Read()
+	assert.Contains(t, err.Error(), "batch 2")
+
+	_, err = NewReader(strings.NewReader("")).Read()
+	assert.Equal(t, io.EOF, err)
+
+	batch = newBatch("nan", time.Now())
+	batch.MetricSets[core.NodeKey("n1")].LabeledMetrics[0].FloatValue = math.NaN()
+	assert.Error(t, NewWriter(ioutil.Discard).Write(batch))
+}
+
+func TestVersions(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	batch := newBatch("b1", now)
+	batch.Partial = true
+	batch.DedupKey = &core.DedupKey{Cluster: "c1", Replica: "r1", Timestamp: now}
+	batch.Part = &core.BatchPart{Index: 2, Last: true}
+	data, err := Encode(batch)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), `{"version":1,"batch":{"timestamp":"2017-10-01T12:00:00Z","id":"b1"`), string(data))
+	decoded, err := Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, batch, decoded)
+
+	// Unknown fields, e.g. written by a later release adding fields, are ignored.
+	decoded, err = Decode([]byte(`{"version":1,"future":true,"batch":{"id":"b2","metricSets":{"node:n1":{"size":3,` +
+		`"metrics":{"cpu/usage":{"type":"cumulative","int":42,"unit":"ns"}}}}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "b2", decoded.ID)
+	assert.Equal(t, core.MetricValue{ValueType: core.ValueInt64, MetricType: core.MetricCumulative, IntValue: 42},
+		decoded.MetricSets["node:n1"].MetricValues["cpu/usage"])
+
+	_, err = Decode([]byte(`{"version":2,"batch":{}}`))
+	assert.Contains(t, err.Error(), "upgrade")
+	_, err = Decode([]byte(`{"version":1,"batch":{"metricSets":{"n":{"metrics":{"m":{"type":"rate","int":1}}}}}}`))
+	assert.Error(t, err)
+	_, err = Decode([]byte(`{"version":1,"batch":{"metricSets":{"n":{"metrics":{"m":{"type":"gauge","int":1,"float":1}}}}}}`))
+	assert.Error(t, err)
+}
+
+func TestMigrate(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	batches := []*core.DataBatch{newBatch("b1", now), newBatch("b2", now.Add(time.Minute))}
+	// Batches written before the format was versioned.
+	var legacy bytes.Buffer
+	for _, batch := range batches {
+		data, err := json.Marshal(batch)
+		require.NoError(t, err)
+		legacy.Write(append(data, '\n'))
+	}
+
+	dir, err := ioutil.TempDir("", "batchfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "batches.jsonl")
+	require.NoError(t, ioutil.WriteFile(path, legacy.Bytes(), 0600))
+	read, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, batches, read)
+
+	count, err := MigrateFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(contents), `{"version":1,`))
+	read, err = ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, batches, read)
+
+	// Invalid files are left unchanged.
+	require.NoError(t, ioutil.WriteFile(path, []byte("{\"version\":3}\n"), 0600))
+	_, err = MigrateFile(path)
+	assert.Error(t, err)
+	contents, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"version\":3}\n", string(contents))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package batchfile
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+// Schema of version 1. Only fields may be added to it, see the package documentation.
+
+type batchV1 struct {
+	Timestamp  time.Time               `json:"timestamp"`
+	ID         string                  `json:"id,omitempty"`
+	Partial    bool                    `json:"partial,omitempty"`
+	DedupKey   *dedupKeyV1             `json:"dedupKey,omitempty"`
+	Part       *partV1                 `json:"part,omitempty"`
+	MetricSets map[string]*metricSetV1 `json:"metricSets"`
+}
+
+type dedupKeyV1 struct {
+	Cluster   string    `json:"cluster"`
+	Replica   string    `json:"replica"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type partV1 struct {
+	Index int  `json:"index"`
+	Last  bool `json:"last,omitempty"`
+}
+
+type metricSetV1 struct {
+	CollectionStartTime time.Time          `json:"collectionStartTime"`
+	EntityCreateTime    time.Time          `json:"entityCreateTime"`
+	ScrapeTime          time.Time          `json:"scrapeTime"`
+	Labels              map[string]string  `json:"labels,omitempty"`
+	Metrics             map[string]valueV1 `json:"metrics,omitempty"`
+	LabeledMetrics      []labeledMetricV1  `json:"labeledMetrics,omitempty"`
+}
+
+// valueV1 holds either an integer or a float value.
+type valueV1 struct {
+	// gauge, cumulative or delta.
+	Type  string   `json:"type"`
+	Int   *int64   `json:"int,omitempty"`
+	Float *float64 `json:"float,omitempty"`
+}
+
+type labeledMetricV1 struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	valueV1
+}
+
+var metricTypesV1 = map[core.MetricType]string{
+	core.MetricGauge:      "gauge",
+	core.MetricCumulative: "cumulative",
+	core.MetricDelta:      "delta",
+}
+
+func toV1(batch *core.DataBatch) *batchV1 {
+	result := &batchV1{
+		Timestamp:  batch.Timestamp,
+		ID:         batch.ID,
+		Partial:    batch.Partial,
+		MetricSets: make(map[string]*metricSetV1, len(batch.MetricSets)),
+	}
+	if batch.DedupKey != nil {
+		result.DedupKey = &dedupKeyV1{
+			Cluster:   batch.DedupKey.Cluster,
+			Replica:   batch.DedupKey.Replica,
+			Timestamp: batch.DedupKey.Timestamp,
+		}
+	}
+	if batch.Part != nil {
+		result.Part = &partV1{Index: batch.Part.Index, Last: batch.Part.Last}
+	}
+	for key, metricSet := range batch.MetricSets {
+		set := &metricSetV1{
+			CollectionStartTime: metricSet.CollectionStartTime,
+			EntityCreateTime:    metricSet.EntityCreateTime,
+			ScrapeTime:          metricSet.ScrapeTime,
+			Labels:              metricSet.Labels,
+			Metrics:             make(map[string]valueV1, len(metricSet.MetricValues)),
+		}
+		for name, value := range metricSet.MetricValues {
+			set.Metrics[name] = toValueV1(value)
+		}
+		for _, metric := range metricSet.LabeledMetrics {
+			set.LabeledMetrics = append(set.LabeledMetrics, labeledMetricV1{
+				Name:    metric.Name,
+				Labels:  metric.Labels,
+				valueV1: toValueV1(metric.MetricValue),
+			})
+		}
+		result.MetricSets[key] = set
+	}
+	return result
+}
+
+func toValueV1(value core.MetricValue) valueV1 {
+	result := valueV1{Type: metricTypesV1[value.MetricType]}
+	if value.ValueType == core.ValueFloat {
+		floatValue := value.FloatValue
+		result.Float = &floatValue
+	} else {
+		intValue := value.IntValue
+		result.Int = &intValue
+	}
+	return result
+}
+
+func fromV1(batch *batchV1) (*core.DataBatch, error) {
+	result := &core.DataBatch{
+		Timestamp:  batch.Timestamp,
+		ID:         batch.ID,
+		Partial:    batch.Partial,
+		MetricSets: make(map[string]*core.MetricSet, len(batch.MetricSets)),
+	}
+	if batch.DedupKey != nil {
+		result.DedupKey = &core.DedupKey{
+			Cluster:   batch.DedupKey.Cluster,
+			Replica:   batch.DedupKey.Replica,
+			Timestamp: batch.DedupKey.Timestamp,
+		}
+	}
+	if batch.Part != nil {
+		result.Part = &core.BatchPart{Index: batch.Part.Index, Last: batch.Part.Last}
+	}
+	for key, set := range batch.MetricSets {
+		if set == nil {
+			continue
+		}
+		metricSet := &core.MetricSet{
+			CollectionStartTime: set.CollectionStartTime,
+			EntityCreateTime:    set.EntityCreateTime,
+			ScrapeTime:          set.ScrapeTime,
+			Labels:              set.Labels,
+			MetricValues:        make(map[string]core.MetricValue, len(set.Metrics)),
+		}
+		if metricSet.Labels == nil {
+			metricSet.Labels = map[string]string{}
+		}
+		for name, value := range set.Metrics {
+			metricValue, err := fromValueV1(value)
+			if err != nil {
+				return nil, fmt.Errorf("metric %s of %s: %v", name, key, err)
+			}
+			metricSet.MetricValues[name] = metricValue
+		}
+		for _, metric := range set.LabeledMetrics {
+			metricValue, err := fromValueV1(metric.valueV1)
+			if err != nil {
+				return nil, fmt.Errorf("metric %s of %s: %v", metric.Name, key, err)
+			}
+			metricSet.LabeledMetrics = append(metricSet.LabeledMetrics, core.LabeledMetric{
+				Name:        metric.Name,
+				Labels:      metric.Labels,
+				MetricValue: metricValue,
+			})
+		}
+		result.MetricSets[key] = metricSet
+	}
+	return result, nil
+}
+
+func fromValueV1(value valueV1) (core.MetricValue, error) {
+	result := core.MetricValue{}
+	found := false
+	for metricType, name := range metricTypesV1 {
+		if name == value.Type {
+			result.MetricType = metricType
+			found = true
+		}
+	}
+	if !found {
+		return result, fmt.Errorf("unknown metric type %q", value.Type)
+	}
+	switch {
+	case value.Int != nil && value.Float == nil:
+		result.ValueType = core.ValueInt64
+		result.IntValue = *value.Int
+	case value.Float != nil && value.Int == nil:
+		result.ValueType = core.ValueFloat
+		result.FloatValue = *value.Float
+	default:
+		return result, fmt.Errorf("exactly one of int and float must be set")
+	}
+	return result, nil
+}
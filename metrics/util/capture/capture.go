@@ -0,0 +1,266 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capture records the first batches exported to the sinks and the kubelet summaries
+// they were built from into a fixtures directory, for the replay command and bug reports.
+// The values of sensitive labels are replaced by salted hashes, so that they cannot be
+// recovered but the metrics of a same entity can still be related.
+package capture
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util/batchfile"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+const (
+	// BatchesFile is the name of the file holding the captured batches, readable by batchfile.
+	BatchesFile = "batches.jsonl"
+	// SummariesDir is the name of the directory holding the captured kubelet summaries.
+	SummariesDir = "summaries"
+)
+
+// DefaultScrubbedLabels are the labels whose values identify the workloads and nodes of the
+// cluster.
+var DefaultScrubbedLabels = []string{
+	core.LabelLabels.Key,
+	core.LabelPodName.Key,
+	core.LabelNamespaceName.Key,
+	core.LabelNodename.Key,
+	core.LabelHostname.Key,
+	core.LabelHostID.Key,
+	core.LabelContainerBaseImage.Key,
+	core.LabelImageName.Key,
+	core.LabelPersistentVolumeClaimName.Key,
+	core.LabelPersistentVolumeName.Key,
+}
+
+var (
+	activeLock sync.Mutex
+	active     *Capturer
+)
+
+// Capturer is a sink writing the first batches it receives to the fixtures directory. While it
+// is capturing, the summaries passed to Summary are written too.
+type Capturer struct {
+	dir        string
+	maxBatches int
+	scrubbed   map[string]bool
+	salt       []byte
+
+	lock      sync.Mutex
+	file      *os.File
+	writer    *batchfile.Writer
+	batches   int
+	summaries map[string]int
+}
+
+// Enable starts capturing maxBatches batches into dir and returns the sink to export the
+// batches to.
+func Enable(dir string, maxBatches int, scrubbedLabels []string) (*Capturer, error) {
+	if maxBatches < 1 {
+		return nil, fmt.Errorf("at least one batch should be captured, got %d", maxBatches)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, SummariesDir), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(dir, BatchesFile))
+	if err != nil {
+		return nil, err
+	}
+	this := &Capturer{
+		dir:        dir,
+		maxBatches: maxBatches,
+		scrubbed:   map[string]bool{},
+		salt:       make([]byte, 16),
+		file:       file,
+		writer:     batchfile.NewWriter(file),
+		summaries:  map[string]int{},
+	}
+	if _, err := rand.Read(this.salt); err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, label := range scrubbedLabels {
+		this.scrubbed[label] = true
+	}
+	activeLock.Lock()
+	defer activeLock.Unlock()
+	active = this
+	glog.Infof("Capturing %d batches into %s", maxBatches, dir)
+	return this, nil
+}
+
+// Summary captures the summary scraped from the kubelet of the node, if a capture is running.
+func Summary(nodeName string, summary *stats.Summary) {
+	activeLock.Lock()
+	capturer := active
+	activeLock.Unlock()
+	if capturer != nil {
+		capturer.captureSummary(nodeName, summary)
+	}
+}
+
+func (this *Capturer) Name() string {
+	return "Capture Sink"
+}
+
+func (this *Capturer) ExportData(batch *core.DataBatch) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.file == nil {
+		return
+	}
+	if err := this.writer.Write(this.scrubBatch(batch)); err != nil {
+		glog.Errorf("[batch %s] Failed to capture the batch: %v", batch.ID, err)
+		return
+	}
+	this.batches++
+	if this.batches >= this.maxBatches {
+		this.closeLocked()
+		glog.Infof("Captured %d batches into %s", this.batches, this.dir)
+	}
+}
+
+func (this *Capturer) Stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closeLocked()
+}
+
+func (this *Capturer) closeLocked() {
+	if this.file == nil {
+		return
+	}
+	if err := this.file.Close(); err != nil {
+		glog.Errorf("Failed to close the captured batches: %v", err)
+	}
+	this.file = nil
+	activeLock.Lock()
+	defer activeLock.Unlock()
+	if active == this {
+		active = nil
+	}
+}
+
+func (this *Capturer) captureSummary(nodeName string, summary *stats.Summary) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.file == nil {
+		return
+	}
+	node := this.scrubLabel(core.LabelNodename.Key, nodeName)
+	this.summaries[node]++
+	data, err := json.MarshalIndent(this.scrubSummary(summary), "", "  ")
+	if err == nil {
+		path := filepath.Join(this.dir, SummariesDir, fmt.Sprintf("%s-%03d.json", node, this.summaries[node]))
+		err = ioutil.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		glog.Errorf("Failed to capture the summary of node %s: %v", nodeName, err)
+	}
+}
+
+// scrub returns a salted hash of the value, stable for the duration of the capture.
+func (this *Capturer) scrub(value string) string {
+	if value == "" {
+		return value
+	}
+	hash := sha256.New()
+	hash.Write(this.salt)
+	hash.Write([]byte(value))
+	return "scrubbed-" + hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+func (this *Capturer) scrubLabels(labels map[string]string, replacer *[]string) map[string]string {
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if this.scrubbed[k] && v != "" {
+			result[k] = this.scrub(v)
+			if replacer != nil {
+				*replacer = append(*replacer, v, result[k])
+			}
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// scrubBatch returns a copy of the batch with the values of the scrubbed labels replaced, in
+// the labels and in the keys of the metric sets.
+func (this *Capturer) scrubBatch(batch *core.DataBatch) *core.DataBatch {
+	result := &core.DataBatch{
+		Timestamp:  batch.Timestamp,
+		ID:         batch.ID,
+		MetricSets: make(map[string]*core.MetricSet, len(batch.MetricSets)),
+	}
+	for key, metricSet := range batch.MetricSets {
+		var replacements []string
+		scrubbed := *metricSet
+		scrubbed.Labels = this.scrubLabels(metricSet.Labels, &replacements)
+		scrubbed.LabeledMetrics = make([]core.LabeledMetric, len(metricSet.LabeledMetrics))
+		for i, metric := range metricSet.LabeledMetrics {
+			metric.Labels = this.scrubLabels(metric.Labels, nil)
+			scrubbed.LabeledMetrics[i] = metric
+		}
+		result.MetricSets[strings.NewReplacer(replacements...).Replace(key)] = &scrubbed
+	}
+	return result
+}
+
+// scrubLabel returns the scrubbed value if the label is scrubbed, the value otherwise.
+func (this *Capturer) scrubLabel(label, value string) string {
+	if this.scrubbed[label] {
+		return this.scrub(value)
+	}
+	return value
+}
+
+// scrubSummary returns a copy of the summary with the names of the node, pods, namespaces and
+// claims replaced like the labels holding them.
+func (this *Capturer) scrubSummary(summary *stats.Summary) *stats.Summary {
+	result := *summary
+	result.Node.NodeName = this.scrubLabel(core.LabelNodename.Key, summary.Node.NodeName)
+	result.Pods = make([]stats.PodStats, len(summary.Pods))
+	for i, pod := range summary.Pods {
+		pod.PodRef.Name = this.scrubLabel(core.LabelPodName.Key, pod.PodRef.Name)
+		pod.PodRef.Namespace = this.scrubLabel(core.LabelNamespaceName.Key, pod.PodRef.Namespace)
+		volumes := make([]stats.VolumeStats, len(pod.VolumeStats))
+		for j, volume := range pod.VolumeStats {
+			if volume.PVCRef != nil {
+				volume.PVCRef = &stats.PVCReference{
+					Name:      this.scrubLabel(core.LabelPersistentVolumeClaimName.Key, volume.PVCRef.Name),
+					Namespace: this.scrubLabel(core.LabelNamespaceName.Key, volume.PVCRef.Namespace),
+				}
+			}
+			volumes[j] = volume
+		}
+		pod.VolumeStats = volumes
+		result.Pods[i] = pod
+	}
+	return &result
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/util/batchfile"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+func newBatch(id string) *core.DataBatch {
+	return &core.DataBatch{
+		ID:        id,
+		Timestamp: time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC),
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("billing", "payments-0"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelNamespaceName.Key: "billing",
+					core.LabelPodName.Key:       "payments-0",
+					core.LabelPodId.Key:         "uid-1",
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricMemoryUsage.Name: {ValueType: core.ValueInt64, MetricType: core.MetricGauge, IntValue: 1024},
+				},
+				LabeledMetrics: []core.LabeledMetric{{
+					Name:   core.MetricFilesystemUsage.Name,
+					Labels: map[string]string{core.LabelResourceID.Key: "Volume:data", core.LabelPersistentVolumeClaimName.Key: "payments-data"},
+				}},
+			},
+		},
+	}
+}
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	capturer, err := Enable(dir, 2, DefaultScrubbedLabels)
+	require.NoError(t, err)
+	Summary("node-1", &stats.Summary{
+		Node: stats.NodeStats{NodeName: "node-1"},
+		Pods: []stats.PodStats{{PodRef: stats.PodReference{Name: "payments-0", Namespace: "billing", UID: "uid-1"}}},
+	})
+	original := newBatch("b1")
+	capturer.ExportData(original)
+	capturer.ExportData(newBatch("b2"))
+	// The capture is complete.
+	capturer.ExportData(newBatch("b3"))
+	Summary("node-1", &stats.Summary{})
+
+	batches, err := batchfile.ReadFile(filepath.Join(dir, BatchesFile))
+	require.NoError(t, err)
+	require.Len(t, batches, 2)
+	assert.Equal(t, "b1", batches[0].ID)
+	require.Len(t, batches[0].MetricSets, 1)
+	for key, metricSet := range batches[0].MetricSets {
+		assert.Equal(t, core.PodKey(metricSet.Labels[core.LabelNamespaceName.Key], metricSet.Labels[core.LabelPodName.Key]), key)
+		assert.True(t, strings.HasPrefix(metricSet.Labels[core.LabelPodName.Key], "scrubbed-"))
+		assert.Equal(t, "uid-1", metricSet.Labels[core.LabelPodId.Key])
+		assert.Equal(t, int64(1024), metricSet.MetricValues[core.MetricMemoryUsage.Name].IntValue)
+		assert.Equal(t, "Volume:data", metricSet.LabeledMetrics[0].Labels[core.LabelResourceID.Key])
+		assert.NotEqual(t, "payments-data", metricSet.LabeledMetrics[0].Labels[core.LabelPersistentVolumeClaimName.Key])
+	}
+	// The exported batch is not modified.
+	assert.Equal(t, "payments-0", original.MetricSets[core.PodKey("billing", "payments-0")].Labels[core.LabelPodName.Key])
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, SummariesDir))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	data, err := ioutil.ReadFile(filepath.Join(dir, SummariesDir, files[0].Name()))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "node-1")
+	assert.NotContains(t, string(data), "payments-0")
+	summary := &stats.Summary{}
+	require.NoError(t, json.Unmarshal(data, summary))
+	assert.Equal(t, "uid-1", summary.Pods[0].PodRef.UID)
+	assert.Equal(t, batches[0].MetricSets[core.PodKey(summary.Pods[0].PodRef.Namespace, summary.Pods[0].PodRef.Name)].Labels[core.LabelPodId.Key], "uid-1")
+}
+
+func TestCaptureValidation(t *testing.T) {
+	_, err := Enable(os.TempDir(), 0, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,389 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dashboards generates Grafana dashboards querying the metrics Heapster exports to its
+// sinks. The queries are built from the metric and label descriptors and from the naming of the
+// sinks, so the dashboards match the names Heapster currently emits.
+package dashboards
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/heapster/metrics/core"
+	"k8s.io/heapster/metrics/sinks/influxdb"
+	"k8s.io/heapster/metrics/util/openmetrics"
+)
+
+// Sinks the dashboards can query.
+const (
+	// The InfluxDB sink, queried with InfluxQL.
+	SinkInfluxdb = "influxdb"
+	// Sinks exporting the OpenMetrics names, e.g. heapster_cpu_usage_rate, queried with PromQL.
+	SinkPrometheus = "prometheus"
+)
+
+// Options parameterize the generated dashboards.
+type Options struct {
+	// Name of the cluster, as in the cluster_name label. Empty to query the metrics of all
+	// clusters.
+	Cluster string
+	// SinkInfluxdb or SinkPrometheus.
+	Sink string
+	// Whether the InfluxDB sink writes the metrics as fields, see its withfields option.
+	WithFields bool
+	// Name of the Grafana data source, empty for the default data source.
+	Datasource string
+}
+
+// panelSpec is a graph of a metric of the metric sets of a type, one series per value of a label.
+type panelSpec struct {
+	title   string
+	metric  core.Metric
+	setType string
+	groupBy core.LabelDescriptor
+	format  string
+}
+
+// dashboardSpec is a dashboard, optionally templated by namespace and pod.
+type dashboardSpec struct {
+	title     string
+	perPod    bool
+	panelRows [][]panelSpec
+}
+
+var specs = map[string]dashboardSpec{
+	"cluster": {
+		title: "Cluster",
+		panelRows: [][]panelSpec{
+			{
+				{"CPU usage by node (millicores)", core.MetricCpuUsageRate, core.MetricSetTypeNode, core.LabelNodename, "short"},
+				{"Memory usage by node", core.MetricMemoryUsage, core.MetricSetTypeNode, core.LabelNodename, "bytes"},
+			},
+			{
+				{"Network received by node", core.MetricNetworkRxRate, core.MetricSetTypeNode, core.LabelNodename, "Bps"},
+				{"Network sent by node", core.MetricNetworkTxRate, core.MetricSetTypeNode, core.LabelNodename, "Bps"},
+			},
+			{
+				{"CPU usage by namespace (millicores)", core.MetricCpuUsageRate, core.MetricSetTypeNamespace, core.LabelNamespaceName, "short"},
+				{"Memory usage by namespace", core.MetricMemoryUsage, core.MetricSetTypeNamespace, core.LabelNamespaceName, "bytes"},
+			},
+		},
+	},
+	"pods": {
+		title:  "Pods",
+		perPod: true,
+		panelRows: [][]panelSpec{
+			{
+				{"CPU usage by container (millicores)", core.MetricCpuUsageRate, core.MetricSetTypePodContainer, core.LabelContainerName, "short"},
+				{"Memory usage by container", core.MetricMemoryUsage, core.MetricSetTypePodContainer, core.LabelContainerName, "bytes"},
+			},
+			{
+				{"Network received", core.MetricNetworkRxRate, core.MetricSetTypePod, core.LabelPodName, "Bps"},
+				{"Network sent", core.MetricNetworkTxRate, core.MetricSetTypePod, core.LabelPodName, "Bps"},
+			},
+		},
+	},
+}
+
+// Names returns the names of the dashboards, sorted.
+func Names() []string {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Request is a dashboard as posted to the /api/dashboards/db endpoint of Grafana.
+type Request struct {
+	Dashboard *Dashboard `json:"dashboard"`
+	Overwrite bool       `json:"overwrite"`
+}
+
+type Dashboard struct {
+	ID            *int64     `json:"id"`
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []*Panel   `json:"panels"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []*Variable `json:"list"`
+}
+
+type Variable struct {
+	Name       string  `json:"name"`
+	Label      string  `json:"label"`
+	Type       string  `json:"type"`
+	Datasource *string `json:"datasource"`
+	Query      string  `json:"query"`
+	Refresh    int     `json:"refresh"`
+	Sort       int     `json:"sort"`
+}
+
+type Panel struct {
+	ID         int64      `json:"id"`
+	Type       string     `json:"type"`
+	Title      string     `json:"title"`
+	Datasource *string    `json:"datasource"`
+	GridPos    GridPos    `json:"gridPos"`
+	Targets    []*Target  `json:"targets"`
+	Yaxes      []YAxis    `json:"yaxes"`
+	Legend     Legend     `json:"legend"`
+	Lines      bool       `json:"lines"`
+	Fill       int        `json:"fill"`
+	Linewidth  int        `json:"linewidth"`
+	Tooltip    PanelHover `json:"tooltip"`
+}
+
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Target is an InfluxQL query or a PromQL expression.
+type Target struct {
+	RefID        string `json:"refId"`
+	Query        string `json:"query,omitempty"`
+	RawQuery     bool   `json:"rawQuery,omitempty"`
+	ResultFormat string `json:"resultFormat,omitempty"`
+	Alias        string `json:"alias,omitempty"`
+	Expr         string `json:"expr,omitempty"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+type YAxis struct {
+	Format string `json:"format"`
+	Show   bool   `json:"show"`
+}
+
+type Legend struct {
+	Show bool `json:"show"`
+}
+
+type PanelHover struct {
+	Shared    bool   `json:"shared"`
+	ValueType string `json:"value_type"`
+}
+
+const (
+	panelHeight = 8
+	panelWidth  = 12
+)
+
+// Generate returns the dashboard with the given name.
+func Generate(name string, options Options) (*Request, error) {
+	spec, found := specs[name]
+	if !found {
+		return nil, fmt.Errorf("unknown dashboard %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	var query queryBuilder
+	switch options.Sink {
+	case SinkInfluxdb:
+		query = &influxdbQueries{options: options}
+	case SinkPrometheus:
+		query = &prometheusQueries{options: options}
+	default:
+		return nil, fmt.Errorf("unsupported sink %q, expected %s or %s", options.Sink, SinkInfluxdb, SinkPrometheus)
+	}
+	var datasource *string
+	if options.Datasource != "" {
+		datasource = &options.Datasource
+	}
+
+	dashboard := &Dashboard{
+		UID:           dashboardUID(name, options.Cluster),
+		Title:         spec.title,
+		Tags:          []string{"heapster", options.Sink},
+		Timezone:      "browser",
+		SchemaVersion: 16,
+		Refresh:       "1m",
+		Time:          TimeRange{From: "now-1h", To: "now"},
+		Templating:    Templating{List: []*Variable{}},
+	}
+	if options.Cluster != "" {
+		dashboard.Title = fmt.Sprintf("%s (%s)", spec.title, options.Cluster)
+	}
+	if spec.perPod {
+		for _, variable := range []*Variable{
+			{Name: "namespace", Label: "Namespace", Query: query.namespaces()},
+			{Name: "pod", Label: "Pod", Query: query.pods()},
+		} {
+			variable.Type = "query"
+			variable.Datasource = datasource
+			// Refreshed when the time range changes, so that new pods are listed.
+			variable.Refresh = 2
+			variable.Sort = 1
+			dashboard.Templating.List = append(dashboard.Templating.List, variable)
+		}
+	}
+	id := int64(1)
+	for row, panels := range spec.panelRows {
+		for column, panel := range panels {
+			dashboard.Panels = append(dashboard.Panels, &Panel{
+				ID:         id,
+				Type:       "graph",
+				Title:      panel.title,
+				Datasource: datasource,
+				GridPos:    GridPos{H: panelHeight, W: panelWidth, X: column * panelWidth, Y: row * panelHeight},
+				Targets:    []*Target{query.target(panel, spec.perPod)},
+				Yaxes:      []YAxis{{Format: panel.format, Show: true}, {Format: "short", Show: false}},
+				Legend:     Legend{Show: true},
+				Lines:      true,
+				Fill:       1,
+				Linewidth:  1,
+				Tooltip:    PanelHover{Shared: true, ValueType: "individual"},
+			})
+			id++
+		}
+	}
+	return &Request{Dashboard: dashboard, Overwrite: true}, nil
+}
+
+// dashboardUID returns a stable identifier of the dashboard, so that posting it again updates it.
+func dashboardUID(name, cluster string) string {
+	uid := "heapster-" + name
+	if cluster != "" {
+		uid += "-" + cluster
+	}
+	// The maximum length of Grafana identifiers.
+	if len(uid) > 40 {
+		uid = uid[:40]
+	}
+	return uid
+}
+
+// queryBuilder writes the queries of a sink.
+type queryBuilder interface {
+	target(panel panelSpec, perPod bool) *Target
+	namespaces() string
+	pods() string
+}
+
+type influxdbQueries struct {
+	options Options
+}
+
+func (this *influxdbQueries) target(panel panelSpec, perPod bool) *Target {
+	measurement, field := influxdb.MeasurementAndField(panel.metric.Name, this.options.WithFields)
+	conditions := []string{fmt.Sprintf(`"%s" = '%s'`, core.LabelMetricSetType.Key, panel.setType)}
+	conditions = append(conditions, this.clusterConditions()...)
+	if perPod {
+		conditions = append(conditions,
+			fmt.Sprintf(`"%s" =~ /^$namespace$/`, core.LabelNamespaceName.Key),
+			fmt.Sprintf(`"%s" =~ /^$pod$/`, core.LabelPodName.Key))
+	}
+	conditions = append(conditions, "$timeFilter")
+	return &Target{
+		RefID: "A",
+		Query: fmt.Sprintf(`SELECT mean("%s") FROM "%s" WHERE %s GROUP BY time($__interval), "%s" fill(null)`,
+			field, measurement, strings.Join(conditions, " AND "), panel.groupBy.Key),
+		RawQuery:     true,
+		ResultFormat: "time_series",
+		Alias:        "$tag_" + panel.groupBy.Key,
+	}
+}
+
+func (this *influxdbQueries) clusterConditions() []string {
+	if this.options.Cluster == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf(`"%s" = '%s'`, core.LabelClusterName.Key, escapeInfluxdb(this.options.Cluster))}
+}
+
+func (this *influxdbQueries) tagValues(key string, conditions []string) string {
+	measurement, _ := influxdb.MeasurementAndField(core.MetricCpuUsageRate.Name, this.options.WithFields)
+	query := fmt.Sprintf(`SHOW TAG VALUES FROM "%s" WITH KEY = "%s"`, measurement, key)
+	conditions = append(this.clusterConditions(), conditions...)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return query
+}
+
+func (this *influxdbQueries) namespaces() string {
+	return this.tagValues(core.LabelNamespaceName.Key, nil)
+}
+
+func (this *influxdbQueries) pods() string {
+	return this.tagValues(core.LabelPodName.Key, []string{fmt.Sprintf(`"%s" =~ /^$namespace$/`, core.LabelNamespaceName.Key)})
+}
+
+func escapeInfluxdb(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+type prometheusQueries struct {
+	options Options
+}
+
+func (this *prometheusQueries) selector(metric core.Metric, matchers ...string) string {
+	if this.options.Cluster != "" {
+		matchers = append(matchers, fmt.Sprintf(`%s="%s"`, openmetrics.LabelName(core.LabelClusterName.Key),
+			escapePrometheus(this.options.Cluster)))
+	}
+	return fmt.Sprintf("%s{%s}", openmetrics.MetricName(metric.Name, metric.Type), strings.Join(matchers, ","))
+}
+
+func (this *prometheusQueries) target(panel panelSpec, perPod bool) *Target {
+	matchers := []string{fmt.Sprintf(`%s="%s"`, openmetrics.LabelName(core.LabelMetricSetType.Key), panel.setType)}
+	if perPod {
+		matchers = append(matchers,
+			fmt.Sprintf(`%s="$namespace"`, openmetrics.LabelName(core.LabelNamespaceName.Key)),
+			fmt.Sprintf(`%s="$pod"`, openmetrics.LabelName(core.LabelPodName.Key)))
+	}
+	groupBy := openmetrics.LabelName(panel.groupBy.Key)
+	expr := this.selector(panel.metric, matchers...)
+	if panel.metric.Type == core.MetricCumulative {
+		expr = fmt.Sprintf("rate(%s[5m])", expr)
+	}
+	return &Target{
+		RefID:        "A",
+		Expr:         fmt.Sprintf("sum by (%s) (%s)", groupBy, expr),
+		LegendFormat: fmt.Sprintf("{{%s}}", groupBy),
+	}
+}
+
+func (this *prometheusQueries) namespaces() string {
+	return fmt.Sprintf("label_values(%s, %s)", this.selector(core.MetricCpuUsageRate,
+		fmt.Sprintf(`%s="%s"`, openmetrics.LabelName(core.LabelMetricSetType.Key), core.MetricSetTypePod)),
+		openmetrics.LabelName(core.LabelNamespaceName.Key))
+}
+
+func (this *prometheusQueries) pods() string {
+	return fmt.Sprintf("label_values(%s, %s)", this.selector(core.MetricCpuUsageRate,
+		fmt.Sprintf(`%s="%s"`, openmetrics.LabelName(core.LabelMetricSetType.Key), core.MetricSetTypePod),
+		fmt.Sprintf(`%s="$namespace"`, openmetrics.LabelName(core.LabelNamespaceName.Key))),
+		openmetrics.LabelName(core.LabelPodName.Key))
+}
+
+func escapePrometheus(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
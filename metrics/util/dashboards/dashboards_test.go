@@ -0,0 +1,68 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dashboards
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfluxdbDashboard(t *testing.T) {
+	request, err := Generate("cluster", Options{Cluster: "prod", Sink: SinkInfluxdb})
+	require.NoError(t, err)
+	dashboard := request.Dashboard
+	assert.Equal(t, "Cluster (prod)", dashboard.Title)
+	assert.Equal(t, "heapster-cluster-prod", dashboard.UID)
+	assert.Nil(t, dashboard.Panels[0].Datasource)
+	assert.Equal(t, `SELECT mean("value") FROM "cpu/usage_rate" WHERE "type" = 'node' AND "cluster_name" = 'prod' AND $timeFilter GROUP BY time($__interval), "nodename" fill(null)`,
+		dashboard.Panels[0].Targets[0].Query)
+	assert.Equal(t, "$tag_nodename", dashboard.Panels[0].Targets[0].Alias)
+	assert.Equal(t, GridPos{H: 8, W: 12, X: 12, Y: 0}, dashboard.Panels[1].GridPos)
+
+	request, err = Generate("pods", Options{Sink: SinkInfluxdb, WithFields: true, Datasource: "influxdb"})
+	require.NoError(t, err)
+	dashboard = request.Dashboard
+	assert.Equal(t, "influxdb", *dashboard.Panels[0].Datasource)
+	assert.Equal(t, `SELECT mean("usage_rate") FROM "cpu" WHERE "type" = 'pod_container' AND "namespace_name" =~ /^$namespace$/ AND "pod_name" =~ /^$pod$/ AND $timeFilter GROUP BY time($__interval), "container_name" fill(null)`,
+		dashboard.Panels[0].Targets[0].Query)
+	require.Equal(t, 2, len(dashboard.Templating.List))
+	assert.Equal(t, `SHOW TAG VALUES FROM "cpu" WITH KEY = "pod_name" WHERE "namespace_name" =~ /^$namespace$/`,
+		dashboard.Templating.List[1].Query)
+
+	_, err = json.Marshal(request)
+	assert.NoError(t, err)
+}
+
+func TestPrometheusDashboard(t *testing.T) {
+	request, err := Generate("pods", Options{Cluster: "prod", Sink: SinkPrometheus})
+	require.NoError(t, err)
+	dashboard := request.Dashboard
+	assert.Equal(t, `sum by (container_name) (heapster_cpu_usage_rate{type="pod_container",namespace_name="$namespace",pod_name="$pod",cluster_name="prod"})`,
+		dashboard.Panels[0].Targets[0].Expr)
+	assert.Equal(t, "{{container_name}}", dashboard.Panels[0].Targets[0].LegendFormat)
+	assert.Equal(t, `label_values(heapster_cpu_usage_rate{type="pod",cluster_name="prod"}, namespace_name)`,
+		dashboard.Templating.List[0].Query)
+}
+
+func TestInvalidDashboards(t *testing.T) {
+	assert.Equal(t, []string{"cluster", "pods"}, Names())
+	_, err := Generate("unknown", Options{Sink: SinkInfluxdb})
+	assert.Error(t, err)
+	_, err = Generate("cluster", Options{Sink: "graphite"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	kube_client "k8s.io/client-go/kubernetes"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	kube_config "k8s.io/heapster/common/kubernetes"
+)
+
+// Resync period of the shared informers.
+const informerResyncPeriod = time.Hour
+
+var (
+	informerFactoriesLock sync.Mutex
+	// Shared informer factories by the uri of the Kubernetes API server they watch.
+	informerFactories = map[string]informers.SharedInformerFactory{}
+	// Node listers filtered by selectors, by the uri of the Kubernetes API server and the selectors.
+	filteredNodeListers = map[string]v1listers.NodeLister{}
+)
+
+// GetSharedInformerFactory returns the informer factory for the Kubernetes API server configured
+// by uri. Sources, processors and the API handlers configured with the same uri share the factory,
+// and thus a single watch and cache per resource.
+func GetSharedInformerFactory(uri *url.URL) (informers.SharedInformerFactory, error) {
+	informerFactoriesLock.Lock()
+	defer informerFactoriesLock.Unlock()
+
+	key := uri.String()
+	if factory, found := informerFactories[key]; found {
+		return factory, nil
+	}
+	kubeClient, err := newKubeClient(uri)
+	if err != nil {
+		return nil, err
+	}
+	factory := informers.NewSharedInformerFactory(kubeClient, informerResyncPeriod)
+	informerFactories[key] = factory
+	return factory, nil
+}
+
+func newKubeClient(uri *url.URL) (kube_client.Interface, error) {
+	kubeConfig, err := kube_config.GetKubeClientConfig(uri)
+	if err != nil {
+		return nil, err
+	}
+	return kube_client.NewForConfig(kubeConfig)
+}
+
+// GetSharedNodeLister returns a node lister backed by the shared informer factory for uri.
+func GetSharedNodeLister(uri *url.URL) (v1listers.NodeLister, error) {
+	factory, err := GetSharedInformerFactory(uri)
+	if err != nil {
+		return nil, err
+	}
+	lister := factory.Core().V1().Nodes().Lister()
+	// Starts only the informers which are not running yet.
+	factory.Start(wait.NeverStop)
+	return lister, nil
+}
+
+// GetFilteredNodeLister returns a node lister for uri listing only the nodes matching the label
+// and field selectors. The selectors are applied by the API server, so the other nodes are
+// neither transferred nor cached. Without selectors, the shared node lister is returned.
+func GetFilteredNodeLister(uri *url.URL, labelSelector, fieldSelector string) (v1listers.NodeLister, error) {
+	if labelSelector == "" && fieldSelector == "" {
+		return GetSharedNodeLister(uri)
+	}
+	informerFactoriesLock.Lock()
+	defer informerFactoriesLock.Unlock()
+
+	key := uri.String() + "|" + labelSelector + "|" + fieldSelector
+	if lister, found := filteredNodeListers[key]; found {
+		return lister, nil
+	}
+	kubeClient, err := newKubeClient(uri)
+	if err != nil {
+		return nil, err
+	}
+	informer := coreinformers.NewFilteredNodeInformer(kubeClient, informerResyncPeriod, cache.Indexers{}, func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
+		options.FieldSelector = fieldSelector
+	})
+	go informer.Run(wait.NeverStop)
+	lister := v1listers.NewNodeLister(informer.GetIndexer())
+	filteredNodeListers[key] = lister
+	return lister, nil
+}
+
+// GetSharedPodLister returns a pod lister backed by the shared informer factory for uri.
+func GetSharedPodLister(uri *url.URL) (v1listers.PodLister, error) {
+	factory, err := GetSharedInformerFactory(uri)
+	if err != nil {
+		return nil, err
+	}
+	lister := factory.Core().V1().Pods().Lister()
+	factory.Start(wait.NeverStop)
+	return lister, nil
+}
+
+// GetSharedPersistentVolumeClaimLister returns a persistent volume claim lister backed by the
+// shared informer factory for uri.
+func GetSharedPersistentVolumeClaimLister(uri *url.URL) (v1listers.PersistentVolumeClaimLister, error) {
+	factory, err := GetSharedInformerFactory(uri)
+	if err != nil {
+		return nil, err
+	}
+	lister := factory.Core().V1().PersistentVolumeClaims().Lister()
+	factory.Start(wait.NeverStop)
+	return lister, nil
+}
+
+// GetSharedPersistentVolumeLister returns a persistent volume lister backed by the shared
+// informer factory for uri.
+func GetSharedPersistentVolumeLister(uri *url.URL) (v1listers.PersistentVolumeLister, error) {
+	factory, err := GetSharedInformerFactory(uri)
+	if err != nil {
+		return nil, err
+	}
+	lister := factory.Core().V1().PersistentVolumes().Lister()
+	factory.Start(wait.NeverStop)
+	return lister, nil
+}
+
+// GetSharedNamespaceStore returns the store of the shared namespace informer for uri.
+func GetSharedNamespaceStore(uri *url.URL) (cache.Store, error) {
+	factory, err := GetSharedInformerFactory(uri)
+	if err != nil {
+		return nil, err
+	}
+	store := factory.Core().V1().Namespaces().Informer().GetStore()
+	factory.Start(wait.NeverStop)
+	return store, nil
+}
+
+// AddNamespaceDeletionHandler calls handler with the name of every namespace which is deleted
+// from the Kubernetes API server configured by uri.
+func AddNamespaceDeletionHandler(uri *url.URL, handler func(namespace string)) error {
+	factory, err := GetSharedInformerFactory(uri)
+	if err != nil {
+		return err
+	}
+	factory.Core().V1().Namespaces().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if namespace, ok := obj.(*kube_api.Namespace); ok {
+				handler(namespace.Name)
+			}
+		},
+	})
+	factory.Start(wait.NeverStop)
+	return nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSharedInformerFactory(t *testing.T) {
+	uri, err := url.Parse("http://localhost:8080?inClusterConfig=false")
+	require.NoError(t, err)
+	otherUri, err := url.Parse("http://otherhost:8080?inClusterConfig=false")
+	require.NoError(t, err)
+
+	factory, err := GetSharedInformerFactory(uri)
+	require.NoError(t, err)
+	same, err := GetSharedInformerFactory(uri)
+	require.NoError(t, err)
+	other, err := GetSharedInformerFactory(otherUri)
+	require.NoError(t, err)
+
+	assert.True(t, factory == same)
+	assert.False(t, factory == other)
+}
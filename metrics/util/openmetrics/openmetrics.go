@@ -0,0 +1,170 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package openmetrics renders data batches in the OpenMetrics text exposition format.
+package openmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+const metricPrefix = "heapster_"
+
+type sample struct {
+	labels    map[string]string
+	value     core.MetricValue
+	timestamp time.Time
+}
+
+type family struct {
+	name       string
+	metricType core.MetricType
+	samples    []sample
+}
+
+// Write renders all metrics of the batch to w. Families and samples are sorted, so the
+// output for a given batch is stable and suitable for use as a test fixture.
+func Write(w io.Writer, batch *core.DataBatch) error {
+	families := map[string]*family{}
+	add := func(name string, labels map[string]string, value core.MetricValue, timestamp time.Time) {
+		name = metricPrefix + sanitize(name)
+		f, found := families[name]
+		if !found {
+			f = &family{name: name, metricType: value.MetricType}
+			families[name] = f
+		}
+		f.samples = append(f.samples, sample{labels: labels, value: value, timestamp: timestamp})
+	}
+
+	for _, ms := range batch.MetricSets {
+		timestamp := ms.ScrapeTime
+		if timestamp.IsZero() {
+			timestamp = batch.Timestamp
+		}
+		for name, value := range ms.MetricValues {
+			add(name, ms.Labels, value, timestamp)
+		}
+		for _, lm := range ms.LabeledMetrics {
+			labels := make(map[string]string, len(ms.Labels)+len(lm.Labels))
+			for k, v := range ms.Labels {
+				labels[k] = v
+			}
+			for k, v := range lm.Labels {
+				labels[k] = v
+			}
+			add(lm.Name, labels, lm.MetricValue, timestamp)
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		writeFamily(buf, families[name])
+	}
+	buf.WriteString("# EOF\n")
+	return buf.Flush()
+}
+
+func writeFamily(w *bufio.Writer, f *family) {
+	sampleName := f.name
+	switch f.metricType {
+	case core.MetricCumulative:
+		fmt.Fprintf(w, "# TYPE %s counter\n", f.name)
+		sampleName += "_total"
+	default:
+		fmt.Fprintf(w, "# TYPE %s gauge\n", f.name)
+	}
+
+	lines := make([]string, 0, len(f.samples))
+	for _, s := range f.samples {
+		lines = append(lines, fmt.Sprintf("%s%s %s %s\n", sampleName, formatLabels(s.labels),
+			formatValue(s.value), formatTimestamp(s.timestamp)))
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		w.WriteString(line)
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", sanitize(k), escapeLabelValue(labels[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value core.MetricValue) string {
+	if value.ValueType == core.ValueFloat {
+		return strconv.FormatFloat(value.FloatValue, 'g', -1, 64)
+	}
+	return strconv.FormatInt(value.IntValue, 10)
+}
+
+func formatTimestamp(timestamp time.Time) string {
+	return strconv.FormatFloat(float64(timestamp.UnixNano())/float64(time.Second), 'f', 3, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// MetricName returns the name of the samples of the metric, e.g. heapster_cpu_usage_total for the
+// cumulative cpu/usage.
+func MetricName(name string, metricType core.MetricType) string {
+	name = metricPrefix + sanitize(name)
+	if metricType == core.MetricCumulative {
+		name += "_total"
+	}
+	return name
+}
+
+// LabelName returns the name of the label with the given key.
+func LabelName(key string) string {
+	return sanitize(key)
+}
+
+// sanitize replaces all characters which are not allowed in metric and label names.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openmetrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/heapster/metrics/core"
+)
+
+func TestWrite(t *testing.T) {
+	timestamp := time.Unix(1500000000, 0)
+	batch := &core.DataBatch{
+		Timestamp: timestamp,
+		MetricSets: map[string]*core.MetricSet{
+			core.PodKey("ns1", "pod1"): {
+				Labels: map[string]string{
+					core.LabelMetricSetType.Key: core.MetricSetTypePod,
+					core.LabelPodName.Key:       "pod1",
+					core.LabelLabels.Key:        `app:"web"`,
+				},
+				MetricValues: map[string]core.MetricValue{
+					core.MetricCpuUsage.Name: {
+						IntValue:   100,
+						MetricType: core.MetricCumulative,
+						ValueType:  core.ValueInt64,
+					},
+					core.MetricMemoryUsage.Name: {
+						IntValue:   2048,
+						MetricType: core.MetricGauge,
+						ValueType:  core.ValueInt64,
+					},
+				},
+				LabeledMetrics: []core.LabeledMetric{
+					{
+						Name:   core.MetricFilesystemUsage.Name,
+						Labels: map[string]string{core.LabelResourceID.Key: "/"},
+						MetricValue: core.MetricValue{
+							FloatValue: 1.5,
+							MetricType: core.MetricGauge,
+							ValueType:  core.ValueFloat,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, batch))
+	expected := `# TYPE heapster_cpu_usage counter
+heapster_cpu_usage_total{labels="app:\"web\"",pod_name="pod1",type="pod"} 100 1500000000.000
+# TYPE heapster_filesystem_usage gauge
+heapster_filesystem_usage{labels="app:\"web\"",pod_name="pod1",resource_id="/",type="pod"} 1.5 1500000000.000
+# TYPE heapster_memory_usage gauge
+heapster_memory_usage{labels="app:\"web\"",pod_name="pod1",type="pod"} 2048 1500000000.000
+# EOF
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package profiling captures CPU and heap profiles of Heapster on a schedule and keeps the latest
+// ones in memory, compressed, so that the performance problems of large clusters can be diagnosed
+// after the fact instead of waiting for them to happen again with a profiler attached.
+package profiling
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	ProfileTypeCPU  = "cpu"
+	ProfileTypeHeap = "heap"
+)
+
+// Profile describes a captured profile.
+type Profile struct {
+	// Name of the profile, e.g. heap-20171017T120000Z-3.pb.gz, unique within the recorder.
+	Name string    `json:"name"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Size of the compressed profile in bytes.
+	Size int `json:"size"`
+	// Heap in use when the profile was captured, in bytes.
+	HeapInuseBytes uint64 `json:"heapInuseBytes"`
+
+	data []byte
+}
+
+// Recorder captures a heap profile and a CPU profile at every interval, and keeps the latest ones.
+type Recorder struct {
+	interval    time.Duration
+	cpuDuration time.Duration
+	retention   int
+	// Profiles are only captured while the heap in use is at least this large, so that the
+	// retained profiles cover the high-load windows.
+	minHeapInuse uint64
+
+	lock sync.Mutex
+	// Oldest first.
+	profiles []*Profile
+	// Number of profiles captured so far.
+	count int
+}
+
+// NewRecorder returns a recorder capturing profiles every interval, the CPU being profiled for
+// cpuDuration, while the heap in use is at least minHeapInuse bytes. At most retention profiles
+// of each type are kept.
+func NewRecorder(interval, cpuDuration time.Duration, retention int, minHeapInuse uint64) (*Recorder, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("profile capture interval must be positive, got %s", interval)
+	}
+	if cpuDuration <= 0 || cpuDuration >= interval {
+		return nil, fmt.Errorf("CPU profile duration must be positive and shorter than the capture interval %s, got %s", interval, cpuDuration)
+	}
+	if retention < 1 {
+		return nil, fmt.Errorf("profile retention must be at least 1, got %d", retention)
+	}
+	return &Recorder{
+		interval:     interval,
+		cpuDuration:  cpuDuration,
+		retention:    retention,
+		minHeapInuse: minHeapInuse,
+	}, nil
+}
+
+// Start captures profiles in the background until the process exits.
+func (this *Recorder) Start() {
+	go func() {
+		for range time.Tick(this.interval) {
+			this.Capture()
+		}
+	}()
+}
+
+// Capture captures a heap profile and a CPU profile if the heap in use is large enough.
+func (this *Recorder) Capture() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapInuse < this.minHeapInuse {
+		glog.V(2).Infof("Not capturing profiles, %d bytes of heap in use", stats.HeapInuse)
+		return
+	}
+
+	var heap bytes.Buffer
+	if err := pprof.WriteHeapProfile(&heap); err != nil {
+		glog.Warningf("Failed to capture heap profile: %v", err)
+	} else {
+		this.add(ProfileTypeHeap, heap.Bytes(), stats.HeapInuse)
+	}
+
+	var cpu bytes.Buffer
+	// Fails if the CPU is already profiled, e.g. through /debug/pprof/profile.
+	if err := pprof.StartCPUProfile(&cpu); err != nil {
+		glog.Warningf("Failed to capture CPU profile: %v", err)
+		return
+	}
+	time.Sleep(this.cpuDuration)
+	pprof.StopCPUProfile()
+	this.add(ProfileTypeCPU, cpu.Bytes(), stats.HeapInuse)
+}
+
+func (this *Recorder) add(profileType string, data []byte, heapInuse uint64) {
+	data, err := compress(data)
+	if err != nil {
+		glog.Warningf("Failed to compress %s profile: %v", profileType, err)
+		return
+	}
+	now := time.Now()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.count++
+	profile := &Profile{
+		Name:           fmt.Sprintf("%s-%s-%d.pb.gz", profileType, now.UTC().Format("20060102T150405Z"), this.count),
+		Type:           profileType,
+		Time:           now,
+		Size:           len(data),
+		HeapInuseBytes: heapInuse,
+		data:           data,
+	}
+	profiles := []*Profile{}
+	kept := 0
+	// Drop the oldest profiles of the type beyond the retention.
+	for i := len(this.profiles) - 1; i >= 0; i-- {
+		if this.profiles[i].Type == profileType {
+			kept++
+			if kept >= this.retention {
+				continue
+			}
+		}
+		profiles = append([]*Profile{this.profiles[i]}, profiles...)
+	}
+	this.profiles = append(profiles, profile)
+	glog.V(2).Infof("Captured %s profile %s of %d bytes", profileType, profile.Name, profile.Size)
+}
+
+// List returns the retained profiles, oldest first.
+func (this *Recorder) List() []Profile {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	result := make([]Profile, 0, len(this.profiles))
+	for _, profile := range this.profiles {
+		result = append(result, *profile)
+	}
+	return result
+}
+
+// Get returns the compressed profile with the given name, readable by go tool pprof.
+func (this *Recorder) Get(name string) ([]byte, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, profile := range this.profiles {
+		if profile.Name == name {
+			return profile.data, true
+		}
+	}
+	return nil, false
+}
+
+// compress gzips the profile unless the runtime did already.
+func compress(data []byte) ([]byte, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		return data, nil
+	}
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profiling
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	_, err := NewRecorder(time.Minute, time.Minute, 1, 0)
+	assert.Error(t, err)
+	_, err = NewRecorder(time.Minute, time.Second, 0, 0)
+	assert.Error(t, err)
+
+	recorder, err := NewRecorder(time.Minute, 10*time.Millisecond, 2, 0)
+	require.NoError(t, err)
+	recorder.Capture()
+	profiles := recorder.List()
+	require.Len(t, profiles, 2)
+	assert.Equal(t, ProfileTypeHeap, profiles[0].Type)
+	assert.Equal(t, ProfileTypeCPU, profiles[1].Type)
+
+	for _, profile := range profiles {
+		data, found := recorder.Get(profile.Name)
+		require.True(t, found)
+		assert.Equal(t, profile.Size, len(data))
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+	}
+	_, found := recorder.Get("heap-19700101T000000Z-1.pb.gz")
+	assert.False(t, found)
+}
+
+func TestRecorderRetention(t *testing.T) {
+	recorder, err := NewRecorder(time.Minute, time.Second, 2, 0)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		recorder.add(ProfileTypeHeap, []byte{byte(i)}, 0)
+	}
+	recorder.add(ProfileTypeCPU, []byte{3}, 0)
+
+	profiles := recorder.List()
+	require.Len(t, profiles, 3)
+	types := []string{}
+	for _, profile := range profiles {
+		types = append(types, profile.Type)
+	}
+	assert.Equal(t, []string{ProfileTypeHeap, ProfileTypeHeap, ProfileTypeCPU}, types)
+
+	// The oldest heap profile was dropped.
+	data, found := recorder.Get(profiles[0].Name)
+	require.True(t, found)
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, content)
+}
+
+func TestRecorderMinHeap(t *testing.T) {
+	recorder, err := NewRecorder(time.Minute, time.Second, 2, 1<<50)
+	require.NoError(t, err)
+	recorder.Capture()
+	assert.Empty(t, recorder.List())
+}
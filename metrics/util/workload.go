@@ -0,0 +1,63 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	kube_api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1listers "k8s.io/client-go/listers/core/v1"
+)
+
+const (
+	// Label set by the deployment controller on its pods and replica sets.
+	PodTemplateHashLabel = "pod-template-hash"
+
+	// Kinds of the workloads which are not the controller of the pod.
+	KindPod        = "Pod"
+	KindDeployment = "Deployment"
+)
+
+// WorkloadOf returns the kind and name of the controller of the pod, as WorkloadOfPod, or the
+// pod itself if it is not known to the lister.
+func WorkloadOf(podLister v1listers.PodLister, namespace, podName string) (string, string) {
+	pod, err := podLister.Pods(namespace).Get(podName)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			glog.V(2).Infof("Failed to get pod %s/%s: %v", namespace, podName, err)
+		}
+		return KindPod, podName
+	}
+	return WorkloadOfPod(pod)
+}
+
+// WorkloadOfPod returns the kind and name of the controller of the pod. Pods of a deployment
+// are attributed to the deployment rather than to its current replica set, and pods without a
+// controller to themselves.
+func WorkloadOfPod(pod *kube_api.Pod) (string, string) {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		if hash, found := pod.Labels[PodTemplateHashLabel]; owner.Kind == "ReplicaSet" && found &&
+			strings.HasSuffix(owner.Name, "-"+hash) {
+			return KindDeployment, strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+		return owner.Kind, owner.Name
+	}
+	return KindPod, pod.Name
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	kube_api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newOwnedPod(name string, labels map[string]string, ownerKind, ownerName string) *kube_api.Pod {
+	controller := true
+	pod := &kube_api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      name,
+			Labels:    labels,
+		},
+	}
+	if ownerKind != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: ownerKind, Name: ownerName, Controller: &controller}}
+	}
+	return pod
+}
+
+func TestWorkloadOfPod(t *testing.T) {
+	kind, name := WorkloadOfPod(newOwnedPod("web-5d8f-abcde", map[string]string{PodTemplateHashLabel: "5d8f"}, "ReplicaSet", "web-5d8f"))
+	assert.Equal(t, KindDeployment, kind)
+	assert.Equal(t, "web", name)
+
+	kind, name = WorkloadOfPod(newOwnedPod("rs-abcde", nil, "ReplicaSet", "rs"))
+	assert.Equal(t, "ReplicaSet", kind)
+	assert.Equal(t, "rs", name)
+
+	kind, name = WorkloadOfPod(newOwnedPod("db-0", nil, "StatefulSet", "db"))
+	assert.Equal(t, "StatefulSet", kind)
+	assert.Equal(t, "db", name)
+
+	kind, name = WorkloadOfPod(newOwnedPod("standalone", nil, "", ""))
+	assert.Equal(t, KindPod, kind)
+	assert.Equal(t, "standalone", name)
+}
+
+func TestWorkloadOf(t *testing.T) {
+	store := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	assert.NoError(t, store.Add(newOwnedPod("db-0", nil, "StatefulSet", "db")))
+	podLister := v1listers.NewPodLister(store)
+
+	kind, name := WorkloadOf(podLister, "ns1", "db-0")
+	assert.Equal(t, "StatefulSet", kind)
+	assert.Equal(t, "db", name)
+
+	kind, name = WorkloadOf(podLister, "ns1", "gone")
+	assert.Equal(t, KindPod, kind)
+	assert.Equal(t, "gone", name)
+}